@@ -3,14 +3,56 @@ module tkestack.io/gpu-admission
 go 1.13
 
 require (
+	github.com/fsnotify/fsnotify v1.4.7
+	github.com/golang/protobuf v1.4.3
 	github.com/gophercloud/gophercloud v0.1.0 // indirect
 	github.com/julienschmidt/httprouter v1.3.1-0.20191005171706-08a3b3d20bbe
+	github.com/prometheus/client_golang v1.0.0
 	github.com/spf13/pflag v1.0.5
+	go.opentelemetry.io/otel v0.20.0
+	go.opentelemetry.io/otel/exporters/stdout v0.20.0
+	go.opentelemetry.io/otel/sdk v0.20.0
+	go.opentelemetry.io/otel/trace v0.20.0
+	go.opentelemetry.io/proto/otlp v0.7.0
+	google.golang.org/grpc v1.36.0
+	google.golang.org/protobuf v1.25.0
 	k8s.io/api v0.18.12
 	k8s.io/apimachinery v0.18.12
 	k8s.io/client-go v0.18.12
 	k8s.io/component-base v0.18.12
 	k8s.io/klog v1.0.0
 	k8s.io/kube-scheduler v0.18.12
+	k8s.io/kubernetes v1.18.12
 	sigs.k8s.io/structured-merge-diff/v3 v3.0.0 // indirect
 )
+
+// k8s.io/kubernetes, which the framework plugin is built on, requires its
+// staging modules at v0.0.0 and vbom.ml/util, which moved to
+// github.com/fvbommel/util
+replace (
+	k8s.io/api => k8s.io/api v0.18.12
+	k8s.io/apiextensions-apiserver => k8s.io/apiextensions-apiserver v0.18.12
+	k8s.io/apimachinery => k8s.io/apimachinery v0.18.12
+	k8s.io/apiserver => k8s.io/apiserver v0.18.12
+	k8s.io/cli-runtime => k8s.io/cli-runtime v0.18.12
+	k8s.io/client-go => k8s.io/client-go v0.18.12
+	k8s.io/cloud-provider => k8s.io/cloud-provider v0.18.12
+	k8s.io/cluster-bootstrap => k8s.io/cluster-bootstrap v0.18.12
+	k8s.io/code-generator => k8s.io/code-generator v0.18.12
+	k8s.io/component-base => k8s.io/component-base v0.18.12
+	k8s.io/cri-api => k8s.io/cri-api v0.18.12
+	k8s.io/csi-translation-lib => k8s.io/csi-translation-lib v0.18.12
+	k8s.io/kube-aggregator => k8s.io/kube-aggregator v0.18.12
+	k8s.io/kube-controller-manager => k8s.io/kube-controller-manager v0.18.12
+	k8s.io/kube-proxy => k8s.io/kube-proxy v0.18.12
+	k8s.io/kube-scheduler => k8s.io/kube-scheduler v0.18.12
+	k8s.io/kubectl => k8s.io/kubectl v0.18.12
+	k8s.io/kubelet => k8s.io/kubelet v0.18.12
+	k8s.io/legacy-cloud-providers => k8s.io/legacy-cloud-providers v0.18.12
+	k8s.io/metrics => k8s.io/metrics v0.18.12
+	k8s.io/sample-apiserver => k8s.io/sample-apiserver v0.18.12
+	// etcd, which k8s.io/kubernetes requires, is built on the balancer API of
+	// grpc before v1.27
+	google.golang.org/grpc => google.golang.org/grpc v1.26.0
+	vbom.ml/util => github.com/fvbommel/util v0.0.0-20160121211510-db5cfe13f5cc
+)
@@ -17,22 +17,39 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"flag"
-	"log"
+	"net"
 	"net/http"
-	_ "net/http/pprof"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/component-base/logs"
 	"k8s.io/klog"
 
+	"tkestack.io/gpu-admission/pkg/audit"
+	"tkestack.io/gpu-admission/pkg/config"
+	"tkestack.io/gpu-admission/pkg/dra"
+	"tkestack.io/gpu-admission/pkg/leader"
+	"tkestack.io/gpu-admission/pkg/metrics"
 	"tkestack.io/gpu-admission/pkg/predicate"
 	"tkestack.io/gpu-admission/pkg/route"
+	"tkestack.io/gpu-admission/pkg/rpc"
+	"tkestack.io/gpu-admission/pkg/state"
+	"tkestack.io/gpu-admission/pkg/tracing"
+	"tkestack.io/gpu-admission/pkg/util"
 	"tkestack.io/gpu-admission/pkg/version/verflag"
 )
 
@@ -41,6 +58,37 @@ var (
 	masterURL      string
 	listenAddress  string
 	profileAddress string
+	profiling      bool
+	admissionAddr  string
+	admissionCert  string
+	admissionKey   string
+	grpcAddress    string
+	draDriver      string
+	maxInflight    uint
+	requestTimeout time.Duration
+	traceExporter  string
+	traceEndpoint  string
+	traceRatio     float64
+	configFile     string
+	configMap      string
+	configMapKey   string
+	debugNodes     bool
+	debugTimings   bool
+	auditLog       string
+	auditBuffer    int
+	stateMap       string
+	stateInterval  time.Duration
+	leaderElect    bool
+	leaderLease    string
+	drainTimeout   time.Duration
+	drainDelay     time.Duration
+	maxBodySize    int64
+	gpuConfig      = config.Default()
+	leaderConfig   = leader.Config{
+		LeaseDuration: leader.DefaultLeaseDuration,
+		RenewDeadline: leader.DefaultRenewDeadline,
+		RetryPeriod:   leader.DefaultRetryPeriod,
+	}
 )
 
 func main() {
@@ -52,15 +100,22 @@ func main() {
 	flag.CommandLine.Parse([]string{})
 	verflag.PrintAndExitIfRequested()
 
-	router := httprouter.New()
-	route.AddVersion(router)
-
-	var (
-		clientCfg *rest.Config
-		err       error
-	)
+	// SIGTERM or SIGINT turns the replica not ready, then drains the
+	// servers before exiting
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	drain := &route.Drain{}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		sig := <-signals
+		klog.Infof("Received %s, shutting down in %s", sig, drainDelay)
+		drain.Start()
+		time.Sleep(drainDelay)
+		stop()
+	}()
 
-	clientCfg, err = clientcmd.BuildConfigFromFlags(masterURL, kubeconfig)
+	clientCfg, err := clientcmd.BuildConfigFromFlags(masterURL, kubeconfig)
 	if err != nil {
 		klog.Fatalf("Error building kubeconfig: %s", err.Error())
 	}
@@ -70,20 +125,203 @@ func main() {
 		klog.Fatalf("Error building kubernetes clientset: %s", err.Error())
 	}
 
-	gpuFilter, err := predicate.NewGPUFilter(kubeClient)
+	var cmNamespace, cmName string
+	if configFile != "" && configMap != "" {
+		klog.Fatalf("Only one of --config and --config-map can be given")
+	}
+	if configMap != "" {
+		cmNamespace, cmName, err = cache.SplitMetaNamespaceKey(configMap)
+		if err != nil || cmNamespace == "" || cmName == "" {
+			klog.Fatalf("Invalid config map %q, expect namespace/name", configMap)
+		}
+	}
+	if configFile != "" || configMap != "" {
+		if configFile != "" {
+			err = gpuConfig.Load(configFile)
+		} else {
+			err = gpuConfig.LoadConfigMap(kubeClient, cmNamespace, cmName, configMapKey)
+		}
+		if err != nil {
+			klog.Fatalf("Error loading config: %s", err.Error())
+		}
+		// flags given explicitly take precedence over the config file
+		if err := pflag.CommandLine.Parse(os.Args[1:]); err != nil {
+			klog.Fatalf("Error parsing flags: %s", err.Error())
+		}
+	}
+	if err := gpuConfig.Validate(); err != nil {
+		klog.Fatalf("Invalid config: %s", err.Error())
+	}
+	util.SetKeys(gpuConfig.Keys)
+	util.SetVendors(gpuConfig.ResolvedVendors())
+	if d := gpuConfig.DefaultEstimatedTime; d != nil {
+		util.SetDefaultEstimatedTime(&d.Duration)
+	}
+	if err := metrics.Register(prometheus.DefaultRegisterer); err != nil {
+		klog.Fatalf("Error registering metrics: %s", err.Error())
+	}
+	shutdownTracing, err := tracing.Setup(traceExporter, traceEndpoint, traceRatio)
+	if err != nil {
+		klog.Fatalf("Error setting up tracing: %s", err.Error())
+	}
+	defer shutdownTracing(context.Background())
+
+	route.SetMaxBodySize(maxBodySize)
+	router := httprouter.New()
+	route.AddVersion(router)
+	route.AddMetrics(router)
+
+	var claims *dra.Resolver
+	if draDriver != "" {
+		dynamicClient, err := dynamic.NewForConfig(clientCfg)
+		if err != nil {
+			klog.Fatalf("Error building dynamic client: %s", err.Error())
+		}
+		claims = dra.NewResolver(dynamicClient, draDriver)
+	}
+	gpuFilter, err := predicate.NewGPUFilter(kubeClient, gpuConfig, claims)
 	if err != nil {
 		klog.Fatalf("Failed to new gpu quota filter: %s", err.Error())
 	}
-	route.AddPredicate(router, gpuFilter)
+	if auditLog != "" {
+		sink, err := audit.NewFileSink(auditLog, auditBuffer)
+		if err != nil {
+			klog.Fatalf("Error opening audit log: %s", err.Error())
+		}
+		defer sink.Close()
+		gpuFilter.SetAuditSink(sink)
+	}
+	gpuFilter.SetAllocationTimings(debugTimings)
+	// only the leader serves the scheduler, the others keep their informer
+	// caches warm to take over
+	var readiness predicate.Readiness = route.AllReady(gpuFilter, drain)
+	var elector *leader.Elector
+	if leaderElect {
+		leaderConfig.Namespace, leaderConfig.Name, err = cache.SplitMetaNamespaceKey(leaderLease)
+		if err != nil || leaderConfig.Namespace == "" || leaderConfig.Name == "" {
+			klog.Fatalf("Invalid leader election lease %q, expect namespace/name", leaderLease)
+		}
+		hostname, err := os.Hostname()
+		if err != nil {
+			klog.Fatalf("Error getting hostname: %s", err.Error())
+		}
+		leaderConfig.Identity = hostname + "_" + string(uuid.NewUUID())
+		elector, err = leader.NewElector(kubeClient, leaderConfig, gpuFilter.Lead)
+		if err != nil {
+			klog.Fatalf("Error setting up leader election: %s", err.Error())
+		}
+		readiness = route.AllReady(gpuFilter, elector, drain)
+		leaderCtx, stopLeading := context.WithCancel(context.Background())
+		defer stopLeading()
+		go elector.Run(leaderCtx)
+	}
+	if stateMap != "" {
+		namespace, name, err := cache.SplitMetaNamespaceKey(stateMap)
+		if err != nil || namespace == "" || name == "" {
+			klog.Fatalf("Invalid state config map %q, expect namespace/name", stateMap)
+		}
+		var store state.Store = state.NewConfigMapStore(kubeClient, namespace, name)
+		if elector != nil {
+			store = elector.Store(store)
+		}
+		gpuFilter.SetStateStore(store, stateInterval, nil)
+	}
+	overrides := configFlagOverrides(pflag.CommandLine)
+	if configFile != "" {
+		reload := func() (*config.Config, error) {
+			return reloadConfig(overrides, func(cfg *config.Config) error {
+				return cfg.Load(configFile)
+			})
+		}
+		if err := config.Watch(configFile, reload, gpuFilter.SetConfig, nil); err != nil {
+			klog.Fatalf("Error watching config: %s", err.Error())
+		}
+	}
+	if configMap != "" {
+		reload := func(data []byte) (*config.Config, error) {
+			return reloadConfig(overrides, func(cfg *config.Config) error {
+				return cfg.Parse(data)
+			})
+		}
+		config.WatchConfigMap(kubeClient, cmNamespace, cmName, configMapKey, reload, gpuFilter.SetConfig, nil)
+	}
+	route.AddPredicate(router, gpuFilter, route.NewLimiter(maxInflight, requestTimeout))
+	route.AddPrioritize(router, gpuFilter)
+	route.AddBind(router, gpuFilter)
+	route.AddPreemption(router, gpuFilter)
+	route.AddSimulate(router, gpuFilter)
+	route.AddGang(router, gpuFilter)
+	route.AddRebalance(router, gpuFilter)
+	route.AddHealth(router, readiness)
+	if debugNodes {
+		route.AddDebugNodes(router, gpuFilter)
+	}
 
-	go func() {
-		log.Println(http.ListenAndServe(profileAddress, nil))
-	}()
+	if profiling {
+		profLis, err := net.Listen("tcp", profileAddress)
+		if err != nil {
+			klog.Fatalf("Error listening on %s: %s", profileAddress, err.Error())
+		}
+		profRouter := httprouter.New()
+		route.AddProfiling(profRouter)
+		go func() {
+			klog.Infof("Profiling server starting on %s", profileAddress)
+			if err := route.Serve(ctx, &http.Server{Handler: profRouter}, profLis, drainTimeout); err != nil {
+				klog.Errorf("Profiling server failed: %s", err.Error())
+			}
+		}()
+	}
+
+	// the webhook serves the API server whether or not this replica leads
+	if admissionAddr != "" {
+		cert, err := tls.LoadX509KeyPair(admissionCert, admissionKey)
+		if err != nil {
+			klog.Fatalf("Error loading the admission webhook certificate: %s", err.Error())
+		}
+		admissionLis, err := net.Listen("tcp", admissionAddr)
+		if err != nil {
+			klog.Fatalf("Error listening on %s: %s", admissionAddr, err.Error())
+		}
+		admissionLis = tls.NewListener(admissionLis, &tls.Config{Certificates: []tls.Certificate{cert}})
+		admissionRouter := httprouter.New()
+		route.AddAdmission(admissionRouter, gpuFilter)
+		go func() {
+			klog.Infof("Admission webhook starting on %s", admissionAddr)
+			if err := route.Serve(ctx, &http.Server{Handler: admissionRouter}, admissionLis, drainTimeout); err != nil {
+				klog.Errorf("Admission webhook failed: %s", err.Error())
+			}
+		}()
+	}
 
+	grpcDone := make(chan struct{})
+	if grpcAddress != "" {
+		go func() {
+			defer close(grpcDone)
+			klog.Infof("gRPC server starting on %s", grpcAddress)
+			if err := rpc.Serve(ctx, grpcAddress, gpuFilter, drainTimeout); err != nil {
+				klog.Fatalf("gRPC server failed: %s", err.Error())
+			}
+		}()
+	} else {
+		close(grpcDone)
+	}
+
+	lis, err := net.Listen("tcp", listenAddress)
+	if err != nil {
+		klog.Fatalf("Error listening on %s: %s", listenAddress, err.Error())
+	}
 	klog.Infof("Server starting on %s", listenAddress)
-	if err := http.ListenAndServe(listenAddress, router); err != nil {
-		log.Fatal(err)
+	if err := route.Serve(ctx, &http.Server{Handler: router}, lis, drainTimeout); err != nil {
+		klog.Errorf("Server failed: %s", err.Error())
+	}
+	stop()
+	<-grpcDone
+	// the snapshot is saved before the lease is released, and the audit
+	// records and the spans are flushed by the deferred calls
+	if err := gpuFilter.SaveState(); err != nil {
+		klog.Errorf("Failed to save the GPU state: %v", err)
 	}
+	klog.Infof("Server stopped")
 }
 
 func addFlags(fs *pflag.FlagSet) {
@@ -92,7 +330,126 @@ func addFlags(fs *pflag.FlagSet) {
 	fs.StringVar(&masterURL, "master", "",
 		"The address of the Kubernetes API server. Overrides any value in kubeconfig. Only required if out-of-cluster.")
 	fs.StringVar(&listenAddress, "address", "127.0.0.1:3456", "The address it will listen")
-	fs.StringVar(&profileAddress, "pprofAddress", "127.0.0.1:3457", "The address for debug")
+	fs.StringVar(&profileAddress, "pprofAddress", "127.0.0.1:3457",
+		"The address the profiling endpoints listen with --profiling, apart from --address.")
+	fs.BoolVar(&profiling, "profiling", false,
+		"Serve the profiles of net/http/pprof at /debug/pprof/ of --pprofAddress.")
+	fs.StringVar(&admissionAddr, "admission-address", "",
+		"The address the validating admission webhook of the GPU pods listens in TLS, empty disables it.")
+	fs.StringVar(&admissionCert, "admission-tls-cert-file", "",
+		"Path to the TLS certificate of the admission webhook given by --admission-address.")
+	fs.StringVar(&admissionKey, "admission-tls-private-key-file", "",
+		"Path to the TLS private key of the admission webhook given by --admission-address.")
+	fs.StringVar(&grpcAddress, "grpc-address", "",
+		"The address the gRPC extender service listens, empty disables it.")
+	fs.StringVar(&draDriver, "dra-driver", "",
+		"The DRA driver whose resource claims of a pod give its GPU request instead of the container limits, empty reads the limits only.")
+	fs.UintVar(&maxInflight, "max-inflight-requests", 0,
+		"The max number of predicate requests handled at the same time, the others are rejected to retry later. 0 means no limit.")
+	fs.Int64Var(&maxBodySize, "max-request-body-size", route.DefaultMaxBodySize,
+		"The max size in bytes of the request body of the extender routes, a larger one is rejected with 400. 0 means no limit.")
+	fs.DurationVar(&requestTimeout, "request-timeout", 0,
+		"The time a predicate request may take before it's cancelled, 0 means no timeout.")
+	fs.StringVar(&traceExporter, "tracing-exporter", tracing.NoneExporter,
+		"The exporter of the tracing spans, otlp sends them to --tracing-endpoint, stdout prints them for debugging, empty disables tracing.")
+	fs.StringVar(&traceEndpoint, "tracing-endpoint", tracing.DefaultOTLPEndpoint,
+		"The OTLP/HTTP endpoint of the collector the otlp exporter sends the spans, a host:port or a URL, the path defaults to /v1/traces.")
+	fs.Float64Var(&traceRatio, "tracing-sample-ratio", 1,
+		"The ratio of the traces sampled, unless the scheduler has sampled them.")
+	fs.StringVar(&configFile, "config", "",
+		"Path to a config file in JSON, e.g. to override the names of annotations and resources.")
+	fs.StringVar(&configMap, "config-map", "",
+		"The namespace/name of a ConfigMap to read the config from instead of a file, it's applied once changed.")
+	fs.StringVar(&configMapKey, "config-map-key", config.DefaultConfigMapKey,
+		"The key of the config in the ConfigMap given by --config-map.")
+	fs.StringVar(&auditLog, "audit-log", "",
+		"Path to a file the allocation decisions are appended to in JSON lines, empty disables the audit.")
+	fs.IntVar(&auditBuffer, "audit-buffer-size", audit.DefaultBufferSize,
+		"The max number of audit records waiting to be written, the new ones are dropped once it's full.")
+	fs.StringVar(&stateMap, "state-config-map", "",
+		"The namespace/name of a ConfigMap the GPU accounting of the nodes is saved to and restored from after restart, empty disables it.")
+	fs.DurationVar(&stateInterval, "state-snapshot-interval", 30*time.Second,
+		"The interval the GPU accounting is saved to the ConfigMap given by --state-config-map.")
+	fs.BoolVar(&leaderElect, "leader-elect", false,
+		"Elect a leader among the replicas by a Lease, only the leader is ready to serve the scheduler.")
+	fs.StringVar(&leaderLease, "leader-elect-lease", "kube-system/gpu-admission",
+		"The namespace/name of the Lease the replicas elect the leader by.")
+	fs.DurationVar(&leaderConfig.LeaseDuration, "leader-elect-lease-duration", leader.DefaultLeaseDuration,
+		"How long the followers wait before taking over the Lease the leader fails to renew.")
+	fs.DurationVar(&leaderConfig.RenewDeadline, "leader-elect-renew-deadline", leader.DefaultRenewDeadline,
+		"How long the leader retries renewing the Lease before it stops leading.")
+	fs.DurationVar(&leaderConfig.RetryPeriod, "leader-elect-retry-period", leader.DefaultRetryPeriod,
+		"The interval the replicas try to acquire or renew the Lease.")
+	fs.DurationVar(&drainTimeout, "shutdown-timeout", 30*time.Second,
+		"How long the requests in flight are waited for on SIGTERM before exiting, 0 means no limit.")
+	fs.DurationVar(&drainDelay, "shutdown-delay", 5*time.Second,
+		"How long /readyz responds not ready on SIGTERM before the servers stop accepting requests.")
+	fs.BoolVar(&debugNodes, "debug-nodes", false,
+		"Serve the GPU state of the nodes in JSON at /debug/nodes and /debug/nodes/<node>.")
+	fs.BoolVar(&debugTimings, "debug-timings", false,
+		"Record the microseconds spent in each stage of the allocation in the annotation tencent.com/predicate-stage-timings of the pod.")
+	addConfigFlags(fs, gpuConfig)
+}
+
+// addConfigFlags adds the flags of the options in config file to fs, which
+// are bound to the fields of cfg
+func addConfigFlags(fs *pflag.FlagSet, cfg *config.Config) {
+	fs.Float64Var(&cfg.CoreOvercommitRatio, "core-overcommit-ratio", config.DefaultCoreOvercommitRatio,
+		"The ratio scales the schedulable cores of each GPU, it can be overridden by node annotation "+
+			"tencent.com/gpu-core-overcommit-ratio.")
+	fs.BoolVar(&cfg.DisableExclusive, "disable-exclusive", false,
+		"Reject the containers requesting a GPU or more instead of giving them whole GPUs.")
+	fs.UintVar(&cfg.MaxContainersPerDevice, "max-containers-per-device", 0,
+		"The max number of containers sharing a GPU, 0 means no limit.")
+	fs.UintVar(&cfg.MaxDevicesPerPod, "max-devices-per-pod", 0,
+		"The max number of GPUs the containers of a pod take together, 0 means no limit.")
+	fs.UintVar(&cfg.FilterCacheSize, "filter-cache-size", 0,
+		"The max number of filter results cached, 0 disables the cache.")
+	fs.Var(&cfg.FilterCacheTTL, "filter-cache-ttl",
+		"How long a cached filter result is trusted.")
+	fs.Var(&cfg.MaxNodeStateAge, "max-node-state-age",
+		"How long since a node last changed its state is trusted, an older node is rejected for the pod to retry later. 0 means no limit.")
+	fs.UintVar(&cfg.MinFreeMemoryPerDevice, "min-free-memory-per-device", 0,
+		"The vmemory each GPU keeps free after placing a shared container, a GPU left with less is infeasible. A GPU whose schedulable vmemory isn't more is never shared and logged.")
+	fs.StringVar(&cfg.NodePolicy, "node-policy", config.PackPolicy,
+		"The policy to choose nodes, pack prefers the busiest nodes and spread prefers the idlest ones.")
+	fs.StringVar(&cfg.SharePolicy, "share-policy", config.ShareAllowed,
+		"How a container requesting less than a GPU is allocated, allow shares a GPU, reject fails it and roundUp gives it a whole GPU.")
+	fs.UintVar(&cfg.ReservedCoresPerDevice, "reserved-cores-per-device", 0,
+		"The cores of each GPU reserved for the system, which are never allocated.")
+	fs.UintVar(&cfg.ReservedMemoryPerDevice, "reserved-memory-per-device", 0,
+		"The vmemory of each GPU reserved for the system, which is never allocated.")
+}
+
+// configFlagOverrides returns the values of the config flags given
+// explicitly on the command line of fs
+func configFlagOverrides(fs *pflag.FlagSet) map[string]string {
+	overrides := make(map[string]string)
+	configFlags := pflag.NewFlagSet("config", pflag.ContinueOnError)
+	addConfigFlags(configFlags, config.Default())
+	configFlags.VisitAll(func(f *pflag.Flag) {
+		if fs.Changed(f.Name) {
+			overrides[f.Name] = fs.Lookup(f.Name).Value.String()
+		}
+	})
+	return overrides
+}
+
+// reloadConfig loads the config afresh by load, the flags given explicitly
+// on the command line still take precedence
+func reloadConfig(overrides map[string]string, load func(*config.Config) error) (*config.Config, error) {
+	cfg := config.Default()
+	if err := load(cfg); err != nil {
+		return nil, err
+	}
+	fs := pflag.NewFlagSet("config", pflag.ContinueOnError)
+	addConfigFlags(fs, cfg)
+	for name, value := range overrides {
+		if err := fs.Set(name, value); err != nil {
+			return nil, err
+		}
+	}
+	return cfg, nil
 }
 
 func wordSepNormalizeFunc(f *pflag.FlagSet, name string) pflag.NormalizedName {
@@ -17,126 +17,975 @@
 package algorithm
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"strconv"
 	"strings"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
 	"k8s.io/api/core/v1"
 	"k8s.io/klog"
 
 	"tkestack.io/gpu-admission/pkg/device"
+	"tkestack.io/gpu-admission/pkg/metrics"
+	"tkestack.io/gpu-admission/pkg/tracing"
 	"tkestack.io/gpu-admission/pkg/util"
 )
 
 type allocator struct {
 	nodeInfo *device.NodeInfo
+	// relaxed are the soft constraints dropped for the container being
+	// allocated, see allocateRelaxed
+	relaxed []string
+	// timings records the time of the stages of Allocate, nil records
+	// nothing, see WithTimings
+	timings *stageTimer
+	// observe makes allocateOne observe its latency, it's only set by
+	// Allocate so the evaluations of Plan, Place and Score aren't observed
+	observe bool
 }
 
 func NewAllocator(n *device.NodeInfo) *allocator {
 	return &allocator{nodeInfo: n}
 }
 
-// IsAllocatable attempt to allocate containers which has GPU request of given pod
-func (alloc *allocator) IsAllocatable(pod *v1.Pod) bool {
-	allocatable := true
-	for i, c := range pod.Spec.Containers {
-		if !util.IsGPURequiredContainer(&c) {
-			continue
-		}
-		_, err := alloc.AllocateOne(pod, i, &c)
+// WithTimings makes Allocate write the time spent in each of its stages to
+// the PredicateStageTimings annotation of the pod if enabled, see
+// StageTimings
+func (alloc *allocator) WithTimings(enabled bool) *allocator {
+	alloc.timings = nil
+	if enabled {
+		alloc.timings = &stageTimer{}
+	}
+	return alloc
+}
+
+// ContainerPlacement records the GPU devices chosen for a container
+type ContainerPlacement struct {
+	// Name is the name of the container
+	Name string
+	// Index is the index of the container in pod spec
+	Index int
+	// Init tells if this is an init container
+	Init bool
+	// Devices are the idx of chosen GPU devices
+	Devices []int
+	// UUIDs are the UUID of chosen GPU devices, it's empty if any of the
+	// UUIDs is unknown
+	UUIDs []string
+	// MIGInstances are the chosen MIG instances if the container requests
+	// a MIG profile, Devices are the devices of them
+	MIGInstances []MIGInstanceRef
+	// Relaxed are the soft constraints dropped to place the container
+	Relaxed []string
+}
+
+// IsAllocatable tells if the containers which has GPU request of given pod
+// can be allocated, the node's used resources are not changed. A pod is not
+// allocatable once ctx is done.
+func (alloc *allocator) IsAllocatable(ctx context.Context, pod *v1.Pod) bool {
+	ctx, span := tracing.Start(ctx, tracing.SpanIsAllocatable, alloc.spanAttributes(pod)...)
+	reasons := alloc.UnmetReasons(ctx, pod)
+	for _, reason := range reasons {
+		klog.Infof("failed to allocate for pod %s: %v", pod.UID, reason)
+	}
+	if len(reasons) > 0 {
+		tracing.End(span, reasons[0])
+		return false
+	}
+	tracing.End(span, nil)
+	return true
+}
+
+// UnmetReasons evaluates all containers which has GPU request of given pod
+// against a copy of the node as allocate does, and returns the reason of
+// every container which can't be allocated. A container failed to be
+// allocated is not charged, so the later containers are still evaluated,
+// and the first reason is the error Plan returns. The node's used
+// resources are not changed. It stops with the error of ctx once it's
+// done.
+func (alloc *allocator) UnmetReasons(ctx context.Context, pod *v1.Pod) []error {
+	alloc.nodeInfo.Lock()
+	defer alloc.nodeInfo.Unlock()
+
+	_, reasons := NewAllocator(alloc.nodeInfo.Clone()).allocateAll(ctx, pod, true)
+	return reasons
+}
+
+// Plan runs the whole allocation of given pod against a copy of the node,
+// and returns the chosen devices of each container which has GPU request.
+// The node's used resources are not changed, and nothing is recorded, so
+// it tells whether the pod fits without any side effect. It stops with the
+// error of ctx once it's done.
+func (alloc *allocator) Plan(ctx context.Context, pod *v1.Pod) ([]ContainerPlacement, error) {
+	alloc.nodeInfo.Lock()
+	defer alloc.nodeInfo.Unlock()
+
+	return NewAllocator(alloc.nodeInfo.Clone()).allocate(ctx, pod)
+}
+
+// Place runs the whole allocation of given pod like Plan, but the node is
+// charged for it, so the pods placed later see the devices used. No
+// annotations are written, it's meant for a NodeInfo of a simulation.
+func (alloc *allocator) Place(ctx context.Context, pod *v1.Pod) ([]ContainerPlacement, error) {
+	alloc.nodeInfo.Lock()
+	defer alloc.nodeInfo.Unlock()
+
+	return alloc.allocate(ctx, pod)
+}
+
+// Allocate tries to find a suitable GPU device for containers
+// and records some data in pod's annotation. If any container failed to
+// be allocated, the resources charged for the former containers are
+// rolled back.
+//
+// The node is locked during the whole allocation. Once ctx is done, the
+// allocation is rolled back and the error of ctx is returned.
+//
+// If the pod has already been allocated on this node, it's returned as is
+// without charging the node again, because the node has been charged when
+// it was created from the pods. If it was allocated on another node, the
+// previous predicate annotations are dropped and it's allocated afresh.
+//
+// A container which doesn't fit has the soft constraints of the pod dropped
+// one by one, see allocateRelaxed, and the constraints dropped for any
+// container are recorded in the PredicateRelaxedConstraints annotation.
+//
+// A pod whose containers requesting less than a GPU are given whole ones by
+// the share policy has the PredicateRoundedUp annotation, so the node is
+// still charged the whole GPUs for it once rebuilt under another policy.
+//
+// With timings enabled, the time spent in each stage is recorded in the
+// PredicateStageTimings annotation, see WithTimings.
+func (alloc *allocator) Allocate(ctx context.Context, pod *v1.Pod) (newPod *v1.Pod, err error) {
+	ctx, span := tracing.Start(ctx, tracing.SpanAllocate, alloc.spanAttributes(pod)...)
+	defer func() { tracing.End(span, err) }()
+
+	alloc.nodeInfo.Lock()
+	defer alloc.nodeInfo.Unlock()
+
+	if alloc.isAllocatedHere(pod) {
+		klog.V(4).Infof("pod %s has been allocated on node %s", pod.UID, alloc.nodeInfo.GetName())
+		return pod, nil
+	}
+
+	if alloc.timings != nil {
+		*alloc.timings = stageTimer{}
+	}
+	alloc.observe = true
+	defer func() { alloc.observe = false }()
+	placements, err := alloc.allocate(ctx, pod)
+	metrics.RecordAllocation(podMode(pod), metricsReason(err))
+	if err != nil {
+		return nil, err
+	}
+	recordModeDecisions(alloc.nodeInfo, pod)
+
+	newPod = alloc.annotate(pod, placements)
+	newPod.Annotations[util.GPUAssigned] = "false"
+	newPod.Annotations[util.PredicateTimeAnnotation] = fmt.Sprintf("%d", time.Now().UnixNano())
+	if alloc.timings != nil {
+		timings, err := json.Marshal(alloc.timings.timings())
 		if err != nil {
-			klog.Infof("failed to allocate for pod %s container %s", pod.UID, c.Name)
-			allocatable = false
-			break
+			return nil, err
 		}
+		newPod.Annotations[util.PredicateStageTimings] = string(timings)
 	}
-	return allocatable
+
+	return newPod, nil
 }
 
-// Allocate tries to find a suitable GPU device for containers
-// and records some data in pod's annotation
-func (alloc *allocator) Allocate(pod *v1.Pod) (*v1.Pod, error) {
+// Annotate returns a copy of given pod with the predicate annotations of
+// the placements on this node, as Allocate would write them, so the node
+// is charged the same for it by AddPod. It's meant for a pod placed on a
+// NodeInfo of a simulation, nothing is recorded.
+func (alloc *allocator) Annotate(pod *v1.Pod, placements []ContainerPlacement) *v1.Pod {
+	alloc.nodeInfo.Lock()
+	defer alloc.nodeInfo.Unlock()
+
+	return alloc.annotate(pod, placements)
+}
+
+func (alloc *allocator) annotate(pod *v1.Pod, placements []ContainerPlacement) *v1.Pod {
 	newPod := pod.DeepCopy()
 	if newPod.Annotations == nil {
 		newPod.Annotations = make(map[string]string)
 	}
-	for i, c := range newPod.Spec.Containers {
+	for k := range newPod.Annotations {
+		if util.IsPredicateAnnotation(k) {
+			delete(newPod.Annotations, k)
+		}
+	}
+	for _, placement := range placements {
+		idxPrefix, uuidPrefix := util.PredicateGPUIndexPrefix, util.PredicateGPUUUIDPrefix
+		if placement.Init {
+			idxPrefix, uuidPrefix = util.PredicateGPUInitIndexPrefix, util.PredicateGPUInitUUIDPrefix
+		}
+		newPod.Annotations[idxPrefix+strconv.Itoa(placement.Index)] = joinIDs(placement.Devices)
+		if len(placement.UUIDs) > 0 {
+			newPod.Annotations[uuidPrefix+strconv.Itoa(placement.Index)] = strings.Join(placement.UUIDs, ",")
+		}
+		if len(placement.MIGInstances) > 0 {
+			newPod.Annotations[util.PredicateMIGInstancePrefix+strconv.Itoa(placement.Index)] =
+				joinMIGInstances(placement.MIGInstances)
+		}
+	}
+	if relaxed := relaxedConstraints(placements); len(relaxed) > 0 {
+		newPod.Annotations[util.PredicateRelaxedConstraints] = strings.Join(relaxed, ",")
+	}
+	if alloc.isRoundedUp(pod) {
+		newPod.Annotations[util.PredicateRoundedUp] = "true"
+	}
+	newPod.Annotations[util.PredicateNode] = alloc.nodeInfo.GetName()
+	return newPod
+}
+
+// isRoundedUp tells if a container of given pod requesting less than a GPU
+// is given a whole one by the share policy of the node, the pod is charged
+// so until it ends, whatever the policy is later
+func (alloc *allocator) isRoundedUp(pod *v1.Pod) bool {
+	if !alloc.nodeInfo.RoundsUpShare() {
+		return false
+	}
+	containers := append(append([]v1.Container{}, pod.Spec.Containers...), pod.Spec.InitContainers...)
+	for i := range containers {
+		if util.IsGPURequiredContainer(&containers[i]) && containerMode(&containers[i]) == metrics.ModeShare {
+			return true
+		}
+	}
+	return false
+}
+
+// isAllocatedHere tells if the pod carries the predicate annotations of this
+// node for all its containers which has GPU request
+func (alloc *allocator) isAllocatedHere(pod *v1.Pod) bool {
+	if pod.Annotations[util.PredicateNode] != alloc.nodeInfo.GetName() {
+		return false
+	}
+	for i, c := range pod.Spec.Containers {
 		if !util.IsGPURequiredContainer(&c) {
 			continue
 		}
-		devIDs := []string{}
-		devs, err := alloc.AllocateOne(pod, i, &c)
+		if _, err := util.GetPredicateIdxOfContainer(pod, i); err != nil {
+			return false
+		}
+		if _, count := util.GetMIGRequestOfContainer(&c); count > 0 {
+			if _, err := util.GetPredicateMIGInstancesOfContainer(pod, i); err != nil {
+				return false
+			}
+		}
+	}
+	for i, c := range pod.Spec.InitContainers {
+		if !util.IsGPURequiredContainer(&c) {
+			continue
+		}
+		if _, err := util.GetPredicateIdxOfInitContainer(pod, i); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// allocate charges the node for all containers which has GPU request of
+// given pod, and rolls back if any of them failed. The caller must hold
+// the lock of the node.
+//
+// If the pod asks for colocation, a shared container prefers the devices
+// already chosen for the former shared containers of this pod, and falls
+// back to the whole node if none of them fits.
+//
+// If the pod asks for anti-affinity, a container never uses the devices
+// chosen for the former containers of this pod. Anti-affinity takes
+// precedence over colocation.
+//
+// A container requesting a MIG profile gets MIG instances, and never
+// colocates with shared containers.
+//
+// The allocation is rolled back if ctx is done before a container.
+func (alloc *allocator) allocate(ctx context.Context, pod *v1.Pod) ([]ContainerPlacement, error) {
+	placements, reasons := alloc.allocateAll(ctx, pod, false)
+	if len(reasons) > 0 {
+		return nil, reasons[0]
+	}
+	return placements, nil
+}
+
+// allocateAll is allocate which returns the reasons the pod isn't
+// allocated. It stops at the first reason unless collect is set, then it
+// goes on after a container which doesn't fit, without charging it, and
+// the node is rolled back once there is any reason. The reasons of the pod
+// as a whole, e.g. an invalid node, are returned without evaluating the
+// containers.
+func (alloc *allocator) allocateAll(ctx context.Context, pod *v1.Pod, collect bool) ([]ContainerPlacement, []error) {
+	var (
+		placements   []ContainerPlacement
+		reasons      []error
+		antiAffinity = util.IsAntiAffinityPod(pod)
+		colocate     = util.IsColocatedPod(pod) && !antiAffinity
+		sharedIDs    []int
+		usedIDs      []int
+	)
+	if err := alloc.nodeInfo.Invalid(); err != nil {
+		return nil, []error{alloc.newAllocationError("", ErrInvalidNode, "%v", err)}
+	}
+	if max, need := alloc.nodeInfo.MaxDevicesPerPod(), alloc.devicesOf(pod); max > 0 && need > max {
+		reasons = append(reasons,
+			alloc.newAllocationError("", ErrTooManyDevices, "request %d GPUs, at most %d per pod", need, max))
+	}
+	if err := validateWholeNodePod(pod); err != nil {
+		err.Node = alloc.nodeInfo.GetName()
+		reasons = append(reasons, err)
+	}
+	if len(reasons) > 0 {
+		if !collect {
+			reasons = reasons[:1]
+		}
+		return nil, reasons
+	}
+	snapshot := alloc.nodeInfo.Clone()
+	// fail records the reason of a container, and tells if the allocation
+	// stops
+	fail := func(err error) bool {
+		reasons = append(reasons, err)
+		if collect && ctx.Err() == nil {
+			return false
+		}
+		alloc.nodeInfo.Restore(snapshot)
+		return true
+	}
+	for i, c := range pod.Spec.Containers {
+		if !util.IsGPURequiredContainer(&c) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			fail(err)
+			return nil, reasons
+		}
+		var (
+			devs    []*device.DeviceInfo
+			err     error
+			extra   []DeviceFilter
+			relaxed []string
+		)
+		if antiAffinity && len(usedIDs) > 0 {
+			extra = append(extra, ExcludeDeviceIDFilter(usedIDs))
+		}
+		if profile, count := util.GetMIGRequestOfContainer(&c); count > 0 {
+			refs, err := alloc.allocateMIG(&c, profile, count, extra...)
+			if err != nil {
+				klog.Infof("failed to allocate for pod %s(%s)", pod.Name, c.Name)
+				if fail(err) {
+					return nil, reasons
+				}
+				continue
+			}
+			ids := migDeviceIDs(refs)
+			usedIDs = append(usedIDs, ids...)
+			placements = append(placements, ContainerPlacement{
+				Name:         c.Name,
+				Index:        i,
+				Devices:      ids,
+				UUIDs:        alloc.migDeviceUUIDs(ids),
+				MIGInstances: refs,
+			})
+			continue
+		}
+		// a malformed request fails in allocateOne
+		needCores, _, _ := alloc.nodeInfo.GPURequestOf(pod, &c)
+		sharedMode := needCores < util.HundredCore
+		if colocate && sharedMode && len(sharedIDs) > 0 {
+			devs, err = alloc.allocateOne(ctx, pod, i, &c, DeviceIDFilter(sharedIDs))
+			if err != nil {
+				klog.V(4).Infof("failed to colocate pod %s(%s) on devices %v: %v",
+					pod.Name, c.Name, sharedIDs, err)
+			}
+		}
+		if len(devs) == 0 {
+			devs, relaxed, err = alloc.allocateRelaxed(ctx, pod, i, &c, extra...)
+		}
 		if err != nil {
-			klog.Infof("failed to allocate for pod %s(%s)", newPod.Name, c.Name)
+			klog.Infof("failed to allocate for pod %s(%s)", pod.Name, c.Name)
+			if fail(err) {
+				return nil, reasons
+			}
+			continue
+		}
+		if sharedMode {
+			sharedIDs = append(sharedIDs, deviceIDs(devs)...)
+		}
+		usedIDs = append(usedIDs, deviceIDs(devs)...)
+		placements = append(placements, ContainerPlacement{
+			Name:    c.Name,
+			Index:   i,
+			Devices: deviceIDs(devs),
+			UUIDs:   deviceUUIDs(devs),
+			Relaxed: relaxed,
+		})
+	}
+	initPlacements, initReasons := alloc.allocateInitContainers(ctx, pod, snapshot, collect)
+	if len(initReasons) > 0 {
+		klog.Infof("failed to allocate for pod %s init containers: %v", pod.Name, initReasons[0])
+		reasons = append(reasons, initReasons...)
+	}
+	if len(reasons) > 0 {
+		alloc.nodeInfo.Restore(snapshot)
+		return nil, reasons
+	}
+	return append(placements, initPlacements...), nil
+}
+
+// devicesOf returns the number of GPU devices given pod takes at most at a
+// time. A shared container takes a device, an exclusive one a device per
+// HundredCore, a MIG container a device per instance at most, and a whole
+// node pod all of the devices. The init containers run one by one before
+// the regular ones.
+func (alloc *allocator) devicesOf(pod *v1.Pod) uint {
+	if util.IsWholeNodePod(pod) {
+		return uint(alloc.nodeInfo.GetDeviceCount())
+	}
+	var regular, peak uint
+	for i := range pod.Spec.Containers {
+		regular += alloc.devicesOfContainer(pod, &pod.Spec.Containers[i])
+	}
+	for i := range pod.Spec.InitContainers {
+		if n := alloc.devicesOfContainer(pod, &pod.Spec.InitContainers[i]); n > peak {
+			peak = n
+		}
+	}
+	if peak > regular {
+		return peak
+	}
+	return regular
+}
+
+// devicesOfContainer returns the number of GPU devices given container of
+// pod takes, see devicesOf
+func (alloc *allocator) devicesOfContainer(pod *v1.Pod, c *v1.Container) uint {
+	if !util.IsGPURequiredContainer(c) {
+		return 0
+	}
+	if _, count := util.GetMIGRequestOfContainer(c); count > 0 {
+		return count
+	}
+	// a malformed request fails in allocateOne
+	vcore, _, err := alloc.nodeInfo.GPURequestOf(pod, c)
+	if err != nil {
+		return 0
+	}
+	if vcore < util.HundredCore {
+		return 1
+	}
+	return vcore / util.HundredCore
+}
+
+// AllocateOne tries to allocate GPU devices for given container,
+// the caller must hold the lock of the node. Nothing is allocated once ctx
+// is done. The soft constraints of the pod are relaxed in order if the
+// container doesn't fit otherwise, see relaxations.
+func (alloc *allocator) AllocateOne(ctx context.Context, pod *v1.Pod, containerIndex int,
+	container *v1.Container) ([]*device.DeviceInfo, error) {
+	devs, _, err := alloc.allocateRelaxed(ctx, pod, containerIndex, container)
+	return devs, err
+}
+
+// allocateOne tries to allocate GPU devices for given container, only the
+// devices accepted by the extra filters are candidates
+func (alloc *allocator) allocateOne(ctx context.Context, pod *v1.Pod, containerIndex int,
+	container *v1.Container, extra ...DeviceFilter) ([]*device.DeviceInfo, error) {
+	node := alloc.nodeInfo.GetNode()
+	//容器的预测执行时间
+	estimatedTime, err := util.GetEstimatedTimeOfContainer(pod, containerIndex)
+	if err != nil {
+		return nil, alloc.newAllocationError(container.Name, ErrInvalidRequest, "%v", err)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if alloc.observe {
+		defer metrics.ObserveAllocateLatency(containerMode(container), time.Now())
+	}
+	devs, vcore, vmemory, err := alloc.traceEvaluate(ctx, pod, container, estimatedTime, extra...)
+	if err != nil {
+		return nil, err
+	}
+
+	// record this container GPU request, we don't rollback data if an error happened,
+	// the caller should restore the node from a snapshot, see Allocate
+	defer alloc.timings.observe(stageCharge, time.Now())
+	for _, dev := range devs {
+		//新加入的container，已执行时间为 0
+		cores, memory := chargedResources(dev, vcore, vmemory)
+		err := alloc.nodeInfo.AddUsedResources(dev.GetID(), cores, memory, int(estimatedTime))
+		if err != nil {
+			klog.Infof("failed to update used resource for node %s dev %d due to %v",
+				node.Name, dev.GetID(), err)
+			return nil, err
+		}
+		alloc.nodeInfo.AddOwner(dev.GetID(), alloc.nodeInfo.OwnerOf(pod))
+	}
+	return devs, nil
+}
+
+// allocateMIG tries to allocate count MIG instances of given profile for
+// given container, only the devices accepted by the extra filters are
+// candidates. A container requesting more than one profile fails.
+func (alloc *allocator) allocateMIG(container *v1.Container, profile string, count uint,
+	extra ...DeviceFilter) ([]MIGInstanceRef, error) {
+	if err := validateMIGContainer(container); err != nil {
+		err.Node = alloc.nodeInfo.GetName()
+		return nil, err
+	}
+	start := time.Now()
+	refs := NewMIGMode(alloc.nodeInfo, extra...).Evaluate(profile, count)
+	alloc.timings.observe(stageFilter, start)
+	if len(refs) == 0 {
+		return nil, alloc.newAllocationError(container.Name, ErrInsufficientMIGInstances,
+			"request %d of profile %s", count, profile)
+	}
+	defer alloc.timings.observe(stageCharge, time.Now())
+	for _, ref := range refs {
+		if err := alloc.nodeInfo.UseMIGInstance(ref.Device, ref.Instance); err != nil {
+			klog.Infof("failed to update used MIG instance for node %s due to %v",
+				alloc.nodeInfo.GetName(), err)
 			return nil, err
 		}
+	}
+	return refs, nil
+}
+
+// allocateInitContainers tries to find suitable GPU devices for init containers.
+//
+// Init containers run one by one and finish before the regular containers
+// start, so each init container is evaluated against the node state before
+// this pod (the given snapshot), that is, it can reuse the devices of the
+// other init containers and of the regular containers of the same pod.
+// The pod occupies the peak of its init containers and regular containers
+// on each device, so only the part of the init containers' usage beyond the
+// regular containers' is charged to the node.
+//
+// It returns the reason of the first init container which doesn't fit, or
+// of all of them if collect is set, and nothing is charged then.
+func (alloc *allocator) allocateInitContainers(ctx context.Context, pod *v1.Pod,
+	snapshot *device.NodeInfo, collect bool) ([]ContainerPlacement, []error) {
+	var (
+		ret         []ContainerPlacement
+		reasons     []error
+		peakCores   = make(map[int]uint)
+		peakMemory  = make(map[int]uint)
+		snapshotDev = snapshot.GetDeviceMap()
+	)
+
+	for i, c := range pod.Spec.InitContainers {
+		if !util.IsGPURequiredContainer(&c) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, append(reasons, err)
+		}
+		initAlloc := NewAllocator(snapshot)
+		initAlloc.timings = alloc.timings
+		devs, vcore, vmemory, err := initAlloc.traceEvaluate(ctx, pod, &c, 0)
+		if err != nil {
+			if reasons = append(reasons, err); !collect {
+				return nil, reasons
+			}
+			continue
+		}
 		for _, dev := range devs {
-			devIDs = append(devIDs, strconv.Itoa(dev.GetID()))
+			cores, memory := chargedResources(dev, vcore, vmemory)
+			if peakCores[dev.GetID()] < cores {
+				peakCores[dev.GetID()] = cores
+			}
+			if peakMemory[dev.GetID()] < memory {
+				peakMemory[dev.GetID()] = memory
+			}
 		}
-		newPod.Annotations[util.PredicateGPUIndexPrefix+strconv.Itoa(i)] = strings.Join(devIDs, ",")
+		ret = append(ret, ContainerPlacement{
+			Name:    c.Name,
+			Index:   i,
+			Init:    true,
+			Devices: deviceIDs(devs),
+			UUIDs:   deviceUUIDs(devs),
+		})
+	}
+	if len(reasons) > 0 {
+		return nil, reasons
 	}
-	newPod.Annotations[util.PredicateNode] = alloc.nodeInfo.GetName()
-	newPod.Annotations[util.GPUAssigned] = "false"
-	newPod.Annotations[util.PredicateTimeAnnotation] = fmt.Sprintf("%d", time.Now().UnixNano())
 
-	return newPod, nil
+	defer alloc.timings.observe(stageCharge, time.Now())
+	for id, dev := range alloc.nodeInfo.GetDeviceMap() {
+		vcore := subOrZero(peakCores[id], snapshotDev[id].AllocatableCores()-dev.AllocatableCores())
+		vmemory := subOrZero(peakMemory[id], snapshotDev[id].AllocatableMemory()-dev.AllocatableMemory())
+		if vcore == 0 && vmemory == 0 {
+			continue
+		}
+		if err := alloc.nodeInfo.AddUsedResources(id, vcore, vmemory, 0); err != nil {
+			return nil, []error{err}
+		}
+	}
+	return ret, nil
+}
+
+// traceEvaluate is evaluate in a span tagged with the chosen devices
+func (alloc *allocator) traceEvaluate(ctx context.Context, pod *v1.Pod, container *v1.Container,
+	estimatedTime uint, extra ...DeviceFilter) ([]*device.DeviceInfo, uint, uint, error) {
+	attrs := append(alloc.spanAttributes(pod),
+		tracing.ContainerKey.String(container.Name),
+		tracing.ModeKey.String(containerMode(container)))
+	_, span := tracing.Start(ctx, tracing.SpanEvaluate, attrs...)
+	devs, vcore, vmemory, err := alloc.evaluate(pod, container, estimatedTime, extra...)
+	span.SetAttributes(tracing.DevicesKey.String(joinIDs(deviceIDs(devs))))
+	tracing.End(span, err)
+	return devs, vcore, vmemory, err
+}
+
+// spanAttributes returns the attributes of the spans of given pod on the
+// node of this allocator
+func (alloc *allocator) spanAttributes(pod *v1.Pod) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		tracing.NodeKey.String(alloc.nodeInfo.GetName()),
+		tracing.PodKey.String(pod.Namespace + "/" + pod.Name),
+		tracing.DeviceCountKey.Int(alloc.nodeInfo.GetDeviceCount()),
+	}
 }
 
-// AllocateOne tries to allocate GPU devices for given container
-func (alloc *allocator) AllocateOne(pod *v1.Pod, containerIndex int, container *v1.Container) ([]*device.DeviceInfo, error) {
+// evaluate picks GPU devices for given container without charging them,
+// it returns the chosen devices and the cores and memory should be charged
+// on each of them, the resources of an exclusive container depend on the
+// device, see chargedResources
+func (alloc *allocator) evaluate(pod *v1.Pod, container *v1.Container, estimatedTime uint,
+	extra ...DeviceFilter) ([]*device.DeviceInfo, uint, uint, error) {
 	var (
 		devs           []*device.DeviceInfo
 		sharedMode     bool
 		vcore, vmemory uint
 	)
-	node := alloc.nodeInfo.GetNode()
-	//节点的总的显存快熟
-	nodeTotalMemory := util.GetCapacityOfNode(node, util.VMemoryAnnotation)
-	//GPU设备数量
-	deviceCount := util.GetGPUDeviceCountOfNode(node)
-	//每张卡的GPU显存数量
-	deviceTotalMemory := uint(nodeTotalMemory / deviceCount)
-	//容器请求的GPU份数
-	needCores := util.GetGPUResourceOfContainer(container, util.VCoreAnnotation)
-	//容器所需的显存块数
-	needMemory := util.GetGPUResourceOfContainer(container, util.VMemoryAnnotation)
-	//容器的预测执行时间
-	estimatedTime, err := util.GetEstimatedTimeOfContainer(pod, containerIndex)
+	if profile, count := util.GetMIGRequestOfContainer(container); count > 0 {
+		return nil, 0, 0, alloc.newAllocationError(container.Name, ErrInvalidRequest,
+			"MIG profile %s is only supported by regular containers", profile)
+	}
+	vendor := alloc.nodeInfo.Vendor()
+	if requested, ok := util.GetVendorOfContainer(container); ok && requested.Name != vendor.Name {
+		return nil, 0, 0, alloc.newAllocationError(container.Name, ErrVendorMismatch,
+			"request %s, node has %s", requested.Name, vendor.Name)
+	}
+	//容器请求的GPU份数和显存块数
+	needCores, needMemory, err := alloc.nodeInfo.GPURequestOf(pod, container)
+	if err != nil {
+		return nil, 0, 0, alloc.newAllocationError(container.Name, ErrInvalidRequest, "pod %s: %v", pod.Name, err)
+	}
+	if needCores < util.HundredCore && alloc.nodeInfo.ShareDisabled() {
+		return nil, 0, 0, alloc.newAllocationError(container.Name, ErrShareDisabled,
+			"request %d vcore, only whole GPUs are allocated", needCores)
+	}
+	if needCores >= util.HundredCore && alloc.nodeInfo.ExclusiveDisabled() {
+		return nil, 0, 0, alloc.newAllocationError(container.Name, ErrExclusiveDisabled,
+			"request %d vcore, only shared GPUs are allocated", needCores)
+	}
+	filters, err := alloc.deviceFilters(pod, container)
 	if err != nil {
-		return devs, err
+		return nil, 0, 0, err
+	}
+	filters = append(filters, extra...)
+	if util.IsWholeNodePod(pod) {
+		start := time.Now()
+		devs, err = NewWholeNodeMode(alloc.nodeInfo, filters...).Evaluate(Request{})
+		alloc.timings.observe(stageFilter, start)
+		if err == nil && len(devs) == 0 {
+			err = alloc.wholeNodeError(container, filters)
+		}
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		// each device is charged as an exclusive container
+		return devs, needCores, needMemory, nil
+	}
+	//GPU设备数量
+	deviceCount := alloc.nodeInfo.GetDeviceCount()
+	//单张卡的最大GPU显存数量
+	var maxDeviceMemory uint
+	// a shared container fits a single device if its memory rounded up
+	// to the blocks of the device does
+	shareable := false
+	for _, dev := range alloc.nodeInfo.GetDeviceMap() {
+		if dev.SchedulableMemory() > maxDeviceMemory {
+			maxDeviceMemory = dev.SchedulableMemory()
+		}
+		if dev.AlignMemory(needMemory)+alloc.keepFree() <= dev.SchedulableMemory() {
+			shareable = true
+		}
 	}
 
+	// a request exceeding the capacity of the node never fits, skip the
+	// evaluation. A shared container leaves the free memory the node keeps
+	// on each device.
+	keepFree := alloc.keepFree()
 	switch {
-	case needCores < util.HundredCore:
-		devs = NewShareMode(alloc.nodeInfo).Evaluate(needCores, needMemory, estimatedTime)
-		sharedMode = true
-	default:
-		devs = NewExclusiveMode(alloc.nodeInfo).Evaluate(needCores, needMemory)
+	case needCores < util.HundredCore && needMemory+keepFree > maxDeviceMemory:
+		return nil, 0, 0, alloc.newAllocationError(container.Name, ErrExceedsCapacity,
+			"request %d vmemory, single GPU has at most %d", needMemory, subOrZero(maxDeviceMemory, keepFree))
+	case needCores < util.HundredCore && !shareable:
+		return nil, 0, 0, alloc.newAllocationError(container.Name, ErrExceedsCapacity,
+			"request %d vmemory, no GPU has so much in its memory blocks", needMemory)
+	case needCores >= util.HundredCore && int(needCores/util.HundredCore) > deviceCount:
+		return nil, 0, 0, alloc.newAllocationError(container.Name, ErrExceedsCapacity,
+			"request %d GPUs, node has %d", needCores/util.HundredCore, deviceCount)
 	}
 
-	if len(devs) == 0 {
-		return nil, fmt.Errorf("failed to allocate for container %s", container.Name)
+	var mode Mode
+	if needCores < util.HundredCore {
+		mode = NewShareMode(alloc.nodeInfo, filters...).ForOwner(alloc.nodeInfo.OwnerOf(pod)).
+			Packed(alloc.nodeInfo.IsLowPriority(pod)).KeepFree(keepFree).timed(alloc.timings)
+		sharedMode = true
+	} else {
+		mode = NewExclusiveMode(alloc.nodeInfo, filters...).timed(alloc.timings)
+	}
+	devs, err = mode.Evaluate(Request{Cores: needCores, Memory: needMemory, EstimatedTime: estimatedTime})
+	if err != nil {
+		return nil, 0, 0, alloc.newAllocationError(container.Name, ErrInvalidRequest, "%v", err)
 	}
 
+	// an exclusive container takes the whole of each device, see
+	// chargedResources
 	if sharedMode {
 		vcore = needCores
 		vmemory = needMemory
 	} else {
 		vcore = util.HundredCore
-		vmemory = deviceTotalMemory
 	}
 
-	// record this container GPU request, we don't rollback data if an error happened,
-	// because any container failed to be allocated will cause the predication failed
+	if len(devs) == 0 {
+		return nil, 0, 0, alloc.unsatisfiedError(container, filters, sharedMode, needCores, vmemory)
+	}
+
 	for _, dev := range devs {
-		//新加入的container，已执行时间为 0
-		err := alloc.nodeInfo.AddUsedResources(dev.GetID(), vcore, vmemory, int(estimatedTime))
-		if err != nil {
-			klog.Infof("failed to update used resource for node %s dev %d due to %v",
-				node.Name, dev.GetID(), err)
-			return nil, err
+		cores, memory := chargedResources(dev, vcore, vmemory)
+		if dev.AllocatableCores() < cores {
+			return nil, 0, 0, alloc.newAllocationError(container.Name, ErrInsufficientCores,
+				"request %d, dev %d has %d", cores, dev.GetID(), dev.AllocatableCores())
+		}
+		allocatable := dev.AllocatableMemory()
+		if sharedMode {
+			allocatable = subOrZero(allocatable, keepFree)
+		}
+		if allocatable < memory {
+			return nil, 0, 0, alloc.newAllocationError(container.Name, ErrInsufficientMemory,
+				"request %d, dev %d has %d", memory, dev.GetID(), allocatable)
 		}
 	}
-	return devs, nil
+	return devs, vcore, vmemory, nil
+}
+
+// chargedResources returns the cores and memory should be charged on given
+// device for a container which is charged vcore and vmemory on it by
+// evaluate. An exclusive container takes the whole schedulable part of the
+// device, whose memory differs between devices of different sizes, and
+// whose cores may be overcommitted or reserved.
+func chargedResources(dev *device.DeviceInfo, vcore, vmemory uint) (uint, uint) {
+	if vcore >= util.HundredCore {
+		return dev.SchedulableCores(), dev.SchedulableMemory()
+	}
+	return vcore, dev.AlignMemory(vmemory)
+}
+
+// unsatisfiedError finds out which constraint makes the evaluation of
+// given container return nothing. Only a shared container leaves the
+// memory the node keeps free on a device.
+func (alloc *allocator) unsatisfiedError(container *v1.Container, filters []DeviceFilter,
+	sharedMode bool, needCores, needMemory uint) error {
+	var candidates, enoughCores, fits, free int
+	var maxCores, maxMemory, keepFree uint
+	if sharedMode {
+		keepFree = alloc.keepFree()
+	}
+
+	for _, dev := range alloc.nodeInfo.GetDeviceMap() {
+		if !isCandidate(dev, filters) {
+			continue
+		}
+		candidates++
+		if isFree(dev) {
+			free++
+		}
+		if dev.AllocatableCores() > maxCores {
+			maxCores = dev.AllocatableCores()
+		}
+		if dev.AllocatableCores() < needCores {
+			continue
+		}
+		enoughCores++
+		if dev.AllocatableMemory() > maxMemory {
+			maxMemory = dev.AllocatableMemory()
+		}
+		if dev.AllocatableMemory() >= dev.AlignMemory(needMemory)+keepFree {
+			fits++
+		}
+	}
+
+	switch {
+	case candidates == 0:
+		return alloc.newAllocationError(container.Name, ErrNoAvailableDevice,
+			"all of %d GPUs are unavailable", alloc.nodeInfo.GetDeviceCount())
+	case !sharedMode:
+		return alloc.newAllocationError(container.Name, ErrInsufficientDevices,
+			"request %d, got %d", needCores/util.HundredCore, free)
+	case enoughCores == 0:
+		return alloc.newAllocationError(container.Name, ErrInsufficientCores,
+			"request %d, max allocatable %d", needCores, maxCores)
+	case fits > 0:
+		return alloc.newAllocationError(container.Name, ErrContainerLimit,
+			"%d GPUs fit, each of them has %d containers", fits, alloc.nodeInfo.MaxContainersPerDevice())
+	default:
+		return alloc.newAllocationError(container.Name, ErrInsufficientMemory,
+			"request %d, max allocatable %d", needMemory, subOrZero(maxMemory, keepFree))
+	}
+}
+
+// deviceFilters returns the filters restricting candidate devices of given pod
+func (alloc *allocator) deviceFilters(pod *v1.Pod, container *v1.Container) ([]DeviceFilter, error) {
+	var filters []DeviceFilter
+
+	if max, ok := alloc.nodeInfo.MaxECCErrors(); ok {
+		filters = append(filters, ECCErrorsFilter(max))
+	}
+
+	if model := util.GetGPUModelOfPod(pod); model != "" && !alloc.isRelaxed(ConstraintModel) {
+		filter := ModelFilter(model)
+		found := false
+		for _, dev := range alloc.nodeInfo.GetDeviceMap() {
+			if filter(dev) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, alloc.newAllocationError(container.Name, ErrNoMatchingModel, "model %s", model)
+		}
+		filters = append(filters, filter)
+	}
+
+	minCC, ok, err := util.GetMinComputeCapabilityOfPod(pod)
+	if err != nil {
+		return nil, alloc.newAllocationError(container.Name, ErrInvalidRequest, "%v", err)
+	}
+	if ok {
+		filter := ComputeCapabilityFilter(minCC)
+		found := false
+		for _, dev := range alloc.nodeInfo.GetDeviceMap() {
+			if filter(dev) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, alloc.newAllocationError(container.Name, ErrComputeCapability,
+				"compute capability %s", minCC)
+		}
+		filters = append(filters, filter)
+	}
+
+	pinned, err := util.GetPinnedDevicesOfPod(pod)
+	if err == nil && pinned != nil {
+		err = alloc.validateDeviceIDs(pinned)
+	}
+	if err != nil {
+		return nil, alloc.newAllocationError(container.Name, ErrInvalidRequest, "pinned devices: %v", err)
+	}
+	if pinned != nil && !alloc.isRelaxed(ConstraintTopology) {
+		filters = append(filters, DeviceIDFilter(pinned))
+	}
+
+	forbidden, err := util.GetForbiddenDevicesOfPod(pod)
+	if err == nil && forbidden != nil {
+		err = alloc.validateDeviceIDs(forbidden)
+	}
+	if err != nil {
+		return nil, alloc.newAllocationError(container.Name, ErrInvalidRequest, "forbidden devices: %v", err)
+	}
+	if forbidden != nil {
+		filters = append(filters, ExcludeDeviceIDFilter(forbidden))
+	}
+
+	return filters, nil
+}
+
+// validateDeviceIDs checks given idx against the GPU devices of the node
+func (alloc *allocator) validateDeviceIDs(ids []int) error {
+	count := alloc.nodeInfo.GetDeviceCount()
+	for _, id := range ids {
+		if id >= count {
+			return fmt.Errorf("GPU %d out of range, node has %d", id, count)
+		}
+	}
+	return nil
+}
+
+func deviceIDs(devs []*device.DeviceInfo) []int {
+	ids := make([]int, 0, len(devs))
+	for _, dev := range devs {
+		ids = append(ids, dev.GetID())
+	}
+	return ids
+}
+
+// deviceUUIDs returns the UUIDs of given devices, or nil if any of them is
+// unknown
+func deviceUUIDs(devs []*device.DeviceInfo) []string {
+	uuids := make([]string, 0, len(devs))
+	for _, dev := range devs {
+		if dev.UUID() == "" {
+			return nil
+		}
+		uuids = append(uuids, dev.UUID())
+	}
+	return uuids
+}
+
+// migDeviceIDs returns the distinct idx of the devices of given MIG instances
+func migDeviceIDs(refs []MIGInstanceRef) []int {
+	var ids []int
+	seen := make(map[int]bool)
+	for _, ref := range refs {
+		if !seen[ref.Device] {
+			seen[ref.Device] = true
+			ids = append(ids, ref.Device)
+		}
+	}
+	return ids
+}
+
+// migDeviceUUIDs returns the UUIDs of given devices of this node, or nil if
+// any of them is unknown
+func (alloc *allocator) migDeviceUUIDs(ids []int) []string {
+	devs := make([]*device.DeviceInfo, 0, len(ids))
+	for _, id := range ids {
+		devs = append(devs, alloc.nodeInfo.GetDeviceMap()[id])
+	}
+	return deviceUUIDs(devs)
+}
+
+func joinMIGInstances(refs []MIGInstanceRef) string {
+	strs := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		strs = append(strs, ref.String())
+	}
+	return strings.Join(strs, ",")
+}
+
+func joinIDs(ids []int) string {
+	idStrs := make([]string, 0, len(ids))
+	for _, id := range ids {
+		idStrs = append(idStrs, strconv.Itoa(id))
+	}
+	return strings.Join(idStrs, ",")
+}
+
+func subOrZero(a, b uint) uint {
+	if a < b {
+		return 0
+	}
+	return a - b
 }
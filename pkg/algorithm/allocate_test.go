@@ -0,0 +1,1364 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package algorithm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+
+	"tkestack.io/gpu-admission/pkg/config"
+	"tkestack.io/gpu-admission/pkg/device"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+type testContainer struct {
+	cores  uint
+	memory uint
+}
+
+func newTestPod(name string, containers ...testContainer) *v1.Pod {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			UID:         k8stypes.UID("uid-" + name),
+			Annotations: make(map[string]string),
+		},
+	}
+	for i, c := range containers {
+		pod.Spec.Containers = append(pod.Spec.Containers, v1.Container{
+			Name: "container-" + strconv.Itoa(i),
+			Resources: v1.ResourceRequirements{
+				Limits: v1.ResourceList{
+					v1.ResourceName(util.VCoreAnnotation):   resource.MustParse(fmt.Sprintf("%d", c.cores)),
+					v1.ResourceName(util.VMemoryAnnotation): resource.MustParse(fmt.Sprintf("%d", c.memory)),
+				},
+			},
+		})
+		pod.Annotations[util.EstimatedTime+strconv.Itoa(i)] = "0"
+	}
+	return pod
+}
+
+func TestAllocateSkipUnhealthyDevices(t *testing.T) {
+	testCases := []struct {
+		name      string
+		unhealthy string
+		container testContainer
+		expect    string
+	}{
+		{
+			name:      "share mode with partial unhealthy devices",
+			unhealthy: "0,1",
+			container: testContainer{cores: 10, memory: 1},
+			expect:    "2",
+		},
+		{
+			name:      "exclusive mode with partial unhealthy devices",
+			unhealthy: "0",
+			container: testContainer{cores: 200, memory: 1},
+			expect:    "1,2",
+		},
+		{
+			name:      "exclusive mode without enough healthy devices",
+			unhealthy: "0,2",
+			container: testContainer{cores: 200, memory: 1},
+		},
+		{
+			name:      "share mode with all devices unhealthy",
+			unhealthy: "0,1,2",
+			container: testContainer{cores: 10, memory: 1},
+		},
+		{
+			name:      "exclusive mode with all devices unhealthy",
+			unhealthy: "0,1,2",
+			container: testContainer{cores: 100, memory: 1},
+		},
+	}
+
+	for _, cs := range testCases {
+		node := newTestNode("testnode", 3, 24)
+		node.Annotations[util.UnhealthyGPUIndexes] = cs.unhealthy
+		nodeInfo := device.NewNodeInfo(node, nil)
+		pod := newTestPod("pod", cs.container)
+
+		newPod, err := NewAllocator(nodeInfo).Allocate(context.Background(), pod)
+		if cs.expect == "" {
+			if err == nil {
+				t.Fatalf("%s: allocation should fail, got %v", cs.name, newPod.Annotations)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: allocation failed: %v", cs.name, err)
+		}
+		if got := newPod.Annotations[util.PredicateGPUIndexPrefix+"0"]; got != cs.expect {
+			t.Fatalf("%s: got devices %s, expect %s", cs.name, got, cs.expect)
+		}
+	}
+}
+
+func TestEvaluateSkipDrainingDevices(t *testing.T) {
+	node := newTestNode("testnode", 3, 24)
+	node.Annotations[util.DrainingGPUIndexes] = "0"
+	node.Annotations[util.UnhealthyGPUIndexes] = "2"
+	running := newTestPod("running", testContainer{cores: 30, memory: 2})
+	running.Annotations[util.PredicateGPUIndexPrefix+"0"] = "0"
+	nodeInfo := device.NewNodeInfo(node, []*v1.Pod{running})
+
+	// the draining device still accounts for the running container
+	dev := nodeInfo.GetDeviceMap()[0]
+	if dev.Health() != device.Draining {
+		t.Fatalf("expect dev 0 draining, got %v", dev.Health())
+	}
+	if dev.AllocatableCores() != 70 || dev.AllocatableMemory() != 6 {
+		t.Fatalf("expect 70 cores and 6 memory left on dev 0, got %d and %d",
+			dev.AllocatableCores(), dev.AllocatableMemory())
+	}
+	if devs := nodeInfo.SchedulableDevices(); len(devs) != 1 || devs[0].GetID() != 1 {
+		t.Fatalf("expect only dev 1 schedulable, got %v", deviceIDs(devs))
+	}
+
+	if devs := mustEvaluate(t, NewShareMode(nodeInfo), Request{Cores: 10, Memory: 1}); len(devs) != 1 || devs[0].GetID() != 1 {
+		t.Fatalf("share mode should pick dev 1, got %v", deviceIDs(devs))
+	}
+	if devs := mustEvaluate(t, NewExclusiveMode(nodeInfo), Request{Cores: 100}); len(devs) != 1 || devs[0].GetID() != 1 {
+		t.Fatalf("exclusive mode should pick dev 1, got %v", deviceIDs(devs))
+	}
+	if devs := mustEvaluate(t, NewExclusiveMode(nodeInfo), Request{Cores: 200}); devs != nil {
+		t.Fatalf("exclusive mode should not pick draining devices, got %v", deviceIDs(devs))
+	}
+}
+
+func TestAllocateHeterogeneousMemory(t *testing.T) {
+	// a 24GB card and a 16GB card, 1 vmemory is 256MB
+	node := newTestNode("testnode", 2, 160)
+	node.Annotations[util.GPUMemories] = "96,64"
+	nodeInfo := device.NewNodeInfo(node, nil)
+	if got := nodeInfo.GetAvailableMemory(); got != 160 {
+		t.Fatalf("expect 160 memory on node, got %d", got)
+	}
+
+	// only the larger card fits
+	placements, err := NewAllocator(nodeInfo).Plan(context.Background(), newTestPod("pod", testContainer{cores: 10, memory: 80}))
+	if err != nil {
+		t.Fatalf("plan failed: %v", err)
+	}
+	if !reflect.DeepEqual(placements[0].Devices, []int{0}) {
+		t.Fatalf("got devices %v, expect [0]", placements[0].Devices)
+	}
+	_, err = NewAllocator(nodeInfo).Plan(context.Background(), newTestPod("pod", testContainer{cores: 10, memory: 97}))
+	if !errors.Is(err, ErrExceedsCapacity) {
+		t.Fatalf("expect ErrExceedsCapacity, got %v", err)
+	}
+
+	// an exclusive container takes the whole memory of each card
+	pod := newTestPod("pod", testContainer{cores: 200, memory: 1})
+	newPod, err := NewAllocator(nodeInfo).Allocate(context.Background(), pod)
+	if err != nil {
+		t.Fatalf("allocation failed: %v", err)
+	}
+	for id, dev := range nodeInfo.GetDeviceMap() {
+		if dev.AllocatableMemory() != 0 {
+			t.Fatalf("expect no memory left on dev %d, got %d", id, dev.AllocatableMemory())
+		}
+	}
+	nodeInfo = device.NewNodeInfo(node, []*v1.Pod{newPod})
+	if got := nodeInfo.GetAvailableMemory(); got != 0 {
+		t.Fatalf("expect no memory left on node, got %d", got)
+	}
+}
+
+func TestAllocateCoreOvercommit(t *testing.T) {
+	pod := newTestPod("pod", testContainer{cores: 60, memory: 1}, testContainer{cores: 60, memory: 1})
+	testCases := []struct {
+		name       string
+		ratio      float64
+		annotation string
+		cores      int
+		fits       bool
+	}{
+		{name: "no overcommit", ratio: 1, cores: 100, fits: false},
+		{name: "global overcommit", ratio: 1.5, cores: 150, fits: true},
+		{name: "node overrides global", ratio: 1.5, annotation: "1", cores: 100, fits: false},
+		{name: "ratio below 1 is clamped", ratio: 0.5, cores: 100, fits: false},
+	}
+
+	for _, cs := range testCases {
+		node := newTestNode("testnode", 1, 8)
+		if cs.annotation != "" {
+			node.Annotations[util.GPUCoreOvercommitRatio] = cs.annotation
+		}
+		nodeInfo := device.NewNodeInfoWithConfig(node, nil, &config.Config{CoreOvercommitRatio: cs.ratio})
+		if got := nodeInfo.GetAvailableCore(); got != cs.cores {
+			t.Fatalf("%s: expect %d cores, got %d", cs.name, cs.cores, got)
+		}
+		if got := nodeInfo.GetAvailableMemory(); got != 8 {
+			t.Fatalf("%s: memory should not be overcommitted, got %d", cs.name, got)
+		}
+		_, err := NewAllocator(nodeInfo).Plan(context.Background(), pod)
+		if fits := err == nil; fits != cs.fits {
+			t.Fatalf("%s: expect fits %v, got %v", cs.name, cs.fits, err)
+		}
+	}
+
+	// an exclusive container takes all of the overcommitted cores
+	nodeInfo := device.NewNodeInfoWithConfig(newTestNode("testnode", 1, 8), nil, &config.Config{CoreOvercommitRatio: 1.5})
+	if _, err := NewAllocator(nodeInfo).Allocate(context.Background(), newTestPod("pod", testContainer{cores: 100, memory: 1})); err != nil {
+		t.Fatalf("allocation failed: %v", err)
+	}
+	if got := nodeInfo.GetAvailableCore(); got != 0 {
+		t.Fatalf("expect no cores left, got %d", got)
+	}
+}
+
+func TestAllocateMaxContainersPerDevice(t *testing.T) {
+	nodeInfo := device.NewNodeInfoWithConfig(newTestNode("testnode", 2, 16), nil,
+		&config.Config{MaxContainersPerDevice: 2})
+	alloc := NewAllocator(nodeInfo)
+
+	for i := 0; i < 4; i++ {
+		if _, err := alloc.Allocate(context.Background(), newTestPod("pod"+strconv.Itoa(i), testContainer{cores: 1, memory: 1})); err != nil {
+			t.Fatalf("allocation %d failed: %v", i, err)
+		}
+	}
+	for id, dev := range nodeInfo.GetDeviceMap() {
+		if dev.NumberofContainer() != 2 {
+			t.Fatalf("expect 2 containers on dev %d, got %d", id, dev.NumberofContainer())
+		}
+	}
+	_, err := alloc.Allocate(context.Background(), newTestPod("pod4", testContainer{cores: 1, memory: 1}))
+	if !errors.Is(err, ErrContainerLimit) {
+		t.Fatalf("expect ErrContainerLimit, got %v", err)
+	}
+}
+
+func TestAllocateSkipBlacklistedDevices(t *testing.T) {
+	node := newTestNode("testnode", 3, 24)
+	node.Annotations[util.GPUUUIDs] = "GPU-a,GPU-b,GPU-c"
+	node.Annotations[util.GPUBlacklist] = "0,gpu-c"
+	running := newTestPod("running", testContainer{cores: 30, memory: 2})
+	running.Annotations[util.PredicateGPUIndexPrefix+"0"] = "0"
+	nodeInfo := device.NewNodeInfo(node, []*v1.Pod{running})
+
+	// the blacklisted device still accounts for the running container
+	if dev := nodeInfo.GetDeviceMap()[0]; dev.AllocatableCores() != 70 {
+		t.Fatalf("expect 70 cores left on dev 0, got %d", dev.AllocatableCores())
+	}
+	for _, expect := range []string{"1", ""} {
+		newPod, err := NewAllocator(nodeInfo).Allocate(context.Background(), newTestPod("pod", testContainer{cores: 100, memory: 1}))
+		if expect == "" {
+			if !errors.Is(err, ErrInsufficientDevices) {
+				t.Fatalf("expect ErrInsufficientDevices, got %v", err)
+			}
+			break
+		}
+		if err != nil {
+			t.Fatalf("allocation failed: %v", err)
+		}
+		if got := newPod.Annotations[util.PredicateGPUIndexPrefix+"0"]; got != expect {
+			t.Fatalf("got devices %s, expect %s", got, expect)
+		}
+	}
+	// dev 0 has free cores, but it's blacklisted
+	_, err := NewAllocator(nodeInfo).Allocate(context.Background(), newTestPod("pod", testContainer{cores: 10, memory: 1}))
+	if !errors.Is(err, ErrInsufficientCores) {
+		t.Fatalf("expect ErrInsufficientCores, got %v", err)
+	}
+}
+
+func TestAllocateReserved(t *testing.T) {
+	node := newTestNode("testnode", 2, 16)
+	nodeInfo := device.NewNodeInfoWithConfig(node, nil,
+		&config.Config{ReservedCoresPerDevice: 30, ReservedMemoryPerDevice: 2})
+	if got := nodeInfo.GetAvailableCore(); got != 140 {
+		t.Fatalf("expect 140 cores, got %d", got)
+	}
+
+	// the request fits the total of a device but not the schedulable part
+	_, err := NewAllocator(nodeInfo).Plan(context.Background(), newTestPod("pod", testContainer{cores: 80, memory: 1}))
+	if !errors.Is(err, ErrInsufficientCores) {
+		t.Fatalf("expect ErrInsufficientCores, got %v", err)
+	}
+	_, err = NewAllocator(nodeInfo).Plan(context.Background(), newTestPod("pod", testContainer{cores: 10, memory: 7}))
+	if !errors.Is(err, ErrExceedsCapacity) {
+		t.Fatalf("expect ErrExceedsCapacity, got %v", err)
+	}
+
+	// an exclusive container takes the schedulable part only
+	if _, err := NewAllocator(nodeInfo).Allocate(context.Background(), newTestPod("pod", testContainer{cores: 100, memory: 1})); err != nil {
+		t.Fatalf("allocation failed: %v", err)
+	}
+	if got := nodeInfo.GetAvailableCore(); got != 70 {
+		t.Fatalf("expect 70 cores, got %d", got)
+	}
+
+	// a device fully consumed by the reservation has nothing to schedule
+	nodeInfo = device.NewNodeInfoWithConfig(node, nil,
+		&config.Config{ReservedCoresPerDevice: 150, ReservedMemoryPerDevice: 10})
+	for id, dev := range nodeInfo.GetDeviceMap() {
+		if dev.AllocatableCores() != 0 || dev.AllocatableMemory() != 0 {
+			t.Fatalf("expect nothing schedulable on dev %d, got %d cores and %d memory",
+				id, dev.AllocatableCores(), dev.AllocatableMemory())
+		}
+	}
+	if got := nodeInfo.GetAvailableCore(); got != 0 {
+		t.Fatalf("expect 0 cores, got %d", got)
+	}
+	if _, err := NewAllocator(nodeInfo).Plan(context.Background(), newTestPod("pod", testContainer{cores: 100, memory: 1})); err == nil {
+		t.Fatalf("exclusive request should not fit")
+	}
+}
+
+func TestAllocateCancelled(t *testing.T) {
+	nodeInfo := device.NewNodeInfo(newTestNode("testnode", 2, 16), nil)
+	pod := newTestPod("pod", testContainer{cores: 10, memory: 1})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	alloc := NewAllocator(nodeInfo)
+	if _, err := alloc.Allocate(ctx, pod); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expect context.Canceled, got %v", err)
+	}
+	if alloc.IsAllocatable(ctx, pod) {
+		t.Fatalf("pod should not be allocatable once the context is done")
+	}
+	if _, err := alloc.AllocateOne(ctx, pod, 0, &pod.Spec.Containers[0]); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expect AllocateOne to return context.Canceled, got %v", err)
+	}
+	if nodeInfo.GetAvailableCore() != 200 {
+		t.Fatalf("cancelled allocation should not change the node, got %d cores left",
+			nodeInfo.GetAvailableCore())
+	}
+}
+
+func TestAllocateRollback(t *testing.T) {
+	nodeInfo := device.NewNodeInfo(newTestNode("testnode", 1, 8), nil)
+	// the second container asks for a whole card which is already shared
+	// by the first one
+	pod := newTestPod("pod", testContainer{cores: 50, memory: 2},
+		testContainer{cores: 100, memory: 1})
+
+	if newPod, err := NewAllocator(nodeInfo).Allocate(context.Background(), pod); err == nil {
+		t.Fatalf("allocation should fail, got %v", newPod.Annotations)
+	}
+	if NewAllocator(nodeInfo).IsAllocatable(context.Background(), pod) {
+		t.Fatalf("pod should not be allocatable")
+	}
+
+	dev := nodeInfo.GetDeviceMap()[0]
+	if dev.AllocatableCores() != util.HundredCore || dev.AllocatableMemory() != 8 ||
+		dev.NumberofContainer() != 0 {
+		t.Fatalf("device resources are not rolled back, cores: %d, memory: %d, containers: %d",
+			dev.AllocatableCores(), dev.AllocatableMemory(), dev.NumberofContainer())
+	}
+	if nodeInfo.GetAvailableCore() != util.HundredCore || nodeInfo.GetAvailableMemory() != 8 {
+		t.Fatalf("node resources are not rolled back, cores: %d, memory: %d",
+			nodeInfo.GetAvailableCore(), nodeInfo.GetAvailableMemory())
+	}
+}
+
+func TestAllocateInitContainers(t *testing.T) {
+	nodeInfo := device.NewNodeInfo(newTestNode("testnode", 1, 8), nil)
+	// the init container and the regular container can't fit in one GPU
+	// together, but they never run at the same time
+	pod := newTestPod("pod", testContainer{cores: 60, memory: 2})
+	initPod := newTestPod("init", testContainer{cores: 60, memory: 4})
+	pod.Spec.InitContainers = initPod.Spec.Containers
+
+	newPod, err := NewAllocator(nodeInfo).Allocate(context.Background(), pod)
+	if err != nil {
+		t.Fatalf("allocation failed: %v", err)
+	}
+	if got := newPod.Annotations[util.PredicateGPUInitIndexPrefix+"0"]; got != "0" {
+		t.Fatalf("init container got device %q, expect 0", got)
+	}
+	if got := newPod.Annotations[util.PredicateGPUIndexPrefix+"0"]; got != "0" {
+		t.Fatalf("container got device %q, expect 0", got)
+	}
+
+	// the pod occupies the peak of its init container and regular container
+	rebuilt := device.NewNodeInfo(nodeInfo.GetNode(), []*v1.Pod{newPod})
+	for _, n := range []*device.NodeInfo{nodeInfo, rebuilt} {
+		if n.GetAvailableCore() != 40 || n.GetAvailableMemory() != 4 {
+			t.Fatalf("expect 40 cores and 4 memory left, got %d cores and %d memory",
+				n.GetAvailableCore(), n.GetAvailableMemory())
+		}
+	}
+}
+
+func TestAllocateGPUModel(t *testing.T) {
+	testCases := []struct {
+		name      string
+		model     string
+		container testContainer
+		expect    string
+	}{
+		{
+			name:      "share mode picks the requested model",
+			model:     "A10",
+			container: testContainer{cores: 10, memory: 1},
+			expect:    "2",
+		},
+		{
+			name:      "exclusive mode picks the requested model",
+			model:     "t4",
+			container: testContainer{cores: 200, memory: 1},
+			expect:    "0,1",
+		},
+		{
+			name:      "exclusive mode without enough devices of the model",
+			model:     "a10",
+			container: testContainer{cores: 200, memory: 1},
+		},
+		{
+			name:      "absent model",
+			model:     "v100",
+			container: testContainer{cores: 10, memory: 1},
+		},
+	}
+
+	for _, cs := range testCases {
+		node := newTestNode("testnode", 3, 24)
+		node.Annotations[util.GPUModels] = "t4,t4,a10"
+		nodeInfo := device.NewNodeInfo(node, nil)
+		pod := newTestPod("pod", cs.container)
+		pod.Annotations[util.GPUModelAnnotation] = cs.model
+
+		newPod, err := NewAllocator(nodeInfo).Allocate(context.Background(), pod)
+		if cs.expect == "" {
+			if err == nil {
+				t.Fatalf("%s: allocation should fail, got %v", cs.name, newPod.Annotations)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: allocation failed: %v", cs.name, err)
+		}
+		if got := newPod.Annotations[util.PredicateGPUIndexPrefix+"0"]; got != cs.expect {
+			t.Fatalf("%s: got devices %s, expect %s", cs.name, got, cs.expect)
+		}
+	}
+}
+
+func TestAllocateMinComputeCapability(t *testing.T) {
+	testCases := []struct {
+		name      string
+		ccs       string
+		minCC     string
+		container testContainer
+		expect    string
+		err       error
+	}{
+		{name: "share mode at the minimum", ccs: "7.5,8.0,8.6", minCC: "8.6",
+			container: testContainer{cores: 10, memory: 1}, expect: "2"},
+		{name: "exclusive mode above the minimum", ccs: "7.5,8.0,8.6", minCC: "8.0",
+			container: testContainer{cores: 200, memory: 1}, expect: "1,2"},
+		{name: "exclusive mode without enough qualifying devices", ccs: "7.5,8.0,8.6", minCC: "8.1",
+			container: testContainer{cores: 200, memory: 1}, err: ErrInsufficientDevices},
+		{name: "just above the best device", ccs: "7.5,8.0,8.6", minCC: "8.7",
+			container: testContainer{cores: 10, memory: 1}, err: ErrComputeCapability},
+		{name: "unknown compute capabilities", minCC: "7.0",
+			container: testContainer{cores: 10, memory: 1}, err: ErrComputeCapability},
+		{name: "invalid minimum", ccs: "7.5,8.0,8.6", minCC: "8.x",
+			container: testContainer{cores: 10, memory: 1}, err: ErrInvalidRequest},
+	}
+
+	for _, cs := range testCases {
+		node := newTestNode("testnode", 3, 24)
+		if cs.ccs != "" {
+			node.Annotations[util.GPUComputeCapabilities] = cs.ccs
+		}
+		nodeInfo := device.NewNodeInfo(node, nil)
+		pod := newTestPod("pod", cs.container)
+		pod.Annotations[util.GPUMinCCAnnotation] = cs.minCC
+
+		newPod, err := NewAllocator(nodeInfo).Allocate(context.Background(), pod)
+		if cs.err != nil {
+			if !errors.Is(err, cs.err) {
+				t.Fatalf("%s: expect %v, got %v", cs.name, cs.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: allocation failed: %v", cs.name, err)
+		}
+		if got := newPod.Annotations[util.PredicateGPUIndexPrefix+"0"]; got != cs.expect {
+			t.Fatalf("%s: got devices %s, expect %s", cs.name, got, cs.expect)
+		}
+	}
+}
+
+func TestAllocateMaxECCErrors(t *testing.T) {
+	five, zero := uint(5), uint(0)
+	testCases := []struct {
+		name      string
+		eccErrors string
+		max       *uint
+		pin       string
+		container testContainer
+		expect    string
+		err       error
+	}{
+		{name: "device at the threshold", eccErrors: "5,6", max: &five, pin: "0",
+			container: testContainer{cores: 10, memory: 1}, expect: "0"},
+		{name: "device just over the threshold", eccErrors: "5,6", max: &five, pin: "1",
+			container: testContainer{cores: 10, memory: 1}, err: ErrNoAvailableDevice},
+		{name: "exclusive mode skips the device over the threshold", eccErrors: "5,6", max: &five,
+			container: testContainer{cores: 200, memory: 1}, err: ErrInsufficientDevices},
+		{name: "no threshold", eccErrors: "5,6", pin: "1",
+			container: testContainer{cores: 10, memory: 1}, expect: "1"},
+		{name: "no errors reported", max: &zero,
+			container: testContainer{cores: 200, memory: 1}, expect: "0,1"},
+	}
+
+	for _, cs := range testCases {
+		node := newTestNode("testnode", 2, 16)
+		if cs.eccErrors != "" {
+			node.Annotations[util.GPUECCErrors] = cs.eccErrors
+		}
+		cfg := config.Default()
+		cfg.MaxECCErrors = cs.max
+		nodeInfo := device.NewNodeInfoWithConfig(node, nil, cfg)
+		pod := newTestPod("pod", cs.container)
+		if cs.pin != "" {
+			pod.Annotations[util.GPUPinDevicesAnnotation] = cs.pin
+		}
+
+		newPod, err := NewAllocator(nodeInfo).Allocate(context.Background(), pod)
+		if cs.err != nil {
+			if !errors.Is(err, cs.err) {
+				t.Fatalf("%s: expect %v, got %v", cs.name, cs.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: allocation failed: %v", cs.name, err)
+		}
+		if got := newPod.Annotations[util.PredicateGPUIndexPrefix+"0"]; got != cs.expect {
+			t.Fatalf("%s: got devices %s, expect %s", cs.name, got, cs.expect)
+		}
+	}
+}
+
+func TestAllocatePinForbidDevices(t *testing.T) {
+	testCases := []struct {
+		name      string
+		pin       string
+		forbid    string
+		container testContainer
+		expect    string
+		err       error
+	}{
+		{name: "share mode on the pinned device", pin: "3",
+			container: testContainer{cores: 10, memory: 1}, expect: "3"},
+		{name: "exclusive mode on the pinned device", pin: "3",
+			container: testContainer{cores: 100, memory: 1}, expect: "3"},
+		{name: "exclusive mode on the pinned devices", pin: "0, 3",
+			container: testContainer{cores: 200, memory: 1}, expect: "0,3"},
+		{name: "pinned device partly used", pin: "1",
+			container: testContainer{cores: 100, memory: 1}, err: ErrInsufficientDevices},
+		{name: "pinned device without enough cores", pin: "1",
+			container: testContainer{cores: 60, memory: 1}, err: ErrInsufficientCores},
+		{name: "exclusive mode skips the forbidden device", forbid: "0",
+			container: testContainer{cores: 200, memory: 1}, expect: "2,3"},
+		{name: "pinned and forbidden", pin: "2,3", forbid: "3",
+			container: testContainer{cores: 10, memory: 1}, expect: "2"},
+		{name: "all devices forbidden", forbid: "0,1,2,3",
+			container: testContainer{cores: 10, memory: 1}, err: ErrNoAvailableDevice},
+		{name: "pinned device out of range", pin: "4",
+			container: testContainer{cores: 10, memory: 1}, err: ErrInvalidRequest},
+		{name: "invalid forbidden device", forbid: "x",
+			container: testContainer{cores: 10, memory: 1}, err: ErrInvalidRequest},
+	}
+
+	for _, cs := range testCases {
+		nodeInfo := device.NewNodeInfo(newTestNode("testnode", 4, 24), nil)
+		if err := nodeInfo.AddUsedResources(1, 50, 1, 0); err != nil {
+			t.Fatalf("%s: failed to add used resources: %v", cs.name, err)
+		}
+		pod := newTestPod("pod", cs.container)
+		if cs.pin != "" {
+			pod.Annotations[util.GPUPinDevicesAnnotation] = cs.pin
+		}
+		if cs.forbid != "" {
+			pod.Annotations[util.GPUForbidDevicesAnnotation] = cs.forbid
+		}
+
+		newPod, err := NewAllocator(nodeInfo).Allocate(context.Background(), pod)
+		if cs.err != nil {
+			if !errors.Is(err, cs.err) {
+				t.Fatalf("%s: expect %v, got %v", cs.name, cs.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: allocation failed: %v", cs.name, err)
+		}
+		if got := newPod.Annotations[util.PredicateGPUIndexPrefix+"0"]; got != cs.expect {
+			t.Fatalf("%s: got devices %s, expect %s", cs.name, got, cs.expect)
+		}
+	}
+}
+
+func TestConcurrentAllocate(t *testing.T) {
+	nodeInfo := device.NewNodeInfo(newTestNode("testnode", 1, 8), nil)
+	pod := newTestPod("pod", testContainer{cores: 20, memory: 1})
+
+	var (
+		wg      sync.WaitGroup
+		success int32
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if _, err := NewAllocator(nodeInfo).Allocate(context.Background(), pod); err == nil {
+				atomic.AddInt32(&success, 1)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			NewAllocator(nodeInfo).IsAllocatable(context.Background(), pod)
+		}()
+	}
+	wg.Wait()
+
+	if success != 5 {
+		t.Fatalf("expect 5 pods allocated on one GPU, got %d", success)
+	}
+	if nodeInfo.GetAvailableCore() != 0 {
+		t.Fatalf("expect no cores left, got %d", nodeInfo.GetAvailableCore())
+	}
+}
+
+func TestPlan(t *testing.T) {
+	nodeInfo := device.NewNodeInfo(newTestNode("testnode", 4, 32), nil)
+	if err := nodeInfo.AddUsedResources(0, 50, 4, 0); err != nil {
+		t.Fatalf("failed to add used resources: %v", err)
+	}
+	pod := newTestPod("pod", testContainer{cores: 200, memory: 1},
+		testContainer{}, testContainer{cores: 30, memory: 2})
+
+	alloc := NewAllocator(nodeInfo)
+	placements, err := alloc.Plan(context.Background(), pod)
+	if err != nil {
+		t.Fatalf("plan failed: %v", err)
+	}
+	if nodeInfo.GetAvailableCore() != 350 || nodeInfo.GetAvailableMemory() != 28 {
+		t.Fatalf("plan should not change the node, got %d cores and %d memory left",
+			nodeInfo.GetAvailableCore(), nodeInfo.GetAvailableMemory())
+	}
+	if !alloc.IsAllocatable(context.Background(), pod) || nodeInfo.GetAvailableCore() != 350 {
+		t.Fatalf("pod should be allocatable without changing the node")
+	}
+
+	newPod, err := alloc.Allocate(context.Background(), pod)
+	if err != nil {
+		t.Fatalf("allocation failed: %v", err)
+	}
+	if len(placements) != 2 {
+		t.Fatalf("expect placements of 2 containers, got %v", placements)
+	}
+	for _, placement := range placements {
+		got := newPod.Annotations[util.PredicateGPUIndexPrefix+strconv.Itoa(placement.Index)]
+		if got != joinIDs(placement.Devices) {
+			t.Fatalf("container %s planned on %v, but allocated on %s",
+				placement.Name, placement.Devices, got)
+		}
+	}
+}
+
+func TestAllocationError(t *testing.T) {
+	testCases := []struct {
+		name      string
+		modify    func(node *v1.Node, pod *v1.Pod)
+		container testContainer
+		reason    error
+	}{
+		{
+			name:      "insufficient cores",
+			container: testContainer{cores: 90, memory: 1},
+			reason:    ErrInsufficientCores,
+		},
+		{
+			name:      "insufficient memory",
+			container: testContainer{cores: 10, memory: 7},
+			reason:    ErrInsufficientMemory,
+		},
+		{
+			name:      "insufficient free devices",
+			container: testContainer{cores: 200, memory: 1},
+			reason:    ErrInsufficientDevices,
+		},
+		{
+			name:      "memory exceeds single GPU capacity",
+			container: testContainer{cores: 10, memory: 9},
+			reason:    ErrExceedsCapacity,
+		},
+		{
+			name:      "cards exceed node capacity",
+			container: testContainer{cores: 300, memory: 1},
+			reason:    ErrExceedsCapacity,
+		},
+		{
+			name: "no matching model",
+			modify: func(node *v1.Node, pod *v1.Pod) {
+				pod.Annotations[util.GPUModelAnnotation] = "a10"
+			},
+			container: testContainer{cores: 10, memory: 1},
+			reason:    ErrNoMatchingModel,
+		},
+		{
+			name: "no available device",
+			modify: func(node *v1.Node, pod *v1.Pod) {
+				node.Annotations[util.UnhealthyGPUIndexes] = "0,1"
+			},
+			container: testContainer{cores: 10, memory: 1},
+			reason:    ErrNoAvailableDevice,
+		},
+		{
+			name: "invalid request",
+			modify: func(node *v1.Node, pod *v1.Pod) {
+				delete(pod.Annotations, util.EstimatedTime+"0")
+			},
+			container: testContainer{cores: 10, memory: 1},
+			reason:    ErrInvalidRequest,
+		},
+		{
+			name: "malformed estimated time",
+			modify: func(node *v1.Node, pod *v1.Pod) {
+				pod.Annotations[util.EstimatedTime+"0"] = "soon"
+			},
+			container: testContainer{cores: 10, memory: 1},
+			reason:    ErrInvalidRequest,
+		},
+		{
+			name: "negative memory",
+			modify: func(node *v1.Node, pod *v1.Pod) {
+				pod.Spec.Containers[0].Resources.Limits[v1.ResourceName(util.VMemoryAnnotation)] = resource.MustParse("-1")
+			},
+			container: testContainer{cores: 10, memory: 1},
+			reason:    ErrInvalidRequest,
+		},
+		{
+			name: "overflowing cores",
+			modify: func(node *v1.Node, pod *v1.Pod) {
+				pod.Spec.Containers[0].Resources.Limits[v1.ResourceName(util.VCoreAnnotation)] = resource.MustParse("1e30")
+			},
+			container: testContainer{cores: 10, memory: 1},
+			reason:    ErrInvalidRequest,
+		},
+	}
+
+	for _, cs := range testCases {
+		node := newTestNode("testnode", 2, 16)
+		pod := newTestPod("pod", cs.container)
+		if cs.modify != nil {
+			cs.modify(node, pod)
+		}
+		nodeInfo := device.NewNodeInfo(node, nil)
+		// leave 80 cores and 2 memory on device 0 and 1 individually
+		for id := 0; id < 2; id++ {
+			if err := nodeInfo.AddUsedResources(id, 20, 6, 0); err != nil {
+				t.Fatalf("%s: failed to add used resources: %v", cs.name, err)
+			}
+		}
+
+		_, err := NewAllocator(nodeInfo).Allocate(context.Background(), pod)
+		if !errors.Is(err, cs.reason) {
+			t.Fatalf("%s: expect error %v, got %v", cs.name, cs.reason, err)
+		}
+		var allocErr *AllocationError
+		if !errors.As(err, &allocErr) || allocErr.Node != "testnode" || allocErr.Container != "container-0" {
+			t.Fatalf("%s: expect AllocationError of testnode/container-0, got %v", cs.name, err)
+		}
+	}
+}
+
+func TestUnmetReasons(t *testing.T) {
+	nodeInfo := device.NewNodeInfo(newTestNode("testnode", 1, 8), nil)
+	pod := newTestPod("pod", testContainer{cores: 50, memory: 2},
+		testContainer{cores: 60, memory: 1}, testContainer{cores: 10, memory: 7})
+
+	alloc := NewAllocator(nodeInfo)
+	reasons := alloc.UnmetReasons(context.Background(), pod)
+	if len(reasons) != 2 {
+		t.Fatalf("expect 2 reasons, got %v", reasons)
+	}
+	if !errors.Is(reasons[0], ErrInsufficientCores) || !errors.Is(reasons[1], ErrInsufficientMemory) {
+		t.Fatalf("expect insufficient vcore and vmemory, got %v", reasons)
+	}
+	if alloc.IsAllocatable(context.Background(), pod) {
+		t.Fatalf("pod should not be allocatable")
+	}
+	if nodeInfo.GetAvailableCore() != util.HundredCore || nodeInfo.GetAvailableMemory() != 8 {
+		t.Fatalf("node should not be changed, got %d cores and %d memory left",
+			nodeInfo.GetAvailableCore(), nodeInfo.GetAvailableMemory())
+	}
+	if reasons := alloc.UnmetReasons(context.Background(), newTestPod("pod", testContainer{cores: 50, memory: 2})); len(reasons) != 0 {
+		t.Fatalf("expect no reason, got %v", reasons)
+	}
+
+	// the checks of the whole allocation apply as well, the first reason
+	// is the error of Plan
+	antiAffinity := newTestPod("pod", testContainer{cores: 40, memory: 1}, testContainer{cores: 40, memory: 1})
+	antiAffinity.Annotations[util.GPUAntiAffinityAnnotation] = "true"
+	cfg := config.Default()
+	cfg.MaxDevicesPerPod = 1
+	for _, cs := range []struct {
+		name     string
+		nodeInfo *device.NodeInfo
+		pod      *v1.Pod
+		reason   error
+	}{
+		{"anti-affinity", nodeInfo, antiAffinity, ErrNoAvailableDevice},
+		{"max devices per pod", device.NewNodeInfoWithConfig(newTestNode("testnode", 2, 16), nil, cfg),
+			newTestPod("pod", testContainer{cores: 200, memory: 2}), ErrTooManyDevices},
+	} {
+		alloc := NewAllocator(cs.nodeInfo)
+		reasons := alloc.UnmetReasons(context.Background(), cs.pod)
+		_, err := alloc.Plan(context.Background(), cs.pod)
+		if len(reasons) != 1 || !errors.Is(reasons[0], cs.reason) || err == nil || err.Error() != reasons[0].Error() {
+			t.Fatalf("%s: expect the reason %v of Plan, got %v and %v", cs.name, cs.reason, reasons, err)
+		}
+		if alloc.IsAllocatable(context.Background(), cs.pod) {
+			t.Fatalf("%s: pod should not be allocatable", cs.name)
+		}
+	}
+}
+
+func TestAllocateIgnoreEphemeralContainers(t *testing.T) {
+	nodeInfo := device.NewNodeInfo(newTestNode("testnode", 1, 8), nil)
+	pod := newTestPod("pod", testContainer{cores: 50, memory: 2})
+	debugPod := newTestPod("debug", testContainer{cores: 100, memory: 8})
+	pod.Spec.EphemeralContainers = []v1.EphemeralContainer{
+		{EphemeralContainerCommon: v1.EphemeralContainerCommon(debugPod.Spec.Containers[0])},
+	}
+
+	newPod, err := NewAllocator(nodeInfo).Allocate(context.Background(), pod)
+	if err != nil {
+		t.Fatalf("allocation failed: %v", err)
+	}
+	for k := range newPod.Annotations {
+		if strings.HasPrefix(k, util.PredicateGPUIndexPrefix) && k != util.PredicateGPUIndexPrefix+"0" {
+			t.Fatalf("only the regular container should be annotated, got %v", newPod.Annotations)
+		}
+	}
+
+	rebuilt := device.NewNodeInfo(nodeInfo.GetNode(), []*v1.Pod{newPod})
+	for _, n := range []*device.NodeInfo{nodeInfo, rebuilt} {
+		if n.GetAvailableCore() != 50 || n.GetAvailableMemory() != 6 {
+			t.Fatalf("ephemeral container should not be charged, got %d cores and %d memory left",
+				n.GetAvailableCore(), n.GetAvailableMemory())
+		}
+	}
+}
+
+func TestAllocateColocate(t *testing.T) {
+	testCases := []struct {
+		name       string
+		colocate   string
+		containers []testContainer
+		sameDevice bool
+	}{
+		{
+			name:       "independent placement",
+			containers: []testContainer{{cores: 20, memory: 1}, {cores: 20, memory: 1}},
+			sameDevice: false,
+		},
+		{
+			name:       "colocate on the same device",
+			colocate:   "true",
+			containers: []testContainer{{cores: 20, memory: 1}, {cores: 20, memory: 1}},
+			sameDevice: true,
+		},
+		{
+			name:       "fall back when colocation doesn't fit",
+			colocate:   "true",
+			containers: []testContainer{{cores: 20, memory: 1}, {cores: 90, memory: 1}},
+			sameDevice: false,
+		},
+	}
+
+	for _, cs := range testCases {
+		nodeInfo := device.NewNodeInfo(newTestNode("testnode", 2, 16), nil)
+		pod := newTestPod("pod", cs.containers...)
+		pod.Annotations[util.GPUColocateAnnotation] = cs.colocate
+
+		newPod, err := NewAllocator(nodeInfo).Allocate(context.Background(), pod)
+		if err != nil {
+			t.Fatalf("%s: allocation failed: %v", cs.name, err)
+		}
+		dev0 := newPod.Annotations[util.PredicateGPUIndexPrefix+"0"]
+		dev1 := newPod.Annotations[util.PredicateGPUIndexPrefix+"1"]
+		if (dev0 == dev1) != cs.sameDevice {
+			t.Fatalf("%s: containers are placed on %s and %s", cs.name, dev0, dev1)
+		}
+	}
+}
+
+func TestAllocateAntiAffinity(t *testing.T) {
+	containers := []testContainer{{cores: 10, memory: 1}, {cores: 10, memory: 1}, {cores: 10, memory: 1}}
+
+	nodeInfo := device.NewNodeInfo(newTestNode("testnode", 2, 16), nil)
+	pod := newTestPod("pod", containers...)
+	pod.Annotations[util.GPUAntiAffinityAnnotation] = "true"
+	if newPod, err := NewAllocator(nodeInfo).Allocate(context.Background(), pod); err == nil {
+		t.Fatalf("allocation should fail on 2 GPUs, got %v", newPod.Annotations)
+	}
+
+	nodeInfo = device.NewNodeInfo(newTestNode("testnode", 4, 32), nil)
+	newPod, err := NewAllocator(nodeInfo).Allocate(context.Background(), pod)
+	if err != nil {
+		t.Fatalf("allocation failed on 4 GPUs: %v", err)
+	}
+	devs := make(map[string]bool)
+	for i := range containers {
+		devs[newPod.Annotations[util.PredicateGPUIndexPrefix+strconv.Itoa(i)]] = true
+	}
+	if len(devs) != len(containers) {
+		t.Fatalf("containers should be placed on distinct devices, got %v", newPod.Annotations)
+	}
+}
+
+func TestAllocateUUIDs(t *testing.T) {
+	uuids := []string{"GPU-a", "GPU-b", "GPU-c"}
+	node := newTestNode("testnode", 3, 24)
+	node.Annotations[util.GPUUUIDs] = strings.Join(uuids, ",")
+	pod := newTestPod("pod", testContainer{cores: 200, memory: 1}, testContainer{cores: 10, memory: 1})
+
+	newPod, err := NewAllocator(device.NewNodeInfo(node, nil)).Allocate(context.Background(), pod)
+	if err != nil {
+		t.Fatalf("allocation failed: %v", err)
+	}
+	for i := range pod.Spec.Containers {
+		ids, err := util.GetPredicateIdxOfContainer(newPod, i)
+		if err != nil {
+			t.Fatalf("failed to get predicate idx of container %d: %v", i, err)
+		}
+		expect := make([]string, 0, len(ids))
+		for _, id := range ids {
+			expect = append(expect, uuids[id])
+		}
+		if got := newPod.Annotations[util.PredicateGPUUUIDPrefix+strconv.Itoa(i)]; got != strings.Join(expect, ",") {
+			t.Fatalf("container %d got UUIDs %s, expect %v", i, got, expect)
+		}
+	}
+
+	// no UUID annotation if the node doesn't report UUIDs
+	newPod, err = NewAllocator(device.NewNodeInfo(newTestNode("testnode", 3, 24), nil)).Allocate(context.Background(), pod)
+	if err != nil {
+		t.Fatalf("allocation failed: %v", err)
+	}
+	if _, ok := newPod.Annotations[util.PredicateGPUUUIDPrefix+"0"]; ok {
+		t.Fatalf("unexpected UUID annotation: %v", newPod.Annotations)
+	}
+}
+
+func TestAllocateIdempotent(t *testing.T) {
+	node := newTestNode("testnode", 2, 16)
+	otherNode := newTestNode("othernode", 2, 16)
+	pod := newTestPod("pod", testContainer{cores: 20, memory: 1}, testContainer{cores: 100, memory: 1})
+
+	newPod, err := NewAllocator(device.NewNodeInfo(otherNode, nil)).Allocate(context.Background(), pod)
+	if err != nil {
+		t.Fatalf("allocation failed: %v", err)
+	}
+
+	// a pod allocated on another node is allocated afresh
+	nodeInfo := device.NewNodeInfo(node, nil)
+	newPod, err = NewAllocator(nodeInfo).Allocate(context.Background(), newPod)
+	if err != nil {
+		t.Fatalf("allocation failed: %v", err)
+	}
+	if newPod.Annotations[util.PredicateNode] != "testnode" {
+		t.Fatalf("pod should be allocated on testnode, got %v", newPod.Annotations)
+	}
+	if nodeInfo.GetAvailableCore() != 80 {
+		t.Fatalf("expect 80 cores left, got %d", nodeInfo.GetAvailableCore())
+	}
+
+	// a node created from the allocated pod is not charged again
+	nodeInfo = device.NewNodeInfo(node, []*v1.Pod{newPod})
+	againPod, err := NewAllocator(nodeInfo).Allocate(context.Background(), newPod)
+	if err != nil {
+		t.Fatalf("allocation failed: %v", err)
+	}
+	if !reflect.DeepEqual(againPod, newPod) {
+		t.Fatalf("pod should be unchanged, got %v, expect %v", againPod.Annotations, newPod.Annotations)
+	}
+	if nodeInfo.GetAvailableCore() != 80 {
+		t.Fatalf("expect 80 cores left, got %d", nodeInfo.GetAvailableCore())
+	}
+}
+
+func TestAllocateVendor(t *testing.T) {
+	defer util.SetVendors(nil)
+	cfg := config.Default()
+	cfg.Vendors = map[string]config.VendorKeys{"amd": {VCore: "amd.com/vcore", VMemory: "amd.com/vmemory"}}
+	util.SetVendors(cfg.ResolvedVendors())
+
+	amdNode := newTestNode("amd", 0, 0)
+	amdNode.Annotations[util.GPUVendor] = "amd"
+	amdNode.Status.Capacity = v1.ResourceList{
+		"amd.com/vcore":   resource.MustParse("200"),
+		"amd.com/vmemory": resource.MustParse("32"),
+	}
+	amdPod := func() *v1.Pod {
+		pod := newTestPod("amd-pod", testContainer{})
+		pod.Spec.Containers[0].Resources.Limits = v1.ResourceList{
+			"amd.com/vcore":   resource.MustParse("50"),
+			"amd.com/vmemory": resource.MustParse("4"),
+		}
+		return pod
+	}
+	nvidiaPod := func() *v1.Pod {
+		return newTestPod("nvidia-pod", testContainer{cores: 50, memory: 4})
+	}
+
+	nodeInfo := device.NewNodeInfo(amdNode, nil)
+	if nodeInfo.GetDeviceCount() != 2 || nodeInfo.Vendor().Name != "amd" {
+		t.Fatalf("expect 2 amd GPUs, got %d of %s", nodeInfo.GetDeviceCount(), nodeInfo.Vendor().Name)
+	}
+	newPod, err := NewAllocator(nodeInfo).Allocate(context.Background(), amdPod())
+	if err != nil {
+		t.Fatalf("failed to allocate amd pod: %v", err)
+	}
+	if got := newPod.Annotations[util.PredicateGPUIndexPrefix+"0"]; got != "0" {
+		t.Fatalf("expect device 0, got %s", got)
+	}
+	if free := nodeInfo.FreeCores(); free != 150 {
+		t.Fatalf("expect 150 free cores, got %d", free)
+	}
+	// the allocated pod is charged when the node is built from the pods
+	if free := device.NewNodeInfo(amdNode, []*v1.Pod{newPod}).FreeCores(); free != 150 {
+		t.Fatalf("expect 150 free cores of the rebuilt node, got %d", free)
+	}
+
+	for _, cs := range []struct {
+		name string
+		node *v1.Node
+		pod  *v1.Pod
+	}{
+		{name: "nvidia pod on amd node", node: amdNode, pod: nvidiaPod()},
+		{name: "amd pod on nvidia node", node: newTestNode("nvidia", 2, 32), pod: amdPod()},
+	} {
+		_, err := NewAllocator(device.NewNodeInfo(cs.node, nil)).Allocate(context.Background(), cs.pod)
+		if !errors.Is(err, ErrVendorMismatch) {
+			t.Fatalf("%s: expect %v, got %v", cs.name, ErrVendorMismatch, err)
+		}
+	}
+}
+
+func TestAllocateChargedRequest(t *testing.T) {
+	type used struct{ cores, memory uint }
+	testCases := []struct {
+		name      string
+		container testContainer
+		wholeNode bool
+		expect    []used
+	}{
+		{
+			name:      "shared",
+			container: testContainer{cores: 30, memory: 3},
+			expect:    []used{{30, 3}, {0, 0}},
+		},
+		{
+			name:      "exclusive",
+			container: testContainer{cores: 100, memory: 2},
+			expect:    []used{{100, 8}, {0, 0}},
+		},
+		{
+			// the limits of a whole node pod don't matter
+			name:      "whole node",
+			container: testContainer{cores: 50, memory: 1},
+			wholeNode: true,
+			expect:    []used{{100, 8}, {100, 8}},
+		},
+	}
+
+	for _, cs := range testCases {
+		nodeInfo := device.NewNodeInfo(newTestNode("testnode", 2, 16), nil)
+		pod := newTestPod("pod", cs.container)
+		if cs.wholeNode {
+			pod.Annotations[util.GPUWholeNodeAnnotation] = "true"
+		}
+		newPod, err := NewAllocator(nodeInfo).Allocate(context.Background(), pod)
+		if err != nil {
+			t.Fatalf("%s: allocation failed: %v", cs.name, err)
+		}
+
+		// the node rebuilt from the pod is charged the same as allocated
+		rebuilt := device.NewNodeInfo(nodeInfo.GetNode(), []*v1.Pod{newPod})
+		for _, n := range []*device.NodeInfo{nodeInfo, rebuilt} {
+			for id, expect := range cs.expect {
+				dev := n.GetDeviceMap()[id]
+				if got := (used{dev.UsedCores(), dev.UsedMemory()}); got != expect {
+					t.Fatalf("%s: device %d is charged %+v, expect %+v", cs.name, id, got, expect)
+				}
+			}
+		}
+	}
+}
+
+func TestAllocateMemoryBlocks(t *testing.T) {
+	testCases := []struct {
+		name   string
+		blocks string
+		memory uint
+		expect uint
+		reason error
+	}{
+		{name: "no block", memory: 5, expect: 5},
+		{name: "a block", blocks: "4,4", memory: 4, expect: 4},
+		{name: "above a block", blocks: "4,4", memory: 5, expect: 8},
+		{name: "two blocks", blocks: "4,4", memory: 8, expect: 8},
+		{name: "below capacity", blocks: "3,3", memory: 6, expect: 6},
+		// 7 fits the device of 8 while 9 in blocks doesn't
+		{name: "above capacity in blocks", blocks: "3,3", memory: 7, reason: ErrExceedsCapacity},
+		{name: "a device of smaller blocks", blocks: "3,4", memory: 7, expect: 8},
+	}
+
+	for _, cs := range testCases {
+		node := newTestNode("testnode", 2, 16)
+		if cs.blocks != "" {
+			node.Annotations[util.GPUMemoryBlocks] = cs.blocks
+		}
+		nodeInfo := device.NewNodeInfo(node, nil)
+		pod := newTestPod("pod", testContainer{cores: 10, memory: cs.memory})
+		newPod, err := NewAllocator(nodeInfo).Allocate(context.Background(), pod)
+		if cs.reason != nil {
+			if !errors.Is(err, cs.reason) {
+				t.Fatalf("%s: expect %v, got %v", cs.name, cs.reason, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: allocation failed: %v", cs.name, err)
+		}
+
+		// the rebuilt node is charged the rounded memory too
+		rebuilt := device.NewNodeInfo(node, []*v1.Pod{newPod})
+		for _, n := range []*device.NodeInfo{nodeInfo, rebuilt} {
+			if used := uint(16 - n.GetAvailableMemory()); used != cs.expect {
+				t.Fatalf("%s: charged %d vmemory, expect %d", cs.name, used, cs.expect)
+			}
+		}
+	}
+}
+
+func TestAllocateInconsistentMemoryBlocks(t *testing.T) {
+	node := newTestNode("testnode", 2, 16)
+	node.Annotations[util.GPUMemoryBlocks] = "4,2"
+	cfg := config.Default()
+	cfg.RejectInconsistentMemoryBlocks = true
+	nodeInfo := device.NewNodeInfoWithConfig(node, nil, cfg)
+
+	pod := newTestPod("pod", testContainer{cores: 10, memory: 2})
+	_, err := NewAllocator(nodeInfo).Allocate(context.Background(), pod)
+	if !errors.Is(err, ErrInvalidNode) {
+		t.Fatalf("expect %v, got %v", ErrInvalidNode, err)
+	}
+	if reason := FailureReason(err); reason != "invalid GPU node, GPU 1 has memory blocks of 2, others have 4" {
+		t.Fatalf("unexpected reason %q", reason)
+	}
+}
+
+func TestAllocateSharePolicy(t *testing.T) {
+	testCases := []struct {
+		policy string
+		reason error
+		expect []uint
+	}{
+		{policy: config.ShareAllowed, expect: []uint{30, 3}},
+		{policy: config.ShareRejected, reason: ErrShareDisabled},
+		{policy: config.ShareRoundedUp, expect: []uint{100, 8}},
+	}
+
+	for _, cs := range testCases {
+		cfg := config.Default()
+		cfg.SharePolicy = cs.policy
+		nodeInfo := device.NewNodeInfoWithConfig(newTestNode("testnode", 2, 16), nil, cfg)
+		pod := newTestPod("pod", testContainer{cores: 30, memory: 3})
+		newPod, err := NewAllocator(nodeInfo).Allocate(context.Background(), pod)
+		if cs.reason != nil {
+			if !errors.Is(err, cs.reason) {
+				t.Fatalf("%s: expect %v, got %v", cs.policy, cs.reason, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: allocation failed: %v", cs.policy, err)
+		}
+
+		// the node rebuilt from the pod is charged the same as allocated
+		rebuilt := device.NewNodeInfoWithConfig(nodeInfo.GetNode(), []*v1.Pod{newPod}, cfg)
+		for _, n := range []*device.NodeInfo{nodeInfo, rebuilt} {
+			dev := n.GetDeviceMap()[0]
+			if got := []uint{dev.UsedCores(), dev.UsedMemory()}; !reflect.DeepEqual(got, cs.expect) {
+				t.Fatalf("%s: device 0 is charged %v, expect %v", cs.policy, got, cs.expect)
+			}
+		}
+	}
+
+	// the pods are charged by their annotations once the policy changes, a
+	// pod rounded up keeps its whole GPU and a shared one its share
+	allowed, roundedUp := config.Default(), config.Default()
+	roundedUp.SharePolicy = config.ShareRoundedUp
+	var pods []*v1.Pod
+	for _, policy := range []*config.Config{allowed, roundedUp} {
+		nodeInfo := device.NewNodeInfoWithConfig(newTestNode("testnode", 1, 16), nil, policy)
+		newPod, err := NewAllocator(nodeInfo).Allocate(context.Background(),
+			newTestPod("pod", testContainer{cores: 30, memory: 3}))
+		if err != nil {
+			t.Fatalf("%s: allocation failed: %v", policy.SharePolicy, err)
+		}
+		if rounded := util.IsRoundedUpPod(newPod); rounded != (policy == roundedUp) {
+			t.Fatalf("%s: expect the pod rounded up %v, got %v", policy.SharePolicy, !rounded, rounded)
+		}
+		pods = append(pods, newPod)
+	}
+	for _, policy := range []*config.Config{allowed, roundedUp} {
+		for i, expect := range [][]uint{{30, 3}, {100, 16}} {
+			n := device.NewNodeInfoWithConfig(newTestNode("testnode", 1, 16), pods[i:i+1], policy)
+			dev := n.GetDeviceMap()[0]
+			if got := []uint{dev.UsedCores(), dev.UsedMemory()}; !reflect.DeepEqual(got, expect) {
+				t.Fatalf("%s: pod %d is charged %v, expect %v", policy.SharePolicy, i, got, expect)
+			}
+		}
+	}
+
+	// a whole GPU is allocated whatever the policy is
+	cfg := config.Default()
+	cfg.SharePolicy = config.ShareRejected
+	nodeInfo := device.NewNodeInfoWithConfig(newTestNode("testnode", 2, 16), nil, cfg)
+	pod := newTestPod("pod", testContainer{cores: 100, memory: 8})
+	if _, err := NewAllocator(nodeInfo).Allocate(context.Background(), pod); err != nil {
+		t.Fatalf("exclusive allocation failed: %v", err)
+	}
+}
+
+func TestAllocateExclusiveDisabled(t *testing.T) {
+	for _, disabled := range []bool{false, true} {
+		cfg := config.Default()
+		cfg.DisableExclusive = disabled
+		nodeInfo := device.NewNodeInfoWithConfig(newTestNode("testnode", 2, 16), nil, cfg)
+		pod := newTestPod("pod", testContainer{cores: 100, memory: 8})
+		_, err := NewAllocator(nodeInfo).Allocate(context.Background(), pod)
+		if disabled != errors.Is(err, ErrExclusiveDisabled) {
+			t.Fatalf("exclusive mode disabled %v: unexpected error %v", disabled, err)
+		}
+		if !disabled && err != nil {
+			t.Fatalf("exclusive allocation failed: %v", err)
+		}
+		if disabled && nodeInfo.GetDeviceMap()[0].UsedCores() != 0 {
+			t.Fatalf("a rejected container shouldn't be charged")
+		}
+
+		// a shared container is allocated either way
+		pod = newTestPod("pod", testContainer{cores: 30, memory: 3})
+		if _, err := NewAllocator(nodeInfo).Allocate(context.Background(), pod); err != nil {
+			t.Fatalf("exclusive mode disabled %v: shared allocation failed: %v", disabled, err)
+		}
+	}
+}
+
+func TestAllocateMaxDevicesPerPod(t *testing.T) {
+	testCases := []struct {
+		name       string
+		containers []testContainer
+		wholeNode  bool
+		reason     error
+	}{
+		{name: "at the cap", containers: []testContainer{{cores: 200, memory: 1}, {cores: 10, memory: 1}}},
+		{name: "below the cap", containers: []testContainer{{cores: 100, memory: 1}}},
+		{name: "exclusive above the cap", containers: []testContainer{{cores: 400, memory: 1}},
+			reason: ErrTooManyDevices},
+		{name: "shared above the cap", containers: []testContainer{{cores: 200, memory: 1},
+			{cores: 10, memory: 1}, {cores: 10, memory: 1}}, reason: ErrTooManyDevices},
+		{name: "whole node above the cap", containers: []testContainer{{cores: 10, memory: 1}},
+			wholeNode: true, reason: ErrTooManyDevices},
+	}
+
+	for _, cs := range testCases {
+		cfg := config.Default()
+		cfg.MaxDevicesPerPod = 3
+		nodeInfo := device.NewNodeInfoWithConfig(newTestNode("testnode", 4, 32), nil, cfg)
+		pod := newTestPod("pod", cs.containers...)
+		if cs.wholeNode {
+			pod.Annotations[util.GPUWholeNodeAnnotation] = "true"
+		}
+		_, err := NewAllocator(nodeInfo).Allocate(context.Background(), pod)
+		if cs.reason == nil {
+			if err != nil {
+				t.Fatalf("%s: allocation failed: %v", cs.name, err)
+			}
+			continue
+		}
+		if !errors.Is(err, cs.reason) {
+			t.Fatalf("%s: expect %v, got %v", cs.name, cs.reason, err)
+		}
+		if nodeInfo.GetAvailableCore() != 400 {
+			t.Fatalf("%s: a rejected pod shouldn't be charged", cs.name)
+		}
+	}
+
+	// there is no limit by default
+	nodeInfo := device.NewNodeInfo(newTestNode("testnode", 4, 32), nil)
+	if _, err := NewAllocator(nodeInfo).Allocate(context.Background(), newTestPod("pod", testContainer{cores: 400, memory: 1})); err != nil {
+		t.Fatalf("allocation without limit failed: %v", err)
+	}
+}
+
+func TestAllocateEstimatedTimes(t *testing.T) {
+	nodeInfo := device.NewNodeInfo(newTestNode("testnode", 2, 16), nil)
+	pod := newTestPod("pod", testContainer{cores: 20, memory: 2}, testContainer{cores: 20, memory: 2})
+	pod.Annotations[util.GPUAntiAffinityAnnotation] = "true"
+	delete(pod.Annotations, util.EstimatedTime+"1")
+	pod.Annotations[util.EstimatedTime+"0"] = "30m"
+	// the second container falls back to the estimated time of the pod
+	pod.Annotations[util.EstimatedTimeAnnotation] = "2h"
+
+	newPod, err := NewAllocator(nodeInfo).Allocate(context.Background(), pod)
+	if err != nil {
+		t.Fatalf("allocation failed: %v", err)
+	}
+	rebuilt := device.NewNodeInfo(nodeInfo.GetNode(), []*v1.Pod{newPod})
+	for i, expect := range []uint{1800, 7200} {
+		ids, err := util.GetPredicateIdxOfContainer(newPod, i)
+		if err != nil || len(ids) != 1 {
+			t.Fatalf("container %d: unexpected devices %v, %v", i, ids, err)
+		}
+		for _, n := range []*device.NodeInfo{nodeInfo, rebuilt} {
+			if got := n.GetDeviceMap()[ids[0]].IsolatedTime(); got != expect {
+				t.Fatalf("container %d: device %d has isolated time %d, expect %d", i, ids[0], got, expect)
+			}
+		}
+	}
+}
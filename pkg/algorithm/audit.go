@@ -0,0 +1,117 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package algorithm
+
+import (
+	"time"
+
+	"k8s.io/api/core/v1"
+
+	"tkestack.io/gpu-admission/pkg/audit"
+	"tkestack.io/gpu-admission/pkg/device"
+	"tkestack.io/gpu-admission/pkg/metrics"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+// AuditAllocated returns the record of given pod allocated on the node,
+// it's the pod returned by Allocate whose predicate annotations tell the
+// devices of each container
+func AuditAllocated(n *device.NodeInfo, pod *v1.Pod) audit.Record {
+	record := newAuditRecord(pod)
+	record.Node = n.GetName()
+	record.Outcome = audit.OutcomeAllocated
+	alloc := NewAllocator(n)
+	for _, placement := range annotatedPlacements(pod) {
+		containers := pod.Spec.Containers
+		if placement.Init {
+			containers = pod.Spec.InitContainers
+		}
+		c := &containers[placement.Index]
+		cores, memory := alloc.chargedOf(pod, c, placement)
+		record.Containers = append(record.Containers, audit.ContainerRecord{
+			Name:    placement.Name,
+			Init:    placement.Init,
+			Mode:    containerMode(c),
+			Devices: placement.Devices,
+			Cores:   cores,
+			Memory:  memory,
+		})
+	}
+	return record
+}
+
+// AuditRejected returns the record of given pod which fits no node, reason
+// tells why each node is unfit
+func AuditRejected(pod *v1.Pod, reason string) audit.Record {
+	record := newAuditRecord(pod)
+	record.Outcome = audit.OutcomeRejected
+	record.Reason = reason
+	return record
+}
+
+func newAuditRecord(pod *v1.Pod) audit.Record {
+	return audit.Record{
+		Time:      time.Now(),
+		PodUID:    string(pod.UID),
+		Namespace: pod.Namespace,
+		Pod:       pod.Name,
+		Mode:      podMode(pod),
+	}
+}
+
+// annotatedPlacements returns the devices of the containers which has GPU
+// request of given pod by its predicate annotations, the containers not
+// annotated are left out
+func annotatedPlacements(pod *v1.Pod) []ContainerPlacement {
+	var placements []ContainerPlacement
+	for i, c := range pod.Spec.Containers {
+		if !util.IsGPURequiredContainer(&c) {
+			continue
+		}
+		if ids, err := util.GetPredicateIdxOfContainer(pod, i); err == nil {
+			placements = append(placements, ContainerPlacement{Name: c.Name, Index: i, Devices: ids})
+		}
+	}
+	for i, c := range pod.Spec.InitContainers {
+		if !util.IsGPURequiredContainer(&c) {
+			continue
+		}
+		if ids, err := util.GetPredicateIdxOfInitContainer(pod, i); err == nil {
+			placements = append(placements, ContainerPlacement{Name: c.Name, Index: i, Init: true, Devices: ids})
+		}
+	}
+	return placements
+}
+
+// chargedOf returns the cores and memory in total the container takes on
+// the devices of its placement, nothing for MIG instances, see
+// chargedResources
+func (alloc *allocator) chargedOf(pod *v1.Pod, c *v1.Container, placement ContainerPlacement) (uint, uint) {
+	if containerMode(c) == metrics.ModeMIG {
+		return 0, 0
+	}
+	// the request has been validated by the allocation
+	vcore, vmemory, _ := alloc.nodeInfo.GPURequestOf(pod, c)
+	var cores, memory uint
+	devices := alloc.nodeInfo.GetDeviceMap()
+	for _, id := range placement.Devices {
+		devCores, devMemory := chargedResources(devices[id], vcore, vmemory)
+		cores += devCores
+		memory += devMemory
+	}
+	return cores, memory
+}
@@ -0,0 +1,77 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package algorithm
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"tkestack.io/gpu-admission/pkg/audit"
+	"tkestack.io/gpu-admission/pkg/device"
+	"tkestack.io/gpu-admission/pkg/metrics"
+)
+
+func TestAuditRecords(t *testing.T) {
+	// 2 devices of 8 vmemory each, device 1 is partly used
+	nodeInfo := device.NewNodeInfo(newTestNode("testnode", 2, 16), nil)
+	if err := nodeInfo.AddUsedResources(1, 10, 1, 0); err != nil {
+		t.Fatalf("failed to add used resources: %v", err)
+	}
+
+	shared := newTestPod("shared", testContainer{cores: 50, memory: 2})
+	shared.Namespace = "ns"
+	newPod, err := NewAllocator(nodeInfo).Allocate(context.Background(), shared)
+	if err != nil {
+		t.Fatalf("failed to allocate: %v", err)
+	}
+	exclusive := newTestPod("exclusive", testContainer{cores: 200, memory: 1})
+	exclusive.Namespace = "ns"
+
+	for i, cs := range []struct {
+		got    audit.Record
+		expect audit.Record
+	}{
+		{
+			got: AuditAllocated(nodeInfo, newPod),
+			expect: audit.Record{
+				PodUID: "uid-shared", Namespace: "ns", Pod: "shared", Node: "testnode",
+				Mode: metrics.ModeShare, Outcome: audit.OutcomeAllocated,
+				Containers: []audit.ContainerRecord{
+					{Name: "container-0", Mode: metrics.ModeShare, Devices: []int{0}, Cores: 50, Memory: 2},
+				},
+			},
+		},
+		{
+			got: AuditRejected(exclusive, "testnode: container container-0: insufficient free GPUs"),
+			expect: audit.Record{
+				PodUID: "uid-exclusive", Namespace: "ns", Pod: "exclusive",
+				Mode: metrics.ModeExclusive, Outcome: audit.OutcomeRejected,
+				Reason: "testnode: container container-0: insufficient free GPUs",
+			},
+		},
+	} {
+		got := cs.got
+		if got.Time.IsZero() {
+			t.Fatalf("record %d has no time", i)
+		}
+		got.Time = cs.expect.Time
+		if !reflect.DeepEqual(got, cs.expect) {
+			t.Fatalf("record %d: expect %+v, got %+v", i, cs.expect, got)
+		}
+	}
+}
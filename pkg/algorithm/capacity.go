@@ -0,0 +1,59 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package algorithm
+
+import (
+	"context"
+
+	"k8s.io/api/core/v1"
+)
+
+// DeviceCapacity is the free part of a GPU device
+type DeviceCapacity struct {
+	// ID is the idx of the device
+	ID int
+	// Cores and Memory are the allocatable vcore and vmemory
+	Cores  uint
+	Memory uint
+	// Containers is the number of containers on the device
+	Containers uint
+}
+
+// RemainingCapacity simulates the allocation of given pod against a copy of
+// the node, and returns the free part of each schedulable device after the
+// placement, ordered by device idx. The unschedulable devices are left out
+// since nothing can be placed on them. An error is returned if the pod
+// doesn't fit. The node's used resources are not changed.
+func (alloc *allocator) RemainingCapacity(pod *v1.Pod) ([]DeviceCapacity, error) {
+	alloc.nodeInfo.Lock()
+	defer alloc.nodeInfo.Unlock()
+
+	clone := alloc.nodeInfo.Clone()
+	if _, err := NewAllocator(clone).allocate(context.Background(), pod); err != nil {
+		return nil, err
+	}
+	var capacity []DeviceCapacity
+	for _, dev := range clone.SchedulableDevices() {
+		capacity = append(capacity, DeviceCapacity{
+			ID:         dev.GetID(),
+			Cores:      dev.AllocatableCores(),
+			Memory:     dev.AllocatableMemory(),
+			Containers: dev.NumberofContainer(),
+		})
+	}
+	return capacity, nil
+}
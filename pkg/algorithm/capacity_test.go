@@ -0,0 +1,66 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package algorithm
+
+import (
+	"errors"
+	"testing"
+
+	"tkestack.io/gpu-admission/pkg/device"
+)
+
+func TestRemainingCapacity(t *testing.T) {
+	nodeInfo := device.NewNodeInfo(newTestNode("testnode", 2, 16), nil)
+	if err := nodeInfo.AddUsedResources(0, 60, 4, 0); err != nil {
+		t.Fatalf("failed to add used resources: %v", err)
+	}
+	alloc := NewAllocator(nodeInfo)
+
+	capacity, err := alloc.RemainingCapacity(newTestPod("shared", testContainer{cores: 30, memory: 2}))
+	if err != nil {
+		t.Fatalf("failed to get remaining capacity: %v", err)
+	}
+	var cores, memory, containers uint
+	for _, dev := range capacity {
+		cores += dev.Cores
+		memory += dev.Memory
+		containers += dev.Containers
+	}
+	if len(capacity) != 2 || cores != 110 || memory != 10 || containers != 2 {
+		t.Fatalf("expect 110 cores, 10 vmemory and 2 containers left on 2 devices, got %+v", capacity)
+	}
+
+	capacity, err = alloc.RemainingCapacity(newTestPod("exclusive", testContainer{cores: 100, memory: 8}))
+	if err != nil {
+		t.Fatalf("failed to get remaining capacity: %v", err)
+	}
+	expect := []DeviceCapacity{{ID: 0, Cores: 40, Memory: 4, Containers: 1}, {ID: 1, Containers: 1}}
+	if len(capacity) != 2 || capacity[0] != expect[0] || capacity[1] != expect[1] {
+		t.Fatalf("expect %+v, got %+v", expect, capacity)
+	}
+
+	_, err = alloc.RemainingCapacity(newTestPod("too-big", testContainer{cores: 200, memory: 16}))
+	if !errors.Is(err, ErrInsufficientDevices) {
+		t.Fatalf("expect ErrInsufficientDevices, got %v", err)
+	}
+
+	// the simulated placements are not charged
+	if nodeInfo.GetAvailableCore() != 140 || nodeInfo.GetAvailableMemory() != 12 {
+		t.Fatalf("expect the node unchanged, got %d cores and %d vmemory",
+			nodeInfo.GetAvailableCore(), nodeInfo.GetAvailableMemory())
+	}
+}
@@ -0,0 +1,125 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package algorithm
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrInvalidRequest means the GPU request of the container can't be parsed
+	ErrInvalidRequest = errors.New("invalid GPU request")
+	// ErrExceedsCapacity means the request exceeds the capacity of a single
+	// GPU, or the number of GPUs of the node, so it never fits
+	ErrExceedsCapacity = errors.New("request exceeds single-GPU capacity")
+	// ErrNoMatchingModel means no GPU of the requested model on the node
+	ErrNoMatchingModel = errors.New("no GPU of the requested model")
+	// ErrVendorMismatch means the container requests the GPU of a vendor
+	// other than the one of the node
+	ErrVendorMismatch = errors.New("GPU of another vendor")
+	// ErrComputeCapability means no GPU on the node has the minimum
+	// compute capability requested
+	ErrComputeCapability = errors.New("no GPU of the minimum compute capability")
+	// ErrNoAvailableDevice means no GPU can be a placement candidate,
+	// e.g. all of them are unhealthy or filtered out
+	ErrNoAvailableDevice = errors.New("no available GPU")
+	// ErrInsufficientCores means no candidate GPU has enough vcore
+	ErrInsufficientCores = errors.New("insufficient vcore")
+	// ErrInsufficientMemory means no candidate GPU has enough vmemory
+	ErrInsufficientMemory = errors.New("insufficient vmemory")
+	// ErrInsufficientDevices means not enough free GPUs for an exclusive request
+	ErrInsufficientDevices = errors.New("insufficient free GPUs")
+	// ErrContainerLimit means all candidate GPUs having enough vcore and
+	// vmemory are shared by the max number of containers
+	ErrContainerLimit = errors.New("all GPUs at container limit")
+	// ErrInsufficientMIGInstances means not enough unused MIG instances of
+	// the requested profile
+	ErrInsufficientMIGInstances = errors.New("insufficient MIG instances")
+	// ErrShareDisabled means the container requests less than a GPU while
+	// the share policy rejects it
+	ErrShareDisabled = errors.New("GPU sharing disabled")
+	// ErrExclusiveDisabled means the container requests a GPU or more while
+	// exclusive mode is disabled
+	ErrExclusiveDisabled = errors.New("GPU exclusive mode disabled")
+	// ErrTooManyDevices means the containers of the pod take more GPUs
+	// together than MaxDevicesPerPod
+	ErrTooManyDevices = errors.New("too many GPUs per pod")
+	// ErrInvalidNode means no pod can be allocated on the node, e.g. its
+	// GPUs have inconsistent memory blocks
+	ErrInvalidNode = errors.New("invalid GPU node")
+	// ErrQuotaExceeded means the pod would use more than the GPU quota of
+	// its namespace
+	ErrQuotaExceeded = errors.New("namespace GPU quota exceeded")
+)
+
+// AllocationError tells which constraint of a container can't be satisfied
+// on a node. Reason is one of the Err* values above, use errors.Is to
+// inspect it. Container is empty if the constraint is of the whole pod.
+type AllocationError struct {
+	Node      string
+	Container string
+	Reason    error
+	Detail    string
+}
+
+func (e *AllocationError) Error() string {
+	msg := fmt.Sprintf("failed to allocate for container %s on node %s: %v",
+		e.Container, e.Node, e.Reason)
+	if e.Container == "" {
+		msg = fmt.Sprintf("failed to allocate on node %s: %v", e.Node, e.Reason)
+	}
+	if e.Detail != "" {
+		msg += ", " + e.Detail
+	}
+	return msg
+}
+
+func (e *AllocationError) Unwrap() error {
+	return e.Reason
+}
+
+// FailureReason returns the reason of a failed allocation to show for the
+// node in the filter result, e.g. "container c0: insufficient vmemory,
+// request 8, max allocatable 4". The node is left out, since the result is
+// keyed by it.
+func FailureReason(err error) string {
+	var allocErr *AllocationError
+	if !errors.As(err, &allocErr) {
+		return err.Error()
+	}
+	msg := fmt.Sprintf("container %s: %v", allocErr.Container, allocErr.Reason)
+	if allocErr.Container == "" {
+		msg = allocErr.Reason.Error()
+	}
+	if allocErr.Detail != "" {
+		msg += ", " + allocErr.Detail
+	}
+	return msg
+}
+
+// newAllocationError returns an AllocationError of given container on the
+// node of this allocator
+func (alloc *allocator) newAllocationError(container string, reason error,
+	format string, args ...interface{}) *AllocationError {
+	return &AllocationError{
+		Node:      alloc.nodeInfo.GetName(),
+		Container: container,
+		Reason:    reason,
+		Detail:    fmt.Sprintf(format, args...),
+	}
+}
@@ -0,0 +1,43 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package algorithm
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestFailureReason(t *testing.T) {
+	testCases := []struct {
+		err    error
+		expect string
+	}{
+		{&AllocationError{Node: "n", Container: "c0", Reason: ErrInsufficientMemory, Detail: "request 8, max allocatable 4"},
+			"container c0: insufficient vmemory, request 8, max allocatable 4"},
+		{fmt.Errorf("wrapped: %w", &AllocationError{Node: "n", Container: "c1", Reason: ErrNoMatchingModel, Detail: "model A10"}),
+			"container c1: no GPU of the requested model, model A10"},
+		{&AllocationError{Node: "n", Container: "c2", Reason: ErrContainerLimit},
+			"container c2: all GPUs at container limit"},
+		{errors.New("something else"), "something else"},
+	}
+	for _, tc := range testCases {
+		if got := FailureReason(tc.err); got != tc.expect {
+			t.Fatalf("expect %q, got %q", tc.expect, got)
+		}
+	}
+}
@@ -17,66 +17,209 @@
 package algorithm
 
 import (
+	"fmt"
 	"sort"
+	"time"
 
 	"k8s.io/klog"
 
 	"tkestack.io/gpu-admission/pkg/device"
+	"tkestack.io/gpu-admission/pkg/topology"
 	"tkestack.io/gpu-admission/pkg/util"
 )
 
 type exclusiveMode struct {
-	node *device.NodeInfo
+	node    *device.NodeInfo
+	filters []DeviceFilter
+	// timer records the time of the stages of Evaluate, nil records
+	// nothing
+	timer *stageTimer
 }
 
-//NewExclusiveMode returns a new exclusiveMode struct.
+// NewExclusiveMode returns a new exclusiveMode struct.
 //
-//Evaluate() of exclusiveMode returns one or more empty devices
-//which fullfil the request.
+// Evaluate() of exclusiveMode returns one or more empty devices
+// which fullfil the request.
 //
-//Exclusive mode means GPU devices are not sharing, only one
-//application can use them.
-func NewExclusiveMode(n *device.NodeInfo) *exclusiveMode {
-	return &exclusiveMode{n}
+// Exclusive mode means GPU devices are not sharing, only one
+// application can use them.
+//
+// The selection is deterministic: only schedulable and completely free
+// devices accepted by all of the filters are candidates, and they are
+// ordered by allocatable memory and then by device id, so the same node
+// state always yields the same devices.
+//
+// For a multi-card request, the devices best connected to each other are
+// picked if the node tells its topology, preferring those sharing the PCIe
+// locality of an RDMA NIC among the equally connected ones, see
+// pickByTopology. Otherwise they are picked from those sharing the PCIe
+// locality of an RDMA NIC if possible, see pickByNIC, or from as few NUMA
+// nodes as possible, see pickByNUMA.
+func NewExclusiveMode(n *device.NodeInfo, filters ...DeviceFilter) *exclusiveMode {
+	return &exclusiveMode{node: n, filters: filters}
+}
+
+// timed makes Evaluate record the time of its stages to given timer
+func (al *exclusiveMode) timed(timer *stageTimer) *exclusiveMode {
+	al.timer = timer
+	return al
 }
 
-func (al *exclusiveMode) Evaluate(cores uint, _ uint) []*device.DeviceInfo {
+func (al *exclusiveMode) Evaluate(req Request) ([]*device.DeviceInfo, error) {
+	if req.Cores < util.HundredCore {
+		return nil, fmt.Errorf("exclusive mode takes at least %d cores, request %d", util.HundredCore, req.Cores)
+	}
 	var (
 		devs        []*device.DeviceInfo
 		deviceCount = al.node.GetDeviceCount()
-		tmpStore    = make([]*device.DeviceInfo, deviceCount)
+		tmpStore    = make([]*device.DeviceInfo, 0, deviceCount)
 		sorter      = exclusiveModeSort(
-			device.ByAllocatableCores,
 			device.ByAllocatableMemory,
 			device.ByID)
-		num = int(cores / util.HundredCore)
+		num = int(req.Cores / util.HundredCore)
 	)
 
-	for i := 0; i < deviceCount; i++ {
-		tmpStore[i] = al.node.GetDeviceMap()[i]
+	start := time.Now()
+	for _, dev := range al.node.SchedulableDevices() {
+		if isCandidate(dev, al.filters) && isFree(dev) {
+			tmpStore = append(tmpStore, dev)
+		}
+	}
+
+	if len(tmpStore) < num {
+		al.timer.observe(stageFilter, start)
+		return nil, nil
 	}
 
 	sorter.Sort(tmpStore)
+	al.timer.observe(stageFilter, start)
+	start = time.Now()
+	if topo := al.node.Topology(); topo != nil && num > 1 {
+		devs = pickByTopology(topo, tmpStore, num)
+	} else {
+		devs = pickByNIC(tmpStore, num)
+	}
+	al.timer.observe(stageScore, start)
+
+	if klog.V(2) {
+		for _, dev := range devs {
+			klog.V(4).Infof("Pick up %d , cores: %d, memory: %d",
+				dev.GetID(), dev.AllocatableCores(), dev.AllocatableMemory())
+		}
+	}
 
-	for _, dev := range tmpStore {
-		if num == 0 {
-			break
+	return devs, nil
+}
+
+// pickByTopology picks num devices of the sorted candidates best connected
+// to each other, see topology.BestSetNear. Among the equally connected
+// devices, those close to the fewest RDMA NICs are preferred, like
+// pickByNIC, and then the order of candidates breaks the ties.
+func pickByTopology(topo *topology.Topology, candidates []*device.DeviceInfo, num int) []*device.DeviceInfo {
+	var (
+		devs = make([]*device.DeviceInfo, 0, num)
+		ids  = make([]int, 0, len(candidates))
+		byID = make(map[int]*device.DeviceInfo, len(candidates))
+		nics = make([]int, topo.Count())
+	)
+
+	for i := range nics {
+		nics[i] = -1
+	}
+	for _, dev := range candidates {
+		ids = append(ids, dev.GetID())
+		byID[dev.GetID()] = dev
+		if dev.GetID() < len(nics) {
+			nics[dev.GetID()] = dev.RDMANIC()
 		}
-		if dev.AllocatableCores() == util.HundredCore {
-			devs = append(devs, dev)
-			num -= 1
+	}
+	for _, id := range topo.BestSetNear(ids, nics, num) {
+		devs = append(devs, byID[id])
+	}
+	return devs
+}
+
+// pickByNIC picks num devices from the sorted candidates close to the same
+// RDMA NIC, so the traffic of GPUDirect RDMA doesn't cross the PCIe root.
+// If several NICs have enough devices, the one with the fewest candidates
+// is chosen, ties broken by NIC id, like pickByNUMA. If none has, or the
+// node tells no NIC affinity, the devices are picked by pickByNUMA.
+func pickByNIC(candidates []*device.DeviceInfo, num int) []*device.DeviceInfo {
+	var (
+		groups = make(map[int][]*device.DeviceInfo)
+		nics   []int
+	)
+
+	for _, dev := range candidates {
+		nic := dev.RDMANIC()
+		if nic < 0 {
 			continue
 		}
+		if _, ok := groups[nic]; !ok {
+			nics = append(nics, nic)
+		}
+		groups[nic] = append(groups[nic], dev)
 	}
 
-	if num > 0 {
-		return nil
+	sort.Slice(nics, func(i, j int) bool {
+		ni, nj := len(groups[nics[i]]), len(groups[nics[j]])
+		if ni != nj {
+			return ni < nj
+		}
+		return nics[i] < nics[j]
+	})
+	for _, nic := range nics {
+		if len(groups[nic]) >= num {
+			return groups[nic][:num]
+		}
 	}
 
-	if klog.V(2) {
-		for _, dev := range devs {
-			klog.V(4).Infof("Pick up %d , cores: %d, memory: %d",
-				dev.GetID(), dev.AllocatableCores(), dev.AllocatableMemory())
+	return pickByNUMA(candidates, num)
+}
+
+// pickByNUMA picks num devices from the sorted candidates. If a single
+// NUMA node has enough devices, the one with the fewest candidates is
+// chosen to leave larger NUMA nodes for later requests. Otherwise NUMA
+// nodes are taken from the largest to the smallest, which minimizes the
+// number of NUMA nodes used. Ties are broken by NUMA node id, and devices
+// inside a NUMA node keep the order of candidates.
+func pickByNUMA(candidates []*device.DeviceInfo, num int) []*device.DeviceInfo {
+	var (
+		devs   []*device.DeviceInfo
+		groups = make(map[int][]*device.DeviceInfo)
+		numas  []int
+	)
+
+	for _, dev := range candidates {
+		numa := dev.GetNUMANode()
+		if _, ok := groups[numa]; !ok {
+			numas = append(numas, numa)
+		}
+		groups[numa] = append(groups[numa], dev)
+	}
+
+	sort.Slice(numas, func(i, j int) bool {
+		ni, nj := len(groups[numas[i]]), len(groups[numas[j]])
+		if ni != nj {
+			return ni < nj
+		}
+		return numas[i] < numas[j]
+	})
+	for _, numa := range numas {
+		if len(groups[numa]) >= num {
+			return groups[numa][:num]
+		}
+	}
+
+	sort.SliceStable(numas, func(i, j int) bool {
+		return len(groups[numas[i]]) > len(groups[numas[j]])
+	})
+	for _, numa := range numas {
+		for _, dev := range groups[numa] {
+			if len(devs) == num {
+				return devs
+			}
+			devs = append(devs, dev)
 		}
 	}
 
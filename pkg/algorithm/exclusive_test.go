@@ -0,0 +1,277 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package algorithm
+
+import (
+	"fmt"
+	"testing"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"tkestack.io/gpu-admission/pkg/device"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+func newTestNode(name string, deviceCount, totalMemory int) *v1.Node {
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Annotations: make(map[string]string),
+		},
+		Status: v1.NodeStatus{
+			Capacity: v1.ResourceList{
+				v1.ResourceName(util.VCoreAnnotation):   resource.MustParse(fmt.Sprintf("%d", deviceCount*util.HundredCore)),
+				v1.ResourceName(util.VMemoryAnnotation): resource.MustParse(fmt.Sprintf("%d", totalMemory)),
+			},
+		},
+	}
+}
+
+func TestExclusiveModeDeterministic(t *testing.T) {
+	node := newTestNode("testnode", 4, 32)
+	nodeInfo := device.NewNodeInfo(node, nil)
+	// occupy a part of device 1 so that only 0, 2 and 3 are empty
+	if err := nodeInfo.AddUsedResources(1, 10, 1, 0); err != nil {
+		t.Fatalf("failed to add used resources: %v", err)
+	}
+
+	clonedNode := node.DeepCopy()
+	clonedNodeInfo := device.NewNodeInfo(clonedNode, nil)
+	if err := clonedNodeInfo.AddUsedResources(1, 10, 1, 0); err != nil {
+		t.Fatalf("failed to add used resources: %v", err)
+	}
+
+	expect := []int{0, 2}
+	for i := 0; i < 10; i++ {
+		got := deviceIDs(mustEvaluate(t, NewExclusiveMode(nodeInfo), Request{Cores: 2 * util.HundredCore}))
+		gotCloned := deviceIDs(mustEvaluate(t, NewExclusiveMode(clonedNodeInfo), Request{Cores: 2 * util.HundredCore}))
+		if fmt.Sprint(got) != fmt.Sprint(expect) || fmt.Sprint(gotCloned) != fmt.Sprint(expect) {
+			t.Fatalf("exclusive mode picked %v and %v on identical nodes, expect %v",
+				got, gotCloned, expect)
+		}
+	}
+
+	if devs := mustEvaluate(t, NewExclusiveMode(nodeInfo), Request{Cores: 4 * util.HundredCore}); devs != nil {
+		t.Fatalf("exclusive mode should fail when not enough empty devices, got %v", deviceIDs(devs))
+	}
+}
+
+func TestExclusiveModeNUMA(t *testing.T) {
+	testCases := []struct {
+		name   string
+		used   []int
+		cores  uint
+		expect []int
+	}{
+		{
+			name:   "empty node picks the first NUMA node",
+			cores:  2 * util.HundredCore,
+			expect: []int{0, 1},
+		},
+		{
+			name:   "skip the NUMA node without enough free devices",
+			used:   []int{0},
+			cores:  2 * util.HundredCore,
+			expect: []int{2, 3},
+		},
+		{
+			name:   "single card prefers the fuller NUMA node",
+			used:   []int{0},
+			cores:  util.HundredCore,
+			expect: []int{1},
+		},
+		{
+			name:   "span NUMA nodes when impossible to fit in one",
+			used:   []int{0, 2},
+			cores:  2 * util.HundredCore,
+			expect: []int{1, 3},
+		},
+		{
+			name:   "minimize NUMA nodes used",
+			cores:  3 * util.HundredCore,
+			expect: []int{0, 1, 2},
+		},
+	}
+
+	for _, cs := range testCases {
+		node := newTestNode("testnode", 4, 32)
+		node.Annotations[util.GPUNUMANodes] = "0,0,1,1"
+		nodeInfo := device.NewNodeInfo(node, nil)
+		for _, id := range cs.used {
+			if err := nodeInfo.AddUsedResources(id, 10, 1, 0); err != nil {
+				t.Fatalf("%s: failed to add used resources: %v", cs.name, err)
+			}
+		}
+
+		got := deviceIDs(mustEvaluate(t, NewExclusiveMode(nodeInfo), Request{Cores: cs.cores}))
+		if fmt.Sprint(got) != fmt.Sprint(cs.expect) {
+			t.Fatalf("%s: got devices %v, expect %v", cs.name, got, cs.expect)
+		}
+	}
+}
+
+func TestExclusiveModeRDMANIC(t *testing.T) {
+	testCases := []struct {
+		name   string
+		nics   string
+		used   []int
+		cores  uint
+		expect []int
+	}{
+		{
+			name:   "devices sharing a NIC across NUMA nodes",
+			nics:   "0,0,1,1",
+			cores:  2 * util.HundredCore,
+			expect: []int{0, 1},
+		},
+		{
+			name:   "skip the NIC without enough free devices",
+			nics:   "0,0,1,1",
+			used:   []int{0},
+			cores:  2 * util.HundredCore,
+			expect: []int{2, 3},
+		},
+		{
+			name:   "fall back to NUMA nodes when no NIC fits",
+			nics:   "0,0,1,1",
+			used:   []int{0, 2},
+			cores:  2 * util.HundredCore,
+			expect: []int{1, 3},
+		},
+		{
+			name:   "devices close to no NIC",
+			nics:   "-1,-1,1,1",
+			cores:  2 * util.HundredCore,
+			expect: []int{2, 3},
+		},
+		{
+			name:   "no NIC affinity",
+			cores:  2 * util.HundredCore,
+			expect: []int{0, 2},
+		},
+	}
+
+	for _, cs := range testCases {
+		node := newTestNode("testnode", 4, 32)
+		// each NIC is shared by the devices of both NUMA nodes
+		node.Annotations[util.GPUNUMANodes] = "0,1,0,1"
+		if cs.nics != "" {
+			node.Annotations[util.GPURDMANICs] = cs.nics
+		}
+		nodeInfo := device.NewNodeInfo(node, nil)
+		for _, id := range cs.used {
+			if err := nodeInfo.AddUsedResources(id, 10, 1, 0); err != nil {
+				t.Fatalf("%s: failed to add used resources: %v", cs.name, err)
+			}
+		}
+
+		got := deviceIDs(mustEvaluate(t, NewExclusiveMode(nodeInfo), Request{Cores: cs.cores}))
+		if fmt.Sprint(got) != fmt.Sprint(cs.expect) {
+			t.Fatalf("%s: got devices %v, expect %v", cs.name, got, cs.expect)
+		}
+	}
+}
+
+func TestExclusiveModeTopology(t *testing.T) {
+	// the NVLinks pair the devices across the NICs
+	const topo = `{"gpus":[` +
+		`["X","PIX","NV2","SYS"],` +
+		`["PIX","X","SYS","NV2"],` +
+		`["NV2","SYS","X","PIX"],` +
+		`["SYS","NV2","PIX","X"]],` +
+		`"nics":["mlx5_0","mlx5_1"],` +
+		`"nicLinks":[["PIX","SYS"],["PIX","SYS"],["SYS","PIX"],["SYS","PIX"]]}`
+
+	// no NVLink, the devices are all linked alike
+	const pcie = `{"gpus":[` +
+		`["X","SYS","SYS","SYS"],` +
+		`["SYS","X","SYS","SYS"],` +
+		`["SYS","SYS","X","SYS"],` +
+		`["SYS","SYS","SYS","X"]]}`
+
+	testCases := []struct {
+		name     string
+		topology string
+		nics     string
+		used     []int
+		cores    uint
+		expect   []int
+	}{
+		{
+			name:     "a pair of NVLinks",
+			topology: topo,
+			cores:    2 * util.HundredCore,
+			expect:   []int{0, 2},
+		},
+		{
+			name:     "the other pair of NVLinks",
+			topology: topo,
+			used:     []int{2},
+			cores:    2 * util.HundredCore,
+			expect:   []int{1, 3},
+		},
+		{
+			name:     "no NVLink left",
+			topology: topo,
+			used:     []int{0, 3},
+			cores:    2 * util.HundredCore,
+			expect:   []int{1, 2},
+		},
+		{
+			name:     "devices sharing a NIC among the equally linked",
+			topology: pcie,
+			nics:     "0,1,1,0",
+			cores:    2 * util.HundredCore,
+			expect:   []int{0, 3},
+		},
+		{
+			name:     "topology of another node",
+			topology: `{"gpus":[["X","NV1"],["NV1","X"]]}`,
+			cores:    2 * util.HundredCore,
+			expect:   []int{0, 1},
+		},
+	}
+
+	for _, cs := range testCases {
+		node := newTestNode("testnode", 4, 32)
+		node.Annotations[util.GPUTopology] = cs.topology
+		if cs.nics != "" {
+			node.Annotations[util.GPURDMANICs] = cs.nics
+		}
+		nodeInfo := device.NewNodeInfo(node, nil)
+		for _, id := range cs.used {
+			if err := nodeInfo.AddUsedResources(id, 10, 1, 0); err != nil {
+				t.Fatalf("%s: failed to add used resources: %v", cs.name, err)
+			}
+		}
+
+		got := deviceIDs(mustEvaluate(t, NewExclusiveMode(nodeInfo), Request{Cores: cs.cores}))
+		if fmt.Sprint(got) != fmt.Sprint(cs.expect) {
+			t.Fatalf("%s: got devices %v, expect %v", cs.name, got, cs.expect)
+		}
+	}
+
+	node := newTestNode("testnode", 4, 32)
+	node.Annotations[util.GPUTopology] = topo
+	for id, dev := range device.NewNodeInfo(node, nil).GetDeviceMap() {
+		if expect := id / 2; dev.RDMANIC() != expect {
+			t.Errorf("got NIC %d of device %d, expect %d", dev.RDMANIC(), id, expect)
+		}
+	}
+}
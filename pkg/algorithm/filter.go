@@ -0,0 +1,105 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package algorithm
+
+import (
+	"strings"
+
+	"tkestack.io/gpu-admission/pkg/device"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+// DeviceFilter tells if a GPU device can be a placement candidate
+type DeviceFilter func(dev *device.DeviceInfo) bool
+
+// ModelFilter returns a DeviceFilter which only accepts devices of given
+// model, the model name is case insensitive
+func ModelFilter(model string) DeviceFilter {
+	return func(dev *device.DeviceInfo) bool {
+		return strings.EqualFold(dev.Model(), model)
+	}
+}
+
+// ComputeCapabilityFilter returns a DeviceFilter which only accepts devices
+// of at least given compute capability, a device of unknown compute
+// capability is rejected
+func ComputeCapabilityFilter(min util.ComputeCapability) DeviceFilter {
+	return func(dev *device.DeviceInfo) bool {
+		return dev.ComputeCapability().AtLeast(min)
+	}
+}
+
+// ECCErrorsFilter returns a DeviceFilter which rejects devices of more ECC
+// errors than max
+func ECCErrorsFilter(max uint) DeviceFilter {
+	return func(dev *device.DeviceInfo) bool {
+		return dev.ECCErrors() <= max
+	}
+}
+
+// DeviceIDFilter returns a DeviceFilter which only accepts devices of given idx
+func DeviceIDFilter(ids []int) DeviceFilter {
+	return func(dev *device.DeviceInfo) bool {
+		for _, id := range ids {
+			if dev.GetID() == id {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// ExcludeDeviceIDFilter returns a DeviceFilter which rejects devices of given idx
+func ExcludeDeviceIDFilter(ids []int) DeviceFilter {
+	accept := DeviceIDFilter(ids)
+	return func(dev *device.DeviceInfo) bool {
+		return !accept(dev)
+	}
+}
+
+// isCandidate tells if a GPU device can be allocated by cores and memory,
+// that is, it's not MIG enabled, schedulable and accepted by all of the filters
+func isCandidate(dev *device.DeviceInfo, filters []DeviceFilter) bool {
+	return !dev.IsMIGEnabled() && acceptedBy(dev, filters)
+}
+
+// isFree tells if no cores of a GPU device is used, a device whose cores
+// are all reserved is never free
+func isFree(dev *device.DeviceInfo) bool {
+	return dev.SchedulableCores() > 0 && dev.AllocatableCores() == dev.SchedulableCores()
+}
+
+// atContainerLimit tells if a GPU device is shared by the max number of
+// containers of the node
+func atContainerLimit(n *device.NodeInfo, dev *device.DeviceInfo) bool {
+	max := n.MaxContainersPerDevice()
+	return max > 0 && dev.NumberofContainer() >= max
+}
+
+// acceptedBy tells if a GPU device is schedulable and accepted by all of the
+// filters
+func acceptedBy(dev *device.DeviceInfo, filters []DeviceFilter) bool {
+	if !dev.IsSchedulable() {
+		return false
+	}
+	for _, filter := range filters {
+		if !filter(dev) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,108 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package algorithm
+
+import (
+	"context"
+	"errors"
+
+	"k8s.io/api/core/v1"
+
+	"tkestack.io/gpu-admission/pkg/device"
+	"tkestack.io/gpu-admission/pkg/metrics"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+// containerMode returns the metrics mode of given container
+func containerMode(c *v1.Container) string {
+	if _, count := util.GetMIGRequestOfContainer(c); count > 0 {
+		return metrics.ModeMIG
+	}
+	// a malformed request is counted as shared
+	vendor, _ := util.GetVendorOfContainer(c)
+	needCores, _ := util.GetGPUResourceOfContainer(c, vendor.VCore)
+	if needCores >= util.HundredCore {
+		return metrics.ModeExclusive
+	}
+	return metrics.ModeShare
+}
+
+// recordModeDecisions counts the containers of given pod requesting GPU by
+// the mode they are allocated in on the node, that is exclusive for a whole
+// node pod, otherwise by the request under the share policy of the node
+func recordModeDecisions(n *device.NodeInfo, pod *v1.Pod) {
+	wholeNode := util.IsWholeNodePod(pod)
+	record := func(c *v1.Container) {
+		if !util.IsGPURequiredContainer(c) {
+			return
+		}
+		mode := containerMode(c)
+		if mode != metrics.ModeMIG {
+			mode = metrics.ModeShare
+			vcore, _, err := n.GPURequestOf(pod, c)
+			if wholeNode || (err == nil && vcore >= util.HundredCore) {
+				mode = metrics.ModeExclusive
+			}
+		}
+		metrics.RecordModeDecision(mode, pod.Namespace)
+	}
+	for i := range pod.Spec.Containers {
+		record(&pod.Spec.Containers[i])
+	}
+	for i := range pod.Spec.InitContainers {
+		record(&pod.Spec.InitContainers[i])
+	}
+}
+
+// podMode returns the metrics mode of the first container which has GPU
+// request of given pod
+func podMode(pod *v1.Pod) string {
+	for i := range pod.Spec.Containers {
+		if util.IsGPURequiredContainer(&pod.Spec.Containers[i]) {
+			return containerMode(&pod.Spec.Containers[i])
+		}
+	}
+	for i := range pod.Spec.InitContainers {
+		if util.IsGPURequiredContainer(&pod.Spec.InitContainers[i]) {
+			return containerMode(&pod.Spec.InitContainers[i])
+		}
+	}
+	return metrics.ModeShare
+}
+
+// RecordRejection counts given pod as failed to be allocated on any node,
+// err is the failure on the first node tried. The allocation on the node
+// chosen is counted by Allocate.
+func RecordRejection(pod *v1.Pod, err error) {
+	metrics.RecordAllocation(podMode(pod), metricsReason(err))
+}
+
+// metricsReason returns the failure reason label of err, which is the
+// text of the Err* reason so the number of label values is bounded
+func metricsReason(err error) string {
+	var allocErr *AllocationError
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &allocErr):
+		return allocErr.Reason.Error()
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return "canceled"
+	default:
+		return "internal"
+	}
+}
@@ -0,0 +1,78 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package algorithm
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"tkestack.io/gpu-admission/pkg/config"
+	"tkestack.io/gpu-admission/pkg/device"
+	"tkestack.io/gpu-admission/pkg/metrics"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+func TestAllocateModeDecisions(t *testing.T) {
+	testCases := []struct {
+		name        string
+		container   testContainer
+		sharePolicy string
+		wholeNode   bool
+		mode        string
+	}{
+		{name: "shared", container: testContainer{cores: 50, memory: 2}, mode: metrics.ModeShare},
+		{name: "exclusive", container: testContainer{cores: 100, memory: 8}, mode: metrics.ModeExclusive},
+		{name: "rounded up", container: testContainer{cores: 50, memory: 2}, sharePolicy: config.ShareRoundedUp,
+			mode: metrics.ModeExclusive},
+		{name: "whole node", container: testContainer{cores: 200, memory: 16}, wholeNode: true,
+			mode: metrics.ModeExclusive},
+	}
+
+	for _, cs := range testCases {
+		cfg := config.Default()
+		if cs.sharePolicy != "" {
+			cfg.SharePolicy = cs.sharePolicy
+		}
+		nodeInfo := device.NewNodeInfoWithConfig(newTestNode("testnode", 2, 16), nil, cfg)
+		pod := newTestPod("pod", cs.container)
+		pod.Namespace = "decisions-" + strings.Replace(cs.name, " ", "-", -1)
+		if cs.wholeNode {
+			pod.Annotations[util.GPUWholeNodeAnnotation] = "true"
+		}
+		counter := metrics.ModeDecisions.WithLabelValues(cs.mode, pod.Namespace)
+		before := testutil.ToFloat64(counter)
+
+		// planning the nodes decides nothing
+		if _, err := NewAllocator(nodeInfo).Plan(context.Background(), pod); err != nil {
+			t.Fatalf("%s: plan failed: %v", cs.name, err)
+		}
+		newPod, err := NewAllocator(nodeInfo).Allocate(context.Background(), pod)
+		if err != nil {
+			t.Fatalf("%s: allocation failed: %v", cs.name, err)
+		}
+		// the pod allocated already isn't decided again, e.g. on bind
+		if _, err := NewAllocator(nodeInfo).Allocate(context.Background(), newPod); err != nil {
+			t.Fatalf("%s: allocation failed: %v", cs.name, err)
+		}
+		if got := testutil.ToFloat64(counter) - before; got != 1 {
+			t.Fatalf("%s: expect 1 %s decision, got %v", cs.name, cs.mode, got)
+		}
+	}
+}
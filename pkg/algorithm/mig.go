@@ -0,0 +1,103 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package algorithm
+
+import (
+	"fmt"
+	"sort"
+
+	"k8s.io/klog"
+
+	"tkestack.io/gpu-admission/pkg/device"
+)
+
+// MIGInstanceRef refers to a MIG instance of a GPU device
+type MIGInstanceRef struct {
+	// Device is the idx of the GPU device
+	Device int
+	// Instance is the id of the MIG instance within the device
+	Instance int
+}
+
+func (r MIGInstanceRef) String() string {
+	return fmt.Sprintf("%d:%d", r.Device, r.Instance)
+}
+
+type migMode struct {
+	node    *device.NodeInfo
+	filters []DeviceFilter
+}
+
+// NewMIGMode returns a new migMode struct.
+//
+// Evaluate() of migMode returns unused MIG instances of the requested
+// profile. A MIG enabled device is sliced into instances of fixed profiles
+// by the administrator, so a request is matched to whole instances instead
+// of cores and memory.
+//
+// Devices with fewer free instances of the profile are used first, and
+// ties are broken by device id, which packs requests onto partially used
+// devices and keeps the selection deterministic.
+func NewMIGMode(n *device.NodeInfo, filters ...DeviceFilter) *migMode {
+	return &migMode{node: n, filters: filters}
+}
+
+func (al *migMode) Evaluate(profile string, count uint) []MIGInstanceRef {
+	var (
+		refs       []MIGInstanceRef
+		candidates []*device.DeviceInfo
+		free       = make(map[int][]int)
+		total      uint
+	)
+
+	for _, dev := range al.node.SchedulableDevices() {
+		if !dev.IsMIGEnabled() || !acceptedBy(dev, al.filters) {
+			continue
+		}
+		ids := dev.FreeMIGInstances(profile)
+		if len(ids) == 0 {
+			continue
+		}
+		candidates = append(candidates, dev)
+		free[dev.GetID()] = ids
+		total += uint(len(ids))
+	}
+
+	if total < count {
+		return nil
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		ni, nj := len(free[candidates[i].GetID()]), len(free[candidates[j].GetID()])
+		if ni != nj {
+			return ni < nj
+		}
+		return candidates[i].GetID() < candidates[j].GetID()
+	})
+	for _, dev := range candidates {
+		for _, id := range free[dev.GetID()] {
+			if uint(len(refs)) == count {
+				break
+			}
+			refs = append(refs, MIGInstanceRef{Device: dev.GetID(), Instance: id})
+		}
+	}
+
+	klog.V(4).Infof("Pick up MIG instances %v of profile %s", refs, profile)
+
+	return refs
+}
@@ -0,0 +1,123 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package algorithm
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"tkestack.io/gpu-admission/pkg/device"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+func newMIGTestNode() *v1.Node {
+	node := newTestNode("testnode", 3, 120)
+	// dev 0 and 1 are sliced, dev 2 is a normal GPU
+	node.Annotations[util.GPUMIGInstances] = "0:1g.5gb,0:1g.5gb,0:1g.5gb,0:3g.20gb,1:1g.5gb,1:1g.5gb"
+	return node
+}
+
+func newMIGTestPod(name, profile string, count int) *v1.Pod {
+	pod := newTestPod(name, testContainer{})
+	pod.Spec.Containers[0].Resources.Limits = v1.ResourceList{
+		v1.ResourceName(util.MIGResourcePrefix + profile): *resource.NewQuantity(int64(count), resource.DecimalSI),
+	}
+	return pod
+}
+
+func TestMIGModeEvaluate(t *testing.T) {
+	nodeInfo := device.NewNodeInfo(newMIGTestNode(), nil)
+
+	// dev 1 has fewer free 1g.5gb instances, so it's used first
+	refs := NewMIGMode(nodeInfo).Evaluate("1g.5gb", 3)
+	expect := []MIGInstanceRef{{Device: 1, Instance: 0}, {Device: 1, Instance: 1}, {Device: 0, Instance: 0}}
+	if !reflect.DeepEqual(refs, expect) {
+		t.Fatalf("got %v, expect %v", refs, expect)
+	}
+	if refs := NewMIGMode(nodeInfo).Evaluate("3g.20gb", 2); refs != nil {
+		t.Fatalf("expect no instances, got %v", refs)
+	}
+	if refs := NewMIGMode(nodeInfo).Evaluate("7g.40gb", 1); refs != nil {
+		t.Fatalf("expect no instances, got %v", refs)
+	}
+}
+
+func TestAllocateMIG(t *testing.T) {
+	node := newMIGTestNode()
+	nodeInfo := device.NewNodeInfo(node, nil)
+	alloc := NewAllocator(nodeInfo)
+
+	newPod, err := alloc.Allocate(context.Background(), newMIGTestPod("pod", "1g.5gb", 2))
+	if err != nil {
+		t.Fatalf("allocation failed: %v", err)
+	}
+	if got := newPod.Annotations[util.PredicateMIGInstancePrefix+"0"]; got != "1:0,1:1" {
+		t.Fatalf("got MIG instances %s, expect 1:0,1:1", got)
+	}
+	if got := newPod.Annotations[util.PredicateGPUIndexPrefix+"0"]; got != "1" {
+		t.Fatalf("got idx %s, expect 1", got)
+	}
+
+	// the used instances are restored from the pod annotations
+	nodeInfo = device.NewNodeInfo(node, []*v1.Pod{newPod})
+	if free := nodeInfo.GetDeviceMap()[1].FreeMIGInstances("1g.5gb"); len(free) != 0 {
+		t.Fatalf("expect no free instances on dev 1, got %v", free)
+	}
+	if nodeInfo.GetAvailableCore() != 300 {
+		t.Fatalf("MIG instances should not be charged by cores, got %d", nodeInfo.GetAvailableCore())
+	}
+
+	_, err = NewAllocator(nodeInfo).Allocate(context.Background(), newMIGTestPod("pod2", "3g.20gb", 2))
+	if !errors.Is(err, ErrInsufficientMIGInstances) {
+		t.Fatalf("expect ErrInsufficientMIGInstances, got %v", err)
+	}
+
+	// a container takes instances of a single profile, even if another one
+	// would fit
+	mixed := newMIGTestPod("pod3", "3g.20gb", 1)
+	mixed.Spec.Containers[0].Resources.Limits[v1.ResourceName(util.MIGResourcePrefix+"1g.5gb")] =
+		*resource.NewQuantity(1, resource.DecimalSI)
+	_, err = NewAllocator(device.NewNodeInfo(node, nil)).Allocate(context.Background(), mixed)
+	if !errors.Is(err, ErrInvalidRequest) {
+		t.Fatalf("expect ErrInvalidRequest, got %v", err)
+	}
+	if profile, count := util.GetMIGRequestOfContainer(&mixed.Spec.Containers[0]); profile != "1g.5gb" || count != 1 {
+		t.Fatalf("expect the first profile in name order, got %d of %s", count, profile)
+	}
+}
+
+func TestAllocateSkipMIGDevices(t *testing.T) {
+	nodeInfo := device.NewNodeInfo(newMIGTestNode(), nil)
+
+	// only dev 2 can be allocated by cores and memory
+	placements, err := NewAllocator(nodeInfo).Plan(context.Background(), newTestPod("pod", testContainer{cores: 10, memory: 1}))
+	if err != nil {
+		t.Fatalf("plan failed: %v", err)
+	}
+	if !reflect.DeepEqual(placements[0].Devices, []int{2}) {
+		t.Fatalf("got devices %v, expect [2]", placements[0].Devices)
+	}
+	_, err = NewAllocator(nodeInfo).Plan(context.Background(), newTestPod("pod", testContainer{cores: 200, memory: 1}))
+	if !errors.Is(err, ErrInsufficientDevices) {
+		t.Fatalf("expect ErrInsufficientDevices, got %v", err)
+	}
+}
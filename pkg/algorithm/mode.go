@@ -0,0 +1,45 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package algorithm
+
+import (
+	"tkestack.io/gpu-admission/pkg/device"
+)
+
+// Request is the GPU resources a container asks a Mode for
+type Request struct {
+	// Cores is the vcore of the container, HundredCore for a whole device
+	Cores uint
+	// Memory is the vmemory of the container, ignored by exclusive mode
+	Memory uint
+	// EstimatedTime is the estimated running time of the container,
+	// ignored by exclusive mode
+	EstimatedTime uint
+}
+
+// Mode picks the GPU devices of a node for a container. Evaluate returns no
+// devices and no error if the request doesn't fit, an error means the mode
+// can't handle the request at all. The devices are not charged, see
+// allocator.evaluate.
+type Mode interface {
+	Evaluate(req Request) ([]*device.DeviceInfo, error)
+}
+
+var (
+	_ Mode = &shareMode{}
+	_ Mode = &exclusiveMode{}
+)
@@ -0,0 +1,49 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package algorithm
+
+import (
+	"testing"
+
+	"tkestack.io/gpu-admission/pkg/device"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+// mustEvaluate returns the devices mode picks for req, the test fails if
+// the mode can't handle req
+func mustEvaluate(t *testing.T, mode Mode, req Request) []*device.DeviceInfo {
+	t.Helper()
+	devs, err := mode.Evaluate(req)
+	if err != nil {
+		t.Fatalf("failed to evaluate %+v: %v", req, err)
+	}
+	return devs
+}
+
+func TestModeRejectsOtherRequests(t *testing.T) {
+	nodeInfo := device.NewNodeInfo(newTestNode("testnode", 2, 16), nil)
+
+	if _, err := NewShareMode(nodeInfo).Evaluate(Request{Cores: util.HundredCore}); err == nil {
+		t.Fatalf("share mode should reject a request of a whole device")
+	}
+	if _, err := NewExclusiveMode(nodeInfo).Evaluate(Request{Cores: 50, Memory: 1}); err == nil {
+		t.Fatalf("exclusive mode should reject a request of part of a device")
+	}
+	if devs := mustEvaluate(t, NewShareMode(nodeInfo), Request{Cores: 50, Memory: 1}); len(devs) != 1 {
+		t.Fatalf("share mode should pick one device, got %v", deviceIDs(devs))
+	}
+}
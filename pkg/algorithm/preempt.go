@@ -0,0 +1,127 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package algorithm
+
+import (
+	"context"
+	"math"
+	"sort"
+
+	"k8s.io/api/core/v1"
+
+	"tkestack.io/gpu-admission/pkg/config"
+	"tkestack.io/gpu-admission/pkg/device"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+// SelectVictims returns a minimal set of pods among given pods on the node
+// whose eviction makes given pod allocatable on the node. Only pods which
+// have GPU request and a lower priority than given pod are candidates, the
+// ones with the lowest priority, then the least remaining time, are chosen
+// first. It returns false if the pod can't be allocated even if all
+// candidates are evicted. The pod fits once it's planned on the node as the
+// filter would allocate it, see Plan. The pods are simulated on fresh
+// NodeInfos, none of the given objects is changed.
+func SelectVictims(node *v1.Node, pods []*v1.Pod, pod *v1.Pod, cfg *config.Config) ([]*v1.Pod, bool) {
+	var (
+		priority   = podPriority(pod)
+		candidates []*v1.Pod
+	)
+	for _, p := range pods {
+		if p.UID != pod.UID && podPriority(p) < priority && util.IsGPURequiredPod(p) {
+			candidates = append(candidates, p)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		pi, pj := podPriority(candidates[i]), podPriority(candidates[j])
+		if pi != pj {
+			return pi < pj
+		}
+		return remainingTime(candidates[i]) < remainingTime(candidates[j])
+	})
+
+	evicted := make(map[*v1.Pod]bool)
+	fits := func() bool {
+		var rest []*v1.Pod
+		for _, p := range pods {
+			if p.UID != pod.UID && !evicted[p] {
+				rest = append(rest, p)
+			}
+		}
+		nodeInfo := device.NewNodeInfoWithConfig(node, rest, cfg)
+		_, err := NewAllocator(nodeInfo).Plan(context.Background(), pod)
+		return err == nil
+	}
+	if fits() {
+		return nil, true
+	}
+
+	var (
+		victims []*v1.Pod
+		found   bool
+	)
+	for _, p := range candidates {
+		evicted[p] = true
+		victims = append(victims, p)
+		if found = fits(); found {
+			break
+		}
+	}
+	if !found {
+		return nil, false
+	}
+
+	// Give back the victims which are not needed, the ones chosen last
+	// are the most valuable, so they are tried first
+	for i := len(victims) - 1; i >= 0; i-- {
+		evicted[victims[i]] = false
+		if fits() {
+			victims = append(victims[:i], victims[i+1:]...)
+		} else {
+			evicted[victims[i]] = true
+		}
+	}
+	return victims, true
+}
+
+func podPriority(pod *v1.Pod) int32 {
+	if pod.Spec.Priority != nil {
+		return *pod.Spec.Priority
+	}
+	return 0
+}
+
+// remainingTime returns the largest remaining time of the containers which
+// has GPU request of given pod in EstimatedTimeUnit. A pod whose remaining
+// time can't be told is taken as never ending.
+func remainingTime(pod *v1.Pod) uint {
+	var remaining uint
+	for i, c := range pod.Spec.Containers {
+		if !util.IsGPURequiredContainer(&c) {
+			continue
+		}
+		estimated, err := util.GetEstimatedTimeOfContainer(pod, i)
+		if err != nil {
+			return math.MaxUint32
+		}
+		running, _ := util.GetRunningTimeOfContainer(pod, i)
+		if left := subOrZero(estimated, running); left > remaining {
+			remaining = left
+		}
+	}
+	return remaining
+}
@@ -0,0 +1,106 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package algorithm
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/api/core/v1"
+
+	"tkestack.io/gpu-admission/pkg/config"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+// newRunningPod returns a pod of one container allocated on given device
+func newRunningPod(name string, priority int32, cores uint, device string, estimated string) *v1.Pod {
+	pod := newTestPod(name, testContainer{cores: cores, memory: 1})
+	pod.Spec.Priority = &priority
+	pod.Annotations[util.PredicateGPUIndexPrefix+"0"] = device
+	pod.Annotations[util.EstimatedTime+"0"] = estimated
+	return pod
+}
+
+func podNames(pods []*v1.Pod) []string {
+	var names []string
+	for _, p := range pods {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+func TestSelectVictims(t *testing.T) {
+	node := newTestNode("testnode", 2, 8)
+	short := newRunningPod("short", 0, 100, "0", "10")
+	long := newRunningPod("long", 0, 100, "1", "100")
+	shared := newRunningPod("shared", 0, 50, "0", "1")
+	important := newRunningPod("important", 10, 50, "0", "1")
+	exclusive := newRunningPod("exclusive", 1, 100, "1", "1")
+
+	testCases := []struct {
+		name     string
+		pods     []*v1.Pod
+		cores    uint
+		priority int32
+		victims  []string
+		ok       bool
+	}{
+		{"fits without eviction", []*v1.Pod{short}, 100, 5, nil, true},
+		{"least remaining time first", []*v1.Pod{long, short}, 100, 5, []string{"short"}, true},
+		{"evict both", []*v1.Pod{long, short}, 200, 5, []string{"short", "long"}, true},
+		{"no lower priority pods", []*v1.Pod{long, short}, 100, 0, nil, false},
+		// shared is chosen first for its priority, but evicting it alone is
+		// useless because of important, so it's given back
+		{"unneeded victims are given back", []*v1.Pod{shared, important, exclusive}, 100, 5,
+			[]string{"exclusive"}, true},
+		{"higher priority pods are kept", []*v1.Pod{important, exclusive}, 200, 5, nil, false},
+	}
+	for _, tc := range testCases {
+		pod := newTestPod("pod", testContainer{cores: tc.cores, memory: 1})
+		pod.Spec.Priority = &tc.priority
+		victims, ok := SelectVictims(node, tc.pods, pod, config.Default())
+		if ok != tc.ok || !reflect.DeepEqual(podNames(victims), tc.victims) {
+			t.Fatalf("%s: expect victims %v (%v), got %v (%v)",
+				tc.name, tc.victims, tc.ok, podNames(victims), ok)
+		}
+	}
+}
+
+func TestSelectVictimsPlanned(t *testing.T) {
+	node := newTestNode("testnode", 2, 8)
+	important := newRunningPod("important", 10, 100, "1", "1")
+	low := newRunningPod("low", 0, 100, "0", "1")
+
+	// both containers fit on GPU 0 once low is evicted, but anti-affinity
+	// wants another GPU for the second one
+	pod := newTestPod("pod", testContainer{cores: 40, memory: 1}, testContainer{cores: 40, memory: 1})
+	pod.Annotations[util.GPUAntiAffinityAnnotation] = "true"
+	priority := int32(5)
+	pod.Spec.Priority = &priority
+	if victims, ok := SelectVictims(node, []*v1.Pod{important, low}, pod, config.Default()); ok {
+		t.Fatalf("anti-affinity pod should never fit, got victims %v", podNames(victims))
+	}
+
+	// the node has the GPUs, but a pod may take one of them only
+	cfg := config.Default()
+	cfg.MaxDevicesPerPod = 1
+	pod = newTestPod("pod", testContainer{cores: 200, memory: 1})
+	pod.Spec.Priority = &priority
+	if victims, ok := SelectVictims(node, []*v1.Pod{low}, pod, cfg); ok {
+		t.Fatalf("pod beyond max devices per pod should never fit, got victims %v", podNames(victims))
+	}
+}
@@ -0,0 +1,253 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package algorithm
+
+import (
+	"fmt"
+	"sync"
+
+	"k8s.io/api/core/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+
+	"tkestack.io/gpu-admission/pkg/config"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+// QuotaTracker accounts the vcore and vmemory the pods of each namespace
+// use across the cluster, and caps them by the quotas of the namespaces.
+// A pod is charged once it's bound however many times it's allocated, and
+// until it's released, a pod yet to be bound is only checked against the
+// quota. It's safe for concurrent use.
+type QuotaTracker struct {
+	sync.Mutex
+	quotas map[string]config.Quota
+	// used are the resources charged for each namespace
+	used map[string]config.Quota
+	// pods are the resources charged for each pod
+	pods map[k8stypes.UID]podUsage
+}
+
+// podUsage is the resources charged for a pod
+type podUsage struct {
+	namespace string
+	usage     config.Quota
+}
+
+// NewQuotaTracker returns a QuotaTracker capping the namespaces by given
+// quotas
+func NewQuotaTracker(quotas map[string]config.Quota) *QuotaTracker {
+	return &QuotaTracker{
+		quotas: quotas,
+		used:   make(map[string]config.Quota),
+		pods:   make(map[k8stypes.UID]podUsage),
+	}
+}
+
+// SetQuotas replaces the quotas, the pods charged are kept even if their
+// namespace exceeds the new quota
+func (t *QuotaTracker) SetQuotas(quotas map[string]config.Quota) {
+	t.Lock()
+	defer t.Unlock()
+	t.quotas = quotas
+}
+
+// Charge charges the namespace of given pod for the GPU request of it, an
+// ErrQuotaExceeded is returned without charging if the namespace would use
+// more than its quota. Charging a pod again replaces its former usage.
+func (t *QuotaTracker) Charge(pod *v1.Pod) error {
+	return t.charge(pod, true)
+}
+
+// Check tells whether the namespace of given pod may be charged for it, an
+// ErrQuotaExceeded is returned if it would use more than its quota. Nothing
+// is charged.
+func (t *QuotaTracker) Check(pod *v1.Pod) error {
+	request, err := quotaUsageOf(pod)
+	if err != nil {
+		return &AllocationError{Reason: ErrInvalidRequest, Detail: err.Error()}
+	}
+
+	t.Lock()
+	defer t.Unlock()
+	return t.check(pod, request)
+}
+
+// check returns ErrQuotaExceeded if the namespace of given pod would use
+// more than its quota once charged for request, the former usage of the
+// pod is given back first. The caller must hold the lock.
+func (t *QuotaTracker) check(pod *v1.Pod, request config.Quota) error {
+	quota, ok := t.quotas[pod.Namespace]
+	if !ok || (request.Cores == 0 && request.Memory == 0) {
+		return nil
+	}
+	used := t.used[pod.Namespace]
+	if former, ok := t.pods[pod.UID]; ok && former.namespace == pod.Namespace {
+		used = subUsage(used, former.usage)
+	}
+	if quota.Cores > 0 && used.Cores+request.Cores > quota.Cores {
+		return &AllocationError{Reason: ErrQuotaExceeded,
+			Detail: quotaDetail(pod.Namespace, "vcore", used.Cores, quota.Cores, request.Cores)}
+	}
+	if quota.Memory > 0 && used.Memory+request.Memory > quota.Memory {
+		return &AllocationError{Reason: ErrQuotaExceeded,
+			Detail: quotaDetail(pod.Namespace, "vmemory", used.Memory, quota.Memory, request.Memory)}
+	}
+	return nil
+}
+
+// Track charges the namespace of given pod like Charge but regardless of
+// the quota, it's meant for the pods already placed
+func (t *QuotaTracker) Track(pod *v1.Pod) {
+	if err := t.charge(pod, false); err != nil {
+		klog.Warningf("failed to track GPU usage of pod %s: %v", pod.UID, err)
+	}
+}
+
+func (t *QuotaTracker) charge(pod *v1.Pod, enforce bool) error {
+	request, err := quotaUsageOf(pod)
+	if err != nil {
+		return &AllocationError{Reason: ErrInvalidRequest, Detail: err.Error()}
+	}
+	if request.Cores == 0 && request.Memory == 0 {
+		return nil
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	if enforce {
+		if err := t.check(pod, request); err != nil {
+			return err
+		}
+	}
+	t.release(pod.UID)
+	t.used[pod.Namespace] = config.Quota{
+		Cores:  t.used[pod.Namespace].Cores + request.Cores,
+		Memory: t.used[pod.Namespace].Memory + request.Memory,
+	}
+	t.pods[pod.UID] = podUsage{namespace: pod.Namespace, usage: request}
+	return nil
+}
+
+// Release gives back the resources charged for given pod to its namespace,
+// releasing a pod not charged does nothing
+func (t *QuotaTracker) Release(pod *v1.Pod) {
+	t.Lock()
+	defer t.Unlock()
+	t.release(pod.UID)
+}
+
+// release gives back the resources charged for the pod of given UID, the
+// caller must hold the lock
+func (t *QuotaTracker) release(uid k8stypes.UID) {
+	former, ok := t.pods[uid]
+	if !ok {
+		return
+	}
+	delete(t.pods, uid)
+	used := subUsage(t.used[former.namespace], former.usage)
+	if used.Cores == 0 && used.Memory == 0 {
+		delete(t.used, former.namespace)
+		return
+	}
+	t.used[former.namespace] = used
+}
+
+// Used returns the resources charged for given namespace
+func (t *QuotaTracker) Used(namespace string) config.Quota {
+	t.Lock()
+	defer t.Unlock()
+	return t.used[namespace]
+}
+
+// ExcessShare tells how far given namespace is beyond its fair share of
+// the vcore charged in the cluster, in [0, 1]. Under max-min fairness each
+// of the n namespaces charged, counting the given one, deserves 1/n:
+//
+//	(share - 1/n) / (1 - 1/n) if share > 1/n, otherwise 0
+//
+// where share is the part of the charged vcore held by the namespace.
+func (t *QuotaTracker) ExcessShare(namespace string) float64 {
+	t.Lock()
+	defer t.Unlock()
+
+	var total uint
+	for _, used := range t.used {
+		total += used.Cores
+	}
+	n := len(t.used)
+	if _, ok := t.used[namespace]; !ok {
+		n++
+	}
+	if total == 0 || n < 2 {
+		return 0
+	}
+	share := float64(t.used[namespace].Cores) / float64(total)
+	fair := 1 / float64(n)
+	if share <= fair {
+		return 0
+	}
+	return (share - fair) / (1 - fair)
+}
+
+// NamespaceShare tells the part of the vcore requested by given pods, e.g.
+// those on a node, held by the pods of given namespace, in [0, 1]
+func NamespaceShare(pods []*v1.Pod, namespace string) float64 {
+	var total, held uint
+	for _, pod := range pods {
+		usage, err := quotaUsageOf(pod)
+		if err != nil {
+			continue
+		}
+		total += usage.Cores
+		if pod.Namespace == namespace {
+			held += usage.Cores
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(held) / float64(total)
+}
+
+// quotaUsageOf returns the vcore and vmemory a pod counts against its
+// namespace quota, that is the total of its containers of all vendors
+func quotaUsageOf(pod *v1.Pod) (config.Quota, error) {
+	var usage config.Quota
+	for _, vendor := range util.Vendors() {
+		cores, err := util.GetGPUResourceOfPod(pod, vendor.VCore)
+		if err != nil {
+			return config.Quota{}, err
+		}
+		memory, err := util.GetGPUResourceOfPod(pod, vendor.VMemory)
+		if err != nil {
+			return config.Quota{}, err
+		}
+		usage.Cores += cores
+		usage.Memory += memory
+	}
+	return usage, nil
+}
+
+func subUsage(used, usage config.Quota) config.Quota {
+	return config.Quota{Cores: subOrZero(used.Cores, usage.Cores), Memory: subOrZero(used.Memory, usage.Memory)}
+}
+
+func quotaDetail(namespace, resource string, used, quota, request uint) string {
+	return fmt.Sprintf("namespace %s uses %d of %d %s, request %d", namespace, used, quota, resource, request)
+}
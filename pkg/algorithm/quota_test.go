@@ -0,0 +1,167 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package algorithm
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+
+	"k8s.io/api/core/v1"
+
+	"tkestack.io/gpu-admission/pkg/config"
+)
+
+func newQuotaTestPod(name string, cores uint) *v1.Pod {
+	pod := newTestPod(name, testContainer{cores: cores, memory: 1})
+	pod.Namespace = "team-a"
+	return pod
+}
+
+func TestQuotaExhaustion(t *testing.T) {
+	quota := NewQuotaTracker(map[string]config.Quota{"team-a": {Cores: 100}})
+	first, second := newQuotaTestPod("first", 60), newQuotaTestPod("second", 60)
+
+	// checking charges nothing
+	if err := quota.Check(first); err != nil {
+		t.Fatalf("failed to check the first pod: %v", err)
+	}
+	if err := quota.Check(second); err != nil {
+		t.Fatalf("failed to check the second pod: %v", err)
+	}
+	if used := quota.Used("team-a"); used.Cores != 0 {
+		t.Fatalf("expect nothing charged by the checks, got %+v", used)
+	}
+
+	// the first pod is charged once however many times it's charged
+	for i := 0; i < 2; i++ {
+		if err := quota.Charge(first); err != nil {
+			t.Fatalf("failed to charge the first pod: %v", err)
+		}
+	}
+	if used := quota.Used("team-a"); used.Cores != 60 || used.Memory != 1 {
+		t.Fatalf("expect 60 cores and 1 memory used, got %+v", used)
+	}
+	if err := quota.Check(first); err != nil {
+		t.Fatalf("expect the pod charged to pass the check, got %v", err)
+	}
+
+	for _, err := range []error{quota.Check(second), quota.Charge(second)} {
+		if !errors.Is(err, ErrQuotaExceeded) {
+			t.Fatalf("expect %v, got %v", ErrQuotaExceeded, err)
+		}
+		expect := "namespace GPU quota exceeded, namespace team-a uses 60 of 100 vcore, request 60"
+		if reason := FailureReason(err); reason != expect {
+			t.Fatalf("expect reason %q, got %q", expect, reason)
+		}
+	}
+
+	quota.Release(first)
+	if used := quota.Used("team-a"); used.Cores != 0 {
+		t.Fatalf("expect no cores used after release, got %d", used.Cores)
+	}
+	if err := quota.Charge(second); err != nil {
+		t.Fatalf("failed to charge the second pod after release: %v", err)
+	}
+	// releasing twice does nothing
+	quota.Release(first)
+	if used := quota.Used("team-a"); used.Cores != 60 {
+		t.Fatalf("expect 60 cores used, got %d", used.Cores)
+	}
+}
+
+func TestQuotaTrackerConcurrentCharge(t *testing.T) {
+	quota := NewQuotaTracker(map[string]config.Quota{"team-a": {Cores: 100}})
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		charged int
+	)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := quota.Charge(newQuotaTestPod(fmt.Sprintf("pod-%d", i), 10)); err == nil {
+				mu.Lock()
+				charged++
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	if used := quota.Used("team-a"); charged != 10 || used.Cores != 100 {
+		t.Fatalf("expect 10 pods charged for 100 cores, got %d pods for %d cores", charged, used.Cores)
+	}
+}
+
+func TestQuotaTrackerTrack(t *testing.T) {
+	quota := NewQuotaTracker(map[string]config.Quota{"team-a": {Cores: 50}})
+	// the pods already placed are accounted beyond the quota
+	quota.Track(newQuotaTestPod("placed", 80))
+	if used := quota.Used("team-a"); used.Cores != 80 {
+		t.Fatalf("expect 80 cores used, got %d", used.Cores)
+	}
+	if err := quota.Charge(newQuotaTestPod("new", 10)); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expect %v, got %v", ErrQuotaExceeded, err)
+	}
+	quota.SetQuotas(nil)
+	if err := quota.Charge(newQuotaTestPod("new", 10)); err != nil {
+		t.Fatalf("expect no quota, got %v", err)
+	}
+}
+
+func TestQuotaTrackerExcessShare(t *testing.T) {
+	quota := NewQuotaTracker(nil)
+	if share := quota.ExcessShare("team-a"); share != 0 {
+		t.Fatalf("expect no excess share of an empty cluster, got %v", share)
+	}
+	quota.Track(newQuotaTestPod("pod-a", 80))
+	// a namespace alone is not beyond its fair share
+	if share := quota.ExcessShare("team-a"); share != 0 {
+		t.Fatalf("expect no excess share of a single namespace, got %v", share)
+	}
+	// team-b counts as the second namespace, holding nothing
+	if share := quota.ExcessShare("team-b"); share != 0 {
+		t.Fatalf("expect no excess share of team-b, got %v", share)
+	}
+	pod := newQuotaTestPod("pod-b", 20)
+	pod.Namespace = "team-b"
+	quota.Track(pod)
+	if share := quota.ExcessShare("team-a"); math.Abs(share-0.6) > 1e-9 {
+		t.Fatalf("expect an excess share of 0.6, got %v", share)
+	}
+	if share := quota.ExcessShare("team-b"); share != 0 {
+		t.Fatalf("expect no excess share of team-b, got %v", share)
+	}
+}
+
+func TestNamespaceShare(t *testing.T) {
+	pod := newQuotaTestPod("pod-b", 20)
+	pod.Namespace = "team-b"
+	pods := []*v1.Pod{newQuotaTestPod("pod-a", 60), pod}
+	if share := NamespaceShare(pods, "team-a"); math.Abs(share-0.75) > 1e-9 {
+		t.Fatalf("expect a share of 0.75, got %v", share)
+	}
+	if share := NamespaceShare(pods, "team-c"); share != 0 {
+		t.Fatalf("expect no share of team-c, got %v", share)
+	}
+	if share := NamespaceShare(nil, "team-a"); share != 0 {
+		t.Fatalf("expect no share of an empty node, got %v", share)
+	}
+}
@@ -0,0 +1,170 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package algorithm
+
+import (
+	"context"
+	"errors"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+
+	"tkestack.io/gpu-admission/pkg/device"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+// The constraints a pod may ask to drop by the GPUSoftConstraints
+// annotation when it doesn't fit otherwise
+const (
+	// ConstraintBuffer is the free memory a shared device keeps, see
+	// device.NodeInfo.MinFreeMemoryPerDevice
+	ConstraintBuffer = "buffer"
+	// ConstraintTopology is the devices the pod is pinned to
+	ConstraintTopology = "topology"
+	// ConstraintModel is the GPU model the pod requests
+	ConstraintModel = "model"
+)
+
+// relaxation is a soft constraint which can be dropped, applies tells if
+// the constraint takes effect for given pod at all
+type relaxation struct {
+	name    string
+	applies func(alloc *allocator, pod *v1.Pod) bool
+}
+
+// relaxations are the soft constraints in the order they are dropped, the
+// ones costing the pod the least go first, and each of them stays dropped
+// for the later ones
+var relaxations = []relaxation{
+	{
+		name: ConstraintBuffer,
+		applies: func(alloc *allocator, pod *v1.Pod) bool {
+			return alloc.nodeInfo.MinFreeMemoryPerDevice() > 0
+		},
+	},
+	{
+		name: ConstraintTopology,
+		applies: func(alloc *allocator, pod *v1.Pod) bool {
+			pinned, _ := util.GetPinnedDevicesOfPod(pod)
+			return pinned != nil
+		},
+	},
+	{
+		name: ConstraintModel,
+		applies: func(alloc *allocator, pod *v1.Pod) bool {
+			return util.GetGPUModelOfPod(pod) != ""
+		},
+	},
+}
+
+// allocateRelaxed is allocateOne, but if the container doesn't fit, the
+// soft constraints of the pod are dropped one by one in the order of
+// relaxations and the allocation is retried, until it fits or all of them
+// are dropped. It returns the constraints dropped, and the error of the
+// strict allocation if the container never fits.
+//
+// Only a container which doesn't fit is retried, an invalid request or a
+// done ctx fails at once.
+func (alloc *allocator) allocateRelaxed(ctx context.Context, pod *v1.Pod, containerIndex int,
+	container *v1.Container, extra ...DeviceFilter) ([]*device.DeviceInfo, []string, error) {
+	soft := make(map[string]bool)
+	for _, name := range util.GetSoftConstraintsOfPod(pod) {
+		if !isRelaxation(name) {
+			return nil, nil, alloc.newAllocationError(container.Name, ErrInvalidRequest,
+				"unknown soft constraint %s", name)
+		}
+		soft[name] = true
+	}
+
+	devs, err := alloc.allocateOne(ctx, pod, containerIndex, container, extra...)
+	if err == nil || len(soft) == 0 || !isUnfit(err) {
+		return devs, nil, err
+	}
+
+	defer func() { alloc.relaxed = nil }()
+	for _, r := range relaxations {
+		if !soft[r.name] || !r.applies(alloc, pod) {
+			continue
+		}
+		alloc.relaxed = append(alloc.relaxed, r.name)
+		relaxedDevs, relaxedErr := alloc.allocateOne(ctx, pod, containerIndex, container, extra...)
+		if relaxedErr == nil {
+			klog.V(4).Infof("pod %s(%s) fits on node %s without %v", pod.Name, container.Name,
+				alloc.nodeInfo.GetName(), alloc.relaxed)
+			return relaxedDevs, append([]string(nil), alloc.relaxed...), nil
+		}
+		if !isUnfit(relaxedErr) {
+			return nil, nil, relaxedErr
+		}
+	}
+	return nil, nil, err
+}
+
+// isRelaxed tells if given constraint is dropped for the container being
+// allocated
+func (alloc *allocator) isRelaxed(name string) bool {
+	return containsString(alloc.relaxed, name)
+}
+
+// keepFree returns the memory a shared device keeps free after the
+// placement of the container being allocated
+func (alloc *allocator) keepFree() uint {
+	if alloc.isRelaxed(ConstraintBuffer) {
+		return 0
+	}
+	return alloc.nodeInfo.MinFreeMemoryPerDevice()
+}
+
+func isRelaxation(name string) bool {
+	for _, r := range relaxations {
+		if r.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// isUnfit tells if the allocation failed because the node can't satisfy
+// the request, rather than the request being invalid
+func isUnfit(err error) bool {
+	var allocErr *AllocationError
+	return errors.As(err, &allocErr) && !errors.Is(err, ErrInvalidRequest)
+}
+
+// relaxedConstraints returns the distinct constraints dropped for the
+// containers, in the order of relaxations
+func relaxedConstraints(placements []ContainerPlacement) []string {
+	var ret []string
+	for _, r := range relaxations {
+		for _, placement := range placements {
+			if containsString(placement.Relaxed, r.name) {
+				ret = append(ret, r.name)
+				break
+			}
+		}
+	}
+	return ret
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
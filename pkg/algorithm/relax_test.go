@@ -0,0 +1,90 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package algorithm
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"tkestack.io/gpu-admission/pkg/config"
+	"tkestack.io/gpu-admission/pkg/device"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+func TestAllocateSoftConstraints(t *testing.T) {
+	testCases := []struct {
+		name     string
+		soft     string
+		model    string
+		pin      string
+		memory   uint
+		keepFree uint
+		relaxed  string
+		err      error
+	}{
+		{name: "hard model", model: "a10", memory: 1, err: ErrInsufficientCores},
+		{name: "soft model", soft: "model", model: "a10", memory: 1, relaxed: "model"},
+		{name: "soft model fits", soft: "model", model: "t4", memory: 1},
+		{name: "soft buffer", soft: "buffer", memory: 7, keepFree: 2, relaxed: "buffer"},
+		{name: "soft topology", soft: "topology", pin: "2", memory: 1, relaxed: "topology"},
+		{name: "all dropped in order", soft: "Model, buffer", model: "a10", memory: 7, keepFree: 2,
+			relaxed: "buffer,model"},
+		{name: "hard buffer with soft model", soft: "model", model: "a10", memory: 7, keepFree: 2,
+			err: ErrExceedsCapacity},
+		{name: "unknown soft constraint", soft: "numa", memory: 1, err: ErrInvalidRequest},
+	}
+
+	for _, cs := range testCases {
+		node := newTestNode("testnode", 3, 24)
+		node.Annotations[util.GPUModels] = "t4,t4,a10"
+		cfg := config.Default()
+		cfg.MinFreeMemoryPerDevice = cs.keepFree
+		nodeInfo := device.NewNodeInfoWithConfig(node, nil, cfg)
+		// the only a10 is busy
+		if err := nodeInfo.AddUsedResources(2, 100, 0, 0); err != nil {
+			t.Fatalf("%s: failed to add used resources: %v", cs.name, err)
+		}
+		pod := newTestPod("pod", testContainer{cores: 10, memory: cs.memory})
+		for k, v := range map[string]string{
+			util.GPUSoftConstraintsAnnotation: cs.soft,
+			util.GPUModelAnnotation:           cs.model,
+			util.GPUPinDevicesAnnotation:      cs.pin,
+		} {
+			if v != "" {
+				pod.Annotations[k] = v
+			}
+		}
+
+		newPod, err := NewAllocator(nodeInfo).Allocate(context.Background(), pod)
+		if cs.err != nil {
+			if !errors.Is(err, cs.err) {
+				t.Fatalf("%s: expect %v, got %v", cs.name, cs.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: allocation failed: %v", cs.name, err)
+		}
+		if got := newPod.Annotations[util.PredicateRelaxedConstraints]; got != cs.relaxed {
+			t.Fatalf("%s: expect relaxed %q, got %q", cs.name, cs.relaxed, got)
+		}
+		if got := newPod.Annotations[util.PredicateGPUIndexPrefix+"0"]; got == "2" {
+			t.Fatalf("%s: expect a free device, got %s", cs.name, got)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package algorithm
+
+import (
+	"context"
+	"math"
+
+	"k8s.io/api/core/v1"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+
+	"tkestack.io/gpu-admission/pkg/config"
+	"tkestack.io/gpu-admission/pkg/device"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+// Score returns the score in [0, MaxExtenderPriority] of the node for given
+// pod under given node policy. With the pack policy the node with the least
+// free cores scores the highest, with the spread policy the node with the
+// most free cores does. A node which can't hold the pod scores 0. The
+// node's used resources are not changed.
+//
+// With a positive FragmentationWeight of the node, the score is blended
+// with how little the node is fragmented once the pod is placed, see
+// FragmentationScore:
+//
+//	(1 - weight) * policy score + weight * (1 - fragmentation after placement)
+func (alloc *allocator) Score(pod *v1.Pod, policy string) int64 {
+	schedulable := alloc.nodeInfo.GetSchedulableCore()
+	if schedulable <= 0 {
+		return 0
+	}
+	alloc.nodeInfo.Lock()
+	placed := alloc.nodeInfo.Clone()
+	alloc.nodeInfo.Unlock()
+	// A pod predicated to this node is already charged on it
+	if pod.Annotations[util.PredicateNode] != alloc.nodeInfo.GetName() {
+		if _, err := NewAllocator(placed).Place(context.Background(), pod); err != nil {
+			return 0
+		}
+	}
+
+	alloc.nodeInfo.Lock()
+	free := float64(alloc.nodeInfo.FreeCores()) / float64(schedulable)
+	weight := alloc.nodeInfo.FragmentationWeight()
+	alloc.nodeInfo.Unlock()
+	if policy != config.SpreadPolicy {
+		free = 1 - free
+	}
+	score := free
+	if weight > 0 {
+		score = (1-weight)*free + weight*(1-FragmentationScore(placed))
+	}
+	return int64(math.Round(score * float64(extenderv1.MaxExtenderPriority)))
+}
+
+// FragmentationScore returns how fragmented the GPUs of given node are, in
+// [0, 1], that is the part of the free cores of the node which are on
+// partly used GPUs:
+//
+//	sum of the allocatable cores of the partly used GPUs / FreeCores
+//
+// Only the schedulable GPUs not MIG enabled count, see
+// device.NodeInfo.FreeCores, and a GPU is partly used if some of its
+// schedulable cores are used. Those cores can't be given to an exclusive
+// container, so the lower the better. A node without free cores is not
+// fragmented. The node is not locked.
+func FragmentationScore(n *device.NodeInfo) float64 {
+	free := n.FreeCores()
+	if free <= 0 {
+		return 0
+	}
+	var fragmented uint
+	for _, dev := range n.SchedulableDevices() {
+		if !dev.IsMIGEnabled() && !isFree(dev) {
+			fragmented += dev.AllocatableCores()
+		}
+	}
+	return float64(fragmented) / float64(free)
+}
@@ -0,0 +1,84 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package algorithm
+
+import (
+	"testing"
+
+	"tkestack.io/gpu-admission/pkg/config"
+	"tkestack.io/gpu-admission/pkg/device"
+)
+
+func TestScoreFragmentationWeight(t *testing.T) {
+	testCases := []struct {
+		name   string
+		weight float64
+		cores  uint
+		expect int64
+	}{
+		{"no weight", 0, 50, 8},
+		{"pod fills the partly used GPU", 0.5, 50, 9},
+		{"pod leaves the GPU partly used", 0.5, 30, 4},
+		{"only fragmentation", 1, 30, 0},
+	}
+	for _, tc := range testCases {
+		cfg := config.Default()
+		cfg.FragmentationWeight = tc.weight
+		nodeInfo := device.NewNodeInfoWithConfig(newTestNode("testnode", 2, 8), nil, cfg)
+		// the pod can only go to GPU 0
+		for i, cores := range []uint{50, 100} {
+			if err := nodeInfo.AddUsedResources(i, cores, 1, 0); err != nil {
+				t.Fatalf("failed to add used resources: %v", err)
+			}
+		}
+		pod := newTestPod("pod", testContainer{cores: tc.cores, memory: 1})
+		if score := NewAllocator(nodeInfo).Score(pod, config.PackPolicy); score != tc.expect {
+			t.Fatalf("%s: expect score %d, got %d", tc.name, tc.expect, score)
+		}
+		if free := nodeInfo.FreeCores(); free != 50 {
+			t.Fatalf("%s: expect the node not to be charged, got %d free cores", tc.name, free)
+		}
+	}
+}
+
+func TestFragmentationScore(t *testing.T) {
+	testCases := []struct {
+		name      string
+		usedCores []uint
+		expect    float64
+	}{
+		{"idle node", []uint{0, 0}, 0},
+		{"one GPU partly used", []uint{50, 0}, 50.0 / 150},
+		{"one GPU fully used", []uint{100, 0}, 0},
+		{"all GPUs partly used", []uint{50, 25}, 1},
+		{"no free cores", []uint{100, 100}, 0},
+	}
+	for _, tc := range testCases {
+		nodeInfo := device.NewNodeInfo(newTestNode("testnode", 2, 8), nil)
+		for i, cores := range tc.usedCores {
+			if cores == 0 {
+				continue
+			}
+			if err := nodeInfo.AddUsedResources(i, cores, 1, 0); err != nil {
+				t.Fatalf("failed to add used resources: %v", err)
+			}
+		}
+		if score := FragmentationScore(nodeInfo); score != tc.expect {
+			t.Fatalf("%s: expect fragmentation %v, got %v", tc.name, tc.expect, score)
+		}
+	}
+}
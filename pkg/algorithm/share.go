@@ -17,16 +17,70 @@
 package algorithm
 
 import (
+	"fmt"
 	"sort"
 	"math"
+	"sync"
+	"time"
 
 	"k8s.io/klog"
 
 	"tkestack.io/gpu-admission/pkg/device"
+	"tkestack.io/gpu-admission/pkg/util"
 )
 
+// shareScratch is the working memory of a share mode evaluation. Evaluate
+// runs for each shared container on each node the scheduler asks about, so
+// the candidates and the decision matrix are pooled instead of allocated
+// each time. They are only read while the devices are scored.
+type shareScratch struct {
+	devices    []*device.DeviceInfo
+	candidates []*device.DeviceInfo
+	cells      []float64
+	rows       [][]float64
+}
+
+var shareScratchPool = sync.Pool{New: func() interface{} { return new(shareScratch) }}
+
+// matrix returns a matrix of given rows, each of them empty with a capacity
+// of cols
+func (s *shareScratch) matrix(rows, cols int) [][]float64 {
+	if cap(s.cells) < rows*cols {
+		s.cells = make([]float64, rows*cols)
+	}
+	if cap(s.rows) < rows {
+		s.rows = make([][]float64, rows)
+	}
+	s.rows = s.rows[:rows]
+	for i := range s.rows {
+		s.rows[i] = s.cells[i*cols : i*cols : (i+1)*cols]
+	}
+	return s.rows
+}
+
+// release returns the scratch to the pool, the devices are dropped to not
+// retain them
+func (s *shareScratch) release() {
+	for i := range s.devices {
+		s.devices[i] = nil
+	}
+	for i := range s.candidates {
+		s.candidates[i] = nil
+	}
+	s.devices, s.candidates = s.devices[:0], s.candidates[:0]
+	shareScratchPool.Put(s)
+}
+
 type shareMode struct {
-	node *device.NodeInfo
+	node    *device.NodeInfo
+	filters []DeviceFilter
+	owner   string
+	pack    bool
+	// keepFree is the memory a candidate keeps free after the placement
+	keepFree uint
+	// timer records the time of the stages of Evaluate, nil records
+	// nothing
+	timer *stageTimer
 }
 
 //NewShareMode returns a new shareMode struct.
@@ -36,40 +90,159 @@ type shareMode struct {
 //
 //Share mode means multiple application may share one GPU device which uses
 //GPU more efficiently.
-func NewShareMode(n *device.NodeInfo) *shareMode {
-	return &shareMode{n}
+//
+//Only the schedulable devices accepted by all of the filters and having
+//enough cores and memory are candidates, the memory left after the
+//placement must be at least MinFreeMemoryPerDevice of the node. A device
+//already shared by the max number of containers of the node is not a
+//candidate either.
+func NewShareMode(n *device.NodeInfo, filters ...DeviceFilter) *shareMode {
+	return &shareMode{node: n, filters: filters, keepFree: n.MinFreeMemoryPerDevice()}
+}
+
+// KeepFree overrides the memory a candidate keeps free after the
+// placement, which is MinFreeMemoryPerDevice of the node by default
+func (al *shareMode) KeepFree(memory uint) *shareMode {
+	al.keepFree = memory
+	return al
+}
+
+// Packed makes the busier devices preferred, that is the allocatable cores
+// and memory are costs instead of benefits, see
+// device.NodeInfo.IsLowPriority
+func (al *shareMode) Packed(pack bool) *shareMode {
+	al.pack = pack
+	return al
+}
+
+// ForOwner sets the workload owner of the container to place, a device
+// already running the same workload adds no distinct owner, see
+// device.NodeInfo.DistinctOwnersWeight
+func (al *shareMode) ForOwner(owner string) *shareMode {
+	al.owner = owner
+	return al
+}
+
+// timed makes Evaluate record the time of its stages to given timer
+func (al *shareMode) timed(timer *stageTimer) *shareMode {
+	al.timer = timer
+	return al
 }
 
-func (al *shareMode) Evaluate(cores uint, memory uint, estimatedTime uint) []*device.DeviceInfo {
+// neutralizeIdle gives the idle rows the mean of the other rows in column
+// c, so an idle device is neither preferred nor avoided by the criterion.
+// The column is the same for all rows if all of them are idle.
+func neutralizeIdle(matrix [][]float64, c int, idle []int) {
+	if len(idle) == 0 {
+		return
+	}
+	var sum float64
+	for _, row := range matrix {
+		sum += row[c]
+	}
+	mean := 0.0
+	if busy := len(matrix) - len(idle); busy > 0 {
+		// the idle rows are 0
+		mean = sum / float64(busy)
+	}
+	for _, i := range idle {
+		matrix[i][c] = mean
+	}
+}
+
+func (al *shareMode) Evaluate(req Request) ([]*device.DeviceInfo, error) {
+	if req.Cores >= util.HundredCore {
+		return nil, fmt.Errorf("share mode takes less than %d cores, request %d", util.HundredCore, req.Cores)
+	}
 	var (
-		devs        []*device.DeviceInfo
-		deviceCount = al.node.GetDeviceCount()
-		tmpStore    = make([]*device.DeviceInfo, deviceCount)
-		sorter      = shareModeSort(device.ByAllocatableCores, device.ByAllocatableMemory, device.ByID)
+		cores, memory, estimatedTime = req.Cores, req.Memory, req.EstimatedTime
+		devs          []*device.DeviceInfo
+		scratch       = shareScratchPool.Get().(*shareScratch)
+		tmpStore      = scratch.candidates[:0]
+		sorter        = shareModeSort(al.node.ShareSortOrder()...)
+		ownersWeight  = al.node.DistinctOwnersWeight()
+		overlapWeight = al.node.TimeOverlapWeight()
+		similarWeight = al.node.TimeSimilarityWeight()
+		keepFree      = al.keepFree
 	)
+	defer scratch.release()
 
-	for i := 0; i < deviceCount; i++ {
-		tmpStore[i] = al.node.GetDeviceMap()[i]
+	start := time.Now()
+	scratch.devices = al.node.AppendSchedulableDevices(scratch.devices[:0])
+	for _, dev := range scratch.devices {
+		if !isCandidate(dev, al.filters) {
+			continue
+		}
+		if dev.AllocatableCores() < cores || dev.AllocatableMemory() < dev.AlignMemory(memory)+keepFree {
+			continue
+		}
+		if atContainerLimit(al.node, dev) {
+			continue
+		}
+		tmpStore = append(tmpStore, dev)
+	}
+	scratch.candidates = tmpStore
+
+	if len(tmpStore) == 0 {
+		al.timer.observe(stageFilter, start)
+		return nil, nil
 	}
 
-	
 	sorter.Sort(tmpStore)
+	al.timer.observe(stageFilter, start)
+	start = time.Now()
 
 	//此处实现TOPSIS算法
-	var decisionMatrix [][]float64
+	criteria := 4
+	if ownersWeight > 0 {
+		criteria++
+	}
+	if overlapWeight > 0 {
+		criteria++
+	}
+	if similarWeight > 0 {
+		criteria++
+	}
+	decisionMatrix := scratch.matrix(len(tmpStore), criteria)
+	// the rows of the devices running no container to compare the
+	// estimated time with
+	var idle []int
 
 	//构造决策矩阵
-	for _, dev := range tmpStore {
-		var nodeMatrix []float64
+	for i, dev := range tmpStore {
+		nodeMatrix := decisionMatrix[i]
 		nodeMatrix = append(nodeMatrix, float64(dev.AllocatableCores()))
 		nodeMatrix = append(nodeMatrix, float64(dev.AllocatableMemory()))
+		// the time criterion is the part of the estimated time beyond the
+		// isolated time of the device, both in util.EstimatedTimeUnit
 		itime := int(estimatedTime) - int(dev.IsolatedTime())
 		if itime < 0 {
 			itime = 0
 		}
 		nodeMatrix = append(nodeMatrix, float64(itime))
 		nodeMatrix = append(nodeMatrix, float64(dev.NumberofContainer()))
-		decisionMatrix = append(decisionMatrix, nodeMatrix)
+		if ownersWeight > 0 {
+			// the distinct owners after placing the container
+			owners := dev.DistinctOwners()
+			if !dev.HasOwner(al.owner) {
+				owners++
+			}
+			nodeMatrix = append(nodeMatrix, float64(owners))
+		}
+		if overlapWeight > 0 {
+			nodeMatrix = append(nodeMatrix, float64(dev.TimeOverlap(estimatedTime)))
+		}
+		if similarWeight > 0 {
+			distance, ok := dev.TimeDistance(estimatedTime)
+			if !ok {
+				idle = append(idle, i)
+			}
+			nodeMatrix = append(nodeMatrix, float64(distance))
+		}
+		decisionMatrix[i] = nodeMatrix
+	}
+	if similarWeight > 0 {
+		neutralizeIdle(decisionMatrix, criteria-1, idle)
 	}
 
 	row := len(decisionMatrix)
@@ -85,7 +258,22 @@ func (al *shareMode) Evaluate(cores uint, memory uint, estimatedTime uint) []*de
 		tmp1 = append(tmp1, math.Sqrt(sum))
 	}
 
-	weight := []float64{0.3, 0.3, 0.2, 0.2}
+	al.timer.observe(stageMatrix, start)
+	start = time.Now()
+
+	weight := al.node.ShareWeights()
+	if ownersWeight > 0 {
+		weight = append(append([]float64(nil), weight...), ownersWeight)
+	}
+	if overlapWeight > 0 {
+		weight = append(append([]float64(nil), weight...), overlapWeight)
+	}
+	if similarWeight > 0 {
+		weight = append(append([]float64(nil), weight...), similarWeight)
+	}
+	// the number of containers, of distinct owners, the time overlap and
+	// the time distance are costs
+	weight = criteriaWeights(al.node.ShareWeighting(), decisionMatrix, 3, weight)
 
 	for i := 0; i < col; i++ {
 		for j := 0; j < row; j++ {
@@ -97,8 +285,8 @@ func (al *shareMode) Evaluate(cores uint, memory uint, estimatedTime uint) []*de
 		}
 	}
 
-	Amax := []float64{decisionMatrix[0][0], decisionMatrix[0][1], decisionMatrix[0][2], decisionMatrix[0][3]}
-	Amin := []float64{decisionMatrix[0][0], decisionMatrix[0][1], decisionMatrix[0][2], decisionMatrix[0][3]}
+	Amax := append([]float64(nil), decisionMatrix[0]...)
+	Amin := append([]float64(nil), decisionMatrix[0]...)
 
 
 	for i := 0; i < row; i++ {
@@ -128,14 +316,24 @@ func (al *shareMode) Evaluate(cores uint, memory uint, estimatedTime uint) []*de
 		}
  	}
 
-	for i := 0; i < row; i++ {
-		if Amax[3] > decisionMatrix[i][3] {
-			Amax[3] = decisionMatrix[i][3]
-		}
-		if Amin[3] < decisionMatrix[i][3] {
-			Amin[3] = decisionMatrix[i][3]
+	// a packed container prefers less allocatable cores and memory
+	if al.pack {
+		Amax[0], Amin[0] = Amin[0], Amax[0]
+		Amax[1], Amin[1] = Amin[1], Amax[1]
+	}
+
+	// the number of containers, of distinct owners, the time overlap and
+	// the time distance are costs
+	for c := 3; c < col; c++ {
+		for i := 0; i < row; i++ {
+			if Amax[c] > decisionMatrix[i][c] {
+				Amax[c] = decisionMatrix[i][c]
+			}
+			if Amin[c] < decisionMatrix[i][c] {
+				Amin[c] = decisionMatrix[i][c]
+			}
 		}
- 	}
+	}
 
 	var SMmax, SMmin []float64
 	for i := 0; i < row; i++ {
@@ -172,9 +370,10 @@ func (al *shareMode) Evaluate(cores uint, memory uint, estimatedTime uint) []*de
 
 	}
 	devs = append(devs, maxdev)
+	al.timer.observe(stageScore, start)
 	klog.V(4).Infof("Pick up %d , cores: %d, memory: %d",
 				maxdev.GetID(), maxdev.AllocatableCores(), maxdev.AllocatableMemory())
-	return devs
+	return devs, nil
 }
 
 type shareModePriority struct {
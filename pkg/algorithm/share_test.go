@@ -0,0 +1,390 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package algorithm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+
+	"tkestack.io/gpu-admission/pkg/config"
+	"tkestack.io/gpu-admission/pkg/device"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+func newOwnedTestPod(name, owner string, dev int) *v1.Pod {
+	controller := true
+	pod := newTestPod(name, testContainer{cores: 20, memory: 2})
+	pod.OwnerReferences = []metav1.OwnerReference{
+		{Kind: "Job", Name: owner, UID: k8stypes.UID(owner), Controller: &controller},
+	}
+	if dev >= 0 {
+		pod.Annotations[util.PredicateGPUIndexPrefix+"0"] = strconv.Itoa(dev)
+	}
+	return pod
+}
+
+func TestShareModeDistinctOwners(t *testing.T) {
+	// device 0 runs two different jobs, device 1 two replicas of a job,
+	// they are the same otherwise
+	pods := []*v1.Pod{
+		newOwnedTestPod("a", "job-a", 0),
+		newOwnedTestPod("b", "job-b", 0),
+		newOwnedTestPod("c-0", "job-c", 1),
+		newOwnedTestPod("c-1", "job-c", 1),
+	}
+	node := newTestNode("testnode", 2, 16)
+
+	for _, cs := range []struct {
+		weight float64
+		expect string
+	}{
+		// ties are broken by device idx
+		{weight: 0, expect: "0"},
+		{weight: 0.2, expect: "1"},
+	} {
+		cfg := config.Default()
+		cfg.DistinctOwnersWeight = cs.weight
+		nodeInfo := device.NewNodeInfoWithConfig(node, pods, cfg)
+		if got := nodeInfo.GetDeviceMap()[0].DistinctOwners(); got != 2 {
+			t.Fatalf("expect 2 owners on device 0, got %d", got)
+		}
+		if got := nodeInfo.GetDeviceMap()[1].DistinctOwners(); got != 1 {
+			t.Fatalf("expect 1 owner on device 1, got %d", got)
+		}
+
+		newPod, err := NewAllocator(nodeInfo).Allocate(context.Background(), newOwnedTestPod("d", "job-d", -1))
+		if err != nil {
+			t.Fatalf("weight %v: failed to allocate: %v", cs.weight, err)
+		}
+		if got := newPod.Annotations[util.PredicateGPUIndexPrefix+"0"]; got != cs.expect {
+			t.Fatalf("weight %v: expect device %s, got %s", cs.weight, cs.expect, got)
+		}
+		if !nodeInfo.GetDeviceMap()[1].HasOwner("job-d") && cs.expect == "1" {
+			t.Fatalf("weight %v: expect the owner recorded on device 1", cs.weight)
+		}
+	}
+}
+
+func TestShareModeTimeOverlap(t *testing.T) {
+	for _, cs := range []struct {
+		weight float64
+		expect int
+	}{
+		// device 0 has more free cores
+		{weight: 0, expect: 0},
+		// device 1 finishes its job soon
+		{weight: 0.5, expect: 1},
+	} {
+		cfg := config.Default()
+		cfg.TimeOverlapWeight = cs.weight
+		nodeInfo := device.NewNodeInfoWithConfig(newTestNode("testnode", 2, 16), nil, cfg)
+		if err := nodeInfo.AddUsedResources(0, 10, 1, 10); err != nil {
+			t.Fatalf("failed to add used resources: %v", err)
+		}
+		if err := nodeInfo.AddUsedResources(1, 60, 6, 1); err != nil {
+			t.Fatalf("failed to add used resources: %v", err)
+		}
+		if got := nodeInfo.GetDeviceMap()[0].TimeOverlap(8); got != 8 {
+			t.Fatalf("expect an overlap of 8 on device 0, got %d", got)
+		}
+
+		devs := mustEvaluate(t, NewShareMode(nodeInfo), Request{Cores: 20, Memory: 1, EstimatedTime: 8})
+		if len(devs) != 1 || devs[0].GetID() != cs.expect {
+			t.Fatalf("weight %v: expect device %d, got %v", cs.weight, cs.expect, deviceIDs(devs))
+		}
+	}
+}
+
+func TestShareModeTimeSimilarity(t *testing.T) {
+	const tenMinutes, threeHours = 600, 3 * 3600
+	for _, cs := range []struct {
+		weight float64
+		expect int
+	}{
+		// device 1 has more free cores
+		{weight: 0, expect: 1},
+		// device 0 runs jobs as short as the new one
+		{weight: 0.5, expect: 0},
+	} {
+		cfg := config.Default()
+		cfg.TimeSimilarityWeight = cs.weight
+		nodeInfo := device.NewNodeInfoWithConfig(newTestNode("testnode", 2, 16), nil, cfg)
+		for _, job := range []struct {
+			dev   int
+			cores uint
+			time  int
+		}{
+			{dev: 0, cores: 30, time: tenMinutes},
+			{dev: 0, cores: 30, time: tenMinutes},
+			{dev: 1, cores: 20, time: threeHours},
+			{dev: 1, cores: 20, time: threeHours},
+		} {
+			if err := nodeInfo.AddUsedResources(job.dev, job.cores, 1, job.time); err != nil {
+				t.Fatalf("failed to add used resources: %v", err)
+			}
+		}
+		if got, _ := nodeInfo.GetDeviceMap()[1].TimeDistance(tenMinutes); got != threeHours-tenMinutes {
+			t.Fatalf("expect a distance of %d on device 1, got %d", threeHours-tenMinutes, got)
+		}
+
+		devs := mustEvaluate(t, NewShareMode(nodeInfo), Request{Cores: 20, Memory: 1, EstimatedTime: tenMinutes})
+		if len(devs) != 1 || devs[0].GetID() != cs.expect {
+			t.Fatalf("weight %v: expect device %d, got %v", cs.weight, cs.expect, deviceIDs(devs))
+		}
+	}
+}
+
+func TestShareModeTimeSimilarityIdle(t *testing.T) {
+	const tenMinutes, twentyMinutes, threeHours = 600, 1200, 3 * 3600
+	cfg := config.Default()
+	cfg.TimeSimilarityWeight = 5
+	nodeInfo := device.NewNodeInfoWithConfig(newTestNode("testnode", 3, 24), nil, cfg)
+	for _, job := range []struct {
+		dev  int
+		time int
+	}{
+		{dev: 0, time: twentyMinutes},
+		{dev: 0, time: twentyMinutes},
+		{dev: 1, time: threeHours},
+		{dev: 1, time: threeHours},
+		// no estimated time, nothing to compare with
+		{dev: 2},
+		{dev: 2},
+	} {
+		if err := nodeInfo.AddUsedResources(job.dev, 30, 1, job.time); err != nil {
+			t.Fatalf("failed to add used resources: %v", err)
+		}
+	}
+	if _, ok := nodeInfo.GetDeviceMap()[2].TimeDistance(tenMinutes); ok {
+		t.Fatalf("expect device 2 idle")
+	}
+
+	// the idle device is as far as the average of the others, so the
+	// device running the jobs closest to the new one is preferred
+	devs := mustEvaluate(t, NewShareMode(nodeInfo), Request{Cores: 20, Memory: 1, EstimatedTime: tenMinutes})
+	if len(devs) != 1 || devs[0].GetID() != 0 {
+		t.Fatalf("expect device 0, got %v", deviceIDs(devs))
+	}
+
+	matrix := [][]float64{{1, 0}, {2, 10}, {3, 0}}
+	neutralizeIdle(matrix, 1, []int{2})
+	if fmt.Sprint(matrix) != "[[1 0] [2 10] [3 5]]" {
+		t.Fatalf("expect the idle row given the mean, got %v", matrix)
+	}
+	matrix = [][]float64{{1, 0}, {2, 0}}
+	neutralizeIdle(matrix, 1, []int{0, 1})
+	if fmt.Sprint(matrix) != "[[1 0] [2 0]]" {
+		t.Fatalf("expect the idle rows alike, got %v", matrix)
+	}
+}
+
+func TestShareModeMinFreeMemory(t *testing.T) {
+	for _, cs := range []struct {
+		keepFree uint
+		expect   string
+		err      error
+	}{
+		// device 0 has more free cores
+		{keepFree: 0, expect: "0"},
+		// device 0 would be left with 1
+		{keepFree: 2, expect: "1"},
+		// neither device keeps 5 free
+		{keepFree: 5, err: ErrInsufficientMemory},
+		// no device keeps 7 free after any placement
+		{keepFree: 7, err: ErrExceedsCapacity},
+	} {
+		cfg := config.Default()
+		cfg.MinFreeMemoryPerDevice = cs.keepFree
+		nodeInfo := device.NewNodeInfoWithConfig(newTestNode("testnode", 2, 16), nil, cfg)
+		if err := nodeInfo.AddUsedResources(0, 10, 5, 0); err != nil {
+			t.Fatalf("failed to add used resources: %v", err)
+		}
+		if err := nodeInfo.AddUsedResources(1, 60, 2, 0); err != nil {
+			t.Fatalf("failed to add used resources: %v", err)
+		}
+
+		newPod, err := NewAllocator(nodeInfo).Allocate(context.Background(),
+			newTestPod("pod", testContainer{cores: 20, memory: 2}))
+		if cs.err != nil {
+			if !errors.Is(err, cs.err) {
+				t.Fatalf("keep %d free: expect %v, got %v", cs.keepFree, cs.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("keep %d free: failed to allocate: %v", cs.keepFree, err)
+		}
+		if got := newPod.Annotations[util.PredicateGPUIndexPrefix+"0"]; got != cs.expect {
+			t.Fatalf("keep %d free: expect device %s, got %s", cs.keepFree, cs.expect, got)
+		}
+	}
+}
+
+func TestShareModeSortOrder(t *testing.T) {
+	for _, cs := range []struct {
+		order  []string
+		expect int
+	}{
+		// the devices are tied, the one of the lower id is sorted first
+		{order: config.DefaultShareSortOrder, expect: 0},
+		// device 1 is less utilized
+		{order: []string{config.SortByUtilization, config.SortByID}, expect: 1},
+	} {
+		cfg := config.Default()
+		cfg.ShareSortOrder = cs.order
+		node := newTestNode("testnode", 2, 16)
+		node.Annotations[util.GPUUtilizations] = "50,10"
+		nodeInfo := device.NewNodeInfoWithConfig(node, nil, cfg)
+
+		devs := mustEvaluate(t, NewShareMode(nodeInfo), Request{Cores: 20, Memory: 1})
+		if len(devs) != 1 || devs[0].GetID() != cs.expect {
+			t.Fatalf("order %v: expect device %d, got %v", cs.order, cs.expect, deviceIDs(devs))
+		}
+	}
+}
+
+func TestShareModeLowPriority(t *testing.T) {
+	threshold := int32(1000)
+	for _, cs := range []struct {
+		threshold *int32
+		priority  int32
+		expect    string
+	}{
+		// device 1 is emptier
+		{threshold: nil, priority: 0, expect: "1"},
+		{threshold: &threshold, priority: 2000, expect: "1"},
+		// a low priority pod packs into the busier device 0
+		{threshold: &threshold, priority: 0, expect: "0"},
+	} {
+		cfg := config.Default()
+		cfg.LowPriorityThreshold = cs.threshold
+		nodeInfo := device.NewNodeInfoWithConfig(newTestNode("testnode", 2, 16), nil, cfg)
+		if err := nodeInfo.AddUsedResources(0, 60, 4, 0); err != nil {
+			t.Fatalf("failed to add used resources: %v", err)
+		}
+
+		pod := newTestPod("pod", testContainer{cores: 20, memory: 2})
+		pod.Spec.Priority = &cs.priority
+		newPod, err := NewAllocator(nodeInfo).Allocate(context.Background(), pod)
+		if err != nil {
+			t.Fatalf("priority %d: failed to allocate: %v", cs.priority, err)
+		}
+		if got := newPod.Annotations[util.PredicateGPUIndexPrefix+"0"]; got != cs.expect {
+			t.Fatalf("priority %d: expect device %s, got %s", cs.priority, cs.expect, got)
+		}
+	}
+}
+
+// testDevice is the usage of a GPU device of a share mode fixture, the
+// cores and memory are used by the given number of containers
+type testDevice struct {
+	cores, memory uint
+	containers    int
+}
+
+// newShareTestNodeInfo returns the NodeInfo of a node whose GPU devices
+// have 100 cores and given vmemory each, and are used as devs tell
+func newShareTestNodeInfo(t testing.TB, memory int, devs ...testDevice) *device.NodeInfo {
+	nodeInfo := device.NewNodeInfo(newTestNode("testnode", len(devs), memory*len(devs)), nil)
+	for id, dev := range devs {
+		containers := dev.containers
+		if containers == 0 && (dev.cores > 0 || dev.memory > 0) {
+			containers = 1
+		}
+		for i := 0; i < containers; i++ {
+			// the first container takes all of the usage
+			cores, memory := dev.cores, dev.memory
+			if i > 0 {
+				cores, memory = 0, 0
+			}
+			if err := nodeInfo.AddUsedResources(id, cores, memory, 0); err != nil {
+				t.Fatalf("failed to add used resources of device %d: %v", id, err)
+			}
+		}
+	}
+	return nodeInfo
+}
+
+func TestShareModeScore(t *testing.T) {
+	busy := testDevice{cores: 60, memory: 4, containers: 2}
+	testCases := []struct {
+		name   string
+		devs   []testDevice
+		packed bool
+		// expect is the chosen device, -1 if none
+		expect int
+	}{
+		{name: "single device", devs: []testDevice{{}}, expect: 0},
+		{name: "single used device", devs: []testDevice{busy}, expect: 0},
+		// the devices are tied, the first of the sort order is chosen
+		{name: "all equal", devs: []testDevice{{}, {}, {}, {}}, expect: 0},
+		{name: "all equally used", devs: []testDevice{busy, busy, busy, busy}, expect: 0},
+		{name: "one dominant", devs: []testDevice{busy, busy, {}, busy}, expect: 2},
+		{name: "one dominant packed", devs: []testDevice{busy, busy, {}, busy}, packed: true, expect: 0},
+		{name: "all full", devs: []testDevice{{cores: 100, memory: 8}, {cores: 100, memory: 8}}, expect: -1},
+		{name: "all out of memory", devs: []testDevice{{cores: 10, memory: 7}, {cores: 10, memory: 7}}, expect: -1},
+		// device 0 is short of memory, device 1 of cores
+		{name: "one feasible", devs: []testDevice{{cores: 10, memory: 7}, {cores: 90}, {cores: 50, memory: 4}},
+			expect: 2},
+		// the number of containers is a cost
+		{name: "fewer containers", devs: []testDevice{{cores: 30, memory: 3, containers: 3},
+			{cores: 30, memory: 3, containers: 1}}, expect: 1},
+		{name: "more allocatable cores", devs: []testDevice{{cores: 50, memory: 2}, {cores: 20, memory: 2}},
+			expect: 1},
+		{name: "more allocatable memory", devs: []testDevice{{cores: 20, memory: 6}, {cores: 20, memory: 2}},
+			expect: 1},
+	}
+
+	for _, cs := range testCases {
+		nodeInfo := newShareTestNodeInfo(t, 8, cs.devs...)
+		devs := mustEvaluate(t, NewShareMode(nodeInfo).Packed(cs.packed), Request{Cores: 20, Memory: 2})
+		if cs.expect < 0 {
+			if len(devs) != 0 {
+				t.Fatalf("%s: expect no device, got %v", cs.name, deviceIDs(devs))
+			}
+			continue
+		}
+		if len(devs) != 1 || devs[0].GetID() != cs.expect {
+			t.Fatalf("%s: expect device %d, got %v", cs.name, cs.expect, deviceIDs(devs))
+		}
+	}
+}
+
+func BenchmarkShareModeEvaluate(b *testing.B) {
+	devs := make([]testDevice, 16)
+	for i := range devs {
+		devs[i] = testDevice{cores: uint(i%5) * 15, memory: uint(i % 7), containers: i % 4}
+	}
+	nodeInfo := newShareTestNodeInfo(b, 16, devs...)
+	mode := NewShareMode(nodeInfo)
+	req := Request{Cores: 20, Memory: 2, EstimatedTime: 10}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if devs, err := mode.Evaluate(req); err != nil || len(devs) != 1 {
+			b.Fatalf("failed to evaluate: %v %v", deviceIDs(devs), err)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package algorithm
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// stage is a step of Allocate whose time is recorded, see WithTimings
+type stage int
+
+const (
+	// stageFilter picks the candidate devices of a container
+	stageFilter stage = iota
+	// stageMatrix builds and normalizes the decision matrix of a shared
+	// container
+	stageMatrix
+	// stageScore scores the candidates and picks the devices
+	stageScore
+	// stageCharge charges the node for the devices picked
+	stageCharge
+	numStages
+)
+
+// stageTimer sums the time spent in each stage over the containers of a
+// pod, a nil timer records nothing
+type stageTimer [numStages]time.Duration
+
+// observe adds the time since start to given stage
+func (t *stageTimer) observe(s stage, start time.Time) {
+	if t == nil {
+		return
+	}
+	t[s] += time.Since(start)
+}
+
+// StageTimings are the microseconds Allocate spent in each stage for a pod
+// on a node, summed over its containers. They're written in JSON to the
+// PredicateStageTimings annotation.
+type StageTimings struct {
+	Filter int64 `json:"filterMicros"`
+	Matrix int64 `json:"matrixMicros"`
+	Score  int64 `json:"scoreMicros"`
+	Charge int64 `json:"chargeMicros"`
+}
+
+// timings returns the recorded time of the stages
+func (t *stageTimer) timings() StageTimings {
+	return StageTimings{
+		Filter: t[stageFilter].Microseconds(),
+		Matrix: t[stageMatrix].Microseconds(),
+		Score:  t[stageScore].Microseconds(),
+		Charge: t[stageCharge].Microseconds(),
+	}
+}
+
+// ParseStageTimings parses the PredicateStageTimings annotation
+func ParseStageTimings(value string) (StageTimings, error) {
+	var ret StageTimings
+	err := json.Unmarshal([]byte(value), &ret)
+	return ret, err
+}
@@ -0,0 +1,69 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package algorithm
+
+import (
+	"context"
+	"testing"
+
+	"tkestack.io/gpu-admission/pkg/device"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+func TestAllocateStageTimings(t *testing.T) {
+	testCases := []struct {
+		name      string
+		container testContainer
+		enabled   bool
+	}{
+		{name: "disabled", container: testContainer{cores: 10, memory: 1}},
+		{name: "shared", container: testContainer{cores: 10, memory: 1}, enabled: true},
+		{name: "exclusive", container: testContainer{cores: 200, memory: 8}, enabled: true},
+	}
+
+	for _, cs := range testCases {
+		nodeInfo := device.NewNodeInfo(newTestNode("testnode", 4, 32), nil)
+		pod := newTestPod("pod", cs.container)
+		// a timing left by former allocation is overwritten or removed
+		pod.Annotations[util.PredicateStageTimings] = "stale"
+
+		newPod, err := NewAllocator(nodeInfo).WithTimings(cs.enabled).Allocate(context.Background(), pod)
+		if err != nil {
+			t.Fatalf("%s: allocation failed: %v", cs.name, err)
+		}
+		value, ok := newPod.Annotations[util.PredicateStageTimings]
+		if !cs.enabled {
+			if ok {
+				t.Fatalf("%s: expect no timings, got %s", cs.name, value)
+			}
+			continue
+		}
+		if !ok {
+			t.Fatalf("%s: expect timings annotation", cs.name)
+		}
+		timings, err := ParseStageTimings(value)
+		if err != nil {
+			t.Fatalf("%s: failed to parse timings %s: %v", cs.name, value, err)
+		}
+		if timings.Filter < 0 || timings.Matrix < 0 || timings.Score < 0 || timings.Charge < 0 {
+			t.Fatalf("%s: expect non-negative timings, got %+v", cs.name, timings)
+		}
+		if cs.container.cores >= util.HundredCore && timings.Matrix != 0 {
+			t.Fatalf("%s: expect no matrix for exclusive container, got %+v", cs.name, timings)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package algorithm
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/api/core/v1"
+
+	"tkestack.io/gpu-admission/pkg/config"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+// ValidatePod checks the GPU request and the GPU annotations of given pod
+// under cfg without any node, and returns every malformed request which
+// would fail the pod on all nodes at scheduling, e.g. a negative vmemory, a
+// shared container while the share policy rejects it, or a GPU both pinned
+// and forbidden. The errors are AllocationError of no node, see
+// FailureReason to show them. A pod without GPU request is always valid.
+//
+// An ephemeral container requesting GPU is invalid: it's added to a pod
+// running on a node already, and it's never allocated nor charged, since
+// gpu-manager assigns the devices by the predicate annotations, which
+// never cover ephemeral containers.
+func ValidatePod(pod *v1.Pod, cfg *config.Config) []error {
+	reasons := validateEphemeralContainers(pod)
+	if !util.IsGPURequiredPod(pod) {
+		return reasons
+	}
+	for i := range pod.Spec.Containers {
+		c := &pod.Spec.Containers[i]
+		if !util.IsGPURequiredContainer(c) {
+			continue
+		}
+		if _, count := util.GetMIGRequestOfContainer(c); count > 0 {
+			if err := validateMIGContainer(c); err != nil {
+				reasons = append(reasons, err)
+			}
+			continue
+		}
+		if err := validateContainer(pod, c, cfg); err != nil {
+			reasons = append(reasons, err)
+			continue
+		}
+		if _, err := util.GetEstimatedTimeOfContainer(pod, i); err != nil {
+			reasons = append(reasons, invalidPod(c.Name, ErrInvalidRequest, "%v", err))
+		}
+	}
+	for i := range pod.Spec.InitContainers {
+		c := &pod.Spec.InitContainers[i]
+		if !util.IsGPURequiredContainer(c) {
+			continue
+		}
+		if profile, count := util.GetMIGRequestOfContainer(c); count > 0 {
+			reasons = append(reasons, invalidPod(c.Name, ErrInvalidRequest,
+				"MIG profile %s is only supported by regular containers", profile))
+			continue
+		}
+		if err := validateContainer(pod, c, cfg); err != nil {
+			reasons = append(reasons, err)
+		}
+	}
+	if err := validateWholeNodePod(pod); err != nil {
+		reasons = append(reasons, err)
+	}
+	return append(reasons, validateAnnotations(pod)...)
+}
+
+// validateContainer checks the vcore and vmemory requested by given
+// container are integers the share policy and exclusive mode of cfg allow
+func validateContainer(pod *v1.Pod, c *v1.Container, cfg *config.Config) error {
+	vendor, _ := util.GetVendorOfContainer(c)
+	vcore, _, err := util.GetGPURequestOfContainer(pod, c, vendor)
+	if err != nil {
+		return invalidPod(c.Name, ErrInvalidRequest, "%v", err)
+	}
+	switch {
+	case vcore < util.HundredCore && cfg.SharePolicy == config.ShareRejected:
+		return invalidPod(c.Name, ErrShareDisabled, "request %d vcore, only whole GPUs are allocated", vcore)
+	case vcore >= util.HundredCore && cfg.DisableExclusive:
+		return invalidPod(c.Name, ErrExclusiveDisabled, "request %d vcore, only shared GPUs are allocated", vcore)
+	}
+	return nil
+}
+
+// validateEphemeralContainers checks no ephemeral container of given pod
+// requests GPU
+func validateEphemeralContainers(pod *v1.Pod) []error {
+	var reasons []error
+	for _, ec := range pod.Spec.EphemeralContainers {
+		c := v1.Container(ec.EphemeralContainerCommon)
+		if util.IsGPURequiredContainer(&c) {
+			reasons = append(reasons, invalidPod(c.Name, ErrInvalidRequest,
+				"ephemeral container requests GPU, which is never allocated"))
+		}
+	}
+	return reasons
+}
+
+// validateMIGContainer checks given container requests instances of a
+// single MIG profile
+func validateMIGContainer(c *v1.Container) *AllocationError {
+	if profiles := util.GetMIGProfilesOfContainer(c); len(profiles) > 1 {
+		return invalidPod(c.Name, ErrInvalidRequest,
+			"MIG profiles %s requested together, a container takes instances of one profile",
+			strings.Join(profiles, ", "))
+	}
+	return nil
+}
+
+// validateAnnotations checks the GPU annotations of the whole pod
+func validateAnnotations(pod *v1.Pod) []error {
+	var reasons []error
+	pinned, err := util.GetPinnedDevicesOfPod(pod)
+	if err != nil {
+		reasons = append(reasons, invalidPod("", ErrInvalidRequest, "%v", err))
+	}
+	forbidden, err := util.GetForbiddenDevicesOfPod(pod)
+	if err != nil {
+		reasons = append(reasons, invalidPod("", ErrInvalidRequest, "%v", err))
+	}
+	for _, id := range pinned {
+		if containsInt(forbidden, id) {
+			reasons = append(reasons, invalidPod("", ErrInvalidRequest,
+				"GPU %d is both pinned by %s and forbidden by %s", id,
+				util.GPUPinDevicesAnnotation, util.GPUForbidDevicesAnnotation))
+		}
+	}
+	for _, name := range util.GetSoftConstraintsOfPod(pod) {
+		if !isRelaxation(name) {
+			reasons = append(reasons, invalidPod("", ErrInvalidRequest, "unknown soft constraint %s", name))
+		}
+	}
+	if _, _, err := util.GetGangOfPod(pod); err != nil {
+		reasons = append(reasons, invalidPod("", ErrInvalidRequest, "%v", err))
+	}
+	if _, _, err := util.GetMinComputeCapabilityOfPod(pod); err != nil {
+		reasons = append(reasons, invalidPod("", ErrInvalidRequest, "%v", err))
+	}
+	return reasons
+}
+
+// invalidPod returns an AllocationError of given container on no node
+func invalidPod(container string, reason error, format string, args ...interface{}) *AllocationError {
+	return &AllocationError{
+		Container: container,
+		Reason:    reason,
+		Detail:    fmt.Sprintf(format, args...),
+	}
+}
+
+func containsInt(list []int, i int) bool {
+	for _, item := range list {
+		if item == i {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package algorithm
+
+import (
+	"errors"
+	"testing"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"tkestack.io/gpu-admission/pkg/config"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+func TestValidatePod(t *testing.T) {
+	testCases := []struct {
+		name    string
+		cores   uint
+		modify  func(pod *v1.Pod, cfg *config.Config)
+		reasons []error
+	}{
+		{name: "valid shared", cores: 50, modify: func(pod *v1.Pod, cfg *config.Config) {}},
+		{name: "valid exclusive", cores: 200, modify: func(pod *v1.Pod, cfg *config.Config) {
+			pod.Annotations[util.GPUPinDevicesAnnotation] = "0,1"
+			pod.Annotations[util.GPUForbidDevicesAnnotation] = "2"
+		}},
+		{name: "no GPU request", cores: 0, modify: func(pod *v1.Pod, cfg *config.Config) {
+			cfg.SharePolicy = config.ShareRejected
+			pod.Annotations[util.GPUPinDevicesAnnotation] = "x"
+		}},
+		{name: "negative memory", cores: 50, modify: func(pod *v1.Pod, cfg *config.Config) {
+			pod.Spec.Containers[0].Resources.Limits[v1.ResourceName(util.VMemoryAnnotation)] =
+				resource.MustParse("-1")
+		}, reasons: []error{ErrInvalidRequest}},
+		{name: "fractional cores", cores: 50, modify: func(pod *v1.Pod, cfg *config.Config) {
+			pod.Spec.Containers[0].Resources.Limits[v1.ResourceName(util.VCoreAnnotation)] =
+				resource.MustParse("0.5")
+		}, reasons: []error{ErrInvalidRequest}},
+		{name: "share rejected", cores: 50, modify: func(pod *v1.Pod, cfg *config.Config) {
+			cfg.SharePolicy = config.ShareRejected
+		}, reasons: []error{ErrShareDisabled}},
+		{name: "share rounded up", cores: 50, modify: func(pod *v1.Pod, cfg *config.Config) {
+			cfg.SharePolicy = config.ShareRoundedUp
+		}},
+		{name: "exclusive disabled", cores: 100, modify: func(pod *v1.Pod, cfg *config.Config) {
+			cfg.DisableExclusive = true
+		}, reasons: []error{ErrExclusiveDisabled}},
+		{name: "pinned and forbidden", cores: 50, modify: func(pod *v1.Pod, cfg *config.Config) {
+			pod.Annotations[util.GPUPinDevicesAnnotation] = "0,1"
+			pod.Annotations[util.GPUForbidDevicesAnnotation] = "1"
+		}, reasons: []error{ErrInvalidRequest}},
+		{name: "malformed pin and soft constraint", cores: 50, modify: func(pod *v1.Pod, cfg *config.Config) {
+			pod.Annotations[util.GPUPinDevicesAnnotation] = "-1"
+			pod.Annotations[util.GPUSoftConstraintsAnnotation] = "numa"
+		}, reasons: []error{ErrInvalidRequest, ErrInvalidRequest}},
+		{name: "malformed gang", cores: 50, modify: func(pod *v1.Pod, cfg *config.Config) {
+			pod.Annotations[util.GPUGangNameAnnotation] = "job"
+			pod.Annotations[util.GPUGangSizeAnnotation] = "0"
+		}, reasons: []error{ErrInvalidRequest}},
+		{name: "malformed estimated time", cores: 50, modify: func(pod *v1.Pod, cfg *config.Config) {
+			pod.Annotations[util.EstimatedTime+"0"] = "soon"
+		}, reasons: []error{ErrInvalidRequest}},
+		{name: "MIG init container", cores: 50, modify: func(pod *v1.Pod, cfg *config.Config) {
+			pod.Spec.InitContainers = []v1.Container{{
+				Name: "init",
+				Resources: v1.ResourceRequirements{Limits: v1.ResourceList{
+					v1.ResourceName(util.MIGResourcePrefix + "1g.5gb"): resource.MustParse("1"),
+				}},
+			}}
+		}, reasons: []error{ErrInvalidRequest}},
+		{name: "MIG profile", cores: 0, modify: func(pod *v1.Pod, cfg *config.Config) {
+			pod.Spec.Containers[0].Resources.Limits = v1.ResourceList{
+				v1.ResourceName(util.MIGResourcePrefix + "1g.5gb"): resource.MustParse("2"),
+			}
+		}},
+		{name: "MIG profiles together", cores: 0, modify: func(pod *v1.Pod, cfg *config.Config) {
+			pod.Spec.Containers[0].Resources.Limits = v1.ResourceList{
+				v1.ResourceName(util.MIGResourcePrefix + "1g.5gb"):  resource.MustParse("1"),
+				v1.ResourceName(util.MIGResourcePrefix + "3g.20gb"): resource.MustParse("1"),
+			}
+		}, reasons: []error{ErrInvalidRequest}},
+		{name: "GPU ephemeral container", cores: 0, modify: func(pod *v1.Pod, cfg *config.Config) {
+			debug := newTestPod("debug", testContainer{cores: 100, memory: 8}).Spec.Containers[0]
+			pod.Spec.EphemeralContainers = []v1.EphemeralContainer{
+				{EphemeralContainerCommon: v1.EphemeralContainerCommon(debug)},
+			}
+		}, reasons: []error{ErrInvalidRequest}},
+	}
+
+	for _, cs := range testCases {
+		pod := newTestPod("pod", testContainer{cores: cs.cores, memory: 2})
+		cfg := config.Default()
+		cs.modify(pod, cfg)
+
+		reasons := ValidatePod(pod, cfg)
+		if len(reasons) != len(cs.reasons) {
+			t.Fatalf("%s: expect %d reasons, got %v", cs.name, len(cs.reasons), reasons)
+		}
+		for i, reason := range reasons {
+			if !errors.Is(reason, cs.reasons[i]) {
+				t.Fatalf("%s: expect %v, got %v", cs.name, cs.reasons[i], reason)
+			}
+		}
+	}
+}
@@ -0,0 +1,181 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package algorithm
+
+import (
+	"math"
+
+	"tkestack.io/gpu-admission/pkg/config"
+)
+
+// criteriaWeights returns the weights of the criteria, the columns of
+// matrix, by given weighting, the columns from firstCost on are costs. The
+// static weights are returned for config.StaticWeighting, or if the weights
+// can't be derived from matrix, e.g. there is only one row or all rows are
+// the same. A criterion whose static weight is 0 is disabled, it weighs
+// nothing by any weighting.
+func criteriaWeights(weighting string, matrix [][]float64, firstCost int, static []float64) []float64 {
+	var weights []float64
+	switch weighting {
+	case config.EntropyWeighting:
+		weights = entropyWeights(matrix)
+	case config.CRITICWeighting:
+		weights = criticWeights(matrix, firstCost)
+	}
+	if weights == nil {
+		return static
+	}
+	var sum float64
+	for j := range weights {
+		if static[j] == 0 {
+			weights[j] = 0
+		}
+		sum += weights[j]
+	}
+	if sum == 0 {
+		return static
+	}
+	for j := range weights {
+		weights[j] /= sum
+	}
+	return weights
+}
+
+// entropyWeights derives the weights of the criteria by the entropy weight
+// method: the more evenly a criterion is distributed among the rows, the
+// higher its entropy is and the less it weighs. The values of matrix must
+// be non-negative. It returns nil if no criterion tells the rows apart.
+func entropyWeights(matrix [][]float64) []float64 {
+	rows := len(matrix)
+	if rows < 2 {
+		return nil
+	}
+	cols := len(matrix[0])
+	k := 1 / math.Log(float64(rows))
+	divergences := make([]float64, cols)
+	var sum float64
+	for j := 0; j < cols; j++ {
+		var total float64
+		for i := 0; i < rows; i++ {
+			total += matrix[i][j]
+		}
+		// a criterion of all zero tells nothing
+		if total == 0 {
+			continue
+		}
+		var entropy float64
+		for i := 0; i < rows; i++ {
+			if p := matrix[i][j] / total; p > 0 {
+				entropy -= p * math.Log(p)
+			}
+		}
+		divergences[j] = math.Max(0, 1-k*entropy)
+		sum += divergences[j]
+	}
+	if sum == 0 {
+		return nil
+	}
+	for j := range divergences {
+		divergences[j] /= sum
+	}
+	return divergences
+}
+
+// criticWeights derives the weights of the criteria by the CRITIC method.
+// Each criterion is normalized to [0, 1], the better the higher, then its
+// information is its standard deviation times its conflict with the others,
+// the sum of 1 minus the correlation with each of them. So of two
+// correlated criteria, e.g. cores and memory, neither weighs fully. The
+// columns from firstCost on are costs. It returns nil if no criterion tells
+// the rows apart.
+func criticWeights(matrix [][]float64, firstCost int) []float64 {
+	rows := len(matrix)
+	if rows < 2 {
+		return nil
+	}
+	cols := len(matrix[0])
+	normalized := make([][]float64, cols)
+	stddevs := make([]float64, cols)
+	for j := 0; j < cols; j++ {
+		min, max := matrix[0][j], matrix[0][j]
+		for i := 1; i < rows; i++ {
+			min = math.Min(min, matrix[i][j])
+			max = math.Max(max, matrix[i][j])
+		}
+		if max == min {
+			continue
+		}
+		column := make([]float64, rows)
+		var mean float64
+		for i := 0; i < rows; i++ {
+			column[i] = (matrix[i][j] - min) / (max - min)
+			if j >= firstCost {
+				column[i] = 1 - column[i]
+			}
+			mean += column[i]
+		}
+		mean /= float64(rows)
+		var variance float64
+		for _, v := range column {
+			variance += (v - mean) * (v - mean)
+		}
+		normalized[j] = column
+		stddevs[j] = math.Sqrt(variance / float64(rows))
+	}
+
+	weights := make([]float64, cols)
+	var sum float64
+	for j := 0; j < cols; j++ {
+		if stddevs[j] == 0 {
+			continue
+		}
+		var conflict float64
+		for k := 0; k < cols; k++ {
+			// a constant criterion conflicts with nothing
+			if k == j || stddevs[k] == 0 {
+				continue
+			}
+			conflict += 1 - correlation(normalized[j], normalized[k], stddevs[j], stddevs[k])
+		}
+		weights[j] = stddevs[j] * conflict
+		sum += weights[j]
+	}
+	if sum == 0 {
+		return nil
+	}
+	for j := range weights {
+		weights[j] /= sum
+	}
+	return weights
+}
+
+// correlation returns the Pearson correlation of x and y, whose population
+// standard deviations are sx and sy
+func correlation(x, y []float64, sx, sy float64) float64 {
+	var meanX, meanY float64
+	for i := range x {
+		meanX += x[i]
+		meanY += y[i]
+	}
+	meanX /= float64(len(x))
+	meanY /= float64(len(y))
+	var cov float64
+	for i := range x {
+		cov += (x[i] - meanX) * (y[i] - meanY)
+	}
+	return cov / float64(len(x)) / (sx * sy)
+}
@@ -0,0 +1,115 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package algorithm
+
+import (
+	"math"
+	"testing"
+
+	"tkestack.io/gpu-admission/pkg/config"
+)
+
+func TestEntropyWeights(t *testing.T) {
+	// allocatable cores, allocatable memory, time, containers, the memory
+	// is nearly the same on all devices
+	matrix := [][]float64{
+		{10, 8, 0, 1},
+		{50, 8, 5, 3},
+		{90, 8, 1, 2},
+		{30, 8.1, 3, 0},
+	}
+	weights := criteriaWeights(config.EntropyWeighting, matrix, 3, config.DefaultShareWeights)
+	if len(weights) != 4 {
+		t.Fatalf("expect 4 weights, got %v", weights)
+	}
+	var sum float64
+	for j, w := range weights {
+		sum += w
+		if j != 1 && weights[1] >= w {
+			t.Fatalf("expect the near-constant memory to weigh the least, got %v", weights)
+		}
+	}
+	if weights[1] > 0.01 {
+		t.Fatalf("expect a low weight of the near-constant memory, got %v", weights)
+	}
+	if math.Abs(sum-1) > 1e-9 {
+		t.Fatalf("expect the weights to sum up to 1, got %v", weights)
+	}
+
+	// a criterion disabled by its static weight stays disabled
+	disabled := []float64{0.3, 0.3, 0, 0.2}
+	for _, weighting := range []string{config.EntropyWeighting, config.CRITICWeighting} {
+		weights := criteriaWeights(weighting, matrix, 3, disabled)
+		sum = 0
+		for _, w := range weights {
+			sum += w
+		}
+		if weights[2] != 0 || math.Abs(sum-1) > 1e-9 {
+			t.Fatalf("%s: expect no weight of the disabled time and the others to sum up to 1, got %v",
+				weighting, weights)
+		}
+	}
+
+	for _, cs := range []struct {
+		name      string
+		weighting string
+		matrix    [][]float64
+	}{
+		{name: "static", weighting: config.StaticWeighting, matrix: matrix},
+		{name: "one device", weighting: config.EntropyWeighting, matrix: matrix[:1]},
+		{name: "same devices", weighting: config.EntropyWeighting, matrix: [][]float64{{1, 2, 0, 1}, {1, 2, 0, 1}}},
+	} {
+		got := criteriaWeights(cs.weighting, cs.matrix, 3, config.DefaultShareWeights)
+		if len(got) != len(config.DefaultShareWeights) || got[0] != config.DefaultShareWeights[0] {
+			t.Fatalf("%s: expect the static weights, got %v", cs.name, got)
+		}
+	}
+}
+
+func TestCRITICWeights(t *testing.T) {
+	// allocatable cores and memory are perfectly correlated
+	matrix := [][]float64{
+		{10, 2, 0, 1},
+		{50, 10, 5, 3},
+		{90, 18, 1, 2},
+		{30, 6, 3, 0},
+	}
+	static := config.DefaultShareWeights
+	weights := criteriaWeights(config.CRITICWeighting, matrix, 3, static)
+	if len(weights) != 4 {
+		t.Fatalf("expect 4 weights, got %v", weights)
+	}
+	if math.Abs(weights[0]-weights[1]) > 1e-9 {
+		t.Fatalf("expect the same weight of correlated cores and memory, got %v", weights)
+	}
+	if weights[0]+weights[1] >= static[0]+static[1] {
+		t.Fatalf("expect correlated cores and memory to weigh less than static %v, got %v", static, weights)
+	}
+	var sum float64
+	for _, w := range weights {
+		sum += w
+	}
+	if math.Abs(sum-1) > 1e-9 {
+		t.Fatalf("expect the weights to sum up to 1, got %v", weights)
+	}
+
+	// a constant criterion weighs nothing
+	matrix[0][2], matrix[1][2], matrix[2][2], matrix[3][2] = 4, 4, 4, 4
+	if weights := criticWeights(matrix, 3); weights[2] != 0 {
+		t.Fatalf("expect no weight of the constant time, got %v", weights)
+	}
+}
@@ -0,0 +1,106 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package algorithm
+
+import (
+	"strings"
+
+	"k8s.io/api/core/v1"
+
+	"tkestack.io/gpu-admission/pkg/device"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+type wholeNodeMode struct {
+	node    *device.NodeInfo
+	filters []DeviceFilter
+}
+
+// NewWholeNodeMode returns a mode whose Evaluate returns all of the GPU
+// devices of the node, in the order of device idx, if every one of them is
+// schedulable, accepted by all of the filters, not MIG enabled and
+// completely free. Otherwise nothing is returned. The request is ignored,
+// a whole node container takes the devices exclusively.
+func NewWholeNodeMode(n *device.NodeInfo, filters ...DeviceFilter) *wholeNodeMode {
+	return &wholeNodeMode{node: n, filters: filters}
+}
+
+func (al *wholeNodeMode) Evaluate(_ Request) ([]*device.DeviceInfo, error) {
+	devs := make([]*device.DeviceInfo, 0, al.node.GetDeviceCount())
+	for i := 0; i < al.node.GetDeviceCount(); i++ {
+		dev, ok := al.node.GetDeviceMap()[i]
+		if !ok || !isWholeFree(dev, al.filters) {
+			return nil, nil
+		}
+		devs = append(devs, dev)
+	}
+	return devs, nil
+}
+
+// isWholeFree tells if a device can be taken by a whole node container
+func isWholeFree(dev *device.DeviceInfo, filters []DeviceFilter) bool {
+	return dev.IsSchedulable() && isCandidate(dev, filters) && isFree(dev) &&
+		dev.AllocatableMemory() == dev.SchedulableMemory()
+}
+
+// validateWholeNodePod checks a whole node pod has a single GPU container:
+// the container takes all of the GPU devices, so another one would never
+// find a free device on any node
+func validateWholeNodePod(pod *v1.Pod) *AllocationError {
+	if !util.IsWholeNodePod(pod) {
+		return nil
+	}
+	var names []string
+	for i := range pod.Spec.Containers {
+		if util.IsGPURequiredContainer(&pod.Spec.Containers[i]) {
+			names = append(names, pod.Spec.Containers[i].Name)
+		}
+	}
+	if len(names) > 1 {
+		return invalidPod("", ErrInvalidRequest,
+			"whole node request, only one container may request GPU, got %s", strings.Join(names, ","))
+	}
+	return nil
+}
+
+// wholeNodeError tells which device keeps the whole node container from
+// being allocated
+func (alloc *allocator) wholeNodeError(container *v1.Container, filters []DeviceFilter) error {
+	count := alloc.nodeInfo.GetDeviceCount()
+	for i := 0; i < count; i++ {
+		dev, ok := alloc.nodeInfo.GetDeviceMap()[i]
+		switch {
+		case !ok:
+			return alloc.newAllocationError(container.Name, ErrNoAvailableDevice,
+				"whole node request, GPU %d is missing", i)
+		case dev.IsBlacklisted():
+			return alloc.newAllocationError(container.Name, ErrNoAvailableDevice,
+				"whole node request, GPU %d is blacklisted", i)
+		case !dev.IsHealthy():
+			return alloc.newAllocationError(container.Name, ErrNoAvailableDevice,
+				"whole node request, GPU %d is %s", i, dev.Health())
+		case !isCandidate(dev, filters):
+			return alloc.newAllocationError(container.Name, ErrNoAvailableDevice,
+				"whole node request, GPU %d is unavailable", i)
+		case !isWholeFree(dev, filters):
+			return alloc.newAllocationError(container.Name, ErrInsufficientDevices,
+				"whole node request, GPU %d is partly used", i)
+		}
+	}
+	return alloc.newAllocationError(container.Name, ErrNoAvailableDevice,
+		"whole node request, node has no GPU")
+}
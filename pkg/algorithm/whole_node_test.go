@@ -0,0 +1,120 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package algorithm
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"k8s.io/api/core/v1"
+
+	"tkestack.io/gpu-admission/pkg/config"
+	"tkestack.io/gpu-admission/pkg/device"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+func TestAllocateWholeNode(t *testing.T) {
+	testCases := []struct {
+		name      string
+		usedCores uint
+		usedMem   uint
+		unhealthy string
+		cores     uint
+		expect    string
+		err       error
+		detail    string
+	}{
+		{name: "all GPUs free", cores: 100, expect: "0,1,2"},
+		{name: "the request is ignored", cores: 10, expect: "0,1,2"},
+		{name: "GPU partly used by cores", usedCores: 10, cores: 100,
+			err: ErrInsufficientDevices, detail: "GPU 1 is partly used"},
+		{name: "GPU partly used by memory", usedMem: 1, cores: 100,
+			err: ErrInsufficientDevices, detail: "GPU 1 is partly used"},
+		{name: "GPU unhealthy", unhealthy: "2", cores: 100,
+			err: ErrNoAvailableDevice, detail: "GPU 2 is Unhealthy"},
+	}
+	for _, cs := range testCases {
+		node := newTestNode("testnode", 3, 24)
+		if cs.unhealthy != "" {
+			node.Annotations[util.UnhealthyGPUIndexes] = cs.unhealthy
+		}
+		nodeInfo := device.NewNodeInfo(node, nil)
+		if cs.usedCores > 0 || cs.usedMem > 0 {
+			if err := nodeInfo.AddUsedResources(1, cs.usedCores, cs.usedMem, 0); err != nil {
+				t.Fatalf("%s: failed to add used resources: %v", cs.name, err)
+			}
+		}
+		pod := newTestPod("pod", testContainer{cores: cs.cores, memory: 1})
+		pod.Annotations[util.GPUWholeNodeAnnotation] = "true"
+
+		newPod, err := NewAllocator(nodeInfo).Allocate(context.Background(), pod)
+		if cs.err != nil {
+			if !errors.Is(err, cs.err) || !strings.Contains(err.Error(), cs.detail) {
+				t.Fatalf("%s: expect %v with %q, got %v", cs.name, cs.err, cs.detail, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: failed to allocate: %v", cs.name, err)
+		}
+		if got := newPod.Annotations[util.PredicateGPUIndexPrefix+"0"]; got != cs.expect {
+			t.Fatalf("%s: expect devices %s, got %s", cs.name, cs.expect, got)
+		}
+		if free := nodeInfo.FreeWholeGPUs(); free != 0 {
+			t.Fatalf("%s: expect all GPUs charged, got %d free", cs.name, free)
+		}
+		// the node rebuilt from the allocated pod is charged the same
+		rebuilt := device.NewNodeInfo(node, []*v1.Pod{newPod})
+		for id, dev := range nodeInfo.GetDeviceMap() {
+			got := rebuilt.GetDeviceMap()[id]
+			if got.AllocatableCores() != dev.AllocatableCores() || got.AllocatableMemory() != dev.AllocatableMemory() {
+				t.Fatalf("%s: expect GPU %d rebuilt with %d cores %d memory, got %d cores %d memory",
+					cs.name, id, dev.AllocatableCores(), dev.AllocatableMemory(),
+					got.AllocatableCores(), got.AllocatableMemory())
+			}
+		}
+	}
+}
+
+func TestAllocateWholeNodeContainers(t *testing.T) {
+	node := newTestNode("testnode", 2, 24)
+	nodeInfo := device.NewNodeInfo(node, nil)
+	pod := newTestPod("pod", testContainer{cores: 100, memory: 1}, testContainer{cores: 100, memory: 1})
+	pod.Annotations[util.GPUWholeNodeAnnotation] = "true"
+
+	_, err := NewAllocator(nodeInfo).Allocate(context.Background(), pod)
+	if !errors.Is(err, ErrInvalidRequest) || !strings.Contains(err.Error(), "only one container may request GPU") {
+		t.Fatalf("expect %v for two GPU containers, got %v", ErrInvalidRequest, err)
+	}
+	if free := nodeInfo.FreeWholeGPUs(); free != 2 {
+		t.Fatalf("expect nothing charged, got %d free GPUs", free)
+	}
+	if reasons := ValidatePod(pod, config.Default()); len(reasons) != 1 || !errors.Is(reasons[0], ErrInvalidRequest) {
+		t.Fatalf("expect the pod invalid, got %v", reasons)
+	}
+
+	// a container without GPU request is left alone
+	pod.Spec.Containers[1].Resources.Limits = nil
+	if _, err := NewAllocator(nodeInfo).Allocate(context.Background(), pod); err != nil {
+		t.Fatalf("failed to allocate: %v", err)
+	}
+	if reasons := ValidatePod(pod, config.Default()); len(reasons) != 0 {
+		t.Fatalf("expect the pod valid, got %v", reasons)
+	}
+}
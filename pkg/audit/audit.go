@@ -0,0 +1,163 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+// Package audit records the GPU allocation decisions durably, one record
+// for each pod filtered
+package audit
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// The outcomes of an allocation
+const (
+	OutcomeAllocated = "allocated"
+	OutcomeRejected  = "rejected"
+)
+
+// DefaultBufferSize is the number of records a FileSink holds before the
+// new ones are dropped
+const DefaultBufferSize = 1024
+
+// Record is the decision of the filter on a pod, the node it's allocated on
+// or the reasons it fits none
+type Record struct {
+	Time      time.Time `json:"time"`
+	PodUID    string    `json:"podUID"`
+	Namespace string    `json:"namespace"`
+	Pod       string    `json:"pod"`
+	// Node is empty if the pod is rejected
+	Node string `json:"node,omitempty"`
+	// Mode is the mode of the first container requesting GPU, see
+	// metrics.ModeShare
+	Mode    string `json:"mode"`
+	Outcome string `json:"outcome"`
+	// Reason tells why the pod is rejected
+	Reason string `json:"reason,omitempty"`
+	// Containers are the containers allocated, empty if the pod is
+	// rejected
+	Containers []ContainerRecord `json:"containers,omitempty"`
+}
+
+// ContainerRecord is the GPU devices chosen for a container, and the cores
+// and memory charged on them in total
+type ContainerRecord struct {
+	Name    string `json:"name"`
+	Init    bool   `json:"init,omitempty"`
+	Mode    string `json:"mode"`
+	Devices []int  `json:"devices"`
+	Cores   uint   `json:"cores"`
+	Memory  uint   `json:"memory"`
+}
+
+// Sink receives the records. Write must not block, since it's called while
+// the pod is being filtered, a sink which can't keep up should drop the
+// records instead.
+type Sink interface {
+	Write(record Record)
+}
+
+// FileSink appends the records to a file in JSON lines. The records are
+// written by a goroutine from a buffer, once the buffer is full the new
+// records are dropped and counted, so are the records written after Close,
+// e.g. by a request still in flight when the servers are cut off.
+type FileSink struct {
+	file    *os.File
+	records chan Record
+	done    chan struct{}
+	dropped uint64
+	// mu guards closed against the records being buffered
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewFileSink opens the file of given path for appending, and starts
+// writing the records buffered up to bufferSize
+func NewFileSink(path string, bufferSize int) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if bufferSize <= 0 {
+		bufferSize = DefaultBufferSize
+	}
+	s := &FileSink{
+		file:    file,
+		records: make(chan Record, bufferSize),
+		done:    make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+func (s *FileSink) run() {
+	defer close(s.done)
+	encoder := json.NewEncoder(s.file)
+	for record := range s.records {
+		if err := encoder.Encode(record); err != nil {
+			klog.Errorf("failed to write audit record of pod %s: %v", record.PodUID, err)
+		}
+	}
+}
+
+// Write buffers the record, or drops it if the buffer is full or the sink
+// is closed
+func (s *FileSink) Write(record Record) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		atomic.AddUint64(&s.dropped, 1)
+		klog.Warningf("audit log is closed, dropped the record of pod %s", record.PodUID)
+		return
+	}
+	select {
+	case s.records <- record:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+		klog.Warningf("audit buffer is full, dropped the record of pod %s on node %s",
+			record.PodUID, record.Node)
+	}
+}
+
+// Dropped returns the number of records dropped so far
+func (s *FileSink) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close writes the records buffered and closes the file, the records
+// written afterwards are dropped
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.records)
+	s.mu.Unlock()
+	<-s.done
+	if err := s.file.Sync(); err != nil {
+		s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
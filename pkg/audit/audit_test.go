@@ -0,0 +1,108 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gpu-admission")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+	records := []Record{
+		{
+			Time: time.Unix(1, 0).UTC(), PodUID: "uid-a", Namespace: "ns", Pod: "a", Node: "node-1",
+			Mode: "share", Outcome: OutcomeAllocated,
+			Containers: []ContainerRecord{{Name: "c0", Mode: "share", Devices: []int{1}, Cores: 50, Memory: 2}},
+		},
+		{
+			Time: time.Unix(2, 0).UTC(), PodUID: "uid-b", Namespace: "ns", Pod: "b", Node: "node-1",
+			Mode: "exclusive", Outcome: OutcomeRejected, Reason: "insufficient free GPUs",
+		},
+	}
+	sink, err := NewFileSink(path, 0)
+	if err != nil {
+		t.Fatalf("failed to create sink: %v", err)
+	}
+	for _, record := range records {
+		sink.Write(record)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("failed to close sink: %v", err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open audit log: %v", err)
+	}
+	defer file.Close()
+	var got []Record
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("invalid line %q: %v", scanner.Text(), err)
+		}
+		got = append(got, record)
+	}
+	if !reflect.DeepEqual(got, records) {
+		t.Fatalf("expect %+v, got %+v", records, got)
+	}
+}
+
+func TestFileSinkDrops(t *testing.T) {
+	sink := &FileSink{records: make(chan Record, 1)}
+	sink.Write(Record{PodUID: "uid-a"})
+	sink.Write(Record{PodUID: "uid-b"})
+	if dropped := sink.Dropped(); dropped != 1 {
+		t.Fatalf("expect 1 record dropped, got %d", dropped)
+	}
+}
+
+func TestFileSinkClosed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gpu-admission")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	sink, err := NewFileSink(filepath.Join(dir, "audit.log"), 0)
+	if err != nil {
+		t.Fatalf("failed to create sink: %v", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("failed to close sink: %v", err)
+	}
+	// a request left in flight writes after the sink is closed
+	sink.Write(Record{PodUID: "uid-a"})
+	if dropped := sink.Dropped(); dropped != 1 {
+		t.Fatalf("expect the record after close dropped, got %d", dropped)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("expect closing twice to do nothing, got %v", err)
+	}
+}
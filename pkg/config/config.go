@@ -0,0 +1,472 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultCoreOvercommitRatio keeps the schedulable cores of a GPU
+	// device equal to its physical cores
+	DefaultCoreOvercommitRatio = 1.0
+	// MaxCoreOvercommitRatio is the upper bound of the overcommit ratio
+	MaxCoreOvercommitRatio = 10.0
+
+	// PackPolicy prefers the nodes with less free GPU resources
+	PackPolicy = "pack"
+	// SpreadPolicy prefers the nodes with more free GPU resources
+	SpreadPolicy = "spread"
+
+	// ShareAllowed places a container requesting less than a GPU on a
+	// shared GPU
+	ShareAllowed = "allow"
+	// ShareRejected rejects a container requesting less than a GPU
+	ShareRejected = "reject"
+	// ShareRoundedUp gives a container requesting less than a GPU a whole
+	// GPU, as if it requested one
+	ShareRoundedUp = "roundUp"
+
+	// StaticWeighting ranks the shared devices by ShareWeights
+	StaticWeighting = "static"
+	// EntropyWeighting derives the weights from the candidate devices by
+	// the entropy weight method, a criterion differing more among the
+	// devices weighs more
+	EntropyWeighting = "entropy"
+	// CRITICWeighting derives the weights from the candidate devices by the
+	// CRITIC method, a criterion differing more among the devices and less
+	// correlated with the others weighs more
+	CRITICWeighting = "critic"
+
+	// the criteria share mode sorts the candidate devices by, see
+	// ShareSortOrder
+	SortByAllocatableCores  = "allocatableCores"
+	SortByAllocatableMemory = "allocatableMemory"
+	SortByID                = "id"
+	SortByUtilization       = "utilization"
+	SortByTemperature       = "temperature"
+	SortByPower             = "power"
+	SortByNumberOfContainer = "numberOfContainer"
+
+	// DefaultFilterCacheTTL is how long a cached filter result is trusted
+	DefaultFilterCacheTTL = 5 * time.Second
+
+	// HundredCore is the cores of a GPU device before overcommit, see
+	// util.HundredCore
+	HundredCore = 100
+)
+
+// DefaultShareWeights are the weights of allocatable cores, allocatable
+// memory, estimated time and number of containers when share mode ranks
+// the devices
+var DefaultShareWeights = []float64{0.3, 0.3, 0.2, 0.2}
+
+// DefaultShareSortOrder is the order share mode sorts the candidate devices
+// by before ranking them
+var DefaultShareSortOrder = []string{SortByAllocatableCores, SortByAllocatableMemory, SortByID}
+
+// ShareSortCriteria are the names ShareSortOrder can list
+var ShareSortCriteria = []string{SortByAllocatableCores, SortByAllocatableMemory, SortByID,
+	SortByUtilization, SortByTemperature, SortByPower, SortByNumberOfContainer}
+
+// Config is the policy of GPU allocation, which applies to all nodes unless
+// it's overridden by node annotations
+type Config struct {
+	// CoreOvercommitRatio scales the schedulable cores of each GPU device,
+	// e.g. 1.5 makes a device advertise 150 cores. Memory is never
+	// overcommitted.
+	CoreOvercommitRatio float64 `json:"coreOvercommitRatio"`
+	// MaxContainersPerDevice limits the number of containers sharing a GPU
+	// device regardless of its remaining cores and memory, 0 means no limit
+	MaxContainersPerDevice uint `json:"maxContainersPerDevice"`
+	// MaxDevicesPerPod limits the number of GPU devices the containers of
+	// a pod take together, so that a pod can't monopolize a node by
+	// mistake, 0 means no limit
+	MaxDevicesPerPod uint `json:"maxDevicesPerPod"`
+	// ReservedCoresPerDevice and ReservedMemoryPerDevice are kept for the
+	// system on each GPU device, e.g. display or monitoring, the
+	// schedulable capacity of a device is its total minus the reserved
+	ReservedCoresPerDevice  uint `json:"reservedCoresPerDevice"`
+	ReservedMemoryPerDevice uint `json:"reservedMemoryPerDevice"`
+	// MinFreeMemoryPerDevice is the vmemory a GPU device keeps free after
+	// placing a shared container, a device which would be left with less
+	// is infeasible. It keeps a device from being filled to the brim. A
+	// device whose schedulable memory isn't more is never shared, it's
+	// logged once the node is known.
+	MinFreeMemoryPerDevice uint `json:"minFreeMemoryPerDevice"`
+	// NodePolicy tells which node is preferred among the feasible ones,
+	// PackPolicy or SpreadPolicy
+	NodePolicy string `json:"nodePolicy"`
+	// SharePolicy tells how a container requesting less than a GPU is
+	// allocated, ShareAllowed, ShareRejected or ShareRoundedUp. The
+	// clusters running exclusive containers only disable share mode by
+	// the latter two. It applies to the pods of all of the profiles, as
+	// the GPUs are charged by it.
+	SharePolicy string `json:"sharePolicy"`
+	// DisableExclusive rejects a container requesting a GPU or more, and
+	// the whole node pods, instead of giving them whole GPUs. The clusters
+	// dedicated to sharing the GPUs take such a request as a mistake. It
+	// requires the share policy ShareAllowed.
+	DisableExclusive bool `json:"disableExclusive"`
+	// ShareWeights are the weights share mode ranks the devices by, see
+	// DefaultShareWeights
+	ShareWeights []float64 `json:"shareWeights"`
+	// ShareWeighting tells how the weights of the criteria are decided,
+	// StaticWeighting, EntropyWeighting or CRITICWeighting
+	ShareWeighting string `json:"shareWeighting"`
+	// ShareSortOrder are the criteria share mode sorts the candidate
+	// devices by in ascending order before ranking them, the later ones
+	// break the ties of the former ones. The first of the devices ranked
+	// the same is chosen. See ShareSortCriteria for the names.
+	ShareSortOrder []string `json:"shareSortOrder"`
+	// DistinctOwnersWeight is the weight of the number of distinct workloads
+	// on a device, counting the pod's own, when share mode ranks the
+	// devices. The more workloads interfere with each other, the less the
+	// device is preferred. 0 disables the criterion.
+	DistinctOwnersWeight float64 `json:"distinctOwnersWeight"`
+	// TimeOverlapWeight is the weight of how long a container would run
+	// together with the containers on a device, by their estimated times,
+	// when share mode ranks the devices. A long container is preferably
+	// paired with short ones. 0 disables the criterion.
+	TimeOverlapWeight float64 `json:"timeOverlapWeight"`
+	// TimeSimilarityWeight is the weight of how far the remaining
+	// estimated times of the containers on a device are from the estimated
+	// time of a container, when share mode ranks the devices. Jobs of
+	// comparable duration are grouped on a device, so a short job isn't
+	// time-sliced with long ones for its whole run. A device running no
+	// container with an estimated time is as far as the others on average.
+	// 0 disables the criterion. It's not set with TimeOverlapWeight, which
+	// pairs the jobs the other way.
+	TimeSimilarityWeight float64 `json:"timeSimilarityWeight"`
+	// FragmentationWeight in [0, 1] is how much the node score of the
+	// prioritize verb counts the fragmentation of the GPUs after placing
+	// the pod, instead of the node policy. 0 disables it.
+	FragmentationWeight float64 `json:"fragmentationWeight"`
+	// FairnessWeight in [0, 1] is how much the prioritize verb lowers the
+	// scores of the nodes held by the namespace of a pod which holds more
+	// than its fair share of the GPU resources charged in the cluster, see
+	// algorithm.QuotaTracker.ExcessShare. 0 disables it.
+	FairnessWeight float64 `json:"fairnessWeight"`
+	// LowPriorityThreshold sorts the pods by their priority, those of a
+	// lower priority pack into the busy GPUs when shared, leaving the
+	// emptier ones to the pods of a higher priority. A pod without priority
+	// has priority 0. Nil places all of the pods the same.
+	LowPriorityThreshold *int32 `json:"lowPriorityThreshold,omitempty"`
+	// MaxECCErrors excludes the GPU devices whose ECC error count reported
+	// by the node is above it from the placement candidates, 0 excludes a
+	// device with any error. Nil excludes none.
+	MaxECCErrors *uint `json:"maxECCErrors,omitempty"`
+	// RejectInconsistentMemoryBlocks rejects the pods on a node whose GPU
+	// devices have different memory block sizes, or a memory which isn't
+	// a multiple of its blocks. Such a node is only logged otherwise.
+	RejectInconsistentMemoryBlocks bool `json:"rejectInconsistentMemoryBlocks"`
+	// RecordAllocatedCondition sets the pod condition GPUAllocated telling
+	// the node and the GPU devices of the pod before the bind verb binds
+	// it, so that the tools can watch the condition instead of parsing the
+	// annotations. It costs an API call of the pod status per binding.
+	RecordAllocatedCondition bool `json:"recordAllocatedCondition"`
+	// OwnerLabel is the label telling the workload of a pod, the controller
+	// of the pod is the workload if it's empty or absent
+	OwnerLabel string `json:"ownerLabel"`
+	// FilterCacheSize is the max number of filter results cached, keyed
+	// by the pod's GPU request and the state of the node, 0 disables the
+	// cache. A result expires after FilterCacheTTL.
+	FilterCacheSize uint     `json:"filterCacheSize"`
+	FilterCacheTTL  Duration `json:"filterCacheTTL"`
+	// MaxNodeStateAge is how long since the informers of the nodes and
+	// the pods were last seen alive, by an event or a resync every 30s, the
+	// state of the nodes is trusted, a node older than it or never seen is
+	// rejected for the pod to retry later. 0 trusts the state however old
+	// it is.
+	MaxNodeStateAge Duration `json:"maxNodeStateAge"`
+	// DefaultEstimatedTime is the estimated time of a container whose pod
+	// has neither its indexed nor the shared estimated time annotation.
+	// Nil requires one of the annotations.
+	DefaultEstimatedTime *Duration `json:"defaultEstimatedTime,omitempty"`
+	// NamespaceQuotas cap the vcore and vmemory the pods of a namespace
+	// use across the cluster, keyed by the namespace. The namespaces absent
+	// are not capped.
+	NamespaceQuotas map[string]Quota `json:"namespaceQuotas"`
+	// Keys are the names of annotations and resources
+	Keys Keys `json:"keys"`
+	// Vendors are the names of the resources of the GPU devices of the
+	// vendors other than DefaultVendor, keyed by the vendor a node tells by
+	// the GPUVendor annotation
+	Vendors map[string]VendorKeys `json:"vendors"`
+	// Profiles override the allocation policy for the pods of a scheduler
+	// profile, keyed by the scheduler name of the pod. Pods of any other
+	// scheduler use the policy above.
+	Profiles map[string]Profile `json:"profiles"`
+}
+
+// Quota is the GPU resources a namespace can use, 0 means no limit
+type Quota struct {
+	Cores  uint `json:"cores"`
+	Memory uint `json:"memory"`
+}
+
+// Profile overrides the fields of Config it sets
+type Profile struct {
+	CoreOvercommitRatio     *float64  `json:"coreOvercommitRatio,omitempty"`
+	MaxContainersPerDevice  *uint     `json:"maxContainersPerDevice,omitempty"`
+	ReservedCoresPerDevice  *uint     `json:"reservedCoresPerDevice,omitempty"`
+	ReservedMemoryPerDevice *uint     `json:"reservedMemoryPerDevice,omitempty"`
+	MinFreeMemoryPerDevice  *uint     `json:"minFreeMemoryPerDevice,omitempty"`
+	NodePolicy              *string   `json:"nodePolicy,omitempty"`
+	ShareWeights            []float64 `json:"shareWeights,omitempty"`
+	ShareWeighting          *string   `json:"shareWeighting,omitempty"`
+	ShareSortOrder          []string  `json:"shareSortOrder,omitempty"`
+	DistinctOwnersWeight    *float64  `json:"distinctOwnersWeight,omitempty"`
+	TimeOverlapWeight       *float64  `json:"timeOverlapWeight,omitempty"`
+	TimeSimilarityWeight    *float64  `json:"timeSimilarityWeight,omitempty"`
+	FragmentationWeight     *float64  `json:"fragmentationWeight,omitempty"`
+	FairnessWeight          *float64  `json:"fairnessWeight,omitempty"`
+	LowPriorityThreshold    *int32    `json:"lowPriorityThreshold,omitempty"`
+	MaxECCErrors            *uint     `json:"maxECCErrors,omitempty"`
+	OwnerLabel              *string   `json:"ownerLabel,omitempty"`
+}
+
+// Default returns the config which keeps the original behavior
+func Default() *Config {
+	return &Config{
+		CoreOvercommitRatio: DefaultCoreOvercommitRatio,
+		NodePolicy:          PackPolicy,
+		SharePolicy:         ShareAllowed,
+		ShareWeights:        append([]float64(nil), DefaultShareWeights...),
+		ShareWeighting:      StaticWeighting,
+		ShareSortOrder:      append([]string(nil), DefaultShareSortOrder...),
+		FilterCacheTTL:      Duration{DefaultFilterCacheTTL},
+		Keys:                DefaultKeys(),
+	}
+}
+
+// Load reads the config in JSON from given file into c, the fields absent
+// from the file keep their values
+func (c *Config) Load(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config %s: %v", path, err)
+	}
+	if err := c.Parse(data); err != nil {
+		return fmt.Errorf("failed to parse config %s: %v", path, err)
+	}
+	return nil
+}
+
+// Parse reads the config in JSON from data into c, the fields absent from
+// data keep their values
+func (c *Config) Parse(data []byte) error {
+	return json.Unmarshal(data, c)
+}
+
+// ForProfile returns the config of the pods of given scheduler profile,
+// which is c itself if the profile doesn't override anything
+func (c *Config) ForProfile(name string) *Config {
+	p, ok := c.Profiles[name]
+	if !ok {
+		return c
+	}
+	cfg := *c
+	cfg.Profiles = nil
+	if p.CoreOvercommitRatio != nil {
+		cfg.CoreOvercommitRatio = *p.CoreOvercommitRatio
+	}
+	if p.MaxContainersPerDevice != nil {
+		cfg.MaxContainersPerDevice = *p.MaxContainersPerDevice
+	}
+	if p.ReservedCoresPerDevice != nil {
+		cfg.ReservedCoresPerDevice = *p.ReservedCoresPerDevice
+	}
+	if p.ReservedMemoryPerDevice != nil {
+		cfg.ReservedMemoryPerDevice = *p.ReservedMemoryPerDevice
+	}
+	if p.MinFreeMemoryPerDevice != nil {
+		cfg.MinFreeMemoryPerDevice = *p.MinFreeMemoryPerDevice
+	}
+	if p.NodePolicy != nil {
+		cfg.NodePolicy = *p.NodePolicy
+	}
+	if p.ShareWeights != nil {
+		cfg.ShareWeights = p.ShareWeights
+	}
+	if p.ShareWeighting != nil {
+		cfg.ShareWeighting = *p.ShareWeighting
+	}
+	if p.ShareSortOrder != nil {
+		cfg.ShareSortOrder = p.ShareSortOrder
+	}
+	if p.DistinctOwnersWeight != nil {
+		cfg.DistinctOwnersWeight = *p.DistinctOwnersWeight
+	}
+	if p.TimeOverlapWeight != nil {
+		cfg.TimeOverlapWeight = *p.TimeOverlapWeight
+	}
+	if p.TimeSimilarityWeight != nil {
+		cfg.TimeSimilarityWeight = *p.TimeSimilarityWeight
+	}
+	if p.FragmentationWeight != nil {
+		cfg.FragmentationWeight = *p.FragmentationWeight
+	}
+	if p.FairnessWeight != nil {
+		cfg.FairnessWeight = *p.FairnessWeight
+	}
+	if p.LowPriorityThreshold != nil {
+		cfg.LowPriorityThreshold = p.LowPriorityThreshold
+	}
+	if p.MaxECCErrors != nil {
+		cfg.MaxECCErrors = p.MaxECCErrors
+	}
+	if p.OwnerLabel != nil {
+		cfg.OwnerLabel = *p.OwnerLabel
+	}
+	return &cfg
+}
+
+// Validate checks if the config is usable
+func (c *Config) Validate() error {
+	if err := c.validatePolicy(); err != nil {
+		return err
+	}
+	for name := range c.Profiles {
+		if err := c.ForProfile(name).validatePolicy(); err != nil {
+			return fmt.Errorf("invalid profile %s: %v", name, err)
+		}
+	}
+	switch c.SharePolicy {
+	case ShareAllowed, ShareRejected, ShareRoundedUp:
+	default:
+		return fmt.Errorf("invalid share policy %q, expect %s, %s or %s", c.SharePolicy,
+			ShareAllowed, ShareRejected, ShareRoundedUp)
+	}
+	if c.DisableExclusive && c.SharePolicy != ShareAllowed {
+		return fmt.Errorf("invalid share policy %s with exclusive mode disabled, expect %s",
+			c.SharePolicy, ShareAllowed)
+	}
+	if c.FilterCacheSize > 0 && c.FilterCacheTTL.Duration <= 0 {
+		return fmt.Errorf("invalid filter cache TTL %v, expect a positive duration", c.FilterCacheTTL)
+	}
+	if c.MaxNodeStateAge.Duration < 0 {
+		return fmt.Errorf("invalid max node state age %v, expect a non-negative duration", c.MaxNodeStateAge)
+	}
+	if d := c.DefaultEstimatedTime; d != nil && d.Duration < 0 {
+		return fmt.Errorf("invalid default estimated time %v, expect a non-negative duration", *d)
+	}
+	for namespace := range c.NamespaceQuotas {
+		if namespace == "" {
+			return fmt.Errorf("invalid namespace quota, expect a namespace")
+		}
+	}
+	if err := c.Keys.Validate(); err != nil {
+		return fmt.Errorf("invalid keys: %v", err)
+	}
+	if err := c.validateVendors(); err != nil {
+		return fmt.Errorf("invalid vendors: %v", err)
+	}
+	return nil
+}
+
+// validatePolicy checks the fields a profile can override
+func (c *Config) validatePolicy() error {
+	if math.IsNaN(c.CoreOvercommitRatio) || c.CoreOvercommitRatio < DefaultCoreOvercommitRatio ||
+		c.CoreOvercommitRatio > MaxCoreOvercommitRatio {
+		return fmt.Errorf("invalid core overcommit ratio %v, expect [%v, %v]", c.CoreOvercommitRatio,
+			DefaultCoreOvercommitRatio, MaxCoreOvercommitRatio)
+	}
+	if cores := uint(math.Round(c.CoreOvercommitRatio * HundredCore)); c.ReservedCoresPerDevice >= cores {
+		return fmt.Errorf("invalid reserved cores %d, expect less than the %d cores of a GPU",
+			c.ReservedCoresPerDevice, cores)
+	}
+	if c.NodePolicy != PackPolicy && c.NodePolicy != SpreadPolicy {
+		return fmt.Errorf("invalid node policy %q, expect %s or %s", c.NodePolicy, PackPolicy, SpreadPolicy)
+	}
+	if len(c.ShareWeights) != len(DefaultShareWeights) {
+		return fmt.Errorf("invalid share weights %v, expect %d weights", c.ShareWeights, len(DefaultShareWeights))
+	}
+	var sum float64
+	for _, w := range c.ShareWeights {
+		if math.IsNaN(w) || math.IsInf(w, 0) || w < 0 {
+			return fmt.Errorf("invalid share weights %v, expect non-negative weights", c.ShareWeights)
+		}
+		sum += w
+	}
+	if sum == 0 {
+		return fmt.Errorf("invalid share weights %v, expect a positive weight at least", c.ShareWeights)
+	}
+	switch c.ShareWeighting {
+	case StaticWeighting, EntropyWeighting, CRITICWeighting:
+	default:
+		return fmt.Errorf("invalid share weighting %q, expect %s, %s or %s", c.ShareWeighting,
+			StaticWeighting, EntropyWeighting, CRITICWeighting)
+	}
+	if len(c.ShareSortOrder) == 0 {
+		return fmt.Errorf("invalid share sort order, expect a criterion at least")
+	}
+	for _, name := range c.ShareSortOrder {
+		if !isShareSortCriterion(name) {
+			return fmt.Errorf("invalid share sort criterion %q, expect one of %s", name,
+				strings.Join(ShareSortCriteria, ", "))
+		}
+	}
+	if w := c.DistinctOwnersWeight; math.IsNaN(w) || math.IsInf(w, 0) || w < 0 {
+		return fmt.Errorf("invalid distinct owners weight %v, expect a non-negative weight", w)
+	}
+	if w := c.TimeOverlapWeight; math.IsNaN(w) || math.IsInf(w, 0) || w < 0 {
+		return fmt.Errorf("invalid time overlap weight %v, expect a non-negative weight", w)
+	}
+	if w := c.TimeSimilarityWeight; math.IsNaN(w) || math.IsInf(w, 0) || w < 0 {
+		return fmt.Errorf("invalid time similarity weight %v, expect a non-negative weight", w)
+	}
+	// one pairs a long job with short ones, the other with long ones
+	if c.TimeOverlapWeight > 0 && c.TimeSimilarityWeight > 0 {
+		return fmt.Errorf("time overlap weight %v and time similarity weight %v conflict, expect at most one of them",
+			c.TimeOverlapWeight, c.TimeSimilarityWeight)
+	}
+	if w := c.FragmentationWeight; math.IsNaN(w) || w < 0 || w > 1 {
+		return fmt.Errorf("invalid fragmentation weight %v, expect a weight in [0, 1]", w)
+	}
+	if w := c.FairnessWeight; math.IsNaN(w) || w < 0 || w > 1 {
+		return fmt.Errorf("invalid fairness weight %v, expect a weight in [0, 1]", w)
+	}
+	return nil
+}
+
+// ClampCoreOvercommitRatio limits the ratio to
+// [DefaultCoreOvercommitRatio, MaxCoreOvercommitRatio], so cores are never
+// undercommitted
+func ClampCoreOvercommitRatio(ratio float64) float64 {
+	switch {
+	case math.IsNaN(ratio) || ratio < DefaultCoreOvercommitRatio:
+		return DefaultCoreOvercommitRatio
+	case ratio > MaxCoreOvercommitRatio:
+		return MaxCoreOvercommitRatio
+	}
+	return ratio
+}
+
+func isShareSortCriterion(name string) bool {
+	for _, criterion := range ShareSortCriteria {
+		if name == criterion {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package config
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+// DefaultConfigMapKey is the key of the config in the ConfigMap
+const DefaultConfigMapKey = "config.json"
+
+// LoadConfigMap reads the config in JSON under key of the ConfigMap
+// namespace/name into c, the fields absent from it keep their values
+func (c *Config) LoadConfigMap(client kubernetes.Interface, namespace, name, key string) error {
+	cm, err := client.CoreV1().ConfigMaps(namespace).Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get ConfigMap %s/%s: %v", namespace, name, err)
+	}
+	_, err = loadConfigMap(cm, key, func(data []byte) (*Config, error) {
+		return c, c.Parse(data)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to parse ConfigMap %s/%s: %v", namespace, name, err)
+	}
+	return nil
+}
+
+// WatchConfigMap calls load with the config under key of the ConfigMap
+// namespace/name whenever it's created or updated, and passes the new
+// config to apply, until stopCh is closed. A config failed to be loaded or
+// applied is logged and ignored, the previous one is kept, and so is it
+// when the ConfigMap is deleted.
+func WatchConfigMap(client kubernetes.Interface, namespace, name, key string,
+	load func(data []byte) (*Config, error), apply func(*Config) error, stopCh <-chan struct{}) {
+	factory := kubeinformers.NewSharedInformerFactoryWithOptions(client, 0,
+		kubeinformers.WithNamespace(namespace),
+		kubeinformers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+		}))
+
+	ref := namespace + "/" + name
+	reload := func(obj interface{}) {
+		cm, ok := obj.(*corev1.ConfigMap)
+		if !ok || cm.Name != name {
+			return
+		}
+		cfg, err := loadConfigMap(cm, key, load)
+		if err == nil {
+			err = apply(cfg)
+		}
+		if err != nil {
+			klog.Errorf("Rejected config of ConfigMap %s, keep the previous one: %v", ref, err)
+			return
+		}
+		klog.Infof("Reloaded config of ConfigMap %s(%s)", ref, cm.ResourceVersion)
+	}
+	factory.Core().V1().ConfigMaps().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: reload,
+		UpdateFunc: func(_, obj interface{}) {
+			reload(obj)
+		},
+		DeleteFunc: func(interface{}) {
+			klog.Warningf("ConfigMap %s is deleted, keep the config in use", ref)
+		},
+	})
+	factory.Start(stopCh)
+}
+
+// loadConfigMap calls load with the config under key of the ConfigMap
+func loadConfigMap(cm *corev1.ConfigMap, key string,
+	load func(data []byte) (*Config, error)) (*Config, error) {
+	data, ok := cm.Data[key]
+	if !ok {
+		return nil, fmt.Errorf("no key %s", key)
+	}
+	return load([]byte(data))
+}
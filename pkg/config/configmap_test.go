@@ -0,0 +1,80 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package config
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestConfigMap(t *testing.T) {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "gpu-admission"},
+		Data:       map[string]string{DefaultConfigMapKey: `{"coreOvercommitRatio": 1.5}`},
+	}
+	client := fake.NewSimpleClientset(cm)
+
+	cfg := Default()
+	if err := cfg.LoadConfigMap(client, "kube-system", "gpu-admission", DefaultConfigMapKey); err != nil {
+		t.Fatalf("failed to load ConfigMap: %v", err)
+	}
+	if cfg.CoreOvercommitRatio != 1.5 {
+		t.Fatalf("expect ratio 1.5, got %v", cfg.CoreOvercommitRatio)
+	}
+	if err := cfg.LoadConfigMap(client, "kube-system", "gpu-admission", "other.json"); err == nil {
+		t.Fatalf("expect an error of missing key")
+	}
+
+	store := NewStore(cfg)
+	load := func(data []byte) (*Config, error) {
+		cfg := Default()
+		return cfg, cfg.Parse(data)
+	}
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	WatchConfigMap(client, "kube-system", "gpu-admission", DefaultConfigMapKey, load, store.Swap, stopCh)
+
+	waitFor := func(ratio float64) {
+		deadline := time.Now().Add(5 * time.Second)
+		for store.Load().CoreOvercommitRatio != ratio {
+			if time.Now().After(deadline) {
+				t.Fatalf("expect ratio %v, got %v", ratio, store.Load().CoreOvercommitRatio)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	update := func(data string) {
+		cm.Data[DefaultConfigMapKey] = data
+		cm.ResourceVersion += "1"
+		if _, err := client.CoreV1().ConfigMaps("kube-system").Update(context.Background(), cm,
+			metav1.UpdateOptions{}); err != nil {
+			t.Fatalf("failed to update ConfigMap: %v", err)
+		}
+	}
+
+	update(`{"coreOvercommitRatio": 2}`)
+	waitFor(2)
+	// an invalid config is rejected, then a valid one is applied again
+	update(`{"coreOvercommitRatio": 20}`)
+	update(`{"coreOvercommitRatio": 3}`)
+	waitFor(3)
+}
@@ -0,0 +1,185 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// DefaultDomain is the domain of the default annotation and resource keys
+const DefaultDomain = "tencent.com"
+
+// Keys are the names of the annotations and resources used by
+// gpu-admission. A name without "/" is prefixed by Domain, e.g. vcuda-core
+// means tencent.com/vcuda-core by default, while a name with "/" is used as
+// is. The names ending with "-" are prefixes followed by container index.
+type Keys struct {
+	Domain string `json:"domain"`
+
+	// resources of node capacity and container limits
+	VCore             string `json:"vcore"`
+	VMemory           string `json:"vmemory"`
+	MIGResourcePrefix string `json:"migResourcePrefix"`
+
+	// annotations written by gpu-admission
+	PredicateTime               string `json:"predicateTime"`
+	PredicateGPUIndexPrefix     string `json:"predicateGPUIndexPrefix"`
+	PredicateGPUInitIndexPrefix string `json:"predicateGPUInitIndexPrefix"`
+	PredicateGPUUUIDPrefix      string `json:"predicateGPUUUIDPrefix"`
+	PredicateGPUInitUUIDPrefix  string `json:"predicateGPUInitUUIDPrefix"`
+	PredicateMIGInstancePrefix  string `json:"predicateMIGInstancePrefix"`
+	PredicateRelaxedConstraints string `json:"predicateRelaxedConstraints"`
+	PredicateStageTimings       string `json:"predicateStageTimings"`
+	PredicateRoundedUp          string `json:"predicateRoundedUp"`
+	PredicateNode               string `json:"predicateNode"`
+	GPUAssigned                 string `json:"gpuAssigned"`
+
+	// annotations of pods
+	EstimatedTimePrefix string `json:"estimatedTimePrefix"`
+	EstimatedTime       string `json:"estimatedTime"`
+	GPUModel            string `json:"gpuModel"`
+	GPUColocate         string `json:"gpuColocate"`
+	GPUAntiAffinity     string `json:"gpuAntiAffinity"`
+	GPUWholeNode        string `json:"gpuWholeNode"`
+	GPUGangName         string `json:"gpuGangName"`
+	GPUGangSize         string `json:"gpuGangSize"`
+	GPUPinDevices       string `json:"gpuPinDevices"`
+	GPUForbidDevices    string `json:"gpuForbidDevices"`
+	GPUSoftConstraints  string `json:"gpuSoftConstraints"`
+	GPUMinCC            string `json:"gpuMinCC"`
+
+	// annotations of nodes
+	UnhealthyGPUIndexes    string `json:"unhealthyGPUIndexes"`
+	DrainingGPUIndexes     string `json:"drainingGPUIndexes"`
+	GPUBlacklist           string `json:"gpuBlacklist"`
+	GPUNUMANodes           string `json:"gpuNUMANodes"`
+	GPUMemories            string `json:"gpuMemories"`
+	GPUCoreOvercommitRatio string `json:"gpuCoreOvercommitRatio"`
+	GPUModels              string `json:"gpuModels"`
+	GPUUUIDs               string `json:"gpuUUIDs"`
+	GPUMIGInstances        string `json:"gpuMIGInstances"`
+	GPUUtilizations        string `json:"gpuUtilizations"`
+	GPUTemperatures        string `json:"gpuTemperatures"`
+	GPUPowers              string `json:"gpuPowers"`
+	GPUComputeCapabilities string `json:"gpuComputeCapabilities"`
+	GPUECCErrors           string `json:"gpuECCErrors"`
+	GPUVendor              string `json:"gpuVendor"`
+	GPURDMANICs            string `json:"gpuRDMANICs"`
+	GPUTopology            string `json:"gpuTopology"`
+	GPUMemoryBlocks        string `json:"gpuMemoryBlocks"`
+}
+
+// DefaultKeys returns the keys used by gpu-manager and gpu-admission
+// originally
+func DefaultKeys() Keys {
+	return Keys{
+		Domain: DefaultDomain,
+
+		VCore:             "vcuda-core",
+		VMemory:           "vcuda-memory",
+		MIGResourcePrefix: "mig-",
+
+		PredicateTime:               "predicate-time",
+		PredicateGPUIndexPrefix:     "predicate-gpu-idx-",
+		PredicateGPUInitIndexPrefix: "predicate-gpu-init-idx-",
+		PredicateGPUUUIDPrefix:      "predicate-gpu-uuid-",
+		PredicateGPUInitUUIDPrefix:  "predicate-gpu-init-uuid-",
+		PredicateMIGInstancePrefix:  "predicate-mig-instance-",
+		PredicateRelaxedConstraints: "predicate-relaxed-constraints",
+		PredicateStageTimings:       "predicate-stage-timings",
+		PredicateRoundedUp:          "predicate-rounded-up",
+		PredicateNode:               "predicate-node",
+		GPUAssigned:                 "gpu-assigned",
+
+		EstimatedTimePrefix: "estimated-time-",
+		EstimatedTime:       "estimated-time",
+		GPUModel:            "gpu-model",
+		GPUColocate:         "gpu-container-colocate",
+		GPUAntiAffinity:     "gpu-container-anti-affinity",
+		GPUWholeNode:        "gpu-whole-node",
+		GPUGangName:         "gpu-gang-name",
+		GPUGangSize:         "gpu-gang-size",
+		GPUPinDevices:       "gpu-pin-devices",
+		GPUForbidDevices:    "gpu-forbid-devices",
+		GPUSoftConstraints:  "gpu-soft-constraints",
+		GPUMinCC:            "gpu-min-cc",
+
+		UnhealthyGPUIndexes:    "unhealthy-gpu-idx",
+		DrainingGPUIndexes:     "draining-gpu-idx",
+		GPUBlacklist:           "gpu-blacklist",
+		GPUNUMANodes:           "gpu-numa-nodes",
+		GPUMemories:            "gpu-memories",
+		GPUCoreOvercommitRatio: "gpu-core-overcommit-ratio",
+		GPUModels:              "gpu-models",
+		GPUUUIDs:               "gpu-uuids",
+		GPUMIGInstances:        "gpu-mig-instances",
+		GPUUtilizations:        "gpu-utilizations",
+		GPUTemperatures:        "gpu-temperatures",
+		GPUPowers:              "gpu-powers",
+		GPUComputeCapabilities: "gpu-compute-capabilities",
+		GPUECCErrors:           "gpu-ecc-errors",
+		GPUVendor:              "gpu-vendor",
+		GPURDMANICs:            "gpu-rdma-nics",
+		GPUTopology:            "gpu-topology",
+		GPUMemoryBlocks:        "gpu-memory-blocks",
+	}
+}
+
+// Resolve returns the full names of the keys, that is, the names without
+// "/" are prefixed by Domain. Domain of the result is kept.
+func (k Keys) Resolve() Keys {
+	ret := k
+	v := reflect.ValueOf(&ret).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if v.Type().Field(i).Name == "Domain" {
+			continue
+		}
+		name := v.Field(i).String()
+		if name != "" && !strings.Contains(name, "/") {
+			v.Field(i).SetString(k.Domain + "/" + name)
+		}
+	}
+	return ret
+}
+
+// Validate checks that all of the keys are non-empty and distinct
+func (k Keys) Validate() error {
+	raw := reflect.ValueOf(k)
+	resolved := reflect.ValueOf(k.Resolve())
+	seen := make(map[string]string)
+	for i := 0; i < raw.NumField(); i++ {
+		field := raw.Type().Field(i).Name
+		if field == "Domain" {
+			continue
+		}
+		name := raw.Field(i).String()
+		switch {
+		case name == "":
+			return fmt.Errorf("key %s is empty", field)
+		case !strings.Contains(name, "/") && k.Domain == "":
+			return fmt.Errorf("key %s needs a domain: %s", field, name)
+		}
+		name = resolved.Field(i).String()
+		if other, ok := seen[name]; ok {
+			return fmt.Errorf("key %s collides with %s: %s", field, other, name)
+		}
+		seen[name] = field
+	}
+	return nil
+}
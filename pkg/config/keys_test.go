@@ -0,0 +1,248 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package config
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestKeysResolve(t *testing.T) {
+	keys := DefaultKeys()
+	if got := keys.Resolve().VCore; got != "tencent.com/vcuda-core" {
+		t.Fatalf("got %s, expect tencent.com/vcuda-core", got)
+	}
+
+	keys.Domain = "example.com"
+	keys.GPUAssigned = "gpu.example.com/assigned"
+	resolved := keys.Resolve()
+	if resolved.PredicateGPUIndexPrefix != "example.com/predicate-gpu-idx-" {
+		t.Fatalf("got %s, expect example.com/predicate-gpu-idx-", resolved.PredicateGPUIndexPrefix)
+	}
+	if resolved.GPUAssigned != "gpu.example.com/assigned" {
+		t.Fatalf("got %s, expect gpu.example.com/assigned", resolved.GPUAssigned)
+	}
+	if resolved.Domain != "example.com" {
+		t.Fatalf("domain should be kept, got %s", resolved.Domain)
+	}
+}
+
+func TestKeysValidate(t *testing.T) {
+	testCases := []struct {
+		name   string
+		modify func(k *Keys)
+		valid  bool
+	}{
+		{name: "default", modify: func(k *Keys) {}, valid: true},
+		{name: "empty key", modify: func(k *Keys) { k.GPUModel = "" }},
+		{name: "no domain", modify: func(k *Keys) { k.Domain = "" }},
+		{name: "collision", modify: func(k *Keys) { k.GPUModel = k.GPUModels }},
+		{name: "collision of full name", modify: func(k *Keys) { k.GPUModel = "tencent.com/gpu-models" }},
+	}
+
+	for _, cs := range testCases {
+		keys := DefaultKeys()
+		cs.modify(&keys)
+		if err := keys.Validate(); (err == nil) != cs.valid {
+			t.Fatalf("%s: expect valid %v, got %v", cs.name, cs.valid, err)
+		}
+	}
+}
+
+func TestConfigLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gpu-admission")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.json")
+	data := `{"coreOvercommitRatio": 1.5, "filterCacheTTL": "10s", "keys": {"domain": "example.com"}}`
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	cfg := Default()
+	if err := cfg.Load(path); err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	if cfg.CoreOvercommitRatio != 1.5 || cfg.Keys.Domain != "example.com" ||
+		cfg.FilterCacheTTL.Duration != 10*time.Second {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+	// the keys absent from the file keep the defaults
+	if cfg.Keys.VCore != "vcuda-core" {
+		t.Fatalf("got %s, expect vcuda-core", cfg.Keys.VCore)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("config should be valid: %v", err)
+	}
+}
+
+func TestConfigValidateNodePolicy(t *testing.T) {
+	for policy, valid := range map[string]bool{
+		PackPolicy:   true,
+		SpreadPolicy: true,
+		"":           false,
+		"binpack":    false,
+	} {
+		cfg := Default()
+		cfg.NodePolicy = policy
+		if err := cfg.Validate(); (err == nil) != valid {
+			t.Fatalf("policy %q: expect valid %v, got %v", policy, valid, err)
+		}
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	ratio, policy := 20.0, "binpack"
+	testCases := []struct {
+		name   string
+		modify func(c *Config)
+	}{
+		{name: "negative weight", modify: func(c *Config) { c.ShareWeights = []float64{0.5, -0.1, 0.3, 0.3} }},
+		{name: "NaN weight", modify: func(c *Config) { c.ShareWeights[0] = math.NaN() }},
+		{name: "zero weights", modify: func(c *Config) { c.ShareWeights = []float64{0, 0, 0, 0} }},
+		{name: "missing weight", modify: func(c *Config) { c.ShareWeights = []float64{0.5, 0.5} }},
+		{name: "negative distinct owners weight", modify: func(c *Config) { c.DistinctOwnersWeight = -1 }},
+		{name: "negative time overlap weight", modify: func(c *Config) { c.TimeOverlapWeight = -1 }},
+		{name: "negative time similarity weight", modify: func(c *Config) { c.TimeSimilarityWeight = -1 }},
+		{name: "both time weights", modify: func(c *Config) { c.TimeOverlapWeight, c.TimeSimilarityWeight = 1, 1 }},
+		{name: "fragmentation weight above 1", modify: func(c *Config) { c.FragmentationWeight = 1.5 }},
+		{name: "negative fairness weight", modify: func(c *Config) { c.FairnessWeight = -0.5 }},
+		{name: "unknown weighting", modify: func(c *Config) { c.ShareWeighting = "ahp" }},
+		{name: "empty share sort order", modify: func(c *Config) { c.ShareSortOrder = nil }},
+		{name: "quota without namespace", modify: func(c *Config) { c.NamespaceQuotas = map[string]Quota{"": {Cores: 100}} }},
+		{name: "unknown share sort criterion", modify: func(c *Config) { c.ShareSortOrder = []string{"id", "age"} }},
+		{name: "unknown strategy", modify: func(c *Config) { c.NodePolicy = "binpack" }},
+		{name: "unknown share policy", modify: func(c *Config) { c.SharePolicy = "exclusive" }},
+		{name: "share and exclusive disabled", modify: func(c *Config) {
+			c.SharePolicy = ShareRejected
+			c.DisableExclusive = true
+		}},
+		{name: "undercommit", modify: func(c *Config) { c.CoreOvercommitRatio = 0.5 }},
+		{name: "overcommit too much", modify: func(c *Config) { c.CoreOvercommitRatio = 20 }},
+		{name: "reserve all cores", modify: func(c *Config) { c.ReservedCoresPerDevice = 100 }},
+		{name: "empty annotation key", modify: func(c *Config) { c.Keys.GPUAssigned = "" }},
+		{name: "empty domain", modify: func(c *Config) { c.Keys.Domain = "" }},
+		{name: "vendor without name", modify: func(c *Config) {
+			c.Vendors = map[string]VendorKeys{"": {VCore: "amd-core", VMemory: "amd-memory"}}
+		}},
+		{name: "default vendor overridden", modify: func(c *Config) {
+			c.Vendors = map[string]VendorKeys{DefaultVendor: {VCore: "nv-core", VMemory: "nv-memory"}}
+		}},
+		{name: "vendor without memory resource", modify: func(c *Config) {
+			c.Vendors = map[string]VendorKeys{"amd": {VCore: "amd-core"}}
+		}},
+		{name: "vendor sharing the default resource", modify: func(c *Config) {
+			c.Vendors = map[string]VendorKeys{"amd": {VCore: "vcuda-core", VMemory: "amd-memory"}}
+		}},
+		{name: "zero cache TTL", modify: func(c *Config) {
+			c.FilterCacheSize = 10
+			c.FilterCacheTTL.Duration = 0
+		}},
+		{name: "negative node state age", modify: func(c *Config) {
+			c.MaxNodeStateAge.Duration = -time.Second
+		}},
+		{name: "negative default estimated time", modify: func(c *Config) {
+			c.DefaultEstimatedTime = &Duration{-time.Minute}
+		}},
+		{name: "invalid profile ratio", modify: func(c *Config) {
+			c.Profiles = map[string]Profile{"training": {CoreOvercommitRatio: &ratio}}
+		}},
+		{name: "unknown profile strategy", modify: func(c *Config) {
+			c.Profiles = map[string]Profile{"training": {NodePolicy: &policy}}
+		}},
+	}
+
+	if err := Default().Validate(); err != nil {
+		t.Fatalf("default config should be valid: %v", err)
+	}
+	for _, policy := range []string{ShareAllowed, ShareRejected, ShareRoundedUp} {
+		cfg := Default()
+		cfg.SharePolicy = policy
+		if err := cfg.Validate(); err != nil {
+			t.Fatalf("share policy %s should be valid: %v", policy, err)
+		}
+	}
+	for _, weighting := range []string{StaticWeighting, EntropyWeighting, CRITICWeighting} {
+		cfg := Default()
+		cfg.ShareWeighting = weighting
+		if err := cfg.Validate(); err != nil {
+			t.Fatalf("weighting %s should be valid: %v", weighting, err)
+		}
+	}
+	for _, cs := range testCases {
+		cfg := Default()
+		cs.modify(cfg)
+		if err := cfg.Validate(); err == nil {
+			t.Fatalf("%s: expect an error", cs.name)
+		}
+	}
+
+	cfg := Default()
+	cfg.Vendors = map[string]VendorKeys{"amd": {VCore: "amd.com/vcore", VMemory: "amd-memory"}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("vendor should be valid: %v", err)
+	}
+	if got := cfg.ResolvedVendors()["amd"]; got != (VendorKeys{VCore: "amd.com/vcore", VMemory: "tencent.com/amd-memory"}) {
+		t.Fatalf("unexpected resources of vendor amd %+v", got)
+	}
+
+	cfg = Default()
+	cfg.CoreOvercommitRatio = 2
+	cfg.ReservedCoresPerDevice = 150
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("150 of 200 overcommitted cores can be reserved: %v", err)
+	}
+}
+
+func TestConfigForProfile(t *testing.T) {
+	ratio, policy := 2.0, SpreadPolicy
+	cfg := Default()
+	cfg.Profiles = map[string]Profile{
+		"inference": {CoreOvercommitRatio: &ratio, NodePolicy: &policy},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("config should be valid: %v", err)
+	}
+
+	inference := cfg.ForProfile("inference")
+	if inference.CoreOvercommitRatio != 2 || inference.NodePolicy != SpreadPolicy ||
+		inference.Keys != cfg.Keys {
+		t.Fatalf("unexpected config of profile inference %+v", inference)
+	}
+	if cfg.NodePolicy != PackPolicy || cfg.CoreOvercommitRatio != DefaultCoreOvercommitRatio {
+		t.Fatalf("the profile should not change the default config %+v", cfg)
+	}
+	if cfg.ForProfile("unknown") != cfg {
+		t.Fatalf("unknown profiles should use the default config")
+	}
+
+	invalid := "binpack"
+	cfg.Profiles["training"] = Profile{NodePolicy: &invalid}
+	if err := cfg.Validate(); err == nil {
+		t.Fatalf("invalid policy of a profile should be rejected")
+	}
+	cfg.Profiles["training"] = Profile{ShareWeights: []float64{1, 1}}
+	if err := cfg.Validate(); err == nil {
+		t.Fatalf("share weights of a wrong length should be rejected")
+	}
+}
@@ -0,0 +1,110 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/fsnotify/fsnotify"
+	"k8s.io/klog"
+)
+
+// Store holds the config in use, it's swapped as a whole so a reader never
+// sees a config half applied
+type Store struct {
+	v atomic.Value
+}
+
+// NewStore returns a store holding given config
+func NewStore(c *Config) *Store {
+	s := &Store{}
+	s.v.Store(c)
+	return s
+}
+
+// Load returns the config in use, which must not be modified
+func (s *Store) Load() *Config {
+	return s.v.Load().(*Config)
+}
+
+// Swap replaces the config in use by c if it's valid. The keys can't be
+// changed at runtime, since the annotations already written by the old keys
+// would be lost.
+func (s *Store) Swap(c *Config) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	if old := s.Load(); old.Keys != c.Keys {
+		return fmt.Errorf("keys can't be changed without restart")
+	}
+	s.v.Store(c)
+	return nil
+}
+
+// Watch calls load whenever the config file at path changes and passes the
+// new config to apply, until stopCh is closed. A config failed to be loaded
+// or applied is logged and ignored, the previous one is kept. The directory
+// of the file is watched, so a file replaced by renaming, e.g. a mounted
+// ConfigMap, is still followed.
+func Watch(path string, load func() (*Config, error), apply func(*Config) error,
+	stopCh <-chan struct{}) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	dir, name := filepath.Split(filepath.Clean(path))
+	if dir == "" {
+		dir = "."
+	}
+	if err := watcher.Add(dir); err != nil {
+		watcher.Close()
+		return fmt.Errorf("failed to watch config %s: %v", path, err)
+	}
+
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case <-stopCh:
+				return
+			case err := <-watcher.Errors:
+				klog.Warningf("error watching config %s: %v", path, err)
+			case event := <-watcher.Events:
+				// a ConfigMap volume swaps the ..data symlink
+				base := filepath.Base(event.Name)
+				if base != name && base != "..data" {
+					continue
+				}
+				if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+					continue
+				}
+				cfg, err := load()
+				if err == nil {
+					err = apply(cfg)
+				}
+				if err != nil {
+					klog.Errorf("Rejected config %s, keep the previous one: %v", path, err)
+					continue
+				}
+				klog.Infof("Reloaded config %s", path)
+			}
+		}
+	}()
+	return nil
+}
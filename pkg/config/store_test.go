@@ -0,0 +1,106 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStoreSwap(t *testing.T) {
+	store := NewStore(Default())
+
+	invalid := Default()
+	invalid.NodePolicy = "random"
+	if err := store.Swap(invalid); err == nil {
+		t.Fatalf("expect an invalid config to be rejected")
+	}
+	keys := Default()
+	keys.Keys.Domain = "example.com"
+	if err := store.Swap(keys); err == nil {
+		t.Fatalf("expect a config of other keys to be rejected")
+	}
+	if store.Load().NodePolicy != PackPolicy {
+		t.Fatalf("expect the previous config to be kept")
+	}
+
+	spread := Default()
+	spread.NodePolicy = SpreadPolicy
+	if err := store.Swap(spread); err != nil {
+		t.Fatalf("failed to swap: %v", err)
+	}
+	if store.Load() != spread {
+		t.Fatalf("expect the new config in use")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gpu-admission")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(path, []byte(`{}`), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	store := NewStore(Default())
+	applied := make(chan struct{}, 10)
+	load := func() (*Config, error) {
+		cfg := Default()
+		return cfg, cfg.Load(path)
+	}
+	apply := func(cfg *Config) error {
+		err := store.Swap(cfg)
+		applied <- struct{}{}
+		return err
+	}
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	if err := Watch(path, load, apply, stopCh); err != nil {
+		t.Fatalf("failed to watch: %v", err)
+	}
+
+	write := func(data string) {
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatalf("failed to write config: %v", err)
+		}
+		select {
+		case <-applied:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("config is not reloaded")
+		}
+		// drain the events of the same write
+		time.Sleep(100 * time.Millisecond)
+		for len(applied) > 0 {
+			<-applied
+		}
+	}
+
+	write(`{"nodePolicy": "spread"}`)
+	if got := store.Load().NodePolicy; got != SpreadPolicy {
+		t.Fatalf("expect node policy %s, got %s", SpreadPolicy, got)
+	}
+	write(`{"nodePolicy": "random"}`)
+	if got := store.Load().NodePolicy; got != SpreadPolicy {
+		t.Fatalf("expect the previous node policy %s kept, got %s", SpreadPolicy, got)
+	}
+}
@@ -0,0 +1,80 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultVendor is the vendor of the GPU devices of a node without the
+// GPUVendor annotation, whose resources are Keys.VCore and Keys.VMemory
+const DefaultVendor = "nvidia"
+
+// VendorKeys are the names of the resources of the GPU devices of a
+// vendor, a name without "/" is prefixed by the domain of Keys. The cores
+// and memory of the devices of all vendors are accounted the same.
+type VendorKeys struct {
+	VCore   string `json:"vcore"`
+	VMemory string `json:"vmemory"`
+}
+
+// ResolvedVendors returns the resource names of all of the vendors keyed
+// by the vendor, including DefaultVendor, with the domain of Keys prefixed
+func (c *Config) ResolvedVendors() map[string]VendorKeys {
+	keys := c.Keys.Resolve()
+	ret := map[string]VendorKeys{
+		DefaultVendor: {VCore: keys.VCore, VMemory: keys.VMemory},
+	}
+	for vendor, vk := range c.Vendors {
+		ret[vendor] = VendorKeys{
+			VCore:   resolveKey(c.Keys.Domain, vk.VCore),
+			VMemory: resolveKey(c.Keys.Domain, vk.VMemory),
+		}
+	}
+	return ret
+}
+
+func resolveKey(domain, name string) string {
+	if name == "" || strings.Contains(name, "/") {
+		return name
+	}
+	return domain + "/" + name
+}
+
+// validateVendors checks that each vendor has its own resource names
+func (c *Config) validateVendors() error {
+	if _, ok := c.Vendors[DefaultVendor]; ok {
+		return fmt.Errorf("vendor %s uses the resources of keys", DefaultVendor)
+	}
+	seen := make(map[string]string)
+	for vendor, vk := range c.ResolvedVendors() {
+		if vendor == "" {
+			return fmt.Errorf("invalid vendor, expect a name")
+		}
+		for _, name := range []string{vk.VCore, vk.VMemory} {
+			if name == "" {
+				return fmt.Errorf("vendor %s has an empty resource name", vendor)
+			}
+			if other, ok := seen[name]; ok {
+				return fmt.Errorf("vendors %s and %s share resource %s", other, vendor, name)
+			}
+			seen[name] = vendor
+		}
+	}
+	return nil
+}
@@ -18,26 +18,126 @@ package device
 
 import (
 	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/klog"
 
 	"tkestack.io/gpu-admission/pkg/util"
 )
 
+// HealthStatus is the health status of a GPU device
+type HealthStatus int
+
+const (
+	// Healthy devices accept new containers
+	Healthy HealthStatus = iota
+	// Unhealthy devices are broken, e.g. Xid error
+	Unhealthy
+	// Draining devices accept no new containers, but the containers
+	// running on them are still accounted
+	Draining
+)
+
+func (s HealthStatus) String() string {
+	switch s {
+	case Healthy:
+		return "Healthy"
+	case Unhealthy:
+		return "Unhealthy"
+	case Draining:
+		return "Draining"
+	}
+	return fmt.Sprintf("HealthStatus(%d)", int(s))
+}
+
 type DeviceInfo struct {
 	id          int
+	totalCores  uint
 	totalMemory uint
+	reservedCores  uint
+	reservedMemory uint
 	usedMemory  uint
 	usedCore    uint
 	numberofContainer uint
 	isolatedTime uint
+	// isolatedSince is when isolatedTime was set, the isolated time decays
+	// as the time passes
+	isolatedSince time.Time
+	clock         clock.PassiveClock
+	health      HealthStatus
+	blacklisted bool
+	numaNode    int
+	// rdmaNIC is the RDMA NIC sharing the PCIe locality, -1 if none
+	rdmaNIC     int
+	model       string
+	uuid        string
+	// vendor is the name of the vendor, see util.Vendor
+	vendor string
+	// computeCapability is zero if unknown
+	computeCapability util.ComputeCapability
+	// utilization in percent, temperature in Celsius and power draw in
+	// watts reported by the node, 0 if unknown
+	utilization uint
+	temperature uint
+	power       uint
+	// eccErrors is the ECC error count reported by the node
+	eccErrors uint
+	// memoryBlock is the vmemory block size, 0 means 1
+	memoryBlock uint
+	migInstances []MIGInstance
+	// owners are the number of containers of each workload owner on this
+	// device, see util.GetOwnerOfPod
+	owners map[string]uint
+	// remainingTimes are the remaining estimated times of the containers
+	// having one
+	remainingTimes []remainingTime
+}
+
+// remainingTime is the remaining estimated time of a container when it was
+// recorded, in util.EstimatedTimeUnit
+type remainingTime struct {
+	remaining uint
+	since     time.Time
 }
 
-func newDeviceInfo(id int, totalMemory uint) *DeviceInfo {
+// MIGInstance is a fixed slice of a MIG enabled GPU device, e.g. 1g.5gb
+type MIGInstance struct {
+	// ID is the position of this instance within its device
+	ID      int    `json:"id"`
+	Profile string `json:"profile"`
+	Used    bool   `json:"used"`
+}
+
+func newDeviceInfo(id int, totalCores, totalMemory uint) *DeviceInfo {
 	return &DeviceInfo{
 		id:          id,
+		totalCores:  totalCores,
 		totalMemory: totalMemory,
+		numaNode:    -1,
+		rdmaNIC:     -1,
+		clock:       clock.RealClock{},
 	}
 }
 
+func (dev *DeviceInfo) clone() *DeviceInfo {
+	ret := *dev
+	if dev.migInstances != nil {
+		ret.migInstances = make([]MIGInstance, len(dev.migInstances))
+		copy(ret.migInstances, dev.migInstances)
+	}
+	if dev.owners != nil {
+		ret.owners = make(map[string]uint, len(dev.owners))
+		for owner, count := range dev.owners {
+			ret.owners[owner] = count
+		}
+	}
+	if dev.remainingTimes != nil {
+		ret.remainingTimes = append([]remainingTime(nil), dev.remainingTimes...)
+	}
+	return &ret
+}
+
 // GetID returns the idx of this device
 func (dev *DeviceInfo) GetID() int {
 	return dev.id
@@ -45,7 +145,13 @@ func (dev *DeviceInfo) GetID() int {
 
 // AddUsedResources records the used GPU core and memory
 func (dev *DeviceInfo) AddUsedResources(usedCore uint, usedMemory uint, isolatedTime int) error {
-	if usedCore+dev.usedCore > util.HundredCore {
+	return dev.addUsedResourcesSince(usedCore, usedMemory, isolatedTime, dev.clock.Now())
+}
+
+// addUsedResourcesSince records the used GPU core and memory of a container
+// whose isolated time started decaying at since, e.g. when it started
+func (dev *DeviceInfo) addUsedResourcesSince(usedCore uint, usedMemory uint, isolatedTime int, since time.Time) error {
+	if usedCore+dev.usedCore > dev.totalCores {
 		return fmt.Errorf("update usedcore failed, request: %d, already used: %d",
 			usedCore, dev.usedCore)
 	}
@@ -54,34 +160,424 @@ func (dev *DeviceInfo) AddUsedResources(usedCore uint, usedMemory uint, isolated
 		return fmt.Errorf("update usedmemory failed, request: %d, already used: %d",
 			usedMemory, dev.usedMemory)
 	}
-	var itime uint
 	dev.usedCore += usedCore
 	dev.usedMemory += usedMemory
 	dev.numberofContainer += 1
-	if dev.isolatedTime < uint(isolatedTime) {
-		itime = uint(isolatedTime)
-	} else {
-		itime = dev.isolatedTime
+	if isolatedTime > 0 {
+		// the isolated time is of the container ending last
+		end := since.Add(time.Duration(isolatedTime) * util.EstimatedTimeUnit)
+		last := dev.isolatedSince.Add(time.Duration(dev.isolatedTime) * util.EstimatedTimeUnit)
+		if dev.isolatedTime == 0 || end.After(last) {
+			dev.isolatedTime = uint(isolatedTime)
+			dev.isolatedSince = since
+		}
+		dev.remainingTimes = append(dev.remainingTimes, remainingTime{remaining: uint(isolatedTime), since: since})
 	}
-	dev.isolatedTime = itime
 	return nil
 }
 
-// AllocatableCores returns the remaining cores of this GPU device
+// RemoveUsedResources releases the GPU core and memory recorded by
+// AddUsedResources, e.g. when a container finishes. Releasing more than
+// used clamps at zero so a double release can't underflow. The isolated
+// time is the max of the containers and can't be recomputed, so it's only
+// reset once no container is left.
+func (dev *DeviceInfo) RemoveUsedResources(usedCore uint, usedMemory uint, isolatedTime int) {
+	if usedCore > dev.usedCore || usedMemory > dev.usedMemory || dev.numberofContainer == 0 {
+		klog.Warningf("release more than used of dev %d, request: %d cores %d memory, "+
+			"already used: %d cores %d memory of %d containers", dev.id,
+			usedCore, usedMemory, dev.usedCore, dev.usedMemory, dev.numberofContainer)
+	}
+	dev.usedCore = subOrZero(dev.usedCore, usedCore)
+	dev.usedMemory = subOrZero(dev.usedMemory, usedMemory)
+	if dev.numberofContainer > 0 {
+		dev.numberofContainer -= 1
+	}
+	if dev.numberofContainer == 0 {
+		dev.isolatedTime = 0
+	}
+	for i, t := range dev.remainingTimes {
+		if int(t.remaining) == isolatedTime {
+			dev.remainingTimes = append(dev.remainingTimes[:i], dev.remainingTimes[i+1:]...)
+			break
+		}
+	}
+}
+
+// AllocatableCores returns the remaining schedulable cores of this GPU
+// device
 func (d *DeviceInfo) AllocatableCores() uint {
-	return util.HundredCore - d.usedCore
+	return subOrZero(d.SchedulableCores(), d.usedCore)
+}
+
+// TotalCores returns the cores of this GPU device, which is the physical
+// cores scaled by the core overcommit ratio
+func (d *DeviceInfo) TotalCores() uint {
+	return d.totalCores
+}
+
+// SchedulableCores returns the cores of this GPU device except the ones
+// reserved for the system
+func (d *DeviceInfo) SchedulableCores() uint {
+	return subOrZero(d.totalCores, d.reservedCores)
+}
+
+// UsedCores returns the cores charged to the containers on this GPU device
+func (d *DeviceInfo) UsedCores() uint {
+	return d.usedCore
+}
+
+// UsedMemory returns the vmemory charged to the containers on this GPU
+// device
+func (d *DeviceInfo) UsedMemory() uint {
+	return d.usedMemory
+}
+
+// TotalMemory returns the vmemory capacity of this GPU device
+func (d *DeviceInfo) TotalMemory() uint {
+	return d.totalMemory
+}
+
+// SchedulableMemory returns the vmemory of this GPU device except the ones
+// reserved for the system
+func (d *DeviceInfo) SchedulableMemory() uint {
+	return subOrZero(d.totalMemory, d.reservedMemory)
 }
 
-// AllocatableMemory returns the remaining memory of this GPU device
+// SetReserved reserves cores and vmemory of this GPU device for the system,
+// e.g. display or monitoring, they are never allocated to containers
+func (d *DeviceInfo) SetReserved(cores, memory uint) {
+	d.reservedCores = cores
+	d.reservedMemory = memory
+}
+
+// AllocatableMemory returns the remaining schedulable memory of this GPU
+// device
 func (d *DeviceInfo) AllocatableMemory() uint {
-	return d.totalMemory - d.usedMemory
+	return subOrZero(d.SchedulableMemory(), d.usedMemory)
+}
+
+func subOrZero(a, b uint) uint {
+	if a < b {
+		return 0
+	}
+	return a - b
+}
+
+// IsHealthy tells if this GPU device can be a placement candidate, that
+// is, it's neither unhealthy nor draining
+func (d *DeviceInfo) IsHealthy() bool {
+	return d.health == Healthy
+}
+
+// IsBlacklisted tells if this GPU device is quarantined by the operator
+func (d *DeviceInfo) IsBlacklisted() bool {
+	return d.blacklisted
+}
+
+// SetBlacklisted marks this GPU device as quarantined or not
+func (d *DeviceInfo) SetBlacklisted(blacklisted bool) {
+	d.blacklisted = blacklisted
+}
+
+// IsSchedulable tells if this GPU device accepts new containers, that is,
+// it's healthy and not blacklisted
+func (d *DeviceInfo) IsSchedulable() bool {
+	return d.IsHealthy() && !d.blacklisted
+}
+
+// Health returns the health status of this GPU device
+func (d *DeviceInfo) Health() HealthStatus {
+	return d.health
+}
+
+// SetHealth records the health status of this GPU device
+func (d *DeviceInfo) SetHealth(health HealthStatus) {
+	d.health = health
+}
+
+// GetNUMANode returns the NUMA node id this GPU device attaches to,
+// -1 means unknown
+func (d *DeviceInfo) GetNUMANode() int {
+	return d.numaNode
+}
+
+// SetNUMANode records the NUMA node id this GPU device attaches to
+func (d *DeviceInfo) SetNUMANode(numaNode int) {
+	d.numaNode = numaNode
+}
+
+// RDMANIC returns the RDMA NIC sharing the PCIe locality of this GPU
+// device, -1 means none or unknown
+func (d *DeviceInfo) RDMANIC() int {
+	return d.rdmaNIC
+}
+
+// SetRDMANIC records the RDMA NIC sharing the PCIe locality of this device
+func (d *DeviceInfo) SetRDMANIC(nic int) {
+	d.rdmaNIC = nic
+}
+
+// Model returns the model of this GPU device, e.g. t4 or a10
+func (d *DeviceInfo) Model() string {
+	return d.model
 }
 
+// SetModel records the model of this GPU device
+func (d *DeviceInfo) SetModel(model string) {
+	d.model = model
+}
+
+// Vendor returns the vendor of this GPU device, e.g. nvidia
+func (d *DeviceInfo) Vendor() string {
+	return d.vendor
+}
+
+// SetVendor records the vendor of this GPU device
+func (d *DeviceInfo) SetVendor(vendor string) {
+	d.vendor = vendor
+}
+
+// ComputeCapability returns the CUDA compute capability of this GPU device,
+// the zero value if unknown
+func (d *DeviceInfo) ComputeCapability() util.ComputeCapability {
+	return d.computeCapability
+}
+
+// SetComputeCapability records the CUDA compute capability of this device
+func (d *DeviceInfo) SetComputeCapability(cc util.ComputeCapability) {
+	d.computeCapability = cc
+}
+
+// UUID returns the UUID of this GPU device, which is stable while the idx
+// may be remapped by the driver
+func (d *DeviceInfo) UUID() string {
+	return d.uuid
+}
+
+// SetUUID records the UUID of this GPU device
+func (d *DeviceInfo) SetUUID(uuid string) {
+	d.uuid = uuid
+}
+
+// Utilization returns the utilization of this device in percent reported by
+// the node
+func (d *DeviceInfo) Utilization() uint {
+	return d.utilization
+}
+
+// SetUtilization sets the utilization of this device in percent
+func (d *DeviceInfo) SetUtilization(utilization uint) {
+	d.utilization = utilization
+}
+
+// Temperature returns the temperature of this device in Celsius reported by
+// the node
+func (d *DeviceInfo) Temperature() uint {
+	return d.temperature
+}
+
+// SetTemperature sets the temperature of this device in Celsius
+func (d *DeviceInfo) SetTemperature(temperature uint) {
+	d.temperature = temperature
+}
+
+// ECCErrors returns the ECC error count of this device reported by the node
+func (d *DeviceInfo) ECCErrors() uint {
+	return d.eccErrors
+}
+
+// SetECCErrors sets the ECC error count of this device
+func (d *DeviceInfo) SetECCErrors(count uint) {
+	d.eccErrors = count
+}
+
+// MemoryBlock returns the vmemory block size of this device, the memory of
+// a shared container is allocated in blocks of it
+func (d *DeviceInfo) MemoryBlock() uint {
+	if d.memoryBlock == 0 {
+		return 1
+	}
+	return d.memoryBlock
+}
+
+// SetMemoryBlock sets the vmemory block size of this device
+func (d *DeviceInfo) SetMemoryBlock(block uint) {
+	d.memoryBlock = block
+}
+
+// AlignMemory rounds vmemory up to the blocks of this device, which is the
+// memory a shared container requesting vmemory takes on it
+func (d *DeviceInfo) AlignMemory(vmemory uint) uint {
+	block := d.MemoryBlock()
+	return (vmemory + block - 1) / block * block
+}
+
+// Power returns the power draw of this device in watts reported by the node
+func (d *DeviceInfo) Power() uint {
+	return d.power
+}
+
+// SetPower sets the power draw of this device in watts
+func (d *DeviceInfo) SetPower(power uint) {
+	d.power = power
+}
+
+// IsMIGEnabled tells if this GPU device is sliced into MIG instances, such
+// device can only be allocated by instances instead of cores and memory
+func (d *DeviceInfo) IsMIGEnabled() bool {
+	return len(d.migInstances) > 0
+}
+
+// SetMIGInstances slices this GPU device into MIG instances of given
+// profiles, the ids of instances are the positions of profiles
+func (d *DeviceInfo) SetMIGInstances(profiles []string) {
+	d.migInstances = make([]MIGInstance, len(profiles))
+	for i, profile := range profiles {
+		d.migInstances[i] = MIGInstance{ID: i, Profile: profile}
+	}
+}
+
+// MIGInstances returns a copy of the MIG instances of this GPU device
+func (d *DeviceInfo) MIGInstances() []MIGInstance {
+	ret := make([]MIGInstance, len(d.migInstances))
+	copy(ret, d.migInstances)
+	return ret
+}
+
+// FreeMIGInstances returns the ids of unused MIG instances of given profile
+func (d *DeviceInfo) FreeMIGInstances(profile string) []int {
+	var ret []int
+	for _, inst := range d.migInstances {
+		if !inst.Used && inst.Profile == profile {
+			ret = append(ret, inst.ID)
+		}
+	}
+	return ret
+}
+
+// UseMIGInstance marks the MIG instance of given id as used
+func (d *DeviceInfo) UseMIGInstance(id int) error {
+	if id < 0 || id >= len(d.migInstances) {
+		return fmt.Errorf("MIG instance %d not found on device %d", id, d.id)
+	}
+	if d.migInstances[id].Used {
+		return fmt.Errorf("MIG instance %d of device %d is already used", id, d.id)
+	}
+	d.migInstances[id].Used = true
+	d.numberofContainer += 1
+	return nil
+}
+
+// ReleaseMIGInstance marks the MIG instance of given id used by
+// UseMIGInstance as free again
+func (d *DeviceInfo) ReleaseMIGInstance(id int) {
+	if id < 0 || id >= len(d.migInstances) || !d.migInstances[id].Used {
+		return
+	}
+	d.migInstances[id].Used = false
+	if d.numberofContainer > 0 {
+		d.numberofContainer -= 1
+	}
+}
+
+// IsolatedTime returns the max remaining estimated time of the containers
+// on this GPU device, in util.EstimatedTimeUnit. It decreases with the
+// time passed since it was recorded, so a container which should have
+// finished no longer counts.
 func (d *DeviceInfo) IsolatedTime() uint {
-	return d.isolatedTime
+	return d.elapse(d.isolatedTime, d.isolatedSince)
+}
+
+// SetClock sets the clock the remaining times decay by
+func (d *DeviceInfo) SetClock(c clock.PassiveClock) {
+	d.clock = c
+}
+
+// elapse returns what is left of the remaining time recorded at since
+func (d *DeviceInfo) elapse(remaining uint, since time.Time) uint {
+	elapsed := d.clock.Since(since)
+	if elapsed <= 0 {
+		return remaining
+	}
+	return subOrZero(remaining, uint(elapsed/util.EstimatedTimeUnit))
+}
+
+// TimeOverlap returns how long a container of given estimated time would
+// run together with the containers on this GPU device, summed over them,
+// in util.EstimatedTimeUnit
+func (d *DeviceInfo) TimeOverlap(estimatedTime uint) uint {
+	var overlap uint
+	for _, rt := range d.remainingTimes {
+		if t := d.elapse(rt.remaining, rt.since); t < estimatedTime {
+			overlap += t
+		} else {
+			overlap += estimatedTime
+		}
+	}
+	return overlap
+}
+
+// TimeDistance returns how far the remaining estimated times of the
+// containers on this GPU device are from given estimated time, averaged
+// over them, in util.EstimatedTimeUnit. A container which should have
+// finished doesn't count, and ok is false if none is left, the distance of
+// an idle device is nothing to compare.
+func (d *DeviceInfo) TimeDistance(estimatedTime uint) (distance uint, ok bool) {
+	var sum, count uint
+	for _, rt := range d.remainingTimes {
+		t := d.elapse(rt.remaining, rt.since)
+		if t == 0 {
+			continue
+		}
+		if t < estimatedTime {
+			sum += estimatedTime - t
+		} else {
+			sum += t - estimatedTime
+		}
+		count++
+	}
+	if count == 0 {
+		return 0, false
+	}
+	return sum / count, true
 }
 
 func (d *DeviceInfo) NumberofContainer() uint {
 	return d.numberofContainer
 }
 
+// AddOwner records a container of given workload owner on this device
+func (d *DeviceInfo) AddOwner(owner string) {
+	if owner == "" {
+		return
+	}
+	if d.owners == nil {
+		d.owners = make(map[string]uint)
+	}
+	d.owners[owner]++
+}
+
+// RemoveOwner forgets a container of given workload owner recorded by
+// AddOwner
+func (d *DeviceInfo) RemoveOwner(owner string) {
+	if d.owners[owner] == 0 {
+		return
+	}
+	d.owners[owner]--
+	if d.owners[owner] == 0 {
+		delete(d.owners, owner)
+	}
+}
+
+// DistinctOwners returns the number of distinct workload owners of the
+// containers on this device
+func (d *DeviceInfo) DistinctOwners() int {
+	return len(d.owners)
+}
+
+// HasOwner tells if there is a container of given workload owner on this
+// device
+func (d *DeviceInfo) HasOwner(owner string) bool {
+	return d.owners[owner] > 0
+}
+
@@ -0,0 +1,103 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package device
+
+import (
+	"encoding/json"
+)
+
+// deviceJSON is the JSON form of DeviceInfo
+type deviceJSON struct {
+	ID                int           `json:"id"`
+	UUID              string        `json:"uuid,omitempty"`
+	Vendor            string        `json:"vendor,omitempty"`
+	Model             string        `json:"model,omitempty"`
+	ComputeCapability string        `json:"computeCapability,omitempty"`
+	NUMANode          int           `json:"numaNode"`
+	RDMANIC           int           `json:"rdmaNIC"`
+	Health            string        `json:"health"`
+	Blacklisted       bool          `json:"blacklisted"`
+	TotalCores        uint          `json:"totalCores"`
+	SchedulableCores  uint          `json:"schedulableCores"`
+	UsedCores         uint          `json:"usedCores"`
+	AllocatableCores  uint          `json:"allocatableCores"`
+	TotalMemory       uint          `json:"totalMemory"`
+	SchedulableMemory uint          `json:"schedulableMemory"`
+	UsedMemory        uint          `json:"usedMemory"`
+	AllocatableMemory uint          `json:"allocatableMemory"`
+	MemoryBlock       uint          `json:"memoryBlock"`
+	Containers        uint          `json:"containers"`
+	DistinctOwners    int           `json:"distinctOwners"`
+	IsolatedTime      uint          `json:"isolatedTime"`
+	MIGInstances      []MIGInstance `json:"migInstances,omitempty"`
+}
+
+// MarshalJSON returns the state of this device seen by the allocation, the
+// isolated time is what is left at the time of the call
+func (d *DeviceInfo) MarshalJSON() ([]byte, error) {
+	return json.Marshal(deviceJSON{
+		ID:                d.id,
+		UUID:              d.uuid,
+		Vendor:            d.vendor,
+		Model:             d.model,
+		ComputeCapability: d.computeCapability.String(),
+		NUMANode:          d.numaNode,
+		RDMANIC:           d.rdmaNIC,
+		Health:            d.health.String(),
+		Blacklisted:       d.blacklisted,
+		TotalCores:        d.TotalCores(),
+		SchedulableCores:  d.SchedulableCores(),
+		UsedCores:         d.UsedCores(),
+		AllocatableCores:  d.AllocatableCores(),
+		TotalMemory:       d.TotalMemory(),
+		SchedulableMemory: d.SchedulableMemory(),
+		UsedMemory:        d.UsedMemory(),
+		AllocatableMemory: d.AllocatableMemory(),
+		MemoryBlock:       d.MemoryBlock(),
+		Containers:        d.NumberofContainer(),
+		DistinctOwners:    d.DistinctOwners(),
+		IsolatedTime:      d.IsolatedTime(),
+		MIGInstances:      d.migInstances,
+	})
+}
+
+// nodeJSON is the JSON form of NodeInfo
+type nodeJSON struct {
+	Name          string        `json:"name"`
+	FreeCores     int           `json:"freeCores"`
+	FreeMemory    int           `json:"freeMemory"`
+	FreeWholeGPUs int           `json:"freeWholeGPUs"`
+	Devices       []*DeviceInfo `json:"devices"`
+}
+
+// MarshalJSON returns the state of this node and its devices, ordered by
+// device idx
+func (n *NodeInfo) MarshalJSON() ([]byte, error) {
+	devs := make([]*DeviceInfo, 0, len(n.devs))
+	for i := 0; i < n.deviceCount; i++ {
+		if dev, ok := n.devs[i]; ok {
+			devs = append(devs, dev)
+		}
+	}
+	return json.Marshal(nodeJSON{
+		Name:          n.name,
+		FreeCores:     n.FreeCores(),
+		FreeMemory:    n.FreeMemory(),
+		FreeWholeGPUs: n.FreeWholeGPUs(),
+		Devices:       devs,
+	})
+}
@@ -0,0 +1,82 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package device
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+func TestNodeInfoMarshalJSON(t *testing.T) {
+	node := newTestNode("testnode", 2, 16)
+	node.Annotations[util.DrainingGPUIndexes] = "0"
+	node.Annotations[util.GPUMIGInstances] = "1:1g.5gb"
+	n := NewNodeInfo(node, nil)
+	if err := n.AddUsedResources(0, 40, 3, 60); err != nil {
+		t.Fatalf("failed to add used resources: %v", err)
+	}
+
+	data, err := json.Marshal(n)
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+	var got nodeJSON
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("failed to unmarshal %s: %v", data, err)
+	}
+	expect := deviceJSON{
+		ID:                0,
+		Vendor:            "nvidia",
+		NUMANode:          -1,
+		RDMANIC:           -1,
+		Health:            "Draining",
+		TotalCores:        100,
+		SchedulableCores:  100,
+		UsedCores:         40,
+		AllocatableCores:  60,
+		TotalMemory:       8,
+		SchedulableMemory: 8,
+		UsedMemory:        3,
+		AllocatableMemory: 5,
+		MemoryBlock:       1,
+		Containers:        1,
+		IsolatedTime:      60,
+	}
+	if got.Name != "testnode" || len(got.Devices) != 2 {
+		t.Fatalf("unexpected node %s", data)
+	}
+	var dev0, dev1 deviceJSON
+	for i, dev := range []*deviceJSON{&dev0, &dev1} {
+		raw, _ := json.Marshal(n.GetDeviceMap()[i])
+		if err := json.Unmarshal(raw, dev); err != nil {
+			t.Fatalf("failed to unmarshal %s: %v", raw, err)
+		}
+	}
+	if !reflect.DeepEqual(dev0, expect) {
+		t.Fatalf("expect device 0 %+v, got %+v", expect, dev0)
+	}
+	if len(dev1.MIGInstances) != 1 || dev1.MIGInstances[0].Profile != "1g.5gb" {
+		t.Fatalf("expect the MIG instances of device 1, got %+v", dev1.MIGInstances)
+	}
+	// neither the draining nor the MIG enabled device is free
+	if got.FreeCores != 0 || got.FreeMemory != 0 || got.FreeWholeGPUs != 0 {
+		t.Fatalf("unexpected free resources of the node %s", data)
+	}
+}
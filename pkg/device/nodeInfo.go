@@ -17,15 +17,33 @@
 package device
 
 import (
+	"fmt"
+	"math"
 	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
 	"k8s.io/klog"
 
+	"tkestack.io/gpu-admission/pkg/config"
+	"tkestack.io/gpu-admission/pkg/topology"
 	"tkestack.io/gpu-admission/pkg/util"
 )
 
+// NodeInfo records the allocation state of GPU devices of a node.
+//
+// The methods of NodeInfo don't synchronize by themselves. If a NodeInfo
+// is shared by multiple goroutines, the callers must hold Lock() during a
+// whole read-evaluate-update sequence, otherwise two allocations may see
+// the same allocatable resources and book one device twice. A clone has a
+// lock of its own, so the allocations on clones of the same node have to
+// be serialized by whoever hands out the clones.
 type NodeInfo struct {
+	sync.Mutex
+
 	name        string
 	node        *v1.Node
 	devs        map[int]*DeviceInfo
@@ -33,17 +51,176 @@ type NodeInfo struct {
 	totalMemory uint
 	usedCore    uint
 	usedMemory  uint
+	// vendor is the vendor of the GPU devices, whose resources the
+	// capacity and the requests are in
+	vendor util.Vendor
+	// topology is the interconnect of the GPU devices, nil if unknown
+	topology *topology.Topology
+	// invalid is why the node can't be allocated, nil if it can
+	invalid error
+	// inconsistentBlocks is why the memory blocks of the devices are
+	// inconsistent, nil if they aren't
+	inconsistentBlocks error
+	// unusableShare is why a device can't take any shared container while
+	// keeping minFreeMemory free, nil if all of them can
+	unusableShare error
+
+	maxContainersPerDevice uint
+	maxDevicesPerPod       uint
+	sharePolicy            string
+	disableExclusive       bool
+	shareWeights           []float64
+	shareSortOrder         []LessFunc
+	shareWeighting         string
+	distinctOwnersWeight   float64
+	timeOverlapWeight      float64
+	timeSimilarityWeight   float64
+	minFreeMemory          uint
+	fragmentationWeight    float64
+	ownerLabel             string
+	lowPriorityThreshold   *int32
+	maxECCErrors           *uint
 }
 
+// NewNodeInfo creates a NodeInfo with the default config
 func NewNodeInfo(node *v1.Node, pods []*v1.Pod) *NodeInfo {
+	return NewNodeInfoWithConfig(node, pods, config.Default())
+}
+
+// NewNodeInfoWithConfig creates a NodeInfo according to given config, the
+// node annotations take precedence over the config
+func NewNodeInfoWithConfig(node *v1.Node, pods []*v1.Pod, cfg *config.Config) *NodeInfo {
 	klog.V(4).Infof("debug: NewNodeInfo() creates nodeInfo for %s", node.Name)
 
 	devMap := map[int]*DeviceInfo{}
-	nodeTotalMemory := uint(util.GetCapacityOfNode(node, util.VMemoryAnnotation))
+	// the capacity and the requests are in the resources of the vendor
+	vendor := util.GetVendorOfNode(node)
+	nodeTotalMemory := uint(util.GetCapacityOfNode(node, vendor.VMemory))
 	deviceCount := util.GetGPUDeviceCountOfNode(node)
 	deviceTotalMemory := nodeTotalMemory / uint(deviceCount)
+	// GPU devices of a node may have different memory, otherwise the
+	// memory of the node is evenly divided
+	memories := util.GetMemoriesOfNode(node)
+	if len(memories) != 0 && len(memories) != deviceCount {
+		klog.Infof("ignore GPU memories of node %s, got %d for %d devices",
+			node.Name, len(memories), deviceCount)
+		memories = nil
+	}
+	if memories != nil {
+		nodeTotalMemory = 0
+		for _, mem := range memories {
+			nodeTotalMemory += mem
+		}
+	}
+	ratio := cfg.CoreOvercommitRatio
+	if nodeRatio, ok := util.GetCoreOvercommitRatioOfNode(node); ok {
+		ratio = nodeRatio
+	}
+	deviceTotalCores := uint(math.Round(config.ClampCoreOvercommitRatio(ratio) * util.HundredCore))
 	for i := 0; i < deviceCount; i++ {
-		devMap[i] = newDeviceInfo(i, deviceTotalMemory)
+		if memories != nil {
+			deviceTotalMemory = memories[i]
+		}
+		devMap[i] = newDeviceInfo(i, deviceTotalCores, deviceTotalMemory)
+		devMap[i].SetReserved(cfg.ReservedCoresPerDevice, cfg.ReservedMemoryPerDevice)
+		devMap[i].SetVendor(vendor.Name)
+	}
+	for _, index := range util.GetDrainingIdxOfNode(node) {
+		if dev, ok := devMap[index]; ok {
+			dev.SetHealth(Draining)
+		}
+	}
+	// a broken device is unhealthy even if it's draining
+	for _, index := range util.GetUnhealthyIdxOfNode(node) {
+		if dev, ok := devMap[index]; ok {
+			dev.SetHealth(Unhealthy)
+		}
+	}
+	for index, numa := range util.GetNUMANodesOfNode(node) {
+		if dev, ok := devMap[index]; ok {
+			dev.SetNUMANode(numa)
+		}
+	}
+	for index, nic := range util.GetRDMANICsOfNode(node) {
+		if dev, ok := devMap[index]; ok {
+			dev.SetRDMANIC(nic)
+		}
+	}
+	// the topology tells the NUMA nodes and the NICs not annotated
+	topo := util.GetTopologyOfNode(node)
+	if topo != nil && topo.Count() != deviceCount {
+		klog.Infof("ignore GPU topology of node %s, got %d for %d devices",
+			node.Name, topo.Count(), deviceCount)
+		topo = nil
+	}
+	if topo != nil {
+		for index, dev := range devMap {
+			if dev.GetNUMANode() < 0 {
+				dev.SetNUMANode(topo.NUMANode(index))
+			}
+			if dev.RDMANIC() < 0 {
+				dev.SetRDMANIC(topo.NIC(index))
+			}
+		}
+	}
+	for index, model := range util.GetModelsOfNode(node) {
+		if dev, ok := devMap[index]; ok {
+			dev.SetModel(model)
+		}
+	}
+	for index, cc := range util.GetComputeCapabilitiesOfNode(node) {
+		if dev, ok := devMap[index]; ok {
+			dev.SetComputeCapability(cc)
+		}
+	}
+	for index, uuid := range util.GetUUIDsOfNode(node) {
+		if dev, ok := devMap[index]; ok {
+			dev.SetUUID(uuid)
+		}
+	}
+	for index, utilization := range util.GetUtilizationsOfNode(node) {
+		if dev, ok := devMap[index]; ok {
+			dev.SetUtilization(utilization)
+		}
+	}
+	for index, temperature := range util.GetTemperaturesOfNode(node) {
+		if dev, ok := devMap[index]; ok {
+			dev.SetTemperature(temperature)
+		}
+	}
+	for index, power := range util.GetPowersOfNode(node) {
+		if dev, ok := devMap[index]; ok {
+			dev.SetPower(power)
+		}
+	}
+	for index, block := range util.GetMemoryBlocksOfNode(node) {
+		if dev, ok := devMap[index]; ok {
+			dev.SetMemoryBlock(block)
+		}
+	}
+	for index, count := range util.GetECCErrorsOfNode(node) {
+		if dev, ok := devMap[index]; ok {
+			dev.SetECCErrors(count)
+		}
+	}
+	// blacklisted devices are quarantined by the operator
+	blacklistIdx, blacklistUUIDs := util.GetBlacklistOfNode(node)
+	for _, index := range blacklistIdx {
+		if dev, ok := devMap[index]; ok {
+			dev.SetBlacklisted(true)
+		}
+	}
+	for _, uuid := range blacklistUUIDs {
+		for _, dev := range devMap {
+			if dev.UUID() != "" && strings.EqualFold(dev.UUID(), uuid) {
+				dev.SetBlacklisted(true)
+			}
+		}
+	}
+	for index, profiles := range util.GetMIGInstancesOfNode(node) {
+		if dev, ok := devMap[index]; ok {
+			dev.SetMIGInstances(profiles)
+		}
 	}
 
 	ret := &NodeInfo{
@@ -52,63 +229,248 @@ func NewNodeInfo(node *v1.Node, pods []*v1.Pod) *NodeInfo {
 		devs:        devMap,
 		deviceCount: deviceCount,
 		totalMemory: nodeTotalMemory,
+		vendor:      vendor,
+		topology:    topo,
+
+		maxContainersPerDevice: cfg.MaxContainersPerDevice,
+		maxDevicesPerPod:       cfg.MaxDevicesPerPod,
+		sharePolicy:            cfg.SharePolicy,
+		disableExclusive:       cfg.DisableExclusive,
+		shareWeights:           cfg.ShareWeights,
+		shareSortOrder:         lessFuncsOf(cfg.ShareSortOrder),
+		shareWeighting:         cfg.ShareWeighting,
+		distinctOwnersWeight:   cfg.DistinctOwnersWeight,
+		timeOverlapWeight:      cfg.TimeOverlapWeight,
+		timeSimilarityWeight:   cfg.TimeSimilarityWeight,
+		minFreeMemory:          cfg.MinFreeMemoryPerDevice,
+		fragmentationWeight:    cfg.FragmentationWeight,
+		ownerLabel:             cfg.OwnerLabel,
+		lowPriorityThreshold:   cfg.LowPriorityThreshold,
+		maxECCErrors:           cfg.MaxECCErrors,
 	}
 
+	// the inconsistency is logged by the caller, e.g. once for each
+	// version of the node by the node cache
+	if err := ret.validateMemoryBlocks(); err != nil {
+		ret.inconsistentBlocks = err
+		if cfg.RejectInconsistentMemoryBlocks {
+			ret.invalid = err
+		}
+	}
+	ret.unusableShare = ret.validateMinFreeMemory()
+
 	// According to the pods' annotations, construct the node allocation
 	// state
 	for _, pod := range pods {
-		for i, c := range pod.Spec.Containers {
-			predicateIndexes, err := util.GetPredicateIdxOfContainer(pod, i)
+		ret.AddPod(pod)
+	}
+
+	return ret
+}
+
+// PodCharge is what a pod is charged on the devices of a node by AddPod,
+// so RemovePod debits exactly that even if the pod has changed since
+type PodCharge struct {
+	usages []deviceUsage
+	// migInstances are the device and instance ids of the MIG instances
+	migInstances [][2]int
+}
+
+// Devices returns the ids of the devices charged for the cores and memory
+// of the pod, in ascending order
+func (c *PodCharge) Devices() []int {
+	seen := make(map[int]bool)
+	var ids []int
+	for _, u := range c.usages {
+		if !seen[u.dev] {
+			seen[u.dev] = true
+			ids = append(ids, u.dev)
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// deviceUsage is the cores, memory and remaining time charged on a device,
+// owner is the workload owner of a regular container
+type deviceUsage struct {
+	dev     int
+	vcore   uint
+	vmemory uint
+	itime   int
+	owner   string
+}
+
+// AddPod charges the node for the devices the annotations of pod allocate,
+// and returns the charge for RemovePod
+func (n *NodeInfo) AddPod(pod *v1.Pod) *PodCharge {
+	charge := &PodCharge{}
+	usedCores := make(map[int]uint)
+	usedMemory := make(map[int]uint)
+	owner := n.OwnerOf(pod)
+	for i, c := range pod.Spec.Containers {
+		if _, count := util.GetMIGRequestOfContainer(&c); count > 0 {
+			n.addMIGUsage(pod, i, charge)
+			continue
+		}
+		predicateIndexes, err := util.GetPredicateIdxOfContainer(pod, i)
+		if err != nil {
+			continue
+		}
+		//共享模式该循环只会执行一遍
+		for _, index := range predicateIndexes {
+			var vcore, vmemory, etime uint
+			var itime int
+			var since time.Time
+			if index >= n.deviceCount {
+				klog.Infof("invalid predicateIndex %d larger than device count", index)
+				continue
+			}
+			//计算容器的vcore limit size
+			vcore, vmemory, err = n.chargedRequestOf(pod, &c)
 			if err != nil {
+				klog.Infof("failed to get GPU request of pod %s: %v", pod.UID, err)
 				continue
 			}
-			//共享模式该循环只会执行一遍
-			for _, index := range predicateIndexes {
-				var vcore, vmemory, etime, rtime uint
-				var itime int
-				if index >= deviceCount {
-					klog.Infof("invalid predicateIndex %d larger than device count", index)
-					continue
-				}
-				//计算容器的vcore limit size
-	
-				vcore = util.GetGPUResourceOfContainer(&c, util.VCoreAnnotation)
-				if vcore < util.HundredCore {
-					//共享模式
-					etime, err = util.GetEstimatedTimeOfContainer(pod, i)
-					if err != nil {
-						continue
-					}
-					rtime, err = util.GetRunningTimeOfContainer(pod, i)
-					if err != nil {
-						continue
-					}
-					itime = int(etime) - int(rtime)
-					if itime < 0 {
-						itime = 0
-					}
-					vmemory = util.GetGPUResourceOfContainer(&c, util.VMemoryAnnotation)
-				} else {
-					itime = 0
-					vcore = util.HundredCore
-					vmemory = deviceTotalMemory
-				}
-				err = ret.AddUsedResources(index, vcore, vmemory, itime)
+			if vcore < util.HundredCore {
+				//共享模式
+				etime, err = util.GetEstimatedTimeOfContainer(pod, i)
 				if err != nil {
-					klog.Infof("failed to update used resource for node %s dev %d due to %v",
-						node.Name, index, err)
+					continue
 				}
+				// the estimated time decays since the container started,
+				// or since the pod was predicated if it's not running yet
+				itime = int(etime)
+				since, _ = util.GetStartTimeOfContainer(pod, i)
+				vmemory = n.devs[index].AlignMemory(vmemory)
+			} else {
+				itime = 0
+				vcore = n.devs[index].SchedulableCores()
+				vmemory = n.devs[index].SchedulableMemory()
 			}
+			err = n.addUsedResourcesSince(index, vcore, vmemory, itime, since)
+			if err != nil {
+				klog.Infof("failed to update used resource for node %s dev %d due to %v",
+					n.name, index, err)
+				continue
+			}
+			usedCores[index] += vcore
+			usedMemory[index] += vmemory
+			n.devs[index].AddOwner(owner)
+			charge.usages = append(charge.usages, deviceUsage{
+				dev: index, vcore: vcore, vmemory: vmemory, itime: itime, owner: owner,
+			})
+		}
 
+	}
+	n.addInitContainersUsage(pod, usedCores, usedMemory, charge)
+	return charge
+}
+
+// RemovePod releases the charge returned by AddPod, e.g. once the pod is
+// deleted or finished
+func (n *NodeInfo) RemovePod(charge *PodCharge) {
+	if charge == nil {
+		return
+	}
+	for _, u := range charge.usages {
+		if err := n.RemoveUsedResources(u.dev, u.vcore, u.vmemory, u.itime); err != nil {
+			klog.Infof("failed to release used resource for node %s due to %v", n.name, err)
+			continue
+		}
+		n.devs[u.dev].RemoveOwner(u.owner)
+	}
+	for _, inst := range charge.migInstances {
+		if dev, ok := n.devs[inst[0]]; ok {
+			dev.ReleaseMIGInstance(inst[1])
 		}
 	}
+}
 
-	return ret
+// addMIGUsage marks the MIG instances used by given container
+func (n *NodeInfo) addMIGUsage(pod *v1.Pod, containerIndex int, charge *PodCharge) {
+	instances, err := util.GetPredicateMIGInstancesOfContainer(pod, containerIndex)
+	if err != nil {
+		return
+	}
+	for _, inst := range instances {
+		if err := n.UseMIGInstance(inst[0], inst[1]); err != nil {
+			klog.Infof("failed to update used MIG instance for node %s due to %v", n.name, err)
+			continue
+		}
+		charge.migInstances = append(charge.migInstances, [2]int{inst[0], inst[1]})
+	}
+}
+
+// addInitContainersUsage records the part of the init containers' usage
+// beyond the regular containers' usage of the same pod on each device,
+// since init containers never run along with regular containers
+func (n *NodeInfo) addInitContainersUsage(pod *v1.Pod, usedCores, usedMemory map[int]uint, charge *PodCharge) {
+	peakCores := make(map[int]uint)
+	peakMemory := make(map[int]uint)
+	for i, c := range pod.Spec.InitContainers {
+		predicateIndexes, err := util.GetPredicateIdxOfInitContainer(pod, i)
+		if err != nil {
+			continue
+		}
+		for _, index := range predicateIndexes {
+			if index >= n.deviceCount {
+				klog.Infof("invalid predicateIndex %d larger than device count", index)
+				continue
+			}
+			vcore, vmemory, err := n.chargedRequestOf(pod, &c)
+			if err != nil {
+				klog.Infof("failed to get GPU request of pod %s: %v", pod.UID, err)
+				continue
+			}
+			if vcore >= util.HundredCore {
+				vcore = n.devs[index].SchedulableCores()
+				vmemory = n.devs[index].SchedulableMemory()
+			} else {
+				vmemory = n.devs[index].AlignMemory(vmemory)
+			}
+			if peakCores[index] < vcore {
+				peakCores[index] = vcore
+			}
+			if peakMemory[index] < vmemory {
+				peakMemory[index] = vmemory
+			}
+		}
+	}
+
+	for index := range peakCores {
+		var vcore, vmemory uint
+		if peakCores[index] > usedCores[index] {
+			vcore = peakCores[index] - usedCores[index]
+		}
+		if peakMemory[index] > usedMemory[index] {
+			vmemory = peakMemory[index] - usedMemory[index]
+		}
+		if vcore == 0 && vmemory == 0 {
+			continue
+		}
+		if err := n.AddUsedResources(index, vcore, vmemory, 0); err != nil {
+			klog.Infof("failed to update used resource for node %s dev %d due to %v",
+				n.name, index, err)
+			continue
+		}
+		charge.usages = append(charge.usages, deviceUsage{dev: index, vcore: vcore, vmemory: vmemory})
+	}
 }
 
 // AddUsedResources records the used GPU core and memory
 func (n *NodeInfo) AddUsedResources(devID int, vcore uint, vmemory uint, itime int) error {
-	err := n.devs[devID].AddUsedResources(vcore, vmemory, itime)
+	return n.addUsedResourcesSince(devID, vcore, vmemory, itime, time.Time{})
+}
+
+// addUsedResourcesSince records the used GPU core and memory of a container
+// whose remaining time decays since given time, zero means now
+func (n *NodeInfo) addUsedResourcesSince(devID int, vcore uint, vmemory uint, itime int, since time.Time) error {
+	dev := n.devs[devID]
+	if since.IsZero() {
+		since = dev.clock.Now()
+	}
+	err := dev.addUsedResourcesSince(vcore, vmemory, itime, since)
 	if err != nil {
 		klog.Infof("failed to update used resource for node %s dev %d due to %v", n.name, devID, err)
 		return err
@@ -118,6 +480,91 @@ func (n *NodeInfo) AddUsedResources(devID int, vcore uint, vmemory uint, itime i
 	return nil
 }
 
+// RemoveUsedResources releases the GPU core and memory recorded by
+// AddUsedResources, see DeviceInfo.RemoveUsedResources
+func (n *NodeInfo) RemoveUsedResources(devID int, vcore uint, vmemory uint, itime int) error {
+	dev, ok := n.devs[devID]
+	if !ok {
+		return fmt.Errorf("device %d not found on node %s", devID, n.name)
+	}
+	// the node only accounts what its device actually releases
+	usedCore, usedMemory := dev.UsedCores(), dev.UsedMemory()
+	dev.RemoveUsedResources(vcore, vmemory, itime)
+	n.usedCore = subOrZero(n.usedCore, usedCore-dev.UsedCores())
+	n.usedMemory = subOrZero(n.usedMemory, usedMemory-dev.UsedMemory())
+	return nil
+}
+
+// SetClock sets the clock the remaining times of the containers on the
+// devices decay by, see DeviceInfo.IsolatedTime
+func (n *NodeInfo) SetClock(c clock.PassiveClock) {
+	for _, dev := range n.devs {
+		dev.SetClock(c)
+	}
+}
+
+// UseMIGInstance marks the MIG instance instID of device devID as used
+func (n *NodeInfo) UseMIGInstance(devID, instID int) error {
+	dev, ok := n.devs[devID]
+	if !ok {
+		return fmt.Errorf("device %d not found on node %s", devID, n.name)
+	}
+	return dev.UseMIGInstance(instID)
+}
+
+// Clone returns a deep copy of this NodeInfo, so the allocation state of
+// the copy can be changed without affecting the original one, e.g. to
+// evaluate a pod without side effects or to take a snapshot for rollback.
+// The devices, including their MIG instances, and the used resources are
+// duplicated, while the original node structure of kubernetes is shared
+// since it's read only. The copy is unlocked.
+func (n *NodeInfo) Clone() *NodeInfo {
+	devMap := make(map[int]*DeviceInfo, len(n.devs))
+	for id, dev := range n.devs {
+		devMap[id] = dev.clone()
+	}
+	return &NodeInfo{
+		name:        n.name,
+		node:        n.node,
+		devs:        devMap,
+		deviceCount: n.deviceCount,
+		totalMemory: n.totalMemory,
+		usedCore:    n.usedCore,
+		usedMemory:  n.usedMemory,
+		vendor:      n.vendor,
+		topology:    n.topology,
+		invalid:     n.invalid,
+
+		maxContainersPerDevice: n.maxContainersPerDevice,
+		maxDevicesPerPod:       n.maxDevicesPerPod,
+		sharePolicy:            n.sharePolicy,
+		disableExclusive:       n.disableExclusive,
+		shareWeights:           n.shareWeights,
+		shareSortOrder:         n.shareSortOrder,
+		shareWeighting:         n.shareWeighting,
+		distinctOwnersWeight:   n.distinctOwnersWeight,
+		timeOverlapWeight:      n.timeOverlapWeight,
+		timeSimilarityWeight:   n.timeSimilarityWeight,
+		minFreeMemory:          n.minFreeMemory,
+		fragmentationWeight:    n.fragmentationWeight,
+		ownerLabel:             n.ownerLabel,
+		lowPriorityThreshold:   n.lowPriorityThreshold,
+		maxECCErrors:           n.maxECCErrors,
+		inconsistentBlocks:     n.inconsistentBlocks,
+		unusableShare:          n.unusableShare,
+	}
+}
+
+// Restore resets the allocation state of this NodeInfo to the given
+// snapshot which is returned by Clone
+func (n *NodeInfo) Restore(snapshot *NodeInfo) {
+	for id, dev := range snapshot.devs {
+		*n.devs[id] = *dev.clone()
+	}
+	n.usedCore = snapshot.usedCore
+	n.usedMemory = snapshot.usedMemory
+}
+
 // GetDeviceCount returns the number of GPU devices
 func (n *NodeInfo) GetDeviceCount() int {
 	return n.deviceCount
@@ -128,6 +575,249 @@ func (n *NodeInfo) GetDeviceMap() map[int]*DeviceInfo {
 	return n.devs
 }
 
+// SchedulableDevices returns the GPU devices which accept new containers,
+// ordered by device idx. Unhealthy, draining and blacklisted devices are
+// excluded, while their used resources are still accounted by this node.
+func (n *NodeInfo) SchedulableDevices() []*DeviceInfo {
+	return n.AppendSchedulableDevices(make([]*DeviceInfo, 0, len(n.devs)))
+}
+
+// AppendSchedulableDevices appends the devices SchedulableDevices returns
+// to devs, and returns the extended slice. It lets the caller reuse a slice
+// instead of allocating one each time.
+func (n *NodeInfo) AppendSchedulableDevices(devs []*DeviceInfo) []*DeviceInfo {
+	for i := 0; i < n.deviceCount; i++ {
+		if dev, ok := n.devs[i]; ok && dev.IsSchedulable() {
+			devs = append(devs, dev)
+		}
+	}
+	return devs
+}
+
+// Vendor returns the vendor of the GPU devices of this node
+func (n *NodeInfo) Vendor() util.Vendor {
+	return n.vendor
+}
+
+// MaxContainersPerDevice returns the max number of containers sharing a GPU
+// device, 0 means no limit
+func (n *NodeInfo) MaxContainersPerDevice() uint {
+	return n.maxContainersPerDevice
+}
+
+// MaxDevicesPerPod returns the max number of GPU devices the containers of
+// a pod take together, 0 means no limit
+func (n *NodeInfo) MaxDevicesPerPod() uint {
+	return n.maxDevicesPerPod
+}
+
+// ShareDisabled tells if a container requesting less than a GPU is
+// rejected, see config.ShareRejected
+func (n *NodeInfo) ShareDisabled() bool {
+	return n.sharePolicy == config.ShareRejected
+}
+
+// ExclusiveDisabled tells if a container requesting a GPU or more is
+// rejected, see config.Config.DisableExclusive
+func (n *NodeInfo) ExclusiveDisabled() bool {
+	return n.disableExclusive
+}
+
+// GPURequestOf returns the vcore and vmemory given container of pod is
+// allocated for on this node, see util.GetGPURequestOfContainer. A
+// container requesting less than a GPU requests a whole one if the share
+// policy rounds it up. The pods allocated already are charged by
+// chargedRequestOf instead, the policy may have changed since.
+func (n *NodeInfo) GPURequestOf(pod *v1.Pod, container *v1.Container) (vcore, vmemory uint, err error) {
+	vcore, vmemory, err = util.GetGPURequestOfContainer(pod, container, n.vendor)
+	if err != nil {
+		return 0, 0, err
+	}
+	if vcore < util.HundredCore && n.RoundsUpShare() {
+		return util.HundredCore, 0, nil
+	}
+	return vcore, vmemory, nil
+}
+
+// RoundsUpShare tells if a container requesting less than a GPU is given a
+// whole one, see config.ShareRoundedUp
+func (n *NodeInfo) RoundsUpShare() bool {
+	return n.sharePolicy == config.ShareRoundedUp
+}
+
+// chargedRequestOf returns the vcore and vmemory given container of a pod
+// allocated already is charged for. A container requesting less than a GPU
+// is charged a whole one if it was rounded up at allocation, see
+// util.IsRoundedUpPod, whatever the share policy is now.
+func (n *NodeInfo) chargedRequestOf(pod *v1.Pod, container *v1.Container) (vcore, vmemory uint, err error) {
+	vcore, vmemory, err = util.GetGPURequestOfContainer(pod, container, n.vendor)
+	if err != nil {
+		return 0, 0, err
+	}
+	if vcore < util.HundredCore && util.IsRoundedUpPod(pod) {
+		return util.HundredCore, 0, nil
+	}
+	return vcore, vmemory, nil
+}
+
+// MaxECCErrors returns the ECC error count above which a GPU device of this
+// node is not a placement candidate, ok is false if there is no limit
+func (n *NodeInfo) MaxECCErrors() (max uint, ok bool) {
+	if n.maxECCErrors == nil {
+		return 0, false
+	}
+	return *n.maxECCErrors, true
+}
+
+// Invalid returns why no pod can be allocated on this node, nil if they can
+func (n *NodeInfo) Invalid() error {
+	return n.invalid
+}
+
+// InconsistentMemoryBlocks returns why the GPU devices of this node don't
+// allocate memory in blocks of the same size dividing their memory, nil if
+// they do. The node is only invalid for it under
+// config.RejectInconsistentMemoryBlocks.
+func (n *NodeInfo) InconsistentMemoryBlocks() error {
+	return n.inconsistentBlocks
+}
+
+// validateMemoryBlocks checks the GPU devices allocate memory in blocks of
+// the same size, which divides the memory of each of them
+func (n *NodeInfo) validateMemoryBlocks() error {
+	var block uint
+	for id := 0; id < n.deviceCount; id++ {
+		dev, ok := n.devs[id]
+		if !ok {
+			continue
+		}
+		if block == 0 {
+			block = dev.MemoryBlock()
+		} else if dev.MemoryBlock() != block {
+			return fmt.Errorf("GPU %d has memory blocks of %d, others have %d", id, dev.MemoryBlock(), block)
+		}
+		if dev.TotalMemory()%dev.MemoryBlock() != 0 {
+			return fmt.Errorf("memory %d of GPU %d isn't a multiple of its blocks of %d",
+				dev.TotalMemory(), id, dev.MemoryBlock())
+		}
+	}
+	return nil
+}
+
+// UnusableShare returns why a GPU device of this node can't take any shared
+// container, since the memory it keeps free after placing one isn't less
+// than its schedulable memory, nil if every device can. The shared
+// containers are only placed on the other devices.
+func (n *NodeInfo) UnusableShare() error {
+	return n.unusableShare
+}
+
+// validateMinFreeMemory checks the memory each GPU device keeps free after
+// placing a shared container is less than its schedulable memory
+func (n *NodeInfo) validateMinFreeMemory() error {
+	if n.minFreeMemory == 0 {
+		return nil
+	}
+	for id := 0; id < n.deviceCount; id++ {
+		if dev, ok := n.devs[id]; ok && dev.SchedulableMemory() <= n.minFreeMemory {
+			return fmt.Errorf("GPU %d has %d schedulable vmemory, no more than the %d kept free",
+				id, dev.SchedulableMemory(), n.minFreeMemory)
+		}
+	}
+	return nil
+}
+
+// Topology returns the interconnect topology of the GPU devices, nil if the
+// node doesn't tell it. It's shared by the clones and must not be modified.
+func (n *NodeInfo) Topology() *topology.Topology {
+	return n.topology
+}
+
+// MinFreeMemoryPerDevice returns the vmemory a GPU device of this node
+// keeps free after placing a shared container
+func (n *NodeInfo) MinFreeMemoryPerDevice() uint {
+	return n.minFreeMemory
+}
+
+// ShareWeights returns the weights share mode ranks the devices of this
+// node by, see config.DefaultShareWeights
+func (n *NodeInfo) ShareWeights() []float64 {
+	if len(n.shareWeights) != len(config.DefaultShareWeights) {
+		return config.DefaultShareWeights
+	}
+	return n.shareWeights
+}
+
+// ShareSortOrder returns the LessFuncs share mode sorts the candidate
+// devices of this node by, see config.DefaultShareSortOrder
+func (n *NodeInfo) ShareSortOrder() []LessFunc {
+	if len(n.shareSortOrder) == 0 {
+		return lessFuncsOf(config.DefaultShareSortOrder)
+	}
+	return n.shareSortOrder
+}
+
+// ShareWeighting returns how share mode decides the weights of the criteria
+// on this node, see config.StaticWeighting
+func (n *NodeInfo) ShareWeighting() string {
+	if n.shareWeighting == "" {
+		return config.StaticWeighting
+	}
+	return n.shareWeighting
+}
+
+// DistinctOwnersWeight returns the weight of the distinct workload owners
+// on a device when share mode ranks the devices of this node, 0 means the
+// owners are not taken into account
+func (n *NodeInfo) DistinctOwnersWeight() float64 {
+	return n.distinctOwnersWeight
+}
+
+// TimeOverlapWeight returns the weight of the time a container would run
+// together with the containers on a device when share mode ranks the
+// devices of this node, 0 means the overlap is not taken into account
+func (n *NodeInfo) TimeOverlapWeight() float64 {
+	return n.timeOverlapWeight
+}
+
+// TimeSimilarityWeight returns the weight of how far the estimated times of
+// the containers on a device are from the container's when share mode
+// ranks the devices of this node, 0 means it's not taken into account
+func (n *NodeInfo) TimeSimilarityWeight() float64 {
+	return n.timeSimilarityWeight
+}
+
+// FragmentationWeight returns how much the node score counts the
+// fragmentation of the GPUs of this node, 0 means it's not counted
+func (n *NodeInfo) FragmentationWeight() float64 {
+	return n.fragmentationWeight
+}
+
+// OwnerOf returns the workload owner of given pod, see util.GetOwnerOfPod
+func (n *NodeInfo) OwnerOf(pod *v1.Pod) string {
+	return util.GetOwnerOfPod(pod, n.ownerLabel)
+}
+
+// IsLowPriority tells if given pod packs into the busy GPUs of this node,
+// see config.Config.LowPriorityThreshold
+func (n *NodeInfo) IsLowPriority(pod *v1.Pod) bool {
+	if n.lowPriorityThreshold == nil {
+		return false
+	}
+	var priority int32
+	if pod.Spec.Priority != nil {
+		priority = *pod.Spec.Priority
+	}
+	return priority < *n.lowPriorityThreshold
+}
+
+// AddOwner records a container of given workload owner on device devID
+func (n *NodeInfo) AddOwner(devID int, owner string) {
+	if dev, ok := n.devs[devID]; ok {
+		dev.AddOwner(owner)
+	}
+}
+
 // GetNode returns the original node structure of kubernetes
 func (n *NodeInfo) GetNode() *v1.Node {
 	return n.node
@@ -140,12 +830,76 @@ func (n *NodeInfo) GetName() string {
 
 // GetAvailableCore returns the remaining cores of this node
 func (n *NodeInfo) GetAvailableCore() int {
-	return n.deviceCount*util.HundredCore - int(n.usedCore)
+	var total uint
+	for _, dev := range n.devs {
+		total += dev.AllocatableCores()
+	}
+	return int(total)
+}
+
+// GetSchedulableCore returns the cores of this node except the reserved
+func (n *NodeInfo) GetSchedulableCore() int {
+	var total uint
+	for _, dev := range n.devs {
+		total += dev.SchedulableCores()
+	}
+	return int(total)
 }
 
 // GetAvailableMemory returns the remaining memory of this node
 func (n *NodeInfo) GetAvailableMemory() int {
-	return int(n.totalMemory - n.usedMemory)
+	var total uint
+	for _, dev := range n.devs {
+		total += dev.AllocatableMemory()
+	}
+	return int(total)
+}
+
+// FreeCores returns the cores of this node which can still be allocated to
+// containers requesting cores. Only schedulable devices not MIG enabled
+// count, and their reserved cores are excluded.
+func (n *NodeInfo) FreeCores() int {
+	var total uint
+	for _, dev := range n.allocatableDevices() {
+		total += dev.AllocatableCores()
+	}
+	return int(total)
+}
+
+// FreeMemory returns the memory of this node which can still be allocated
+// to containers requesting cores, counted the same way as FreeCores.
+func (n *NodeInfo) FreeMemory() int {
+	var total uint
+	for _, dev := range n.allocatableDevices() {
+		total += dev.AllocatableMemory()
+	}
+	return int(total)
+}
+
+// FreeWholeGPUs returns the number of devices of this node which can be
+// allocated exclusively, that is, none of their schedulable cores is used.
+// A device whose cores are all reserved is never free.
+func (n *NodeInfo) FreeWholeGPUs() int {
+	count := 0
+	for _, dev := range n.allocatableDevices() {
+		if dev.SchedulableCores() > 0 && dev.AllocatableCores() == dev.SchedulableCores() {
+			count++
+		}
+	}
+	return count
+}
+
+// allocatableDevices returns the schedulable devices which are allocated by
+// cores and memory, i.e. not MIG enabled
+func (n *NodeInfo) allocatableDevices() []*DeviceInfo {
+	devs := n.SchedulableDevices()
+	ret := devs[:0]
+	for _, dev := range devs {
+		if !dev.IsMIGEnabled() {
+			ret = append(ret, dev)
+		}
+	}
+	return ret
 }
 
 type nodeInfoPriority struct {
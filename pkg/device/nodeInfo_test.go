@@ -0,0 +1,466 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package device
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
+
+	"tkestack.io/gpu-admission/pkg/config"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+func newTestNode(name string, deviceCount, totalMemory int) *v1.Node {
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Annotations: make(map[string]string),
+		},
+		Status: v1.NodeStatus{
+			Capacity: v1.ResourceList{
+				v1.ResourceName(util.VCoreAnnotation):   resource.MustParse(fmt.Sprintf("%d", deviceCount*util.HundredCore)),
+				v1.ResourceName(util.VMemoryAnnotation): resource.MustParse(fmt.Sprintf("%d", totalMemory)),
+			},
+		},
+	}
+}
+
+func TestNodeInfoClone(t *testing.T) {
+	node := newTestNode("testnode", 2, 16)
+	node.Annotations[util.GPUMIGInstances] = "1:1g.5gb,1:1g.5gb"
+	n := NewNodeInfo(node, nil)
+	if err := n.AddUsedResources(0, 10, 1, 5); err != nil {
+		t.Fatalf("failed to add used resources: %v", err)
+	}
+
+	clone := n.Clone()
+	if !reflect.DeepEqual(clone.GetDeviceMap(), n.GetDeviceMap()) {
+		t.Fatalf("clone differs from the original")
+	}
+	if err := clone.AddUsedResources(0, 20, 2, 10); err != nil {
+		t.Fatalf("failed to add used resources: %v", err)
+	}
+	if err := clone.UseMIGInstance(1, 0); err != nil {
+		t.Fatalf("failed to use MIG instance: %v", err)
+	}
+
+	dev := n.GetDeviceMap()[0]
+	if dev.AllocatableCores() != 90 || dev.AllocatableMemory() != 7 ||
+		dev.NumberofContainer() != 1 || dev.IsolatedTime() != 5 {
+		t.Fatalf("mutations of the clone leak into dev 0: %+v", dev)
+	}
+	if n.GetAvailableCore() != 190 || n.GetAvailableMemory() != 15 {
+		t.Fatalf("mutations of the clone leak into the node: %d cores, %d memory",
+			n.GetAvailableCore(), n.GetAvailableMemory())
+	}
+	if free := n.GetDeviceMap()[1].FreeMIGInstances("1g.5gb"); len(free) != 2 {
+		t.Fatalf("mutations of the clone leak into MIG instances: %v", free)
+	}
+
+	// restoring from the snapshot drops the mutations of the clone
+	clone.Restore(n)
+	if !reflect.DeepEqual(clone.GetDeviceMap(), n.GetDeviceMap()) {
+		t.Fatalf("restored clone differs from the original")
+	}
+	if clone.GetAvailableCore() != n.GetAvailableCore() {
+		t.Fatalf("expect %d cores after restore, got %d", n.GetAvailableCore(), clone.GetAvailableCore())
+	}
+}
+
+func TestNodeInfoFree(t *testing.T) {
+	testCases := []struct {
+		name        string
+		annotations map[string]string
+		reserved    uint
+		used        map[int]uint
+		cores       int
+		memory      int
+		wholeGPUs   int
+	}{
+		{
+			name:      "all free",
+			cores:     400,
+			memory:    32,
+			wholeGPUs: 4,
+		},
+		{
+			name:      "partially used",
+			used:      map[int]uint{0: 30, 1: 100},
+			cores:     270,
+			memory:    27,
+			wholeGPUs: 2,
+		},
+		{
+			name: "mixed device states",
+			annotations: map[string]string{
+				util.UnhealthyGPUIndexes: "0",
+				util.DrainingGPUIndexes:  "1",
+				util.GPUBlacklist:        "2",
+			},
+			used:      map[int]uint{0: 50},
+			cores:     100,
+			memory:    8,
+			wholeGPUs: 1,
+		},
+		{
+			name:        "MIG enabled",
+			annotations: map[string]string{util.GPUMIGInstances: "3:1g.5gb"},
+			used:        map[int]uint{1: 20},
+			cores:       280,
+			memory:      24,
+			wholeGPUs:   2,
+		},
+		{
+			name:      "reserved",
+			reserved:  10,
+			used:      map[int]uint{0: 20},
+			cores:     340,
+			memory:    32,
+			wholeGPUs: 3,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			node := newTestNode("testnode", 4, 32)
+			for k, v := range tc.annotations {
+				node.Annotations[k] = v
+			}
+			cfg := config.Default()
+			cfg.ReservedCoresPerDevice = tc.reserved
+			n := NewNodeInfoWithConfig(node, nil, cfg)
+			for id, cores := range tc.used {
+				if err := n.AddUsedResources(id, cores, cores/25, 0); err != nil {
+					t.Fatalf("failed to add used resources: %v", err)
+				}
+			}
+			if n.FreeCores() != tc.cores || n.FreeMemory() != tc.memory ||
+				n.FreeWholeGPUs() != tc.wholeGPUs {
+				t.Fatalf("expect %d cores, %d memory, %d whole GPUs free, got %d, %d, %d",
+					tc.cores, tc.memory, tc.wholeGPUs,
+					n.FreeCores(), n.FreeMemory(), n.FreeWholeGPUs())
+			}
+		})
+	}
+}
+
+func TestNodeInfoRemoveUsedResources(t *testing.T) {
+	n := NewNodeInfo(newTestNode("testnode", 2, 16), nil)
+	if err := n.AddUsedResources(0, 30, 2, 5); err != nil {
+		t.Fatalf("failed to add used resources: %v", err)
+	}
+	if err := n.AddUsedResources(0, 20, 1, 10); err != nil {
+		t.Fatalf("failed to add used resources: %v", err)
+	}
+
+	if err := n.RemoveUsedResources(0, 30, 2, 5); err != nil {
+		t.Fatalf("failed to remove used resources: %v", err)
+	}
+	dev := n.GetDeviceMap()[0]
+	if dev.UsedCores() != 20 || dev.UsedMemory() != 1 ||
+		dev.NumberofContainer() != 1 || dev.IsolatedTime() != 10 {
+		t.Fatalf("unexpected dev 0 after release: %+v", dev)
+	}
+	if overlap := dev.TimeOverlap(100); overlap != 10 {
+		t.Fatalf("expect only the remaining container to overlap, got %d", overlap)
+	}
+
+	// a double release clamps at zero
+	for i := 0; i < 2; i++ {
+		if err := n.RemoveUsedResources(0, 30, 2, 10); err != nil {
+			t.Fatalf("failed to remove used resources: %v", err)
+		}
+	}
+	if dev.UsedCores() != 0 || dev.UsedMemory() != 0 ||
+		dev.NumberofContainer() != 0 || dev.IsolatedTime() != 0 {
+		t.Fatalf("unexpected dev 0 after double release: %+v", dev)
+	}
+	if n.GetAvailableCore() != 200 || n.GetAvailableMemory() != 16 {
+		t.Fatalf("expect the node fully released, got %d cores, %d memory",
+			n.GetAvailableCore(), n.GetAvailableMemory())
+	}
+	// the other device is not affected by the release of dev 0
+	if err := n.AddUsedResources(1, 50, 4, 0); err != nil {
+		t.Fatalf("failed to add used resources: %v", err)
+	}
+	if err := n.RemoveUsedResources(0, 50, 4, 0); err != nil {
+		t.Fatalf("failed to remove used resources: %v", err)
+	}
+	if n.GetDeviceMap()[1].UsedCores() != 50 || n.usedCore != 50 || n.usedMemory != 4 {
+		t.Fatalf("release of dev 0 leaks into the node: %d cores, %d memory",
+			n.usedCore, n.usedMemory)
+	}
+
+	if err := n.RemoveUsedResources(5, 10, 1, 0); err == nil {
+		t.Fatalf("expect an error for an unknown device")
+	}
+}
+
+func TestNodeInfoIsolatedTimeDecay(t *testing.T) {
+	fakeClock := clock.NewFakePassiveClock(time.Now())
+	n := NewNodeInfo(newTestNode("testnode", 1, 8), nil)
+	n.SetClock(fakeClock)
+	if err := n.AddUsedResources(0, 10, 1, 60); err != nil {
+		t.Fatalf("failed to add used resources: %v", err)
+	}
+	fakeClock.SetTime(fakeClock.Now().Add(20 * util.EstimatedTimeUnit))
+	if err := n.AddUsedResources(0, 10, 1, 30); err != nil {
+		t.Fatalf("failed to add used resources: %v", err)
+	}
+
+	dev := n.GetDeviceMap()[0]
+	for _, cs := range []struct {
+		elapsed  time.Duration
+		isolated uint
+		overlap  uint
+		distance uint
+	}{
+		// 40 and 30 left
+		{elapsed: 0, isolated: 40, overlap: 70, distance: 5},
+		// 15 and 5 left
+		{elapsed: 25 * util.EstimatedTimeUnit, isolated: 15, overlap: 20, distance: 25},
+		// both should have finished
+		{elapsed: 20 * util.EstimatedTimeUnit, isolated: 0, overlap: 0, distance: 0},
+	} {
+		fakeClock.SetTime(fakeClock.Now().Add(cs.elapsed))
+		if got := dev.IsolatedTime(); got != cs.isolated {
+			t.Fatalf("expect isolated time %d, got %d", cs.isolated, got)
+		}
+		if got := dev.TimeOverlap(100); got != cs.overlap {
+			t.Fatalf("expect overlap %d, got %d", cs.overlap, got)
+		}
+		if got, ok := dev.TimeDistance(35); got != cs.distance || ok != (cs.isolated > 0) {
+			t.Fatalf("expect distance %d, got %d of a busy device %v", cs.distance, got, ok)
+		}
+	}
+
+	// a clone decays by the same clock
+	if got := n.Clone().GetDeviceMap()[0].IsolatedTime(); got != 0 {
+		t.Fatalf("expect the clone decayed, got %d", got)
+	}
+}
+
+func TestNodeInfoIsolatedTimeDecayOnRebuild(t *testing.T) {
+	predicated := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	fakeClock := clock.NewFakePassiveClock(predicated)
+	vgpu := v1.ResourceList{
+		v1.ResourceName(util.VCoreAnnotation):   resource.MustParse("30"),
+		v1.ResourceName(util.VMemoryAnnotation): resource.MustParse("2"),
+	}
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "pod",
+			UID:  "pod",
+			Annotations: map[string]string{
+				util.EstimatedTime + "0":           "60",
+				util.EstimatedTime + "1":           "60",
+				util.PredicateGPUIndexPrefix + "0": "0",
+				util.PredicateGPUIndexPrefix + "1": "1",
+				util.PredicateTimeAnnotation:       fmt.Sprintf("%d", predicated.UnixNano()),
+			},
+		},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{newChargeTestContainer("c0", vgpu), newChargeTestContainer("c1", vgpu)},
+		},
+		Status: v1.PodStatus{
+			ContainerStatuses: []v1.ContainerStatus{
+				{Name: "c0"},
+				// c1 started 10 after the pod was predicated
+				{Name: "c1", State: v1.ContainerState{Running: &v1.ContainerStateRunning{
+					StartedAt: metav1.NewTime(predicated.Add(10 * util.EstimatedTimeUnit)),
+				}}},
+			},
+		},
+	}
+
+	// each rebuild decays by the time since the pod was predicated or the
+	// container started, not since the rebuild
+	for _, elapsed := range []time.Duration{20 * util.EstimatedTimeUnit, 45 * util.EstimatedTimeUnit} {
+		fakeClock.SetTime(predicated.Add(elapsed))
+		n := NewNodeInfo(newTestNode("testnode", 2, 8), []*v1.Pod{pod})
+		n.SetClock(fakeClock)
+		left := uint(60 - elapsed/util.EstimatedTimeUnit)
+		if got := n.GetDeviceMap()[0].IsolatedTime(); got != left {
+			t.Fatalf("expect %d left of the pending container, got %d", left, got)
+		}
+		if got := n.GetDeviceMap()[1].IsolatedTime(); got != left+10 {
+			t.Fatalf("expect %d left of the running container, got %d", left+10, got)
+		}
+		if got := n.GetDeviceMap()[0].TimeOverlap(100); got != left {
+			t.Fatalf("expect an overlap of %d, got %d", left, got)
+		}
+	}
+}
+
+func TestNodeInfoMemoryBlocks(t *testing.T) {
+	testCases := []struct {
+		name    string
+		blocks  string
+		invalid bool
+	}{
+		{name: "no block"},
+		{name: "same blocks", blocks: "4,4"},
+		{name: "different blocks", blocks: "4,2", invalid: true},
+		{name: "blocks not dividing the memory", blocks: "3,3", invalid: true},
+	}
+
+	for _, cs := range testCases {
+		node := newTestNode("testnode", 2, 16)
+		if cs.blocks != "" {
+			node.Annotations[util.GPUMemoryBlocks] = cs.blocks
+		}
+		if err := NewNodeInfo(node, nil).Invalid(); err != nil {
+			t.Fatalf("%s: a node should only be logged by default, got %v", cs.name, err)
+		}
+		if inconsistent := NewNodeInfo(node, nil).InconsistentMemoryBlocks() != nil; inconsistent != cs.invalid {
+			t.Fatalf("%s: expect inconsistent %v, got %v", cs.name, cs.invalid, inconsistent)
+		}
+
+		cfg := config.Default()
+		cfg.RejectInconsistentMemoryBlocks = true
+		nodeInfo := NewNodeInfoWithConfig(node, nil, cfg)
+		if invalid := nodeInfo.Invalid() != nil; invalid != cs.invalid {
+			t.Fatalf("%s: expect invalid %v, got %v", cs.name, cs.invalid, nodeInfo.Invalid())
+		}
+		if invalid := nodeInfo.Clone().Invalid() != nil; invalid != cs.invalid {
+			t.Fatalf("%s: the clone should be invalid %v too", cs.name, cs.invalid)
+		}
+	}
+}
+
+func newChargeTestContainer(name string, limits v1.ResourceList) v1.Container {
+	return v1.Container{Name: name, Resources: v1.ResourceRequirements{Limits: limits}}
+}
+
+// usageOf returns the used cores, memory, containers and owners of each
+// device in the order of ids
+func usageOf(n *NodeInfo) [][4]uint {
+	var usage [][4]uint
+	for id := 0; id < n.GetDeviceCount(); id++ {
+		dev := n.GetDeviceMap()[id]
+		usage = append(usage, [4]uint{dev.UsedCores(), dev.UsedMemory(), dev.NumberofContainer(),
+			uint(dev.DistinctOwners())})
+	}
+	return usage
+}
+
+func TestNodeInfoAddRemovePod(t *testing.T) {
+	node := newTestNode("testnode", 3, 24)
+	node.Annotations[util.GPUMIGInstances] = "2:1g.5gb,2:1g.5gb"
+	vgpu := func(cores, memory int) v1.ResourceList {
+		return v1.ResourceList{
+			v1.ResourceName(util.VCoreAnnotation):   resource.MustParse(fmt.Sprintf("%d", cores)),
+			v1.ResourceName(util.VMemoryAnnotation): resource.MustParse(fmt.Sprintf("%d", memory)),
+		}
+	}
+	shared := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "shared",
+			UID:  "shared",
+			Annotations: map[string]string{
+				util.EstimatedTime + "0":               "10",
+				util.PredicateGPUIndexPrefix + "0":     "0",
+				util.PredicateGPUInitIndexPrefix + "0": "0",
+				util.PredicateMIGInstancePrefix + "1":  "2:1",
+			},
+			OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "rs", UID: "rs"}},
+		},
+		Spec: v1.PodSpec{
+			InitContainers: []v1.Container{newChargeTestContainer("init", vgpu(60, 2))},
+			Containers: []v1.Container{
+				newChargeTestContainer("c0", vgpu(30, 2)),
+				newChargeTestContainer("c1", v1.ResourceList{
+					v1.ResourceName(util.MIGResourcePrefix + "1g.5gb"): resource.MustParse("1"),
+				}),
+			},
+		},
+	}
+	exclusive := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "exclusive",
+			UID:         "exclusive",
+			Annotations: map[string]string{util.PredicateGPUIndexPrefix + "0": "1"},
+		},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{newChargeTestContainer("c0", vgpu(100, 8))},
+		},
+	}
+
+	n := NewNodeInfo(node, nil)
+	empty := usageOf(n)
+	sharedCharge := n.AddPod(shared)
+	exclusiveCharge := n.AddPod(exclusive)
+	built := NewNodeInfo(node, []*v1.Pod{shared, exclusive})
+	if !reflect.DeepEqual(usageOf(n), usageOf(built)) {
+		t.Fatalf("expect the pods added to charge %v as built of them, got %v", usageOf(built), usageOf(n))
+	}
+	if n.GetAvailableCore() != built.GetAvailableCore() || n.GetAvailableMemory() != built.GetAvailableMemory() {
+		t.Fatalf("expect %d cores %d memory available, got %d, %d", built.GetAvailableCore(),
+			built.GetAvailableMemory(), n.GetAvailableCore(), n.GetAvailableMemory())
+	}
+	if free := n.GetDeviceMap()[2].FreeMIGInstances("1g.5gb"); !reflect.DeepEqual(free, []int{0}) {
+		t.Fatalf("expect MIG instance 1 used, free %v", free)
+	}
+
+	n.RemovePod(sharedCharge)
+	if !reflect.DeepEqual(usageOf(n), usageOf(NewNodeInfo(node, []*v1.Pod{exclusive}))) {
+		t.Fatalf("expect the shared pod released, got %v", usageOf(n))
+	}
+	if free := n.GetDeviceMap()[2].FreeMIGInstances("1g.5gb"); len(free) != 2 {
+		t.Fatalf("expect the MIG instance released, free %v", free)
+	}
+	n.RemovePod(exclusiveCharge)
+	n.RemovePod(nil)
+	if !reflect.DeepEqual(usageOf(n), empty) || n.GetAvailableCore() != 300 || n.GetAvailableMemory() != 24 {
+		t.Fatalf("expect all released, got %v", usageOf(n))
+	}
+	if got := n.GetDeviceMap()[0].IsolatedTime(); got != 0 {
+		t.Fatalf("expect no remaining time once released, got %d", got)
+	}
+}
+
+func TestNodeInfoUnusableShare(t *testing.T) {
+	testCases := []struct {
+		name              string
+		minFree, reserved uint
+		unusable          bool
+	}{
+		{name: "nothing kept free"},
+		{name: "less than the memory", minFree: 4},
+		{name: "all of the memory", minFree: 8, unusable: true},
+		{name: "all of the schedulable memory", minFree: 4, reserved: 4, unusable: true},
+	}
+
+	for _, cs := range testCases {
+		cfg := config.Default()
+		cfg.MinFreeMemoryPerDevice, cfg.ReservedMemoryPerDevice = cs.minFree, cs.reserved
+		nodeInfo := NewNodeInfoWithConfig(newTestNode("testnode", 2, 16), nil, cfg)
+		if unusable := nodeInfo.UnusableShare() != nil; unusable != cs.unusable {
+			t.Fatalf("%s: expect unusable %v, got %v", cs.name, cs.unusable, nodeInfo.UnusableShare())
+		}
+		if unusable := nodeInfo.Clone().UnusableShare() != nil; unusable != cs.unusable {
+			t.Fatalf("%s: the clone should be unusable %v too", cs.name, cs.unusable)
+		}
+	}
+}
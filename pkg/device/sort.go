@@ -16,9 +16,22 @@
  */
 package device
 
+import (
+	"k8s.io/klog"
+
+	"tkestack.io/gpu-admission/pkg/config"
+)
+
 //LessFunc represents funcion to compare two DeviceInfo or NodeInfo
 type LessFunc func(p1, p2 interface{}) bool
 
+// Reverse returns a LessFunc in the reverse order of given one
+func Reverse(less LessFunc) LessFunc {
+	return func(p1, p2 interface{}) bool {
+		return less(p2, p1)
+	}
+}
+
 var (
 	// ByAllocatableCores compares two device or node by allocatable cores
 	ByAllocatableCores = func(p1, p2 interface{}) bool {
@@ -66,4 +79,88 @@ var (
 		}
 		return result
 	}
+
+	// ByUtilization compares two devices by the utilization reported by
+	// the node
+	ByUtilization = func(p1, p2 interface{}) bool {
+		var result bool
+		switch p1.(type) {
+		case *DeviceInfo:
+			d1 := p1.(*DeviceInfo)
+			d2 := p2.(*DeviceInfo)
+			result = d1.Utilization() < d2.Utilization()
+		}
+		return result
+	}
+
+	// ByTemperature compares two devices by the temperature reported by
+	// the node
+	ByTemperature = func(p1, p2 interface{}) bool {
+		var result bool
+		switch p1.(type) {
+		case *DeviceInfo:
+			d1 := p1.(*DeviceInfo)
+			d2 := p2.(*DeviceInfo)
+			result = d1.Temperature() < d2.Temperature()
+		}
+		return result
+	}
+
+	// ByPower compares two devices by the power draw reported by the node
+	ByPower = func(p1, p2 interface{}) bool {
+		var result bool
+		switch p1.(type) {
+		case *DeviceInfo:
+			d1 := p1.(*DeviceInfo)
+			d2 := p2.(*DeviceInfo)
+			result = d1.Power() < d2.Power()
+		}
+		return result
+	}
+
+	// ByNumberOfContainer compares two devices by the number of containers
+	// sharing them
+	ByNumberOfContainer = func(p1, p2 interface{}) bool {
+		var result bool
+		switch p1.(type) {
+		case *DeviceInfo:
+			d1 := p1.(*DeviceInfo)
+			d2 := p2.(*DeviceInfo)
+			result = d1.NumberofContainer() < d2.NumberofContainer()
+		}
+		return result
+	}
 )
+
+// lessFuncs are the LessFuncs of the names of config.ShareSortCriteria
+var lessFuncs = map[string]LessFunc{
+	config.SortByAllocatableCores:  ByAllocatableCores,
+	config.SortByAllocatableMemory: ByAllocatableMemory,
+	config.SortByID:                ByID,
+	config.SortByUtilization:       ByUtilization,
+	config.SortByTemperature:       ByTemperature,
+	config.SortByPower:             ByPower,
+	config.SortByNumberOfContainer: ByNumberOfContainer,
+}
+
+// LessFuncOf returns the LessFunc of given criterion name, ok is false if
+// there is no such criterion
+func LessFuncOf(name string) (less LessFunc, ok bool) {
+	less, ok = lessFuncs[name]
+	return less, ok
+}
+
+// lessFuncsOf returns the LessFuncs of given criterion names, the unknown
+// ones are skipped since the config has been validated
+func lessFuncsOf(names []string) []LessFunc {
+	ret := make([]LessFunc, 0, len(names))
+	for _, name := range names {
+		less, ok := LessFuncOf(name)
+		if !ok {
+			klog.Warningf("unknown sort criterion %q is ignored", name)
+			continue
+		}
+		ret = append(ret, less)
+	}
+	return ret
+}
@@ -0,0 +1,86 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package device
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"tkestack.io/gpu-admission/pkg/config"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+func TestLessFuncs(t *testing.T) {
+	node := newTestNode("testnode", 3, 8)
+	node.Annotations[util.GPUUtilizations] = "30,10,20"
+	node.Annotations[util.GPUTemperatures] = "60,70,50"
+	node.Annotations[util.GPUPowers] = "150,200,100"
+	nodeInfo := NewNodeInfo(node, nil)
+	for id, containers := range []int{1, 2, 0} {
+		for i := 0; i < containers; i++ {
+			if err := nodeInfo.AddUsedResources(id, 10, 1, 0); err != nil {
+				t.Fatalf("failed to add used resources: %v", err)
+			}
+		}
+	}
+
+	testCases := []struct {
+		name   string
+		less   LessFunc
+		expect []int
+	}{
+		{"by utilization", ByUtilization, []int{1, 2, 0}},
+		{"by temperature", ByTemperature, []int{2, 0, 1}},
+		{"by power", ByPower, []int{2, 0, 1}},
+		{"by number of container", ByNumberOfContainer, []int{2, 0, 1}},
+		{"by number of container reversed", Reverse(ByNumberOfContainer), []int{1, 0, 2}},
+	}
+	for _, tc := range testCases {
+		devs := nodeInfo.SchedulableDevices()
+		sort.SliceStable(devs, func(i, j int) bool { return tc.less(devs[i], devs[j]) })
+		var ids []int
+		for _, dev := range devs {
+			ids = append(ids, dev.GetID())
+		}
+		if !reflect.DeepEqual(ids, tc.expect) {
+			t.Fatalf("%s: expect devices %v, got %v", tc.name, tc.expect, ids)
+		}
+	}
+}
+
+func TestLessFuncsInvalidAnnotation(t *testing.T) {
+	node := newTestNode("testnode", 2, 8)
+	node.Annotations[util.GPUUtilizations] = "30,x"
+	nodeInfo := NewNodeInfo(node, nil)
+	for _, dev := range nodeInfo.GetDeviceMap() {
+		if dev.Utilization() != 0 {
+			t.Fatalf("expect no utilization of device %d, got %d", dev.GetID(), dev.Utilization())
+		}
+	}
+}
+
+func TestLessFuncOf(t *testing.T) {
+	for _, name := range config.ShareSortCriteria {
+		if _, ok := LessFuncOf(name); !ok {
+			t.Fatalf("expect a LessFunc of criterion %s", name)
+		}
+	}
+	if _, ok := LessFuncOf("age"); ok {
+		t.Fatalf("expect no LessFunc of an unknown criterion")
+	}
+}
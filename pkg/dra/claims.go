@@ -0,0 +1,286 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+// Package dra reads the GPU requests of the pods from their Dynamic
+// Resource Allocation claims, so they go through the same evaluation as
+// the requests by the extended resources
+package dra
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+var (
+	podsResource   = schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+	claimsResource = schema.GroupVersionResource{Group: "resource.k8s.io", Version: "v1", Resource: "resourceclaims"}
+)
+
+// Request is the vcore and vmemory a container claims
+type Request struct {
+	Cores  uint `json:"cores"`
+	Memory uint `json:"memory"`
+}
+
+// Resolver reads the GPU request of each container of a pod from the
+// opaque parameters of the driver in the ResourceClaims the container
+// refers to, e.g.
+//
+//	spec:
+//	  devices:
+//	    config:
+//	    - opaque:
+//	        driver: gpu.tencent.com
+//	        parameters: {"cores": 50, "memory": 2}
+//
+// The claims of a container are summed up. The pod and its claims are
+// read through the API, since the typed pods of this client predate
+// resourceClaims, and the requests of each pod are cached by its UID until
+// it's forgotten. A nil Resolver reads no claims.
+type Resolver struct {
+	client dynamic.Interface
+	driver string
+
+	sync.Mutex
+	// requests are those of each container by name, empty if the pod
+	// claims no GPU of the driver
+	requests map[types.UID]map[string]Request
+}
+
+// NewResolver returns a Resolver of the claims of given driver
+func NewResolver(client dynamic.Interface, driver string) *Resolver {
+	return &Resolver{client: client, driver: driver, requests: make(map[types.UID]map[string]Request)}
+}
+
+// Resolve returns a copy of pod whose containers claiming GPUs of the
+// driver have the vcore and vmemory of the claims as the limits of the
+// default vendor, or pod itself if none claims any
+func (r *Resolver) Resolve(ctx context.Context, pod *v1.Pod) (*v1.Pod, error) {
+	if r == nil {
+		return pod, nil
+	}
+	r.Lock()
+	requests, ok := r.requests[pod.UID]
+	r.Unlock()
+	if !ok {
+		var err error
+		if requests, err = r.read(ctx, pod); err != nil {
+			return nil, err
+		}
+		r.Lock()
+		r.requests[pod.UID] = requests
+		r.Unlock()
+	}
+	if len(requests) == 0 {
+		return pod, nil
+	}
+	ret := pod.DeepCopy()
+	apply(ret.Spec.InitContainers, requests)
+	apply(ret.Spec.Containers, requests)
+	return ret, nil
+}
+
+// ResolvePlaced resolves a pod the filter has placed on a node, by its
+// predicate annotations, the other pods aren't charged for any GPU and are
+// returned as is. A pod whose claims can't be read is returned as is too.
+func (r *Resolver) ResolvePlaced(pod *v1.Pod) *v1.Pod {
+	if r == nil || pod.Annotations[util.PredicateNode] == "" {
+		return pod
+	}
+	resolved, err := r.Resolve(context.Background(), pod)
+	if err != nil {
+		klog.Warningf("Failed to read the resource claims of pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		return pod
+	}
+	return resolved
+}
+
+// Forget drops the requests cached of the pod, e.g. once it's deleted
+func (r *Resolver) Forget(uid types.UID) {
+	if r == nil {
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+	delete(r.requests, uid)
+}
+
+// EventHandler returns a handler of the pod informer passing the pods
+// placed to h resolved, see ResolvePlaced, and forgetting the pods deleted
+func (r *Resolver) EventHandler(h cache.ResourceEventHandler) cache.ResourceEventHandler {
+	if r == nil {
+		return h
+	}
+	resolve := func(obj interface{}) interface{} {
+		if pod, ok := obj.(*v1.Pod); ok {
+			return r.ResolvePlaced(pod)
+		}
+		return obj
+	}
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) { h.OnAdd(resolve(obj)) },
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			h.OnUpdate(oldObj, resolve(newObj))
+		},
+		DeleteFunc: func(obj interface{}) {
+			h.OnDelete(obj)
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if pod, ok := obj.(*v1.Pod); ok {
+				r.Forget(pod.UID)
+			}
+		},
+	}
+}
+
+// apply sets the limits of the containers having a request
+func apply(containers []v1.Container, requests map[string]Request) {
+	vendor := util.DefaultVendor()
+	for i := range containers {
+		c := &containers[i]
+		request, ok := requests[c.Name]
+		if !ok {
+			continue
+		}
+		if c.Resources.Limits == nil {
+			c.Resources.Limits = make(v1.ResourceList)
+		}
+		c.Resources.Limits[v1.ResourceName(vendor.VCore)] = *resource.NewQuantity(int64(request.Cores), resource.DecimalSI)
+		c.Resources.Limits[v1.ResourceName(vendor.VMemory)] = *resource.NewQuantity(int64(request.Memory), resource.DecimalSI)
+	}
+}
+
+// read returns the requests of the containers of pod by their claims
+func (r *Resolver) read(ctx context.Context, pod *v1.Pod) (map[string]Request, error) {
+	obj, err := r.client.Resource(podsResource).Namespace(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pod: %v", err)
+	}
+	if obj.GetUID() != pod.UID {
+		return nil, fmt.Errorf("pod %s/%s has UID %s, expect %s", pod.Namespace, pod.Name, obj.GetUID(), pod.UID)
+	}
+	claimNames := claimNamesOf(obj.Object)
+	claims := make(map[string]Request)
+	requests := make(map[string]Request)
+	for _, field := range []string{"initContainers", "containers"} {
+		containers, _, _ := unstructured.NestedSlice(obj.Object, "spec", field)
+		for _, c := range containers {
+			container, ok := c.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			name, _, _ := unstructured.NestedString(container, "name")
+			refs, _, _ := unstructured.NestedSlice(container, "resources", "claims")
+			for _, ref := range refs {
+				claimRef, ok := ref.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				refName, _, _ := unstructured.NestedString(claimRef, "name")
+				request, ok := claims[refName]
+				if !ok {
+					if request, err = r.readClaim(ctx, pod, refName, claimNames); err != nil {
+						return nil, err
+					}
+					claims[refName] = request
+				}
+				if request == (Request{}) {
+					continue
+				}
+				total := requests[name]
+				total.Cores += request.Cores
+				total.Memory += request.Memory
+				requests[name] = total
+			}
+		}
+	}
+	return requests, nil
+}
+
+// claimNamesOf returns the names of the ResourceClaims of each claim of the
+// pod, either given by the pod or generated of a template
+func claimNamesOf(pod map[string]interface{}) map[string]string {
+	names := make(map[string]string)
+	for _, path := range [][]string{{"spec", "resourceClaims"}, {"status", "resourceClaimStatuses"}} {
+		claims, _, _ := unstructured.NestedSlice(pod, path...)
+		for _, c := range claims {
+			claim, ok := c.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			name, _, _ := unstructured.NestedString(claim, "name")
+			if claimName, _, _ := unstructured.NestedString(claim, "resourceClaimName"); claimName != "" {
+				names[name] = claimName
+			}
+		}
+	}
+	return names
+}
+
+// readClaim returns the request of the pod claim of given name, zero if
+// it's not of the driver
+func (r *Resolver) readClaim(ctx context.Context, pod *v1.Pod, name string,
+	claimNames map[string]string) (Request, error) {
+	claimName, ok := claimNames[name]
+	if !ok {
+		return Request{}, fmt.Errorf("claim %s of pod %s/%s isn't generated yet", name, pod.Namespace, pod.Name)
+	}
+	claim, err := r.client.Resource(claimsResource).Namespace(pod.Namespace).Get(ctx, claimName, metav1.GetOptions{})
+	if err != nil {
+		return Request{}, fmt.Errorf("failed to get resource claim %s: %v", claimName, err)
+	}
+	var request Request
+	configs, _, _ := unstructured.NestedSlice(claim.Object, "spec", "devices", "config")
+	for _, c := range configs {
+		config, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if driver, _, _ := unstructured.NestedString(config, "opaque", "driver"); driver != r.driver {
+			continue
+		}
+		parameters, ok, _ := unstructured.NestedFieldNoCopy(config, "opaque", "parameters")
+		if !ok {
+			continue
+		}
+		raw, err := json.Marshal(parameters)
+		if err != nil {
+			return Request{}, fmt.Errorf("malformed parameters of resource claim %s: %v", claimName, err)
+		}
+		var parsed Request
+		if err := json.Unmarshal(raw, &parsed); err != nil {
+			return Request{}, fmt.Errorf("malformed parameters of resource claim %s: %v", claimName, err)
+		}
+		request.Cores += parsed.Cores
+		request.Memory += parsed.Memory
+	}
+	return request, nil
+}
@@ -0,0 +1,176 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package dra
+
+import (
+	"context"
+	"testing"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/tools/cache"
+
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+const testDriver = "gpu.tencent.com"
+
+// newTestClaim returns a ResourceClaim of given name with the opaque
+// parameters of given driver
+func newTestClaim(name, driver string, parameters map[string]interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "resource.k8s.io/v1",
+		"kind":       "ResourceClaim",
+		"metadata":   map[string]interface{}{"name": name, "namespace": "test-ns"},
+		"spec": map[string]interface{}{
+			"devices": map[string]interface{}{
+				"config": []interface{}{
+					map[string]interface{}{
+						"opaque": map[string]interface{}{"driver": driver, "parameters": parameters},
+					},
+				},
+			},
+		},
+	}}
+}
+
+// newTestPod returns a pod whose container c0 refers to the claim gpu, of a
+// template, and c1 to the claim other, and the unstructured pod the API
+// serves with its claims
+func newTestPod() (*v1.Pod, *unstructured.Unstructured) {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "test-ns", UID: "uid"},
+		Spec:       v1.PodSpec{Containers: []v1.Container{{Name: "c0"}, {Name: "c1"}}},
+	}
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Pod",
+		"metadata":   map[string]interface{}{"name": "pod", "namespace": "test-ns", "uid": "uid"},
+		"spec": map[string]interface{}{
+			"resourceClaims": []interface{}{
+				map[string]interface{}{"name": "gpu", "resourceClaimTemplateName": "gpu-template"},
+				map[string]interface{}{"name": "other", "resourceClaimName": "other-claim"},
+			},
+			"containers": []interface{}{
+				map[string]interface{}{"name": "c0", "resources": map[string]interface{}{
+					"claims": []interface{}{map[string]interface{}{"name": "gpu"}},
+				}},
+				map[string]interface{}{"name": "c1", "resources": map[string]interface{}{
+					"claims": []interface{}{map[string]interface{}{"name": "other"}},
+				}},
+			},
+		},
+		"status": map[string]interface{}{
+			"resourceClaimStatuses": []interface{}{
+				map[string]interface{}{"name": "gpu", "resourceClaimName": "pod-gpu-abcde"},
+			},
+		},
+	}}
+	return pod, obj
+}
+
+func TestResolve(t *testing.T) {
+	pod, obj := newTestPod()
+	client := fake.NewSimpleDynamicClient(runtime.NewScheme(), obj,
+		newTestClaim("pod-gpu-abcde", testDriver, map[string]interface{}{"cores": int64(50), "memory": int64(2)}),
+		newTestClaim("other-claim", "nic.example.com", map[string]interface{}{"cores": int64(1)}))
+	resolver := NewResolver(client, testDriver)
+
+	resolved, err := resolver.Resolve(context.Background(), pod)
+	if err != nil {
+		t.Fatalf("failed to resolve: %v", err)
+	}
+	vcore, vmemory, err := util.GetGPURequestOfContainer(resolved, &resolved.Spec.Containers[0], util.DefaultVendor())
+	if err != nil || vcore != 50 || vmemory != 2 {
+		t.Fatalf("expect c0 to request 50 vcore 2 vmemory, got %d, %d, %v", vcore, vmemory, err)
+	}
+	if util.IsGPURequiredContainer(&resolved.Spec.Containers[1]) {
+		t.Fatalf("expect c1 claiming another driver to request no GPU, got %v", resolved.Spec.Containers[1].Resources)
+	}
+	if util.IsGPURequiredContainer(&pod.Spec.Containers[0]) {
+		t.Fatalf("expect the pod given to be left as is")
+	}
+
+	// the requests are cached until the pod is forgotten
+	actions := len(client.Actions())
+	if _, err := resolver.Resolve(context.Background(), pod); err != nil || len(client.Actions()) != actions {
+		t.Fatalf("expect the requests cached, got %d more actions, %v", len(client.Actions())-actions, err)
+	}
+	resolver.Forget(pod.UID)
+	if _, err := resolver.Resolve(context.Background(), pod); err != nil || len(client.Actions()) == actions {
+		t.Fatalf("expect the claims read again once forgotten, %v", err)
+	}
+
+	// a nil resolver reads no claims
+	var none *Resolver
+	if got, err := none.Resolve(context.Background(), pod); err != nil || got != pod {
+		t.Fatalf("expect the pod as is, got %v, %v", got, err)
+	}
+}
+
+func TestResolveErrors(t *testing.T) {
+	pod, obj := newTestPod()
+	unstructured.RemoveNestedField(obj.Object, "status")
+	resolver := NewResolver(fake.NewSimpleDynamicClient(runtime.NewScheme(), obj), testDriver)
+	if _, err := resolver.Resolve(context.Background(), pod); err == nil {
+		t.Fatalf("expect an error of a claim not generated yet")
+	}
+
+	_, obj = newTestPod()
+	resolver = NewResolver(fake.NewSimpleDynamicClient(runtime.NewScheme(), obj,
+		newTestClaim("pod-gpu-abcde", testDriver, map[string]interface{}{"cores": int64(-1)}),
+		newTestClaim("other-claim", "nic.example.com", nil)), testDriver)
+	if _, err := resolver.Resolve(context.Background(), pod); err == nil {
+		t.Fatalf("expect an error of negative cores")
+	}
+
+	// a pod placed whose claims can't be read is kept as is
+	pod.Annotations = map[string]string{util.PredicateNode: "testnode"}
+	if got := resolver.ResolvePlaced(pod); got != pod {
+		t.Fatalf("expect the pod as is, got %v", got)
+	}
+}
+
+func TestEventHandler(t *testing.T) {
+	pod, obj := newTestPod()
+	resolver := NewResolver(fake.NewSimpleDynamicClient(runtime.NewScheme(), obj,
+		newTestClaim("pod-gpu-abcde", testDriver, map[string]interface{}{"cores": int64(100), "memory": int64(8)}),
+		newTestClaim("other-claim", "nic.example.com", nil)), testDriver)
+	var got []*v1.Pod
+	handler := resolver.EventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { got = append(got, obj.(*v1.Pod)) },
+		DeleteFunc: func(obj interface{}) { got = append(got, obj.(*v1.Pod)) },
+	})
+
+	// a pod the filter hasn't placed isn't charged, so it isn't resolved
+	handler.OnAdd(pod)
+	placed := pod.DeepCopy()
+	placed.Annotations = map[string]string{util.PredicateNode: "testnode"}
+	handler.OnAdd(placed)
+	if len(got) != 2 || got[0] != pod || !util.IsGPURequiredPod(got[1]) {
+		t.Fatalf("expect only the pod placed to be resolved, got %v", got)
+	}
+	handler.OnDelete(placed)
+	resolver.Lock()
+	defer resolver.Unlock()
+	if _, ok := resolver.requests[pod.UID]; ok {
+		t.Fatalf("expect the pod deleted to be forgotten")
+	}
+}
@@ -0,0 +1,296 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package extenderpb
+
+import (
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+)
+
+// Each of the messages converts from and to the extender type of
+// k8s.io/kube-scheduler/extender/v1 it encodes.
+
+func (m *ExtenderArgs) from(args *extenderv1.ExtenderArgs) (err error) {
+	if m.Pod, err = marshalPod(args.Pod); err != nil {
+		return err
+	}
+	m.Nodes, m.NodeNames, err = marshalNodes(args.Nodes, args.NodeNames)
+	return err
+}
+
+func (m *ExtenderArgs) to(args *extenderv1.ExtenderArgs) (err error) {
+	if args.Pod, err = unmarshalPod(m.Pod); err != nil {
+		return err
+	}
+	args.Nodes, args.NodeNames, err = unmarshalNodes(m.Nodes, m.NodeNames)
+	return err
+}
+
+func (m *ExtenderFilterResult) from(result *extenderv1.ExtenderFilterResult) (err error) {
+	m.FailedNodes, m.Error = result.FailedNodes, result.Error
+	m.Nodes, m.NodeNames, err = marshalNodes(result.Nodes, result.NodeNames)
+	return err
+}
+
+func (m *ExtenderFilterResult) to(result *extenderv1.ExtenderFilterResult) (err error) {
+	result.FailedNodes, result.Error = m.FailedNodes, m.Error
+	result.Nodes, result.NodeNames, err = unmarshalNodes(m.Nodes, m.NodeNames)
+	return err
+}
+
+func (m *HostPriorityList) from(list *extenderv1.HostPriorityList) {
+	for _, p := range *list {
+		m.Items = append(m.Items, &HostPriority{Host: p.Host, Score: p.Score})
+	}
+}
+
+func (m *HostPriorityList) to(list *extenderv1.HostPriorityList) {
+	*list = make(extenderv1.HostPriorityList, 0, len(m.Items))
+	for _, p := range m.Items {
+		*list = append(*list, extenderv1.HostPriority{Host: p.Host, Score: p.Score})
+	}
+}
+
+func (m *ExtenderPreemptionArgs) from(args *extenderv1.ExtenderPreemptionArgs) (err error) {
+	if m.Pod, err = marshalPod(args.Pod); err != nil {
+		return err
+	}
+	if len(args.NodeNameToVictims) > 0 {
+		m.NodeNameToVictims = make(map[string]*Victims, len(args.NodeNameToVictims))
+	}
+	for node, victims := range args.NodeNameToVictims {
+		pb := &Victims{NumPdbViolations: victims.NumPDBViolations}
+		for _, pod := range victims.Pods {
+			data, err := marshalPod(pod)
+			if err != nil {
+				return err
+			}
+			pb.Pods = append(pb.Pods, data)
+		}
+		m.NodeNameToVictims[node] = pb
+	}
+	m.NodeNameToMetaVictims = marshalMetaVictims(args.NodeNameToMetaVictims)
+	return nil
+}
+
+func (m *ExtenderPreemptionArgs) to(args *extenderv1.ExtenderPreemptionArgs) (err error) {
+	if args.Pod, err = unmarshalPod(m.Pod); err != nil {
+		return err
+	}
+	if len(m.NodeNameToVictims) > 0 {
+		args.NodeNameToVictims = make(map[string]*extenderv1.Victims, len(m.NodeNameToVictims))
+	}
+	for node, pb := range m.NodeNameToVictims {
+		victims := &extenderv1.Victims{}
+		if pb != nil {
+			victims.NumPDBViolations = pb.NumPdbViolations
+			for _, data := range pb.Pods {
+				pod, err := unmarshalPod(data)
+				if err != nil {
+					return err
+				}
+				victims.Pods = append(victims.Pods, pod)
+			}
+		}
+		args.NodeNameToVictims[node] = victims
+	}
+	args.NodeNameToMetaVictims = unmarshalMetaVictims(m.NodeNameToMetaVictims)
+	return nil
+}
+
+// MessageOf returns the message encoding v, which is one of the extender
+// types, or an error if it has no protobuf encoding
+func MessageOf(v interface{}) (proto.Message, error) {
+	switch v := v.(type) {
+	case *extenderv1.ExtenderArgs:
+		m := &ExtenderArgs{}
+		return m, m.from(v)
+	case *extenderv1.ExtenderFilterResult:
+		m := &ExtenderFilterResult{}
+		return m, m.from(v)
+	case *extenderv1.HostPriorityList:
+		m := &HostPriorityList{}
+		m.from(v)
+		return m, nil
+	case *extenderv1.ExtenderBindingArgs:
+		return &ExtenderBindingArgs{PodName: v.PodName, PodNamespace: v.PodNamespace,
+			PodUid: string(v.PodUID), Node: v.Node}, nil
+	case *extenderv1.ExtenderBindingResult:
+		return &ExtenderBindingResult{Error: v.Error}, nil
+	case *extenderv1.ExtenderPreemptionArgs:
+		m := &ExtenderPreemptionArgs{}
+		return m, m.from(v)
+	case *extenderv1.ExtenderPreemptionResult:
+		return &ExtenderPreemptionResult{NodeNameToMetaVictims: marshalMetaVictims(v.NodeNameToMetaVictims)}, nil
+	}
+	return nil, fmt.Errorf("%T has no protobuf encoding", v)
+}
+
+// Unmarshal decodes data into v, which is one of the extender types
+func Unmarshal(data []byte, v interface{}) error {
+	m, err := newMessage(v)
+	if err != nil {
+		return err
+	}
+	if err := proto.Unmarshal(data, m); err != nil {
+		return err
+	}
+	return Decode(m, v)
+}
+
+// newMessage returns an empty message of the kind encoding v
+func newMessage(v interface{}) (proto.Message, error) {
+	switch v.(type) {
+	case *extenderv1.ExtenderArgs:
+		return &ExtenderArgs{}, nil
+	case *extenderv1.ExtenderFilterResult:
+		return &ExtenderFilterResult{}, nil
+	case *extenderv1.HostPriorityList:
+		return &HostPriorityList{}, nil
+	case *extenderv1.ExtenderBindingArgs:
+		return &ExtenderBindingArgs{}, nil
+	case *extenderv1.ExtenderBindingResult:
+		return &ExtenderBindingResult{}, nil
+	case *extenderv1.ExtenderPreemptionArgs:
+		return &ExtenderPreemptionArgs{}, nil
+	case *extenderv1.ExtenderPreemptionResult:
+		return &ExtenderPreemptionResult{}, nil
+	}
+	return nil, fmt.Errorf("%T has no protobuf encoding", v)
+}
+
+// Decode converts m into v, the extender type m encodes
+func Decode(m proto.Message, v interface{}) error {
+	switch v := v.(type) {
+	case *extenderv1.ExtenderArgs:
+		if m, ok := m.(*ExtenderArgs); ok {
+			return m.to(v)
+		}
+	case *extenderv1.ExtenderFilterResult:
+		if m, ok := m.(*ExtenderFilterResult); ok {
+			return m.to(v)
+		}
+	case *extenderv1.HostPriorityList:
+		if m, ok := m.(*HostPriorityList); ok {
+			m.to(v)
+			return nil
+		}
+	case *extenderv1.ExtenderBindingArgs:
+		if m, ok := m.(*ExtenderBindingArgs); ok {
+			*v = extenderv1.ExtenderBindingArgs{PodName: m.PodName, PodNamespace: m.PodNamespace,
+				PodUID: types.UID(m.PodUid), Node: m.Node}
+			return nil
+		}
+	case *extenderv1.ExtenderBindingResult:
+		if m, ok := m.(*ExtenderBindingResult); ok {
+			v.Error = m.Error
+			return nil
+		}
+	case *extenderv1.ExtenderPreemptionArgs:
+		if m, ok := m.(*ExtenderPreemptionArgs); ok {
+			return m.to(v)
+		}
+	case *extenderv1.ExtenderPreemptionResult:
+		if m, ok := m.(*ExtenderPreemptionResult); ok {
+			v.NodeNameToMetaVictims = unmarshalMetaVictims(m.NodeNameToMetaVictims)
+			return nil
+		}
+	}
+	return fmt.Errorf("%T doesn't encode %T", m, v)
+}
+
+func marshalPod(pod *corev1.Pod) ([]byte, error) {
+	if pod == nil {
+		return nil, nil
+	}
+	return pod.Marshal()
+}
+
+func unmarshalPod(data []byte) (*corev1.Pod, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	pod := &corev1.Pod{}
+	if err := pod.Unmarshal(data); err != nil {
+		return nil, err
+	}
+	return pod, nil
+}
+
+// marshalNodes encodes either nodes or the names of them, an empty list of
+// nodes is still encoded to tell it from the names
+func marshalNodes(nodes *corev1.NodeList, names *[]string) ([]byte, []string, error) {
+	if nodes != nil {
+		data, err := nodes.Marshal()
+		return data, nil, err
+	}
+	if names != nil {
+		return nil, *names, nil
+	}
+	return nil, nil, nil
+}
+
+func unmarshalNodes(data []byte, names []string) (*corev1.NodeList, *[]string, error) {
+	if len(data) == 0 {
+		if names == nil {
+			names = []string{}
+		}
+		return nil, &names, nil
+	}
+	nodes := &corev1.NodeList{}
+	if err := nodes.Unmarshal(data); err != nil {
+		return nil, nil, err
+	}
+	return nodes, nil, nil
+}
+
+func marshalMetaVictims(victims map[string]*extenderv1.MetaVictims) map[string]*MetaVictims {
+	if len(victims) == 0 {
+		return nil
+	}
+	m := make(map[string]*MetaVictims, len(victims))
+	for node, v := range victims {
+		pb := &MetaVictims{NumPdbViolations: v.NumPDBViolations}
+		for _, pod := range v.Pods {
+			pb.Pods = append(pb.Pods, &MetaPod{Uid: pod.UID})
+		}
+		m[node] = pb
+	}
+	return m
+}
+
+func unmarshalMetaVictims(m map[string]*MetaVictims) map[string]*extenderv1.MetaVictims {
+	if len(m) == 0 {
+		return nil
+	}
+	victims := make(map[string]*extenderv1.MetaVictims, len(m))
+	for node, pb := range m {
+		v := &extenderv1.MetaVictims{}
+		if pb != nil {
+			v.NumPDBViolations = pb.NumPdbViolations
+			for _, pod := range pb.Pods {
+				v.Pods = append(v.Pods, &extenderv1.MetaPod{UID: pod.Uid})
+			}
+		}
+		victims[node] = v
+	}
+	return victims
+}
@@ -0,0 +1,86 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package extenderpb
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+)
+
+func TestProtobufRoundTrip(t *testing.T) {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default", UID: "uid"}}
+	names := []string{"node-0", "node-1"}
+	testCases := []struct {
+		name  string
+		value interface{}
+		empty interface{}
+	}{
+		{name: "args with node names", value: &extenderv1.ExtenderArgs{Pod: pod, NodeNames: &names},
+			empty: &extenderv1.ExtenderArgs{}},
+		{name: "filter result", value: &extenderv1.ExtenderFilterResult{NodeNames: &names,
+			FailedNodes: extenderv1.FailedNodesMap{"node-2": "no GPU"}, Error: "error"},
+			empty: &extenderv1.ExtenderFilterResult{}},
+		{name: "priorities", value: &extenderv1.HostPriorityList{{Host: "node-0", Score: 10}, {Host: "node-1"}},
+			empty: &extenderv1.HostPriorityList{}},
+		{name: "binding args", value: &extenderv1.ExtenderBindingArgs{PodName: "pod", PodNamespace: "default",
+			PodUID: "uid", Node: "node-0"}, empty: &extenderv1.ExtenderBindingArgs{}},
+		{name: "binding result", value: &extenderv1.ExtenderBindingResult{Error: "error"},
+			empty: &extenderv1.ExtenderBindingResult{}},
+		{name: "preemption args", value: &extenderv1.ExtenderPreemptionArgs{
+			Pod: pod,
+			NodeNameToVictims: map[string]*extenderv1.Victims{
+				"node-0": {Pods: []*corev1.Pod{pod}, NumPDBViolations: 1},
+			},
+			NodeNameToMetaVictims: map[string]*extenderv1.MetaVictims{
+				"node-1": {Pods: []*extenderv1.MetaPod{{UID: "uid"}}, NumPDBViolations: 2},
+			},
+		}, empty: &extenderv1.ExtenderPreemptionArgs{}},
+		{name: "preemption result", value: &extenderv1.ExtenderPreemptionResult{
+			NodeNameToMetaVictims: map[string]*extenderv1.MetaVictims{
+				"node-1": {Pods: []*extenderv1.MetaPod{{UID: "uid"}}},
+			},
+		}, empty: &extenderv1.ExtenderPreemptionResult{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m, err := MessageOf(tc.value)
+			if err != nil {
+				t.Fatalf("failed to convert: %v", err)
+			}
+			data, err := proto.Marshal(m)
+			if err != nil {
+				t.Fatalf("failed to marshal: %v", err)
+			}
+			if err := Unmarshal(data, tc.empty); err != nil {
+				t.Fatalf("failed to unmarshal: %v", err)
+			}
+			if !reflect.DeepEqual(tc.empty, tc.value) {
+				t.Fatalf("expect %+v, got %+v", tc.value, tc.empty)
+			}
+		})
+	}
+
+	if _, err := MessageOf(&struct{}{}); err == nil {
+		t.Fatalf("expect no protobuf encoding of other types")
+	}
+}
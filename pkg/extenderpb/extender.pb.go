@@ -0,0 +1,828 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: extender.proto
+
+package extenderpb
+
+import (
+	context "context"
+	fmt "fmt"
+	proto "github.com/golang/protobuf/proto"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	math "math"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
+
+type ExtenderArgs struct {
+	// pod is a v1.Pod
+	Pod []byte `protobuf:"bytes,1,opt,name=pod,proto3" json:"pod,omitempty"`
+	// nodes is a v1.NodeList, node_names are the nodes without it
+	Nodes                []byte   `protobuf:"bytes,2,opt,name=nodes,proto3" json:"nodes,omitempty"`
+	NodeNames            []string `protobuf:"bytes,3,rep,name=node_names,json=nodeNames,proto3" json:"node_names,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ExtenderArgs) Reset()         { *m = ExtenderArgs{} }
+func (m *ExtenderArgs) String() string { return proto.CompactTextString(m) }
+func (*ExtenderArgs) ProtoMessage()    {}
+func (*ExtenderArgs) Descriptor() ([]byte, []int) {
+	return fileDescriptor_2800f5078cfbc5b9, []int{0}
+}
+
+func (m *ExtenderArgs) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ExtenderArgs.Unmarshal(m, b)
+}
+func (m *ExtenderArgs) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ExtenderArgs.Marshal(b, m, deterministic)
+}
+func (m *ExtenderArgs) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ExtenderArgs.Merge(m, src)
+}
+func (m *ExtenderArgs) XXX_Size() int {
+	return xxx_messageInfo_ExtenderArgs.Size(m)
+}
+func (m *ExtenderArgs) XXX_DiscardUnknown() {
+	xxx_messageInfo_ExtenderArgs.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ExtenderArgs proto.InternalMessageInfo
+
+func (m *ExtenderArgs) GetPod() []byte {
+	if m != nil {
+		return m.Pod
+	}
+	return nil
+}
+
+func (m *ExtenderArgs) GetNodes() []byte {
+	if m != nil {
+		return m.Nodes
+	}
+	return nil
+}
+
+func (m *ExtenderArgs) GetNodeNames() []string {
+	if m != nil {
+		return m.NodeNames
+	}
+	return nil
+}
+
+type ExtenderFilterResult struct {
+	// nodes is a v1.NodeList, node_names are the nodes without it
+	Nodes                []byte            `protobuf:"bytes,1,opt,name=nodes,proto3" json:"nodes,omitempty"`
+	NodeNames            []string          `protobuf:"bytes,2,rep,name=node_names,json=nodeNames,proto3" json:"node_names,omitempty"`
+	FailedNodes          map[string]string `protobuf:"bytes,3,rep,name=failed_nodes,json=failedNodes,proto3" json:"failed_nodes,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	Error                string            `protobuf:"bytes,4,opt,name=error,proto3" json:"error,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
+	XXX_unrecognized     []byte            `json:"-"`
+	XXX_sizecache        int32             `json:"-"`
+}
+
+func (m *ExtenderFilterResult) Reset()         { *m = ExtenderFilterResult{} }
+func (m *ExtenderFilterResult) String() string { return proto.CompactTextString(m) }
+func (*ExtenderFilterResult) ProtoMessage()    {}
+func (*ExtenderFilterResult) Descriptor() ([]byte, []int) {
+	return fileDescriptor_2800f5078cfbc5b9, []int{1}
+}
+
+func (m *ExtenderFilterResult) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ExtenderFilterResult.Unmarshal(m, b)
+}
+func (m *ExtenderFilterResult) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ExtenderFilterResult.Marshal(b, m, deterministic)
+}
+func (m *ExtenderFilterResult) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ExtenderFilterResult.Merge(m, src)
+}
+func (m *ExtenderFilterResult) XXX_Size() int {
+	return xxx_messageInfo_ExtenderFilterResult.Size(m)
+}
+func (m *ExtenderFilterResult) XXX_DiscardUnknown() {
+	xxx_messageInfo_ExtenderFilterResult.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ExtenderFilterResult proto.InternalMessageInfo
+
+func (m *ExtenderFilterResult) GetNodes() []byte {
+	if m != nil {
+		return m.Nodes
+	}
+	return nil
+}
+
+func (m *ExtenderFilterResult) GetNodeNames() []string {
+	if m != nil {
+		return m.NodeNames
+	}
+	return nil
+}
+
+func (m *ExtenderFilterResult) GetFailedNodes() map[string]string {
+	if m != nil {
+		return m.FailedNodes
+	}
+	return nil
+}
+
+func (m *ExtenderFilterResult) GetError() string {
+	if m != nil {
+		return m.Error
+	}
+	return ""
+}
+
+type HostPriority struct {
+	Host                 string   `protobuf:"bytes,1,opt,name=host,proto3" json:"host,omitempty"`
+	Score                int64    `protobuf:"varint,2,opt,name=score,proto3" json:"score,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *HostPriority) Reset()         { *m = HostPriority{} }
+func (m *HostPriority) String() string { return proto.CompactTextString(m) }
+func (*HostPriority) ProtoMessage()    {}
+func (*HostPriority) Descriptor() ([]byte, []int) {
+	return fileDescriptor_2800f5078cfbc5b9, []int{2}
+}
+
+func (m *HostPriority) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_HostPriority.Unmarshal(m, b)
+}
+func (m *HostPriority) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_HostPriority.Marshal(b, m, deterministic)
+}
+func (m *HostPriority) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_HostPriority.Merge(m, src)
+}
+func (m *HostPriority) XXX_Size() int {
+	return xxx_messageInfo_HostPriority.Size(m)
+}
+func (m *HostPriority) XXX_DiscardUnknown() {
+	xxx_messageInfo_HostPriority.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_HostPriority proto.InternalMessageInfo
+
+func (m *HostPriority) GetHost() string {
+	if m != nil {
+		return m.Host
+	}
+	return ""
+}
+
+func (m *HostPriority) GetScore() int64 {
+	if m != nil {
+		return m.Score
+	}
+	return 0
+}
+
+type HostPriorityList struct {
+	Items                []*HostPriority `protobuf:"bytes,1,rep,name=items,proto3" json:"items,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}        `json:"-"`
+	XXX_unrecognized     []byte          `json:"-"`
+	XXX_sizecache        int32           `json:"-"`
+}
+
+func (m *HostPriorityList) Reset()         { *m = HostPriorityList{} }
+func (m *HostPriorityList) String() string { return proto.CompactTextString(m) }
+func (*HostPriorityList) ProtoMessage()    {}
+func (*HostPriorityList) Descriptor() ([]byte, []int) {
+	return fileDescriptor_2800f5078cfbc5b9, []int{3}
+}
+
+func (m *HostPriorityList) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_HostPriorityList.Unmarshal(m, b)
+}
+func (m *HostPriorityList) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_HostPriorityList.Marshal(b, m, deterministic)
+}
+func (m *HostPriorityList) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_HostPriorityList.Merge(m, src)
+}
+func (m *HostPriorityList) XXX_Size() int {
+	return xxx_messageInfo_HostPriorityList.Size(m)
+}
+func (m *HostPriorityList) XXX_DiscardUnknown() {
+	xxx_messageInfo_HostPriorityList.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_HostPriorityList proto.InternalMessageInfo
+
+func (m *HostPriorityList) GetItems() []*HostPriority {
+	if m != nil {
+		return m.Items
+	}
+	return nil
+}
+
+type ExtenderBindingArgs struct {
+	PodName              string   `protobuf:"bytes,1,opt,name=pod_name,json=podName,proto3" json:"pod_name,omitempty"`
+	PodNamespace         string   `protobuf:"bytes,2,opt,name=pod_namespace,json=podNamespace,proto3" json:"pod_namespace,omitempty"`
+	PodUid               string   `protobuf:"bytes,3,opt,name=pod_uid,json=podUid,proto3" json:"pod_uid,omitempty"`
+	Node                 string   `protobuf:"bytes,4,opt,name=node,proto3" json:"node,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ExtenderBindingArgs) Reset()         { *m = ExtenderBindingArgs{} }
+func (m *ExtenderBindingArgs) String() string { return proto.CompactTextString(m) }
+func (*ExtenderBindingArgs) ProtoMessage()    {}
+func (*ExtenderBindingArgs) Descriptor() ([]byte, []int) {
+	return fileDescriptor_2800f5078cfbc5b9, []int{4}
+}
+
+func (m *ExtenderBindingArgs) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ExtenderBindingArgs.Unmarshal(m, b)
+}
+func (m *ExtenderBindingArgs) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ExtenderBindingArgs.Marshal(b, m, deterministic)
+}
+func (m *ExtenderBindingArgs) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ExtenderBindingArgs.Merge(m, src)
+}
+func (m *ExtenderBindingArgs) XXX_Size() int {
+	return xxx_messageInfo_ExtenderBindingArgs.Size(m)
+}
+func (m *ExtenderBindingArgs) XXX_DiscardUnknown() {
+	xxx_messageInfo_ExtenderBindingArgs.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ExtenderBindingArgs proto.InternalMessageInfo
+
+func (m *ExtenderBindingArgs) GetPodName() string {
+	if m != nil {
+		return m.PodName
+	}
+	return ""
+}
+
+func (m *ExtenderBindingArgs) GetPodNamespace() string {
+	if m != nil {
+		return m.PodNamespace
+	}
+	return ""
+}
+
+func (m *ExtenderBindingArgs) GetPodUid() string {
+	if m != nil {
+		return m.PodUid
+	}
+	return ""
+}
+
+func (m *ExtenderBindingArgs) GetNode() string {
+	if m != nil {
+		return m.Node
+	}
+	return ""
+}
+
+type ExtenderBindingResult struct {
+	Error                string   `protobuf:"bytes,1,opt,name=error,proto3" json:"error,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ExtenderBindingResult) Reset()         { *m = ExtenderBindingResult{} }
+func (m *ExtenderBindingResult) String() string { return proto.CompactTextString(m) }
+func (*ExtenderBindingResult) ProtoMessage()    {}
+func (*ExtenderBindingResult) Descriptor() ([]byte, []int) {
+	return fileDescriptor_2800f5078cfbc5b9, []int{5}
+}
+
+func (m *ExtenderBindingResult) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ExtenderBindingResult.Unmarshal(m, b)
+}
+func (m *ExtenderBindingResult) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ExtenderBindingResult.Marshal(b, m, deterministic)
+}
+func (m *ExtenderBindingResult) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ExtenderBindingResult.Merge(m, src)
+}
+func (m *ExtenderBindingResult) XXX_Size() int {
+	return xxx_messageInfo_ExtenderBindingResult.Size(m)
+}
+func (m *ExtenderBindingResult) XXX_DiscardUnknown() {
+	xxx_messageInfo_ExtenderBindingResult.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ExtenderBindingResult proto.InternalMessageInfo
+
+func (m *ExtenderBindingResult) GetError() string {
+	if m != nil {
+		return m.Error
+	}
+	return ""
+}
+
+type Victims struct {
+	// pods are v1.Pod
+	Pods                 [][]byte `protobuf:"bytes,1,rep,name=pods,proto3" json:"pods,omitempty"`
+	NumPdbViolations     int64    `protobuf:"varint,2,opt,name=num_pdb_violations,json=numPdbViolations,proto3" json:"num_pdb_violations,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Victims) Reset()         { *m = Victims{} }
+func (m *Victims) String() string { return proto.CompactTextString(m) }
+func (*Victims) ProtoMessage()    {}
+func (*Victims) Descriptor() ([]byte, []int) {
+	return fileDescriptor_2800f5078cfbc5b9, []int{6}
+}
+
+func (m *Victims) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Victims.Unmarshal(m, b)
+}
+func (m *Victims) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Victims.Marshal(b, m, deterministic)
+}
+func (m *Victims) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Victims.Merge(m, src)
+}
+func (m *Victims) XXX_Size() int {
+	return xxx_messageInfo_Victims.Size(m)
+}
+func (m *Victims) XXX_DiscardUnknown() {
+	xxx_messageInfo_Victims.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Victims proto.InternalMessageInfo
+
+func (m *Victims) GetPods() [][]byte {
+	if m != nil {
+		return m.Pods
+	}
+	return nil
+}
+
+func (m *Victims) GetNumPdbViolations() int64 {
+	if m != nil {
+		return m.NumPdbViolations
+	}
+	return 0
+}
+
+type MetaPod struct {
+	Uid                  string   `protobuf:"bytes,1,opt,name=uid,proto3" json:"uid,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *MetaPod) Reset()         { *m = MetaPod{} }
+func (m *MetaPod) String() string { return proto.CompactTextString(m) }
+func (*MetaPod) ProtoMessage()    {}
+func (*MetaPod) Descriptor() ([]byte, []int) {
+	return fileDescriptor_2800f5078cfbc5b9, []int{7}
+}
+
+func (m *MetaPod) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_MetaPod.Unmarshal(m, b)
+}
+func (m *MetaPod) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_MetaPod.Marshal(b, m, deterministic)
+}
+func (m *MetaPod) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_MetaPod.Merge(m, src)
+}
+func (m *MetaPod) XXX_Size() int {
+	return xxx_messageInfo_MetaPod.Size(m)
+}
+func (m *MetaPod) XXX_DiscardUnknown() {
+	xxx_messageInfo_MetaPod.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_MetaPod proto.InternalMessageInfo
+
+func (m *MetaPod) GetUid() string {
+	if m != nil {
+		return m.Uid
+	}
+	return ""
+}
+
+type MetaVictims struct {
+	Pods                 []*MetaPod `protobuf:"bytes,1,rep,name=pods,proto3" json:"pods,omitempty"`
+	NumPdbViolations     int64      `protobuf:"varint,2,opt,name=num_pdb_violations,json=numPdbViolations,proto3" json:"num_pdb_violations,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}   `json:"-"`
+	XXX_unrecognized     []byte     `json:"-"`
+	XXX_sizecache        int32      `json:"-"`
+}
+
+func (m *MetaVictims) Reset()         { *m = MetaVictims{} }
+func (m *MetaVictims) String() string { return proto.CompactTextString(m) }
+func (*MetaVictims) ProtoMessage()    {}
+func (*MetaVictims) Descriptor() ([]byte, []int) {
+	return fileDescriptor_2800f5078cfbc5b9, []int{8}
+}
+
+func (m *MetaVictims) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_MetaVictims.Unmarshal(m, b)
+}
+func (m *MetaVictims) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_MetaVictims.Marshal(b, m, deterministic)
+}
+func (m *MetaVictims) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_MetaVictims.Merge(m, src)
+}
+func (m *MetaVictims) XXX_Size() int {
+	return xxx_messageInfo_MetaVictims.Size(m)
+}
+func (m *MetaVictims) XXX_DiscardUnknown() {
+	xxx_messageInfo_MetaVictims.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_MetaVictims proto.InternalMessageInfo
+
+func (m *MetaVictims) GetPods() []*MetaPod {
+	if m != nil {
+		return m.Pods
+	}
+	return nil
+}
+
+func (m *MetaVictims) GetNumPdbViolations() int64 {
+	if m != nil {
+		return m.NumPdbViolations
+	}
+	return 0
+}
+
+type ExtenderPreemptionArgs struct {
+	// pod is a v1.Pod
+	Pod                   []byte                  `protobuf:"bytes,1,opt,name=pod,proto3" json:"pod,omitempty"`
+	NodeNameToVictims     map[string]*Victims     `protobuf:"bytes,2,rep,name=node_name_to_victims,json=nodeNameToVictims,proto3" json:"node_name_to_victims,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	NodeNameToMetaVictims map[string]*MetaVictims `protobuf:"bytes,3,rep,name=node_name_to_meta_victims,json=nodeNameToMetaVictims,proto3" json:"node_name_to_meta_victims,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	XXX_NoUnkeyedLiteral  struct{}                `json:"-"`
+	XXX_unrecognized      []byte                  `json:"-"`
+	XXX_sizecache         int32                   `json:"-"`
+}
+
+func (m *ExtenderPreemptionArgs) Reset()         { *m = ExtenderPreemptionArgs{} }
+func (m *ExtenderPreemptionArgs) String() string { return proto.CompactTextString(m) }
+func (*ExtenderPreemptionArgs) ProtoMessage()    {}
+func (*ExtenderPreemptionArgs) Descriptor() ([]byte, []int) {
+	return fileDescriptor_2800f5078cfbc5b9, []int{9}
+}
+
+func (m *ExtenderPreemptionArgs) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ExtenderPreemptionArgs.Unmarshal(m, b)
+}
+func (m *ExtenderPreemptionArgs) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ExtenderPreemptionArgs.Marshal(b, m, deterministic)
+}
+func (m *ExtenderPreemptionArgs) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ExtenderPreemptionArgs.Merge(m, src)
+}
+func (m *ExtenderPreemptionArgs) XXX_Size() int {
+	return xxx_messageInfo_ExtenderPreemptionArgs.Size(m)
+}
+func (m *ExtenderPreemptionArgs) XXX_DiscardUnknown() {
+	xxx_messageInfo_ExtenderPreemptionArgs.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ExtenderPreemptionArgs proto.InternalMessageInfo
+
+func (m *ExtenderPreemptionArgs) GetPod() []byte {
+	if m != nil {
+		return m.Pod
+	}
+	return nil
+}
+
+func (m *ExtenderPreemptionArgs) GetNodeNameToVictims() map[string]*Victims {
+	if m != nil {
+		return m.NodeNameToVictims
+	}
+	return nil
+}
+
+func (m *ExtenderPreemptionArgs) GetNodeNameToMetaVictims() map[string]*MetaVictims {
+	if m != nil {
+		return m.NodeNameToMetaVictims
+	}
+	return nil
+}
+
+type ExtenderPreemptionResult struct {
+	NodeNameToMetaVictims map[string]*MetaVictims `protobuf:"bytes,1,rep,name=node_name_to_meta_victims,json=nodeNameToMetaVictims,proto3" json:"node_name_to_meta_victims,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	XXX_NoUnkeyedLiteral  struct{}                `json:"-"`
+	XXX_unrecognized      []byte                  `json:"-"`
+	XXX_sizecache         int32                   `json:"-"`
+}
+
+func (m *ExtenderPreemptionResult) Reset()         { *m = ExtenderPreemptionResult{} }
+func (m *ExtenderPreemptionResult) String() string { return proto.CompactTextString(m) }
+func (*ExtenderPreemptionResult) ProtoMessage()    {}
+func (*ExtenderPreemptionResult) Descriptor() ([]byte, []int) {
+	return fileDescriptor_2800f5078cfbc5b9, []int{10}
+}
+
+func (m *ExtenderPreemptionResult) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ExtenderPreemptionResult.Unmarshal(m, b)
+}
+func (m *ExtenderPreemptionResult) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ExtenderPreemptionResult.Marshal(b, m, deterministic)
+}
+func (m *ExtenderPreemptionResult) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ExtenderPreemptionResult.Merge(m, src)
+}
+func (m *ExtenderPreemptionResult) XXX_Size() int {
+	return xxx_messageInfo_ExtenderPreemptionResult.Size(m)
+}
+func (m *ExtenderPreemptionResult) XXX_DiscardUnknown() {
+	xxx_messageInfo_ExtenderPreemptionResult.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ExtenderPreemptionResult proto.InternalMessageInfo
+
+func (m *ExtenderPreemptionResult) GetNodeNameToMetaVictims() map[string]*MetaVictims {
+	if m != nil {
+		return m.NodeNameToMetaVictims
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterType((*ExtenderArgs)(nil), "gpuadmission.extender.v1.ExtenderArgs")
+	proto.RegisterType((*ExtenderFilterResult)(nil), "gpuadmission.extender.v1.ExtenderFilterResult")
+	proto.RegisterMapType((map[string]string)(nil), "gpuadmission.extender.v1.ExtenderFilterResult.FailedNodesEntry")
+	proto.RegisterType((*HostPriority)(nil), "gpuadmission.extender.v1.HostPriority")
+	proto.RegisterType((*HostPriorityList)(nil), "gpuadmission.extender.v1.HostPriorityList")
+	proto.RegisterType((*ExtenderBindingArgs)(nil), "gpuadmission.extender.v1.ExtenderBindingArgs")
+	proto.RegisterType((*ExtenderBindingResult)(nil), "gpuadmission.extender.v1.ExtenderBindingResult")
+	proto.RegisterType((*Victims)(nil), "gpuadmission.extender.v1.Victims")
+	proto.RegisterType((*MetaPod)(nil), "gpuadmission.extender.v1.MetaPod")
+	proto.RegisterType((*MetaVictims)(nil), "gpuadmission.extender.v1.MetaVictims")
+	proto.RegisterType((*ExtenderPreemptionArgs)(nil), "gpuadmission.extender.v1.ExtenderPreemptionArgs")
+	proto.RegisterMapType((map[string]*MetaVictims)(nil), "gpuadmission.extender.v1.ExtenderPreemptionArgs.NodeNameToMetaVictimsEntry")
+	proto.RegisterMapType((map[string]*Victims)(nil), "gpuadmission.extender.v1.ExtenderPreemptionArgs.NodeNameToVictimsEntry")
+	proto.RegisterType((*ExtenderPreemptionResult)(nil), "gpuadmission.extender.v1.ExtenderPreemptionResult")
+	proto.RegisterMapType((map[string]*MetaVictims)(nil), "gpuadmission.extender.v1.ExtenderPreemptionResult.NodeNameToMetaVictimsEntry")
+}
+
+func init() { proto.RegisterFile("extender.proto", fileDescriptor_2800f5078cfbc5b9) }
+
+var fileDescriptor_2800f5078cfbc5b9 = []byte{
+	// 715 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xbc, 0x56, 0x5f, 0x4f, 0x13, 0x41,
+	0x10, 0xcf, 0xb5, 0x85, 0xd2, 0x69, 0x35, 0x75, 0x05, 0x2c, 0x35, 0x26, 0x78, 0x46, 0xd2, 0x10,
+	0xb9, 0x6a, 0x8d, 0x91, 0xa8, 0xd1, 0x48, 0x02, 0x9a, 0x20, 0xa4, 0xb9, 0x08, 0x0f, 0xbe, 0x1c,
+	0xd7, 0xee, 0x52, 0x37, 0xed, 0xdd, 0x5e, 0x6e, 0xf7, 0x1a, 0xf0, 0xd1, 0x04, 0xfd, 0x04, 0x7e,
+	0x06, 0xbf, 0xa6, 0xd9, 0x3f, 0x57, 0x8e, 0xd2, 0x02, 0xd5, 0xc4, 0xb7, 0xdd, 0x99, 0x9b, 0xf9,
+	0xcd, 0xfc, 0x66, 0x7f, 0xd3, 0xc2, 0x6d, 0x72, 0x22, 0x48, 0x88, 0x49, 0xec, 0x44, 0x31, 0x13,
+	0x0c, 0xd5, 0x7a, 0x51, 0xe2, 0xe3, 0x80, 0x72, 0x4e, 0x59, 0xe8, 0x8c, 0x9c, 0xc3, 0x67, 0xf6,
+	0x01, 0x54, 0xb6, 0xcd, 0xf5, 0x7d, 0xdc, 0xe3, 0xa8, 0x0a, 0xf9, 0x88, 0xe1, 0x9a, 0xb5, 0x6a,
+	0x35, 0x2a, 0xae, 0x3c, 0xa2, 0x45, 0x98, 0x0b, 0x19, 0x26, 0xbc, 0x96, 0x53, 0x36, 0x7d, 0x41,
+	0x0f, 0x00, 0xe4, 0xc1, 0x0b, 0xfd, 0x80, 0xf0, 0x5a, 0x7e, 0x35, 0xdf, 0x28, 0xb9, 0x25, 0x69,
+	0xd9, 0x97, 0x06, 0xfb, 0x2c, 0x07, 0x8b, 0x69, 0xde, 0x1d, 0x3a, 0x10, 0x24, 0x76, 0x09, 0x4f,
+	0x06, 0xe2, 0x3c, 0x9b, 0x35, 0x3d, 0x5b, 0x6e, 0x2c, 0x1b, 0xea, 0x40, 0xe5, 0xd8, 0xa7, 0x03,
+	0x82, 0x3d, 0x1d, 0x2b, 0xe1, 0xca, 0xad, 0x77, 0xce, 0xb4, 0xae, 0x9c, 0x49, 0xd0, 0xce, 0x8e,
+	0x4a, 0xb1, 0x2f, 0x33, 0x6c, 0x87, 0x22, 0x3e, 0x75, 0xcb, 0xc7, 0xe7, 0x16, 0x59, 0x18, 0x89,
+	0x63, 0x16, 0xd7, 0x0a, 0xab, 0x56, 0xa3, 0xe4, 0xea, 0x4b, 0xfd, 0x2d, 0x54, 0xc7, 0xc3, 0x24,
+	0x45, 0x7d, 0x72, 0xaa, 0x1a, 0x28, 0xb9, 0xf2, 0x28, 0x63, 0x87, 0xfe, 0x20, 0x21, 0x8a, 0xa2,
+	0x92, 0xab, 0x2f, 0xaf, 0x72, 0x9b, 0x96, 0xbd, 0x09, 0x95, 0x8f, 0x8c, 0x8b, 0x76, 0x4c, 0x59,
+	0x4c, 0xc5, 0x29, 0x42, 0x50, 0xf8, 0xca, 0xb8, 0x30, 0xc1, 0xea, 0x2c, 0xa3, 0x79, 0x97, 0xc5,
+	0x3a, 0x3a, 0xef, 0xea, 0x8b, 0xdd, 0x86, 0x6a, 0x36, 0xf2, 0x13, 0xe5, 0x02, 0xbd, 0x81, 0x39,
+	0x2a, 0x48, 0x20, 0xc9, 0x93, 0x04, 0xac, 0x4d, 0x27, 0x20, 0x1b, 0xea, 0xea, 0x20, 0xfb, 0xbb,
+	0x05, 0x77, 0x53, 0x62, 0xb6, 0x68, 0x88, 0x69, 0xd8, 0x53, 0x23, 0x5f, 0x81, 0x85, 0x88, 0x61,
+	0xc5, 0xbd, 0xa9, 0xab, 0x18, 0x31, 0x2c, 0x99, 0x47, 0x8f, 0xe0, 0x56, 0xea, 0xe2, 0x91, 0xdf,
+	0x4d, 0x1b, 0xac, 0x18, 0xbf, 0xb2, 0xa1, 0x7b, 0x20, 0xbf, 0xf7, 0x12, 0x8a, 0x6b, 0x79, 0xe5,
+	0x9e, 0x8f, 0x18, 0x3e, 0xa0, 0x58, 0x36, 0x2b, 0xe7, 0x65, 0x18, 0x55, 0x67, 0x7b, 0x03, 0x96,
+	0xc6, 0x6a, 0x38, 0x7f, 0x18, 0x9a, 0x7f, 0x2b, 0xc3, 0xbf, 0xbd, 0x0b, 0xc5, 0x43, 0xda, 0x15,
+	0x34, 0xe0, 0x32, 0x5b, 0xc4, 0xb0, 0xee, 0xbd, 0xe2, 0xaa, 0x33, 0x7a, 0x02, 0x28, 0x4c, 0x02,
+	0x2f, 0xc2, 0x1d, 0x6f, 0x48, 0xd9, 0xc0, 0x17, 0x94, 0x85, 0xdc, 0xf0, 0x58, 0x0d, 0x93, 0xa0,
+	0x8d, 0x3b, 0x87, 0x23, 0xbb, 0x7d, 0x1f, 0x8a, 0x7b, 0x44, 0xf8, 0x6d, 0x86, 0xe5, 0x0c, 0x65,
+	0xbd, 0x66, 0x86, 0x09, 0xc5, 0x76, 0x0c, 0x65, 0xe9, 0x4c, 0xd1, 0x5e, 0x64, 0xd0, 0xca, 0xad,
+	0x87, 0xd3, 0x99, 0x36, 0x19, 0xff, 0xaa, 0xa0, 0x1f, 0x05, 0x58, 0x4e, 0xd9, 0x68, 0xc7, 0x84,
+	0x04, 0x91, 0xb4, 0x4f, 0xd1, 0xe1, 0x09, 0x2c, 0x8e, 0x34, 0xe2, 0x09, 0xe6, 0x0d, 0x75, 0xa5,
+	0x4a, 0x2d, 0xe5, 0xd6, 0x87, 0xeb, 0xc5, 0x70, 0x11, 0xc1, 0xd9, 0x37, 0xf2, 0xfa, 0xcc, 0x4c,
+	0xcf, 0x5a, 0x14, 0x77, 0xc2, 0x71, 0x3b, 0x3a, 0xb3, 0x60, 0xe5, 0x02, 0x74, 0x40, 0x84, 0x3f,
+	0xc2, 0xd7, 0x62, 0xdc, 0xfd, 0x07, 0xfc, 0x0c, 0xef, 0xba, 0x86, 0xa5, 0x70, 0x92, 0xaf, 0xde,
+	0x83, 0xe5, 0xc9, 0x45, 0x4f, 0x90, 0xe4, 0xcb, 0xac, 0x24, 0xaf, 0x1c, 0xa0, 0x49, 0x94, 0x51,
+	0x6d, 0x9d, 0x41, 0x7d, 0x7a, 0x75, 0x13, 0xc0, 0x5e, 0x5f, 0x04, 0x7b, 0x7c, 0xf5, 0x6b, 0xb9,
+	0x0c, 0x68, 0xff, 0xca, 0x41, 0xed, 0x32, 0x4d, 0x46, 0x19, 0x3f, 0xaf, 0xa4, 0x5f, 0x3f, 0xd0,
+	0xbd, 0x59, 0xe8, 0x37, 0xfb, 0x70, 0xf6, 0x01, 0xfc, 0x6f, 0x5e, 0x5a, 0xbf, 0xf3, 0xb0, 0x90,
+	0xd6, 0x8f, 0x8e, 0x60, 0x5e, 0xef, 0x73, 0xb4, 0x76, 0x7d, 0xb7, 0xf2, 0x89, 0xd5, 0x9d, 0xd9,
+	0x7e, 0x21, 0xd0, 0x11, 0x80, 0x59, 0x9a, 0xf4, 0x1b, 0xb9, 0x31, 0xca, 0xfa, 0xcd, 0xd6, 0xb0,
+	0xda, 0xe0, 0xc7, 0x50, 0x90, 0x6b, 0x0f, 0x6d, 0x5c, 0x9f, 0x3b, 0xb3, 0xa2, 0xeb, 0xcd, 0x1b,
+	0x7f, 0x6e, 0x3a, 0x61, 0x50, 0x34, 0xf3, 0x46, 0x4f, 0x67, 0x55, 0x66, 0xbd, 0x35, 0xfb, 0x63,
+	0xda, 0x5a, 0xff, 0xd2, 0x10, 0x7d, 0xc2, 0x85, 0xdf, 0xed, 0x3b, 0x94, 0x35, 0x7b, 0x51, 0xb2,
+	0x31, 0xca, 0xd0, 0x8c, 0xfa, 0xbd, 0x66, 0x9a, 0x25, 0xea, 0x74, 0xe6, 0xd5, 0x9f, 0x92, 0xe7,
+	0x7f, 0x06, 0x00, 0x06, 0xc2, 0x87, 0xeb, 0xa6, 0x08, 0x00, 0x00,
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// ExtenderClient is the client API for Extender service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type ExtenderClient interface {
+	// Filter is the filter verb, predicates of the HTTP routes
+	Filter(ctx context.Context, in *ExtenderArgs, opts ...grpc.CallOption) (*ExtenderFilterResult, error)
+	// Prioritize is the prioritize verb, priorities of the HTTP routes
+	Prioritize(ctx context.Context, in *ExtenderArgs, opts ...grpc.CallOption) (*HostPriorityList, error)
+	// Bind is the bind verb
+	Bind(ctx context.Context, in *ExtenderBindingArgs, opts ...grpc.CallOption) (*ExtenderBindingResult, error)
+	// Preempt is the preempt verb, preemption of the HTTP routes
+	Preempt(ctx context.Context, in *ExtenderPreemptionArgs, opts ...grpc.CallOption) (*ExtenderPreemptionResult, error)
+}
+
+type extenderClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewExtenderClient(cc *grpc.ClientConn) ExtenderClient {
+	return &extenderClient{cc}
+}
+
+func (c *extenderClient) Filter(ctx context.Context, in *ExtenderArgs, opts ...grpc.CallOption) (*ExtenderFilterResult, error) {
+	out := new(ExtenderFilterResult)
+	err := c.cc.Invoke(ctx, "/gpuadmission.extender.v1.Extender/Filter", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *extenderClient) Prioritize(ctx context.Context, in *ExtenderArgs, opts ...grpc.CallOption) (*HostPriorityList, error) {
+	out := new(HostPriorityList)
+	err := c.cc.Invoke(ctx, "/gpuadmission.extender.v1.Extender/Prioritize", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *extenderClient) Bind(ctx context.Context, in *ExtenderBindingArgs, opts ...grpc.CallOption) (*ExtenderBindingResult, error) {
+	out := new(ExtenderBindingResult)
+	err := c.cc.Invoke(ctx, "/gpuadmission.extender.v1.Extender/Bind", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *extenderClient) Preempt(ctx context.Context, in *ExtenderPreemptionArgs, opts ...grpc.CallOption) (*ExtenderPreemptionResult, error) {
+	out := new(ExtenderPreemptionResult)
+	err := c.cc.Invoke(ctx, "/gpuadmission.extender.v1.Extender/Preempt", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ExtenderServer is the server API for Extender service.
+type ExtenderServer interface {
+	// Filter is the filter verb, predicates of the HTTP routes
+	Filter(context.Context, *ExtenderArgs) (*ExtenderFilterResult, error)
+	// Prioritize is the prioritize verb, priorities of the HTTP routes
+	Prioritize(context.Context, *ExtenderArgs) (*HostPriorityList, error)
+	// Bind is the bind verb
+	Bind(context.Context, *ExtenderBindingArgs) (*ExtenderBindingResult, error)
+	// Preempt is the preempt verb, preemption of the HTTP routes
+	Preempt(context.Context, *ExtenderPreemptionArgs) (*ExtenderPreemptionResult, error)
+}
+
+// UnimplementedExtenderServer can be embedded to have forward compatible implementations.
+type UnimplementedExtenderServer struct {
+}
+
+func (*UnimplementedExtenderServer) Filter(ctx context.Context, req *ExtenderArgs) (*ExtenderFilterResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Filter not implemented")
+}
+func (*UnimplementedExtenderServer) Prioritize(ctx context.Context, req *ExtenderArgs) (*HostPriorityList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Prioritize not implemented")
+}
+func (*UnimplementedExtenderServer) Bind(ctx context.Context, req *ExtenderBindingArgs) (*ExtenderBindingResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Bind not implemented")
+}
+func (*UnimplementedExtenderServer) Preempt(ctx context.Context, req *ExtenderPreemptionArgs) (*ExtenderPreemptionResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Preempt not implemented")
+}
+
+func RegisterExtenderServer(s *grpc.Server, srv ExtenderServer) {
+	s.RegisterService(&_Extender_serviceDesc, srv)
+}
+
+func _Extender_Filter_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ExtenderArgs)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ExtenderServer).Filter(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gpuadmission.extender.v1.Extender/Filter",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ExtenderServer).Filter(ctx, req.(*ExtenderArgs))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Extender_Prioritize_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ExtenderArgs)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ExtenderServer).Prioritize(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gpuadmission.extender.v1.Extender/Prioritize",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ExtenderServer).Prioritize(ctx, req.(*ExtenderArgs))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Extender_Bind_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ExtenderBindingArgs)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ExtenderServer).Bind(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gpuadmission.extender.v1.Extender/Bind",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ExtenderServer).Bind(ctx, req.(*ExtenderBindingArgs))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Extender_Preempt_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ExtenderPreemptionArgs)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ExtenderServer).Preempt(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gpuadmission.extender.v1.Extender/Preempt",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ExtenderServer).Preempt(ctx, req.(*ExtenderPreemptionArgs))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _Extender_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "gpuadmission.extender.v1.Extender",
+	HandlerType: (*ExtenderServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Filter",
+			Handler:    _Extender_Filter_Handler,
+		},
+		{
+			MethodName: "Prioritize",
+			Handler:    _Extender_Prioritize_Handler,
+		},
+		{
+			MethodName: "Bind",
+			Handler:    _Extender_Bind_Handler,
+		},
+		{
+			MethodName: "Preempt",
+			Handler:    _Extender_Preempt_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "extender.proto",
+}
@@ -0,0 +1,167 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package leader
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog"
+
+	"tkestack.io/gpu-admission/pkg/state"
+)
+
+// The defaults of the lease, the same as kube-scheduler's
+const (
+	DefaultLeaseDuration = 15 * time.Second
+	DefaultRenewDeadline = 10 * time.Second
+	DefaultRetryPeriod   = 2 * time.Second
+)
+
+// Config is how the replicas elect the leader by a Lease
+type Config struct {
+	// Namespace and Name are of the Lease
+	Namespace string
+	Name      string
+	// Identity is the holder of the Lease, unique among the replicas
+	Identity string
+	// LeaseDuration is how long the followers wait before taking over the
+	// Lease the leader fails to renew
+	LeaseDuration time.Duration
+	// RenewDeadline is how long the leader retries renewing the Lease
+	// before it gives up leading
+	RenewDeadline time.Duration
+	// RetryPeriod is the interval of trying to acquire or renew the Lease
+	RetryPeriod time.Duration
+}
+
+// Elector runs for the leader of the replicas, only the leader serves the
+// scheduler while the others stand by, see Ready
+type Elector struct {
+	elector *leaderelection.LeaderElector
+	// prepare readies the replica to serve once it leads, it's retried
+	// every retryPeriod until it succeeds
+	prepare     func(context.Context) error
+	retryPeriod time.Duration
+	mu          sync.Mutex
+	// leading tells the replica leads and has been prepared
+	leading bool
+}
+
+// NewElector returns an Elector of given config. Once the replica leads,
+// prepare is called, e.g. to rebuild the accounting of the nodes, and it's
+// ready after prepare returns nil. A failed prepare is retried every
+// RetryPeriod, the context given to it is done once the replica stops
+// leading.
+func NewElector(client kubernetes.Interface, cfg Config, prepare func(context.Context) error) (*Elector, error) {
+	lock, err := resourcelock.New(resourcelock.LeasesResourceLock, cfg.Namespace, cfg.Name,
+		client.CoreV1(), client.CoordinationV1(), resourcelock.ResourceLockConfig{Identity: cfg.Identity})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the lock of lease %s/%s: %v", cfg.Namespace, cfg.Name, err)
+	}
+	e := &Elector{prepare: prepare, retryPeriod: cfg.RetryPeriod}
+	e.elector, err = leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   cfg.LeaseDuration,
+		RenewDeadline:   cfg.RenewDeadline,
+		RetryPeriod:     cfg.RetryPeriod,
+		ReleaseOnCancel: true,
+		Name:            cfg.Name,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: e.lead,
+			OnStoppedLeading: e.follow,
+			OnNewLeader: func(identity string) {
+				klog.Infof("The leader is %s", identity)
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid leader election: %v", err)
+	}
+	return e, nil
+}
+
+// Run campaigns for the leader until ctx is done. A replica losing the
+// Lease goes back to standing by and campaigns again.
+func (e *Elector) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		e.elector.Run(ctx)
+	}
+}
+
+// Ready tells if the replica leads and has been prepared to serve
+func (e *Elector) Ready() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leading
+}
+
+func (e *Elector) lead(ctx context.Context) {
+	klog.Infof("Started leading, preparing to serve")
+	if e.prepare != nil {
+		err := wait.PollImmediateUntil(e.retryPeriod, func() (bool, error) {
+			if err := e.prepare(ctx); err != nil {
+				klog.Errorf("Failed to prepare to serve as the leader: %v", err)
+				return false, nil
+			}
+			return true, nil
+		}, ctx.Done())
+		if err != nil {
+			// stopped leading while preparing
+			return
+		}
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	// ctx is done before follow is called once the replica stops leading
+	if ctx.Err() == nil {
+		e.leading = true
+		klog.Infof("Serving as the leader")
+	}
+}
+
+func (e *Elector) follow() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.leading = false
+	klog.Infof("Stopped leading, standing by")
+}
+
+// Store returns a Store saving to store only while the replica is ready to
+// serve as the leader, so the followers, which account for nothing, never
+// overwrite the snapshot of the leader
+func (e *Elector) Store(store state.Store) state.Store {
+	return &leaderStore{Store: store, elector: e}
+}
+
+type leaderStore struct {
+	state.Store
+	elector *Elector
+}
+
+func (s *leaderStore) Save(snapshot *state.Snapshot) error {
+	if !s.elector.Ready() {
+		return nil
+	}
+	return s.Store.Save(snapshot)
+}
@@ -0,0 +1,125 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package leader
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"tkestack.io/gpu-admission/pkg/state"
+)
+
+func newTestElector(t *testing.T, client kubernetes.Interface, identity string,
+	prepare func(context.Context) error) *Elector {
+	e, err := NewElector(client, Config{
+		Namespace:     "kube-system",
+		Name:          "gpu-admission",
+		Identity:      identity,
+		LeaseDuration: time.Second,
+		RenewDeadline: 500 * time.Millisecond,
+		RetryPeriod:   100 * time.Millisecond,
+	}, prepare)
+	if err != nil {
+		t.Fatalf("failed to create elector: %v", err)
+	}
+	return e
+}
+
+func waitReady(t *testing.T, e *Elector, ready bool) {
+	t.Helper()
+	if err := wait.PollImmediate(20*time.Millisecond, 5*time.Second, func() (bool, error) {
+		return e.Ready() == ready, nil
+	}); err != nil {
+		t.Fatalf("expect ready %v", ready)
+	}
+}
+
+func TestElectorFailover(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	var prepared int32
+	prepare := func(context.Context) error {
+		atomic.AddInt32(&prepared, 1)
+		return nil
+	}
+
+	ctxA, cancelA := context.WithCancel(context.Background())
+	a := newTestElector(t, client, "a", prepare)
+	go a.Run(ctxA)
+	waitReady(t, a, true)
+
+	ctxB, cancelB := context.WithCancel(context.Background())
+	defer cancelB()
+	b := newTestElector(t, client, "b", prepare)
+	go b.Run(ctxB)
+	// b stands by while a leads
+	time.Sleep(300 * time.Millisecond)
+	if b.Ready() {
+		t.Fatalf("expect only one leader")
+	}
+
+	// a releases the lease on exit and b takes over
+	cancelA()
+	waitReady(t, a, false)
+	waitReady(t, b, true)
+	if n := atomic.LoadInt32(&prepared); n != 2 {
+		t.Fatalf("expect each leader to be prepared once, got %d", n)
+	}
+}
+
+func TestElectorPrepare(t *testing.T) {
+	var attempts int32
+	e := newTestElector(t, fake.NewSimpleClientset(), "a", func(context.Context) error {
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			return errors.New("cache not synced")
+		}
+		return nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go e.Run(ctx)
+
+	// the leader isn't ready until it has been prepared
+	waitReady(t, e, true)
+	if n := atomic.LoadInt32(&attempts); n != 3 {
+		t.Fatalf("expect 3 attempts to prepare, got %d", n)
+	}
+}
+
+func TestElectorStore(t *testing.T) {
+	e := newTestElector(t, fake.NewSimpleClientset(), "a", nil)
+	fakeStore := &state.FakeStore{}
+	store := e.Store(fakeStore)
+
+	if err := store.Save(&state.Snapshot{}); err != nil || fakeStore.Saves != 0 {
+		t.Fatalf("expect a follower not to save, got %d saves, error %v", fakeStore.Saves, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go e.Run(ctx)
+	waitReady(t, e, true)
+	if err := store.Save(&state.Snapshot{}); err != nil || fakeStore.Saves != 1 {
+		t.Fatalf("expect the leader to save, got %d saves, error %v", fakeStore.Saves, err)
+	}
+}
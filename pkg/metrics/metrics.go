@@ -0,0 +1,180 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"tkestack.io/gpu-admission/pkg/device"
+)
+
+const namespace = "gpu_admission"
+
+const (
+	// ModeShare is the mode of a container sharing a GPU
+	ModeShare = "share"
+	// ModeExclusive is the mode of a container taking whole GPUs
+	ModeExclusive = "exclusive"
+	// ModeMIG is the mode of a container taking MIG instances
+	ModeMIG = "mig"
+
+	// OutcomeSuccess and OutcomeFailure are the outcomes of an allocation
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+var (
+	// Allocations counts the pod allocations by mode, outcome and the
+	// reason of a failure
+	Allocations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "allocations_total",
+		Help:      "Number of pod allocations by mode, outcome and failure reason.",
+	}, []string{"mode", "outcome", "reason"})
+
+	// ModeDecisions counts the containers allocated by mode and by the
+	// namespace of the pod, once per pod, that is the mix of shared and
+	// whole GPU requests
+	ModeDecisions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mode_decisions_total",
+		Help:      "Number of containers allocated in share or exclusive mode by namespace.",
+	}, []string{"mode", "namespace"})
+
+	// AllocateLatency observes the time to allocate a container
+	AllocateLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "allocate_one_duration_seconds",
+		Help:      "Latency of allocating GPU devices for a container.",
+		Buckets:   prometheus.ExponentialBuckets(0.00001, 4, 10),
+	}, []string{"mode"})
+
+	// NodeFreeCores and NodeFreeMemory are the allocatable GPU resources of
+	// each node seen by the latest filter
+	NodeFreeCores = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "node_free_gpu_cores",
+		Help:      "Allocatable vcore of the GPUs of a node.",
+	}, []string{"node"})
+	NodeFreeMemory = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "node_free_gpu_memory",
+		Help:      "Allocatable vmemory of the GPUs of a node.",
+	}, []string{"node"})
+	// NodeFragmentation is the fragmentation of the GPUs of each node seen
+	// by the latest filter, see algorithm.FragmentationScore
+	NodeFragmentation = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "node_gpu_fragmentation",
+		Help:      "Part of the allocatable vcore of a node which is on partly used GPUs.",
+	}, []string{"node"})
+
+	// StaleNodes counts the nodes rejected since their state is too old,
+	// see config.MaxNodeStateAge
+	StaleNodes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "stale_node_rejections_total",
+		Help:      "Number of times a node is rejected since its state is too old.",
+	}, []string{"node"})
+
+	// The usage of each GPU seen by the latest filter, labelled by node and
+	// device index only, so a node has a fixed number of series no matter
+	// how the devices are replaced
+	DeviceUsedCores = newDeviceGauge("device_used_cores",
+		"Vcore charged to the containers on a GPU.")
+	DeviceAllocatableCores = newDeviceGauge("device_allocatable_cores",
+		"Vcore of a GPU which can still be allocated.")
+	DeviceUsedMemory = newDeviceGauge("device_used_memory",
+		"Vmemory charged to the containers on a GPU.")
+	DeviceAllocatableMemory = newDeviceGauge("device_allocatable_memory",
+		"Vmemory of a GPU which can still be allocated.")
+	DeviceContainers = newDeviceGauge("device_containers",
+		"Number of containers on a GPU.")
+)
+
+func newDeviceGauge(name, help string) *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      name,
+		Help:      help,
+	}, []string{"node", "device"})
+}
+
+// Register registers all metrics to given registerer, e.g.
+// prometheus.DefaultRegisterer
+func Register(registerer prometheus.Registerer) error {
+	for _, c := range []prometheus.Collector{Allocations, ModeDecisions, AllocateLatency, NodeFreeCores, NodeFreeMemory,
+		NodeFragmentation, StaleNodes, DeviceUsedCores, DeviceAllocatableCores, DeviceUsedMemory, DeviceAllocatableMemory, DeviceContainers} {
+		if err := registerer.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecordAllocation counts an allocation of given mode, an empty reason
+// means it succeeded
+func RecordAllocation(mode, reason string) {
+	outcome := OutcomeSuccess
+	if reason != "" {
+		outcome = OutcomeFailure
+	}
+	Allocations.WithLabelValues(mode, outcome, reason).Inc()
+}
+
+// RecordModeDecision counts a container of a pod in given namespace
+// allocated in given mode
+func RecordModeDecision(mode, namespace string) {
+	ModeDecisions.WithLabelValues(mode, namespace).Inc()
+}
+
+// ObserveAllocateLatency observes the latency of an allocation of given
+// mode since start
+func ObserveAllocateLatency(mode string, start time.Time) {
+	AllocateLatency.WithLabelValues(mode).Observe(time.Since(start).Seconds())
+}
+
+// SetNodeFree sets the allocatable GPU resources of the node
+func SetNodeFree(node string, cores, memory int) {
+	NodeFreeCores.WithLabelValues(node).Set(float64(cores))
+	NodeFreeMemory.WithLabelValues(node).Set(float64(memory))
+}
+
+// SetNodeFragmentation sets the fragmentation of the GPUs of the node
+func SetNodeFragmentation(node string, fragmentation float64) {
+	NodeFragmentation.WithLabelValues(node).Set(fragmentation)
+}
+
+// RecordStaleNode counts a rejection of the node whose state is too old
+func RecordStaleNode(node string) {
+	StaleNodes.WithLabelValues(node).Inc()
+}
+
+// SetDevices sets the usage of each GPU of the node
+func SetDevices(n *device.NodeInfo) {
+	for id, dev := range n.GetDeviceMap() {
+		labels := []string{n.GetName(), strconv.Itoa(id)}
+		DeviceUsedCores.WithLabelValues(labels...).Set(float64(dev.UsedCores()))
+		DeviceAllocatableCores.WithLabelValues(labels...).Set(float64(dev.AllocatableCores()))
+		DeviceUsedMemory.WithLabelValues(labels...).Set(float64(dev.UsedMemory()))
+		DeviceAllocatableMemory.WithLabelValues(labels...).Set(float64(dev.AllocatableMemory()))
+		DeviceContainers.WithLabelValues(labels...).Set(float64(dev.NumberofContainer()))
+	}
+}
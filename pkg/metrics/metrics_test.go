@@ -0,0 +1,123 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"tkestack.io/gpu-admission/pkg/device"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+func TestRegister(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	if err := Register(registry); err != nil {
+		t.Fatalf("failed to register: %v", err)
+	}
+	if err := Register(registry); err == nil {
+		t.Fatalf("expect an error registering twice")
+	}
+
+	RecordAllocation(ModeShare, "")
+	RecordAllocation(ModeExclusive, "insufficient free GPUs")
+	RecordModeDecision(ModeShare, "ns1")
+	ObserveAllocateLatency(ModeMIG, time.Now())
+	SetNodeFree("node1", 150, 40)
+	SetNodeFragmentation("node1", 0.25)
+	RecordStaleNode("node1")
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("failed to gather: %v", err)
+	}
+	names := make(map[string]bool)
+	for _, f := range families {
+		names[f.GetName()] = true
+	}
+	for _, name := range []string{
+		"gpu_admission_allocations_total",
+		"gpu_admission_mode_decisions_total",
+		"gpu_admission_allocate_one_duration_seconds",
+		"gpu_admission_node_free_gpu_cores",
+		"gpu_admission_node_free_gpu_memory",
+		"gpu_admission_node_gpu_fragmentation",
+		"gpu_admission_stale_node_rejections_total",
+	} {
+		if !names[name] {
+			t.Errorf("metric %s is not gathered", name)
+		}
+	}
+
+	if v := testutil.ToFloat64(Allocations.WithLabelValues(ModeExclusive, OutcomeFailure,
+		"insufficient free GPUs")); v != 1 {
+		t.Errorf("expect 1 failed exclusive allocation, got %v", v)
+	}
+	if v := testutil.ToFloat64(ModeDecisions.WithLabelValues(ModeShare, "ns1")); v != 1 {
+		t.Errorf("expect 1 shared decision in ns1, got %v", v)
+	}
+	if v := testutil.ToFloat64(NodeFreeCores.WithLabelValues("node1")); v != 150 {
+		t.Errorf("expect 150 free cores, got %v", v)
+	}
+	if v := testutil.ToFloat64(NodeFragmentation.WithLabelValues("node1")); v != 0.25 {
+		t.Errorf("expect fragmentation 0.25, got %v", v)
+	}
+	if v := testutil.ToFloat64(StaleNodes.WithLabelValues("node1")); v != 1 {
+		t.Errorf("expect 1 stale node rejection, got %v", v)
+	}
+}
+
+func TestSetDevices(t *testing.T) {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node2"},
+		Status: corev1.NodeStatus{
+			Capacity: corev1.ResourceList{
+				corev1.ResourceName(util.VCoreAnnotation):   resource.MustParse("200"),
+				corev1.ResourceName(util.VMemoryAnnotation): resource.MustParse("40"),
+			},
+		},
+	}
+	nodeInfo := device.NewNodeInfo(node, nil)
+	if err := nodeInfo.AddUsedResources(1, 30, 10, 0); err != nil {
+		t.Fatalf("failed to charge device 1: %v", err)
+	}
+	SetDevices(nodeInfo)
+
+	for _, c := range []struct {
+		gauge  *prometheus.GaugeVec
+		device string
+		expect float64
+	}{
+		{DeviceUsedCores, "1", 30},
+		{DeviceAllocatableCores, "1", 70},
+		{DeviceAllocatableCores, "0", 100},
+		{DeviceUsedMemory, "1", 10},
+		{DeviceAllocatableMemory, "1", 10},
+		{DeviceContainers, "1", 1},
+		{DeviceContainers, "0", 0},
+	} {
+		if v := testutil.ToFloat64(c.gauge.WithLabelValues("node2", c.device)); v != c.expect {
+			t.Errorf("expect %v of device %s, got %v", c.expect, c.device, v)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+
+	"tkestack.io/gpu-admission/pkg/algorithm"
+	"tkestack.io/gpu-admission/pkg/config"
+	"tkestack.io/gpu-admission/pkg/device"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+// Name is the name of the plugin in the scheduler configuration
+const Name = "GPUAdmission"
+
+// allocatedKey is the key of the annotations of the pod allocated in
+// Reserve, which PreBind patches to the pod
+const allocatedKey framework.StateKey = Name + "/allocated"
+
+// GPUPlugin is the in-process counterpart of the GPU extender, it runs the
+// allocator on the node infos of the scheduler cache rather than on pods
+// listed by an informer of its own:
+//
+//   - Filter plans the pod on the node, like the filter verb, without
+//     charging the node
+//   - Score scores the node like the prioritize verb, scaled to
+//     framework.MaxNodeScore
+//   - Reserve allocates the GPUs of the node chosen and annotates the pod
+//     assumed, so the later scheduling cycles see the node charged
+//   - PreBind patches the annotations to the pod before it is bound
+type GPUPlugin struct {
+	handle framework.FrameworkHandle
+	config *config.Store
+}
+
+var (
+	_ framework.FilterPlugin  = &GPUPlugin{}
+	_ framework.ScorePlugin   = &GPUPlugin{}
+	_ framework.ReservePlugin = &GPUPlugin{}
+	_ framework.PreBindPlugin = &GPUPlugin{}
+)
+
+// NewFactory returns the factory of the plugin under the config of given
+// store, to be registered to the scheduler, e.g.
+//
+//	app.NewSchedulerCommand(app.WithPlugin(plugin.Name, plugin.NewFactory(store)))
+//
+// The plugin args of the scheduler configuration are ignored.
+func NewFactory(store *config.Store) framework.PluginFactory {
+	return func(_ *runtime.Unknown, handle framework.FrameworkHandle) (framework.Plugin, error) {
+		return &GPUPlugin{handle: handle, config: store}, nil
+	}
+}
+
+func (p *GPUPlugin) Name() string {
+	return Name
+}
+
+// Filter tells whether the node can hold the GPU request of the pod
+func (p *GPUPlugin) Filter(ctx context.Context, _ *framework.CycleState, pod *corev1.Pod,
+	nodeInfo *schedulernodeinfo.NodeInfo) *framework.Status {
+	if !util.IsGPURequiredPod(pod) {
+		return nil
+	}
+	n, status := p.deviceInfoOf(pod, nodeInfo)
+	if status != nil {
+		return status
+	}
+	if _, err := algorithm.NewAllocator(n).Plan(ctx, pod); err != nil {
+		return framework.NewStatus(framework.Unschedulable, algorithm.FailureReason(err))
+	}
+	return nil
+}
+
+// Score scores the node by its GPU usage under the node policy, see
+// allocator.Score
+func (p *GPUPlugin) Score(_ context.Context, _ *framework.CycleState, pod *corev1.Pod,
+	nodeName string) (int64, *framework.Status) {
+	if !util.IsGPURequiredPod(pod) {
+		return 0, nil
+	}
+	nodeInfo, err := p.handle.SnapshotSharedLister().NodeInfos().Get(nodeName)
+	if err != nil {
+		return 0, framework.NewStatus(framework.Error, err.Error())
+	}
+	n, status := p.deviceInfoOf(pod, nodeInfo)
+	if status != nil {
+		return 0, nil
+	}
+	score := algorithm.NewAllocator(n).Score(pod, p.config.Load().ForProfile(pod.Spec.SchedulerName).NodePolicy)
+	return score * framework.MaxNodeScore / extenderv1.MaxExtenderPriority, nil
+}
+
+func (p *GPUPlugin) ScoreExtensions() framework.ScoreExtensions {
+	return nil
+}
+
+// Reserve allocates the GPUs of the node to the pod. The annotations of the
+// allocation are set to the pod assumed, which is the one the scheduler
+// cache holds, and kept in the cycle state for PreBind. If the pod is
+// rejected later, the scheduler cache forgets it along with the charge.
+func (p *GPUPlugin) Reserve(ctx context.Context, state *framework.CycleState, pod *corev1.Pod,
+	nodeName string) *framework.Status {
+	if !util.IsGPURequiredPod(pod) {
+		return nil
+	}
+	nodeInfo, err := p.handle.SnapshotSharedLister().NodeInfos().Get(nodeName)
+	if err != nil {
+		return framework.NewStatus(framework.Error, err.Error())
+	}
+	n, status := p.deviceInfoOf(pod, nodeInfo)
+	if status != nil {
+		return status
+	}
+	newPod, err := algorithm.NewAllocator(n).Allocate(ctx, pod)
+	if err != nil {
+		return framework.NewStatus(framework.Unschedulable, algorithm.FailureReason(err))
+	}
+	annotations := make(map[string]string)
+	if pod.Annotations == nil {
+		pod.Annotations = make(map[string]string)
+	}
+	for k, v := range newPod.Annotations {
+		if util.IsPredicateAnnotation(k) {
+			annotations[k] = v
+			pod.Annotations[k] = v
+		}
+	}
+	state.Write(allocatedKey, allocated(annotations))
+	return nil
+}
+
+// PreBind patches the annotations of the allocation to the pod
+func (p *GPUPlugin) PreBind(ctx context.Context, state *framework.CycleState, pod *corev1.Pod,
+	nodeName string) *framework.Status {
+	data, err := state.Read(allocatedKey)
+	if err != nil {
+		// Reserve allocated nothing, the pod requires no GPU
+		return nil
+	}
+	payload, _ := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string(data.(allocated)),
+		},
+	})
+	_, err = p.handle.ClientSet().CoreV1().Pods(pod.Namespace).
+		Patch(ctx, pod.Name, k8stypes.StrategicMergePatchType, payload, metav1.PatchOptions{})
+	if err != nil {
+		klog.Infof("failed to patch the GPU annotations to pod %s: %v", pod.UID, err)
+		return framework.NewStatus(framework.Error,
+			fmt.Sprintf("failed to patch the GPU annotations to pod %s: %v", pod.UID, err))
+	}
+	return nil
+}
+
+// deviceInfoOf returns the NodeInfo of the GPUs of given node under the
+// config of the pod profile, the pods charged are those of the scheduler
+// cache, assumed ones included
+func (p *GPUPlugin) deviceInfoOf(pod *corev1.Pod, nodeInfo *schedulernodeinfo.NodeInfo) (*device.NodeInfo,
+	*framework.Status) {
+	node := nodeInfo.Node()
+	if node == nil {
+		return nil, framework.NewStatus(framework.Error, "node not found")
+	}
+	if !util.IsGPUEnabledNode(node) {
+		return nil, framework.NewStatus(framework.UnschedulableAndUnresolvable,
+			fmt.Sprintf("node %s has no GPU", node.Name))
+	}
+	cfg := p.config.Load().ForProfile(pod.Spec.SchedulerName)
+	return device.NewNodeInfoWithConfig(node, nodeInfo.Pods(), cfg), nil
+}
+
+// allocated is the state of the annotations allocated in Reserve
+type allocated map[string]string
+
+func (a allocated) Clone() framework.StateData {
+	c := make(allocated, len(a))
+	for k, v := range a {
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,185 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+	schedulerlisters "k8s.io/kubernetes/pkg/scheduler/listers"
+	fakelisters "k8s.io/kubernetes/pkg/scheduler/listers/fake"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+
+	"tkestack.io/gpu-admission/pkg/config"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+// fakeHandle is the framework handle of the scheduler cache given
+type fakeHandle struct {
+	framework.FrameworkHandle
+	nodes  fakelisters.NodeInfoLister
+	client kubernetes.Interface
+}
+
+func (h *fakeHandle) SnapshotSharedLister() schedulerlisters.SharedLister {
+	return h
+}
+
+func (h *fakeHandle) Pods() schedulerlisters.PodLister {
+	return nil
+}
+
+func (h *fakeHandle) NodeInfos() schedulerlisters.NodeInfoLister {
+	return h.nodes
+}
+
+func (h *fakeHandle) ClientSet() kubernetes.Interface {
+	return h.client
+}
+
+func newTestNode(name string, deviceCount, totalMemory int) *corev1.Node {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status: corev1.NodeStatus{
+			Capacity: corev1.ResourceList{},
+		},
+	}
+	if deviceCount > 0 {
+		node.Status.Capacity[corev1.ResourceName(util.VCoreAnnotation)] =
+			resource.MustParse(fmt.Sprintf("%d", deviceCount*util.HundredCore))
+		node.Status.Capacity[corev1.ResourceName(util.VMemoryAnnotation)] =
+			resource.MustParse(fmt.Sprintf("%d", totalMemory))
+	}
+	return node
+}
+
+func newTestPod(name string, cores, memory int) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "test-ns",
+			UID:         k8stypes.UID("uid-" + name),
+			Annotations: map[string]string{util.EstimatedTime + "0": "0"},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "container-0"}},
+		},
+	}
+	if cores > 0 {
+		pod.Spec.Containers[0].Resources.Limits = corev1.ResourceList{
+			corev1.ResourceName(util.VCoreAnnotation):   resource.MustParse(fmt.Sprintf("%d", cores)),
+			corev1.ResourceName(util.VMemoryAnnotation): resource.MustParse(fmt.Sprintf("%d", memory)),
+		}
+	}
+	return pod
+}
+
+func newTestPlugin(t *testing.T, pods []*corev1.Pod, nodes ...*corev1.Node) (*GPUPlugin, *fakeHandle) {
+	handle := &fakeHandle{client: fake.NewSimpleClientset()}
+	for _, pod := range pods {
+		if _, err := handle.client.CoreV1().Pods(pod.Namespace).Create(context.Background(), pod,
+			metav1.CreateOptions{}); err != nil {
+			t.Fatalf("failed to create pod %s: %v", pod.Name, err)
+		}
+	}
+	for _, node := range nodes {
+		nodeInfo := schedulernodeinfo.NewNodeInfo()
+		if err := nodeInfo.SetNode(node); err != nil {
+			t.Fatalf("failed to set node %s: %v", node.Name, err)
+		}
+		handle.nodes = append(handle.nodes, nodeInfo)
+	}
+	p, err := NewFactory(config.NewStore(config.Default()))(nil, handle)
+	if err != nil {
+		t.Fatalf("failed to create the plugin: %v", err)
+	}
+	return p.(*GPUPlugin), handle
+}
+
+func TestPluginSchedulingCycle(t *testing.T) {
+	ctx := context.Background()
+	shared := newTestPod("shared", 50, 1)
+	p, handle := newTestPlugin(t, []*corev1.Pod{shared}, newTestNode("testnode", 2, 8))
+	nodeInfo := handle.nodes[0]
+
+	state := framework.NewCycleState()
+	if status := p.Filter(ctx, state, shared, nodeInfo); !status.IsSuccess() {
+		t.Fatalf("expected the shared pod to fit, got %v", status.Message())
+	}
+	score, status := p.Score(ctx, state, shared, "testnode")
+	if !status.IsSuccess() {
+		t.Fatalf("failed to score the node: %v", status.Message())
+	}
+	if status := p.Reserve(ctx, state, shared, "testnode"); !status.IsSuccess() {
+		t.Fatalf("failed to reserve the node: %v", status.Message())
+	}
+	if shared.Annotations[util.PredicateNode] != "testnode" {
+		t.Errorf("expected the pod assumed to be annotated on testnode, got %v", shared.Annotations)
+	}
+	if status := p.PreBind(ctx, state, shared, "testnode"); !status.IsSuccess() {
+		t.Fatalf("failed to patch the pod: %v", status.Message())
+	}
+	patched, err := handle.client.CoreV1().Pods(shared.Namespace).Get(ctx, shared.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get the pod: %v", err)
+	}
+	if patched.Annotations[util.PredicateGPUIndexPrefix+"0"] != shared.Annotations[util.PredicateGPUIndexPrefix+"0"] {
+		t.Errorf("expected the GPU index patched, got %v", patched.Annotations)
+	}
+
+	// the scheduler cache assumes the pod, so the node is charged for it
+	nodeInfo.AddPod(shared)
+	packed, status := p.Score(ctx, framework.NewCycleState(), newTestPod("other", 50, 1), "testnode")
+	if !status.IsSuccess() {
+		t.Fatalf("failed to score the node: %v", status.Message())
+	}
+	if packed <= score || packed > framework.MaxNodeScore {
+		t.Errorf("expected the node used to score in (%d, %d] under the pack policy, got %d",
+			score, framework.MaxNodeScore, packed)
+	}
+	whole := newTestPod("whole", 2*util.HundredCore, 8)
+	status = p.Filter(ctx, framework.NewCycleState(), whole, nodeInfo)
+	if status.Code() != framework.Unschedulable {
+		t.Errorf("expected the pod of 2 GPUs to be unschedulable, got %v", status.Code())
+	}
+}
+
+func TestPluginFilterSkip(t *testing.T) {
+	ctx := context.Background()
+	p, handle := newTestPlugin(t, nil, newTestNode("cpunode", 0, 0))
+
+	if status := p.Filter(ctx, framework.NewCycleState(), newTestPod("cpu", 0, 0),
+		handle.nodes[0]); !status.IsSuccess() {
+		t.Errorf("expected a pod without GPU to fit any node, got %v", status.Message())
+	}
+	status := p.Filter(ctx, framework.NewCycleState(), newTestPod("gpu", 50, 1), handle.nodes[0])
+	if status.Code() != framework.UnschedulableAndUnresolvable {
+		t.Errorf("expected a node without GPU to be unresolvable, got %v", status.Code())
+	}
+	if status := p.PreBind(ctx, framework.NewCycleState(), newTestPod("cpu", 0, 0),
+		"cpunode"); !status.IsSuccess() {
+		t.Errorf("expected nothing to patch for a pod not reserved, got %v", status.Message())
+	}
+}
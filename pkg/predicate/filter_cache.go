@@ -0,0 +1,220 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package predicate
+
+import (
+	"container/list"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+// filterCache remembers why a pod doesn't fit a node, so the same node
+// isn't evaluated again and again for the replicas of a workload. A reason
+// is keyed by the pod's GPU request, the node and the generation of the
+// node, which changes with the node and the pods on it. The least recently
+// used reasons are dropped when the cache is full.
+type filterCache struct {
+	sync.Mutex
+	size  int
+	ttl   time.Duration
+	now   func() time.Time
+	lru   *list.List
+	items map[filterCacheKey]*list.Element
+
+	hits, misses uint64
+}
+
+type filterCacheKey struct {
+	pod        string
+	node       string
+	generation string
+}
+
+type filterCacheEntry struct {
+	key    filterCacheKey
+	reason string
+	expire time.Time
+}
+
+// newFilterCache returns nil if size is 0, a nil cache never hits
+func newFilterCache(size uint, ttl time.Duration) *filterCache {
+	if size == 0 {
+		return nil
+	}
+	return &filterCache{
+		size:  int(size),
+		ttl:   ttl,
+		now:   time.Now,
+		lru:   list.New(),
+		items: make(map[filterCacheKey]*list.Element),
+	}
+}
+
+// Get returns why the pod doesn't fit the node, ok is false on a miss
+func (c *filterCache) Get(key filterCacheKey) (reason string, ok bool) {
+	if c == nil {
+		return "", false
+	}
+	c.Lock()
+	defer c.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		c.misses++
+		return "", false
+	}
+	entry := elem.Value.(*filterCacheEntry)
+	if c.now().After(entry.expire) {
+		c.lru.Remove(elem)
+		delete(c.items, key)
+		c.misses++
+		return "", false
+	}
+	c.lru.MoveToFront(elem)
+	c.hits++
+	return entry.reason, true
+}
+
+// Put records why the pod doesn't fit the node
+func (c *filterCache) Put(key filterCacheKey, reason string) {
+	if c == nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+
+	expire := c.now().Add(c.ttl)
+	if elem, ok := c.items[key]; ok {
+		entry := elem.Value.(*filterCacheEntry)
+		entry.reason, entry.expire = reason, expire
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.items[key] = c.lru.PushFront(&filterCacheEntry{key: key, reason: reason, expire: expire})
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.items, oldest.Value.(*filterCacheEntry).key)
+	}
+}
+
+// Invalidate drops the results of the node, it's called once the node is
+// charged for a pod
+func (c *filterCache) Invalidate(node string) {
+	if c == nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+
+	for key, elem := range c.items {
+		if key.node == node {
+			c.lru.Remove(elem)
+			delete(c.items, key)
+		}
+	}
+}
+
+// Purge drops all results
+func (c *filterCache) Purge() {
+	if c == nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+
+	c.lru.Init()
+	c.items = make(map[filterCacheKey]*list.Element)
+}
+
+// Stats returns the number of hits and misses
+func (c *filterCache) Stats() (hits, misses uint64) {
+	if c == nil {
+		return 0, 0
+	}
+	c.Lock()
+	defer c.Unlock()
+	return c.hits, c.misses
+}
+
+// newFilterCacheKey returns the key of the pod on the node with given pods
+func newFilterCacheKey(pod *corev1.Pod, node *corev1.Node, pods []*corev1.Pod) filterCacheKey {
+	return filterCacheKey{
+		pod:        podSignature(pod),
+		node:       node.Name,
+		generation: nodeGeneration(node, pods),
+	}
+}
+
+// podSignature hashes what the allocation of the pod depends on, i.e. its
+// scheduler profile, the limits of its containers and its annotations
+// except the predicate ones, so the replicas of a workload share the
+// signature
+func podSignature(pod *corev1.Pod) string {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "s/%s;", pod.Spec.SchedulerName)
+	writeContainers := func(kind string, containers []corev1.Container) {
+		for i, c := range containers {
+			names := make([]string, 0, len(c.Resources.Limits))
+			for name := range c.Resources.Limits {
+				names = append(names, string(name))
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				q := c.Resources.Limits[corev1.ResourceName(name)]
+				fmt.Fprintf(h, "%s%d/%s=%s;", kind, i, name, q.String())
+			}
+		}
+	}
+	writeContainers("c", pod.Spec.Containers)
+	writeContainers("i", pod.Spec.InitContainers)
+
+	keys := make([]string, 0, len(pod.Annotations))
+	for k := range pod.Annotations {
+		if !util.IsPredicateAnnotation(k) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(h, "a/%s=%s;", k, pod.Annotations[k])
+	}
+	return fmt.Sprintf("%x", h.Sum64())
+}
+
+// nodeGeneration changes whenever the node or any pod on it changes
+func nodeGeneration(node *corev1.Node, pods []*corev1.Pod) string {
+	versions := make([]string, 0, len(pods))
+	for _, p := range pods {
+		versions = append(versions, string(p.UID)+"@"+p.ResourceVersion)
+	}
+	sort.Strings(versions)
+
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s;", node.ResourceVersion)
+	for _, v := range versions {
+		fmt.Fprintf(h, "%s;", v)
+	}
+	return fmt.Sprintf("%x", h.Sum64())
+}
@@ -0,0 +1,184 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package predicate
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	listerv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"tkestack.io/gpu-admission/pkg/config"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+func TestFilterCache(t *testing.T) {
+	now := time.Now()
+	c := newFilterCache(2, time.Second)
+	c.now = func() time.Time { return now }
+
+	k1 := filterCacheKey{pod: "p", node: "n1", generation: "1"}
+	k2 := filterCacheKey{pod: "p", node: "n2", generation: "1"}
+	k3 := filterCacheKey{pod: "p", node: "n3", generation: "1"}
+	c.Put(k1, "insufficient vcore")
+	c.Put(k2, "insufficient vmemory")
+	if reason, ok := c.Get(k1); !ok || reason != "insufficient vcore" {
+		t.Fatalf("expect the cached reason of n1, got %q %v", reason, ok)
+	}
+	// k2 is the least recently used one
+	c.Put(k3, "insufficient vcore")
+	if _, ok := c.Get(k2); ok {
+		t.Fatalf("expect n2 to be evicted")
+	}
+
+	c.Invalidate("n1")
+	if _, ok := c.Get(k1); ok {
+		t.Fatalf("expect n1 to be invalidated")
+	}
+
+	now = now.Add(2 * time.Second)
+	if _, ok := c.Get(k3); ok {
+		t.Fatalf("expect n3 to be expired")
+	}
+	if hits, misses := c.Stats(); hits != 1 || misses != 3 {
+		t.Fatalf("expect 1 hit and 3 misses, got %d and %d", hits, misses)
+	}
+
+	var disabled *filterCache
+	disabled.Put(k1, "insufficient vcore")
+	if _, ok := disabled.Get(k1); ok {
+		t.Fatalf("a nil cache should never hit")
+	}
+}
+
+func TestFilterCacheKey(t *testing.T) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "n", ResourceVersion: "1"}}
+	pod := newScoredPod(50)
+	replica := newScoredPod(50)
+	replica.Name, replica.UID = "replica", "replica"
+	replica.Annotations[util.PredicateNode] = "another"
+	if newFilterCacheKey(pod, node, nil) != newFilterCacheKey(replica, node, nil) {
+		t.Fatalf("replicas should share the key")
+	}
+
+	if newFilterCacheKey(pod, node, nil) == newFilterCacheKey(newScoredPod(60), node, nil) {
+		t.Fatalf("pods of different requests should not share the key")
+	}
+	if newFilterCacheKey(pod, node, nil) == newFilterCacheKey(pod, node, []*corev1.Pod{replica}) {
+		t.Fatalf("the key should change with the pods on the node")
+	}
+	updated := node.DeepCopy()
+	updated.ResourceVersion = "2"
+	if newFilterCacheKey(pod, node, nil) == newFilterCacheKey(pod, updated, nil) {
+		t.Fatalf("the key should change with the node")
+	}
+}
+
+// newBusyFilter returns a filter of nodes whose GPUs are all used
+func newBusyFilter(tb testing.TB, nodeCount int, cacheSize uint) (*GPUFilter, []corev1.Node) {
+	var nodes []corev1.Node
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for i := 0; i < nodeCount; i++ {
+		node := corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-" + strconv.Itoa(i), ResourceVersion: "1"},
+			Status: corev1.NodeStatus{
+				Capacity: corev1.ResourceList{
+					corev1.ResourceName(util.VCoreAnnotation):   resource.MustParse(fmt.Sprintf("%d", deviceCount*util.HundredCore)),
+					corev1.ResourceName(util.VMemoryAnnotation): resource.MustParse(fmt.Sprintf("%d", totalMemory)),
+				},
+			},
+		}
+		nodes = append(nodes, node)
+		for dev := 0; dev < deviceCount; dev++ {
+			pod := newScoredPod(util.HundredCore)
+			pod.Name = fmt.Sprintf("%s-%d", node.Name, dev)
+			pod.UID = k8stypes.UID(pod.Name)
+			pod.ResourceVersion = "1"
+			pod.Spec.NodeName = node.Name
+			pod.Annotations[util.PredicateGPUIndexPrefix+"0"] = strconv.Itoa(dev)
+			if err := indexer.Add(pod); err != nil {
+				tb.Fatalf("failed to add pod: %v", err)
+			}
+		}
+	}
+	cfg := config.Default()
+	return &GPUFilter{
+		kubeClient: fake.NewSimpleClientset(),
+		podLister:  listerv1.NewPodLister(indexer),
+		config:     config.NewStore(cfg),
+		cache:      newFilterCache(cacheSize, cfg.FilterCacheTTL.Duration),
+	}, nodes
+}
+
+func TestDeviceFilterFailureReason(t *testing.T) {
+	gpuFilter, nodes := newBusyFilter(t, 2, 10)
+	pod := newScoredPod(50)
+	for i := 0; i < 2; i++ {
+		_, failedNodes, err := gpuFilter.deviceFilter(context.Background(), pod, nodes)
+		if err != nil {
+			t.Fatalf("deviceFilter failed: %v", err)
+		}
+		// the second round is served from the cache
+		for _, node := range nodes {
+			if reason := failedNodes[node.Name]; reason != "container c0: insufficient vcore, request 50, max allocatable 0" {
+				t.Fatalf("round %d: unexpected reason of node %s: %q", i, node.Name, reason)
+			}
+		}
+	}
+	if hits, _ := gpuFilter.cache.Stats(); hits != 2 {
+		t.Fatalf("expect 2 hits, got %d", hits)
+	}
+}
+
+func benchmarkDeviceFilter(b *testing.B, nodeCount int, cacheSize uint) {
+	gpuFilter, nodes := newBusyFilter(b, nodeCount, cacheSize)
+	pod := newScoredPod(50)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		passed, _, err := gpuFilter.deviceFilter(context.Background(), pod, nodes)
+		if err != nil || len(passed) != 0 {
+			b.Fatalf("expect no node to pass, got %d: %v", len(passed), err)
+		}
+	}
+	b.StopTimer()
+	if hits, misses := gpuFilter.cache.Stats(); hits+misses > 0 {
+		b.ReportMetric(float64(hits)/float64(hits+misses), "hit-rate")
+	}
+}
+
+func BenchmarkDeviceFilterNoCache(b *testing.B) {
+	benchmarkDeviceFilter(b, 50, 0)
+}
+
+func BenchmarkDeviceFilterCache(b *testing.B) {
+	benchmarkDeviceFilter(b, 50, 1000)
+}
+
+// BenchmarkDeviceFilter200Nodes evaluates each of the nodes, see
+// filterWorkers
+func BenchmarkDeviceFilter200Nodes(b *testing.B) {
+	benchmarkDeviceFilter(b, 200, 0)
+}
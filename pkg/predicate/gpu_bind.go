@@ -0,0 +1,260 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package predicate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+
+	"tkestack.io/gpu-admission/pkg/algorithm"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+// Bind allocates the devices of the pod on the node, writes the predicate
+// annotations together with GPUAssigned and PredicateTimeAnnotation, then
+// binds the pod to the node. The namespace quota and the node cache are
+// charged for the pod, and a pod beyond the quota isn't bound. If the
+// binding fails, the charges and the annotations of the pod are rolled back. With
+// RecordAllocatedCondition, the condition GPUAllocated is set before the
+// binding as well, and is rolled back together with the annotations.
+func (gpuFilter *GPUFilter) Bind(args extenderv1.ExtenderBindingArgs) *extenderv1.ExtenderBindingResult {
+	if err := gpuFilter.bind(args); err != nil {
+		klog.Errorf("failed to bind pod %s/%s to node %s: %v",
+			args.PodNamespace, args.PodName, args.Node, err)
+		return &extenderv1.ExtenderBindingResult{Error: err.Error()}
+	}
+	return &extenderv1.ExtenderBindingResult{}
+}
+
+func (gpuFilter *GPUFilter) bind(args extenderv1.ExtenderBindingArgs) error {
+	pod, err := gpuFilter.kubeClient.CoreV1().Pods(args.PodNamespace).
+		Get(context.Background(), args.PodName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get pod: %v", err)
+	}
+	if pod.UID != args.PodUID {
+		return fmt.Errorf("pod %s/%s has UID %s, expect %s",
+			pod.Namespace, pod.Name, pod.UID, args.PodUID)
+	}
+	if pod, err = gpuFilter.claims.Resolve(context.Background(), pod); err != nil {
+		return err
+	}
+	binding := &corev1.Binding{
+		ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace, UID: pod.UID},
+		Target:     corev1.ObjectReference{Kind: "Node", Name: args.Node},
+	}
+	if !util.IsGPURequiredPod(pod) {
+		return gpuFilter.createBinding(binding)
+	}
+
+	if gpuFilter.quota != nil {
+		if err := gpuFilter.quota.Charge(pod); err != nil {
+			return err
+		}
+	}
+	bound := false
+	defer func() {
+		if !bound && gpuFilter.quota != nil {
+			gpuFilter.quota.Release(pod)
+		}
+	}()
+
+	node, err := gpuFilter.kubeClient.CoreV1().Nodes().
+		Get(context.Background(), args.Node, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get node: %v", err)
+	}
+	// the node is locked until the node cache is charged for the pod, so
+	// a filter or another binding doesn't take its devices meanwhile
+	unlock := gpuFilter.nodes.lockNode(node.Name)
+	locked := true
+	defer func() {
+		if locked {
+			unlock()
+		}
+	}()
+	nodeInfo, err := gpuFilter.currentNodeInfo(node, gpuFilter.configOf(pod))
+	if err != nil {
+		return fmt.Errorf("failed to get pods on node: %v", err)
+	}
+	newPod, err := algorithm.NewAllocator(nodeInfo).Allocate(context.Background(), pod)
+	if err != nil {
+		return err
+	}
+
+	annotationMap := make(map[string]string)
+	for k, v := range newPod.Annotations {
+		if util.IsPredicateAnnotation(k) {
+			annotationMap[k] = v
+		}
+	}
+	annotationMap[util.GPUAssigned] = "false"
+	annotationMap[util.PredicateTimeAnnotation] = fmt.Sprintf("%d", time.Now().UnixNano())
+	patched, err := gpuFilter.patchPodWithAnnotations(context.Background(), pod, annotationMap)
+	if err != nil {
+		return err
+	}
+	// the node cache is charged for the pod patched until it's rolled back
+	gpuFilter.cache.Invalidate(node.Name)
+	gpuFilter.nodes.updatePod(gpuFilter.claims.ResolvePlaced(patched))
+	unlock()
+	locked = false
+	defer func() {
+		if !bound {
+			gpuFilter.cache.Invalidate(node.Name)
+			gpuFilter.nodes.restorePod(pod)
+		}
+	}()
+
+	recordCondition := gpuFilter.configOf(pod).RecordAllocatedCondition
+	if recordCondition {
+		if err := gpuFilter.patchPodCondition(pod, allocatedCondition(newPod, args.Node)); err != nil {
+			if rollbackErr := gpuFilter.restorePodAnnotations(pod, annotationMap); rollbackErr != nil {
+				klog.Errorf("failed to roll back annotations of pod %s: %v", pod.UID, rollbackErr)
+			}
+			return err
+		}
+	}
+
+	if err := gpuFilter.createBinding(binding); err != nil {
+		if recordCondition {
+			if rollbackErr := gpuFilter.restorePodCondition(pod); rollbackErr != nil {
+				klog.Errorf("failed to roll back condition of pod %s: %v", pod.UID, rollbackErr)
+			}
+		}
+		if rollbackErr := gpuFilter.restorePodAnnotations(pod, annotationMap); rollbackErr != nil {
+			klog.Errorf("failed to roll back annotations of pod %s: %v", pod.UID, rollbackErr)
+		}
+		return err
+	}
+	bound = true
+	return nil
+}
+
+func (gpuFilter *GPUFilter) createBinding(binding *corev1.Binding) error {
+	err := gpuFilter.kubeClient.CoreV1().Pods(binding.Namespace).
+		Bind(context.Background(), binding, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to create binding: %v", err)
+	}
+	return nil
+}
+
+// allocatedCondition returns the condition GPUAllocated of pod allocated on
+// given node, the message tells the GPU devices of each container
+func allocatedCondition(pod *corev1.Pod, node string) corev1.PodCondition {
+	var devices []string
+	for i, c := range pod.Spec.Containers {
+		if !util.IsGPURequiredContainer(&c) {
+			continue
+		}
+		ids, err := util.GetPredicateIdxOfContainer(pod, i)
+		if err != nil {
+			continue
+		}
+		devices = append(devices, fmt.Sprintf("container %s: GPU %s", c.Name, joinInts(ids)))
+	}
+	message := "node " + node
+	if len(devices) > 0 {
+		message += ", " + strings.Join(devices, ", ")
+	}
+	return corev1.PodCondition{
+		Type:               util.GPUAllocatedCondition,
+		Status:             corev1.ConditionTrue,
+		LastTransitionTime: metav1.Now(),
+		Reason:             "Allocated",
+		Message:            message,
+	}
+}
+
+func joinInts(ids []int) string {
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		s[i] = strconv.Itoa(id)
+	}
+	return strings.Join(s, ",")
+}
+
+// patchPodCondition sets the condition of the pod status, the condition of
+// the same type is replaced
+func (gpuFilter *GPUFilter) patchPodCondition(pod *corev1.Pod, condition corev1.PodCondition) error {
+	return gpuFilter.patchPodStatus(pod, []interface{}{condition})
+}
+
+// restorePodCondition removes the condition GPUAllocated from the pod
+// status, or sets it back if the pod had one
+func (gpuFilter *GPUFilter) restorePodCondition(pod *corev1.Pod) error {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == util.GPUAllocatedCondition {
+			return gpuFilter.patchPodCondition(pod, c)
+		}
+	}
+	return gpuFilter.patchPodStatus(pod, []interface{}{map[string]interface{}{
+		"type":   util.GPUAllocatedCondition,
+		"$patch": "delete",
+	}})
+}
+
+// patchPodStatus patches the conditions of the pod status, which are merged
+// by their types
+func (gpuFilter *GPUFilter) patchPodStatus(pod *corev1.Pod, conditions []interface{}) error {
+	payload := map[string]interface{}{
+		"status": map[string]interface{}{"conditions": conditions},
+	}
+	payloadBytes, _ := json.Marshal(payload)
+	err := wait.PollImmediate(time.Second, waitTimeout, func() (bool, error) {
+		_, err := gpuFilter.kubeClient.CoreV1().Pods(pod.Namespace).Patch(context.Background(), pod.Name,
+			k8stypes.StrategicMergePatchType, payloadBytes, metav1.PatchOptions{}, "status")
+		if err == nil {
+			return true, nil
+		}
+		if util.ShouldRetry(err) {
+			return false, nil
+		}
+		return false, err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to patch status of pod %s: %v", pod.UID, err)
+	}
+	return nil
+}
+
+// restorePodAnnotations sets the given keys of the pod back to their values
+// in pod, the keys absent from pod are removed
+func (gpuFilter *GPUFilter) restorePodAnnotations(pod *corev1.Pod, annotationMap map[string]string) error {
+	original := make(map[string]interface{}, len(annotationMap))
+	for k := range annotationMap {
+		if v, ok := pod.Annotations[k]; ok {
+			original[k] = v
+		} else {
+			original[k] = nil
+		}
+	}
+	_, err := gpuFilter.patchPod(context.Background(), pod, original)
+	return err
+}
@@ -0,0 +1,352 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package predicate
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	listerv1 "k8s.io/client-go/listers/core/v1"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+
+	"tkestack.io/gpu-admission/pkg/config"
+	"tkestack.io/gpu-admission/pkg/dra"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+func newBindTestFilter(bindErr error) (*GPUFilter, *[]*corev1.Binding) {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "testnode"},
+		Status: corev1.NodeStatus{
+			Capacity: corev1.ResourceList{
+				corev1.ResourceName(util.VCoreAnnotation):   resource.MustParse(fmt.Sprintf("%d", deviceCount*util.HundredCore)),
+				corev1.ResourceName(util.VMemoryAnnotation): resource.MustParse(fmt.Sprintf("%d", totalMemory)),
+			},
+		},
+	}
+	pod := newScoredPod(50)
+	pod.Namespace = namespace
+	k8sClient := fake.NewSimpleClientset(node, pod)
+
+	var bindings []*corev1.Binding
+	k8sClient.PrependReactor("create", "pods",
+		func(action k8stesting.Action) (bool, runtime.Object, error) {
+			if action.GetSubresource() != "binding" {
+				return false, nil, nil
+			}
+			if bindErr != nil {
+				return true, nil, bindErr
+			}
+			binding := action.(k8stesting.CreateAction).GetObject().(*corev1.Binding)
+			bindings = append(bindings, binding)
+			return true, binding, nil
+		})
+
+	return &GPUFilter{
+		kubeClient: k8sClient,
+		nodeLister: listerv1.NewNodeLister(cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})),
+		podLister:  listerv1.NewPodLister(cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})),
+		config:     config.NewStore(config.Default()),
+	}, &bindings
+}
+
+func TestBind(t *testing.T) {
+	gpuFilter, bindings := newBindTestFilter(nil)
+	gpuFilter.nodes = newNodeCache(gpuFilter.config.Load)
+	result := gpuFilter.Bind(extenderv1.ExtenderBindingArgs{
+		PodName:      "pod",
+		PodNamespace: namespace,
+		PodUID:       "uid",
+		Node:         "testnode",
+	})
+	if result.Error != "" {
+		t.Fatalf("failed to bind: %s", result.Error)
+	}
+	if len(*bindings) != 1 || (*bindings)[0].Target.Name != "testnode" {
+		t.Fatalf("expect the pod to be bound to testnode, got %+v", *bindings)
+	}
+
+	pod, err := gpuFilter.kubeClient.CoreV1().Pods(namespace).Get(context.Background(), "pod", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get pod: %v", err)
+	}
+	for _, key := range []string{util.PredicateGPUIndexPrefix + "0", util.PredicateNode,
+		util.GPUAssigned, util.PredicateTimeAnnotation} {
+		if _, ok := pod.Annotations[key]; !ok {
+			t.Fatalf("annotation %s is missing: %v", key, pod.Annotations)
+		}
+	}
+	if pod.Annotations[util.GPUAssigned] != "false" {
+		t.Fatalf("expect %s to be false, got %s", util.GPUAssigned, pod.Annotations[util.GPUAssigned])
+	}
+
+	// the node cache is charged before the informer reports the pod
+	node, err := gpuFilter.kubeClient.CoreV1().Nodes().Get(context.Background(), "testnode", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get node: %v", err)
+	}
+	nodeInfo, _ := gpuFilter.nodes.nodeInfo(node, gpuFilter.config.Load())
+	if free := nodeInfo.GetAvailableCore(); free != deviceCount*util.HundredCore-50 {
+		t.Fatalf("expect the node cache to be charged 50 cores, got %d free", free)
+	}
+}
+
+func TestBindRollback(t *testing.T) {
+	gpuFilter, _ := newBindTestFilter(fmt.Errorf("binding conflict"))
+	result := gpuFilter.Bind(extenderv1.ExtenderBindingArgs{
+		PodName:      "pod",
+		PodNamespace: namespace,
+		PodUID:       "uid",
+		Node:         "testnode",
+	})
+	if result.Error == "" {
+		t.Fatalf("expect the binding to fail")
+	}
+
+	pod, err := gpuFilter.kubeClient.CoreV1().Pods(namespace).Get(context.Background(), "pod", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get pod: %v", err)
+	}
+	for k := range pod.Annotations {
+		if util.IsPredicateAnnotation(k) {
+			t.Fatalf("annotation %s should have been rolled back: %v", k, pod.Annotations)
+		}
+	}
+	if _, ok := pod.Annotations[util.EstimatedTime+"0"]; !ok {
+		t.Fatalf("annotations absent from the patch should be kept: %v", pod.Annotations)
+	}
+}
+
+func TestFilterBindRollback(t *testing.T) {
+	gpuFilter, _ := newBindTestFilter(fmt.Errorf("binding conflict"))
+	gpuFilter.nodes = newNodeCache(gpuFilter.config.Load)
+	node, err := gpuFilter.kubeClient.CoreV1().Nodes().Get(context.Background(), "testnode", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get node: %v", err)
+	}
+	freeCores := func() int {
+		nodeInfo, _ := gpuFilter.nodes.nodeInfo(node, gpuFilter.config.Load())
+		return nodeInfo.GetAvailableCore()
+	}
+	bind := func() {
+		result := gpuFilter.Bind(extenderv1.ExtenderBindingArgs{
+			PodName:      "pod",
+			PodNamespace: namespace,
+			PodUID:       "uid",
+			Node:         "testnode",
+		})
+		if result.Error == "" {
+			t.Fatalf("expect the binding to fail")
+		}
+	}
+	free := freeCores()
+
+	// a failing bind alone leaves nothing charged
+	bind()
+	if got := freeCores(); got != free {
+		t.Fatalf("expect %d cores free after the rollback, got %d", free, got)
+	}
+
+	// the allocation of the filter is kept, and isn't charged twice
+	pod, err := gpuFilter.kubeClient.CoreV1().Pods(namespace).Get(context.Background(), "pod", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get pod: %v", err)
+	}
+	if nodes, _, err := gpuFilter.deviceFilter(context.Background(), pod, []corev1.Node{*node}); err != nil ||
+		len(nodes) != 1 {
+		t.Fatalf("expect the pod to fit, got %v, %v", nodes, err)
+	}
+	filtered := freeCores()
+	if filtered != free-50 {
+		t.Fatalf("expect %d cores free after the filter, got %d", free-50, filtered)
+	}
+	bind()
+	if got := freeCores(); got != filtered {
+		t.Fatalf("expect %d cores free after the rollback, got %d", filtered, got)
+	}
+}
+
+func TestBindResourceClaims(t *testing.T) {
+	gpuFilter, bindings := newBindTestFilter(nil)
+	gpuFilter.nodes = newNodeCache(gpuFilter.config.Load)
+	// the pod requests its GPU by a claim instead of the limits
+	pod, err := gpuFilter.kubeClient.CoreV1().Pods(namespace).Get(context.Background(), "pod", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get pod: %v", err)
+	}
+	pod.Spec.Containers[0].Resources = corev1.ResourceRequirements{}
+	if _, err := gpuFilter.kubeClient.CoreV1().Pods(namespace).Update(context.Background(), pod,
+		metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to update pod: %v", err)
+	}
+	claimedPod := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Pod",
+		"metadata":   map[string]interface{}{"name": "pod", "namespace": namespace, "uid": "uid"},
+		"spec": map[string]interface{}{
+			"resourceClaims": []interface{}{map[string]interface{}{"name": "gpu", "resourceClaimName": "gpu"}},
+			"containers": []interface{}{map[string]interface{}{"name": "c0", "resources": map[string]interface{}{
+				"claims": []interface{}{map[string]interface{}{"name": "gpu"}},
+			}}},
+		},
+	}}
+	claim := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "resource.k8s.io/v1",
+		"kind":       "ResourceClaim",
+		"metadata":   map[string]interface{}{"name": "gpu", "namespace": namespace},
+		"spec": map[string]interface{}{"devices": map[string]interface{}{"config": []interface{}{
+			map[string]interface{}{"opaque": map[string]interface{}{
+				"driver":     "gpu.tencent.com",
+				"parameters": map[string]interface{}{"cores": int64(50), "memory": int64(1)},
+			}},
+		}}},
+	}}
+	gpuFilter.claims = dra.NewResolver(dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), claimedPod, claim),
+		"gpu.tencent.com")
+
+	result := gpuFilter.Bind(extenderv1.ExtenderBindingArgs{
+		PodName:      "pod",
+		PodNamespace: namespace,
+		PodUID:       "uid",
+		Node:         "testnode",
+	})
+	if result.Error != "" {
+		t.Fatalf("failed to bind: %s", result.Error)
+	}
+	if len(*bindings) != 1 {
+		t.Fatalf("expect the pod to be bound, got %+v", *bindings)
+	}
+	node, err := gpuFilter.kubeClient.CoreV1().Nodes().Get(context.Background(), "testnode", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get node: %v", err)
+	}
+	nodeInfo, _ := gpuFilter.nodes.nodeInfo(node, gpuFilter.config.Load())
+	if free := nodeInfo.GetAvailableCore(); free != deviceCount*util.HundredCore-50 {
+		t.Fatalf("expect the node cache to be charged the 50 cores claimed, got %d free", free)
+	}
+}
+
+func TestBindUIDMismatch(t *testing.T) {
+	gpuFilter, bindings := newBindTestFilter(nil)
+	result := gpuFilter.Bind(extenderv1.ExtenderBindingArgs{
+		PodName:      "pod",
+		PodNamespace: namespace,
+		PodUID:       "another-uid",
+		Node:         "testnode",
+	})
+	if result.Error == "" || len(*bindings) != 0 {
+		t.Fatalf("expect a pod with another UID not to be bound")
+	}
+}
+
+func newConditionTestFilter(bindErr error) (*GPUFilter, *[]*corev1.Binding) {
+	gpuFilter, bindings := newBindTestFilter(bindErr)
+	cfg := config.Default()
+	cfg.RecordAllocatedCondition = true
+	gpuFilter.config = config.NewStore(cfg)
+	return gpuFilter, bindings
+}
+
+func allocatedConditionOf(t *testing.T, gpuFilter *GPUFilter) *corev1.PodCondition {
+	pod, err := gpuFilter.kubeClient.CoreV1().Pods(namespace).Get(context.Background(), "pod", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get pod: %v", err)
+	}
+	for i, c := range pod.Status.Conditions {
+		if c.Type == util.GPUAllocatedCondition {
+			return &pod.Status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+func TestBindAllocatedCondition(t *testing.T) {
+	args := extenderv1.ExtenderBindingArgs{PodName: "pod", PodNamespace: namespace, PodUID: "uid", Node: "testnode"}
+
+	// the condition is opt-in
+	gpuFilter, _ := newBindTestFilter(nil)
+	if result := gpuFilter.Bind(args); result.Error != "" {
+		t.Fatalf("failed to bind: %s", result.Error)
+	}
+	if c := allocatedConditionOf(t, gpuFilter); c != nil {
+		t.Fatalf("unexpected condition %+v", c)
+	}
+
+	gpuFilter, bindings := newConditionTestFilter(nil)
+	if result := gpuFilter.Bind(args); result.Error != "" {
+		t.Fatalf("failed to bind: %s", result.Error)
+	}
+	if len(*bindings) != 1 {
+		t.Fatalf("expect the pod to be bound, got %+v", *bindings)
+	}
+	c := allocatedConditionOf(t, gpuFilter)
+	if c == nil {
+		t.Fatalf("condition %s is missing", util.GPUAllocatedCondition)
+	}
+	if c.Status != corev1.ConditionTrue || c.Reason != "Allocated" || c.Message != "node testnode, container c0: GPU 0" ||
+		c.LastTransitionTime.IsZero() {
+		t.Fatalf("unexpected condition %+v", c)
+	}
+}
+
+func TestBindAllocatedConditionRollback(t *testing.T) {
+	args := extenderv1.ExtenderBindingArgs{PodName: "pod", PodNamespace: namespace, PodUID: "uid", Node: "testnode"}
+
+	// a failed binding removes the condition
+	gpuFilter, _ := newConditionTestFilter(fmt.Errorf("binding conflict"))
+	if result := gpuFilter.Bind(args); result.Error == "" {
+		t.Fatalf("expect the binding to fail")
+	}
+	if c := allocatedConditionOf(t, gpuFilter); c != nil {
+		t.Fatalf("condition should have been rolled back: %+v", c)
+	}
+
+	// a failed status update rolls back the annotations and never binds
+	gpuFilter, bindings := newConditionTestFilter(nil)
+	gpuFilter.kubeClient.(*fake.Clientset).PrependReactor("patch", "pods",
+		func(action k8stesting.Action) (bool, runtime.Object, error) {
+			if action.GetSubresource() != "status" {
+				return false, nil, nil
+			}
+			return true, nil, fmt.Errorf("status conflict")
+		})
+	if result := gpuFilter.Bind(args); result.Error == "" {
+		t.Fatalf("expect the status update to fail")
+	}
+	if len(*bindings) != 0 {
+		t.Fatalf("expect the pod not to be bound, got %+v", *bindings)
+	}
+	pod, err := gpuFilter.kubeClient.CoreV1().Pods(namespace).Get(context.Background(), "pod", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get pod: %v", err)
+	}
+	for k := range pod.Annotations {
+		if util.IsPredicateAnnotation(k) {
+			t.Fatalf("annotation %s should have been rolled back: %v", k, pod.Annotations)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package predicate
+
+import (
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"tkestack.io/gpu-admission/pkg/device"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+// NodeInfos returns the GPU state of the node of given name, or of all GPU
+// nodes ordered by name if the name is empty, as the filter sees them with
+// the default policy. The error of an unknown node is the NotFound error of
+// the lister.
+func (gpuFilter *GPUFilter) NodeInfos(name string) ([]*device.NodeInfo, error) {
+	var nodes []*corev1.Node
+	if name != "" {
+		node, err := gpuFilter.nodeLister.Get(name)
+		if err != nil {
+			return nil, err
+		}
+		if !util.IsGPUEnabledNode(node) {
+			return nil, fmt.Errorf("node %s has no GPU device", name)
+		}
+		nodes = append(nodes, node)
+	} else {
+		all, err := gpuFilter.nodeLister.List(labels.Everything())
+		if err != nil {
+			return nil, fmt.Errorf("failed to list nodes: %v", err)
+		}
+		for _, node := range all {
+			if util.IsGPUEnabledNode(node) {
+				nodes = append(nodes, node)
+			}
+		}
+		sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+	}
+
+	cfg := gpuFilter.config.Load()
+	nodeInfos := make([]*device.NodeInfo, 0, len(nodes))
+	for _, node := range nodes {
+		nodeInfo, _, err := gpuFilter.nodeInfoOf(node, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get pods on node %s: %v", node.Name, err)
+		}
+		nodeInfos = append(nodeInfos, nodeInfo)
+	}
+	return nodeInfos, nil
+}
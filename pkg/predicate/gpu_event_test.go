@@ -0,0 +1,64 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package predicate
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestDeviceFilterEvents(t *testing.T) {
+	gpuFilter, _ := newBindTestFilter(nil)
+	recorder := record.NewFakeRecorder(10)
+	gpuFilter.recorder = recorder
+	node, err := gpuFilter.kubeClient.CoreV1().Nodes().Get(context.Background(), "testnode", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get node: %v", err)
+	}
+	pod := newScoredPod(50)
+	pod.Namespace = namespace
+
+	nodes, _, err := gpuFilter.deviceFilter(context.Background(), pod, []corev1.Node{*node})
+	if err != nil || len(nodes) != 1 {
+		t.Fatalf("expect the pod to fit, got %d nodes, err %v", len(nodes), err)
+	}
+	event := <-recorder.Events
+	if !strings.HasPrefix(event, corev1.EventTypeNormal+" "+AllocatedReason) ||
+		!strings.Contains(event, "node testnode") || !strings.Contains(event, "devices ") {
+		t.Fatalf("unexpected event of allocation: %s", event)
+	}
+
+	busyFilter, busyNodes := newBusyFilter(t, 7, 0)
+	recorder = record.NewFakeRecorder(10)
+	busyFilter.recorder = recorder
+	if _, _, err := busyFilter.deviceFilter(context.Background(), pod, busyNodes); err != nil {
+		t.Fatalf("failed to filter: %v", err)
+	}
+	event = <-recorder.Events
+	if !strings.HasPrefix(event, corev1.EventTypeWarning+" "+AllocationFailedReason) ||
+		!strings.Contains(event, "node-0: container") || !strings.Contains(event, "and 2 more nodes") {
+		t.Fatalf("unexpected event of rejection: %s", event)
+	}
+	if len(recorder.Events) != 0 {
+		t.Fatalf("expect only one event of rejection, got %d more", len(recorder.Events))
+	}
+}
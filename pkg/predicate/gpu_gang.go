@@ -0,0 +1,161 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package predicate
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+// GangArgs asks whether a gang of pods, e.g. the replicas of a PyTorchJob
+// or an MPIJob, fits across the candidate nodes as a whole
+type GangArgs struct {
+	// Pod is a member of the gang, the members not placed yet are assumed
+	// to request the same as it
+	Pod *corev1.Pod `json:"pod"`
+	// NodeNames are the candidate nodes, all the nodes known to the filter
+	// if it's empty
+	NodeNames []string `json:"nodeNames,omitempty"`
+	// Gang and Replicas override the annotations of the pod, see
+	// util.GetGangOfPod
+	Gang     string `json:"gang,omitempty"`
+	Replicas int    `json:"replicas,omitempty"`
+}
+
+// GangResult is the advisory hint whether the gang fits, it's not a
+// reservation
+type GangResult struct {
+	Gang     string `json:"gang"`
+	Replicas int    `json:"replicas"`
+	// Placed is the number of the other members already placed on a node
+	Placed int `json:"placed"`
+	// Fits tells if all of the members not placed yet, counting the pod,
+	// fit on the candidate nodes together
+	Fits bool `json:"fits"`
+	// Nodes are the number of the members not placed yet which land on
+	// each node
+	Nodes map[string]int `json:"nodes,omitempty"`
+	// Reason tells why a member doesn't fit
+	Reason string `json:"reason,omitempty"`
+}
+
+// CheckGang places the members of the gang not placed yet on a copy of the
+// candidate nodes one by one, as Simulate does, with the policy of the
+// profile of the pod. Nothing is written to the cluster.
+func (gpuFilter *GPUFilter) CheckGang(ctx context.Context, args GangArgs) (*GangResult, error) {
+	if args.Pod == nil {
+		return nil, fmt.Errorf("no pod given to check the gang")
+	}
+	pod := args.Pod
+	gang, replicas, err := util.GetGangOfPod(pod)
+	if err != nil {
+		return nil, fmt.Errorf("pod %s: %v", pod.Name, err)
+	}
+	if args.Gang != "" {
+		gang = args.Gang
+	}
+	if args.Replicas > 0 {
+		replicas = args.Replicas
+	}
+	if gang == "" || replicas <= 0 {
+		return nil, fmt.Errorf("pod %s is not in a gang", pod.Name)
+	}
+
+	nodes, err := gpuFilter.candidateNodes(args.NodeNames)
+	if err != nil {
+		return nil, err
+	}
+	placed, err := gpuFilter.placedGangMembers(pod, gang)
+	if err != nil {
+		return nil, err
+	}
+	cfg := gpuFilter.configOf(pod)
+	nodeInfos, err := gpuFilter.gpuNodeInfos(nodes, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &GangResult{Gang: gang, Replicas: replicas, Placed: placed, Fits: true}
+	for i := 0; i < replicas-placed; i++ {
+		member := pod.DeepCopy()
+		member.UID = k8stypes.UID(string(pod.UID) + "-" + strconv.Itoa(i))
+		placement, err := placeFirstFit(ctx, nodeInfos, cfg, member)
+		if err != nil {
+			return nil, err
+		}
+		if placement.Node == "" {
+			result.Fits = false
+			result.Reason = fmt.Sprintf("member %d of %d: %s", placed+i+1, replicas, placement.Reason)
+			break
+		}
+		if result.Nodes == nil {
+			result.Nodes = make(map[string]int)
+		}
+		result.Nodes[placement.Node]++
+	}
+	return result, nil
+}
+
+// candidateNodes returns the nodes of given names, or all the nodes known
+// to the filter if there is no name
+func (gpuFilter *GPUFilter) candidateNodes(names []string) ([]*corev1.Node, error) {
+	if len(names) == 0 {
+		nodes, err := gpuFilter.nodeLister.List(labels.Everything())
+		if err != nil {
+			return nil, fmt.Errorf("failed to list nodes: %v", err)
+		}
+		return nodes, nil
+	}
+	nodes := make([]*corev1.Node, 0, len(names))
+	for _, name := range names {
+		node, err := gpuFilter.nodeLister.Get(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get node %s: %v", name, err)
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+// placedGangMembers counts the members of the gang of given pod, other than
+// the pod, which have been placed on a node and not terminated
+func (gpuFilter *GPUFilter) placedGangMembers(pod *corev1.Pod, gang string) (int, error) {
+	pods, err := gpuFilter.podLister.Pods(pod.Namespace).List(labels.Everything())
+	if err != nil {
+		return 0, fmt.Errorf("failed to list pods: %v", err)
+	}
+	var placed int
+	for _, p := range pods {
+		if p.UID == pod.UID || p.Status.Phase == corev1.PodSucceeded || p.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		if name, _, err := util.GetGangOfPod(p); err != nil || name != gang {
+			continue
+		}
+		if p.Spec.NodeName != "" || p.Annotations[util.PredicateNode] != "" {
+			placed++
+		}
+	}
+	return placed, nil
+}
@@ -0,0 +1,97 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package predicate
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	listerv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+func newGangTestPod(name, gang, size string) *corev1.Pod {
+	pod := newScoredPod(util.HundredCore)
+	pod.Name, pod.UID = name, types.UID("uid-"+name)
+	pod.Namespace = namespace
+	pod.Annotations[util.GPUGangNameAnnotation] = gang
+	pod.Annotations[util.GPUGangSizeAnnotation] = size
+	return pod
+}
+
+func TestCheckGang(t *testing.T) {
+	gpuFilter := newSimulateFilter(t)
+	// a member of gang "job" has been bound to node-0
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	pods, err := gpuFilter.podLister.List(labels.Everything())
+	if err != nil {
+		t.Fatalf("failed to list pods: %v", err)
+	}
+	placed := newGangTestPod("job-0", "job", "3")
+	placed.Spec.NodeName = "node-0"
+	for _, pod := range append(pods, placed) {
+		if err := indexer.Add(pod); err != nil {
+			t.Fatalf("failed to add pod: %v", err)
+		}
+	}
+	gpuFilter.podLister = listerv1.NewPodLister(indexer)
+
+	for _, cs := range []struct {
+		name   string
+		args   GangArgs
+		expect GangResult
+	}{
+		{
+			name:   "the other members fit on the free GPUs",
+			args:   GangArgs{Pod: newGangTestPod("job-1", "job", "3")},
+			expect: GangResult{Gang: "job", Replicas: 3, Placed: 1, Fits: true, Nodes: map[string]int{"node-1": 2}},
+		},
+		{
+			name: "more members than the free GPUs",
+			args: GangArgs{Pod: newGangTestPod("job-1", "job", "3"), Replicas: 4},
+			expect: GangResult{Gang: "job", Replicas: 4, Placed: 1, Nodes: map[string]int{"node-1": 2},
+				Reason: "member 4 of 4: node node-0: container c0: insufficient free GPUs, request 1, got 0"},
+		},
+		{
+			name: "only the busy node is a candidate",
+			args: GangArgs{Pod: newGangTestPod("other-0", "other", "1"), NodeNames: []string{"node-0"}},
+			expect: GangResult{Gang: "other", Replicas: 1,
+				Reason: "member 1 of 1: node node-0: container c0: insufficient free GPUs, request 1, got 0"},
+		},
+	} {
+		result, err := gpuFilter.CheckGang(context.Background(), cs.args)
+		if err != nil {
+			t.Fatalf("%s: failed to check gang: %v", cs.name, err)
+		}
+		if !reflect.DeepEqual(*result, cs.expect) {
+			t.Fatalf("%s: expect %+v, got %+v", cs.name, cs.expect, *result)
+		}
+	}
+
+	// a pod not in a gang, or of an invalid gang, is an error
+	for _, pod := range []*corev1.Pod{newScoredPod(50), newGangTestPod("job-1", "job", "x")} {
+		if _, err := gpuFilter.CheckGang(context.Background(), GangArgs{Pod: pod}); err == nil {
+			t.Fatalf("expect an error for pod %s", pod.Name)
+		}
+	}
+}
@@ -20,6 +20,8 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -27,15 +29,26 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/clock"
 	"k8s.io/apimachinery/pkg/util/wait"
 	kubeinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	listerv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog"
 	extenderv1 "k8s.io/kube-scheduler/extender/v1"
 
 	"tkestack.io/gpu-admission/pkg/algorithm"
+	"tkestack.io/gpu-admission/pkg/audit"
+	"tkestack.io/gpu-admission/pkg/config"
 	"tkestack.io/gpu-admission/pkg/device"
+	"tkestack.io/gpu-admission/pkg/dra"
+	"tkestack.io/gpu-admission/pkg/metrics"
+	"tkestack.io/gpu-admission/pkg/state"
 	"tkestack.io/gpu-admission/pkg/util"
 )
 
@@ -43,15 +56,62 @@ type GPUFilter struct {
 	kubeClient kubernetes.Interface
 	nodeLister listerv1.NodeLister
 	podLister  listerv1.PodLister
+	config     *config.Store
+	cache      *filterCache
+	// hasSynced tells whether the informer caches have synced, the filter
+	// is ready once all of them have
+	hasSynced []cache.InformerSynced
+	// recorder records the allocation decisions as events of the pods, nil
+	// records nothing
+	recorder record.EventRecorder
+	// quota caps the GPU usage of the namespaces, nil caps none
+	quota *algorithm.QuotaTracker
+	// timings makes the allocation on each node record the time of its
+	// stages in an annotation of the pod
+	timings bool
+	// audit receives a record of the decision on each pod filtered, nil
+	// records nothing
+	audit audit.Sink
+	// state snapshots the accounting of the nodes evaluated, nil snapshots
+	// nothing
+	state *state.Recorder
+	// stateStore is where the snapshots are saved to
+	stateStore state.Store
+	// freshness tells the nodes whose state is too old to be trusted, nil
+	// trusts all
+	freshness *nodeFreshness
+	// nodes accounts the nodes by the pod events, nil builds the NodeInfo
+	// of a node from the pods listed on each lookup
+	nodes *nodeCache
+	// claims reads the GPU requests of the pods from their resource
+	// claims, nil reads the container limits only
+	claims *dra.Resolver
 }
 
 const (
 	NAME          = "GPUPredicate"
 	PodPhaseField = "status.phase"
 	waitTimeout   = 10 * time.Second
+
+	// component is the source of the events
+	component = "gpu-admission"
+	// event reasons of the allocation decisions
+	AllocatedReason        = "GPUAllocated"
+	AllocationFailedReason = "GPUAllocationFailed"
+	// maxEventNodes is the max number of nodes whose failure reasons are
+	// shown in an event
+	maxEventNodes = 5
+	// filterWorkers is the max number of nodes evaluated concurrently
+	filterWorkers = 16
+	// nodeCacheReconcilePeriod is the interval the node cache is rebuilt
+	// from the pods listed
+	nodeCacheReconcilePeriod = 5 * time.Minute
 )
 
-func NewGPUFilter(client kubernetes.Interface) (*GPUFilter, error) {
+// NewGPUFilter returns a filter watching the nodes and pods by client,
+// claims reads the GPU requests of the pods from their resource claims if
+// not nil
+func NewGPUFilter(client kubernetes.Interface, cfg *config.Config, claims *dra.Resolver) (*GPUFilter, error) {
 	nodeInformerFactory := kubeinformers.NewSharedInformerFactory(client, time.Second*30)
 
 	podListOptions := func(options *metav1.ListOptions) {
@@ -61,28 +121,114 @@ func NewGPUFilter(client kubernetes.Interface) (*GPUFilter, error) {
 		time.Second*30, kubeinformers.WithNamespace(metav1.NamespaceAll),
 		kubeinformers.WithTweakListOptions(podListOptions))
 
+	nodeInformer := nodeInformerFactory.Core().V1().Nodes()
+	podInformer := podInformerFactory.Core().V1().Pods()
 	gpuFilter := &GPUFilter{
 		kubeClient: client,
-		nodeLister: nodeInformerFactory.Core().V1().Nodes().Lister(),
-		podLister:  podInformerFactory.Core().V1().Pods().Lister(),
+		nodeLister: nodeInformer.Lister(),
+		podLister:  podInformer.Lister(),
+		config:     config.NewStore(cfg),
+		cache:      newFilterCache(cfg.FilterCacheSize, cfg.FilterCacheTTL.Duration),
+		hasSynced:  []cache.InformerSynced{nodeInformer.Informer().HasSynced, podInformer.Informer().HasSynced},
+		recorder:   newEventRecorder(client),
+		quota:      algorithm.NewQuotaTracker(cfg.NamespaceQuotas),
+		freshness:  newNodeFreshness(clock.RealClock{}),
+		claims:     claims,
 	}
+	gpuFilter.nodes = newNodeCache(gpuFilter.config.Load)
+	nodeInformer.Informer().AddEventHandler(gpuFilter.freshness.eventHandler())
+	nodeInformer.Informer().AddEventHandler(gpuFilter.nodes.nodeEventHandler())
+	podInformer.Informer().AddEventHandler(gpuFilter.freshness.podEventHandler())
+	podInformer.Informer().AddEventHandler(claims.EventHandler(gpuFilter.nodes.eventHandler()))
+	podInformer.Informer().AddEventHandler(claims.EventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    gpuFilter.trackPod,
+		UpdateFunc: func(_, obj interface{}) { gpuFilter.trackPod(obj) },
+		DeleteFunc: gpuFilter.releasePod,
+	}))
 
 	go nodeInformerFactory.Start(nil)
 	go podInformerFactory.Start(nil)
+	go wait.Until(gpuFilter.reconcileNodes, nodeCacheReconcilePeriod, nil)
 
 	return gpuFilter, nil
 }
 
+// SetAuditSink makes the filter write a record of the decision on each pod
+// to given sink, once the pod is annotated or fits no node, it must be
+// called before serving
+func (gpuFilter *GPUFilter) SetAuditSink(sink audit.Sink) {
+	gpuFilter.audit = sink
+}
+
+// SetAllocationTimings makes the filter record the time spent in each stage
+// of the allocation in the PredicateStageTimings annotation of the pod, it
+// must be called before serving
+func (gpuFilter *GPUFilter) SetAllocationTimings(enabled bool) {
+	gpuFilter.timings = enabled
+}
+
+// SetStateStore makes the filter save the accounting of the nodes to store
+// every interval until stopCh is closed, it must be called before serving.
+// The snapshot saved before restart is restored, whose nodes are charged
+// until the informer caches have synced, so the allocations of the last
+// run aren't booked twice before the pods are seen.
+func (gpuFilter *GPUFilter) SetStateStore(store state.Store, interval time.Duration, stopCh <-chan struct{}) {
+	gpuFilter.state = state.NewRecorder()
+	gpuFilter.stateStore = store
+	if err := gpuFilter.state.Restore(store); err != nil {
+		klog.Errorf("Failed to restore the GPU state: %v", err)
+	}
+	go gpuFilter.state.Run(store, interval, stopCh)
+}
+
+// SaveState saves the snapshot of the accounting to the store given by
+// SetStateStore at once, e.g. before exiting, nothing is saved without one
+func (gpuFilter *GPUFilter) SaveState() error {
+	if gpuFilter.state == nil {
+		return nil
+	}
+	return gpuFilter.stateStore.Save(gpuFilter.state.Snapshot())
+}
+
 func (gpuFilter *GPUFilter) Name() string {
 	return NAME
 }
 
-type filterFunc func(*corev1.Pod, []corev1.Node) ([]corev1.Node, extenderv1.FailedNodesMap,
+// Ready returns true once the informer caches of nodes and pods have synced,
+// so predicates are not served on a partial view of the cluster
+func (gpuFilter *GPUFilter) Ready() bool {
+	for _, synced := range gpuFilter.hasSynced {
+		if !synced() {
+			return false
+		}
+	}
+	return true
+}
+
+// Lead prepares the filter to serve the scheduler as the leader of the
+// replicas, see leader.Elector: the informer caches, whose pod annotations
+// are the accounting of the nodes, are waited for, and the filter results
+// cached before are dropped. It fails if ctx is done before the caches
+// have synced.
+func (gpuFilter *GPUFilter) Lead(ctx context.Context) error {
+	if !cache.WaitForCacheSync(ctx.Done(), gpuFilter.hasSynced...) {
+		return fmt.Errorf("informer caches not synced")
+	}
+	gpuFilter.cache.Purge()
+	return nil
+}
+
+type filterFunc func(context.Context, *corev1.Pod, []corev1.Node) ([]corev1.Node, extenderv1.FailedNodesMap,
 	error)
 
 func (gpuFilter *GPUFilter) Filter(
-	args extenderv1.ExtenderArgs,
+	ctx context.Context, args extenderv1.ExtenderArgs,
 ) *extenderv1.ExtenderFilterResult {
+	pod, err := gpuFilter.claims.Resolve(ctx, args.Pod)
+	if err != nil {
+		return &extenderv1.ExtenderFilterResult{Error: err.Error()}
+	}
+	args.Pod = pod
 	if !util.IsGPURequiredPod(args.Pod) {
 		return &extenderv1.ExtenderFilterResult{
 			Nodes:       args.Nodes,
@@ -97,7 +243,7 @@ func (gpuFilter *GPUFilter) Filter(
 	filteredNodes := args.Nodes.Items
 	failedNodesMap := make(extenderv1.FailedNodesMap)
 	for _, filter := range filters {
-		passedNodes, failedNodes, err := filter(args.Pod, filteredNodes)
+		passedNodes, failedNodes, err := filter(ctx, args.Pod, filteredNodes)
 		if err != nil {
 			return &extenderv1.ExtenderFilterResult{
 				Error: err.Error(),
@@ -120,46 +266,91 @@ func (gpuFilter *GPUFilter) Filter(
 
 //deviceFilter will choose one and only one node fullfil the request,
 //so it should always be the last filter of gpuFilter
-func (gpuFilter *GPUFilter) deviceFilter(
+func (gpuFilter *GPUFilter) deviceFilter(ctx context.Context,
 	pod *corev1.Pod, nodes []corev1.Node) ([]corev1.Node, extenderv1.FailedNodesMap, error) {
 	// #lizard forgives
 	var (
 		filteredNodes  = make([]corev1.Node, 0)
 		failedNodesMap = make(extenderv1.FailedNodesMap)
 		nodeInfoList   []*device.NodeInfo
+		cacheKeys      = make(map[string]filterCacheKey)
 		success        bool
-		sorter         = device.NodeInfoSort(
-			device.ByAllocatableCores,
-			device.ByAllocatableMemory,
-			device.ByID)
+		cfg            = gpuFilter.configOf(pod)
+		sorter         = device.NodeInfoSort(nodeOrder(cfg)...)
 	)
+	// a pod allocated already keeps its decision, evaluating it again
+	// would charge the node twice
+	if allocated := allocatedNodeOf(pod); allocated != "" {
+		for _, node := range nodes {
+			if node.Name == allocated {
+				filteredNodes = append(filteredNodes, node)
+				continue
+			}
+			failedNodesMap[node.Name] = fmt.Sprintf("pod %s has already been allocated on node %s",
+				pod.UID, allocated)
+		}
+		klog.V(4).Infof("pod %s has been allocated on node %s, skip the evaluation", pod.UID, allocated)
+		return filteredNodes, failedNodesMap, nil
+	}
 	for k := range pod.Annotations {
-		if strings.Contains(k, util.GPUAssigned) ||
-			strings.Contains(k, util.PredicateTimeAnnotation) ||
-			strings.Contains(k, util.PredicateGPUIndexPrefix) {
+		if util.IsPredicateAnnotation(k) && !strings.Contains(k, util.PredicateNode) {
 			return filteredNodes, failedNodesMap, fmt.Errorf("pod %s had been predicated!", pod.Name)
 		}
 	}
-
-	for i := range nodes {
-		node := &nodes[i]
-		//筛选出GPU节点
-		if !util.IsGPUEnabledNode(node) {
-			failedNodesMap[node.Name] = "no GPU device"
-			continue
+	// the namespace is charged once the pod is bound, see Bind and trackPod,
+	// so a pod beyond the quota fits no node. It isn't cached, since it's of
+	// the namespace rather than of the nodes.
+	if gpuFilter.quota != nil {
+		if err := gpuFilter.quota.Check(pod); err != nil {
+			reason := algorithm.FailureReason(err)
+			for _, node := range nodes {
+				failedNodesMap[node.Name] = reason
+			}
+			algorithm.RecordRejection(pod, err)
+			gpuFilter.writeAudit(algorithm.AuditRejected(pod, reason))
+			gpuFilter.eventf(pod, corev1.EventTypeWarning, AllocationFailedReason,
+				"no node fits the GPU request: %s", reason)
+			return filteredNodes, failedNodesMap, nil
 		}
-		pods, err := gpuFilter.ListPodsOnNode(node)
-		if err != nil {
-			failedNodesMap[node.Name] = "failed to get pods on node"
+	}
+
+	// The nodes are looked up and evaluated by a bounded pool of workers,
+	// each of them owns the NodeInfo it builds, while the results are
+	// gathered in the order of the nodes so the decision stays the same
+	lookups := make([]nodeLookup, len(nodes))
+	workqueue.ParallelizeUntil(ctx, filterWorkers, len(nodes), func(i int) {
+		lookups[i] = gpuFilter.lookupNode(pod, &nodes[i], cfg)
+	})
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, nil, fmt.Errorf("stopped filtering pod %s: %v", pod.UID, ctxErr)
+	}
+	for i, lookup := range lookups {
+		if lookup.reason != "" {
+			failedNodesMap[nodes[i].Name] = lookup.reason
 			continue
 		}
-		nodeInfo := device.NewNodeInfo(node, pods)
-		nodeInfoList = append(nodeInfoList, nodeInfo)
+		cacheKeys[nodes[i].Name] = lookup.cacheKey
+		nodeInfoList = append(nodeInfoList, lookup.nodeInfo)
 	}
-	//根据各参数对节点进行从小到大的排序
+	//根据各参数对节点进行排序，pack 策略从小到大，spread 策略从大到小
 	sorter.Sort(nodeInfoList)
 
-	for _, nodeInfo := range nodeInfoList {
+	// the nodes are only planned concurrently, the pod is allocated on the
+	// node chosen alone, so it's charged, counted and audited once. The pod
+	// fits a node which has no unmet reason.
+	plans := make([][]error, len(nodeInfoList))
+	workqueue.ParallelizeUntil(ctx, filterWorkers, len(nodeInfoList), func(i int) {
+		plans[i] = algorithm.NewAllocator(nodeInfoList[i]).UnmetReasons(ctx, pod)
+	})
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, nil, fmt.Errorf("stopped filtering pod %s: %v", pod.UID, ctxErr)
+	}
+
+	var (
+		firstErr  error
+		allocated bool
+	)
+	for i, nodeInfo := range nodeInfoList {
 		node := nodeInfo.GetNode()
 		//如果找到一个节点满足条件，且被成功打标记，则跳过后续节点
 		if success {
@@ -168,35 +359,292 @@ func (gpuFilter *GPUFilter) deviceFilter(
 			continue
 		}
 
-		alloc := algorithm.NewAllocator(nodeInfo)
-		newPod, err := alloc.Allocate(pod)
-		if err != nil {
-			failedNodesMap[node.Name] = fmt.Sprintf(
-				"pod %s does not match with this node", pod.UID)
-			continue
-		} else {
-			annotationMap := make(map[string]string)
-			for k, v := range newPod.Annotations {
-				if strings.Contains(k, util.GPUAssigned) ||
-					strings.Contains(k, util.PredicateTimeAnnotation) ||
-					strings.Contains(k, util.PredicateGPUIndexPrefix) ||
-					strings.Contains(k, util.PredicateNode) {
-					annotationMap[k] = v
-				}
+		if unmet := plans[i]; len(unmet) > 0 {
+			err := unmet[0]
+			reason := algorithm.FailureReason(err)
+			gpuFilter.cache.Put(cacheKeys[node.Name], reason)
+			for _, e := range unmet {
+				klog.V(4).Infof("pod %s does not match with node %s: %v", pod.UID, node.Name, e)
 			}
-			err := gpuFilter.patchPodWithAnnotations(newPod, annotationMap)
-			if err != nil {
-				failedNodesMap[node.Name] = "update pod annotation failed"
-				continue
+			failedNodesMap[node.Name] = reason
+			if firstErr == nil {
+				firstErr = err
 			}
-			filteredNodes = append(filteredNodes, *node)
-			success = true
+			continue
+		}
+		allocated = true
+		newPod, nodeInfo, reason := gpuFilter.commitPod(ctx, pod, node, cfg)
+		if reason != "" {
+			failedNodesMap[node.Name] = reason
+			continue
 		}
+		gpuFilter.state.Record(nodeInfo)
+		filteredNodes = append(filteredNodes, *node)
+		success = true
+		gpuFilter.writeAudit(algorithm.AuditAllocated(nodeInfo, newPod))
+		gpuFilter.eventf(pod, corev1.EventTypeNormal, AllocatedReason,
+			"allocated on node %s, devices %s", node.Name, allocatedDevices(newPod))
+	}
+	if !allocated && firstErr != nil {
+		algorithm.RecordRejection(pod, firstErr)
+	}
+	if !success && len(failedNodesMap) > 0 {
+		summary := summarizeFailures(failedNodesMap)
+		gpuFilter.writeAudit(algorithm.AuditRejected(pod, summary))
+		gpuFilter.eventf(pod, corev1.EventTypeWarning, AllocationFailedReason,
+			"no node fits the GPU request: %s", summary)
 	}
 
 	return filteredNodes, failedNodesMap, nil
 }
 
+// commitPod allocates pod on node and patches the pod with the allocation,
+// and returns the pod allocated and the NodeInfo charged for it, or the
+// reason it failed. The NodeInfo planned may be stale once another request
+// has committed on the node, so the node is looked up again and the pod is
+// allocated on it under lockNode, until the node cache is charged for the
+// pod.
+func (gpuFilter *GPUFilter) commitPod(ctx context.Context, pod *corev1.Pod, node *corev1.Node,
+	cfg *config.Config) (*corev1.Pod, *device.NodeInfo, string) {
+	unlock := gpuFilter.nodes.lockNode(node.Name)
+	defer unlock()
+	nodeInfo, err := gpuFilter.currentNodeInfo(node, cfg)
+	if err != nil {
+		return nil, nil, "failed to get pods on node"
+	}
+	newPod, err := algorithm.NewAllocator(nodeInfo).WithTimings(gpuFilter.timings).Allocate(ctx, pod)
+	if err != nil {
+		return nil, nil, algorithm.FailureReason(err)
+	}
+	annotationMap := make(map[string]string)
+	for k, v := range newPod.Annotations {
+		if util.IsPredicateAnnotation(k) {
+			annotationMap[k] = v
+		}
+	}
+	patched, err := gpuFilter.patchPodWithAnnotations(ctx, newPod, annotationMap)
+	if err != nil {
+		return nil, nil, "update pod annotation failed"
+	}
+	// the node is charged for the pod, before the informer reports the pod
+	// annotated. The pod kept is of the version patched, so the informer
+	// reporting an older one doesn't release the charge.
+	newPod.ResourceVersion = patched.ResourceVersion
+	gpuFilter.cache.Invalidate(node.Name)
+	gpuFilter.nodes.updatePod(newPod)
+	return newPod, nodeInfo, ""
+}
+
+// currentNodeInfo returns the NodeInfo of node under cfg as it's now,
+// charged for the restored GPU state as lookupNode does, the caller should
+// hold lockNode of the node
+func (gpuFilter *GPUFilter) currentNodeInfo(node *corev1.Node, cfg *config.Config) (*device.NodeInfo, error) {
+	nodeInfo, _, err := gpuFilter.nodeInfoOf(node, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if !gpuFilter.Ready() {
+		gpuFilter.state.ApplyRestored(nodeInfo)
+	}
+	return nodeInfo, nil
+}
+
+// allocatedNodeOf returns the node given pod has been allocated on, which is
+// the PredicateNode annotation, or the node the pod is bound to once its
+// GPUs are assigned. It's empty if the pod is yet to be allocated.
+func allocatedNodeOf(pod *corev1.Pod) string {
+	if node := pod.Annotations[util.PredicateNode]; node != "" {
+		return node
+	}
+	if pod.Annotations[util.GPUAssigned] == "true" {
+		return pod.Spec.NodeName
+	}
+	return ""
+}
+
+// nodeLookup is the NodeInfo built for a node, or the reason the node is
+// unfit without evaluating the pod
+type nodeLookup struct {
+	nodeInfo *device.NodeInfo
+	cacheKey filterCacheKey
+	reason   string
+}
+
+// lookupNode builds the NodeInfo of given node for pod, it's safe to be
+// called concurrently
+func (gpuFilter *GPUFilter) lookupNode(pod *corev1.Pod, node *corev1.Node, cfg *config.Config) nodeLookup {
+	//筛选出GPU节点
+	if !util.IsGPUEnabledNode(node) {
+		return nodeLookup{reason: "no GPU device"}
+	}
+	if age, stale := gpuFilter.freshness.stale(node.Name, cfg.MaxNodeStateAge.Duration); stale {
+		metrics.RecordStaleNode(node.Name)
+		if age == 0 {
+			return nodeLookup{reason: "GPU state of node is unknown yet, retry later"}
+		}
+		return nodeLookup{reason: fmt.Sprintf("GPU state of node is %s old, retry later", age.Round(time.Second))}
+	}
+	nodeInfo, pods, err := gpuFilter.nodeInfoOf(node, cfg)
+	if err != nil {
+		return nodeLookup{reason: "failed to get pods on node"}
+	}
+	cacheKey := newFilterCacheKey(pod, node, pods)
+	if reason, ok := gpuFilter.cache.Get(cacheKey); ok {
+		return nodeLookup{reason: reason}
+	}
+	// the pods allocated before restart may not be listed yet, the result
+	// on the charged node isn't the one on the pods listed
+	if !gpuFilter.Ready() && gpuFilter.state.ApplyRestored(nodeInfo) {
+		klog.V(4).Infof("node %s is charged for the restored GPU state", node.Name)
+		cacheKey.generation += "/restored"
+	}
+	gpuFilter.state.Record(nodeInfo)
+	metrics.SetNodeFree(node.Name, nodeInfo.FreeCores(), nodeInfo.FreeMemory())
+	metrics.SetNodeFragmentation(node.Name, algorithm.FragmentationScore(nodeInfo))
+	metrics.SetDevices(nodeInfo)
+	return nodeLookup{nodeInfo: nodeInfo, cacheKey: cacheKey}
+}
+
+// newEventRecorder returns a recorder which sends the events to the
+// apiserver
+func newEventRecorder(client kubernetes.Interface) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	return broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: component})
+}
+
+// eventf records an event of the pod if the filter has a recorder
+func (gpuFilter *GPUFilter) eventf(pod *corev1.Pod, eventType, reason, messageFmt string,
+	args ...interface{}) {
+	if gpuFilter.recorder == nil {
+		return
+	}
+	gpuFilter.recorder.Eventf(pod, eventType, reason, messageFmt, args...)
+}
+
+// writeAudit writes the record to the audit sink if the filter has one
+func (gpuFilter *GPUFilter) writeAudit(record audit.Record) {
+	if gpuFilter.audit == nil {
+		return
+	}
+	gpuFilter.audit.Write(record)
+}
+
+// allocatedDevices returns the devices of each container from the predicate
+// annotations, e.g. "c0=0,1 c1=2"
+func allocatedDevices(pod *corev1.Pod) string {
+	var devices []string
+	for i, c := range pod.Spec.Containers {
+		if ids, ok := pod.Annotations[util.PredicateGPUIndexPrefix+strconv.Itoa(i)]; ok {
+			devices = append(devices, c.Name+"="+ids)
+		}
+	}
+	for i, c := range pod.Spec.InitContainers {
+		if ids, ok := pod.Annotations[util.PredicateGPUInitIndexPrefix+strconv.Itoa(i)]; ok {
+			devices = append(devices, c.Name+"="+ids)
+		}
+	}
+	return strings.Join(devices, " ")
+}
+
+// summarizeFailures returns the failure reasons of the first maxEventNodes
+// nodes in name order, so the event stays readable in a large cluster
+func summarizeFailures(failedNodesMap extenderv1.FailedNodesMap) string {
+	names := make([]string, 0, len(failedNodesMap))
+	for name := range failedNodesMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var reasons []string
+	for i, name := range names {
+		if i == maxEventNodes {
+			reasons = append(reasons, fmt.Sprintf("and %d more nodes", len(names)-i))
+			break
+		}
+		reasons = append(reasons, name+": "+failedNodesMap[name])
+	}
+	return strings.Join(reasons, "; ")
+}
+
+// configOf returns the config of the scheduler profile of the pod
+func (gpuFilter *GPUFilter) configOf(pod *corev1.Pod) *config.Config {
+	return gpuFilter.config.Load().ForProfile(pod.Spec.SchedulerName)
+}
+
+// SetConfig replaces the config in use if it's valid, the cached filter
+// results are dropped since they were evaluated under the old config. The
+// size and TTL of the cache are kept until restart. The new namespace
+// quotas apply to the later filters and bindings.
+func (gpuFilter *GPUFilter) SetConfig(cfg *config.Config) error {
+	if err := gpuFilter.config.Swap(cfg); err != nil {
+		return err
+	}
+	gpuFilter.cache.Purge()
+	if gpuFilter.quota != nil {
+		gpuFilter.quota.SetQuotas(cfg.NamespaceQuotas)
+	}
+	return nil
+}
+
+// nodeOrder returns the order in which deviceFilter tries the nodes, the
+// preferred nodes of the node policy come first
+func nodeOrder(cfg *config.Config) []device.LessFunc {
+	if cfg.NodePolicy == config.SpreadPolicy {
+		return []device.LessFunc{
+			device.Reverse(device.ByAllocatableCores),
+			device.Reverse(device.ByAllocatableMemory),
+			device.ByID,
+		}
+	}
+	return []device.LessFunc{
+		device.ByAllocatableCores,
+		device.ByAllocatableMemory,
+		device.ByID,
+	}
+}
+
+// nodeInfoOf returns the NodeInfo of node under cfg, which the caller owns,
+// and the pods on the node. They're of the node cache if there is one.
+func (gpuFilter *GPUFilter) nodeInfoOf(node *corev1.Node, cfg *config.Config) (*device.NodeInfo, []*corev1.Pod, error) {
+	if gpuFilter.nodes != nil {
+		nodeInfo, pods := gpuFilter.nodes.nodeInfo(node, cfg)
+		return nodeInfo, pods, nil
+	}
+	pods, err := gpuFilter.ListPodsOnNode(node)
+	if err != nil {
+		return nil, nil, err
+	}
+	return device.NewNodeInfoWithConfig(node, pods, cfg), pods, nil
+}
+
+// podsOf returns the pods on node, they're of the node cache if there is
+// one
+func (gpuFilter *GPUFilter) podsOf(node *corev1.Node) ([]*corev1.Pod, error) {
+	if gpuFilter.nodes != nil {
+		return gpuFilter.nodes.pods(node.Name), nil
+	}
+	return gpuFilter.ListPodsOnNode(node)
+}
+
+// reconcileNodes rebuilds the node cache from the pods listed once the
+// informer caches have synced, so a missed or misordered event is fixed
+func (gpuFilter *GPUFilter) reconcileNodes() {
+	if gpuFilter.nodes == nil || !gpuFilter.Ready() {
+		return
+	}
+	pods, err := gpuFilter.podLister.Pods(corev1.NamespaceAll).List(labels.Everything())
+	if err != nil {
+		klog.Errorf("Failed to list pods to reconcile the node cache: %v", err)
+		return
+	}
+	for i := range pods {
+		pods[i] = gpuFilter.claims.ResolvePlaced(pods[i])
+	}
+	if drifted := gpuFilter.nodes.reconcile(pods); drifted > 0 {
+		klog.Warningf("Rebuilt the GPU accounting of %d drifted nodes", drifted)
+	}
+}
+
 func (gpuFilter *GPUFilter) ListPodsOnNode(node *corev1.Node) ([]*corev1.Pod, error) {
 	// #lizard forgives
 	pods, err := gpuFilter.podLister.Pods(corev1.NamespaceAll).List(labels.Everything())
@@ -216,46 +664,54 @@ func (gpuFilter *GPUFilter) ListPodsOnNode(node *corev1.Node) ([]*corev1.Pod, er
 		if (pod.Spec.NodeName == node.Name || predicateNode == node.Name) &&
 			pod.Status.Phase != corev1.PodSucceeded &&
 			pod.Status.Phase != corev1.PodFailed {
-			ret = append(ret, pod)
+			ret = append(ret, gpuFilter.claims.ResolvePlaced(pod))
 			klog.V(9).Infof("get pod %s on node %s", pod.UID, node.Name)
 		}
 	}
 	return ret, nil
 }
 
-func (gpuFilter *GPUFilter) patchPodWithAnnotations(
-	pod *corev1.Pod, annotationMap map[string]string) error {
+func (gpuFilter *GPUFilter) patchPodWithAnnotations(ctx context.Context,
+	pod *corev1.Pod, annotationMap map[string]string) (*corev1.Pod, error) {
+	return gpuFilter.patchPod(ctx, pod, annotationMap)
+}
+
+// patchPod patches the annotations of the pod, a nil value removes the key.
+// It returns the pod patched. The patch isn't retried once ctx is done.
+func (gpuFilter *GPUFilter) patchPod(ctx context.Context, pod *corev1.Pod, annotations interface{}) (*corev1.Pod, error) {
 	// update annotations by patching to the pod
 	type patchMetadata struct {
-		Annotations map[string]string `json:"annotations"`
+		Annotations interface{} `json:"annotations"`
 	}
 	type patchPod struct {
 		Metadata patchMetadata `json:"metadata"`
 	}
 	payload := patchPod{
 		Metadata: patchMetadata{
-			Annotations: annotationMap,
+			Annotations: annotations,
 		},
 	}
 
 	payloadBytes, _ := json.Marshal(payload)
+	var patched *corev1.Pod
 	err := wait.PollImmediate(time.Second, waitTimeout, func() (bool, error) {
-		_, err := gpuFilter.kubeClient.CoreV1().Pods(pod.Namespace).
-			Patch(context.Background(), pod.Name, k8stypes.StrategicMergePatchType, payloadBytes, metav1.PatchOptions{})
+		var err error
+		patched, err = gpuFilter.kubeClient.CoreV1().Pods(pod.Namespace).
+			Patch(ctx, pod.Name, k8stypes.StrategicMergePatchType, payloadBytes, metav1.PatchOptions{})
 		if err == nil {
 			return true, nil
 		}
-		if util.ShouldRetry(err) {
+		if util.ShouldRetry(err) && ctx.Err() == nil {
 			return false, nil
 		}
 
 		return false, err
 	})
 	if err != nil {
-		msg := fmt.Sprintf("failed to add annotation %v to pod %s due to %s",
-			annotationMap, pod.UID, err.Error())
+		msg := fmt.Sprintf("failed to patch annotations %v to pod %s due to %s",
+			annotations, pod.UID, err.Error())
 		klog.Infof(msg)
-		return fmt.Errorf(msg)
+		return nil, fmt.Errorf(msg)
 	}
-	return nil
+	return patched, nil
 }
@@ -18,18 +18,29 @@ package predicate
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strconv"
+	"sync"
 	"testing"
 	"time"
 
+	"tkestack.io/gpu-admission/pkg/audit"
+	"tkestack.io/gpu-admission/pkg/config"
+	"tkestack.io/gpu-admission/pkg/metrics"
 	"tkestack.io/gpu-admission/pkg/util"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
 	k8stypes "k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
 )
 
 type podRawInfo struct {
@@ -52,7 +63,7 @@ const (
 
 func TestDeviceFilter(t *testing.T) {
 	k8sClient := fake.NewSimpleClientset()
-	gpuFilter, err := NewGPUFilter(k8sClient)
+	gpuFilter, err := NewGPUFilter(k8sClient, config.Default(), nil)
 	if err != nil {
 		t.Fatalf("failed to create new gpuFilter due to %v", err)
 	}
@@ -65,8 +76,8 @@ func TestDeviceFilter(t *testing.T) {
 			},
 			Status: corev1.NodeStatus{
 				Capacity: corev1.ResourceList{
-					util.VCoreAnnotation:   resource.MustParse(fmt.Sprintf("%d", deviceCount*util.HundredCore)),
-					util.VMemoryAnnotation: resource.MustParse(fmt.Sprintf("%d", totalMemory)),
+					corev1.ResourceName(util.VCoreAnnotation):   resource.MustParse(fmt.Sprintf("%d", deviceCount*util.HundredCore)),
+					corev1.ResourceName(util.VMemoryAnnotation): resource.MustParse(fmt.Sprintf("%d", totalMemory)),
 				},
 			},
 		}
@@ -159,8 +170,8 @@ func TestDeviceFilter(t *testing.T) {
 				Name: c.Name,
 				Resources: corev1.ResourceRequirements{
 					Limits: corev1.ResourceList{
-						util.VCoreAnnotation:   resource.MustParse(fmt.Sprintf("%d", c.Cores)),
-						util.VMemoryAnnotation: resource.MustParse(fmt.Sprintf("%d", c.Memory)),
+						corev1.ResourceName(util.VCoreAnnotation):   resource.MustParse(fmt.Sprintf("%d", c.Cores)),
+						corev1.ResourceName(util.VMemoryAnnotation): resource.MustParse(fmt.Sprintf("%d", c.Memory)),
 					},
 				},
 			}
@@ -170,7 +181,7 @@ func TestDeviceFilter(t *testing.T) {
 			ObjectMeta: metav1.ObjectMeta{
 				Name:        cs.Name,
 				UID:         k8stypes.UID(cs.UID),
-				Annotations: make(map[string]string),
+				Annotations: map[string]string{util.EstimatedTime + "0": "0", util.EstimatedTime + "1": "0"},
 			},
 			Spec: corev1.PodSpec{
 				Containers: containers,
@@ -184,7 +195,7 @@ func TestDeviceFilter(t *testing.T) {
 		// wait for podLister to sync
 		time.Sleep(time.Second * 2)
 
-		nodes, failedNodes, err := gpuFilter.deviceFilter(pod, nodeList)
+		nodes, failedNodes, err := gpuFilter.deviceFilter(context.Background(), pod, nodeList)
 		if err != nil {
 			t.Fatalf("deviceFilter return err: %v", err)
 		}
@@ -206,3 +217,264 @@ func TestDeviceFilter(t *testing.T) {
 	}
 
 }
+
+func TestDeviceFilterCancelled(t *testing.T) {
+	gpuFilter, nodes := newBusyFilter(t, 2, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := gpuFilter.deviceFilter(ctx, newScoredPod(50), nodes); err == nil {
+		t.Fatalf("expect deviceFilter to stop once the context is done")
+	}
+	// the nodes are not even looked up
+	if hits, misses := gpuFilter.cache.Stats(); hits != 0 || misses != 0 {
+		t.Fatalf("expect no lookup, got %d hits and %d misses", hits, misses)
+	}
+	if _, _, err := gpuFilter.deviceFilter(context.Background(), newScoredPod(50), nodes); err != nil {
+		t.Fatalf("deviceFilter failed: %v", err)
+	}
+	if hits, _ := gpuFilter.cache.Stats(); hits != 0 {
+		t.Fatalf("expect no hit after a cancelled filter, got %d", hits)
+	}
+}
+
+func TestDeviceFilterCancelledPatch(t *testing.T) {
+	pod := newScoredPod(50)
+	pod.Namespace = namespace
+	k8sClient := fake.NewSimpleClientset(pod)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	patches := 0
+	// the request is cancelled while the patch conflicts
+	k8sClient.PrependReactor("patch", "pods", func(k8stesting.Action) (bool, runtime.Object, error) {
+		patches++
+		cancel()
+		return true, nil, apierrors.NewConflict(corev1.Resource("pods"), pod.Name, errors.New("conflict"))
+	})
+	store := config.NewStore(config.Default())
+	gpuFilter := &GPUFilter{
+		kubeClient: k8sClient,
+		config:     store,
+		cache:      newFilterCache(0, 0),
+		nodes:      newNodeCache(store.Load),
+	}
+	passed, failedNodes, err := gpuFilter.deviceFilter(ctx, pod, []corev1.Node{*newNodeCacheTestNode()})
+	if err != nil {
+		t.Fatalf("deviceFilter failed: %v", err)
+	}
+	if len(passed) != 0 || failedNodes["testnode"] != "update pod annotation failed" {
+		t.Fatalf("expect the patch failed, got %v, failed nodes %v", passed, failedNodes)
+	}
+	if patches != 1 {
+		t.Fatalf("expect the patch not retried once cancelled, got %d patches", patches)
+	}
+}
+
+func TestDeviceFilterConcurrent(t *testing.T) {
+	cfg := config.Default()
+	store := config.NewStore(cfg)
+	node := newNodeCacheTestNode()
+	var pods []runtime.Object
+	for i := 0; i < 8; i++ {
+		pod := newScoredPod(deviceCount * util.HundredCore)
+		pod.Name, pod.UID, pod.Namespace = fmt.Sprintf("pod-%d", i), k8stypes.UID(fmt.Sprintf("pod-%d", i)), namespace
+		pods = append(pods, pod)
+	}
+	k8sClient := fake.NewSimpleClientset(pods...)
+	// the patch takes a while, in which the other filters plan the node
+	k8sClient.PrependReactor("patch", "pods", func(k8stesting.Action) (bool, runtime.Object, error) {
+		time.Sleep(time.Millisecond)
+		return false, nil, nil
+	})
+	gpuFilter := &GPUFilter{
+		kubeClient: k8sClient,
+		config:     store,
+		cache:      newFilterCache(0, 0),
+		nodes:      newNodeCache(store.Load),
+	}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		winners []string
+		start   = make(chan struct{})
+	)
+	// each of the pods takes the whole node, only one of them fits
+	for _, obj := range pods {
+		wg.Add(1)
+		go func(pod *corev1.Pod) {
+			defer wg.Done()
+			<-start
+			passed, _, err := gpuFilter.deviceFilter(context.Background(), pod, []corev1.Node{*node})
+			if err != nil {
+				t.Errorf("deviceFilter failed: %v", err)
+				return
+			}
+			if len(passed) > 0 {
+				mu.Lock()
+				winners = append(winners, pod.Name)
+				mu.Unlock()
+			}
+		}(obj.(*corev1.Pod))
+	}
+	close(start)
+	wg.Wait()
+	if len(winners) != 1 {
+		t.Fatalf("expect a single pod allocated, got %v", winners)
+	}
+	if got := usedCoresOf(gpuFilter.nodes, node, cfg); fmt.Sprint(got) != "[100 100]" {
+		t.Fatalf("expect the node charged once, got %v", got)
+	}
+}
+
+func TestGPUFilterReady(t *testing.T) {
+	nodesSynced, podsSynced := false, false
+	gpuFilter := &GPUFilter{
+		hasSynced: []cache.InformerSynced{
+			func() bool { return nodesSynced },
+			func() bool { return podsSynced },
+		},
+	}
+	if gpuFilter.Ready() {
+		t.Fatalf("expect not ready before the caches sync")
+	}
+	nodesSynced = true
+	if gpuFilter.Ready() {
+		t.Fatalf("expect not ready before the pod cache syncs")
+	}
+	podsSynced = true
+	if !gpuFilter.Ready() {
+		t.Fatalf("expect ready after the caches sync")
+	}
+}
+
+func TestDeviceFilterAllocatedPod(t *testing.T) {
+	for _, cs := range []struct {
+		name        string
+		annotations map[string]string
+	}{
+		{name: "predicated", annotations: map[string]string{util.PredicateNode: "node-1", util.GPUAssigned: "false"}},
+		{name: "assigned", annotations: map[string]string{util.GPUAssigned: "true"}},
+	} {
+		// every GPU is in use, evaluating the pod again would fail
+		gpuFilter, nodes := newBusyFilter(t, 2, 10)
+		pod := newScoredPod(50)
+		pod.Spec.NodeName = "node-1"
+		pod.Annotations[util.PredicateGPUIndexPrefix+"0"] = "0"
+		for k, v := range cs.annotations {
+			pod.Annotations[k] = v
+		}
+
+		passed, failedNodes, err := gpuFilter.deviceFilter(context.Background(), pod, nodes)
+		if err != nil {
+			t.Fatalf("%s: deviceFilter failed: %v", cs.name, err)
+		}
+		if len(passed) != 1 || passed[0].Name != "node-1" {
+			t.Fatalf("%s: expect node-1 to pass, got %v, failed nodes %v", cs.name, passed, failedNodes)
+		}
+		if reason := failedNodes["node-0"]; reason != "pod uid has already been allocated on node node-1" {
+			t.Fatalf("%s: unexpected reason of node-0 %q", cs.name, reason)
+		}
+		// neither a node is looked up nor the pod patched
+		if hits, misses := gpuFilter.cache.Stats(); hits+misses != 0 {
+			t.Fatalf("%s: expect no node evaluated, got %d hits and %d misses", cs.name, hits, misses)
+		}
+		if actions := gpuFilter.kubeClient.(*fake.Clientset).Actions(); len(actions) != 0 {
+			t.Fatalf("%s: expect no API call, got %v", cs.name, actions)
+		}
+	}
+}
+
+func TestGPUFilterLead(t *testing.T) {
+	synced := false
+	gpuFilter := &GPUFilter{
+		hasSynced: []cache.InformerSynced{func() bool { return synced }},
+		cache:     newFilterCache(10, time.Minute),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := gpuFilter.Lead(ctx); err == nil {
+		t.Fatalf("expect the leader not to be prepared before the caches sync")
+	}
+
+	synced = true
+	gpuFilter.cache.Put(filterCacheKey{node: "node-0"}, "insufficient vcore")
+	if err := gpuFilter.Lead(context.Background()); err != nil {
+		t.Fatalf("failed to lead: %v", err)
+	}
+	if _, ok := gpuFilter.cache.Get(filterCacheKey{node: "node-0"}); ok {
+		t.Fatalf("expect the cached results to be dropped")
+	}
+}
+
+// recordingSink keeps the audit records written
+type recordingSink struct {
+	sync.Mutex
+	records []audit.Record
+}
+
+func (s *recordingSink) Write(record audit.Record) {
+	s.Lock()
+	defer s.Unlock()
+	s.records = append(s.records, record)
+}
+
+func TestDeviceFilterAllocatesChosenNode(t *testing.T) {
+	// both nodes fit the pod, only the first one is charged
+	gpuFilter := newRebalanceFilter(t)
+	gpuFilter.cache = newFilterCache(0, 0)
+	sink := &recordingSink{}
+	gpuFilter.audit = sink
+	pod := newScoredPod(50)
+	pod.Namespace = "chosen-ns"
+	gpuFilter.kubeClient = fake.NewSimpleClientset(pod)
+	nodes, err := gpuFilter.nodeLister.List(labels.Everything())
+	if err != nil {
+		t.Fatalf("failed to list nodes: %v", err)
+	}
+	var items []corev1.Node
+	for _, node := range nodes {
+		items = append(items, *node)
+	}
+	counter := metrics.Allocations.WithLabelValues(metrics.ModeShare, metrics.OutcomeSuccess, "")
+	before := testutil.ToFloat64(counter)
+
+	passed, failedNodes, err := gpuFilter.deviceFilter(context.Background(), pod, items)
+	if err != nil {
+		t.Fatalf("deviceFilter failed: %v", err)
+	}
+	if len(passed) != 1 || len(failedNodes) != 1 {
+		t.Fatalf("expect a node chosen, got %v, failed nodes %v", passed, failedNodes)
+	}
+	if len(sink.records) != 1 || sink.records[0].Node != passed[0].Name {
+		t.Fatalf("expect a record of node %s, got %+v", passed[0].Name, sink.records)
+	}
+	if got := testutil.ToFloat64(counter) - before; got != 1 {
+		t.Fatalf("expect 1 allocation counted, got %v", got)
+	}
+}
+
+func TestDeviceFilterAuditRejected(t *testing.T) {
+	gpuFilter, nodes := newBusyFilter(t, 2, 10)
+	sink := &recordingSink{}
+	gpuFilter.audit = sink
+	pod := newScoredPod(50)
+	// the second round is served from the cache, and recorded as well
+	for i := 0; i < 2; i++ {
+		if _, _, err := gpuFilter.deviceFilter(context.Background(), pod, nodes); err != nil {
+			t.Fatalf("deviceFilter failed: %v", err)
+		}
+	}
+	if hits, _ := gpuFilter.cache.Stats(); hits != 2 {
+		t.Fatalf("expect 2 hits, got %d", hits)
+	}
+	expect := "node-0: container c0: insufficient vcore, request 50, max allocatable 0; " +
+		"node-1: container c0: insufficient vcore, request 50, max allocatable 0"
+	if len(sink.records) != 2 {
+		t.Fatalf("expect a record of each round, got %+v", sink.records)
+	}
+	for _, record := range sink.records {
+		if record.Outcome != audit.OutcomeRejected || record.Node != "" || record.Reason != expect {
+			t.Fatalf("unexpected record %+v", record)
+		}
+	}
+}
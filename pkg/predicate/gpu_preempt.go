@@ -0,0 +1,117 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package predicate
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+
+	"tkestack.io/gpu-admission/pkg/algorithm"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+// ProcessPreemption adds the GPU pods to evict to the victims proposed by
+// the scheduler on each node, so that the pod fits the GPUs of the node once
+// all of them are evicted. Nodes on which the pod doesn't fit even if all
+// lower priority GPU pods are evicted are dropped.
+func (gpuFilter *GPUFilter) ProcessPreemption(
+	args extenderv1.ExtenderPreemptionArgs,
+) (*extenderv1.ExtenderPreemptionResult, error) {
+	if args.Pod == nil {
+		return nil, fmt.Errorf("no pod given to preempt for")
+	}
+	pod, err := gpuFilter.claims.Resolve(context.Background(), args.Pod)
+	if err != nil {
+		return nil, err
+	}
+	args.Pod = pod
+
+	proposed := args.NodeNameToMetaVictims
+	if proposed == nil {
+		proposed = make(map[string]*extenderv1.MetaVictims, len(args.NodeNameToVictims))
+		for nodeName, victims := range args.NodeNameToVictims {
+			metaVictims := &extenderv1.MetaVictims{NumPDBViolations: victims.NumPDBViolations}
+			for _, p := range victims.Pods {
+				metaVictims.Pods = append(metaVictims.Pods, &extenderv1.MetaPod{UID: string(p.UID)})
+			}
+			proposed[nodeName] = metaVictims
+		}
+	}
+
+	result := &extenderv1.ExtenderPreemptionResult{
+		NodeNameToMetaVictims: make(map[string]*extenderv1.MetaVictims, len(proposed)),
+	}
+	for nodeName, victims := range proposed {
+		if !util.IsGPURequiredPod(args.Pod) {
+			result.NodeNameToMetaVictims[nodeName] = victims
+			continue
+		}
+		metaVictims, err := gpuFilter.preemptOnNode(args.Pod, nodeName, victims)
+		if err != nil {
+			klog.Infof("pod %s can't preempt on node %s: %v", args.Pod.UID, nodeName, err)
+			continue
+		}
+		result.NodeNameToMetaVictims[nodeName] = metaVictims
+	}
+	return result, nil
+}
+
+func (gpuFilter *GPUFilter) preemptOnNode(pod *corev1.Pod, nodeName string,
+	victims *extenderv1.MetaVictims) (*extenderv1.MetaVictims, error) {
+	node, err := gpuFilter.kubeClient.CoreV1().Nodes().
+		Get(context.Background(), nodeName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get node: %v", err)
+	}
+	if !util.IsGPUEnabledNode(node) {
+		return nil, fmt.Errorf("no GPU device")
+	}
+	pods, err := gpuFilter.ListPodsOnNode(node)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pods on node: %v", err)
+	}
+
+	evicted := make(map[string]bool)
+	for _, p := range victims.Pods {
+		evicted[p.UID] = true
+	}
+	var rest []*corev1.Pod
+	for _, p := range pods {
+		if !evicted[string(p.UID)] {
+			rest = append(rest, p)
+		}
+	}
+
+	extra, ok := algorithm.SelectVictims(node, rest, pod, gpuFilter.configOf(pod))
+	if !ok {
+		return nil, fmt.Errorf("not enough GPU resources even if all lower priority pods are evicted")
+	}
+	result := &extenderv1.MetaVictims{
+		Pods:             append([]*extenderv1.MetaPod{}, victims.Pods...),
+		NumPDBViolations: victims.NumPDBViolations,
+	}
+	for _, p := range extra {
+		klog.V(4).Infof("pod %s preempts pod %s on node %s", pod.UID, p.UID, nodeName)
+		result.Pods = append(result.Pods, &extenderv1.MetaPod{UID: string(p.UID)})
+	}
+	return result, nil
+}
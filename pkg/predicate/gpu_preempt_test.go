@@ -0,0 +1,68 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package predicate
+
+import (
+	"strconv"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	listerv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+func TestProcessPreemption(t *testing.T) {
+	gpuFilter, _ := newBindTestFilter(nil)
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	var low, high int32 = 0, 10
+	for i, name := range []string{"victim-0", "victim-1"} {
+		victim := newScoredPod(100)
+		victim.Name, victim.UID, victim.Namespace = name, k8stypes.UID(name), namespace
+		victim.Spec.NodeName = "testnode"
+		victim.Spec.Priority = &low
+		victim.Annotations[util.PredicateGPUIndexPrefix+"0"] = strconv.Itoa(i)
+		if err := indexer.Add(victim); err != nil {
+			t.Fatalf("failed to add pod: %v", err)
+		}
+	}
+	gpuFilter.podLister = listerv1.NewPodLister(indexer)
+
+	pod := newScoredPod(100)
+	pod.Name, pod.UID = "preemptor", "preemptor"
+	pod.Spec.Priority = &high
+	result, err := gpuFilter.ProcessPreemption(extenderv1.ExtenderPreemptionArgs{
+		Pod: pod,
+		NodeNameToVictims: map[string]*extenderv1.Victims{
+			"testnode": {Pods: []*corev1.Pod{}, NumPDBViolations: 1},
+			"unknown":  {Pods: []*corev1.Pod{}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to process preemption: %v", err)
+	}
+	if _, ok := result.NodeNameToMetaVictims["unknown"]; ok {
+		t.Fatalf("unknown node should be dropped")
+	}
+	victims := result.NodeNameToMetaVictims["testnode"]
+	if victims == nil || len(victims.Pods) != 1 || victims.NumPDBViolations != 1 {
+		t.Fatalf("expect one victim on testnode, got %+v", victims)
+	}
+}
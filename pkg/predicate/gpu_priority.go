@@ -0,0 +1,73 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package predicate
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"k8s.io/klog"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+
+	"tkestack.io/gpu-admission/pkg/algorithm"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+// Prioritize scores the nodes by their GPU usage under the node policy,
+// see allocator.Score. With a positive FairnessWeight, the scores of a pod
+// whose namespace holds more than its fair share of the cluster are lowered
+// by the part of weight * excess share * the share of the node held by the
+// namespace, so the pod favours the nodes where its namespace holds less.
+func (gpuFilter *GPUFilter) Prioritize(
+	args extenderv1.ExtenderArgs,
+) (*extenderv1.HostPriorityList, error) {
+	if args.Pod == nil || args.Nodes == nil {
+		return nil, fmt.Errorf("no pod or nodes given to prioritize")
+	}
+	pod, err := gpuFilter.claims.Resolve(context.Background(), args.Pod)
+	if err != nil {
+		return nil, err
+	}
+	args.Pod = pod
+
+	result := make(extenderv1.HostPriorityList, 0, len(args.Nodes.Items))
+	cfg := gpuFilter.configOf(args.Pod)
+	var excess float64
+	if cfg.FairnessWeight > 0 && gpuFilter.quota != nil {
+		excess = cfg.FairnessWeight * gpuFilter.quota.ExcessShare(args.Pod.Namespace)
+	}
+	for i := range args.Nodes.Items {
+		node := &args.Nodes.Items[i]
+		var score int64
+		if util.IsGPURequiredPod(args.Pod) && util.IsGPUEnabledNode(node) {
+			nodeInfo, pods, err := gpuFilter.nodeInfoOf(node, cfg)
+			if err != nil {
+				klog.Warningf("failed to get pods on node %s: %v", node.Name, err)
+			} else {
+				score = algorithm.NewAllocator(nodeInfo).Score(args.Pod, cfg.NodePolicy)
+				if excess > 0 {
+					fairness := 1 - excess*algorithm.NamespaceShare(pods, args.Pod.Namespace)
+					score = int64(math.Round(float64(score) * fairness))
+				}
+			}
+		}
+		klog.V(4).Infof("pod %s scores %d on node %s", args.Pod.UID, score, node.Name)
+		result = append(result, extenderv1.HostPriority{Host: node.Name, Score: score})
+	}
+	return &result, nil
+}
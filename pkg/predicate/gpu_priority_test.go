@@ -0,0 +1,154 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package predicate
+
+import (
+	"fmt"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+
+	"tkestack.io/gpu-admission/pkg/algorithm"
+	"tkestack.io/gpu-admission/pkg/config"
+	"tkestack.io/gpu-admission/pkg/device"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+func newScoredNodeInfo(t *testing.T, usedCores uint) *device.NodeInfo {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "testnode"},
+		Status: corev1.NodeStatus{
+			Capacity: corev1.ResourceList{
+				corev1.ResourceName(util.VCoreAnnotation):   resource.MustParse(fmt.Sprintf("%d", deviceCount*util.HundredCore)),
+				corev1.ResourceName(util.VMemoryAnnotation): resource.MustParse(fmt.Sprintf("%d", totalMemory)),
+			},
+		},
+	}
+	nodeInfo := device.NewNodeInfo(node, nil)
+	if usedCores > 0 {
+		if err := nodeInfo.AddUsedResources(0, usedCores, 1, 0); err != nil {
+			t.Fatalf("failed to add used resources: %v", err)
+		}
+	}
+	return nodeInfo
+}
+
+func newScoredPod(cores int) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "pod",
+			UID:         "uid",
+			Annotations: map[string]string{util.EstimatedTime + "0": "0"},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{
+				Name: "c0",
+				Resources: corev1.ResourceRequirements{
+					Limits: corev1.ResourceList{
+						corev1.ResourceName(util.VCoreAnnotation):   resource.MustParse(fmt.Sprintf("%d", cores)),
+						corev1.ResourceName(util.VMemoryAnnotation): resource.MustParse("1"),
+					},
+				},
+			}},
+		},
+	}
+}
+
+func TestScoreNode(t *testing.T) {
+	testCases := []struct {
+		name      string
+		usedCores uint
+		cores     int
+		policy    string
+		expect    int64
+	}{
+		{"pack on an idle node", 0, 10, config.PackPolicy, 0},
+		{"pack on a half used node", 100, 10, config.PackPolicy, 5},
+		{"spread on an idle node", 0, 10, config.SpreadPolicy, 10},
+		{"spread on a half used node", 100, 10, config.SpreadPolicy, 5},
+		{"pod does not fit", 100, 200, config.PackPolicy, 0},
+		{"pod does not fit with spread", 100, 200, config.SpreadPolicy, 0},
+	}
+	for _, tc := range testCases {
+		score := algorithm.NewAllocator(newScoredNodeInfo(t, tc.usedCores)).Score(newScoredPod(tc.cores), tc.policy)
+		if score != tc.expect {
+			t.Fatalf("%s: expect score %d, got %d", tc.name, tc.expect, score)
+		}
+	}
+}
+
+func TestPrioritizeFairness(t *testing.T) {
+	// each namespace holds a half GPU on one of the nodes
+	gpuFilter := newRebalanceFilter(t,
+		rebalanceTestPod{name: "a", namespace: "heavy", node: "node-0", dev: 0, cores: 50},
+		rebalanceTestPod{name: "b", namespace: "light", node: "node-1", dev: 0, cores: 50})
+	cfg := config.Default()
+	cfg.NodePolicy = config.SpreadPolicy
+	gpuFilter.config = config.NewStore(cfg)
+	gpuFilter.quota = algorithm.NewQuotaTracker(nil)
+	for namespace, cores := range map[string]int{"heavy": 80, "light": 20} {
+		pod := newScoredPod(cores)
+		pod.Namespace, pod.UID = namespace, k8stypes.UID("placed-"+namespace)
+		gpuFilter.quota.Track(pod)
+	}
+	nodes, err := gpuFilter.nodeLister.List(labels.Everything())
+	if err != nil {
+		t.Fatalf("failed to list nodes: %v", err)
+	}
+	nodeList := &corev1.NodeList{}
+	for _, node := range nodes {
+		nodeList.Items = append(nodeList.Items, *node)
+	}
+	scoresOf := func(namespace string) map[string]int64 {
+		pod := newScoredPod(50)
+		pod.Namespace = namespace
+		result, err := gpuFilter.Prioritize(extenderv1.ExtenderArgs{Pod: pod, Nodes: nodeList})
+		if err != nil {
+			t.Fatalf("failed to prioritize: %v", err)
+		}
+		scores := make(map[string]int64)
+		for _, host := range *result {
+			scores[host.Host] = host.Score
+		}
+		return scores
+	}
+
+	// the nodes tie without fairness
+	even := scoresOf("heavy")
+	if even["node-0"] == 0 || even["node-0"] != even["node-1"] {
+		t.Fatalf("expect the nodes to tie, got %v", even)
+	}
+	// the heavy namespace is 0.6 beyond its fair share of 0.5, so it
+	// favours the node it holds nothing of
+	cfg.FairnessWeight = 0.5
+	if err := gpuFilter.SetConfig(cfg); err != nil {
+		t.Fatalf("failed to set config: %v", err)
+	}
+	heavy := scoresOf("heavy")
+	if heavy["node-1"] != even["node-1"] || heavy["node-0"] >= heavy["node-1"] {
+		t.Fatalf("expect the heavy namespace to favour node-1, got %v", heavy)
+	}
+	// the light namespace isn't beyond its share
+	if light := scoresOf("light"); light["node-0"] != even["node-0"] || light["node-1"] != even["node-1"] {
+		t.Fatalf("expect no fairness penalty of the light namespace, got %v", light)
+	}
+}
@@ -0,0 +1,86 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package predicate
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	listerv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"tkestack.io/gpu-admission/pkg/config"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+func TestDeviceFilterProfiles(t *testing.T) {
+	spread := config.SpreadPolicy
+	cfg := config.Default()
+	cfg.Profiles = map[string]config.Profile{
+		"inference": {NodePolicy: &spread},
+	}
+
+	var nodes []corev1.Node
+	for i := 0; i < 2; i++ {
+		nodes = append(nodes, corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-" + strconv.Itoa(i)},
+			Status: corev1.NodeStatus{
+				Capacity: corev1.ResourceList{
+					corev1.ResourceName(util.VCoreAnnotation):   resource.MustParse(fmt.Sprintf("%d", deviceCount*util.HundredCore)),
+					corev1.ResourceName(util.VMemoryAnnotation): resource.MustParse(fmt.Sprintf("%d", totalMemory)),
+				},
+			},
+		})
+	}
+	// node-0 is busier than node-1
+	running := newScoredPod(50)
+	running.Name, running.UID, running.Namespace = "running", "running", namespace
+	running.Spec.NodeName = "node-0"
+	running.Annotations[util.PredicateGPUIndexPrefix+"0"] = "0"
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	if err := indexer.Add(running); err != nil {
+		t.Fatalf("failed to add pod: %v", err)
+	}
+
+	for profile, expect := range map[string]string{
+		"training":  "node-0",
+		"inference": "node-1",
+		"":          "node-0",
+	} {
+		pod := newScoredPod(20)
+		pod.Namespace = namespace
+		pod.Spec.SchedulerName = profile
+		gpuFilter := &GPUFilter{
+			kubeClient: fake.NewSimpleClientset(pod),
+			podLister:  listerv1.NewPodLister(indexer),
+			config:     config.NewStore(cfg),
+		}
+		passed, failedNodes, err := gpuFilter.deviceFilter(context.Background(), pod, nodes)
+		if err != nil {
+			t.Fatalf("profile %q: deviceFilter failed: %v", profile, err)
+		}
+		if len(passed) != 1 || passed[0].Name != expect {
+			t.Fatalf("profile %q: expect %s, got %v, failed nodes %v", profile, expect, passed, failedNodes)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package predicate
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+// trackPod charges the namespace quota for a pod bound to a node, so the
+// usage is accounted after restart too, and releases it once the pod is
+// terminated
+func (gpuFilter *GPUFilter) trackPod(obj interface{}) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok || gpuFilter.quota == nil {
+		return
+	}
+	switch {
+	case pod.Status.Phase == corev1.PodSucceeded, pod.Status.Phase == corev1.PodFailed:
+		gpuFilter.quota.Release(pod)
+	case pod.Spec.NodeName != "":
+		gpuFilter.quota.Track(pod)
+	}
+}
+
+// releasePod releases the namespace quota charged for a deleted pod
+func (gpuFilter *GPUFilter) releasePod(obj interface{}) {
+	if gpuFilter.quota == nil {
+		return
+	}
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		klog.Warningf("unexpected object %T of a deleted pod", obj)
+		return
+	}
+	gpuFilter.quota.Release(pod)
+}
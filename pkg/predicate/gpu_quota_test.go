@@ -0,0 +1,143 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package predicate
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+
+	"tkestack.io/gpu-admission/pkg/algorithm"
+	"tkestack.io/gpu-admission/pkg/config"
+)
+
+func TestFilterNamespaceQuota(t *testing.T) {
+	gpuFilter := newSimulateFilter(t)
+	gpuFilter.cache = newFilterCache(10, time.Minute)
+	gpuFilter.quota = algorithm.NewQuotaTracker(map[string]config.Quota{namespace: {Cores: 40}})
+	nodes, err := gpuFilter.nodeLister.List(labels.Everything())
+	if err != nil {
+		t.Fatalf("failed to list nodes: %v", err)
+	}
+	pod := newScoredPod(50)
+	pod.Namespace = namespace
+	other := newScoredPod(50)
+	other.Namespace = "other-ns"
+	gpuFilter.kubeClient = fake.NewSimpleClientset(other)
+
+	args := extenderv1.ExtenderArgs{Pod: pod, Nodes: &corev1.NodeList{}}
+	for _, node := range nodes {
+		args.Nodes.Items = append(args.Nodes.Items, *node)
+	}
+	result := gpuFilter.Filter(context.Background(), args)
+	if len(result.Nodes.Items) != 0 {
+		t.Fatalf("expect no node for the pod exceeding the quota, got %d", len(result.Nodes.Items))
+	}
+	if reason := result.FailedNodes["node-1"]; !strings.Contains(reason, algorithm.ErrQuotaExceeded.Error()) {
+		t.Fatalf("expect the quota exceeded on node-1, got %q", reason)
+	}
+
+	// the same pod of another namespace isn't rejected by the cache, and it
+	// holds no quota until it's bound
+	args.Pod = other
+	result = gpuFilter.Filter(context.Background(), args)
+	if len(result.Nodes.Items) != 1 {
+		t.Fatalf("expect a node for the pod of another namespace, got %v", result.FailedNodes)
+	}
+	if used := gpuFilter.quota.Used(other.Namespace); used.Cores != 0 {
+		t.Fatalf("expect nothing charged before the binding, got %d cores", used.Cores)
+	}
+}
+
+func TestBindNamespaceQuota(t *testing.T) {
+	gpuFilter, bindings := newBindTestFilter(nil)
+	gpuFilter.quota = algorithm.NewQuotaTracker(map[string]config.Quota{namespace: {Cores: 80}})
+	gpuFilter.quota.Track(newQuotaHolder(40))
+	args := extenderv1.ExtenderBindingArgs{PodName: "pod", PodNamespace: namespace, PodUID: "uid", Node: "testnode"}
+
+	if result := gpuFilter.Bind(args); !strings.Contains(result.Error, algorithm.ErrQuotaExceeded.Error()) {
+		t.Fatalf("expect the binding beyond the quota refused, got %q", result.Error)
+	}
+	if len(*bindings) != 0 {
+		t.Fatalf("expect no binding, got %d", len(*bindings))
+	}
+
+	gpuFilter.quota.Release(newQuotaHolder(40))
+	if result := gpuFilter.Bind(args); result.Error != "" {
+		t.Fatalf("bind failed: %s", result.Error)
+	}
+	if used := gpuFilter.quota.Used(namespace); used.Cores != 50 {
+		t.Fatalf("expect the pod bound charged, got %d cores", used.Cores)
+	}
+}
+
+func TestBindRollbackNamespaceQuota(t *testing.T) {
+	gpuFilter, _ := newBindTestFilter(fmt.Errorf("binding refused"))
+	gpuFilter.quota = algorithm.NewQuotaTracker(nil)
+	args := extenderv1.ExtenderBindingArgs{PodName: "pod", PodNamespace: namespace, PodUID: "uid", Node: "testnode"}
+	if result := gpuFilter.Bind(args); result.Error == "" {
+		t.Fatalf("expect the binding to fail")
+	}
+	if used := gpuFilter.quota.Used(namespace); used.Cores != 0 {
+		t.Fatalf("expect the quota released after the failed binding, got %d cores", used.Cores)
+	}
+}
+
+// newQuotaHolder returns another pod of the test namespace using given cores
+func newQuotaHolder(cores int) *corev1.Pod {
+	pod := newScoredPod(cores)
+	pod.Name, pod.UID, pod.Namespace = "holder", "holder", namespace
+	return pod
+}
+
+func TestPodQuotaHandlers(t *testing.T) {
+	gpuFilter := &GPUFilter{quota: algorithm.NewQuotaTracker(nil)}
+	pod := newScoredPod(50)
+	pod.Namespace = namespace
+
+	// a pending pod is charged by the binding, not by the informer
+	gpuFilter.trackPod(pod)
+	if used := gpuFilter.quota.Used(namespace); used.Cores != 0 {
+		t.Fatalf("expect a pending pod not tracked, got %d cores", used.Cores)
+	}
+	pod.Spec.NodeName = "node-1"
+	gpuFilter.trackPod(pod)
+	if used := gpuFilter.quota.Used(namespace); used.Cores != 50 {
+		t.Fatalf("expect a bound pod tracked, got %d cores", used.Cores)
+	}
+
+	terminated := pod.DeepCopy()
+	terminated.Status.Phase = corev1.PodSucceeded
+	gpuFilter.trackPod(terminated)
+	if used := gpuFilter.quota.Used(namespace); used.Cores != 0 {
+		t.Fatalf("expect a terminated pod released, got %d cores", used.Cores)
+	}
+
+	gpuFilter.trackPod(pod)
+	gpuFilter.releasePod(cache.DeletedFinalStateUnknown{Key: namespace + "/pod", Obj: pod})
+	if used := gpuFilter.quota.Used(namespace); used.Cores != 0 {
+		t.Fatalf("expect a deleted pod released, got %d cores", used.Cores)
+	}
+}
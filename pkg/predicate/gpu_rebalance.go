@@ -0,0 +1,462 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package predicate
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog"
+
+	"tkestack.io/gpu-admission/pkg/algorithm"
+	"tkestack.io/gpu-admission/pkg/config"
+	"tkestack.io/gpu-admission/pkg/device"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+// RebalanceArgs bound the consolidation a rebalance recommends
+type RebalanceArgs struct {
+	// NodeNames are the nodes analyzed, all of the GPU nodes by default
+	NodeNames []string `json:"nodeNames,omitempty"`
+	// MaxMoves caps the number of pods moved, 0 means no limit
+	MaxMoves int `json:"maxMoves,omitempty"`
+}
+
+// RebalanceResult are the pod moves which would free whole GPUs, they are
+// recommendations only
+type RebalanceResult struct {
+	// Moves are in the order they should be done
+	Moves []PodMove `json:"moves"`
+	// FreedGPUs are the GPUs the moves empty, as <node>/<idx>
+	FreedGPUs []string `json:"freedGPUs"`
+	// FreeWholeGPUs and Fragmentation are those of the nodes analyzed
+	// before and after the moves, see SimulationResult
+	FreeWholeGPUsBefore int     `json:"freeWholeGPUsBefore"`
+	FreeWholeGPUsAfter  int     `json:"freeWholeGPUsAfter"`
+	FragmentationBefore float64 `json:"fragmentationBefore"`
+	FragmentationAfter  float64 `json:"fragmentationAfter"`
+}
+
+// PodMove is a pod to evict from its GPUs and where it would land again
+type PodMove struct {
+	// Pod is the namespace/name of the pod
+	Pod         string               `json:"pod"`
+	FromNode    string               `json:"fromNode"`
+	FromDevices []int                `json:"fromDevices"`
+	ToNode      string               `json:"toNode"`
+	Containers  []ContainerPlacement `json:"containers"`
+}
+
+// Rebalance recommends the pods to move so that the partly used GPUs are
+// emptied and can be given to exclusive containers, with as few moves as
+// possible. Each partly used GPU is tried in the order of the fewest pods
+// and then the fewest used cores, and it's emptied if all of its pods fit
+// on the other partly used GPUs, as the filter would place them under the
+// config of their scheduler profiles. The free GPUs are kept free, and a
+// pod is moved once at most. Only a copy of the nodes is charged, nothing
+// is written to the cluster, and the pods not bound yet are never moved.
+func (gpuFilter *GPUFilter) Rebalance(ctx context.Context, args RebalanceArgs) (*RebalanceResult, error) {
+	nodes, err := gpuFilter.rebalanceNodes(args.NodeNames)
+	if err != nil {
+		return nil, err
+	}
+	r := newRebalancer(gpuFilter.config.Load())
+	for _, node := range nodes {
+		if !util.IsGPUEnabledNode(node) {
+			continue
+		}
+		pods, err := gpuFilter.podsOf(node)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get pods on node %s: %v", node.Name, err)
+		}
+		r.addNode(node, pods)
+	}
+	return r.plan(ctx, args.MaxMoves)
+}
+
+// rebalanceNodes returns the nodes of given names, or all of the nodes if
+// none is given
+func (gpuFilter *GPUFilter) rebalanceNodes(names []string) ([]*corev1.Node, error) {
+	if len(names) == 0 {
+		nodes, err := gpuFilter.nodeLister.List(labels.Everything())
+		if err != nil {
+			return nil, fmt.Errorf("failed to list nodes: %v", err)
+		}
+		return nodes, nil
+	}
+	nodes := make([]*corev1.Node, 0, len(names))
+	for _, name := range names {
+		node, err := gpuFilter.nodeLister.Get(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get node %s: %v", name, err)
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+// rebalancer plans the moves of a rebalance on a copy of the nodes. Each
+// node is viewed under the base config and under the config of each
+// scheduler profile, since a profile may change what a GPU can take, and
+// all of the views are charged the same pods and reservations.
+type rebalancer struct {
+	// configs are the base config and those of the profiles, the views of
+	// a node are in the same order
+	configs  []*config.Config
+	profiles map[string]int
+	nodes    []*rebalanceNode
+	pods     []*rebalancePod
+	// reserved are the GPUs charged whole so that no pod moves onto them
+	// while planning
+	reserved []reservation
+}
+
+// rebalanceNode is a node of a rebalance under each of the configs
+type rebalanceNode struct {
+	views []*device.NodeInfo
+}
+
+// rebalancePod is a pod charged on a node of a rebalance, charges are
+// those of each view of the node
+type rebalancePod struct {
+	pod     *corev1.Pod
+	node    *rebalanceNode
+	charges []*device.PodCharge
+	moved   bool
+}
+
+// rebalanceDevice is a partly used GPU a rebalance tries to empty, dev is
+// the one of the base view
+type rebalanceDevice struct {
+	node *rebalanceNode
+	dev  *device.DeviceInfo
+	pods []*rebalancePod
+}
+
+// reservation is what a GPU of a view is charged to keep it free
+type reservation struct {
+	node          *device.NodeInfo
+	id            int
+	cores, memory uint
+}
+
+func newRebalancer(cfg *config.Config) *rebalancer {
+	r := &rebalancer{configs: []*config.Config{cfg}, profiles: make(map[string]int, len(cfg.Profiles))}
+	names := make([]string, 0, len(cfg.Profiles))
+	for name := range cfg.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		r.profiles[name] = len(r.configs)
+		r.configs = append(r.configs, cfg.ForProfile(name))
+	}
+	return r
+}
+
+// base returns the view of the node under the base config
+func (n *rebalanceNode) base() *device.NodeInfo {
+	return n.views[0]
+}
+
+// viewOf returns the index of the view a pod is placed on, that of its
+// scheduler profile, see GPUFilter.configOf
+func (r *rebalancer) viewOf(pod *corev1.Pod) int {
+	return r.profiles[pod.Spec.SchedulerName]
+}
+
+// addNode charges copies of the node for each of given pods on it, in the
+// order of their names so that the moves are stable
+func (r *rebalancer) addNode(node *corev1.Node, pods []*corev1.Pod) {
+	n := &rebalanceNode{views: make([]*device.NodeInfo, len(r.configs))}
+	for i, cfg := range r.configs {
+		n.views[i] = device.NewNodeInfoWithConfig(node, nil, cfg)
+	}
+	sort.Slice(pods, func(i, j int) bool {
+		a, b := pods[i], pods[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+	for _, pod := range pods {
+		r.pods = append(r.pods, &rebalancePod{pod: pod, node: n, charges: n.addPod(pod)})
+	}
+	r.nodes = append(r.nodes, n)
+}
+
+// addPod charges each view of the node for the pod
+func (n *rebalanceNode) addPod(pod *corev1.Pod) []*device.PodCharge {
+	charges := make([]*device.PodCharge, len(n.views))
+	for i, view := range n.views {
+		charges[i] = view.AddPod(pod)
+	}
+	return charges
+}
+
+// removePod releases the charges of addPod
+func (n *rebalanceNode) removePod(charges []*device.PodCharge) {
+	for i, view := range n.views {
+		view.RemovePod(charges[i])
+	}
+}
+
+// baseViews returns the base view of each node
+func (r *rebalancer) baseViews() []*device.NodeInfo {
+	views := make([]*device.NodeInfo, len(r.nodes))
+	for i, n := range r.nodes {
+		views[i] = n.base()
+	}
+	return views
+}
+
+// plan empties the candidate GPUs one by one, at most maxMoves pods are
+// moved if it's positive
+func (r *rebalancer) plan(ctx context.Context, maxMoves int) (*RebalanceResult, error) {
+	result := &RebalanceResult{Moves: []PodMove{}, FreedGPUs: []string{}}
+	result.FreeWholeGPUsBefore, result.FragmentationBefore = freeWholeGPUs(r.baseViews())
+
+	// a move only fills the partly used GPUs
+	for _, n := range r.nodes {
+		for _, dev := range n.base().SchedulableDevices() {
+			if isFreeDevice(dev) {
+				r.reserve(n, dev.GetID())
+			}
+		}
+	}
+	for _, candidate := range r.candidates() {
+		if maxMoves > 0 && len(result.Moves)+len(candidate.pods) > maxMoves {
+			continue
+		}
+		moves, err := r.empty(ctx, candidate)
+		if err != nil {
+			return nil, err
+		}
+		if moves == nil {
+			continue
+		}
+		result.Moves = append(result.Moves, moves...)
+		result.FreedGPUs = append(result.FreedGPUs,
+			fmt.Sprintf("%s/%d", candidate.node.base().GetName(), candidate.dev.GetID()))
+	}
+	r.release()
+
+	result.FreeWholeGPUsAfter, result.FragmentationAfter = freeWholeGPUs(r.baseViews())
+	klog.V(4).Infof("rebalance: %+v", result)
+	return result, nil
+}
+
+// candidates returns the partly used GPUs whose pods are all bound, in the
+// order of the fewest pods, the fewest used cores, the node name and the
+// device idx
+func (r *rebalancer) candidates() []*rebalanceDevice {
+	type key struct {
+		node *rebalanceNode
+		id   int
+	}
+	devices := make(map[key]*rebalanceDevice)
+	unmovable := make(map[key]bool)
+	for _, p := range r.pods {
+		for _, id := range p.charges[0].Devices() {
+			k := key{node: p.node, id: id}
+			if p.pod.Spec.NodeName == "" {
+				unmovable[k] = true
+				continue
+			}
+			if devices[k] == nil {
+				devices[k] = &rebalanceDevice{node: p.node, dev: p.node.base().GetDeviceMap()[id]}
+			}
+			devices[k].pods = append(devices[k].pods, p)
+		}
+	}
+
+	var ret []*rebalanceDevice
+	for k, d := range devices {
+		if !unmovable[k] && d.dev != nil && isPartlyUsedDevice(d.dev) {
+			ret = append(ret, d)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		a, b := ret[i], ret[j]
+		switch {
+		case len(a.pods) != len(b.pods):
+			return len(a.pods) < len(b.pods)
+		case a.dev.UsedCores() != b.dev.UsedCores():
+			return a.dev.UsedCores() < b.dev.UsedCores()
+		case a.node.base().GetName() != b.node.base().GetName():
+			return a.node.base().GetName() < b.node.base().GetName()
+		}
+		return a.dev.GetID() < b.dev.GetID()
+	})
+	return ret
+}
+
+// empty moves the pods of the candidate GPU onto the other partly used
+// GPUs and keeps it free. It returns nil and leaves the nodes unchanged if
+// any of the pods doesn't fit, or one of them has been moved already.
+// Each pod is tried on the nodes in the order and under the config of its
+// profile, and then all of the views of the node it lands on are charged
+// for it as it would be annotated.
+func (r *rebalancer) empty(ctx context.Context, candidate *rebalanceDevice) ([]PodMove, error) {
+	for _, p := range candidate.pods {
+		if p.moved {
+			return nil, nil
+		}
+	}
+	snapshots := make(map[*device.NodeInfo]*device.NodeInfo, len(r.nodes)*len(r.configs))
+	viewNodes := make(map[*device.NodeInfo]*rebalanceNode, len(r.nodes)*len(r.configs))
+	for _, n := range r.nodes {
+		for _, view := range n.views {
+			snapshots[view] = view.Clone()
+			viewNodes[view] = n
+		}
+	}
+	reserved := len(r.reserved)
+	rollback := func() {
+		for view, snapshot := range snapshots {
+			view.Restore(snapshot)
+		}
+		r.reserved = r.reserved[:reserved]
+	}
+
+	for _, p := range candidate.pods {
+		p.node.removePod(p.charges)
+	}
+	// a pod moved onto it before is still there
+	if candidate.dev.UsedCores() > 0 || candidate.dev.NumberofContainer() > 0 {
+		rollback()
+		return nil, nil
+	}
+	r.reserve(candidate.node, candidate.dev.GetID())
+
+	moves := make([]PodMove, 0, len(candidate.pods))
+	for _, p := range candidate.pods {
+		pod, view := evictedPod(p.pod), r.viewOf(p.pod)
+		order := make([]*device.NodeInfo, len(r.nodes))
+		for i, n := range r.nodes {
+			order[i] = n.views[view]
+		}
+		device.NodeInfoSort(nodeOrder(r.configs[view])...).Sort(order)
+		var move *PodMove
+		for _, nodeInfo := range order {
+			alloc := algorithm.NewAllocator(nodeInfo)
+			containers, err := alloc.Plan(ctx, pod)
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				rollback()
+				return nil, fmt.Errorf("stopped rebalancing: %v", ctxErr)
+			}
+			if err != nil {
+				continue
+			}
+			viewNodes[nodeInfo].addPod(alloc.Annotate(pod, containers))
+			move = &PodMove{
+				Pod:         p.pod.Namespace + "/" + p.pod.Name,
+				FromNode:    p.node.base().GetName(),
+				FromDevices: p.charges[0].Devices(),
+				ToNode:      nodeInfo.GetName(),
+				Containers:  simulatedContainers(containers),
+			}
+			break
+		}
+		if move == nil {
+			klog.V(4).Infof("rebalance: GPU %d of node %s can't be emptied, pod %s/%s fits nowhere else",
+				candidate.dev.GetID(), candidate.node.base().GetName(), p.pod.Namespace, p.pod.Name)
+			rollback()
+			return nil, nil
+		}
+		moves = append(moves, *move)
+	}
+	for _, p := range candidate.pods {
+		p.moved = true
+	}
+	return moves, nil
+}
+
+// reserve charges the rest of the GPU on each view of the node so that
+// nothing is placed on it
+func (r *rebalancer) reserve(n *rebalanceNode, id int) {
+	for _, view := range n.views {
+		dev, ok := view.GetDeviceMap()[id]
+		if !ok {
+			continue
+		}
+		res := reservation{node: view, id: id, cores: dev.AllocatableCores(), memory: dev.AllocatableMemory()}
+		if err := view.AddUsedResources(res.id, res.cores, res.memory, 0); err != nil {
+			klog.Infof("failed to reserve dev %d of node %s due to %v", res.id, view.GetName(), err)
+			continue
+		}
+		r.reserved = append(r.reserved, res)
+	}
+}
+
+// release releases all of the reservations
+func (r *rebalancer) release() {
+	for i := len(r.reserved) - 1; i >= 0; i-- {
+		res := r.reserved[i]
+		if err := res.node.RemoveUsedResources(res.id, res.cores, res.memory, 0); err != nil {
+			klog.Infof("failed to release dev %d of node %s due to %v", res.id, res.node.GetName(), err)
+		}
+	}
+	r.reserved = nil
+}
+
+// evictedPod is the pod as the scheduler would see it again once evicted,
+// without its node and predicate annotations
+func evictedPod(pod *corev1.Pod) *corev1.Pod {
+	ret := pod.DeepCopy()
+	ret.Spec.NodeName = ""
+	for k := range ret.Annotations {
+		if util.IsPredicateAnnotation(k) {
+			delete(ret.Annotations, k)
+		}
+	}
+	return ret
+}
+
+// isFreeDevice tells if none of the schedulable cores of the GPU is used,
+// see device.NodeInfo.FreeWholeGPUs
+func isFreeDevice(dev *device.DeviceInfo) bool {
+	return !dev.IsMIGEnabled() && dev.SchedulableCores() > 0 && dev.AllocatableCores() == dev.SchedulableCores()
+}
+
+// isPartlyUsedDevice tells if some but not all of the schedulable cores of
+// the GPU are used, see algorithm.FragmentationScore
+func isPartlyUsedDevice(dev *device.DeviceInfo) bool {
+	return dev.IsSchedulable() && !dev.IsMIGEnabled() && dev.AllocatableCores() > 0 &&
+		dev.AllocatableCores() < dev.SchedulableCores()
+}
+
+// freeWholeGPUs returns the number of free GPUs of the nodes, and the part
+// of their free cores on partly used GPUs
+func freeWholeGPUs(nodeInfos []*device.NodeInfo) (int, float64) {
+	var (
+		free, freeCores int
+		fragmented      float64
+	)
+	for _, nodeInfo := range nodeInfos {
+		free += nodeInfo.FreeWholeGPUs()
+		freeCores += nodeInfo.FreeCores()
+		fragmented += algorithm.FragmentationScore(nodeInfo) * float64(nodeInfo.FreeCores())
+	}
+	if freeCores == 0 {
+		return free, 0
+	}
+	return free, fragmented / float64(freeCores)
+}
@@ -0,0 +1,224 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package predicate
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	listerv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"tkestack.io/gpu-admission/pkg/config"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+// rebalanceTestPod is a shared pod of given cores on a GPU of a node, it
+// isn't bound yet if unbound, it's in the test namespace if namespace is
+// empty, and it's of the scheduler profile if any
+type rebalanceTestPod struct {
+	name      string
+	namespace string
+	node      string
+	dev       int
+	cores     int
+	unbound   bool
+	profile   string
+}
+
+// pairProfile is a scheduler profile of at most 2 containers on a GPU
+const pairProfile = "pair"
+
+// newRebalanceFilter returns a filter knowing 2 nodes of 2 GPUs and given
+// pods on them, pairProfile is the only profile
+func newRebalanceFilter(t *testing.T, pods ...rebalanceTestPod) *GPUFilter {
+	nodeIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for i := 0; i < 2; i++ {
+		node := &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-" + strconv.Itoa(i)},
+			Status: corev1.NodeStatus{
+				Capacity: corev1.ResourceList{
+					corev1.ResourceName(util.VCoreAnnotation):   resource.MustParse(fmt.Sprintf("%d", deviceCount*util.HundredCore)),
+					corev1.ResourceName(util.VMemoryAnnotation): resource.MustParse(fmt.Sprintf("%d", totalMemory)),
+				},
+			},
+		}
+		if err := nodeIndexer.Add(node); err != nil {
+			t.Fatalf("failed to add node: %v", err)
+		}
+	}
+	podIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, p := range pods {
+		pod := newScoredPod(p.cores)
+		pod.Name, pod.Namespace, pod.UID = p.name, namespace, k8stypes.UID(p.name)
+		if p.namespace != "" {
+			pod.Namespace = p.namespace
+		}
+		pod.Annotations[util.PredicateGPUIndexPrefix+"0"] = strconv.Itoa(p.dev)
+		if p.profile != "" {
+			pod.Spec.SchedulerName = p.profile
+		}
+		if p.unbound {
+			pod.Annotations[util.PredicateNode] = p.node
+		} else {
+			pod.Spec.NodeName = p.node
+		}
+		if err := podIndexer.Add(pod); err != nil {
+			t.Fatalf("failed to add pod: %v", err)
+		}
+	}
+	cfg, pair := config.Default(), uint(2)
+	cfg.Profiles = map[string]config.Profile{pairProfile: {MaxContainersPerDevice: &pair}}
+	return &GPUFilter{
+		kubeClient: fake.NewSimpleClientset(),
+		nodeLister: listerv1.NewNodeLister(nodeIndexer),
+		podLister:  listerv1.NewPodLister(podIndexer),
+		config:     config.NewStore(cfg),
+	}
+}
+
+func TestRebalance(t *testing.T) {
+	fragmented := []rebalanceTestPod{
+		{name: "a", node: "node-0", dev: 0, cores: 20},
+		{name: "b", node: "node-0", dev: 1, cores: 50},
+		{name: "c", node: "node-0", dev: 1, cores: 30},
+		{name: "d", node: "node-1", dev: 0, cores: 40},
+	}
+	twoMoves := []rebalanceTestPod{
+		{name: "a", node: "node-0", dev: 0, cores: 10},
+		{name: "f", node: "node-0", dev: 0, cores: 10},
+		{name: "b", node: "node-0", dev: 1, cores: 90},
+		{name: "d", node: "node-1", dev: 0, cores: 90},
+	}
+	testCases := []struct {
+		name     string
+		pods     []rebalanceTestPod
+		args     RebalanceArgs
+		moves    []PodMove
+		freed    []string
+		before   int
+		after    int
+		fragment [2]float64
+	}{
+		{
+			// a fills up GPU 1 of node-0, and d fits nowhere else
+			name: "fragmented",
+			pods: fragmented,
+			moves: []PodMove{{Pod: namespace + "/a", FromNode: "node-0", FromDevices: []int{0}, ToNode: "node-0",
+				Containers: []ContainerPlacement{{Name: "c0", Devices: []int{1}}}}},
+			freed:    []string{"node-0/0"},
+			before:   1,
+			after:    2,
+			fragment: [2]float64{160.0 / 260, 60.0 / 260},
+		},
+		{
+			// a is of a profile allowing 2 containers on a GPU, so it
+			// can't join b and c, and goes to d instead
+			name: "profile",
+			pods: append([]rebalanceTestPod{{name: "a", node: "node-0", dev: 0, cores: 20, profile: pairProfile}},
+				fragmented[1:]...),
+			moves: []PodMove{{Pod: namespace + "/a", FromNode: "node-0", FromDevices: []int{0}, ToNode: "node-1",
+				Containers: []ContainerPlacement{{Name: "c0", Devices: []int{0}}}}},
+			freed:    []string{"node-0/0"},
+			before:   1,
+			after:    2,
+			fragment: [2]float64{160.0 / 260, 60.0 / 260},
+		},
+		{
+			// a pod not bound yet keeps its GPU, so d moves there
+			name: "unbound pod",
+			pods: append([]rebalanceTestPod{{name: "e", node: "node-0", dev: 0, cores: 20, unbound: true}},
+				fragmented...),
+			moves: []PodMove{{Pod: namespace + "/d", FromNode: "node-1", FromDevices: []int{0}, ToNode: "node-0",
+				Containers: []ContainerPlacement{{Name: "c0", Devices: []int{0}}}}},
+			freed:    []string{"node-1/0"},
+			before:   1,
+			after:    2,
+			fragment: [2]float64{140.0 / 240, 40.0 / 240},
+		},
+		{
+			// both small pods go, each to the only GPU it fits
+			name: "two moves",
+			pods: twoMoves,
+			moves: []PodMove{
+				{Pod: namespace + "/a", FromNode: "node-0", FromDevices: []int{0}, ToNode: "node-0",
+					Containers: []ContainerPlacement{{Name: "c0", Devices: []int{1}}}},
+				{Pod: namespace + "/f", FromNode: "node-0", FromDevices: []int{0}, ToNode: "node-1",
+					Containers: []ContainerPlacement{{Name: "c0", Devices: []int{0}}}},
+			},
+			freed:    []string{"node-0/0"},
+			before:   1,
+			after:    2,
+			fragment: [2]float64{100.0 / 200, 0},
+		},
+		{
+			name:     "too many moves",
+			pods:     twoMoves,
+			args:     RebalanceArgs{MaxMoves: 1},
+			moves:    []PodMove{},
+			freed:    []string{},
+			before:   1,
+			after:    1,
+			fragment: [2]float64{100.0 / 200, 100.0 / 200},
+		},
+		{
+			name:     "one node",
+			pods:     fragmented,
+			args:     RebalanceArgs{NodeNames: []string{"node-1"}},
+			moves:    []PodMove{},
+			freed:    []string{},
+			before:   1,
+			after:    1,
+			fragment: [2]float64{60.0 / 160, 60.0 / 160},
+		},
+	}
+
+	for _, cs := range testCases {
+		gpuFilter := newRebalanceFilter(t, cs.pods...)
+		result, err := gpuFilter.Rebalance(context.Background(), cs.args)
+		if err != nil {
+			t.Fatalf("%s: rebalance failed: %v", cs.name, err)
+		}
+		if !reflect.DeepEqual(result.Moves, cs.moves) || !reflect.DeepEqual(result.FreedGPUs, cs.freed) {
+			t.Fatalf("%s: expect moves %+v freeing %v, got %+v freeing %v", cs.name,
+				cs.moves, cs.freed, result.Moves, result.FreedGPUs)
+		}
+		if result.FreeWholeGPUsBefore != cs.before || result.FreeWholeGPUsAfter != cs.after ||
+			math.Abs(result.FragmentationBefore-cs.fragment[0]) > 1e-9 ||
+			math.Abs(result.FragmentationAfter-cs.fragment[1]) > 1e-9 {
+			t.Fatalf("%s: unexpected result %+v", cs.name, result)
+		}
+
+		// nothing is changed on the nodes
+		again, err := gpuFilter.Rebalance(context.Background(), cs.args)
+		if err != nil {
+			t.Fatalf("%s: rebalance failed: %v", cs.name, err)
+		}
+		if !reflect.DeepEqual(again, result) {
+			t.Fatalf("%s: the rebalance changed the nodes, got %+v then %+v", cs.name, result, again)
+		}
+	}
+}
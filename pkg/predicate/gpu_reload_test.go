@@ -0,0 +1,83 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package predicate
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"tkestack.io/gpu-admission/pkg/config"
+)
+
+func TestDeviceFilterReloadConfig(t *testing.T) {
+	gpuFilter, _ := newBindTestFilter(nil)
+	node, err := gpuFilter.kubeClient.CoreV1().Nodes().Get(context.Background(), "testnode", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get node: %v", err)
+	}
+	pod := newScoredPod(50)
+	pod.Namespace = namespace
+	fits := func() bool {
+		nodes, _, err := gpuFilter.deviceFilter(context.Background(), pod, []corev1.Node{*node})
+		if err != nil {
+			t.Fatalf("failed to filter: %v", err)
+		}
+		return len(nodes) == 1
+	}
+	if !fits() {
+		t.Fatalf("expect the pod to fit before reload")
+	}
+
+	dir, err := ioutil.TempDir("", "gpu-admission")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.json")
+	load := func() (*config.Config, error) {
+		cfg := config.Default()
+		return cfg, cfg.Load(path)
+	}
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	if err := config.Watch(path, load, gpuFilter.SetConfig, stopCh); err != nil {
+		t.Fatalf("failed to watch: %v", err)
+	}
+
+	// reserving 60 cores of each GPU leaves too few for the pod
+	data := `{"reservedCoresPerDevice": 60}`
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for gpuFilter.configOf(pod).ReservedCoresPerDevice != 60 {
+		if time.Now().After(deadline) {
+			t.Fatalf("config is not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if fits() {
+		t.Fatalf("expect the pod not to fit after reload")
+	}
+}
@@ -0,0 +1,170 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package predicate
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog"
+
+	"tkestack.io/gpu-admission/pkg/algorithm"
+	"tkestack.io/gpu-admission/pkg/config"
+	"tkestack.io/gpu-admission/pkg/device"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+// SimulationArgs are the hypothetical pods of a simulation, they are
+// placed in order
+type SimulationArgs struct {
+	Pods []corev1.Pod `json:"pods"`
+}
+
+// SimulationResult tells where the hypothetical pods would land and the
+// GPUs left afterwards
+type SimulationResult struct {
+	// Fits is the number of pods placed
+	Fits int `json:"fits"`
+	// Placements are the result of each pod, in the order of the pods
+	Placements []PodPlacement `json:"placements"`
+	// FreeCores, FreeMemory and FreeWholeGPUs are left on all the GPU
+	// nodes after the placements, see device.NodeInfo.FreeCores
+	FreeCores     int `json:"freeCores"`
+	FreeMemory    int `json:"freeMemory"`
+	FreeWholeGPUs int `json:"freeWholeGPUs"`
+	// Fragmentation is the part of the free cores on partly used GPUs, in
+	// [0, 1], see algorithm.FragmentationScore
+	Fragmentation float64 `json:"fragmentation"`
+}
+
+// PodPlacement is where a hypothetical pod would land
+type PodPlacement struct {
+	// Pod is the namespace/name of the pod
+	Pod string `json:"pod"`
+	// Node is empty if the pod doesn't fit, the reason tells why
+	Node       string               `json:"node,omitempty"`
+	Containers []ContainerPlacement `json:"containers,omitempty"`
+	Reason     string               `json:"reason,omitempty"`
+}
+
+// ContainerPlacement is the GPU devices a container would get
+type ContainerPlacement struct {
+	Name    string `json:"name"`
+	Init    bool   `json:"init,omitempty"`
+	Devices []int  `json:"devices"`
+	// MIGInstances are the idx of the MIG instances within each device
+	MIGInstances []int `json:"migInstances,omitempty"`
+}
+
+// Simulate places the pods on the nodes known to the filter with the
+// default policy, each of them on the first node which fits in the order of
+// the node policy, as the filter does. The pods placed take up the devices
+// for the later ones. Only a copy of the nodes is charged, nothing is
+// written to the cluster.
+func (gpuFilter *GPUFilter) Simulate(ctx context.Context, args SimulationArgs) (*SimulationResult, error) {
+	nodes, err := gpuFilter.nodeLister.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %v", err)
+	}
+	cfg := gpuFilter.config.Load()
+	nodeInfos, err := gpuFilter.gpuNodeInfos(nodes, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &SimulationResult{Placements: make([]PodPlacement, 0, len(args.Pods))}
+	for i := range args.Pods {
+		placement, err := placeFirstFit(ctx, nodeInfos, cfg, &args.Pods[i])
+		if err != nil {
+			return nil, err
+		}
+		if placement.Node != "" {
+			result.Fits++
+		}
+		result.Placements = append(result.Placements, placement)
+	}
+
+	for _, nodeInfo := range nodeInfos {
+		result.FreeCores += nodeInfo.FreeCores()
+		result.FreeMemory += nodeInfo.FreeMemory()
+	}
+	result.FreeWholeGPUs, result.Fragmentation = freeWholeGPUs(nodeInfos)
+	return result, nil
+}
+
+// gpuNodeInfos builds the NodeInfos of the GPU nodes among given ones
+func (gpuFilter *GPUFilter) gpuNodeInfos(nodes []*corev1.Node, cfg *config.Config) ([]*device.NodeInfo, error) {
+	var nodeInfos []*device.NodeInfo
+	for _, node := range nodes {
+		if !util.IsGPUEnabledNode(node) {
+			continue
+		}
+		nodeInfo, _, err := gpuFilter.nodeInfoOf(node, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get pods on node %s: %v", node.Name, err)
+		}
+		nodeInfos = append(nodeInfos, nodeInfo)
+	}
+	return nodeInfos, nil
+}
+
+// placeFirstFit places the hypothetical pod on the first of the nodes which
+// fits in the order of the node policy, and charges that node for it
+func placeFirstFit(ctx context.Context, nodeInfos []*device.NodeInfo, cfg *config.Config,
+	pod *corev1.Pod) (PodPlacement, error) {
+	placement := PodPlacement{Pod: pod.Namespace + "/" + pod.Name}
+	if !util.IsGPURequiredPod(pod) {
+		placement.Reason = "no GPU request"
+		return placement, nil
+	}
+	// the reason of the first node in the order if none fits
+	placement.Reason = "no GPU node"
+	device.NodeInfoSort(nodeOrder(cfg)...).Sort(nodeInfos)
+	for j, nodeInfo := range nodeInfos {
+		containers, err := algorithm.NewAllocator(nodeInfo).Place(ctx, pod)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return placement, fmt.Errorf("stopped simulating: %v", ctxErr)
+		}
+		if err != nil {
+			if j == 0 {
+				placement.Reason = fmt.Sprintf("node %s: %s", nodeInfo.GetName(), algorithm.FailureReason(err))
+			}
+			continue
+		}
+		placement.Node = nodeInfo.GetName()
+		placement.Containers = simulatedContainers(containers)
+		placement.Reason = ""
+		break
+	}
+	klog.V(4).Infof("simulated pod %s: %+v", placement.Pod, placement)
+	return placement, nil
+}
+
+// simulatedContainers converts the placements of the allocator
+func simulatedContainers(placements []algorithm.ContainerPlacement) []ContainerPlacement {
+	ret := make([]ContainerPlacement, 0, len(placements))
+	for _, p := range placements {
+		c := ContainerPlacement{Name: p.Name, Init: p.Init, Devices: p.Devices}
+		for _, ref := range p.MIGInstances {
+			c.MIGInstances = append(c.MIGInstances, ref.Instance)
+		}
+		ret = append(ret, c)
+	}
+	return ret
+}
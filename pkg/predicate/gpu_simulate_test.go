@@ -0,0 +1,128 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package predicate
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	listerv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"tkestack.io/gpu-admission/pkg/config"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+// newSimulateFilter returns a filter knowing a busy node and a free one
+func newSimulateFilter(t *testing.T) *GPUFilter {
+	busy, nodes := newBusyFilter(t, 2, 0)
+	nodeIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for i := range nodes {
+		if err := nodeIndexer.Add(&nodes[i]); err != nil {
+			t.Fatalf("failed to add node: %v", err)
+		}
+	}
+	// node-1 is free
+	podIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	pods, err := busy.ListPodsOnNode(&nodes[0])
+	if err != nil {
+		t.Fatalf("failed to list pods: %v", err)
+	}
+	for _, pod := range pods {
+		if err := podIndexer.Add(pod); err != nil {
+			t.Fatalf("failed to add pod: %v", err)
+		}
+	}
+	return &GPUFilter{
+		kubeClient: fake.NewSimpleClientset(),
+		nodeLister: listerv1.NewNodeLister(nodeIndexer),
+		podLister:  listerv1.NewPodLister(podIndexer),
+		config:     config.NewStore(config.Default()),
+	}
+}
+
+func newSimulatePods(cores ...int) []corev1.Pod {
+	var pods []corev1.Pod
+	for _, c := range cores {
+		pods = append(pods, *newScoredPod(c))
+	}
+	return pods
+}
+
+func TestSimulate(t *testing.T) {
+	gpuFilter := newSimulateFilter(t)
+
+	for _, cs := range []struct {
+		name          string
+		cores         []int
+		fits          int
+		freeCores     int
+		freeWholeGPUs int
+		fragmentation float64
+	}{
+		{
+			name:          "one shared",
+			cores:         []int{50},
+			fits:          1,
+			freeCores:     150,
+			freeWholeGPUs: 1,
+			fragmentation: 1.0 / 3,
+		},
+		{
+			name:  "more than the free GPUs",
+			cores: []int{util.HundredCore, 50, 50, 2 * util.HundredCore},
+			fits:  3,
+		},
+	} {
+		args := SimulationArgs{Pods: newSimulatePods(cs.cores...)}
+		result, err := gpuFilter.Simulate(context.Background(), args)
+		if err != nil {
+			t.Fatalf("%s: simulate failed: %v", cs.name, err)
+		}
+		if result.Fits != cs.fits || result.FreeCores != cs.freeCores ||
+			result.FreeWholeGPUs != cs.freeWholeGPUs ||
+			math.Abs(result.Fragmentation-cs.fragmentation) > 1e-9 {
+			t.Fatalf("%s: unexpected result %+v", cs.name, result)
+		}
+		for i, placement := range result.Placements {
+			if fits := i < cs.fits; fits != (placement.Node == "node-1") || fits != (placement.Reason == "") {
+				t.Fatalf("%s: unexpected placement of pod %d: %+v", cs.name, i, placement)
+			}
+		}
+
+		// nothing is charged on the nodes
+		again, err := gpuFilter.Simulate(context.Background(), args)
+		if err != nil {
+			t.Fatalf("%s: simulate failed: %v", cs.name, err)
+		}
+		if !reflect.DeepEqual(again, result) {
+			t.Fatalf("%s: the simulation changed the nodes, got %+v then %+v", cs.name, result, again)
+		}
+	}
+}
+
+func TestSimulateCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := newSimulateFilter(t).Simulate(ctx, SimulationArgs{Pods: newSimulatePods(50)}); err == nil {
+		t.Fatalf("expect simulate to stop once the context is done")
+	}
+}
@@ -0,0 +1,115 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package predicate
+
+import (
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/tools/cache"
+)
+
+// nodeFreshness remembers the nodes the informer has seen, and when the
+// informers of the nodes and the pods last delivered an event or a resync,
+// so a node isn't evaluated on the state of a lagging informer. A nil
+// nodeFreshness never tells a node stale.
+type nodeFreshness struct {
+	sync.Mutex
+	clock clock.Clock
+	known map[string]bool
+	// nodesSeen and podsSeen are when the informers were last alive, zero
+	// if they have delivered nothing yet
+	nodesSeen time.Time
+	podsSeen  time.Time
+}
+
+func newNodeFreshness(c clock.Clock) *nodeFreshness {
+	return &nodeFreshness{clock: c, known: make(map[string]bool)}
+}
+
+// eventHandler returns the handler of the node informer, the resyncs which
+// replay the cache tell the informer is alive as well as the changes
+func (f *nodeFreshness) eventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc:    f.touch,
+		UpdateFunc: func(_, newObj interface{}) { f.touch(newObj) },
+		DeleteFunc: f.forget,
+	}
+}
+
+// podEventHandler returns the handler of the pod informer, any of its
+// events or resyncs tells it is alive
+func (f *nodeFreshness) podEventHandler() cache.ResourceEventHandler {
+	seen := func(interface{}) {
+		f.Lock()
+		defer f.Unlock()
+		f.podsSeen = f.clock.Now()
+	}
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc:    seen,
+		UpdateFunc: func(_, newObj interface{}) { seen(newObj) },
+		DeleteFunc: seen,
+	}
+}
+
+func (f *nodeFreshness) touch(obj interface{}) {
+	node, ok := obj.(*corev1.Node)
+	if !ok {
+		return
+	}
+	f.Lock()
+	defer f.Unlock()
+	f.known[node.Name] = true
+	f.nodesSeen = f.clock.Now()
+}
+
+func (f *nodeFreshness) forget(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	node, ok := obj.(*corev1.Node)
+	if !ok {
+		return
+	}
+	f.Lock()
+	defer f.Unlock()
+	delete(f.known, node.Name)
+	f.nodesSeen = f.clock.Now()
+}
+
+// stale tells whether the node was never seen, or an informer has
+// delivered nothing for more than maxAge, the pod informer only once it has
+// delivered anything. It returns the age of the state of a node seen, that
+// of the informer silent the longest, 0 maxAge means no limit.
+func (f *nodeFreshness) stale(node string, maxAge time.Duration) (time.Duration, bool) {
+	if f == nil || maxAge <= 0 {
+		return 0, false
+	}
+	f.Lock()
+	defer f.Unlock()
+	if !f.known[node] {
+		return 0, true
+	}
+	seen := f.nodesSeen
+	if !f.podsSeen.IsZero() && f.podsSeen.Before(seen) {
+		seen = f.podsSeen
+	}
+	age := f.clock.Since(seen)
+	return age, age > maxAge
+}
@@ -0,0 +1,96 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package predicate
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
+
+	"tkestack.io/gpu-admission/pkg/config"
+)
+
+func TestDeviceFilterStaleNode(t *testing.T) {
+	gpuFilter, _ := newBindTestFilter(nil)
+	cfg := config.Default()
+	cfg.MaxNodeStateAge.Duration = time.Minute
+	gpuFilter.config = config.NewStore(cfg)
+	fakeClock := clock.NewFakeClock(time.Now())
+	gpuFilter.freshness = newNodeFreshness(fakeClock)
+	handler := gpuFilter.freshness.eventHandler()
+	podHandler := gpuFilter.freshness.podEventHandler()
+
+	node, err := gpuFilter.kubeClient.CoreV1().Nodes().Get(context.Background(), "testnode", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get node: %v", err)
+	}
+	pod := newScoredPod(50)
+	pod.Namespace = namespace
+	filter := func() string {
+		nodes, failed, err := gpuFilter.deviceFilter(context.Background(), pod, []corev1.Node{*node})
+		if err != nil {
+			t.Fatalf("failed to filter: %v", err)
+		}
+		if len(nodes) == 1 {
+			return ""
+		}
+		return failed[node.Name]
+	}
+
+	if reason := filter(); reason != "GPU state of node is unknown yet, retry later" {
+		t.Fatalf("expect the node never seen to be rejected, got %q", reason)
+	}
+	handler.OnAdd(node)
+	if reason := filter(); reason != "" {
+		t.Fatalf("expect the fresh node to fit, got %q", reason)
+	}
+
+	// the informers have delivered nothing since
+	fakeClock.Step(2 * time.Minute)
+	if reason := filter(); reason != "GPU state of node is 2m0s old, retry later" {
+		t.Fatalf("expect the stale node to be rejected, got %q", reason)
+	}
+	// a resync replays the node unchanged, the informer is alive
+	handler.OnUpdate(node, node)
+	if reason := filter(); reason != "" {
+		t.Fatalf("expect the resynced node to fit, got %q", reason)
+	}
+
+	// the pod informer lags behind the node informer
+	podHandler.OnAdd(pod)
+	fakeClock.Step(2 * time.Minute)
+	handler.OnUpdate(node, node)
+	if reason := filter(); reason != "GPU state of node is 2m0s old, retry later" {
+		t.Fatalf("expect the node to be rejected while the pod informer lags, got %q", reason)
+	}
+	podHandler.OnUpdate(pod, pod)
+	if reason := filter(); reason != "" {
+		t.Fatalf("expect the node to fit once the pods resync, got %q", reason)
+	}
+
+	handler.OnDelete(node)
+	if _, stale := gpuFilter.freshness.stale(node.Name, time.Minute); !stale {
+		t.Fatalf("expect the deleted node to be stale")
+	}
+	if _, stale := gpuFilter.freshness.stale(node.Name, 0); stale {
+		t.Fatalf("expect no limit on the age by 0")
+	}
+}
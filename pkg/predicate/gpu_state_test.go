@@ -0,0 +1,102 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package predicate
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"tkestack.io/gpu-admission/pkg/state"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+func TestDeviceFilterRestoreState(t *testing.T) {
+	// the last run allocated the whole node
+	store := &state.FakeStore{}
+	devices := make([]state.DeviceState, 0, deviceCount)
+	for id := 0; id < deviceCount; id++ {
+		devices = append(devices, state.DeviceState{
+			ID:         id,
+			UsedCores:  util.HundredCore,
+			UsedMemory: totalMemory / deviceCount,
+		})
+	}
+	if err := store.Save(&state.Snapshot{
+		Nodes: []state.NodeState{{Node: "testnode", Devices: devices}},
+	}); err != nil {
+		t.Fatalf("failed to save snapshot: %v", err)
+	}
+
+	gpuFilter, _ := newBindTestFilter(nil)
+	synced := false
+	gpuFilter.hasSynced = []cache.InformerSynced{func() bool { return synced }}
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	gpuFilter.SetStateStore(store, time.Hour, stopCh)
+
+	node, err := gpuFilter.kubeClient.CoreV1().Nodes().Get(context.Background(), "testnode", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get node: %v", err)
+	}
+	pod := newScoredPod(50)
+	pod.Namespace = namespace
+	fits := func() bool {
+		nodes, _, err := gpuFilter.deviceFilter(context.Background(), pod, []corev1.Node{*node})
+		if err != nil {
+			t.Fatalf("failed to filter: %v", err)
+		}
+		return len(nodes) == 1
+	}
+
+	if fits() {
+		t.Fatalf("expect the restored state to charge the node before the caches sync")
+	}
+	synced = true
+	if !fits() {
+		t.Fatalf("expect the pods listed to account the node once the caches sync")
+	}
+
+	// the allocation is recorded for the next snapshot
+	snapshot := gpuFilter.state.Snapshot()
+	if len(snapshot.Nodes) != 1 {
+		t.Fatalf("unexpected snapshot %+v", snapshot)
+	}
+	var used uint
+	for _, dev := range snapshot.Nodes[0].Devices {
+		used += dev.UsedCores
+	}
+	if used != 50 {
+		t.Fatalf("expect 50 cores used in the snapshot, got %d", used)
+	}
+
+	// the snapshot is saved at once on shutdown
+	if err := gpuFilter.SaveState(); err != nil {
+		t.Fatalf("failed to save state: %v", err)
+	}
+	saved, err := store.Load()
+	if err != nil || saved == nil || len(saved.Nodes) != 1 {
+		t.Fatalf("expect the snapshot saved, got %+v, %v", saved, err)
+	}
+	if err := (&GPUFilter{}).SaveState(); err != nil {
+		t.Fatalf("expect nothing saved without a store, got %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package predicate
+
+import (
+	"errors"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"tkestack.io/gpu-admission/pkg/algorithm"
+)
+
+// ValidatePod checks the GPU request and annotations of the pod under the
+// config of its scheduler profile, see algorithm.ValidatePod. The error
+// tells all of the malformed requests, e.g. to reject the pod at admission
+// instead of failing it on every node at scheduling.
+func (gpuFilter *GPUFilter) ValidatePod(pod *corev1.Pod) error {
+	reasons := algorithm.ValidatePod(pod, gpuFilter.configOf(pod))
+	if len(reasons) == 0 {
+		return nil
+	}
+	msgs := make([]string, 0, len(reasons))
+	for _, reason := range reasons {
+		msgs = append(msgs, algorithm.FailureReason(reason))
+	}
+	return errors.New(strings.Join(msgs, "; "))
+}
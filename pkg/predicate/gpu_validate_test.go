@@ -0,0 +1,48 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package predicate
+
+import (
+	"strings"
+	"testing"
+
+	"tkestack.io/gpu-admission/pkg/config"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+func TestValidatePod(t *testing.T) {
+	cfg := config.Default()
+	cfg.SharePolicy = config.ShareRejected
+	gpuFilter := &GPUFilter{config: config.NewStore(cfg)}
+
+	if err := gpuFilter.ValidatePod(newScoredPod(100)); err != nil {
+		t.Fatalf("expect a whole GPU valid, got %v", err)
+	}
+
+	pod := newScoredPod(50)
+	pod.Annotations[util.GPUPinDevicesAnnotation] = "1"
+	pod.Annotations[util.GPUForbidDevicesAnnotation] = "1"
+	err := gpuFilter.ValidatePod(pod)
+	if err == nil {
+		t.Fatalf("expect the shared pod pinned to a forbidden GPU invalid")
+	}
+	for _, reason := range []string{"container c0: GPU sharing disabled", "GPU 1 is both pinned"} {
+		if !strings.Contains(err.Error(), reason) {
+			t.Fatalf("expect %q in %q", reason, err.Error())
+		}
+	}
+}
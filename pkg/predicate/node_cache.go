@@ -0,0 +1,403 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package predicate
+
+import (
+	"strconv"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+
+	"tkestack.io/gpu-admission/pkg/config"
+	"tkestack.io/gpu-admission/pkg/device"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+// nodeCache keeps the pods on each node and the NodeInfo accounting them,
+// which is credited and debited as the pod informer reports the pods come
+// and go, so a lookup clones the NodeInfo instead of listing all the pods
+// of the cluster. The NodeInfo is built again once the node changes, and
+// one under a config other than the base one, e.g. of a scheduler profile,
+// is built from the pods kept without being cached. reconcile rebuilds all
+// of them from the full list of pods to fix any drift. An event of a pod
+// older than the one kept, by ResourceVersion, is ignored, e.g. a lagging
+// informer reporting a pod before it's annotated by the filter.
+//
+// The NodeInfo looked up is a clone, so its lock guards nothing between two
+// requests: an allocation is committed under lockNode of the node, from
+// looking the NodeInfo up again to keeping the pod allocated, see
+// lockNode.
+type nodeCache struct {
+	sync.Mutex
+	nodes map[string]*nodeEntry
+	// podNodes are the nodes the pods are kept on
+	podNodes map[types.UID]string
+	// versions are the ResourceVersions of the latest pods seen
+	versions map[types.UID]string
+	// base returns the config the cached NodeInfos are built under
+	base func() *config.Config
+}
+
+// nodeEntry is the pods on a node and the NodeInfo of them, nil until it's
+// looked up
+type nodeEntry struct {
+	sync.Mutex
+	pods map[types.UID]*cachedPod
+	// node and cfg are what info is built of
+	node *corev1.Node
+	cfg  *config.Config
+	info *device.NodeInfo
+	// warned is the ResourceVersion of the node whose inconsistent memory
+	// blocks have been logged
+	warned string
+	// commit serializes the allocations committed on the node, it's held
+	// apart from the mutex so the pod events go on meanwhile
+	commit sync.Mutex
+}
+
+// cachedPod is a pod and its charge on the NodeInfo
+type cachedPod struct {
+	pod    *corev1.Pod
+	charge *device.PodCharge
+}
+
+func newNodeCache(base func() *config.Config) *nodeCache {
+	return &nodeCache{
+		nodes:    make(map[string]*nodeEntry),
+		podNodes: make(map[types.UID]string),
+		versions: make(map[types.UID]string),
+		base:     base,
+	}
+}
+
+// podNodeOf returns the node the pod takes GPUs of, the same as
+// ListPodsOnNode, or empty if it takes none
+func podNodeOf(pod *corev1.Pod) string {
+	if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		return ""
+	}
+	if pod.Spec.NodeName != "" {
+		return pod.Spec.NodeName
+	}
+	return pod.Annotations[util.PredicateNode]
+}
+
+// entry returns the entry of the node, which is created if absent. The
+// cache must be locked.
+func (c *nodeCache) entry(name string) *nodeEntry {
+	e, ok := c.nodes[name]
+	if !ok {
+		e = &nodeEntry{pods: make(map[types.UID]*cachedPod)}
+		c.nodes[name] = e
+	}
+	return e
+}
+
+// lockNode locks the commit of an allocation on the node and returns the
+// unlock. The caller looks the NodeInfo up under it, allocates the pod and
+// keeps the pod allocated by updatePod before unlocking, so two requests
+// never allocate against the same state of the node and book a device
+// twice. A nil cache locks nothing.
+func (c *nodeCache) lockNode(name string) func() {
+	if c == nil {
+		return func() {}
+	}
+	c.Lock()
+	e := c.entry(name)
+	c.Unlock()
+
+	e.commit.Lock()
+	return e.commit.Unlock
+}
+
+// isOlder tells whether ResourceVersion a is older than b. The versions are
+// opaque to the clients, but they're the revisions of etcd in practice; a
+// version which isn't one is never older.
+func isOlder(a, b string) bool {
+	x, err := strconv.ParseUint(a, 10, 64)
+	if err != nil {
+		return false
+	}
+	y, err := strconv.ParseUint(b, 10, 64)
+	if err != nil {
+		return false
+	}
+	return x < y
+}
+
+// updatePod keeps the latest pod on its node, the charge of the previous
+// one is released. A pod older than the one seen is ignored. A nil cache
+// keeps nothing.
+func (c *nodeCache) updatePod(pod *corev1.Pod) {
+	if c == nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	if seen := c.versions[pod.UID]; isOlder(pod.ResourceVersion, seen) {
+		klog.V(4).Infof("ignore pod %s of version %s, %s has been seen", pod.UID, pod.ResourceVersion, seen)
+		return
+	}
+	c.versions[pod.UID] = pod.ResourceVersion
+	name := podNodeOf(pod)
+	if old, ok := c.podNodes[pod.UID]; ok && old != name {
+		c.entry(old).remove(pod.UID)
+		delete(c.podNodes, pod.UID)
+	}
+	if name == "" {
+		return
+	}
+	c.podNodes[pod.UID] = name
+	c.entry(name).add(pod)
+}
+
+// restorePod keeps the pod as it was before being patched, whatever the
+// version of the one patched, e.g. once the patch is rolled back. A nil
+// cache keeps nothing.
+func (c *nodeCache) restorePod(pod *corev1.Pod) {
+	if c == nil {
+		return
+	}
+	c.Lock()
+	delete(c.versions, pod.UID)
+	c.Unlock()
+	c.updatePod(pod)
+}
+
+// deletePod releases the charge of the deleted pod
+func (c *nodeCache) deletePod(pod *corev1.Pod) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.versions, pod.UID)
+	if name, ok := c.podNodes[pod.UID]; ok {
+		c.entry(name).remove(pod.UID)
+		delete(c.podNodes, pod.UID)
+	}
+}
+
+// deleteNode drops the NodeInfo of the deleted node, the pods on it are
+// kept until they're deleted as well
+func (c *nodeCache) deleteNode(name string) {
+	c.Lock()
+	defer c.Unlock()
+	if e, ok := c.nodes[name]; ok {
+		e.Lock()
+		e.node, e.cfg, e.info = nil, nil, nil
+		for _, p := range e.pods {
+			p.charge = nil
+		}
+		e.Unlock()
+	}
+}
+
+// nodeInfo returns a NodeInfo of the node under cfg which the caller owns,
+// and the pods on the node
+func (c *nodeCache) nodeInfo(node *corev1.Node, cfg *config.Config) (*device.NodeInfo, []*corev1.Pod) {
+	c.Lock()
+	e := c.entry(node.Name)
+	c.Unlock()
+
+	e.Lock()
+	defer e.Unlock()
+	pods := e.podList()
+	if cfg != c.base() {
+		return device.NewNodeInfoWithConfig(node, pods, cfg), pods
+	}
+	if e.info == nil || e.cfg != cfg || e.node.ResourceVersion != node.ResourceVersion {
+		e.build(node, cfg)
+	}
+	return e.info.Clone(), pods
+}
+
+// pods returns the pods on the node, no NodeInfo is built
+func (c *nodeCache) pods(name string) []*corev1.Pod {
+	c.Lock()
+	e := c.entry(name)
+	c.Unlock()
+
+	e.Lock()
+	defer e.Unlock()
+	return e.podList()
+}
+
+// reconcile rebuilds the pods on each node and their NodeInfos from given
+// pods, which are all the pods listed, and returns the number of nodes
+// whose accounting has drifted. A pod kept which is newer than the one
+// listed is kept instead.
+func (c *nodeCache) reconcile(pods []*corev1.Pod) int {
+	c.Lock()
+	defer c.Unlock()
+
+	kept := make(map[types.UID]*corev1.Pod)
+	for _, e := range c.nodes {
+		e.Lock()
+		for uid, p := range e.pods {
+			kept[uid] = p.pod
+		}
+		e.Unlock()
+	}
+	podsOfNode := make(map[string][]*corev1.Pod)
+	c.versions = make(map[types.UID]string, len(pods))
+	for _, pod := range pods {
+		if k, ok := kept[pod.UID]; ok && isOlder(pod.ResourceVersion, k.ResourceVersion) {
+			pod = k
+		}
+		c.versions[pod.UID] = pod.ResourceVersion
+		if name := podNodeOf(pod); name != "" {
+			podsOfNode[name] = append(podsOfNode[name], pod)
+		}
+	}
+	c.podNodes = make(map[types.UID]string, len(pods))
+	for name := range podsOfNode {
+		c.entry(name)
+	}
+	drifted := 0
+	for name, e := range c.nodes {
+		e.Lock()
+		e.pods = make(map[types.UID]*cachedPod, len(podsOfNode[name]))
+		for _, pod := range podsOfNode[name] {
+			e.pods[pod.UID] = &cachedPod{pod: pod}
+			c.podNodes[pod.UID] = name
+		}
+		if e.info != nil {
+			old := e.info
+			e.build(e.node, e.cfg)
+			if !sameUsage(old, e.info) {
+				klog.Warningf("GPU accounting of node %s has drifted, rebuilt from the pods listed", name)
+				drifted++
+			}
+		}
+		e.Unlock()
+	}
+	return drifted
+}
+
+// add credits the NodeInfo for the pod, the previous one of the same pod is
+// debited
+func (e *nodeEntry) add(pod *corev1.Pod) {
+	e.Lock()
+	defer e.Unlock()
+	e.release(pod.UID)
+	p := &cachedPod{pod: pod}
+	if e.info != nil {
+		p.charge = e.info.AddPod(pod)
+	}
+	e.pods[pod.UID] = p
+}
+
+// remove debits the NodeInfo for the pod and forgets it
+func (e *nodeEntry) remove(uid types.UID) {
+	e.Lock()
+	defer e.Unlock()
+	e.release(uid)
+	delete(e.pods, uid)
+}
+
+func (e *nodeEntry) release(uid types.UID) {
+	if p, ok := e.pods[uid]; ok && e.info != nil {
+		e.info.RemovePod(p.charge)
+	}
+}
+
+// build builds the NodeInfo from the pods kept, each of them is charged.
+// The inconsistent memory blocks of the node, and the devices which can't
+// take a shared container, are logged once for each version of it and of
+// the config.
+func (e *nodeEntry) build(node *corev1.Node, cfg *config.Config) {
+	if cfg != e.cfg {
+		e.warned = ""
+	}
+	e.node, e.cfg = node, cfg
+	e.info = device.NewNodeInfoWithConfig(node, nil, cfg)
+	if e.warned != node.ResourceVersion {
+		if err := e.info.InconsistentMemoryBlocks(); err != nil {
+			klog.Warningf("inconsistent GPU memory blocks of node %s: %v", node.Name, err)
+			e.warned = node.ResourceVersion
+		}
+		if err := e.info.UnusableShare(); err != nil {
+			klog.Warningf("GPU of node %s can't be shared: %v", node.Name, err)
+			e.warned = node.ResourceVersion
+		}
+	}
+	for _, p := range e.pods {
+		p.charge = e.info.AddPod(p.pod)
+	}
+}
+
+func (e *nodeEntry) podList() []*corev1.Pod {
+	pods := make([]*corev1.Pod, 0, len(e.pods))
+	for _, p := range e.pods {
+		pods = append(pods, p.pod)
+	}
+	return pods
+}
+
+// sameUsage tells whether the devices of both NodeInfos are used the same
+func sameUsage(a, b *device.NodeInfo) bool {
+	if a.GetDeviceCount() != b.GetDeviceCount() {
+		return false
+	}
+	for id, dev := range a.GetDeviceMap() {
+		other, ok := b.GetDeviceMap()[id]
+		if !ok || dev.UsedCores() != other.UsedCores() || dev.UsedMemory() != other.UsedMemory() ||
+			dev.NumberofContainer() != other.NumberofContainer() {
+			return false
+		}
+	}
+	return true
+}
+
+// eventHandler keeps the pods reported by the pod informer
+func (c *nodeCache) eventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if pod, ok := obj.(*corev1.Pod); ok {
+				c.updatePod(pod)
+			}
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			if pod, ok := obj.(*corev1.Pod); ok {
+				c.updatePod(pod)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if pod, ok := obj.(*corev1.Pod); ok {
+				c.deletePod(pod)
+			}
+		},
+	}
+}
+
+// nodeEventHandler drops the NodeInfos of the nodes deleted
+func (c *nodeCache) nodeEventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if node, ok := obj.(*corev1.Node); ok {
+				c.deleteNode(node.Name)
+			}
+		},
+	}
+}
@@ -0,0 +1,291 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package predicate
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+
+	"tkestack.io/gpu-admission/pkg/algorithm"
+	"tkestack.io/gpu-admission/pkg/config"
+	"tkestack.io/gpu-admission/pkg/device"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+func newNodeCacheTestNode() *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "testnode", ResourceVersion: "1"},
+		Status: corev1.NodeStatus{
+			Capacity: corev1.ResourceList{
+				corev1.ResourceName(util.VCoreAnnotation):   resource.MustParse(fmt.Sprintf("%d", deviceCount*util.HundredCore)),
+				corev1.ResourceName(util.VMemoryAnnotation): resource.MustParse(fmt.Sprintf("%d", totalMemory)),
+			},
+		},
+	}
+}
+
+// newNodeCacheTestPod returns a pod of 50 cores allocated on given device
+// of testnode
+func newNodeCacheTestPod(uid string, device int) *corev1.Pod {
+	pod := newScoredPod(50)
+	pod.Name, pod.UID, pod.Namespace = uid, types.UID(uid), namespace
+	pod.Annotations[util.PredicateNode] = "testnode"
+	pod.Annotations[util.PredicateGPUIndexPrefix+"0"] = fmt.Sprintf("%d", device)
+	return pod
+}
+
+// usedCoresOf returns the used cores of each device of the node looked up
+func usedCoresOf(c *nodeCache, node *corev1.Node, cfg *config.Config) []uint {
+	nodeInfo, _ := c.nodeInfo(node, cfg)
+	return usedCores(nodeInfo)
+}
+
+func usedCores(nodeInfo *device.NodeInfo) []uint {
+	used := make([]uint, nodeInfo.GetDeviceCount())
+	for id, dev := range nodeInfo.GetDeviceMap() {
+		used[id] = dev.UsedCores()
+	}
+	return used
+}
+
+func TestNodeCachePodLifecycle(t *testing.T) {
+	cfg := config.Default()
+	c := newNodeCache(func() *config.Config { return cfg })
+	handler := c.eventHandler()
+	node := newNodeCacheTestNode()
+	expect := func(step string, cores ...uint) {
+		t.Helper()
+		if got := usedCoresOf(c, node, cfg); fmt.Sprint(got) != fmt.Sprint(cores) {
+			t.Fatalf("%s: expect used cores %v, got %v", step, cores, got)
+		}
+	}
+
+	// a pod is kept before the node is looked up, and charged once it is
+	pending := newNodeCacheTestPod("pod-0", 0)
+	delete(pending.Annotations, util.PredicateNode)
+	handler.OnAdd(pending)
+	expect("pending", 0, 0)
+	handler.OnAdd(newNodeCacheTestPod("pod-1", 1))
+	expect("added before lookup", 0, 50)
+
+	// the pending pod is allocated and bound
+	allocated := newNodeCacheTestPod("pod-0", 0)
+	handler.OnUpdate(pending, allocated)
+	expect("allocated", 50, 50)
+	bound := allocated.DeepCopy()
+	bound.Spec.NodeName, bound.Status.Phase = "testnode", corev1.PodRunning
+	handler.OnUpdate(allocated, bound)
+	handler.OnUpdate(bound, bound)
+	expect("bound", 50, 50)
+
+	// the NodeInfo looked up is the caller's own
+	nodeInfo, pods := c.nodeInfo(node, cfg)
+	if len(pods) != 2 {
+		t.Fatalf("expect 2 pods on the node, got %d", len(pods))
+	}
+	if err := nodeInfo.AddUsedResources(0, 50, 1, 0); err != nil {
+		t.Fatalf("failed to add used resources: %v", err)
+	}
+	expect("lookup mutated", 50, 50)
+
+	// the pod finishes, or is deleted
+	finished := bound.DeepCopy()
+	finished.Status.Phase = corev1.PodSucceeded
+	handler.OnUpdate(bound, finished)
+	expect("finished", 0, 50)
+	handler.OnDelete(finished)
+	handler.OnDelete(cache.DeletedFinalStateUnknown{Key: "test-ns/pod-1", Obj: newNodeCacheTestPod("pod-1", 1)})
+	expect("deleted", 0, 0)
+
+	// a pod moved to another node is released from the old one
+	moved := newNodeCacheTestPod("pod-2", 1)
+	handler.OnAdd(moved)
+	expect("moved in", 0, 50)
+	moved = moved.DeepCopy()
+	moved.Annotations[util.PredicateNode] = "othernode"
+	handler.OnUpdate(moved, moved)
+	expect("moved out", 0, 0)
+
+	// the node changed and a profile config are built from the pods kept
+	handler.OnAdd(newNodeCacheTestPod("pod-3", 0))
+	changed := node.DeepCopy()
+	changed.ResourceVersion = "2"
+	if got := usedCoresOf(c, changed, cfg); fmt.Sprint(got) != "[50 0]" {
+		t.Fatalf("expect the changed node rebuilt, got %v", got)
+	}
+	// the pods are charged by their annotations under any share policy
+	profile := *cfg
+	profile.SharePolicy = config.ShareRoundedUp
+	rounded, _ := c.nodeInfo(changed, &profile)
+	if got := usedCores(rounded); !rounded.RoundsUpShare() || fmt.Sprint(got) != "[50 0]" {
+		t.Fatalf("expect the NodeInfo under the profile config, got %v", got)
+	}
+	if plain, _ := c.nodeInfo(changed, cfg); plain.RoundsUpShare() {
+		t.Fatalf("expect the profile config not cached")
+	}
+
+	// the deleted node is built again once looked up
+	c.nodeEventHandler().OnDelete(changed)
+	if got := usedCoresOf(c, changed, cfg); fmt.Sprint(got) != "[50 0]" {
+		t.Fatalf("expect the deleted node rebuilt, got %v", got)
+	}
+}
+
+func TestNodeCacheReconcile(t *testing.T) {
+	cfg := config.Default()
+	c := newNodeCache(func() *config.Config { return cfg })
+	node := newNodeCacheTestNode()
+	kept := newNodeCacheTestPod("pod-0", 0)
+	c.updatePod(kept)
+	c.updatePod(newNodeCacheTestPod("pod-1", 1))
+	if got := usedCoresOf(c, node, cfg); fmt.Sprint(got) != "[50 50]" {
+		t.Fatalf("expect used cores [50 50], got %v", got)
+	}
+
+	if drifted := c.reconcile([]*corev1.Pod{kept, newNodeCacheTestPod("pod-1", 1)}); drifted != 0 {
+		t.Fatalf("expect no drift, got %d nodes", drifted)
+	}
+	// the delete event of pod-1 is missed, and pod-2 isn't reported yet
+	if drifted := c.reconcile([]*corev1.Pod{kept, newNodeCacheTestPod("pod-2", 0)}); drifted != 1 {
+		t.Fatalf("expect the node drifted, got %d nodes", drifted)
+	}
+	if got := usedCoresOf(c, node, cfg); fmt.Sprint(got) != "[100 0]" {
+		t.Fatalf("expect the pods listed accounted, got %v", got)
+	}
+	// the pods listed are kept for the later events
+	c.deletePod(kept)
+	if got := usedCoresOf(c, node, cfg); fmt.Sprint(got) != "[50 0]" {
+		t.Fatalf("expect the deleted pod released, got %v", got)
+	}
+}
+
+func TestNodeCacheLockNode(t *testing.T) {
+	cfg := config.Default()
+	c := newNodeCache(func() *config.Config { return cfg })
+	node := newNodeCacheTestNode()
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		winners []string
+		start   = make(chan struct{})
+	)
+	// each of the pods takes the whole node, only one of them fits
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			pod := newScoredPod(deviceCount * util.HundredCore)
+			pod.Name, pod.UID, pod.Namespace = fmt.Sprintf("pod-%d", i), types.UID(fmt.Sprintf("pod-%d", i)), namespace
+			<-start
+			unlock := c.lockNode(node.Name)
+			defer unlock()
+			nodeInfo, _ := c.nodeInfo(node, cfg)
+			newPod, err := algorithm.NewAllocator(nodeInfo).Allocate(context.Background(), pod)
+			if err != nil {
+				return
+			}
+			// the pod is patched before it's kept, which takes a while
+			time.Sleep(time.Millisecond)
+			c.updatePod(newPod)
+			mu.Lock()
+			winners = append(winners, pod.Name)
+			mu.Unlock()
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+	if len(winners) != 1 {
+		t.Fatalf("expect a single pod allocated, got %v", winners)
+	}
+	if got := usedCoresOf(c, node, cfg); fmt.Sprint(got) != "[100 100]" {
+		t.Fatalf("expect the node charged once, got %v", got)
+	}
+}
+
+func TestNodeCacheStalePod(t *testing.T) {
+	cfg := config.Default()
+	c := newNodeCache(func() *config.Config { return cfg })
+	handler := c.eventHandler()
+	node := newNodeCacheTestNode()
+	expect := func(step string, cores ...uint) {
+		t.Helper()
+		if got := usedCoresOf(c, node, cfg); fmt.Sprint(got) != fmt.Sprint(cores) {
+			t.Fatalf("%s: expect used cores %v, got %v", step, cores, got)
+		}
+	}
+
+	pending := newNodeCacheTestPod("pod-0", 0)
+	delete(pending.Annotations, util.PredicateNode)
+	pending.ResourceVersion = "1"
+	handler.OnAdd(pending)
+	expect("pending", 0, 0)
+
+	// the filter keeps the pod patched, then the informer replays the
+	// version before the patch
+	allocated := newNodeCacheTestPod("pod-0", 0)
+	allocated.ResourceVersion = "2"
+	c.updatePod(allocated)
+	expect("allocated", 50, 0)
+	handler.OnAdd(pending)
+	handler.OnUpdate(pending, pending)
+	expect("stale events", 50, 0)
+	if drifted := c.reconcile([]*corev1.Pod{pending}); drifted != 0 {
+		t.Fatalf("expect the stale pod listed ignored, got %d nodes drifted", drifted)
+	}
+	expect("stale list", 50, 0)
+
+	// a newer version is applied
+	bound := allocated.DeepCopy()
+	bound.ResourceVersion = "3"
+	bound.Spec.NodeName = "testnode"
+	bound.Annotations[util.PredicateGPUIndexPrefix+"0"] = "1"
+	handler.OnUpdate(allocated, bound)
+	expect("bound", 0, 50)
+}
+
+func TestNodeCacheInconsistentMemoryBlocks(t *testing.T) {
+	cfg := config.Default()
+	c := newNodeCache(func() *config.Config { return cfg })
+	node := newNodeCacheTestNode()
+	node.Annotations = map[string]string{util.GPUMemoryBlocks: "4,2"}
+
+	c.nodeInfo(node, cfg)
+	e := c.nodes[node.Name]
+	if e.warned != "1" {
+		t.Fatalf("expect version 1 of the node logged, got %q", e.warned)
+	}
+	// a rebuild of the same version keeps it, a newer version is logged
+	c.reconcile(nil)
+	if e.warned != "1" {
+		t.Fatalf("expect version 1 of the node kept, got %q", e.warned)
+	}
+	node = node.DeepCopy()
+	node.ResourceVersion = "2"
+	c.nodeInfo(node, cfg)
+	if e.warned != "2" {
+		t.Fatalf("expect version 2 of the node logged, got %q", e.warned)
+	}
+}
@@ -17,13 +17,86 @@
 package predicate
 
 import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
 	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+
+	"tkestack.io/gpu-admission/pkg/device"
 )
 
 type Predicate interface {
 	// Name returns the name of this predictor
 	Name() string
 	// Filter returns the filter result of predictor, this will tell the suitable nodes to running
-	// pod
-	Filter(args extenderv1.ExtenderArgs) *extenderv1.ExtenderFilterResult
+	// pod, it stops once ctx is done
+	Filter(ctx context.Context, args extenderv1.ExtenderArgs) *extenderv1.ExtenderFilterResult
+}
+
+type Prioritizer interface {
+	// Name returns the name of this prioritizer
+	Name() string
+	// Prioritize returns the scores of nodes for the pod, the higher the
+	// score is, the more the node is preferred
+	Prioritize(args extenderv1.ExtenderArgs) (*extenderv1.HostPriorityList, error)
+}
+
+type Binder interface {
+	// Name returns the name of this binder
+	Name() string
+	// Bind binds the pod to the node
+	Bind(args extenderv1.ExtenderBindingArgs) *extenderv1.ExtenderBindingResult
+}
+
+type Preemptor interface {
+	// Name returns the name of this preemptor
+	Name() string
+	// ProcessPreemption returns the victims to evict on each node so that
+	// the pod can be scheduled
+	ProcessPreemption(args extenderv1.ExtenderPreemptionArgs) (*extenderv1.ExtenderPreemptionResult, error)
+}
+
+type Simulator interface {
+	// Name returns the name of this simulator
+	Name() string
+	// Simulate places the hypothetical pods on the current nodes one by
+	// one without changing them, it stops once ctx is done
+	Simulate(ctx context.Context, args SimulationArgs) (*SimulationResult, error)
+}
+
+type GangChecker interface {
+	// Name returns the name of this checker
+	Name() string
+	// CheckGang tells whether the members of the gang of the pod not
+	// placed yet fit on the candidate nodes together, without changing
+	// them, it stops once ctx is done
+	CheckGang(ctx context.Context, args GangArgs) (*GangResult, error)
+}
+
+type Rebalancer interface {
+	// Name returns the name of this rebalancer
+	Name() string
+	// Rebalance recommends the pods to move to free whole GPUs, without
+	// moving them, it stops once ctx is done
+	Rebalance(ctx context.Context, args RebalanceArgs) (*RebalanceResult, error)
+}
+
+type PodValidator interface {
+	// Name returns the name of this validator
+	Name() string
+	// ValidatePod returns why the GPU request of the pod would never be
+	// scheduled, nil if it's valid
+	ValidatePod(pod *corev1.Pod) error
+}
+
+type NodeInspector interface {
+	// NodeInfos returns the GPU state of the node of given name, or of all
+	// GPU nodes if the name is empty
+	NodeInfos(name string) ([]*device.NodeInfo, error)
+}
+
+type Readiness interface {
+	// Ready tells whether the state of the cluster, e.g. the informer cache
+	// of nodes and pods, is ready to serve requests
+	Ready() bool
 }
@@ -0,0 +1,173 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package route
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+
+	"tkestack.io/gpu-admission/pkg/predicate"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+// AdmissionRoute reviews the pods of a ValidatingAdmissionWebhook, a pod
+// whose GPU request the validator rejects is denied with 403 and the
+// reasons, the other objects are allowed. The review is answered in the
+// apiVersion of the request, admission.k8s.io/v1 or v1beta1 which have the
+// same fields.
+func AdmissionRoute(validator predicate.PodValidator) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		var review admissionv1.AdmissionReview
+		if err := decodeBody(r, &review); err != nil {
+			badRequest(w, r, validator.Name(), err)
+			return
+		}
+		// a review without request, e.g. {}, has nothing to answer
+		if review.Request == nil {
+			badRequest(w, r, validator.Name(), errors.New("invalid request body: no admission request given"))
+			return
+		}
+		response := &admissionv1.AdmissionResponse{UID: review.Request.UID, Allowed: true}
+		if err := reviewPod(validator, review.Request); err != nil {
+			klog.V(4).Infof("%s: denied %s %s/%s: %v", validator.Name(), review.Request.Operation,
+				review.Request.Namespace, review.Request.Name, err)
+			response.Allowed = false
+			response.Result = &metav1.Status{
+				Status:  metav1.StatusFailure,
+				Code:    http.StatusForbidden,
+				Reason:  metav1.StatusReasonForbidden,
+				Message: err.Error(),
+			}
+		}
+		review.Request = nil
+		review.Response = response
+
+		if resultBody, err := json.Marshal(review); err != nil {
+			klog.Errorf("Failed to marshal admissionReview: %+v, %+v", err, review)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+		} else {
+			klog.V(4).Infof("%s: admissionReview = %s", validator.Name(), string(resultBody))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			w.Write(resultBody)
+		}
+	}
+}
+
+// reviewPod validates the pod created by req, or updated with other GPU
+// request annotations, nil if req is of another resource or operation. The
+// other updates, e.g. of the labels or a finalizer, are always allowed, in
+// case the pod admitted under an older config would be stuck. The ephemeral
+// containers added to a running pod are reviewed alone, see
+// reviewEphemeralContainers.
+func reviewPod(validator predicate.PodValidator, req *admissionv1.AdmissionRequest) error {
+	if req.Resource.Resource != "pods" {
+		return nil
+	}
+	if req.SubResource == ephemeralContainersSubResource {
+		return reviewEphemeralContainers(validator, req)
+	}
+	if req.SubResource != "" {
+		return nil
+	}
+	if req.Operation != admissionv1.Create && req.Operation != admissionv1.Update {
+		return nil
+	}
+	var pod corev1.Pod
+	if err := json.Unmarshal(req.Object.Raw, &pod); err != nil {
+		return fmt.Errorf("malformed pod: %v", err)
+	}
+	if req.Operation == admissionv1.Update {
+		var old corev1.Pod
+		if err := json.Unmarshal(req.OldObject.Raw, &old); err != nil {
+			return fmt.Errorf("malformed old pod: %v", err)
+		}
+		if !requestAnnotationsChanged(&old, &pod) {
+			return nil
+		}
+	}
+	return validator.ValidatePod(&pod)
+}
+
+// ephemeralContainersSubResource is the subresource of the pods by which the
+// ephemeral containers are added, e.g. by kubectl debug
+const ephemeralContainersSubResource = "ephemeralcontainers"
+
+// ephemeralContainersObject is the object of an ephemeralcontainers update,
+// an EphemeralContainers of Kubernetes 1.16 to 1.21 or a Pod of the later
+// ones
+type ephemeralContainersObject struct {
+	metav1.ObjectMeta   `json:"metadata,omitempty"`
+	EphemeralContainers []corev1.EphemeralContainer `json:"ephemeralContainers,omitempty"`
+	Spec                corev1.PodSpec              `json:"spec,omitempty"`
+}
+
+// reviewEphemeralContainers validates the ephemeral containers of the pod
+// updated by req, the other containers of it have been admitted already
+func reviewEphemeralContainers(validator predicate.PodValidator, req *admissionv1.AdmissionRequest) error {
+	if req.Operation != admissionv1.Update {
+		return nil
+	}
+	var obj ephemeralContainersObject
+	if err := json.Unmarshal(req.Object.Raw, &obj); err != nil {
+		return fmt.Errorf("malformed ephemeral containers: %v", err)
+	}
+	ecs := obj.EphemeralContainers
+	if len(ecs) == 0 {
+		ecs = obj.Spec.EphemeralContainers
+	}
+	return validator.ValidatePod(&corev1.Pod{
+		ObjectMeta: obj.ObjectMeta,
+		Spec: corev1.PodSpec{
+			SchedulerName:       obj.Spec.SchedulerName,
+			EphemeralContainers: ecs,
+		},
+	})
+}
+
+// requestAnnotationsChanged tells if a GPU request annotation is added,
+// removed or changed from old to pod
+func requestAnnotationsChanged(old, pod *corev1.Pod) bool {
+	for k, v := range pod.Annotations {
+		if util.IsRequestAnnotation(k) && old.Annotations[k] != v {
+			return true
+		}
+	}
+	for k := range old.Annotations {
+		if _, ok := pod.Annotations[k]; !ok && util.IsRequestAnnotation(k) {
+			return true
+		}
+	}
+	return false
+}
+
+// AddAdmission serves the ValidatingAdmissionWebhook of the pods, see
+// AdmissionRoute
+func AddAdmission(router *httprouter.Router, validator predicate.PodValidator) {
+	path := admissionPath
+	router.POST(path, DebugLogging(AdmissionRoute(validator), path))
+}
@@ -0,0 +1,133 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package route
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// fakeValidator rejects the pods named bad
+type fakeValidator struct{}
+
+func (fakeValidator) Name() string { return "fake" }
+
+func (fakeValidator) ValidatePod(pod *corev1.Pod) error {
+	if pod.Name == "bad" {
+		return errors.New("container c0: invalid GPU request")
+	}
+	return nil
+}
+
+func TestAdmissionRoute(t *testing.T) {
+	update := func(pod, oldAnnotations, annotations string) string {
+		return `{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview","request":{"uid":"uid-1",` +
+			`"resource":{"version":"v1","resource":"pods"},"operation":"UPDATE",` +
+			`"object":{"metadata":{"name":"` + pod + `","annotations":{` + annotations + `}}},` +
+			`"oldObject":{"metadata":{"name":"` + pod + `","annotations":{` + oldAnnotations + `}}}}}`
+	}
+	review := func(apiVersion, resource, operation, pod string) string {
+		return `{"apiVersion":"` + apiVersion + `","kind":"AdmissionReview","request":{"uid":"uid-1",` +
+			`"resource":{"version":"v1","resource":"` + resource + `"},"operation":"` + operation + `",` +
+			`"object":{"metadata":{"name":"` + pod + `"}},"oldObject":{"metadata":{"name":"` + pod + `"}}}}`
+	}
+	ephemeral := func(pod, object string) string {
+		return `{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview","request":{"uid":"uid-1",` +
+			`"resource":{"version":"v1","resource":"pods"},"subResource":"ephemeralcontainers","operation":"UPDATE",` +
+			`"object":{"metadata":{"name":"` + pod + `"},` + object + `}}}`
+	}
+	testCases := []struct {
+		name       string
+		body       string
+		status     int
+		apiVersion string
+		allowed    bool
+		message    string
+	}{
+		{name: "valid pod", body: review("admission.k8s.io/v1", "pods", "CREATE", "good"),
+			status: http.StatusOK, apiVersion: "admission.k8s.io/v1", allowed: true},
+		{name: "invalid pod", body: review("admission.k8s.io/v1", "pods", "CREATE", "bad"),
+			status: http.StatusOK, apiVersion: "admission.k8s.io/v1", message: "invalid GPU request"},
+		{name: "admitted pod updated in v1beta1", body: review("admission.k8s.io/v1beta1", "pods", "UPDATE", "bad"),
+			status: http.StatusOK, apiVersion: "admission.k8s.io/v1beta1", allowed: true},
+		{name: "admitted pod predicated",
+			body:   update("bad", `"tencent.com/gpu-pin-devices":"0"`, `"tencent.com/gpu-pin-devices":"0","tencent.com/gpu-assigned":"false"`),
+			status: http.StatusOK, apiVersion: "admission.k8s.io/v1", allowed: true},
+		{name: "GPU annotation updated",
+			body:   update("bad", `"tencent.com/gpu-pin-devices":"0"`, `"tencent.com/gpu-pin-devices":"1"`),
+			status: http.StatusOK, apiVersion: "admission.k8s.io/v1", message: "invalid GPU request"},
+		{name: "GPU annotation removed", body: update("bad", `"tencent.com/gpu-pin-devices":"0"`, ``),
+			status: http.StatusOK, apiVersion: "admission.k8s.io/v1", message: "invalid GPU request"},
+		{name: "deleted pod", body: review("admission.k8s.io/v1", "pods", "DELETE", "bad"),
+			status: http.StatusOK, apiVersion: "admission.k8s.io/v1", allowed: true},
+		{name: "other resource", body: review("admission.k8s.io/v1", "deployments", "CREATE", "bad"),
+			status: http.StatusOK, apiVersion: "admission.k8s.io/v1", allowed: true},
+		{name: "ephemeral containers added",
+			body:   ephemeral("bad", `"kind":"EphemeralContainers","ephemeralContainers":[{"name":"debug"}]`),
+			status: http.StatusOK, apiVersion: "admission.k8s.io/v1", message: "invalid GPU request"},
+		{name: "ephemeral containers added to pod",
+			body:   ephemeral("bad", `"kind":"Pod","spec":{"ephemeralContainers":[{"name":"debug"}]}`),
+			status: http.StatusOK, apiVersion: "admission.k8s.io/v1", message: "invalid GPU request"},
+		{name: "valid ephemeral containers",
+			body:   ephemeral("good", `"kind":"EphemeralContainers","ephemeralContainers":[{"name":"debug"}]`),
+			status: http.StatusOK, apiVersion: "admission.k8s.io/v1", allowed: true},
+		{name: "other subresource", body: strings.Replace(review("admission.k8s.io/v1", "pods", "UPDATE", "bad"),
+			`"operation"`, `"subResource":"status","operation"`, 1),
+			status: http.StatusOK, apiVersion: "admission.k8s.io/v1", allowed: true},
+		{name: "no request", body: `{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview"}`,
+			status: http.StatusBadRequest},
+		{name: "empty review", body: `{}`, status: http.StatusBadRequest},
+	}
+
+	router := httprouter.New()
+	AddAdmission(router, fakeValidator{})
+	for _, cs := range testCases {
+		req := httptest.NewRequest(http.MethodPost, admissionPath, strings.NewReader(cs.body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != cs.status {
+			t.Fatalf("%s: expect status %d, got %d: %s", cs.name, cs.status, w.Code, w.Body.String())
+		}
+		if cs.status != http.StatusOK {
+			continue
+		}
+		var got admissionv1.AdmissionReview
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatalf("%s: failed to decode the review: %v", cs.name, err)
+		}
+		if got.APIVersion != cs.apiVersion || got.Response == nil || got.Response.UID != "uid-1" {
+			t.Fatalf("%s: expect the response of uid-1 in %s, got %+v", cs.name, cs.apiVersion, got)
+		}
+		if got.Response.Allowed != cs.allowed {
+			t.Fatalf("%s: expect allowed %v, got %+v", cs.name, cs.allowed, got.Response)
+		}
+		if !cs.allowed && (got.Response.Result == nil || got.Response.Result.Code != http.StatusForbidden ||
+			!strings.Contains(got.Response.Result.Message, cs.message)) {
+			t.Fatalf("%s: expect 403 with %q, got %+v", cs.name, cs.message, got.Response.Result)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package route
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+
+	"tkestack.io/gpu-admission/pkg/extenderpb"
+	"tkestack.io/gpu-admission/pkg/predicate"
+)
+
+const (
+	jsonContentType     = "application/json"
+	protobufContentType = "application/x-protobuf"
+)
+
+// DefaultMaxBodySize is the default max size of a request body in bytes,
+// it's far beyond the args of the scheduler with thousands of nodes
+const DefaultMaxBodySize int64 = 128 << 20
+
+var (
+	maxBodySize = DefaultMaxBodySize
+
+	errNoBody       = errors.New("please send a request body")
+	errBodyTooLarge = errors.New("request body too large")
+)
+
+// SetMaxBodySize bounds the size in bytes of the request bodies of the
+// extender routes, 0 means no limit. It must be called before serving.
+func SetMaxBodySize(size int64) {
+	maxBodySize = size
+}
+
+// boundedReader fails with errBodyTooLarge once more than n bytes are read
+type boundedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (b *boundedReader) Read(p []byte) (int, error) {
+	if b.n < 0 {
+		return 0, errBodyTooLarge
+	}
+	if int64(len(p)) > b.n+1 {
+		p = p[:b.n+1]
+	}
+	n, err := b.r.Read(p)
+	b.n -= int64(n)
+	if b.n < 0 {
+		return n, errBodyTooLarge
+	}
+	return n, err
+}
+
+// decodeBody decodes the body of r into args and validates them, the body
+// is read no further than the max size. It's in protobuf if the
+// Content-Type of r says so, see extenderpb, or in JSON otherwise. An error
+// means a bad request.
+func decodeBody(r *http.Request, args interface{}) error {
+	if r.Body == nil || r.Body == http.NoBody {
+		return errNoBody
+	}
+	var body io.Reader = r.Body
+	if maxBodySize > 0 {
+		if r.ContentLength > maxBodySize {
+			return errBodyTooLarge
+		}
+		body = &boundedReader{r: r.Body, n: maxBodySize}
+	}
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		if err == errBodyTooLarge {
+			return err
+		}
+		return fmt.Errorf("failed to read request body: %v", err)
+	}
+	if isMediaType(r.Header.Get("Content-Type"), protobufContentType) {
+		err = extenderpb.Unmarshal(data, args)
+	} else {
+		err = json.Unmarshal(data, args)
+	}
+	if err != nil {
+		return fmt.Errorf("malformed request body: %v", err)
+	}
+	return validateArgs(args)
+}
+
+// encodeResult encodes result in protobuf if r accepts it, or if r is in
+// protobuf without an Accept header, as long as result has a protobuf
+// encoding. Otherwise it's in JSON. The Content-Type is returned as well.
+func encodeResult(r *http.Request, result interface{}) ([]byte, string, error) {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		accept = r.Header.Get("Content-Type")
+	}
+	if isMediaType(accept, protobufContentType) {
+		if m, err := extenderpb.MessageOf(result); err == nil {
+			data, err := proto.Marshal(m)
+			return data, protobufContentType, err
+		}
+	}
+	data, err := json.Marshal(result)
+	return data, jsonContentType, err
+}
+
+// bodyString is body for the logs, a protobuf one is logged as result
+func bodyString(contentType string, body []byte, result interface{}) string {
+	if contentType == protobufContentType {
+		return fmt.Sprintf("%+v", result)
+	}
+	return string(body)
+}
+
+// isMediaType tells whether one of the media types of header, e.g. of
+// Accept, is mediaType
+func isMediaType(header, mediaType string) bool {
+	for _, value := range strings.Split(header, ",") {
+		if t, _, err := mime.ParseMediaType(value); err == nil && t == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// validateArgs checks the fields the verbs can't do without are given
+func validateArgs(args interface{}) error {
+	switch args := args.(type) {
+	case *extenderv1.ExtenderArgs:
+		if args.Pod == nil {
+			return errors.New("invalid request body: no pod given")
+		}
+		if args.Nodes == nil && args.NodeNames == nil {
+			return errors.New("invalid request body: no nodes given")
+		}
+	case *extenderv1.ExtenderBindingArgs:
+		if args.PodName == "" || args.Node == "" {
+			return errors.New("invalid request body: no pod or node given")
+		}
+	case *extenderv1.ExtenderPreemptionArgs:
+		if args.Pod == nil {
+			return errors.New("invalid request body: no pod given")
+		}
+	case *predicate.GangArgs:
+		if args.Pod == nil {
+			return errors.New("invalid request body: no pod given")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package route
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+)
+
+// fakePredicate passes all the nodes but the first of a node list, and
+// counts the filters
+type fakePredicate struct {
+	filters int
+}
+
+func (p *fakePredicate) Name() string { return "fake" }
+
+func (p *fakePredicate) Filter(_ context.Context, args extenderv1.ExtenderArgs) *extenderv1.ExtenderFilterResult {
+	p.filters++
+	if args.Nodes == nil {
+		return &extenderv1.ExtenderFilterResult{NodeNames: args.NodeNames}
+	}
+	result := &extenderv1.ExtenderFilterResult{Nodes: &corev1.NodeList{}, FailedNodes: extenderv1.FailedNodesMap{}}
+	for i, node := range args.Nodes.Items {
+		if i == 0 {
+			result.FailedNodes[node.Name] = "first node"
+			continue
+		}
+		result.Nodes.Items = append(result.Nodes.Items, node)
+	}
+	return result
+}
+
+func TestPredicateRouteBody(t *testing.T) {
+	defer SetMaxBodySize(DefaultMaxBodySize)
+	SetMaxBodySize(1024)
+
+	valid := `{"pod":{"metadata":{"name":"pod"}},"nodenames":["node"]}`
+	// the size of the body is unknown until it's read
+	oversized := func() io.Reader {
+		return io.MultiReader(strings.NewReader(`{"pod":{},"nodenames":["`),
+			strings.NewReader(strings.Repeat("n", 4096)), strings.NewReader(`"]}`))
+	}
+	testCases := []struct {
+		name   string
+		body   io.Reader
+		status int
+		reason string
+	}{
+		{name: "valid", body: strings.NewReader(valid), status: http.StatusOK},
+		{name: "oversized", body: strings.NewReader(valid + strings.Repeat(" ", 1024)),
+			status: http.StatusBadRequest, reason: errBodyTooLarge.Error()},
+		{name: "oversized without length", body: oversized(),
+			status: http.StatusBadRequest, reason: errBodyTooLarge.Error()},
+		{name: "no body", status: http.StatusBadRequest, reason: errNoBody.Error()},
+		{name: "malformed", body: strings.NewReader(`{"pod":`),
+			status: http.StatusBadRequest, reason: "malformed request body"},
+		{name: "no pod", body: strings.NewReader(`{"nodenames":["node"]}`),
+			status: http.StatusBadRequest, reason: "no pod given"},
+		{name: "no nodes", body: strings.NewReader(`{"pod":{}}`),
+			status: http.StatusBadRequest, reason: "no nodes given"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &fakePredicate{}
+			r := httptest.NewRequest(http.MethodPost, predicatesPrefix, tc.body)
+			if tc.name == "oversized without length" {
+				r.ContentLength = -1
+			}
+			w := httptest.NewRecorder()
+			PredicateRoute(p)(w, r, nil)
+			if w.Code != tc.status {
+				t.Fatalf("expect %d, got %d: %s", tc.status, w.Code, w.Body.String())
+			}
+			if !strings.Contains(w.Body.String(), tc.reason) {
+				t.Fatalf("expect reason %q, got %q", tc.reason, w.Body.String())
+			}
+			if filtered := tc.status == http.StatusOK; (p.filters == 1) != filtered {
+				t.Fatalf("expect filtered %t, got %d filters", filtered, p.filters)
+			}
+		})
+	}
+}
+
+func TestBoundedReader(t *testing.T) {
+	data, err := ioutil.ReadAll(&boundedReader{r: strings.NewReader("12345"), n: 5})
+	if err != nil || string(data) != "12345" {
+		t.Fatalf("expect the body within the limit read, got %q, %v", data, err)
+	}
+	if _, err := ioutil.ReadAll(&boundedReader{r: strings.NewReader("123456"), n: 5}); err != errBodyTooLarge {
+		t.Fatalf("expect %v, got %v", errBodyTooLarge, err)
+	}
+}
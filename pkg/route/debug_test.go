@@ -0,0 +1,99 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package route
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"tkestack.io/gpu-admission/pkg/device"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+type fakeInspector struct {
+	nodes map[string]*device.NodeInfo
+}
+
+func (f *fakeInspector) NodeInfos(name string) ([]*device.NodeInfo, error) {
+	if name == "" {
+		return []*device.NodeInfo{f.nodes["node-0"], f.nodes["node-1"]}, nil
+	}
+	if n, ok := f.nodes[name]; ok {
+		return []*device.NodeInfo{n}, nil
+	}
+	return nil, apierrors.NewNotFound(corev1.Resource("nodes"), name)
+}
+
+func newDebugTestNode(name string) *device.NodeInfo {
+	return device.NewNodeInfo(&corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status: corev1.NodeStatus{
+			Capacity: corev1.ResourceList{
+				corev1.ResourceName(util.VCoreAnnotation):   resource.MustParse(fmt.Sprintf("%d", 2*util.HundredCore)),
+				corev1.ResourceName(util.VMemoryAnnotation): resource.MustParse("16"),
+			},
+		},
+	}, nil)
+}
+
+func TestDebugNodes(t *testing.T) {
+	inspector := &fakeInspector{nodes: map[string]*device.NodeInfo{
+		"node-0": newDebugTestNode("node-0"),
+		"node-1": newDebugTestNode("node-1"),
+	}}
+	if err := inspector.nodes["node-1"].AddUsedResources(1, 30, 2, 0); err != nil {
+		t.Fatalf("failed to add used resources: %v", err)
+	}
+	router := httprouter.New()
+	AddDebugNodes(router, inspector)
+
+	get := func(path string) (int, []map[string]interface{}) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		var nodes []map[string]interface{}
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &nodes); err != nil {
+				t.Fatalf("failed to unmarshal %s: %v", w.Body.String(), err)
+			}
+		}
+		return w.Code, nodes
+	}
+
+	if code, nodes := get(debugNodesPath); code != http.StatusOK || len(nodes) != 2 {
+		t.Fatalf("expect 2 nodes, got %d: %v", code, nodes)
+	}
+	code, nodes := get(debugNodesPath + "/node-1")
+	if code != http.StatusOK || len(nodes) != 1 || nodes[0]["name"] != "node-1" {
+		t.Fatalf("expect node-1, got %d: %v", code, nodes)
+	}
+	dev := nodes[0]["devices"].([]interface{})[1].(map[string]interface{})
+	if dev["usedCores"] != float64(30) || dev["allocatableMemory"] != float64(6) || dev["containers"] != float64(1) {
+		t.Fatalf("unexpected device 1 of node-1: %v", dev)
+	}
+	if code, _ := get(debugNodesPath + "/node-2"); code != http.StatusNotFound {
+		t.Fatalf("expect 404 of an unknown node, got %d", code)
+	}
+}
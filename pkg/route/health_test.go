@@ -0,0 +1,57 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package route
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+type fakeReadiness struct {
+	ready bool
+}
+
+func (f *fakeReadiness) Ready() bool {
+	return f.ready
+}
+
+func TestHealth(t *testing.T) {
+	readiness := &fakeReadiness{}
+	router := httprouter.New()
+	AddHealth(router, readiness)
+
+	get := func(path string) int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Code
+	}
+
+	if code := get(healthzPath); code != http.StatusOK {
+		t.Fatalf("expect healthz 200, got %d", code)
+	}
+	if code := get(readyzPath); code != http.StatusServiceUnavailable {
+		t.Fatalf("expect readyz 503 before the cache syncs, got %d", code)
+	}
+
+	readiness.ready = true
+	if code := get(readyzPath); code != http.StatusOK {
+		t.Fatalf("expect readyz 200 after the cache syncs, got %d", code)
+	}
+}
@@ -0,0 +1,72 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package route
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"k8s.io/klog"
+)
+
+// Limiter bounds the number of requests handled at the same time and how
+// long each of them may take
+type Limiter struct {
+	inflight chan struct{}
+	timeout  time.Duration
+}
+
+// NewLimiter returns a Limiter which handles at most maxInflight requests
+// at the same time, and cancels the context of a request after timeout. 0
+// means no limit for either of them.
+func NewLimiter(maxInflight uint, timeout time.Duration) *Limiter {
+	l := &Limiter{timeout: timeout}
+	if maxInflight > 0 {
+		l.inflight = make(chan struct{}, maxInflight)
+	}
+	return l
+}
+
+// Limit wraps the handler, a request beyond the limit is rejected at once
+// with 429 and Retry-After, so the scheduler retries it later. A nil
+// Limiter limits nothing.
+func (l *Limiter) Limit(h httprouter.Handle, path string) httprouter.Handle {
+	if l == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		if l.inflight != nil {
+			select {
+			case l.inflight <- struct{}{}:
+				defer func() { <-l.inflight }()
+			default:
+				klog.Warningf("%s: too many requests in flight, max %d", path, cap(l.inflight))
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, "too many requests in flight, please retry", http.StatusTooManyRequests)
+				return
+			}
+		}
+		if l.timeout > 0 {
+			ctx, cancel := context.WithTimeout(r.Context(), l.timeout)
+			defer cancel()
+			r = r.WithContext(ctx)
+		}
+		h(w, r, p)
+	}
+}
@@ -0,0 +1,73 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package route
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestLimiter(t *testing.T) {
+	var (
+		release  = make(chan struct{})
+		started  = make(chan struct{})
+		deadline time.Time
+	)
+	h := func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		deadline, _ = r.Context().Deadline()
+		started <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}
+	handle := NewLimiter(1, time.Minute).Limit(h, predicatesPrefix)
+
+	first := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		handle(first, httptest.NewRequest(http.MethodPost, predicatesPrefix, nil), nil)
+		close(done)
+	}()
+	<-started
+
+	// the second request is rejected at once while the first is in flight
+	second := httptest.NewRecorder()
+	handle(second, httptest.NewRequest(http.MethodPost, predicatesPrefix, nil), nil)
+	if second.Code != http.StatusTooManyRequests || second.Header().Get("Retry-After") == "" {
+		t.Fatalf("expect 429 with Retry-After, got %d %v", second.Code, second.Header())
+	}
+
+	close(release)
+	<-done
+	if first.Code != http.StatusOK {
+		t.Fatalf("expect the first request to succeed, got %d", first.Code)
+	}
+	if deadline.IsZero() || time.Until(deadline) > time.Minute {
+		t.Fatalf("expect the request to have a deadline within a minute, got %v", deadline)
+	}
+
+	// a free slot accepts requests again
+	go func() { <-started }()
+	third := httptest.NewRecorder()
+	handle(third, httptest.NewRequest(http.MethodPost, predicatesPrefix, nil), nil)
+	if third.Code != http.StatusOK {
+		t.Fatalf("expect the third request to succeed, got %d", third.Code)
+	}
+}
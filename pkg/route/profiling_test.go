@@ -0,0 +1,65 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package route
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestAddProfiling(t *testing.T) {
+	router := httprouter.New()
+	AddProfiling(router)
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	testCases := []struct {
+		path   string
+		expect string
+	}{
+		{path: pprofPath, expect: "goroutine"},
+		{path: pprofPath + "heap?debug=1", expect: "heap profile"},
+		{path: pprofPath + "cmdline"},
+		{path: pprofPath + "symbol", expect: "num_symbols"},
+	}
+	for _, tc := range testCases {
+		resp, err := http.Get(srv.URL + tc.path)
+		if err != nil {
+			t.Fatalf("failed to get %s: %v", tc.path, err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expect 200 at %s, got %d", tc.path, resp.StatusCode)
+		}
+		if !strings.Contains(string(body), tc.expect) {
+			t.Fatalf("expect %q at %s, got %s", tc.expect, tc.path, body)
+		}
+	}
+
+	// the profiles aren't served unless added
+	w := httptest.NewRecorder()
+	httprouter.New().ServeHTTP(w, httptest.NewRequest(http.MethodGet, pprofPath, nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expect 404 without profiling, got %d", w.Code)
+	}
+}
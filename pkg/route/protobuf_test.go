@@ -0,0 +1,114 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package route
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+
+	"tkestack.io/gpu-admission/pkg/extenderpb"
+)
+
+func newEncodingTestNode(name string) corev1.Node {
+	return corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"gpu": "true"}},
+		Status: corev1.NodeStatus{
+			Capacity: corev1.ResourceList{"tencent.com/vcuda-core": resource.MustParse("200")},
+		},
+	}
+}
+
+func TestPredicateRouteEncoding(t *testing.T) {
+	args := extenderv1.ExtenderArgs{
+		Pod: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default", UID: "uid"}},
+		Nodes: &corev1.NodeList{Items: []corev1.Node{
+			newEncodingTestNode("node-0"), newEncodingTestNode("node-1"),
+		}},
+	}
+	expect := &extenderv1.ExtenderFilterResult{
+		Nodes:       &corev1.NodeList{Items: []corev1.Node{newEncodingTestNode("node-1")}},
+		FailedNodes: extenderv1.FailedNodesMap{"node-0": "first node"},
+	}
+	marshalProto := func(v interface{}) ([]byte, error) {
+		m, err := extenderpb.MessageOf(v)
+		if err != nil {
+			return nil, err
+		}
+		return proto.Marshal(m)
+	}
+
+	testCases := []struct {
+		name        string
+		contentType string
+		accept      string
+		marshal     func(interface{}) ([]byte, error)
+		unmarshal   func([]byte, interface{}) error
+		expectType  string
+	}{
+		{name: "json", contentType: jsonContentType, marshal: json.Marshal,
+			unmarshal: json.Unmarshal, expectType: jsonContentType},
+		{name: "no content type", marshal: json.Marshal,
+			unmarshal: json.Unmarshal, expectType: jsonContentType},
+		{name: "protobuf", contentType: protobufContentType, marshal: marshalProto,
+			unmarshal: extenderpb.Unmarshal, expectType: protobufContentType},
+		{name: "protobuf accepted", contentType: jsonContentType, accept: "application/json;q=0.5, " + protobufContentType,
+			marshal: json.Marshal, unmarshal: extenderpb.Unmarshal, expectType: protobufContentType},
+		{name: "json accepted", contentType: protobufContentType, accept: jsonContentType,
+			marshal: marshalProto, unmarshal: json.Unmarshal, expectType: jsonContentType},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			body, err := tc.marshal(&args)
+			if err != nil {
+				t.Fatalf("failed to marshal args: %v", err)
+			}
+			r := httptest.NewRequest(http.MethodPost, predicatesPrefix, bytes.NewReader(body))
+			if tc.contentType != "" {
+				r.Header.Set("Content-Type", tc.contentType)
+			}
+			if tc.accept != "" {
+				r.Header.Set("Accept", tc.accept)
+			}
+			w := httptest.NewRecorder()
+			PredicateRoute(&fakePredicate{})(w, r, nil)
+			if w.Code != http.StatusOK {
+				t.Fatalf("expect 200, got %d: %s", w.Code, w.Body.String())
+			}
+			if got := w.Header().Get("Content-Type"); got != tc.expectType {
+				t.Fatalf("expect Content-Type %s, got %s", tc.expectType, got)
+			}
+			result := &extenderv1.ExtenderFilterResult{}
+			if err := tc.unmarshal(w.Body.Bytes(), result); err != nil {
+				t.Fatalf("failed to unmarshal result: %v", err)
+			}
+			if !reflect.DeepEqual(result, expect) {
+				t.Fatalf("expect %+v, got %+v", expect, result)
+			}
+		})
+	}
+}
@@ -17,73 +17,302 @@
 package route
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
+	"net/http/pprof"
+	"strings"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/klog"
 	extenderv1 "k8s.io/kube-scheduler/extender/v1"
 
 	"tkestack.io/gpu-admission/pkg/predicate"
+	"tkestack.io/gpu-admission/pkg/tracing"
 	"tkestack.io/gpu-admission/pkg/version"
 )
 
 const (
 	// version router path
 	versionPath = "/version"
+	// metrics router path
+	metricsPath = "/metrics"
+	// liveness and readiness router path
+	healthzPath = "/healthz"
+	readyzPath  = "/readyz"
 	apiPrefix   = "/scheduler"
 	// predication router path
 	predicatesPrefix = apiPrefix + "/predicates"
+	// prioritization router path
+	prioritiesPrefix = apiPrefix + "/priorities"
+	// binding router path
+	bindPrefix = apiPrefix + "/bind"
+	// preemption router path
+	preemptionPrefix = apiPrefix + "/preemption"
+	// simulation router path
+	simulatePrefix = apiPrefix + "/simulate"
+	// gang feasibility router path
+	gangPrefix = apiPrefix + "/gang"
+	// rebalance recommendation router path
+	rebalancePrefix = apiPrefix + "/rebalance"
+	// admission webhook router path
+	admissionPath = "/admission/validate"
+	// node state router path
+	debugNodesPath = "/debug/nodes"
+	// profiling router path
+	pprofPath = "/debug/pprof/"
 )
 
-func checkBody(w http.ResponseWriter, r *http.Request) {
-	if r.Body == nil {
-		http.Error(w, "Please send a request body", 400)
-		return
-	}
+// badRequest responds 400 for the body of r which failed decodeBody
+func badRequest(w http.ResponseWriter, r *http.Request, name string, err error) {
+	klog.Errorf("%s: bad request to %s: %v", name, r.URL.Path, err)
+	http.Error(w, err.Error(), http.StatusBadRequest)
 }
 
 // PredicateRoute sets router table for predication
 func PredicateRoute(predicate predicate.Predicate) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-		checkBody(w, r)
+		var extenderArgs extenderv1.ExtenderArgs
+		if err := decodeBody(r, &extenderArgs); err != nil {
+			badRequest(w, r, predicate.Name(), err)
+			return
+		}
+		extenderFilterResult := predicate.Filter(r.Context(), extenderArgs)
+		klog.V(4).Infof("%s: ExtenderArgs = %+v", predicate.Name(), extenderArgs)
 
-		var buf bytes.Buffer
-		body := io.TeeReader(r.Body, &buf)
+		if resultBody, contentType, err := encodeResult(r, extenderFilterResult); err != nil {
+			klog.Errorf("Failed to marshal extenderFilterResult: %+v, %+v",
+				err, extenderFilterResult)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+		} else {
+			klog.V(4).Infof("%s: extenderFilterResult = %s",
+				predicate.Name(), bodyString(contentType, resultBody, extenderFilterResult))
+			w.Header().Set("Content-Type", contentType)
+			w.WriteHeader(http.StatusOK)
+			w.Write(resultBody)
+		}
+	}
+}
 
+// PrioritizeRoute sets router table for prioritization
+func PrioritizeRoute(prioritizer predicate.Prioritizer) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 		var extenderArgs extenderv1.ExtenderArgs
-		var extenderFilterResult *extenderv1.ExtenderFilterResult
+		if err := decodeBody(r, &extenderArgs); err != nil {
+			badRequest(w, r, prioritizer.Name(), err)
+			return
+		}
+		klog.V(4).Infof("%s: ExtenderArgs = %+v", prioritizer.Name(), extenderArgs)
+		hostPriorityList, err := prioritizer.Prioritize(extenderArgs)
+		if err != nil {
+			klog.Errorf("%s: failed to prioritize: %v", prioritizer.Name(), err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-		if err := json.NewDecoder(body).Decode(&extenderArgs); err != nil {
-			extenderFilterResult = &extenderv1.ExtenderFilterResult{
-				Nodes:       nil,
-				FailedNodes: nil,
-				Error:       err.Error(),
-			}
+		if resultBody, contentType, err := encodeResult(r, hostPriorityList); err != nil {
+			klog.Errorf("Failed to marshal hostPriorityList: %+v, %+v",
+				err, hostPriorityList)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
 		} else {
-			extenderFilterResult = predicate.Filter(extenderArgs)
-			klog.V(4).Infof("%s: ExtenderArgs = %+v", predicate.Name(), extenderArgs)
+			klog.V(4).Infof("%s: hostPriorityList = %s",
+				prioritizer.Name(), bodyString(contentType, resultBody, hostPriorityList))
+			w.Header().Set("Content-Type", contentType)
+			w.WriteHeader(http.StatusOK)
+			w.Write(resultBody)
 		}
+	}
+}
 
-		if resultBody, err := json.Marshal(extenderFilterResult); err != nil {
-			klog.Errorf("Failed to marshal extenderFilterResult: %+v, %+v",
-				err, extenderFilterResult)
+// BindRoute sets router table for binding
+func BindRoute(binder predicate.Binder) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		var extenderBindingArgs extenderv1.ExtenderBindingArgs
+		if err := decodeBody(r, &extenderBindingArgs); err != nil {
+			badRequest(w, r, binder.Name(), err)
+			return
+		}
+		klog.V(4).Infof("%s: ExtenderBindingArgs = %+v", binder.Name(), extenderBindingArgs)
+		extenderBindingResult := binder.Bind(extenderBindingArgs)
+
+		if resultBody, contentType, err := encodeResult(r, extenderBindingResult); err != nil {
+			klog.Errorf("Failed to marshal extenderBindingResult: %+v, %+v",
+				err, extenderBindingResult)
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte(err.Error()))
 		} else {
-			klog.V(4).Infof("%s: extenderFilterResult = %s",
-				predicate.Name(), string(resultBody))
+			klog.V(4).Infof("%s: extenderBindingResult = %s",
+				binder.Name(), bodyString(contentType, resultBody, extenderBindingResult))
+			w.Header().Set("Content-Type", contentType)
+			w.WriteHeader(http.StatusOK)
+			w.Write(resultBody)
+		}
+	}
+}
+
+// PreemptionRoute sets router table for preemption
+func PreemptionRoute(preemptor predicate.Preemptor) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		var extenderPreemptionArgs extenderv1.ExtenderPreemptionArgs
+		if err := decodeBody(r, &extenderPreemptionArgs); err != nil {
+			badRequest(w, r, preemptor.Name(), err)
+			return
+		}
+		klog.V(4).Infof("%s: ExtenderPreemptionArgs = %+v", preemptor.Name(), extenderPreemptionArgs)
+		extenderPreemptionResult, err := preemptor.ProcessPreemption(extenderPreemptionArgs)
+		if err != nil {
+			klog.Errorf("%s: failed to process preemption: %v", preemptor.Name(), err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if resultBody, contentType, err := encodeResult(r, extenderPreemptionResult); err != nil {
+			klog.Errorf("Failed to marshal extenderPreemptionResult: %+v, %+v",
+				err, extenderPreemptionResult)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+		} else {
+			klog.V(4).Infof("%s: extenderPreemptionResult = %s",
+				preemptor.Name(), bodyString(contentType, resultBody, extenderPreemptionResult))
+			w.Header().Set("Content-Type", contentType)
+			w.WriteHeader(http.StatusOK)
+			w.Write(resultBody)
+		}
+	}
+}
+
+// GangRoute sets router table for the gang feasibility hint, it's read only
+func GangRoute(checker predicate.GangChecker) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		var gangArgs predicate.GangArgs
+		if err := decodeBody(r, &gangArgs); err != nil {
+			badRequest(w, r, checker.Name(), err)
+			return
+		}
+		klog.V(4).Infof("%s: GangArgs = %+v", checker.Name(), gangArgs)
+		gangResult, err := checker.CheckGang(r.Context(), gangArgs)
+		if err != nil {
+			klog.Errorf("%s: failed to check gang: %v", checker.Name(), err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if resultBody, contentType, err := encodeResult(r, gangResult); err != nil {
+			klog.Errorf("Failed to marshal gangResult: %+v, %+v",
+				err, gangResult)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+		} else {
+			klog.V(4).Infof("%s: gangResult = %s",
+				checker.Name(), bodyString(contentType, resultBody, gangResult))
+			w.Header().Set("Content-Type", contentType)
+			w.WriteHeader(http.StatusOK)
+			w.Write(resultBody)
+		}
+	}
+}
+
+// SimulateRoute sets router table for simulation, it's read only
+func SimulateRoute(simulator predicate.Simulator) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		var simulationArgs predicate.SimulationArgs
+		if err := decodeBody(r, &simulationArgs); err != nil {
+			badRequest(w, r, simulator.Name(), err)
+			return
+		}
+		klog.V(4).Infof("%s: SimulationArgs = %+v", simulator.Name(), simulationArgs)
+		simulationResult, err := simulator.Simulate(r.Context(), simulationArgs)
+		if err != nil {
+			klog.Errorf("%s: failed to simulate: %v", simulator.Name(), err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if resultBody, contentType, err := encodeResult(r, simulationResult); err != nil {
+			klog.Errorf("Failed to marshal simulationResult: %+v, %+v",
+				err, simulationResult)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+		} else {
+			klog.V(4).Infof("%s: simulationResult = %s",
+				simulator.Name(), bodyString(contentType, resultBody, simulationResult))
+			w.Header().Set("Content-Type", contentType)
+			w.WriteHeader(http.StatusOK)
+			w.Write(resultBody)
+		}
+	}
+}
+
+// RebalanceRoute sets router table for rebalance recommendation, it's read
+// only
+func RebalanceRoute(rebalancer predicate.Rebalancer) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		var rebalanceArgs predicate.RebalanceArgs
+		if err := decodeBody(r, &rebalanceArgs); err != nil {
+			badRequest(w, r, rebalancer.Name(), err)
+			return
+		}
+		klog.V(4).Infof("%s: RebalanceArgs = %+v", rebalancer.Name(), rebalanceArgs)
+		rebalanceResult, err := rebalancer.Rebalance(r.Context(), rebalanceArgs)
+		if err != nil {
+			klog.Errorf("%s: failed to rebalance: %v", rebalancer.Name(), err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if resultBody, contentType, err := encodeResult(r, rebalanceResult); err != nil {
+			klog.Errorf("Failed to marshal rebalanceResult: %+v, %+v",
+				err, rebalanceResult)
 			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+		} else {
+			klog.V(4).Infof("%s: rebalanceResult = %s",
+				rebalancer.Name(), bodyString(contentType, resultBody, rebalanceResult))
+			w.Header().Set("Content-Type", contentType)
 			w.WriteHeader(http.StatusOK)
 			w.Write(resultBody)
 		}
 	}
 }
 
+// DebugNodesRoute responds the GPU state of the node given by the node
+// parameter, or of all GPU nodes without it
+func DebugNodesRoute(inspector predicate.NodeInspector) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		nodeInfos, err := inspector.NodeInfos(p.ByName("node"))
+		if err != nil {
+			status := http.StatusInternalServerError
+			if apierrors.IsNotFound(err) {
+				status = http.StatusNotFound
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		resultBody, err := json.Marshal(nodeInfos)
+		if err != nil {
+			klog.Errorf("Failed to marshal nodeInfos: %+v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(resultBody)
+	}
+}
+
 // VersionRoute returns the version of router in response
 func VersionRoute(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	fmt.Fprint(w, fmt.Sprint(version.Get()))
@@ -93,6 +322,83 @@ func AddVersion(router *httprouter.Router) {
 	router.GET(versionPath, DebugLogging(VersionRoute, versionPath))
 }
 
+// HealthzRoute tells the process is up
+func HealthzRoute(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	fmt.Fprint(w, "ok")
+}
+
+// ReadyzRoute tells whether the extender is ready to serve requests, it
+// responds 503 until the readiness is ready
+func ReadyzRoute(readiness predicate.Readiness) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		if !readiness.Ready() {
+			http.Error(w, "not ready to serve", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}
+}
+
+// AllReady returns the readiness which is ready once all of given ones are
+func AllReady(readiness ...predicate.Readiness) predicate.Readiness {
+	return allReady(readiness)
+}
+
+type allReady []predicate.Readiness
+
+func (r allReady) Ready() bool {
+	for _, readiness := range r {
+		if !readiness.Ready() {
+			return false
+		}
+	}
+	return true
+}
+
+func AddHealth(router *httprouter.Router, readiness predicate.Readiness) {
+	router.GET(healthzPath, HealthzRoute)
+	router.GET(readyzPath, ReadyzRoute(readiness))
+}
+
+// AddMetrics serves the metrics registered to the default prometheus
+// registry
+func AddMetrics(router *httprouter.Router) {
+	router.Handler(http.MethodGet, metricsPath, promhttp.Handler())
+}
+
+// AddProfiling serves the profiles of net/http/pprof under /debug/pprof/,
+// e.g. /debug/pprof/profile for CPU and /debug/pprof/heap for memory
+func AddProfiling(router *httprouter.Router) {
+	handle := func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		switch strings.TrimPrefix(p.ByName("profile"), "/") {
+		case "cmdline":
+			pprof.Cmdline(w, r)
+		case "profile":
+			pprof.Profile(w, r)
+		case "symbol":
+			pprof.Symbol(w, r)
+		case "trace":
+			pprof.Trace(w, r)
+		default:
+			// the index, or the profile named by the path
+			pprof.Index(w, r)
+		}
+	}
+	router.GET(pprofPath+"*profile", handle)
+	router.POST(pprofPath+"*profile", handle)
+}
+
+// Traced wraps handler in a span of given name, which is a child of the
+// trace context propagated in the request headers if any
+func Traced(h httprouter.Handle, name string) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracing.Start(ctx, name)
+		defer span.End()
+		h(w, r.WithContext(ctx), p)
+	}
+}
+
 // DebugLogging wraps handler for debugging purposes
 func DebugLogging(h httprouter.Handle, path string) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
@@ -102,7 +408,43 @@ func DebugLogging(h httprouter.Handle, path string) httprouter.Handle {
 	}
 }
 
-func AddPredicate(router *httprouter.Router, predicate predicate.Predicate) {
+func AddPredicate(router *httprouter.Router, predicate predicate.Predicate, limiter *Limiter) {
 	path := predicatesPrefix
-	router.POST(path, DebugLogging(PredicateRoute(predicate), path))
+	router.POST(path, DebugLogging(limiter.Limit(Traced(PredicateRoute(predicate), tracing.SpanFilter), path), path))
+}
+
+func AddPrioritize(router *httprouter.Router, prioritizer predicate.Prioritizer) {
+	path := prioritiesPrefix
+	router.POST(path, DebugLogging(Traced(PrioritizeRoute(prioritizer), tracing.SpanPrioritize), path))
+}
+
+func AddBind(router *httprouter.Router, binder predicate.Binder) {
+	path := bindPrefix
+	router.POST(path, DebugLogging(Traced(BindRoute(binder), tracing.SpanBind), path))
+}
+
+func AddPreemption(router *httprouter.Router, preemptor predicate.Preemptor) {
+	path := preemptionPrefix
+	router.POST(path, DebugLogging(Traced(PreemptionRoute(preemptor), tracing.SpanPreempt), path))
+}
+
+func AddSimulate(router *httprouter.Router, simulator predicate.Simulator) {
+	path := simulatePrefix
+	router.POST(path, DebugLogging(Traced(SimulateRoute(simulator), tracing.SpanSimulate), path))
+}
+
+func AddGang(router *httprouter.Router, checker predicate.GangChecker) {
+	path := gangPrefix
+	router.POST(path, DebugLogging(Traced(GangRoute(checker), tracing.SpanGang), path))
+}
+
+func AddRebalance(router *httprouter.Router, rebalancer predicate.Rebalancer) {
+	path := rebalancePrefix
+	router.POST(path, DebugLogging(Traced(RebalanceRoute(rebalancer), tracing.SpanRebalance), path))
+}
+
+// AddDebugNodes serves the GPU state of the nodes, see DebugNodesRoute
+func AddDebugNodes(router *httprouter.Router, inspector predicate.NodeInspector) {
+	router.GET(debugNodesPath, DebugNodesRoute(inspector))
+	router.GET(debugNodesPath+"/:node", DebugNodesRoute(inspector))
 }
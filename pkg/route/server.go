@@ -0,0 +1,77 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package route
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// Serve serves srv on lis until ctx is done, then drains it: no new
+// connection is accepted, and the requests in flight are waited for up to
+// drainTimeout, 0 means waiting as long as they take. It returns nil once
+// drained, or the error of serving, or of draining in time, in which case
+// the requests left are cut off.
+func Serve(ctx context.Context, srv *http.Server, lis net.Listener, drainTimeout time.Duration) error {
+	served := make(chan error, 1)
+	go func() {
+		served <- srv.Serve(lis)
+	}()
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+
+	klog.Infof("Server draining the requests in flight")
+	drainCtx := context.Background()
+	if drainTimeout > 0 {
+		var cancel context.CancelFunc
+		drainCtx, cancel = context.WithTimeout(drainCtx, drainTimeout)
+		defer cancel()
+	}
+	if err := srv.Shutdown(drainCtx); err != nil {
+		srv.Close()
+		return err
+	}
+	if err := <-served; err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// Drain is the readiness of a replica going down, it turns not ready for
+// good once Start is called, so the endpoints of the replica are removed
+// before its servers stop accepting requests
+type Drain struct {
+	draining int32
+}
+
+// Start makes the readiness not ready
+func (d *Drain) Start() {
+	atomic.StoreInt32(&d.draining, 1)
+}
+
+// Ready is true until Start is called
+func (d *Drain) Ready() bool {
+	return atomic.LoadInt32(&d.draining) == 0
+}
@@ -0,0 +1,125 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package route
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// blockingServer serves a handler which blocks until release is closed
+func blockingServer(t *testing.T) (*http.Server, net.Listener, chan struct{}, chan struct{}) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusOK)
+	})}
+	return srv, lis, started, release
+}
+
+func TestServeDrain(t *testing.T) {
+	srv, lis, started, release := blockingServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- Serve(ctx, srv, lis, time.Minute)
+	}()
+
+	addr := "http://" + lis.Addr().String()
+	inflight := make(chan error, 1)
+	go func() {
+		resp, err := http.Post(addr+predicatesPrefix, "application/json", nil)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("expect the request in flight to succeed, got %d", resp.StatusCode)
+			}
+		}
+		inflight <- err
+	}()
+	<-started
+	cancel()
+
+	// no new connection is accepted while draining
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := net.DialTimeout("tcp", lis.Addr().String(), time.Second)
+		if err != nil {
+			break
+		}
+		conn.Close()
+		if time.Now().After(deadline) {
+			t.Fatalf("expect the listener closed once draining")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case err := <-served:
+		t.Fatalf("expect the server to wait for the request in flight, returned %v", err)
+	default:
+	}
+
+	close(release)
+	if err := <-inflight; err != nil {
+		t.Fatalf("expect the request in flight to finish, got %v", err)
+	}
+	if err := <-served; err != nil {
+		t.Fatalf("expect drained, got %v", err)
+	}
+}
+
+func TestServeDrainTimeout(t *testing.T) {
+	srv, lis, started, release := blockingServer(t)
+	defer close(release)
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- Serve(ctx, srv, lis, 50*time.Millisecond)
+	}()
+
+	go func() {
+		if resp, err := http.Get("http://" + lis.Addr().String()); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	<-started
+	cancel()
+	if err := <-served; err != context.DeadlineExceeded {
+		t.Fatalf("expect the drain to time out, got %v", err)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	drain := &Drain{}
+	readiness := AllReady(drain)
+	if !readiness.Ready() {
+		t.Fatalf("expect ready before draining")
+	}
+	drain.Start()
+	if readiness.Ready() {
+		t.Fatalf("expect not ready once draining")
+	}
+}
@@ -0,0 +1,170 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package rpc
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+
+	"tkestack.io/gpu-admission/pkg/extenderpb"
+	"tkestack.io/gpu-admission/pkg/predicate"
+)
+
+// Extender is what the server serves, the verbs are the same ones the HTTP
+// routes call
+type Extender interface {
+	Name() string
+	Filter(ctx context.Context, args extenderv1.ExtenderArgs) *extenderv1.ExtenderFilterResult
+	Prioritize(args extenderv1.ExtenderArgs) (*extenderv1.HostPriorityList, error)
+	Bind(args extenderv1.ExtenderBindingArgs) *extenderv1.ExtenderBindingResult
+	ProcessPreemption(args extenderv1.ExtenderPreemptionArgs) (*extenderv1.ExtenderPreemptionResult, error)
+}
+
+var (
+	_ predicate.Predicate   = Extender(nil)
+	_ predicate.Prioritizer = Extender(nil)
+	_ predicate.Binder      = Extender(nil)
+	_ predicate.Preemptor   = Extender(nil)
+)
+
+type server struct {
+	extender Extender
+}
+
+// NewServer returns the Extender service of given extender
+func NewServer(extender Extender) extenderpb.ExtenderServer {
+	return &server{extender: extender}
+}
+
+// Serve serves the Extender service of given extender on address until
+// the listener fails or ctx is done, then drains the calls in flight like
+// route.Serve
+func Serve(ctx context.Context, address string, extender Extender, drainTimeout time.Duration) error {
+	lis, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	s := grpc.NewServer()
+	extenderpb.RegisterExtenderServer(s, NewServer(extender))
+	served := make(chan error, 1)
+	go func() {
+		served <- s.Serve(lis)
+	}()
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(drained)
+	}()
+	var timeout <-chan time.Time
+	if drainTimeout > 0 {
+		timeout = time.After(drainTimeout)
+	}
+	select {
+	case <-drained:
+		return nil
+	case <-timeout:
+		s.Stop()
+		return context.DeadlineExceeded
+	}
+}
+
+func (s *server) Filter(ctx context.Context, in *extenderpb.ExtenderArgs) (*extenderpb.ExtenderFilterResult, error) {
+	var args extenderv1.ExtenderArgs
+	if err := decode(in, &args); err != nil {
+		return nil, err
+	}
+	m, err := encode(s.extender.Filter(ctx, args))
+	if err != nil {
+		return nil, err
+	}
+	return m.(*extenderpb.ExtenderFilterResult), nil
+}
+
+func (s *server) Prioritize(_ context.Context, in *extenderpb.ExtenderArgs) (*extenderpb.HostPriorityList, error) {
+	var args extenderv1.ExtenderArgs
+	if err := decode(in, &args); err != nil {
+		return nil, err
+	}
+	result, err := s.extender.Prioritize(args)
+	if err != nil {
+		klog.Errorf("%s: failed to prioritize: %v", s.extender.Name(), err)
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	m, err := encode(result)
+	if err != nil {
+		return nil, err
+	}
+	return m.(*extenderpb.HostPriorityList), nil
+}
+
+func (s *server) Bind(_ context.Context, in *extenderpb.ExtenderBindingArgs) (*extenderpb.ExtenderBindingResult, error) {
+	var args extenderv1.ExtenderBindingArgs
+	if err := decode(in, &args); err != nil {
+		return nil, err
+	}
+	m, err := encode(s.extender.Bind(args))
+	if err != nil {
+		return nil, err
+	}
+	return m.(*extenderpb.ExtenderBindingResult), nil
+}
+
+func (s *server) Preempt(_ context.Context, in *extenderpb.ExtenderPreemptionArgs) (*extenderpb.ExtenderPreemptionResult, error) {
+	var args extenderv1.ExtenderPreemptionArgs
+	if err := decode(in, &args); err != nil {
+		return nil, err
+	}
+	result, err := s.extender.ProcessPreemption(args)
+	if err != nil {
+		klog.Errorf("%s: failed to process preemption: %v", s.extender.Name(), err)
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	m, err := encode(result)
+	if err != nil {
+		return nil, err
+	}
+	return m.(*extenderpb.ExtenderPreemptionResult), nil
+}
+
+func decode(in proto.Message, args interface{}) error {
+	if err := extenderpb.Decode(in, args); err != nil {
+		return status.Errorf(codes.InvalidArgument, "failed to decode args: %v", err)
+	}
+	return nil
+}
+
+func encode(result interface{}) (proto.Message, error) {
+	m, err := extenderpb.MessageOf(result)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to encode result: %v", err)
+	}
+	return m, nil
+}
@@ -0,0 +1,194 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+
+	"tkestack.io/gpu-admission/pkg/extenderpb"
+)
+
+// fakeExtender passes the first node and scores the nodes by their order
+type fakeExtender struct{}
+
+func (fakeExtender) Name() string { return "fake" }
+
+func (fakeExtender) Filter(_ context.Context, args extenderv1.ExtenderArgs) *extenderv1.ExtenderFilterResult {
+	return &extenderv1.ExtenderFilterResult{
+		Nodes:       &corev1.NodeList{Items: args.Nodes.Items[:1]},
+		FailedNodes: extenderv1.FailedNodesMap{args.Nodes.Items[1].Name: "no GPU device"},
+	}
+}
+
+func (fakeExtender) Prioritize(args extenderv1.ExtenderArgs) (*extenderv1.HostPriorityList, error) {
+	if args.Nodes == nil {
+		return nil, fmt.Errorf("no nodes given to prioritize")
+	}
+	var result extenderv1.HostPriorityList
+	for i, node := range args.Nodes.Items {
+		result = append(result, extenderv1.HostPriority{Host: node.Name, Score: int64(i)})
+	}
+	return &result, nil
+}
+
+func (fakeExtender) Bind(args extenderv1.ExtenderBindingArgs) *extenderv1.ExtenderBindingResult {
+	return &extenderv1.ExtenderBindingResult{Error: "bound " + args.PodName}
+}
+
+func (fakeExtender) ProcessPreemption(args extenderv1.ExtenderPreemptionArgs) (*extenderv1.ExtenderPreemptionResult, error) {
+	return &extenderv1.ExtenderPreemptionResult{NodeNameToMetaVictims: args.NodeNameToMetaVictims}, nil
+}
+
+func newTestClient(t *testing.T) (extenderpb.ExtenderClient, func()) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	s := grpc.NewServer()
+	extenderpb.RegisterExtenderServer(s, NewServer(fakeExtender{}))
+	go s.Serve(lis)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cc, err := grpc.DialContext(ctx, lis.Addr().String(), grpc.WithInsecure(), grpc.WithBlock())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	return extenderpb.NewExtenderClient(cc), func() {
+		cc.Close()
+		s.Stop()
+	}
+}
+
+// call encodes args, invokes a verb with them and decodes its result into
+// result
+func call(t *testing.T, invoke func(proto.Message) (proto.Message, error), args, result interface{}) error {
+	in, err := extenderpb.MessageOf(args)
+	if err != nil {
+		t.Fatalf("failed to encode args: %v", err)
+	}
+	out, err := invoke(in)
+	if err != nil {
+		return err
+	}
+	if err := extenderpb.Decode(out, result); err != nil {
+		t.Fatalf("failed to decode result: %v", err)
+	}
+	return nil
+}
+
+// verbs are the verbs of client to call
+type verbs struct {
+	client extenderpb.ExtenderClient
+}
+
+func (v verbs) filter(in proto.Message) (proto.Message, error) {
+	return v.client.Filter(context.Background(), in.(*extenderpb.ExtenderArgs))
+}
+
+func (v verbs) prioritize(in proto.Message) (proto.Message, error) {
+	return v.client.Prioritize(context.Background(), in.(*extenderpb.ExtenderArgs))
+}
+
+func (v verbs) bind(in proto.Message) (proto.Message, error) {
+	return v.client.Bind(context.Background(), in.(*extenderpb.ExtenderBindingArgs))
+}
+
+func (v verbs) preempt(in proto.Message) (proto.Message, error) {
+	return v.client.Preempt(context.Background(), in.(*extenderpb.ExtenderPreemptionArgs))
+}
+
+func TestServerRoundTrip(t *testing.T) {
+	client, stop := newTestClient(t)
+	defer stop()
+	verb := verbs{client: client}
+
+	args := extenderv1.ExtenderArgs{
+		Pod: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod"}},
+		Nodes: &corev1.NodeList{Items: []corev1.Node{
+			{ObjectMeta: metav1.ObjectMeta{Name: "node-0"}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}},
+		}},
+	}
+
+	var filterResult extenderv1.ExtenderFilterResult
+	if err := call(t, verb.filter, &args, &filterResult); err != nil {
+		t.Fatalf("filter failed: %v", err)
+	}
+	if len(filterResult.Nodes.Items) != 1 || filterResult.Nodes.Items[0].Name != "node-0" ||
+		filterResult.FailedNodes["node-1"] != "no GPU device" {
+		t.Fatalf("unexpected filter result %+v", filterResult)
+	}
+
+	var priorities extenderv1.HostPriorityList
+	if err := call(t, verb.prioritize, &args, &priorities); err != nil {
+		t.Fatalf("prioritize failed: %v", err)
+	}
+	if len(priorities) != 2 || priorities[1].Host != "node-1" || priorities[1].Score != 1 {
+		t.Fatalf("unexpected priorities %+v", priorities)
+	}
+
+	var bindResult extenderv1.ExtenderBindingResult
+	if err := call(t, verb.bind, &extenderv1.ExtenderBindingArgs{PodName: "pod"}, &bindResult); err != nil {
+		t.Fatalf("bind failed: %v", err)
+	}
+	if bindResult.Error != "bound pod" {
+		t.Fatalf("unexpected bind result %+v", bindResult)
+	}
+
+	var preemptResult extenderv1.ExtenderPreemptionResult
+	preemptArgs := extenderv1.ExtenderPreemptionArgs{
+		NodeNameToMetaVictims: map[string]*extenderv1.MetaVictims{
+			"node-0": {Pods: []*extenderv1.MetaPod{{UID: "victim"}}},
+		},
+	}
+	if err := call(t, verb.preempt, &preemptArgs, &preemptResult); err != nil {
+		t.Fatalf("preempt failed: %v", err)
+	}
+	if victims := preemptResult.NodeNameToMetaVictims["node-0"]; victims == nil || victims.Pods[0].UID != "victim" {
+		t.Fatalf("unexpected preemption result %+v", preemptResult)
+	}
+}
+
+func TestServerErrors(t *testing.T) {
+	client, stop := newTestClient(t)
+	defer stop()
+	verb := verbs{client: client}
+
+	var priorities extenderv1.HostPriorityList
+	err := call(t, verb.prioritize, &extenderv1.ExtenderArgs{}, &priorities)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expect InvalidArgument, got %v", err)
+	}
+
+	_, err = client.Filter(context.Background(), &extenderpb.ExtenderArgs{Pod: []byte("not a pod")})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expect InvalidArgument for malformed args, got %v", err)
+	}
+}
@@ -0,0 +1,198 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+// Package state snapshots the GPU accounting of the nodes, so a restarted
+// extender charges the nodes for what it allocated before the informers
+// have caught up with the pods
+package state
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+
+	"tkestack.io/gpu-admission/pkg/device"
+)
+
+// DeviceState is the used resources of a GPU device
+type DeviceState struct {
+	ID         int  `json:"id"`
+	UsedCores  uint `json:"usedCores"`
+	UsedMemory uint `json:"usedMemory"`
+}
+
+// NodeState is the used resources of the GPU devices of a node
+type NodeState struct {
+	Node    string        `json:"node"`
+	Time    time.Time     `json:"time"`
+	Devices []DeviceState `json:"devices"`
+}
+
+// Snapshot is the state of the nodes at a time
+type Snapshot struct {
+	Time  time.Time   `json:"time"`
+	Nodes []NodeState `json:"nodes"`
+}
+
+// Capture returns the state of the node, it holds the lock of the node so
+// the devices are read at the same point of the allocations
+func Capture(n *device.NodeInfo, now time.Time) NodeState {
+	n.Lock()
+	defer n.Unlock()
+
+	state := NodeState{Node: n.GetName(), Time: now}
+	for id := 0; id < n.GetDeviceCount(); id++ {
+		dev, ok := n.GetDeviceMap()[id]
+		if !ok {
+			continue
+		}
+		state.Devices = append(state.Devices, DeviceState{
+			ID:         id,
+			UsedCores:  dev.UsedCores(),
+			UsedMemory: dev.UsedMemory(),
+		})
+	}
+	return state
+}
+
+// Apply charges the node for the resources the state records beyond what
+// it already accounts, under the lock of the node. It returns whether the
+// node is charged.
+func Apply(n *device.NodeInfo, state NodeState) bool {
+	n.Lock()
+	defer n.Unlock()
+
+	charged := false
+	for _, ds := range state.Devices {
+		dev, ok := n.GetDeviceMap()[ds.ID]
+		if !ok {
+			continue
+		}
+		cores, memory := subOrZero(ds.UsedCores, dev.UsedCores()), subOrZero(ds.UsedMemory, dev.UsedMemory())
+		if cores == 0 && memory == 0 {
+			continue
+		}
+		if err := n.AddUsedResources(ds.ID, cores, memory, 0); err != nil {
+			continue
+		}
+		charged = true
+	}
+	return charged
+}
+
+// Recorder keeps the latest state of each node and saves them to a Store
+// periodically. A nil Recorder records nothing.
+type Recorder struct {
+	sync.Mutex
+	now      func() time.Time
+	nodes    map[string]NodeState
+	restored map[string]NodeState
+}
+
+// NewRecorder returns an empty Recorder
+func NewRecorder() *Recorder {
+	return &Recorder{
+		now:      time.Now,
+		nodes:    make(map[string]NodeState),
+		restored: make(map[string]NodeState),
+	}
+}
+
+// Record remembers the state of the node
+func (r *Recorder) Record(n *device.NodeInfo) {
+	if r == nil {
+		return
+	}
+	state := Capture(n, r.now())
+	r.Lock()
+	defer r.Unlock()
+	r.nodes[state.Node] = state
+}
+
+// Snapshot returns the latest state of the nodes in name order
+func (r *Recorder) Snapshot() *Snapshot {
+	if r == nil {
+		return nil
+	}
+	r.Lock()
+	defer r.Unlock()
+
+	snapshot := &Snapshot{Time: r.now(), Nodes: make([]NodeState, 0, len(r.nodes))}
+	for _, state := range r.nodes {
+		snapshot.Nodes = append(snapshot.Nodes, state)
+	}
+	sort.Slice(snapshot.Nodes, func(i, j int) bool {
+		return snapshot.Nodes[i].Node < snapshot.Nodes[j].Node
+	})
+	return snapshot
+}
+
+// Restore loads the snapshot of store, whose nodes are charged by
+// ApplyRestored. They are also recorded, so the next snapshot keeps the
+// nodes not evaluated since.
+func (r *Recorder) Restore(store Store) error {
+	if r == nil {
+		return nil
+	}
+	snapshot, err := store.Load()
+	if err != nil || snapshot == nil {
+		return err
+	}
+	r.Lock()
+	defer r.Unlock()
+	for _, state := range snapshot.Nodes {
+		r.restored[state.Node] = state
+		if _, ok := r.nodes[state.Node]; !ok {
+			r.nodes[state.Node] = state
+		}
+	}
+	klog.Infof("Restored the GPU state of %d nodes taken at %s", len(snapshot.Nodes), snapshot.Time)
+	return nil
+}
+
+// ApplyRestored charges the node for the restored state of it, see Apply
+func (r *Recorder) ApplyRestored(n *device.NodeInfo) bool {
+	if r == nil {
+		return false
+	}
+	r.Lock()
+	state, ok := r.restored[n.GetName()]
+	r.Unlock()
+	return ok && Apply(n, state)
+}
+
+// Run saves the snapshot to store every interval until stopCh is closed,
+// a failed save is logged and retried at the next interval
+func (r *Recorder) Run(store Store, interval time.Duration, stopCh <-chan struct{}) {
+	if r == nil {
+		return
+	}
+	wait.Until(func() {
+		if err := store.Save(r.Snapshot()); err != nil {
+			klog.Errorf("Failed to save the GPU state: %v", err)
+		}
+	}, interval, stopCh)
+}
+
+func subOrZero(a, b uint) uint {
+	if a < b {
+		return 0
+	}
+	return a - b
+}
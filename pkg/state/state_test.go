@@ -0,0 +1,174 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package state
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"tkestack.io/gpu-admission/pkg/device"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+func newTestNodeInfo(name string, deviceCount, totalMemory int) *device.NodeInfo {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Annotations: make(map[string]string),
+		},
+		Status: v1.NodeStatus{
+			Capacity: v1.ResourceList{
+				v1.ResourceName(util.VCoreAnnotation):   resource.MustParse(fmt.Sprintf("%d", deviceCount*util.HundredCore)),
+				v1.ResourceName(util.VMemoryAnnotation): resource.MustParse(fmt.Sprintf("%d", totalMemory)),
+			},
+		},
+	}
+	return device.NewNodeInfo(node, nil)
+}
+
+func TestCaptureApply(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	nodeInfo := newTestNodeInfo("node-0", 2, 8)
+	if err := nodeInfo.AddUsedResources(1, 50, 2, 0); err != nil {
+		t.Fatalf("failed to add used resources: %v", err)
+	}
+
+	got := Capture(nodeInfo, now)
+	expect := NodeState{
+		Node: "node-0",
+		Time: now,
+		Devices: []DeviceState{
+			{ID: 0},
+			{ID: 1, UsedCores: 50, UsedMemory: 2},
+		},
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Fatalf("got %+v, expect %+v", got, expect)
+	}
+
+	// the rebuilt node has seen a part of the allocations
+	rebuilt := newTestNodeInfo("node-0", 2, 8)
+	if err := rebuilt.AddUsedResources(1, 20, 1, 0); err != nil {
+		t.Fatalf("failed to add used resources: %v", err)
+	}
+	if !Apply(rebuilt, got) {
+		t.Fatalf("the rebuilt node should be charged")
+	}
+	if after := Capture(rebuilt, now); !reflect.DeepEqual(after, expect) {
+		t.Fatalf("got %+v after apply, expect %+v", after, expect)
+	}
+	if Apply(rebuilt, got) {
+		t.Fatalf("the node accounting the state should not be charged again")
+	}
+}
+
+func TestConfigMapStore(t *testing.T) {
+	store := NewConfigMapStore(fake.NewSimpleClientset(), "kube-system", "gpu-admission-state")
+
+	if snapshot, err := store.Load(); err != nil || snapshot != nil {
+		t.Fatalf("expect no snapshot, got %+v, %v", snapshot, err)
+	}
+	for _, cores := range []uint{10, 30} {
+		snapshot := &Snapshot{
+			Time: time.Unix(1600000000, 0).UTC(),
+			Nodes: []NodeState{{
+				Node:    "node-0",
+				Time:    time.Unix(1600000000, 0).UTC(),
+				Devices: []DeviceState{{ID: 0, UsedCores: cores, UsedMemory: 1}},
+			}},
+		}
+		if err := store.Save(snapshot); err != nil {
+			t.Fatalf("failed to save snapshot: %v", err)
+		}
+		got, err := store.Load()
+		if err != nil {
+			t.Fatalf("failed to load snapshot: %v", err)
+		}
+		if !reflect.DeepEqual(got, snapshot) {
+			t.Fatalf("got %+v, expect %+v", got, snapshot)
+		}
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	store := &FakeStore{}
+	recorder := NewRecorder()
+	nodeInfo := newTestNodeInfo("node-1", 2, 8)
+	if err := nodeInfo.AddUsedResources(0, util.HundredCore, 4, 0); err != nil {
+		t.Fatalf("failed to add used resources: %v", err)
+	}
+	recorder.Record(nodeInfo)
+	recorder.Record(newTestNodeInfo("node-0", 2, 8))
+
+	stopCh := make(chan struct{})
+	go recorder.Run(store, 10*time.Millisecond, stopCh)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		store.Lock()
+		saves := store.Saves
+		store.Unlock()
+		if saves > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no snapshot is saved")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(stopCh)
+
+	snapshot, _ := store.Load()
+	if len(snapshot.Nodes) != 2 || snapshot.Nodes[0].Node != "node-0" || snapshot.Nodes[1].Node != "node-1" {
+		t.Fatalf("unexpected snapshot %+v", snapshot)
+	}
+
+	// a restarted extender charges the nodes before it sees the pods
+	restarted := NewRecorder()
+	if err := restarted.Restore(store); err != nil {
+		t.Fatalf("failed to restore: %v", err)
+	}
+	rebuilt := newTestNodeInfo("node-1", 2, 8)
+	if !restarted.ApplyRestored(rebuilt) {
+		t.Fatalf("the rebuilt node should be charged")
+	}
+	if dev := rebuilt.GetDeviceMap()[0]; dev.UsedCores() != util.HundredCore || dev.UsedMemory() != 4 {
+		t.Fatalf("got used cores %d, memory %d", dev.UsedCores(), dev.UsedMemory())
+	}
+	if restarted.ApplyRestored(newTestNodeInfo("node-2", 2, 8)) {
+		t.Fatalf("the node absent from the snapshot should not be charged")
+	}
+	if got := restarted.Snapshot(); len(got.Nodes) != 2 {
+		t.Fatalf("the restored nodes should be kept, got %+v", got)
+	}
+
+	store.Err = fmt.Errorf("broken")
+	if err := NewRecorder().Restore(store); err == nil {
+		t.Fatalf("expect the error of the store")
+	}
+	var nilRecorder *Recorder
+	nilRecorder.Record(nodeInfo)
+	if nilRecorder.ApplyRestored(rebuilt) {
+		t.Fatalf("a nil recorder should charge nothing")
+	}
+}
@@ -0,0 +1,141 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ConfigMapKey is the key of the snapshot in the ConfigMap
+const ConfigMapKey = "state.json"
+
+// Store persists the snapshot
+type Store interface {
+	// Save replaces the persisted snapshot
+	Save(*Snapshot) error
+	// Load returns the persisted snapshot, nil if none
+	Load() (*Snapshot, error)
+}
+
+// ConfigMapStore persists the snapshot in JSON under ConfigMapKey of a
+// ConfigMap, which is created on the first save
+type ConfigMapStore struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+}
+
+// NewConfigMapStore returns a Store of the ConfigMap namespace/name
+func NewConfigMapStore(client kubernetes.Interface, namespace, name string) *ConfigMapStore {
+	return &ConfigMapStore{client: client, namespace: namespace, name: name}
+}
+
+// Save writes the snapshot to the ConfigMap
+func (s *ConfigMapStore) Save(snapshot *Snapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	configMaps := s.client.CoreV1().ConfigMaps(s.namespace)
+	cm, err := configMaps.Get(context.Background(), s.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		cm = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: s.namespace, Name: s.name},
+			Data:       map[string]string{ConfigMapKey: string(data)},
+		}
+		_, err = configMaps.Create(context.Background(), cm, metav1.CreateOptions{})
+		return s.wrap(err)
+	}
+	if err != nil {
+		return s.wrap(err)
+	}
+	cm = cm.DeepCopy()
+	if cm.Data == nil {
+		cm.Data = make(map[string]string)
+	}
+	cm.Data[ConfigMapKey] = string(data)
+	_, err = configMaps.Update(context.Background(), cm, metav1.UpdateOptions{})
+	return s.wrap(err)
+}
+
+// Load reads the snapshot from the ConfigMap, nil is returned if either
+// the ConfigMap or the key is absent
+func (s *ConfigMapStore) Load() (*Snapshot, error) {
+	cm, err := s.client.CoreV1().ConfigMaps(s.namespace).Get(context.Background(), s.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, s.wrap(err)
+	}
+	data, ok := cm.Data[ConfigMapKey]
+	if !ok {
+		return nil, nil
+	}
+	snapshot := &Snapshot{}
+	if err := json.Unmarshal([]byte(data), snapshot); err != nil {
+		return nil, fmt.Errorf("invalid snapshot of ConfigMap %s/%s: %v", s.namespace, s.name, err)
+	}
+	return snapshot, nil
+}
+
+func (s *ConfigMapStore) wrap(err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("ConfigMap %s/%s: %v", s.namespace, s.name, err)
+}
+
+// FakeStore keeps the snapshot in memory, it's meant for tests
+type FakeStore struct {
+	sync.Mutex
+	snapshot *Snapshot
+	// Saves is the number of snapshots saved
+	Saves int
+	// Err is returned by Save and Load if not nil
+	Err error
+}
+
+// Save keeps the snapshot
+func (s *FakeStore) Save(snapshot *Snapshot) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.Err != nil {
+		return s.Err
+	}
+	s.snapshot = snapshot
+	s.Saves++
+	return nil
+}
+
+// Load returns the snapshot kept
+func (s *FakeStore) Load() (*Snapshot, error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	return s.snapshot, nil
+}
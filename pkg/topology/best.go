@@ -0,0 +1,208 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package topology
+
+// maxCombinations bounds the sets BestSetOf tries exhaustively, beyond which
+// the devices are picked greedily
+const maxCombinations = 1 << 16
+
+// setScore is how well a set of GPU devices is connected, the weakest link
+// first since it bounds collective operations, then the number of NICs
+// the devices are close to since GPUDirect RDMA traffic crossing the PCIe
+// root is slow, and then the sum of links
+type setScore struct {
+	min, nics, sum int
+}
+
+func (s setScore) better(o setScore) bool {
+	if s.min != o.min {
+		return s.min > o.min
+	}
+	if s.nics != o.nics {
+		return s.nics < o.nics
+	}
+	return s.sum > o.sum
+}
+
+// score returns the score of the set of GPU devices, whose NICs are those
+// of the topology
+func (t *Topology) score(set []int) setScore {
+	return t.scoreNear(set, nil)
+}
+
+// scoreNear returns the score of the set of GPU devices, nics gives the NIC
+// of each device by idx, see BestSetNear
+func (t *Topology) scoreNear(set []int, nics []int) setScore {
+	s := setScore{min: -1, nics: t.nicsOf(set, nics)}
+	for i := 0; i < len(set); i++ {
+		for j := i + 1; j < len(set); j++ {
+			score := t.Link(set[i], set[j]).Score()
+			if s.min < 0 || score < s.min {
+				s.min = score
+			}
+			s.sum += score
+		}
+	}
+	return s
+}
+
+// nicsOf returns the number of NICs the set of GPU devices is close to, a
+// device close to no NIC counts as one of its own
+func (t *Topology) nicsOf(set []int, nics []int) int {
+	var (
+		count int
+		seen  = make(map[int]bool, len(set))
+	)
+	for _, gpu := range set {
+		nic := t.NIC(gpu)
+		if nics != nil {
+			nic = nics[gpu]
+		}
+		if nic < 0 || !seen[nic] {
+			count++
+		}
+		seen[nic] = true
+	}
+	return count
+}
+
+// BestSet returns count GPU devices best connected to each other, nil if
+// the node doesn't have so many devices
+func (t *Topology) BestSet(count int) []int {
+	all := make([]int, t.Count())
+	for i := range all {
+		all[i] = i
+	}
+	return t.BestSetOf(all, count)
+}
+
+// BestSetOf returns count devices of the candidates best connected to each
+// other, nil if there aren't enough candidates. Among the sets whose weakest
+// links are equal, the one close to the fewest NICs is preferred. The
+// devices are returned in the order of candidates, and the remaining ties
+// are broken by the order too, so the caller's preference is kept among the
+// equally connected sets.
+func (t *Topology) BestSetOf(candidates []int, count int) []int {
+	return t.BestSetNear(candidates, nil, count)
+}
+
+// BestSetNear is BestSetOf with the NIC of each device given by nics,
+// indexed by device idx, -1 if the device is close to no NIC. The NICs of
+// the topology are used if nics is nil.
+func (t *Topology) BestSetNear(candidates []int, nics []int, count int) []int {
+	if count <= 0 || count > len(candidates) {
+		return nil
+	}
+	if nics != nil && len(nics) < t.Count() {
+		return nil
+	}
+	for _, c := range candidates {
+		if c < 0 || c >= t.Count() {
+			return nil
+		}
+	}
+	if count == len(candidates) || count == 1 {
+		return append([]int(nil), candidates[:count]...)
+	}
+	if !fewCombinations(len(candidates), count) {
+		return t.greedySetOf(candidates, nics, count)
+	}
+
+	var (
+		best      []int
+		bestScore setScore
+		pos       = make([]int, count)
+		set       = make([]int, count)
+	)
+	for i := range pos {
+		pos[i] = i
+	}
+	for {
+		for i, p := range pos {
+			set[i] = candidates[p]
+		}
+		if s := t.scoreNear(set, nics); best == nil || s.better(bestScore) {
+			best, bestScore = append([]int(nil), set...), s
+		}
+		// advance to the next combination in lexicographic order
+		i := count - 1
+		for i >= 0 && pos[i] == len(candidates)-count+i {
+			i--
+		}
+		if i < 0 {
+			return best
+		}
+		pos[i]++
+		for j := i + 1; j < count; j++ {
+			pos[j] = pos[j-1] + 1
+		}
+	}
+}
+
+// greedySetOf grows a set from each candidate by adding the device best
+// connected to it, and returns the best of them in the order of candidates
+func (t *Topology) greedySetOf(candidates []int, nics []int, count int) []int {
+	var (
+		best      []bool
+		bestScore setScore
+	)
+	for seed := range candidates {
+		picked := make([]bool, len(candidates))
+		picked[seed] = true
+		set := []int{candidates[seed]}
+		for len(set) < count {
+			next, nextScore := -1, setScore{}
+			for i, c := range candidates {
+				if picked[i] {
+					continue
+				}
+				if s := t.scoreNear(append(set, c), nics); next < 0 || s.better(nextScore) {
+					next, nextScore = i, s
+				}
+			}
+			picked[next] = true
+			set = append(set, candidates[next])
+		}
+		if s := t.scoreNear(set, nics); best == nil || s.better(bestScore) {
+			best, bestScore = picked, s
+		}
+	}
+
+	ret := make([]int, 0, count)
+	for i, c := range candidates {
+		if best[i] {
+			ret = append(ret, c)
+		}
+	}
+	return ret
+}
+
+// fewCombinations tells whether choosing k of n is within maxCombinations
+func fewCombinations(n, k int) bool {
+	if k > n-k {
+		k = n - k
+	}
+	c := 1
+	for i := 1; i <= k; i++ {
+		// c*(n-k+i)/i is always an integer
+		c = c * (n - k + i) / i
+		if c > maxCombinations {
+			return false
+		}
+	}
+	return true
+}
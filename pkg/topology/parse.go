@@ -0,0 +1,181 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package topology
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// newer nvidia-smi underlines the header by ANSI escapes
+	ansiEscape = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+	gpuName    = regexp.MustCompile(`^GPU(\d+)$`)
+)
+
+// Parse parses the topology either in JSON, see ParseJSON, or in the output
+// of `nvidia-smi topo -m`, see ParseText
+func Parse(data []byte) (*Topology, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return ParseJSON(data)
+	}
+	return ParseText(data)
+}
+
+// ParseJSON parses the topology marshaled from Topology, e.g.
+//
+//	{"gpus":[["X","NV1"],["NV1","X"]],"nics":["mlx5_0"],"nicLinks":[["PIX"],["PHB"]]}
+func ParseJSON(data []byte) (*Topology, error) {
+	t := &Topology{}
+	if err := json.Unmarshal(data, t); err != nil {
+		return nil, err
+	}
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// ParseText parses the matrix printed by `nvidia-smi topo -m`. The columns
+// are separated by tabs, the 1st line names the devices followed by the
+// affinity columns, and each GPU device has a row of its links to the
+// devices named. The rows of NICs and the legend are ignored except the
+// NIC legend, which gives the real names of NIC0, NIC1 and so on.
+func ParseText(data []byte) (*Topology, error) {
+	var (
+		header   []string
+		rows     = make(map[int][]string)
+		nicNames = make(map[string]string)
+		legend   bool
+	)
+	for _, line := range strings.Split(ansiEscape.ReplaceAllString(string(data), ""), "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+			continue
+		case strings.HasSuffix(trimmed, "Legend:"):
+			legend = true
+			continue
+		case legend:
+			// e.g. "  NIC0: mlx5_0" of the NIC legend
+			if parts := strings.SplitN(trimmed, ":", 2); len(parts) == 2 {
+				nicNames[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+			}
+			continue
+		}
+
+		cells := strings.Split(line, "\t")
+		for i := range cells {
+			cells[i] = strings.TrimSpace(cells[i])
+		}
+		if header == nil {
+			header = cells
+			if len(header) > 0 && header[0] == "" {
+				header = header[1:]
+			}
+			continue
+		}
+		m := gpuName.FindStringSubmatch(cells[0])
+		if m == nil {
+			continue
+		}
+		idx, _ := strconv.Atoi(m[1])
+		if _, ok := rows[idx]; ok {
+			return nil, fmt.Errorf("duplicate row of %s", cells[0])
+		}
+		rows[idx] = cells[1:]
+	}
+	if header == nil {
+		return nil, fmt.Errorf("no topology matrix")
+	}
+
+	var (
+		gpuColumns  = make(map[int]int)
+		nicColumns  []int
+		numaColumn  = -1
+		deviceCount = len(rows)
+		t           = &Topology{}
+	)
+	for col, name := range header {
+		if name == "NUMA Affinity" {
+			numaColumn = col
+		}
+		if strings.Contains(name, "Affinity") || strings.Contains(name, "NUMA ID") {
+			continue
+		}
+		if m := gpuName.FindStringSubmatch(name); m != nil {
+			idx, _ := strconv.Atoi(m[1])
+			gpuColumns[idx] = col
+			continue
+		}
+		if real, ok := nicNames[name]; ok {
+			name = real
+		}
+		t.NICs = append(t.NICs, name)
+		nicColumns = append(nicColumns, col)
+	}
+	if len(gpuColumns) != deviceCount {
+		return nil, fmt.Errorf("got %d GPU columns and %d GPU rows", len(gpuColumns), deviceCount)
+	}
+
+	cell := func(row []string, col int) string {
+		if col < len(row) {
+			return row[col]
+		}
+		return ""
+	}
+	for i := 0; i < deviceCount; i++ {
+		row, ok := rows[i]
+		if !ok {
+			return nil, fmt.Errorf("no row of GPU%d", i)
+		}
+		links := make([]Link, deviceCount)
+		for j := range links {
+			col, ok := gpuColumns[j]
+			if !ok {
+				return nil, fmt.Errorf("no column of GPU%d", j)
+			}
+			links[j] = Link(cell(row, col))
+		}
+		t.GPUs = append(t.GPUs, links)
+		if len(nicColumns) != 0 {
+			nicLinks := make([]Link, len(nicColumns))
+			for j, col := range nicColumns {
+				nicLinks[j] = Link(cell(row, col))
+			}
+			t.NICLinks = append(t.NICLinks, nicLinks)
+		}
+		if numaColumn >= 0 {
+			numa, err := strconv.Atoi(cell(row, numaColumn))
+			if err != nil {
+				// N/A if the system has a single NUMA node
+				numa = -1
+			}
+			t.NUMANodes = append(t.NUMANodes, numa)
+		}
+	}
+
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
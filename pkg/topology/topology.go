@@ -0,0 +1,177 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+// Package topology describes the interconnect between the GPU devices of a
+// node, as reported by `nvidia-smi topo -m`, and picks the devices best
+// connected to each other
+package topology
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Link is the connection between two devices in the notation of
+// nvidia-smi, e.g. NV2 or PIX
+type Link string
+
+// The links reported by nvidia-smi, from the worst to the best, except
+// NVLink which is NV followed by the number of bonded links, e.g. NV2
+const (
+	// LinkSelf is a device to itself
+	LinkSelf Link = "X"
+	// LinkSYS crosses the SMP interconnect between NUMA nodes
+	LinkSYS Link = "SYS"
+	// LinkSOC is the name of SYS of old drivers
+	LinkSOC Link = "SOC"
+	// LinkNODE crosses PCIe host bridges within a NUMA node
+	LinkNODE Link = "NODE"
+	// LinkPHB traverses a PCIe host bridge
+	LinkPHB Link = "PHB"
+	// LinkPXB traverses multiple PCIe bridges
+	LinkPXB Link = "PXB"
+	// LinkPIX traverses at most a single PCIe bridge
+	LinkPIX Link = "PIX"
+
+	nvlinkPrefix = "NV"
+)
+
+// NVLinks returns the number of bonded NVLinks, 0 if the link isn't NVLink
+func (l Link) NVLinks() int {
+	if !strings.HasPrefix(string(l), nvlinkPrefix) {
+		return 0
+	}
+	n, err := strconv.Atoi(string(l)[len(nvlinkPrefix):])
+	if err != nil || n <= 0 {
+		return 0
+	}
+	return n
+}
+
+// Score returns how good the link is, the higher the better. Any NVLink
+// beats PCIe, 0 is returned for the unknown links and the self link.
+func (l Link) Score() int {
+	if n := l.NVLinks(); n > 0 {
+		return 10 + n
+	}
+	switch l {
+	case LinkPIX:
+		return 5
+	case LinkPXB:
+		return 4
+	case LinkPHB:
+		return 3
+	case LinkNODE:
+		return 2
+	case LinkSYS, LinkSOC:
+		return 1
+	}
+	return 0
+}
+
+func (l Link) valid() bool {
+	return l == LinkSelf || l.Score() > 0
+}
+
+// Topology is the links among the GPU devices of a node and the links of
+// them to the NICs
+type Topology struct {
+	// GPUs is the link between each pair of GPU devices, indexed by device
+	// idx
+	GPUs [][]Link `json:"gpus"`
+	// NICs is the name of each NIC, e.g. mlx5_0
+	NICs []string `json:"nics,omitempty"`
+	// NICLinks is the link of each GPU device to each NIC, indexed by device
+	// idx and then by the index in NICs
+	NICLinks [][]Link `json:"nicLinks,omitempty"`
+	// NUMANodes is the NUMA node of each GPU device, -1 if unknown
+	NUMANodes []int `json:"numaNodes,omitempty"`
+}
+
+// Count returns the number of GPU devices
+func (t *Topology) Count() int {
+	return len(t.GPUs)
+}
+
+// Link returns the link between the GPU devices i and j
+func (t *Topology) Link(i, j int) Link {
+	return t.GPUs[i][j]
+}
+
+// NUMANode returns the NUMA node of the GPU device, -1 if unknown
+func (t *Topology) NUMANode(gpu int) int {
+	if gpu >= len(t.NUMANodes) {
+		return -1
+	}
+	return t.NUMANodes[gpu]
+}
+
+// NIC returns the index in NICs of the NIC sharing the PCIe switch of the
+// GPU device, which is the best linked one through PIX or PXB, -1 if none
+func (t *Topology) NIC(gpu int) int {
+	if gpu >= len(t.NICLinks) {
+		return -1
+	}
+	nic, best := -1, LinkPHB.Score()
+	for i, link := range t.NICLinks[gpu] {
+		if link.Score() > best {
+			nic, best = i, link.Score()
+		}
+	}
+	return nic
+}
+
+// Validate checks the matrices are complete and the GPU links symmetric
+func (t *Topology) Validate() error {
+	n := len(t.GPUs)
+	if n == 0 {
+		return fmt.Errorf("no GPU device")
+	}
+	for i, row := range t.GPUs {
+		if len(row) != n {
+			return fmt.Errorf("GPU%d has %d links, expect %d", i, len(row), n)
+		}
+		for j, link := range row {
+			if !link.valid() {
+				return fmt.Errorf("invalid link %q between GPU%d and GPU%d", link, i, j)
+			}
+			if (i == j) != (link == LinkSelf) {
+				return fmt.Errorf("invalid link %q between GPU%d and GPU%d", link, i, j)
+			}
+			if t.GPUs[j][i] != link {
+				return fmt.Errorf("asymmetric links between GPU%d and GPU%d", i, j)
+			}
+		}
+	}
+	if len(t.NICs) != 0 && len(t.NICLinks) != n {
+		return fmt.Errorf("got NIC links of %d GPU devices, expect %d", len(t.NICLinks), n)
+	}
+	for i, row := range t.NICLinks {
+		if len(row) != len(t.NICs) {
+			return fmt.Errorf("GPU%d has %d NIC links, expect %d", i, len(row), len(t.NICs))
+		}
+		for j, link := range row {
+			if link == LinkSelf || !link.valid() {
+				return fmt.Errorf("invalid link %q between GPU%d and %s", link, i, t.NICs[j])
+			}
+		}
+	}
+	if len(t.NUMANodes) != 0 && len(t.NUMANodes) != n {
+		return fmt.Errorf("got NUMA nodes of %d GPU devices, expect %d", len(t.NUMANodes), n)
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package topology
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// dgx1 is `nvidia-smi topo -m` of a DGX-1 with V100, whose NVLinks form a
+// hybrid cube mesh
+var dgx1 = strings.Join([]string{
+	"\x1b[4m\tGPU0\tGPU1\tGPU2\tGPU3\tGPU4\tGPU5\tGPU6\tGPU7\tmlx5_0\tmlx5_2\tmlx5_1\tmlx5_3\tCPU Affinity\tNUMA Affinity\x1b[0m",
+	"GPU0\t X \tNV1\tNV1\tNV2\tNV2\tSYS\tSYS\tSYS\tPIX\tSYS\tPHB\tSYS\t0-19,40-59\t0",
+	"GPU1\tNV1\t X \tNV2\tNV1\tSYS\tNV2\tSYS\tSYS\tPIX\tSYS\tPHB\tSYS\t0-19,40-59\t0",
+	"GPU2\tNV1\tNV2\t X \tNV2\tSYS\tSYS\tNV1\tSYS\tPHB\tSYS\tPIX\tSYS\t0-19,40-59\t0",
+	"GPU3\tNV2\tNV1\tNV2\t X \tSYS\tSYS\tSYS\tNV1\tPHB\tSYS\tPIX\tSYS\t0-19,40-59\t0",
+	"GPU4\tNV2\tSYS\tSYS\tSYS\t X \tNV1\tNV1\tNV2\tSYS\tPIX\tSYS\tPHB\t20-39,60-79\t1",
+	"GPU5\tSYS\tNV2\tSYS\tSYS\tNV1\t X \tNV2\tNV1\tSYS\tPIX\tSYS\tPHB\t20-39,60-79\t1",
+	"GPU6\tSYS\tSYS\tNV1\tSYS\tNV1\tNV2\t X \tNV2\tSYS\tPHB\tSYS\tPIX\t20-39,60-79\t1",
+	"GPU7\tSYS\tSYS\tSYS\tNV1\tNV2\tNV1\tNV2\t X \tSYS\tPHB\tSYS\tPIX\t20-39,60-79\t1",
+	"mlx5_0\tPIX\tPIX\tPHB\tPHB\tSYS\tSYS\tSYS\tSYS\t X \tSYS\tPHB\tSYS\t\t",
+	"mlx5_2\tSYS\tSYS\tSYS\tSYS\tPIX\tPIX\tPHB\tPHB\tSYS\t X \tSYS\tPHB\t\t",
+	"mlx5_1\tPHB\tPHB\tPIX\tPIX\tSYS\tSYS\tSYS\tSYS\tPHB\tSYS\t X \tSYS\t\t",
+	"mlx5_3\tSYS\tSYS\tSYS\tSYS\tPHB\tPHB\tPIX\tPIX\tSYS\tPHB\tSYS\t X \t\t",
+	"",
+	"Legend:",
+	"",
+	"  X    = Self",
+	"  SYS  = Connection traversing PCIe as well as the SMP interconnect between NUMA nodes (e.g., QPI/UPI)",
+	"  NODE = Connection traversing PCIe as well as the interconnect between PCIe Host Bridges within a NUMA node",
+	"  PHB  = Connection traversing PCIe as well as a PCIe Host Bridge (typically the CPU)",
+	"  PXB  = Connection traversing multiple PCIe bridges (without traversing the PCIe Host Bridge)",
+	"  PIX  = Connection traversing at most a single PCIe bridge",
+	"  NV#  = Connection traversing a bonded set of # NVLinks",
+	"",
+}, "\n")
+
+func mustParse(t *testing.T, data string) *Topology {
+	topo, err := Parse([]byte(data))
+	if err != nil {
+		t.Fatalf("failed to parse topology: %v", err)
+	}
+	return topo
+}
+
+func TestParseText(t *testing.T) {
+	topo := mustParse(t, dgx1)
+
+	if topo.Count() != 8 {
+		t.Fatalf("got %d GPU devices, expect 8", topo.Count())
+	}
+	for _, cs := range []struct {
+		i, j   int
+		expect Link
+	}{
+		{0, 0, LinkSelf},
+		{0, 3, "NV2"},
+		{3, 0, "NV2"},
+		{2, 6, "NV1"},
+		{1, 7, LinkSYS},
+	} {
+		if got := topo.Link(cs.i, cs.j); got != cs.expect {
+			t.Errorf("link between GPU%d and GPU%d: got %q, expect %q", cs.i, cs.j, got, cs.expect)
+		}
+	}
+	if expect := []string{"mlx5_0", "mlx5_2", "mlx5_1", "mlx5_3"}; !reflect.DeepEqual(topo.NICs, expect) {
+		t.Errorf("got NICs %v, expect %v", topo.NICs, expect)
+	}
+	if expect := []int{0, 0, 0, 0, 1, 1, 1, 1}; !reflect.DeepEqual(topo.NUMANodes, expect) {
+		t.Errorf("got NUMA nodes %v, expect %v", topo.NUMANodes, expect)
+	}
+	var nics []int
+	for i := 0; i < topo.Count(); i++ {
+		nics = append(nics, topo.NIC(i))
+	}
+	if expect := []int{0, 0, 2, 2, 1, 1, 3, 3}; !reflect.DeepEqual(nics, expect) {
+		t.Errorf("got NICs of GPU devices %v, expect %v", nics, expect)
+	}
+}
+
+func TestParseTextNICLegend(t *testing.T) {
+	data := strings.Join([]string{
+		"\tGPU0\tGPU1\tNIC0\tNIC1\tCPU Affinity\tNUMA Affinity\tGPU NUMA ID",
+		"GPU0\t X \tNV12\tPXB\tSYS\t0-31\t0\t\tN/A",
+		"GPU1\tNV12\t X \tSYS\tPXB\t32-63\t1\t\tN/A",
+		"NIC0\tPXB\tSYS\t X \tSYS",
+		"NIC1\tSYS\tPXB\tSYS\t X ",
+		"",
+		"Legend:",
+		"",
+		"  X    = Self",
+		"  NV#  = Connection traversing a bonded set of # NVLinks",
+		"",
+		"NIC Legend:",
+		"",
+		"  NIC0: mlx5_0",
+		"  NIC1: mlx5_1",
+	}, "\n")
+
+	topo := mustParse(t, data)
+	if expect := []string{"mlx5_0", "mlx5_1"}; !reflect.DeepEqual(topo.NICs, expect) {
+		t.Errorf("got NICs %v, expect %v", topo.NICs, expect)
+	}
+	if got := topo.Link(0, 1).NVLinks(); got != 12 {
+		t.Errorf("got %d NVLinks, expect 12", got)
+	}
+	if got := topo.NIC(1); got != 1 {
+		t.Errorf("got NIC %d of GPU1, expect 1", got)
+	}
+}
+
+func TestParseJSON(t *testing.T) {
+	topo := mustParse(t, dgx1)
+	data, err := json.Marshal(topo)
+	if err != nil {
+		t.Fatalf("failed to marshal topology: %v", err)
+	}
+	got := mustParse(t, string(data))
+	if !reflect.DeepEqual(got, topo) {
+		t.Fatalf("got %+v, expect %+v", got, topo)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	testCases := []struct {
+		name string
+		data string
+	}{
+		{name: "empty", data: ""},
+		{name: "unknown link", data: "\tGPU0\tGPU1\nGPU0\t X \tFOO\nGPU1\tFOO\t X "},
+		{name: "asymmetric", data: "\tGPU0\tGPU1\nGPU0\t X \tNV1\nGPU1\tSYS\t X "},
+		{name: "missing row", data: "\tGPU0\tGPU1\nGPU0\t X \tNV1"},
+		{name: "not self", data: `{"gpus":[["PIX"]]}`},
+		{name: "incomplete json", data: `{"gpus":[["X","NV1"],["NV1"]]}`},
+		{name: "invalid json", data: `{"gpus":`},
+	}
+
+	for _, cs := range testCases {
+		if topo, err := Parse([]byte(cs.data)); err == nil {
+			t.Errorf("%s: parsing should fail, got %+v", cs.name, topo)
+		}
+	}
+}
+
+func TestBestSet(t *testing.T) {
+	topo := mustParse(t, dgx1)
+
+	testCases := []struct {
+		name       string
+		candidates []int
+		count      int
+		expect     []int
+	}{
+		{name: "a pair of double NVLinks sharing a NIC", count: 2, expect: []int{2, 3}},
+		{name: "three devices", count: 3, expect: []int{0, 2, 3}},
+		{name: "a NVLink quad", count: 4, expect: []int{0, 1, 2, 3}},
+		{name: "whole node", count: 8, expect: []int{0, 1, 2, 3, 4, 5, 6, 7}},
+		{name: "too many", count: 9},
+		{name: "the other quad", candidates: []int{1, 2, 4, 5, 6, 7}, count: 4, expect: []int{4, 5, 6, 7}},
+		{name: "keep the order of candidates", candidates: []int{7, 6, 5, 4}, count: 2, expect: []int{7, 6}},
+		{name: "NVLink across NUMA nodes", candidates: []int{1, 3, 5, 6}, count: 2, expect: []int{1, 5}},
+	}
+
+	for _, cs := range testCases {
+		var got []int
+		if cs.candidates == nil {
+			got = topo.BestSet(cs.count)
+		} else {
+			got = topo.BestSetOf(cs.candidates, cs.count)
+		}
+		if !reflect.DeepEqual(got, cs.expect) {
+			t.Errorf("%s: got %v, expect %v", cs.name, got, cs.expect)
+		}
+	}
+
+	// the NICs given by the caller take the place of those of the topology
+	all := []int{0, 1, 2, 3, 4, 5, 6, 7}
+	if got := topo.BestSetNear(all, []int{-1, -1, -1, -1, -1, -1, -1, -1}, 2); !reflect.DeepEqual(got, []int{0, 3}) {
+		t.Errorf("no NIC: got %v, expect [0 3]", got)
+	}
+	if got := topo.BestSetNear(all, []int{0, 1, 1, 2, 0, 1, 1, 2}, 2); !reflect.DeepEqual(got, []int{0, 4}) {
+		t.Errorf("NICs of the caller: got %v, expect [0 4]", got)
+	}
+	if got := topo.BestSetNear(all, []int{0, 0}, 2); got != nil {
+		t.Errorf("incomplete NICs: got %v, expect nil", got)
+	}
+}
+
+func TestBestSetGreedy(t *testing.T) {
+	// 32 devices in pairs of NVLinks, choosing 16 of them is too many
+	// combinations to try
+	const n = 32
+	topo := &Topology{}
+	for i := 0; i < n; i++ {
+		links := make([]Link, n)
+		for j := range links {
+			switch {
+			case i == j:
+				links[j] = LinkSelf
+			case i/2 == j/2:
+				links[j] = "NV4"
+			case i/8 == j/8:
+				links[j] = LinkPIX
+			default:
+				links[j] = LinkSYS
+			}
+		}
+		topo.GPUs = append(topo.GPUs, links)
+	}
+	if err := topo.Validate(); err != nil {
+		t.Fatalf("invalid topology: %v", err)
+	}
+
+	got := topo.BestSetOf([]int{3, 4, 5, 6, 7, 8, 9, 10}, 4)
+	if expect := []int{4, 5, 6, 7}; !reflect.DeepEqual(got, expect) {
+		t.Errorf("got %v, expect %v", got, expect)
+	}
+	if got := topo.BestSet(16); len(got) != 16 || topo.score(got).min != LinkSYS.Score() {
+		t.Errorf("got %v of score %+v", got, topo.score(got))
+	}
+	if got := topo.BestSet(8); !reflect.DeepEqual(got, []int{0, 1, 2, 3, 4, 5, 6, 7}) {
+		t.Errorf("got %v, expect the devices of the 1st PCIe switch", got)
+	}
+}
@@ -0,0 +1,250 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// DefaultOTLPEndpoint is the OTLP/HTTP endpoint of a collector on the
+	// same host
+	DefaultOTLPEndpoint = "localhost:4318"
+	// otlpTracesPath is the path the collector receives the spans
+	otlpTracesPath = "/v1/traces"
+	otlpTimeout    = 10 * time.Second
+)
+
+// otlpExporter exports the spans to an OpenTelemetry collector in OTLP over
+// HTTP, encoded in protobuf.
+//
+// The exporters of go.opentelemetry.io/otel/exporters/otlp are not used
+// since the package of the request message holds the gRPC stubs of the
+// collector, which require a grpc newer than the one etcd is built on, see
+// go.mod. The request only wraps the ResourceSpans in its field 1, so it's
+// encoded here.
+type otlpExporter struct {
+	url    string
+	client *http.Client
+}
+
+// newOTLPExporter returns an exporter to given endpoint, which is a
+// host:port sent to in plain HTTP, or a URL. The spans are sent to
+// /v1/traces unless the URL has a path.
+func newOTLPExporter(endpoint string) (*otlpExporter, error) {
+	if endpoint == "" {
+		endpoint = DefaultOTLPEndpoint
+	}
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "http://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q: %v", endpoint, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q, expect host:port or an http(s) URL", endpoint)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = otlpTracesPath
+	}
+	return &otlpExporter{url: u.String(), client: &http.Client{Timeout: otlpTimeout}}, nil
+}
+
+// ExportSpans sends the spans in a request, which fails unless the
+// collector responds 200
+func (e *otlpExporter) ExportSpans(ctx context.Context, spans []*sdktrace.SpanSnapshot) error {
+	if len(spans) == 0 {
+		return nil
+	}
+	body, err := marshalSpans(spans)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// drain the body so the connection is reused
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("OTLP collector %s responded %s", e.url, resp.Status)
+	}
+	return nil
+}
+
+// Shutdown closes the idle connections, the spans are flushed by the batcher
+// before
+func (e *otlpExporter) Shutdown(context.Context) error {
+	e.client.CloseIdleConnections()
+	return nil
+}
+
+// marshalSpans encodes the ExportTraceServiceRequest of the spans, which
+// are grouped by their resource and instrumentation library
+func marshalSpans(spans []*sdktrace.SpanSnapshot) ([]byte, error) {
+	type libraryKey struct {
+		res *resource.Resource
+		lib instrumentation.Library
+	}
+	var (
+		resources []*tracepb.ResourceSpans
+		byRes     = make(map[*resource.Resource]*tracepb.ResourceSpans)
+		byLib     = make(map[libraryKey]*tracepb.InstrumentationLibrarySpans)
+	)
+	for _, s := range spans {
+		rs, ok := byRes[s.Resource]
+		if !ok {
+			rs = &tracepb.ResourceSpans{Resource: resourceOf(s.Resource)}
+			byRes[s.Resource] = rs
+			resources = append(resources, rs)
+		}
+		key := libraryKey{res: s.Resource, lib: s.InstrumentationLibrary}
+		ls, ok := byLib[key]
+		if !ok {
+			ls = &tracepb.InstrumentationLibrarySpans{
+				InstrumentationLibrary: &commonpb.InstrumentationLibrary{
+					Name:    s.InstrumentationLibrary.Name,
+					Version: s.InstrumentationLibrary.Version,
+				},
+			}
+			byLib[key] = ls
+			rs.InstrumentationLibrarySpans = append(rs.InstrumentationLibrarySpans, ls)
+		}
+		ls.Spans = append(ls.Spans, spanOf(s))
+	}
+
+	var body []byte
+	for _, rs := range resources {
+		data, err := proto.Marshal(rs)
+		if err != nil {
+			return nil, err
+		}
+		body = protowire.AppendTag(body, 1, protowire.BytesType)
+		body = protowire.AppendBytes(body, data)
+	}
+	return body, nil
+}
+
+func resourceOf(res *resource.Resource) *resourcepb.Resource {
+	if res == nil {
+		return nil
+	}
+	return &resourcepb.Resource{Attributes: keyValuesOf(res.Attributes())}
+}
+
+func spanOf(s *sdktrace.SpanSnapshot) *tracepb.Span {
+	traceID, spanID := s.SpanContext.TraceID(), s.SpanContext.SpanID()
+	span := &tracepb.Span{
+		TraceId:                traceID[:],
+		SpanId:                 spanID[:],
+		TraceState:             s.SpanContext.TraceState().String(),
+		Name:                   s.Name,
+		Kind:                   tracepb.Span_SpanKind(s.SpanKind),
+		StartTimeUnixNano:      uint64(s.StartTime.UnixNano()),
+		EndTimeUnixNano:        uint64(s.EndTime.UnixNano()),
+		Attributes:             keyValuesOf(s.Attributes),
+		DroppedAttributesCount: uint32(s.DroppedAttributeCount),
+		DroppedEventsCount:     uint32(s.DroppedMessageEventCount),
+		DroppedLinksCount:      uint32(s.DroppedLinkCount),
+		Status:                 &tracepb.Status{Code: statusCodeOf(s.StatusCode), Message: s.StatusMessage},
+	}
+	if s.Parent.HasSpanID() {
+		parentID := s.Parent.SpanID()
+		span.ParentSpanId = parentID[:]
+	}
+	for _, ev := range s.MessageEvents {
+		span.Events = append(span.Events, &tracepb.Span_Event{
+			TimeUnixNano:           uint64(ev.Time.UnixNano()),
+			Name:                   ev.Name,
+			Attributes:             keyValuesOf(ev.Attributes),
+			DroppedAttributesCount: uint32(ev.DroppedAttributeCount),
+		})
+	}
+	for _, link := range s.Links {
+		linkTraceID, linkSpanID := link.SpanContext.TraceID(), link.SpanContext.SpanID()
+		span.Links = append(span.Links, &tracepb.Span_Link{
+			TraceId:                linkTraceID[:],
+			SpanId:                 linkSpanID[:],
+			TraceState:             link.SpanContext.TraceState().String(),
+			Attributes:             keyValuesOf(link.Attributes),
+			DroppedAttributesCount: uint32(link.DroppedAttributeCount),
+		})
+	}
+	return span
+}
+
+// statusCodeOf returns the OTLP status code, whose values differ from those
+// of codes
+func statusCodeOf(code codes.Code) tracepb.Status_StatusCode {
+	switch code {
+	case codes.Ok:
+		return tracepb.Status_STATUS_CODE_OK
+	case codes.Error:
+		return tracepb.Status_STATUS_CODE_ERROR
+	default:
+		return tracepb.Status_STATUS_CODE_UNSET
+	}
+}
+
+// keyValuesOf returns the OTLP attributes, an array is sent as a string
+func keyValuesOf(attrs []attribute.KeyValue) []*commonpb.KeyValue {
+	if len(attrs) == 0 {
+		return nil
+	}
+	ret := make([]*commonpb.KeyValue, 0, len(attrs))
+	for _, kv := range attrs {
+		value := &commonpb.AnyValue{}
+		switch kv.Value.Type() {
+		case attribute.BOOL:
+			value.Value = &commonpb.AnyValue_BoolValue{BoolValue: kv.Value.AsBool()}
+		case attribute.INT64:
+			value.Value = &commonpb.AnyValue_IntValue{IntValue: kv.Value.AsInt64()}
+		case attribute.FLOAT64:
+			value.Value = &commonpb.AnyValue_DoubleValue{DoubleValue: kv.Value.AsFloat64()}
+		default:
+			value.Value = &commonpb.AnyValue_StringValue{StringValue: kv.Value.Emit()}
+		}
+		ret = append(ret, &commonpb.KeyValue{Key: string(kv.Key), Value: value})
+	}
+	return ret
+}
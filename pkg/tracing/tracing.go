@@ -0,0 +1,128 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/stdout"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "tkestack.io/gpu-admission"
+
+const (
+	// NoneExporter disables tracing, the spans are not recorded
+	NoneExporter = ""
+	// OTLPExporter sends the spans to an OpenTelemetry collector in
+	// OTLP over HTTP
+	OTLPExporter = "otlp"
+	// StdoutExporter writes the spans to stdout, e.g. for debugging
+	StdoutExporter = "stdout"
+)
+
+// serviceName is the service.name of the spans
+const serviceName = "gpu-admission"
+
+// Names of the spans
+const (
+	SpanFilter        = "extender.Filter"
+	SpanPrioritize    = "extender.Prioritize"
+	SpanBind          = "extender.Bind"
+	SpanPreempt       = "extender.Preempt"
+	SpanSimulate      = "extender.Simulate"
+	SpanGang          = "extender.Gang"
+	SpanRebalance     = "extender.Rebalance"
+	SpanIsAllocatable = "allocator.IsAllocatable"
+	SpanAllocate      = "allocator.Allocate"
+	SpanEvaluate      = "allocator.Evaluate"
+)
+
+// Keys of the span attributes
+const (
+	NodeKey        = attribute.Key("gpu.node")
+	PodKey         = attribute.Key("gpu.pod")
+	ContainerKey   = attribute.Key("gpu.container")
+	ModeKey        = attribute.Key("gpu.mode")
+	DeviceCountKey = attribute.Key("gpu.device_count")
+	DevicesKey     = attribute.Key("gpu.devices")
+)
+
+// Setup installs the global tracer provider exporting the spans to given
+// exporter, sampling the given ratio of the traces which are not sampled by
+// the caller. endpoint is where OTLPExporter sends the spans, see
+// DefaultOTLPEndpoint, and ignored by the others. The returned function
+// flushes and stops the exporter. With NoneExporter the default no-op
+// provider is kept.
+func Setup(exporter, endpoint string, sampleRatio float64) (func(context.Context) error, error) {
+	// the trace context is propagated even if tracing is disabled here, so
+	// the traces of the scheduler are not broken
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var exp sdktrace.SpanExporter
+	switch exporter {
+	case NoneExporter:
+		return func(context.Context) error { return nil }, nil
+	case OTLPExporter:
+		otlpExp, err := newOTLPExporter(endpoint)
+		if err != nil {
+			return nil, err
+		}
+		exp = otlpExp
+	case StdoutExporter:
+		stdoutExp, err := stdout.NewExporter(stdout.WithPrettyPrint())
+		if err != nil {
+			return nil, err
+		}
+		exp = stdoutExp
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q", exporter)
+	}
+	if sampleRatio < 0 || sampleRatio > 1 {
+		return nil, fmt.Errorf("sample ratio %v is not in [0, 1]", sampleRatio)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.ServiceNameKey.String(serviceName))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Start starts a span of given name from ctx
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records err if any and ends the span
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
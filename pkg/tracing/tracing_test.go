@@ -0,0 +1,163 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestSetup(t *testing.T) {
+	shutdown, err := Setup(NoneExporter, "", 1)
+	if err != nil {
+		t.Fatalf("failed to set up without exporter: %v", err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("failed to shut down: %v", err)
+	}
+	if _, err := Setup("jaeger", "", 1); err == nil {
+		t.Fatalf("expect an error of unknown exporter")
+	}
+	if _, err := Setup(StdoutExporter, "", 2); err == nil {
+		t.Fatalf("expect an error of invalid sample ratio")
+	}
+	if _, err := Setup(OTLPExporter, "ftp://collector", 1); err == nil {
+		t.Fatalf("expect an error of invalid endpoint")
+	}
+}
+
+func TestOTLPEndpoint(t *testing.T) {
+	testCases := []struct {
+		endpoint string
+		expect   string
+	}{
+		{endpoint: "", expect: "http://localhost:4318/v1/traces"},
+		{endpoint: "collector:4318", expect: "http://collector:4318/v1/traces"},
+		{endpoint: "https://collector/", expect: "https://collector/v1/traces"},
+		{endpoint: "https://collector/otlp/traces", expect: "https://collector/otlp/traces"},
+	}
+	for _, cs := range testCases {
+		exp, err := newOTLPExporter(cs.endpoint)
+		if err != nil {
+			t.Fatalf("%q: failed to create the exporter: %v", cs.endpoint, err)
+		}
+		if exp.url != cs.expect {
+			t.Fatalf("%q: got URL %s, expect %s", cs.endpoint, exp.url, cs.expect)
+		}
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	received := make(chan []*tracepb.ResourceSpans, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/x-protobuf" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		received <- unmarshalRequest(t, body)
+	}))
+	defer collector.Close()
+
+	exp, err := newOTLPExporter(collector.URL)
+	if err != nil {
+		t.Fatalf("failed to create the exporter: %v", err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithSyncer(exp),
+		sdktrace.WithResource(resource.NewWithAttributes(attribute.String("service.name", serviceName))),
+	)
+	ctx, parent := provider.Tracer(tracerName).Start(context.Background(), SpanFilter)
+	_, child := provider.Tracer(tracerName).Start(ctx, SpanAllocate, trace.WithAttributes(
+		NodeKey.String("node-0"), DeviceCountKey.Int(2)))
+	End(child, errors.New("no GPU fits"))
+
+	got := <-received
+	if len(got) != 1 || len(got[0].InstrumentationLibrarySpans) != 1 {
+		t.Fatalf("expect the spans of a resource and a library, got %v", got)
+	}
+	if attrs := got[0].Resource.Attributes; len(attrs) != 1 || attrs[0].Value.GetStringValue() != serviceName {
+		t.Fatalf("expect the service name in the resource, got %v", attrs)
+	}
+	spans := got[0].InstrumentationLibrarySpans[0].Spans
+	if len(spans) != 1 {
+		t.Fatalf("expect a span, got %d", len(spans))
+	}
+	span := spans[0]
+	parentID := parent.SpanContext().SpanID()
+	switch {
+	case span.Name != SpanAllocate:
+		t.Fatalf("got span %s, expect %s", span.Name, SpanAllocate)
+	case !bytes.Equal(span.ParentSpanId, parentID[:]):
+		t.Fatalf("got parent %x, expect %x", span.ParentSpanId, parentID[:])
+	case span.Status.Code != tracepb.Status_STATUS_CODE_ERROR || span.Status.Message != "no GPU fits":
+		t.Fatalf("got status %v, expect the error", span.Status)
+	case len(span.Attributes) != 2 || span.Attributes[1].Value.GetIntValue() != 2:
+		t.Fatalf("got attributes %v", span.Attributes)
+	case len(span.Events) != 1:
+		t.Fatalf("expect the error recorded as an event, got %v", span.Events)
+	}
+
+	if err := provider.Shutdown(context.Background()); err != nil {
+		t.Fatalf("failed to shut down: %v", err)
+	}
+
+	unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer unavailable.Close()
+	exp, _ = newOTLPExporter(unavailable.URL)
+	if err := exp.ExportSpans(context.Background(), []*sdktrace.SpanSnapshot{{Name: SpanFilter}}); err == nil {
+		t.Fatalf("expect an error of the collector unavailable")
+	}
+}
+
+// unmarshalRequest decodes the ResourceSpans in field 1 of an
+// ExportTraceServiceRequest
+func unmarshalRequest(t *testing.T, body []byte) []*tracepb.ResourceSpans {
+	var ret []*tracepb.ResourceSpans
+	for len(body) > 0 {
+		num, typ, n := protowire.ConsumeTag(body)
+		if n < 0 || num != 1 || typ != protowire.BytesType {
+			t.Fatalf("unexpected field %d of type %d", num, typ)
+		}
+		data, m := protowire.ConsumeBytes(body[n:])
+		if m < 0 {
+			t.Fatalf("truncated field")
+		}
+		rs := &tracepb.ResourceSpans{}
+		if err := proto.Unmarshal(data, rs); err != nil {
+			t.Fatalf("failed to unmarshal: %v", err)
+		}
+		ret = append(ret, rs)
+		body = body[n+m:]
+	}
+	return ret
+}
@@ -0,0 +1,76 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package util
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ComputeCapability is the CUDA compute capability of a GPU device, e.g.
+// 8.0 of A100 or 7.5 of T4. The zero value means unknown.
+type ComputeCapability struct {
+	Major uint
+	Minor uint
+}
+
+// ParseComputeCapability parses a compute capability in the form of
+// major.minor, e.g. 8.6, or major alone as major.0
+func ParseComputeCapability(s string) (ComputeCapability, error) {
+	parts := strings.Split(strings.TrimSpace(s), ".")
+	if len(parts) > 2 {
+		return ComputeCapability{}, fmt.Errorf("invalid compute capability %q, expect major.minor", s)
+	}
+	var numbers [2]uint
+	for i, part := range parts {
+		n, err := strconv.ParseUint(part, 10, 0)
+		if err != nil {
+			return ComputeCapability{}, fmt.Errorf("invalid compute capability %q, expect major.minor", s)
+		}
+		numbers[i] = uint(n)
+	}
+	cc := ComputeCapability{Major: numbers[0], Minor: numbers[1]}
+	if cc.IsZero() {
+		return ComputeCapability{}, fmt.Errorf("invalid compute capability %q, expect major.minor", s)
+	}
+	return cc, nil
+}
+
+// IsZero tells if the compute capability is unknown
+func (cc ComputeCapability) IsZero() bool {
+	return cc == ComputeCapability{}
+}
+
+// AtLeast tells if the compute capability is min or later, an unknown one
+// never is
+func (cc ComputeCapability) AtLeast(min ComputeCapability) bool {
+	if cc.IsZero() {
+		return false
+	}
+	if cc.Major != min.Major {
+		return cc.Major > min.Major
+	}
+	return cc.Minor >= min.Minor
+}
+
+func (cc ComputeCapability) String() string {
+	if cc.IsZero() {
+		return ""
+	}
+	return fmt.Sprintf("%d.%d", cc.Major, cc.Minor)
+}
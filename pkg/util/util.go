@@ -18,6 +18,8 @@ package util
 
 import (
 	"fmt"
+	"math"
+	"sort"
 	"strconv"
 	"strings"
 	//"reflect"
@@ -27,43 +29,186 @@ import (
 	"k8s.io/api/core/v1"
 	apierr "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/klog"
+
+	"tkestack.io/gpu-admission/pkg/config"
+	"tkestack.io/gpu-admission/pkg/topology"
 )
 
 const (
-	VCoreAnnotation         = "tencent.com/vcuda-core"
-	VMemoryAnnotation       = "tencent.com/vcuda-memory"
-	PredicateTimeAnnotation = "tencent.com/predicate-time"
-	PredicateGPUIndexPrefix = "tencent.com/predicate-gpu-idx-"
-	PredicateNode           = "tencent.com/predicate-node"
-	GPUAssigned             = "tencent.com/gpu-assigned"
-	EstimatedTime			= "tencent.com/estimated-time-"
-	HundredCore             = 100
+	HundredCore = config.HundredCore
+
+	// GPUAllocatedCondition is the pod condition telling the node and the
+	// GPU devices the pod is allocated on by the bind verb
+	GPUAllocatedCondition v1.PodConditionType = "GPUAllocated"
+)
+
+// The names of annotations and resources, they are the defaults of
+// config.Keys and can be overridden at startup by SetKeys
+var (
+	VCoreAnnotation              string
+	VMemoryAnnotation            string
+	PredicateTimeAnnotation      string
+	PredicateGPUIndexPrefix      string
+	PredicateGPUInitIndexPrefix  string
+	PredicateGPUUUIDPrefix       string
+	PredicateGPUInitUUIDPrefix   string
+	PredicateMIGInstancePrefix   string
+	PredicateRelaxedConstraints  string
+	PredicateStageTimings        string
+	PredicateRoundedUp           string
+	PredicateNode                string
+	GPUAssigned                  string
+	EstimatedTime                string
+	EstimatedTimeAnnotation      string
+	UnhealthyGPUIndexes          string
+	DrainingGPUIndexes           string
+	GPUBlacklist                 string
+	GPUNUMANodes                 string
+	GPUMemories                  string
+	GPUCoreOvercommitRatio       string
+	GPUModels                    string
+	GPUUUIDs                     string
+	GPUMIGInstances              string
+	GPUUtilizations              string
+	GPUTemperatures              string
+	GPUPowers                    string
+	GPUComputeCapabilities       string
+	GPUECCErrors                 string
+	GPUVendor                    string
+	GPURDMANICs                  string
+	GPUTopology                  string
+	GPUMemoryBlocks              string
+	MIGResourcePrefix            string
+	GPUModelAnnotation           string
+	GPUColocateAnnotation        string
+	GPUAntiAffinityAnnotation    string
+	GPUWholeNodeAnnotation       string
+	GPUGangNameAnnotation        string
+	GPUGangSizeAnnotation        string
+	GPUPinDevicesAnnotation      string
+	GPUForbidDevicesAnnotation   string
+	GPUSoftConstraintsAnnotation string
+	GPUMinCCAnnotation           string
 )
 
+func init() {
+	SetKeys(config.DefaultKeys())
+}
+
+// SetKeys overrides the names of annotations and resources. It's not safe
+// for concurrent use, so it must be called at startup before any other
+// function of this package, and the keys should have been validated.
+func SetKeys(keys config.Keys) {
+	k := keys.Resolve()
+	VCoreAnnotation = k.VCore
+	VMemoryAnnotation = k.VMemory
+	PredicateTimeAnnotation = k.PredicateTime
+	PredicateGPUIndexPrefix = k.PredicateGPUIndexPrefix
+	PredicateGPUInitIndexPrefix = k.PredicateGPUInitIndexPrefix
+	PredicateGPUUUIDPrefix = k.PredicateGPUUUIDPrefix
+	PredicateGPUInitUUIDPrefix = k.PredicateGPUInitUUIDPrefix
+	PredicateMIGInstancePrefix = k.PredicateMIGInstancePrefix
+	PredicateRelaxedConstraints = k.PredicateRelaxedConstraints
+	PredicateStageTimings = k.PredicateStageTimings
+	PredicateRoundedUp = k.PredicateRoundedUp
+	PredicateNode = k.PredicateNode
+	GPUAssigned = k.GPUAssigned
+	EstimatedTime = k.EstimatedTimePrefix
+	EstimatedTimeAnnotation = k.EstimatedTime
+	UnhealthyGPUIndexes = k.UnhealthyGPUIndexes
+	DrainingGPUIndexes = k.DrainingGPUIndexes
+	GPUBlacklist = k.GPUBlacklist
+	GPUNUMANodes = k.GPUNUMANodes
+	GPUMemories = k.GPUMemories
+	GPUCoreOvercommitRatio = k.GPUCoreOvercommitRatio
+	GPUModels = k.GPUModels
+	GPUUUIDs = k.GPUUUIDs
+	GPUMIGInstances = k.GPUMIGInstances
+	GPUUtilizations = k.GPUUtilizations
+	GPUTemperatures = k.GPUTemperatures
+	GPUPowers = k.GPUPowers
+	GPUComputeCapabilities = k.GPUComputeCapabilities
+	GPUECCErrors = k.GPUECCErrors
+	GPUVendor = k.GPUVendor
+	GPURDMANICs = k.GPURDMANICs
+	GPUTopology = k.GPUTopology
+	GPUMemoryBlocks = k.GPUMemoryBlocks
+	MIGResourcePrefix = k.MIGResourcePrefix
+	GPUModelAnnotation = k.GPUModel
+	GPUColocateAnnotation = k.GPUColocate
+	GPUAntiAffinityAnnotation = k.GPUAntiAffinity
+	GPUWholeNodeAnnotation = k.GPUWholeNode
+	GPUGangNameAnnotation = k.GPUGangName
+	GPUGangSizeAnnotation = k.GPUGangSize
+	GPUPinDevicesAnnotation = k.GPUPinDevices
+	GPUForbidDevicesAnnotation = k.GPUForbidDevices
+	GPUSoftConstraintsAnnotation = k.GPUSoftConstraints
+	GPUMinCCAnnotation = k.GPUMinCC
+}
+
 // IsGPURequiredPod tell if the pod is a GPU request pod
 func IsGPURequiredPod(pod *v1.Pod) bool {
 	klog.V(4).Infof("Determine if the pod %s needs GPU resource", pod.Name)
 
-	vcore := GetGPUResourceOfPod(pod, VCoreAnnotation)
-	vmemory := GetGPUResourceOfPod(pod, VMemoryAnnotation)
-
-	// Check if pod request for GPU resource
-	if vcore <= 0 || (vcore < HundredCore && vmemory <= 0) {
-		klog.V(4).Infof("Pod %s in namespace %s does not Request for GPU resource",
-			pod.Name,
-			pod.Namespace)
-		return false
+	// a malformed request is treated as a GPU request, so the allocation
+	// reports it instead of ignoring it silently
+	for _, vendor := range Vendors() {
+		vcore, err := GetGPUResourceOfPod(pod, vendor.VCore)
+		if err != nil {
+			klog.Infof("pod %s in namespace %s has malformed GPU request: %v", pod.Name, pod.Namespace, err)
+			return true
+		}
+		vmemory, err := GetGPUResourceOfPod(pod, vendor.VMemory)
+		if err != nil {
+			klog.Infof("pod %s in namespace %s has malformed GPU request: %v", pod.Name, pod.Namespace, err)
+			return true
+		}
+		if vcore > 0 && (vcore >= HundredCore || vmemory > 0) {
+			return true
+		}
 	}
 
-	return true
+	for _, containers := range [][]v1.Container{pod.Spec.Containers, pod.Spec.InitContainers} {
+		for i := range containers {
+			if _, count := GetMIGRequestOfContainer(&containers[i]); count > 0 {
+				return true
+			}
+		}
+	}
+	for i := range pod.Spec.InitContainers {
+		if IsGPURequiredContainer(&pod.Spec.InitContainers[i]) {
+			return true
+		}
+	}
+	klog.V(4).Infof("Pod %s in namespace %s does not Request for GPU resource",
+		pod.Name,
+		pod.Namespace)
+	return false
 }
 
-// IsGPURequiredContainer tell if the container is a GPU request container
+// IsGPURequiredContainer tell if the container is a GPU request container.
+//
+// The GPU request is read from the extended resources in the limits of the
+// container, whose names are VCoreAnnotation and VMemoryAnnotation, or the
+// resources of another vendor, see SetKeys and SetVendors to use other
+// names.
 func IsGPURequiredContainer(c *v1.Container) bool {
 	klog.V(4).Infof("Determine if the container %s needs GPU resource", c.Name)
 
-	vcore := GetGPUResourceOfContainer(c, VCoreAnnotation)
-	vmemory := GetGPUResourceOfContainer(c, VMemoryAnnotation)
+	if _, count := GetMIGRequestOfContainer(c); count > 0 {
+		return true
+	}
+
+	// a malformed request is treated as a GPU request, see IsGPURequiredPod
+	vendor, _ := GetVendorOfContainer(c)
+	vcore, err := GetGPUResourceOfContainer(c, vendor.VCore)
+	if err != nil {
+		return true
+	}
+	vmemory, err := GetGPUResourceOfContainer(c, vendor.VMemory)
+	if err != nil {
+		return true
+	}
 
 	// Check if container request for GPU resource
 	if vcore <= 0 || (vcore < HundredCore && vmemory <= 0) {
@@ -75,29 +220,137 @@ func IsGPURequiredContainer(c *v1.Container) bool {
 }
 
 // GetGPUResourceOfPod returns the limit size of GPU resource of given pod
-func GetGPUResourceOfPod(pod *v1.Pod, resourceName v1.ResourceName) uint {
+func GetGPUResourceOfPod(pod *v1.Pod, resourceName string) (uint, error) {
 	var total uint
 	containers := pod.Spec.Containers
-	for _, container := range containers {
-		if val, ok := container.Resources.Limits[resourceName]; ok {
-			total += uint(val.Value())
+	for i := range containers {
+		count, err := GetGPUResourceOfContainer(&containers[i], resourceName)
+		if err != nil {
+			return 0, fmt.Errorf("pod %s: %v", pod.Name, err)
 		}
+		if total+count < total {
+			return 0, fmt.Errorf("pod %s: total %s limit overflows", pod.Name, resourceName)
+		}
+		total += count
 	}
-	return total
+	return total, nil
 }
 
-// GetGPUResourceOfPod returns the limit size of GPU resource of given container
-func GetGPUResourceOfContainer(container *v1.Container, resourceName v1.ResourceName) uint {
-	var count uint
-	if val, ok := container.Resources.Limits[resourceName]; ok {
-		count = uint(val.Value())
+// GetGPUResourceOfContainer returns the limit size of GPU resource of given
+// container, 0 if there is no limit. The limit must be a non-negative
+// integer, a fractional, negative or overflowing one is an error.
+func GetGPUResourceOfContainer(container *v1.Container, resourceName string) (uint, error) {
+	val, ok := container.Resources.Limits[v1.ResourceName(resourceName)]
+	if !ok {
+		return 0, nil
+	}
+	if val.Sign() < 0 {
+		return 0, fmt.Errorf("%s limit %s of container %s is negative",
+			resourceName, val.String(), container.Name)
+	}
+	count, ok := val.AsInt64()
+	if !ok || uint64(count) > uint64(math.MaxUint32) {
+		return 0, fmt.Errorf("%s limit %s of container %s is not an integer or overflows",
+			resourceName, val.String(), container.Name)
+	}
+	return uint(count), nil
+}
+
+// GetGPURequestOfContainer returns the vcore and vmemory given container of
+// pod requests by the extended resources of the vendor. It's the request
+// the allocation evaluates and charges, and the one the node is charged for
+// when rebuilt from the pod, so both always agree. A container of a whole
+// node pod requests whole GPUs whatever its limits are.
+func GetGPURequestOfContainer(pod *v1.Pod, container *v1.Container, vendor Vendor) (vcore, vmemory uint, err error) {
+	vcore, err = GetGPUResourceOfContainer(container, vendor.VCore)
+	if err != nil {
+		return 0, 0, err
+	}
+	vmemory, err = GetGPUResourceOfContainer(container, vendor.VMemory)
+	if err != nil {
+		return 0, 0, err
+	}
+	if IsWholeNodePod(pod) {
+		return HundredCore, 0, nil
 	}
-	return count
+	return vcore, vmemory, nil
+}
+
+// GetMIGRequestOfContainer returns the MIG profile and the number of
+// instances requested by given container, the request is a resource limit
+// like tencent.com/mig-1g.5gb: 1. A container requesting more than one
+// profile is invalid, see GetMIGProfilesOfContainer, the first of them in
+// name order is returned so the result doesn't change from call to call.
+func GetMIGRequestOfContainer(container *v1.Container) (string, uint) {
+	profiles := GetMIGProfilesOfContainer(container)
+	if len(profiles) == 0 {
+		return "", 0
+	}
+	val := container.Resources.Limits[v1.ResourceName(MIGResourcePrefix+profiles[0])]
+	return profiles[0], uint(val.Value())
+}
+
+// GetMIGProfilesOfContainer returns the MIG profiles given container
+// requests instances of, in name order
+func GetMIGProfilesOfContainer(container *v1.Container) []string {
+	var profiles []string
+	for name, val := range container.Resources.Limits {
+		if strings.HasPrefix(string(name), MIGResourcePrefix) && val.Value() > 0 {
+			profiles = append(profiles, strings.TrimPrefix(string(name), MIGResourcePrefix))
+		}
+	}
+	sort.Strings(profiles)
+	return profiles
+}
+
+// GetMIGInstancesOfNode returns the profiles of MIG instances of each GPU
+// device. The annotation lists <device idx>:<profile> pairs, and the order
+// of the pairs of a device gives the instance ids, e.g.
+// 0:1g.5gb,0:1g.5gb,0:2g.10gb,1:3g.20gb
+func GetMIGInstancesOfNode(node *v1.Node) map[int][]string {
+	ret := make(map[int][]string)
+	value, ok := node.Annotations[GPUMIGInstances]
+	if !ok || value == "" {
+		return ret
+	}
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), ":", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			klog.Infof("invalid MIG instance %q of node %s", pair, node.Name)
+			continue
+		}
+		index, err := strconv.Atoi(parts[0])
+		if err != nil {
+			klog.Infof("invalid MIG instance %q of node %s", pair, node.Name)
+			continue
+		}
+		ret[index] = append(ret[index], parts[1])
+	}
+	return ret
+}
+
+// GetPredicateMIGInstancesOfContainer returns the MIG instances given
+// container should use, each of them is a <device idx>:<instance id> pair
+func GetPredicateMIGInstancesOfContainer(pod *v1.Pod, containerIndex int) ([][2]int, error) {
+	var ret [][2]int
+	value, ok := pod.Annotations[PredicateMIGInstancePrefix+strconv.Itoa(containerIndex)]
+	if !ok {
+		return ret, fmt.Errorf("predicate MIG instance for container %d of pod %s not found",
+			containerIndex, pod.UID)
+	}
+	for _, pair := range strings.Split(value, ",") {
+		var index, instance int
+		if _, err := fmt.Sscanf(pair, "%d:%d", &index, &instance); err != nil {
+			return nil, fmt.Errorf("invalid predicate MIG instance %q of pod %s", pair, pod.UID)
+		}
+		ret = append(ret, [2]int{index, instance})
+	}
+	return ret, nil
 }
 
 // Is the Node has GPU device
 func IsGPUEnabledNode(node *v1.Node) bool {
-	return GetCapacityOfNode(node, VCoreAnnotation) > 0
+	return GetCapacityOfNode(node, GetVendorOfNode(node).VCore) > 0
 }
 
 // Get the capacity of request resource of the Node
@@ -111,23 +364,398 @@ func GetCapacityOfNode(node *v1.Node, resourceName string) int {
 	return int(val.Value())
 }
 
-// GetGPUDeviceCountOfNode returns the number of GPU devices
+// GetGPUDeviceCountOfNode returns the number of GPU devices, by the cores
+// resource of the vendor of the node
 func GetGPUDeviceCountOfNode(node *v1.Node) int {
-	val, ok := node.Status.Capacity[VCoreAnnotation]
+	val, ok := node.Status.Capacity[v1.ResourceName(GetVendorOfNode(node).VCore)]
 	if !ok {
 		return 0
 	}
 	return int(val.Value()) / HundredCore
 }
 
+// GetUnhealthyIdxOfNode returns the idx of GPU devices which are marked as
+// unhealthy (e.g. Xid error) by node annotation
+func GetUnhealthyIdxOfNode(node *v1.Node) []int {
+	return getIdxOfNode(node, UnhealthyGPUIndexes)
+}
+
+// GetDrainingIdxOfNode returns the idx of GPU devices which are marked as
+// draining (e.g. waiting for maintenance) by node annotation
+func GetDrainingIdxOfNode(node *v1.Node) []int {
+	return getIdxOfNode(node, DrainingGPUIndexes)
+}
+
+// GetBlacklistOfNode returns the GPU devices which are quarantined by node
+// annotation, the annotation lists device idx or UUIDs, e.g. 0,GPU-8a7b...
+func GetBlacklistOfNode(node *v1.Node) (indexes []int, uuids []string) {
+	value, ok := node.Annotations[GPUBlacklist]
+	if !ok || value == "" {
+		return nil, nil
+	}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if index, err := strconv.Atoi(item); err == nil {
+			indexes = append(indexes, index)
+			continue
+		}
+		uuids = append(uuids, item)
+	}
+	return indexes, uuids
+}
+
+func getIdxOfNode(node *v1.Node, annotation string) []int {
+	var ret []int
+	value, ok := node.Annotations[annotation]
+	if !ok || value == "" {
+		return ret
+	}
+	for _, indexStr := range strings.Split(value, ",") {
+		index, err := strconv.Atoi(strings.TrimSpace(indexStr))
+		if err != nil {
+			klog.Infof("invalid GPU index %q in %s of node %s", indexStr, annotation, node.Name)
+			continue
+		}
+		ret = append(ret, index)
+	}
+	return ret
+}
+
+// GetNUMANodesOfNode returns the NUMA node id of each GPU device, the
+// annotation lists the NUMA node ids in the order of device idx
+func GetNUMANodesOfNode(node *v1.Node) []int {
+	return getIntsOfNode(node, GPUNUMANodes, "NUMA node")
+}
+
+// GetRDMANICsOfNode returns the RDMA NIC sharing the PCIe locality of each
+// GPU device, the annotation lists the NIC ids in the order of device idx,
+// -1 for a device close to no NIC
+func GetRDMANICsOfNode(node *v1.Node) []int {
+	return getIntsOfNode(node, GPURDMANICs, "RDMA NIC")
+}
+
+// GetTopologyOfNode returns the interconnect topology of the GPU devices,
+// the annotation is either the output of `nvidia-smi topo -m` or its JSON
+// form, nil is returned if it's absent or invalid
+func GetTopologyOfNode(node *v1.Node) *topology.Topology {
+	value, ok := node.Annotations[GPUTopology]
+	if !ok || value == "" {
+		return nil
+	}
+	topo, err := topology.Parse([]byte(value))
+	if err != nil {
+		klog.Infof("invalid GPU topology of node %s: %v", node.Name, err)
+		return nil
+	}
+	return topo
+}
+
+// getIntsOfNode parses the comma separated list of given annotation of the
+// node, nil is returned if any of them is invalid
+func getIntsOfNode(node *v1.Node, annotation, what string) []int {
+	var ret []int
+	value, ok := node.Annotations[annotation]
+	if !ok || value == "" {
+		return ret
+	}
+	for _, str := range strings.Split(value, ",") {
+		v, err := strconv.Atoi(strings.TrimSpace(str))
+		if err != nil {
+			klog.Infof("invalid GPU %s %q of node %s", what, str, node.Name)
+			return nil
+		}
+		ret = append(ret, v)
+	}
+	return ret
+}
+
+// GetMemoriesOfNode returns the vmemory of each GPU device, the annotation
+// lists the vmemory in the order of device idx
+func GetMemoriesOfNode(node *v1.Node) []uint {
+	return getUintsOfNode(node, GPUMemories, "memory")
+}
+
+// GetUtilizationsOfNode returns the utilization in percent of each GPU
+// device, the annotation lists the utilizations in the order of device idx
+func GetUtilizationsOfNode(node *v1.Node) []uint {
+	return getUintsOfNode(node, GPUUtilizations, "utilization")
+}
+
+// GetTemperaturesOfNode returns the temperature in Celsius of each GPU
+// device, the annotation lists the temperatures in the order of device idx
+func GetTemperaturesOfNode(node *v1.Node) []uint {
+	return getUintsOfNode(node, GPUTemperatures, "temperature")
+}
+
+// GetPowersOfNode returns the power draw in watts of each GPU device, the
+// annotation lists the power draws in the order of device idx
+func GetPowersOfNode(node *v1.Node) []uint {
+	return getUintsOfNode(node, GPUPowers, "power")
+}
+
+// GetECCErrorsOfNode returns the ECC error count of each GPU device, the
+// annotation lists the counts in the order of device idx
+func GetECCErrorsOfNode(node *v1.Node) []uint {
+	return getUintsOfNode(node, GPUECCErrors, "ECC error count")
+}
+
+// GetMemoryBlocksOfNode returns the vmemory block size of each GPU device,
+// in which its memory is allocated, the annotation lists the sizes in the
+// order of device idx
+func GetMemoryBlocksOfNode(node *v1.Node) []uint {
+	return getUintsOfNode(node, GPUMemoryBlocks, "memory block size")
+}
+
+// getUintsOfNode parses the comma separated list of given annotation of
+// the node, nil is returned if any of them is invalid
+func getUintsOfNode(node *v1.Node, annotation, what string) []uint {
+	var ret []uint
+	value, ok := node.Annotations[annotation]
+	if !ok || value == "" {
+		return ret
+	}
+	for _, str := range strings.Split(value, ",") {
+		v, err := strconv.ParseUint(strings.TrimSpace(str), 10, 0)
+		if err != nil {
+			klog.Infof("invalid GPU %s %q of node %s", what, str, node.Name)
+			return nil
+		}
+		ret = append(ret, uint(v))
+	}
+	return ret
+}
+
+// GetComputeCapabilitiesOfNode returns the compute capability of each GPU
+// device, the annotation lists them in the order of device idx, e.g.
+// 7.5,8.0. nil is returned if any of them is invalid.
+func GetComputeCapabilitiesOfNode(node *v1.Node) []ComputeCapability {
+	var ret []ComputeCapability
+	value, ok := node.Annotations[GPUComputeCapabilities]
+	if !ok || value == "" {
+		return ret
+	}
+	for _, str := range strings.Split(value, ",") {
+		cc, err := ParseComputeCapability(str)
+		if err != nil {
+			klog.Infof("invalid GPU compute capability %q of node %s", str, node.Name)
+			return nil
+		}
+		ret = append(ret, cc)
+	}
+	return ret
+}
+
+// GetCoreOvercommitRatioOfNode returns the core overcommit ratio of GPU
+// devices overridden by node annotation, ok is false if there is no valid
+// one
+func GetCoreOvercommitRatioOfNode(node *v1.Node) (ratio float64, ok bool) {
+	value, ok := node.Annotations[GPUCoreOvercommitRatio]
+	if !ok || value == "" {
+		return 0, false
+	}
+	ratio, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		klog.Infof("invalid GPU core overcommit ratio %q of node %s", value, node.Name)
+		return 0, false
+	}
+	return ratio, true
+}
+
+// GetModelsOfNode returns the model of each GPU device, the annotation
+// lists the models in the order of device idx
+func GetModelsOfNode(node *v1.Node) []string {
+	var ret []string
+	value, ok := node.Annotations[GPUModels]
+	if !ok || value == "" {
+		return ret
+	}
+	for _, model := range strings.Split(value, ",") {
+		ret = append(ret, strings.TrimSpace(model))
+	}
+	return ret
+}
+
+// GetUUIDsOfNode returns the UUID of each GPU device, the annotation
+// lists the UUIDs in the order of device idx
+func GetUUIDsOfNode(node *v1.Node) []string {
+	var ret []string
+	value, ok := node.Annotations[GPUUUIDs]
+	if !ok || value == "" {
+		return ret
+	}
+	for _, uuid := range strings.Split(value, ",") {
+		ret = append(ret, strings.TrimSpace(uuid))
+	}
+	return ret
+}
+
+// IsPredicateAnnotation tells if the annotation key is written by predication
+func IsPredicateAnnotation(key string) bool {
+	for _, prefix := range []string{GPUAssigned, PredicateTimeAnnotation, PredicateNode,
+		PredicateGPUIndexPrefix, PredicateGPUInitIndexPrefix,
+		PredicateGPUUUIDPrefix, PredicateGPUInitUUIDPrefix, PredicateMIGInstancePrefix,
+		PredicateRelaxedConstraints, PredicateStageTimings, PredicateRoundedUp} {
+		if strings.Contains(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsRoundedUpPod tells if the containers of the pod requesting less than a
+// GPU were allocated whole GPUs, see the share policy config.ShareRoundedUp
+func IsRoundedUpPod(pod *v1.Pod) bool {
+	return pod.Annotations[PredicateRoundedUp] == "true"
+}
+
+// IsRequestAnnotation tells if the annotation key is part of the GPU request
+// of the pod, i.e. read at scheduling, unlike those written by predication
+func IsRequestAnnotation(key string) bool {
+	switch key {
+	case EstimatedTimeAnnotation, GPUModelAnnotation, GPUColocateAnnotation, GPUAntiAffinityAnnotation,
+		GPUWholeNodeAnnotation, GPUGangNameAnnotation, GPUGangSizeAnnotation, GPUPinDevicesAnnotation,
+		GPUForbidDevicesAnnotation, GPUSoftConstraintsAnnotation, GPUMinCCAnnotation:
+		return true
+	}
+	return strings.HasPrefix(key, EstimatedTime)
+}
+
+// GetGPUModelOfPod returns the GPU model requested by given pod, empty
+// string means any model
+func GetGPUModelOfPod(pod *v1.Pod) string {
+	return strings.TrimSpace(pod.Annotations[GPUModelAnnotation])
+}
+
+// GetOwnerOfPod returns the workload the pod belongs to: the value of
+// given label if the pod has it, otherwise the UID of its controller, e.g.
+// the ReplicaSet or Job, otherwise the UID of the pod itself
+func GetOwnerOfPod(pod *v1.Pod, label string) string {
+	if label != "" {
+		if value, ok := pod.Labels[label]; ok {
+			return label + "=" + value
+		}
+	}
+	for _, ref := range pod.OwnerReferences {
+		if ref.Controller != nil && *ref.Controller {
+			return string(ref.UID)
+		}
+	}
+	return string(pod.UID)
+}
+
+// IsColocatedPod tells if the shared containers of given pod prefer to
+// run on the same GPU device
+func IsColocatedPod(pod *v1.Pod) bool {
+	colocate, _ := strconv.ParseBool(pod.Annotations[GPUColocateAnnotation])
+	return colocate
+}
+
+// IsAntiAffinityPod tells if each GPU container of given pod must run on
+// its own GPU devices
+func IsAntiAffinityPod(pod *v1.Pod) bool {
+	antiAffinity, _ := strconv.ParseBool(pod.Annotations[GPUAntiAffinityAnnotation])
+	return antiAffinity
+}
+
+// IsWholeNodePod tells if the GPU container of given pod takes all of the
+// GPU devices of a node
+func IsWholeNodePod(pod *v1.Pod) bool {
+	wholeNode, _ := strconv.ParseBool(pod.Annotations[GPUWholeNodeAnnotation])
+	return wholeNode
+}
+
+// GetGangOfPod returns the gang of given pod and the number of the members
+// of the gang, the name is empty if the pod is not in a gang
+func GetGangOfPod(pod *v1.Pod) (name string, size int, err error) {
+	name = strings.TrimSpace(pod.Annotations[GPUGangNameAnnotation])
+	if name == "" {
+		return "", 0, nil
+	}
+	value := pod.Annotations[GPUGangSizeAnnotation]
+	size, err = strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || size <= 0 {
+		return "", 0, fmt.Errorf("invalid size %q of gang %s, expect a positive integer", value, name)
+	}
+	return name, size, nil
+}
+
+// GetMinComputeCapabilityOfPod returns the minimum compute capability of
+// the GPU devices given pod requests, ok is false if it requests none
+func GetMinComputeCapabilityOfPod(pod *v1.Pod) (cc ComputeCapability, ok bool, err error) {
+	value, ok := pod.Annotations[GPUMinCCAnnotation]
+	if !ok || strings.TrimSpace(value) == "" {
+		return ComputeCapability{}, false, nil
+	}
+	cc, err = ParseComputeCapability(value)
+	if err != nil {
+		return ComputeCapability{}, false, err
+	}
+	return cc, true, nil
+}
+
+// GetPinnedDevicesOfPod returns the idx of the GPU devices given pod must
+// run on, nil if the pod is not pinned
+func GetPinnedDevicesOfPod(pod *v1.Pod) ([]int, error) {
+	return getDevicesOfPod(pod, GPUPinDevicesAnnotation)
+}
+
+// GetForbiddenDevicesOfPod returns the idx of the GPU devices given pod must
+// not run on
+func GetForbiddenDevicesOfPod(pod *v1.Pod) ([]int, error) {
+	return getDevicesOfPod(pod, GPUForbidDevicesAnnotation)
+}
+
+// GetSoftConstraintsOfPod returns the names of the constraints of given pod
+// which may be dropped when the pod doesn't fit otherwise, in lower case
+func GetSoftConstraintsOfPod(pod *v1.Pod) []string {
+	var names []string
+	for _, s := range strings.Split(pod.Annotations[GPUSoftConstraintsAnnotation], ",") {
+		if name := strings.ToLower(strings.TrimSpace(s)); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// getDevicesOfPod parses a comma separated list of GPU idx in the
+// annotation of given pod, e.g. 0,3
+func getDevicesOfPod(pod *v1.Pod, annotation string) ([]int, error) {
+	value, ok := pod.Annotations[annotation]
+	if !ok || strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	var ids []int
+	for _, s := range strings.Split(value, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || id < 0 {
+			return nil, fmt.Errorf("invalid GPU idx %q in annotation %s", s, annotation)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
 // GetPredicateIdxOfContainer returns the idx number of given container should be run on which
 // GPU device
 func GetPredicateIdxOfContainer(pod *v1.Pod, containerIndex int) ([]int, error) {
+	return getPredicateIdx(pod, PredicateGPUIndexPrefix, containerIndex)
+}
+
+// GetPredicateIdxOfInitContainer returns the idx number of given init container should be
+// run on which GPU device
+func GetPredicateIdxOfInitContainer(pod *v1.Pod, containerIndex int) ([]int, error) {
+	return getPredicateIdx(pod, PredicateGPUInitIndexPrefix, containerIndex)
+}
+
+func getPredicateIdx(pod *v1.Pod, prefix string, containerIndex int) ([]int, error) {
 	var ret []int
-	predicateIndexes, ok := pod.Annotations[PredicateGPUIndexPrefix+strconv.Itoa(containerIndex)]
+	predicateIndexes, ok := pod.Annotations[prefix+strconv.Itoa(containerIndex)]
 	if !ok {
-		return ret, fmt.Errorf("predicate index for container %d of pod %s not found",
-			containerIndex, pod.UID)
+		return ret, fmt.Errorf("predicate index %s%d of pod %s not found",
+			prefix, containerIndex, pod.UID)
 	}
 	for _, indexStr := range strings.Split(predicateIndexes, ",") {
 		index, err := strconv.Atoi(indexStr)
@@ -139,31 +767,89 @@ func GetPredicateIdxOfContainer(pod *v1.Pod, containerIndex int) ([]int, error)
 	return ret, nil
 }
 
+// EstimatedTimeUnit is the unit of estimated time and running time of
+// containers, an estimated time annotation in a bare integer is in this unit
+const EstimatedTimeUnit = time.Second
+
+// defaultEstimatedTime is the estimated time in EstimatedTimeUnit of the
+// containers without the annotations, nil if one is required
+var defaultEstimatedTime *uint
+
+// SetDefaultEstimatedTime sets the estimated time of the containers whose
+// pod has neither estimated time annotation, see
+// config.Config.DefaultEstimatedTime. Nil requires one of them. Like
+// SetKeys, it must be called at startup.
+func SetDefaultEstimatedTime(d *time.Duration) {
+	if d == nil {
+		defaultEstimatedTime = nil
+		return
+	}
+	t := uint(*d / EstimatedTimeUnit)
+	defaultEstimatedTime = &t
+}
+
 //获得容器c的预测执行时间
+//
+// GetEstimatedTimeOfContainer returns the estimated time of given container
+// in EstimatedTimeUnit. It's the annotation EstimatedTime followed by the
+// index of the container, e.g. tencent.com/estimated-time-1 for the second
+// container, or EstimatedTimeAnnotation shared by all of the containers of
+// the pod without their own, or the default of SetDefaultEstimatedTime if
+// the pod has neither. The annotation is either a bare integer in
+// EstimatedTimeUnit, e.g. 1800, or a duration, e.g. 30m or 1h30m, which is
+// truncated to EstimatedTimeUnit.
 func GetEstimatedTimeOfContainer(pod *v1.Pod, containerIndex int) (uint, error) {
-	var ret uint
-	estimatedTime, ok := pod.Annotations[EstimatedTime+strconv.Itoa(containerIndex)]
+	key := EstimatedTime + strconv.Itoa(containerIndex)
+	estimatedTime, ok := pod.Annotations[key]
+	if !ok {
+		key = EstimatedTimeAnnotation
+		estimatedTime, ok = pod.Annotations[key]
+	}
 	if !ok {
-		return ret, fmt.Errorf("estimated time for container %d of pod %s not found",
+		if defaultEstimatedTime != nil {
+			return *defaultEstimatedTime, nil
+		}
+		return 0, fmt.Errorf("estimated time for container %d of pod %s not found",
 			containerIndex, pod.UID)
 	}
-	ans, err := strconv.Atoi(estimatedTime)
+	return parseEstimatedTime(pod, key, estimatedTime)
+}
+
+// parseEstimatedTime parses the estimated time annotation of given key of
+// pod, see GetEstimatedTimeOfContainer
+func parseEstimatedTime(pod *v1.Pod, key, estimatedTime string) (uint, error) {
+	value := strings.TrimSpace(estimatedTime)
+	if ans, err := strconv.ParseUint(value, 10, 32); err == nil {
+		return uint(ans), nil
+	}
+	d, err := time.ParseDuration(value)
 	if err != nil {
-		return ret, err
+		return 0, fmt.Errorf("invalid %s annotation %q of pod %s, expect an integer or a duration",
+			key, estimatedTime, pod.Name)
 	}
-	ret = uint(ans)
-	return ret, nil
+	if d < 0 || d/EstimatedTimeUnit > math.MaxUint32 {
+		return 0, fmt.Errorf("invalid %s annotation %q of pod %s, the duration is negative or overflows",
+			key, estimatedTime, pod.Name)
+	}
+	return uint(d / EstimatedTimeUnit), nil
 }
 
 //获得容器已经执行的时间
+//
+// GetRunningTimeOfContainer returns the running time of given container in
+// EstimatedTimeUnit
 func GetRunningTimeOfContainer(pod *v1.Pod, containerIndex int) (uint, error) {
 	var ret uint
+	if containerIndex >= len(pod.Status.ContainerStatuses) ||
+		pod.Status.ContainerStatuses[containerIndex].State.Running == nil {
+		return ret, fmt.Errorf("container %d of pod %s is not running", containerIndex, pod.UID)
+	}
 	startTime := pod.Status.ContainerStatuses[containerIndex].State.Running.StartedAt.Time
 	if startTime.IsZero() {
 		return ret, errors.New("time: Invalid time")
 	}
 	currentTime := time.Now()
-	runningTime := currentTime.Sub(startTime).Seconds()
+	runningTime := currentTime.Sub(startTime) / EstimatedTimeUnit
 	if runningTime < 0 {
 		return ret, errors.New("time: time less than 0 is illegal")
 	}
@@ -171,6 +857,23 @@ func GetRunningTimeOfContainer(pod *v1.Pod, containerIndex int) (uint, error) {
 	return ret, nil
 }
 
+// GetStartTimeOfContainer returns when given container started running, or
+// the predicate time of its pod if it's not running yet, false if neither
+// is known
+func GetStartTimeOfContainer(pod *v1.Pod, containerIndex int) (time.Time, bool) {
+	if containerIndex < len(pod.Status.ContainerStatuses) {
+		if running := pod.Status.ContainerStatuses[containerIndex].State.Running; running != nil &&
+			!running.StartedAt.IsZero() {
+			return running.StartedAt.Time, true
+		}
+	}
+	nanos, err := strconv.ParseInt(pod.Annotations[PredicateTimeAnnotation], 10, 64)
+	if err != nil || nanos <= 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, nanos), true
+}
+
 func ShouldRetry(err error) bool {
 	return apierr.IsConflict(err) || apierr.IsServerTimeout(err)
 }
@@ -0,0 +1,365 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package util
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"tkestack.io/gpu-admission/pkg/config"
+)
+
+func TestGetBlacklistOfNode(t *testing.T) {
+	testCases := []struct {
+		value   string
+		indexes []int
+		uuids   []string
+	}{
+		{value: ""},
+		{value: "1", indexes: []int{1}},
+		{value: "0, GPU-a,3,,GPU-b", indexes: []int{0, 3}, uuids: []string{"GPU-a", "GPU-b"}},
+	}
+
+	for _, cs := range testCases {
+		node := &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "testnode",
+				Annotations: map[string]string{GPUBlacklist: cs.value},
+			},
+		}
+		indexes, uuids := GetBlacklistOfNode(node)
+		if !reflect.DeepEqual(indexes, cs.indexes) || !reflect.DeepEqual(uuids, cs.uuids) {
+			t.Fatalf("%q: got %v %v, expect %v %v", cs.value, indexes, uuids, cs.indexes, cs.uuids)
+		}
+	}
+}
+
+func TestSetKeys(t *testing.T) {
+	defer SetKeys(config.DefaultKeys())
+
+	keys := config.DefaultKeys()
+	keys.Domain = "example.com"
+	SetKeys(keys)
+	if VCoreAnnotation != "example.com/vcuda-core" {
+		t.Fatalf("got %s, expect example.com/vcuda-core", VCoreAnnotation)
+	}
+	if !IsPredicateAnnotation("example.com/predicate-gpu-idx-0") {
+		t.Fatalf("predicate annotation of the new domain is not recognized")
+	}
+	if IsPredicateAnnotation("tencent.com/predicate-gpu-idx-0") {
+		t.Fatalf("predicate annotation of the old domain should not be recognized")
+	}
+}
+
+func TestGetGPUResourceOfContainer(t *testing.T) {
+	testCases := []struct {
+		value  string
+		expect uint
+		valid  bool
+	}{
+		{value: "", expect: 0, valid: true},
+		{value: "30", expect: 30, valid: true},
+		{value: "-10"},
+		{value: "500m"},
+		{value: "1e30"},
+		{value: "5G"},
+	}
+
+	for _, cs := range testCases {
+		c := &v1.Container{Name: "container"}
+		if cs.value != "" {
+			c.Resources.Limits = v1.ResourceList{v1.ResourceName(VCoreAnnotation): resource.MustParse(cs.value)}
+		}
+		got, err := GetGPUResourceOfContainer(c, VCoreAnnotation)
+		if (err == nil) != cs.valid || got != cs.expect {
+			t.Fatalf("%q: got %d, %v, expect %d, valid %v", cs.value, got, err, cs.expect, cs.valid)
+		}
+		if err != nil && !strings.Contains(err.Error(), VCoreAnnotation) {
+			t.Fatalf("%q: error should name the resource, got %v", cs.value, err)
+		}
+	}
+}
+
+func TestGetEstimatedTimeOfContainer(t *testing.T) {
+	testCases := []struct {
+		value  string
+		expect uint
+		valid  bool
+	}{
+		{value: "30", expect: 30, valid: true},
+		{value: " 30 ", expect: 30, valid: true},
+		{value: "30m", expect: 1800, valid: true},
+		{value: "2h", expect: 7200, valid: true},
+		{value: "1h30m", expect: 5400, valid: true},
+		{value: "1500ms", expect: 1, valid: true},
+		{value: "abc"},
+		{value: "-1"},
+		{value: "-5m"},
+		{value: "30 minutes"},
+		{value: "99999999999999999999"},
+		{value: "2000000h"},
+	}
+
+	for _, cs := range testCases {
+		pod := &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "pod",
+				Annotations: map[string]string{EstimatedTime + "0": cs.value},
+			},
+		}
+		got, err := GetEstimatedTimeOfContainer(pod, 0)
+		if (err == nil) != cs.valid || got != cs.expect {
+			t.Fatalf("%q: got %d, %v, expect %d, valid %v", cs.value, got, err, cs.expect, cs.valid)
+		}
+		if err != nil && !strings.Contains(err.Error(), EstimatedTime+"0") {
+			t.Fatalf("%q: error should name the annotation, got %v", cs.value, err)
+		}
+	}
+}
+
+func TestGetEstimatedTimeOfContainerPrecedence(t *testing.T) {
+	defer SetDefaultEstimatedTime(nil)
+
+	oneHour := time.Hour
+	testCases := []struct {
+		name        string
+		annotations map[string]string
+		defaultTime *time.Duration
+		expect      [2]uint
+		valid       bool
+	}{
+		{name: "indexed", annotations: map[string]string{EstimatedTime + "0": "30m", EstimatedTime + "1": "2h"},
+			expect: [2]uint{1800, 7200}, valid: true},
+		{name: "indexed over shared", annotations: map[string]string{EstimatedTime + "1": "2h",
+			EstimatedTimeAnnotation: "10m"}, expect: [2]uint{600, 7200}, valid: true},
+		{name: "shared", annotations: map[string]string{EstimatedTimeAnnotation: "10m"},
+			expect: [2]uint{600, 600}, valid: true},
+		{name: "shared over default", annotations: map[string]string{EstimatedTimeAnnotation: "10m"},
+			defaultTime: &oneHour, expect: [2]uint{600, 600}, valid: true},
+		{name: "default", defaultTime: &oneHour, expect: [2]uint{3600, 3600}, valid: true},
+		{name: "indexed over default", annotations: map[string]string{EstimatedTime + "0": "60"},
+			defaultTime: &oneHour, expect: [2]uint{60, 3600}, valid: true},
+		{name: "required"},
+	}
+
+	for _, cs := range testCases {
+		SetDefaultEstimatedTime(cs.defaultTime)
+		pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod", Annotations: cs.annotations}}
+		for i := range cs.expect {
+			got, err := GetEstimatedTimeOfContainer(pod, i)
+			if (err == nil) != cs.valid || got != cs.expect[i] {
+				t.Fatalf("%s: container %d got %d, %v, expect %d, valid %v", cs.name, i, got, err,
+					cs.expect[i], cs.valid)
+			}
+		}
+	}
+
+	// the error names the shared annotation it's read from
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod",
+		Annotations: map[string]string{EstimatedTimeAnnotation: "soon"}}}
+	if _, err := GetEstimatedTimeOfContainer(pod, 0); err == nil || !strings.Contains(err.Error(), EstimatedTimeAnnotation) {
+		t.Fatalf("error should name the shared annotation, got %v", err)
+	}
+}
+
+func TestIsGPURequiredContainer(t *testing.T) {
+	defer SetKeys(config.DefaultKeys())
+
+	newContainer := func(cores, memory string) *v1.Container {
+		return &v1.Container{
+			Name: "container",
+			Resources: v1.ResourceRequirements{
+				Limits: v1.ResourceList{
+					v1.ResourceName(VCoreAnnotation):   resource.MustParse(cores),
+					v1.ResourceName(VMemoryAnnotation): resource.MustParse(memory),
+				},
+			},
+		}
+	}
+	testCases := []struct {
+		cores, memory string
+		expect        bool
+	}{
+		{cores: "10", memory: "1", expect: true},
+		{cores: "100", memory: "0", expect: true},
+		{cores: "10", memory: "0", expect: false},
+		{cores: "0", memory: "1", expect: false},
+	}
+	for _, cs := range testCases {
+		if got := IsGPURequiredContainer(newContainer(cs.cores, cs.memory)); got != cs.expect {
+			t.Fatalf("%s cores %s memory: got %v, expect %v", cs.cores, cs.memory, got, cs.expect)
+		}
+	}
+
+	// the extended resources of other names
+	c := newContainer("10", "1")
+	keys := config.DefaultKeys()
+	keys.VCore = "gpu.example.com/core"
+	keys.VMemory = "gpu.example.com/memory"
+	SetKeys(keys)
+	if IsGPURequiredContainer(c) {
+		t.Fatalf("resources of the default names should be ignored")
+	}
+	if !IsGPURequiredContainer(newContainer("10", "1")) {
+		t.Fatalf("resources of the configured names are not recognized")
+	}
+}
+
+func TestIsGPURequiredPodInitContainers(t *testing.T) {
+	gpu := v1.Container{
+		Name: "init",
+		Resources: v1.ResourceRequirements{Limits: v1.ResourceList{
+			v1.ResourceName(VCoreAnnotation):   resource.MustParse("50"),
+			v1.ResourceName(VMemoryAnnotation): resource.MustParse("1"),
+		}},
+	}
+	pod := &v1.Pod{Spec: v1.PodSpec{
+		InitContainers: []v1.Container{gpu},
+		Containers:     []v1.Container{{Name: "main"}},
+	}}
+	if !IsGPURequiredPod(pod) {
+		t.Fatalf("a pod whose init container requests GPU should require GPU")
+	}
+	pod.Spec.InitContainers = []v1.Container{{Name: "init"}}
+	if IsGPURequiredPod(pod) {
+		t.Fatalf("a pod without GPU request should not require GPU")
+	}
+}
+
+func TestGetOwnerOfPod(t *testing.T) {
+	controller := true
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "pod",
+			UID:  "uid-pod",
+			OwnerReferences: []metav1.OwnerReference{
+				{Kind: "Pod", Name: "parent", UID: "uid-parent"},
+				{Kind: "Job", Name: "job", UID: "uid-job", Controller: &controller},
+			},
+		},
+	}
+	if got := GetOwnerOfPod(pod, ""); got != "uid-job" {
+		t.Fatalf("expect the controller uid-job, got %s", got)
+	}
+	pod.Labels = map[string]string{"app": "bert"}
+	if got := GetOwnerOfPod(pod, "app"); got != "app=bert" {
+		t.Fatalf("expect the label app=bert, got %s", got)
+	}
+	pod.OwnerReferences = nil
+	if got := GetOwnerOfPod(pod, "team"); got != "uid-pod" {
+		t.Fatalf("expect the pod itself, got %s", got)
+	}
+}
+
+func TestParseComputeCapability(t *testing.T) {
+	testCases := []struct {
+		value  string
+		expect ComputeCapability
+		valid  bool
+	}{
+		{value: "8.0", expect: ComputeCapability{Major: 8}, valid: true},
+		{value: " 8.6 ", expect: ComputeCapability{Major: 8, Minor: 6}, valid: true},
+		{value: "9", expect: ComputeCapability{Major: 9}, valid: true},
+		{value: "7.10", expect: ComputeCapability{Major: 7, Minor: 10}, valid: true},
+		{value: ""},
+		{value: "0.0"},
+		{value: "8."},
+		{value: "8.x"},
+		{value: "-8.0"},
+		{value: "8.0.1"},
+		{value: "sm_80"},
+	}
+
+	for _, cs := range testCases {
+		got, err := ParseComputeCapability(cs.value)
+		if (err == nil) != cs.valid || got != cs.expect {
+			t.Fatalf("%q: got %v, %v, expect %v, valid %v", cs.value, got, err, cs.expect, cs.valid)
+		}
+	}
+}
+
+func TestComputeCapabilityAtLeast(t *testing.T) {
+	min := ComputeCapability{Major: 8}
+	testCases := []struct {
+		cc     ComputeCapability
+		expect bool
+	}{
+		{cc: ComputeCapability{Major: 8}, expect: true},
+		{cc: ComputeCapability{Major: 8, Minor: 6}, expect: true},
+		{cc: ComputeCapability{Major: 9}, expect: true},
+		{cc: ComputeCapability{Major: 7, Minor: 10}},
+		{cc: ComputeCapability{}},
+	}
+
+	for _, cs := range testCases {
+		if got := cs.cc.AtLeast(min); got != cs.expect {
+			t.Fatalf("%v at least %v: expect %v, got %v", cs.cc, min, cs.expect, got)
+		}
+	}
+}
+
+func TestVendors(t *testing.T) {
+	defer SetVendors(nil)
+	cfg := config.Default()
+	cfg.Vendors = map[string]config.VendorKeys{"amd": {VCore: "amd.com/vcore", VMemory: "amd.com/vmemory"}}
+	SetVendors(cfg.ResolvedVendors())
+
+	amd := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "amd", Annotations: map[string]string{GPUVendor: "AMD"}},
+		Status: v1.NodeStatus{Capacity: v1.ResourceList{
+			"amd.com/vcore":   resource.MustParse("200"),
+			"amd.com/vmemory": resource.MustParse("32"),
+		}},
+	}
+	if vendor := GetVendorOfNode(amd); vendor.Name != "amd" || vendor.VCore != "amd.com/vcore" {
+		t.Fatalf("unexpected vendor %+v", vendor)
+	}
+	if !IsGPUEnabledNode(amd) || GetGPUDeviceCountOfNode(amd) != 2 {
+		t.Fatalf("expect 2 GPUs of node amd")
+	}
+	amd.Annotations[GPUVendor] = "intel"
+	if IsGPUEnabledNode(amd) {
+		t.Fatalf("a node of an unknown vendor should have no GPU")
+	}
+
+	c := &v1.Container{
+		Name: "c0",
+		Resources: v1.ResourceRequirements{Limits: v1.ResourceList{
+			"amd.com/vcore":   resource.MustParse("50"),
+			"amd.com/vmemory": resource.MustParse("4"),
+		}},
+	}
+	if vendor, ok := GetVendorOfContainer(c); !ok || vendor.Name != "amd" {
+		t.Fatalf("unexpected vendor %+v of container", vendor)
+	}
+	if !IsGPURequiredContainer(c) {
+		t.Fatalf("container of amd GPU should require GPU")
+	}
+	pod := &v1.Pod{Spec: v1.PodSpec{Containers: []v1.Container{*c}}}
+	if !IsGPURequiredPod(pod) {
+		t.Fatalf("pod of amd GPU should require GPU")
+	}
+	if vendor, ok := GetVendorOfContainer(&v1.Container{}); ok || vendor != DefaultVendor() {
+		t.Fatalf("a container without GPU request should be of the default vendor, got %+v", vendor)
+	}
+}
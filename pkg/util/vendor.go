@@ -0,0 +1,102 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package util
+
+import (
+	"sort"
+	"strings"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/klog"
+
+	"tkestack.io/gpu-admission/pkg/config"
+)
+
+// Vendor is the names of the resources of the GPU devices of a vendor in
+// node capacity and container limits
+type Vendor struct {
+	Name    string
+	VCore   string
+	VMemory string
+}
+
+// vendors are the vendors other than config.DefaultVendor, set by
+// SetVendors
+var vendors map[string]Vendor
+
+// SetVendors sets the resources of the vendors, which are resolved by
+// config.Config.ResolvedVendors. The resources of config.DefaultVendor
+// always follow SetKeys. Like SetKeys, it must be called at startup.
+func SetVendors(resolved map[string]config.VendorKeys) {
+	vendors = make(map[string]Vendor, len(resolved))
+	for name, vk := range resolved {
+		if name != config.DefaultVendor {
+			vendors[name] = Vendor{Name: name, VCore: vk.VCore, VMemory: vk.VMemory}
+		}
+	}
+}
+
+// DefaultVendor returns the vendor of the nodes without the GPUVendor
+// annotation
+func DefaultVendor() Vendor {
+	return Vendor{Name: config.DefaultVendor, VCore: VCoreAnnotation, VMemory: VMemoryAnnotation}
+}
+
+// Vendors returns all of the vendors, the default one first and the others
+// by name
+func Vendors() []Vendor {
+	ret := []Vendor{DefaultVendor()}
+	names := make([]string, 0, len(vendors))
+	for name := range vendors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		ret = append(ret, vendors[name])
+	}
+	return ret
+}
+
+// GetVendorOfNode returns the vendor of the GPU devices of given node by
+// the GPUVendor annotation. An unknown vendor has no resources, so the
+// node has no GPU device.
+func GetVendorOfNode(node *v1.Node) Vendor {
+	name := strings.ToLower(strings.TrimSpace(node.Annotations[GPUVendor]))
+	if name == "" || name == config.DefaultVendor {
+		return DefaultVendor()
+	}
+	vendor, ok := vendors[name]
+	if !ok {
+		klog.V(4).Infof("unknown GPU vendor %s of node %s", name, node.Name)
+		return Vendor{Name: name}
+	}
+	return vendor
+}
+
+// GetVendorOfContainer returns the vendor whose resources are in the limits
+// of given container, ok is false and the default vendor is returned if
+// there is none
+func GetVendorOfContainer(c *v1.Container) (vendor Vendor, ok bool) {
+	for _, vendor := range Vendors() {
+		for _, name := range []string{vendor.VCore, vendor.VMemory} {
+			if _, found := c.Resources.Limits[v1.ResourceName(name)]; found {
+				return vendor, true
+			}
+		}
+	}
+	return DefaultVendor(), false
+}